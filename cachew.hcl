@@ -12,6 +12,24 @@ log {
   level = "debug"
 }
 
+# Limit each client to 10GiB per hour.
+# quota {
+#   bytes = 10737418240
+#   window = "1h"
+# }
+
+# Spool resumable uploads next to the cache rather than in the system temporary directory. Uploads in progress are
+# held by the instance that received them, so must not be balanced across instances.
+# uploads {
+#   dir = "./state/uploads"
+#   max-size-mb = 20480
+# }
+
+# Reject crawlers that ignore /robots.txt.
+# crawlers {
+#   block-user-agents = ["Googlebot", "bingbot", "GPTBot"]
+# }
+
 git-clone {
     mirror-root = "./state/git-mirrors"
 }
@@ -19,6 +37,9 @@ git-clone {
 git {
   bundle-interval = "24h"
   snapshot-interval = "24h"
+  # repo "https://github.com/myorg/*" {
+  #   fetch-interval = "1m"
+  # }
 }
 
 host "https://w3.org" {}
@@ -36,6 +57,9 @@ disk {
 
 gomod {
   proxy = "https://proxy.golang.org"
+  # Any strategy can limit the cost of its requests.
+  # max-request-duration = "1m"
+  # max-response-bytes = 1073741824
 }
 
 hermit { }
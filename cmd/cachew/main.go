@@ -1,19 +1,26 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/alecthomas/errors"
 	"github.com/alecthomas/kong"
 
 	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/events"
 	"github.com/block/cachew/internal/logging"
 	"github.com/block/cachew/internal/snapshot"
 )
@@ -26,13 +33,21 @@ type CLI struct {
 	Daily    bool   `help:"Prefix keys with date ($${YYYY}-$${MM}-$${DD}-). Mutually exclusive with --hourly." xor:"timeprefix"`
 	Hourly   bool   `help:"Prefix keys with date and hour ($${YYYY}-$${MM}-$${DD}-$${HH}-). Mutually exclusive with --daily." xor:"timeprefix"`
 
+	UploadChunkMB int `help:"Upload objects in chunks of this many MB, each retried until committed, so that uploads over unreliable links resume rather than restart (0 uploads in a single request). A failed upload is resumed by the next upload of the same key."`
+
 	Get    GetCmd    `cmd:"" help:"Download object from cache." group:"Operations:"`
 	Stat   StatCmd   `cmd:"" help:"Show metadata for cached object." group:"Operations:"`
 	Put    PutCmd    `cmd:"" help:"Upload object to cache." group:"Operations:"`
 	Delete DeleteCmd `cmd:"" help:"Remove object from cache." group:"Operations:"`
+	Sign   SignCmd   `cmd:"" help:"Mint a signed URL granting access to an object." group:"Operations:"`
+	List   ListCmd   `cmd:"" help:"List cached objects." group:"Operations:"`
+	Export ExportCmd `cmd:"" help:"Write cached objects to an archive, eg. to seed a cache on another network." group:"Operations:"`
+	Import ImportCmd `cmd:"" help:"Load the objects in an archive written by export into the cache." group:"Operations:"`
 
 	Snapshot SnapshotCmd `cmd:"" help:"Create compressed archive of directory and upload." group:"Snapshots:"`
 	Restore  RestoreCmd  `cmd:"" help:"Download and extract archive to directory." group:"Snapshots:"`
+
+	Watch WatchCmd `cmd:"" help:"Stream live events from the server." group:"Diagnostics:"`
 }
 
 func main() {
@@ -42,10 +57,14 @@ func main() {
 	_, ctx = logging.Configure(ctx, cli.LoggingConfig)
 
 	remote := cache.NewRemote(cli.URL)
+	if cli.UploadChunkMB > 0 {
+		remote = remote.WithChunkSize(cli.UploadChunkMB << 20)
+	}
 	defer remote.Close()
 
 	kctx.BindTo(ctx, (*context.Context)(nil))
 	kctx.BindTo(remote, (*cache.Cache)(nil))
+	kctx.Bind(remote)
 	kctx.FatalIfErrorf(kctx.Run(ctx))
 }
 
@@ -93,15 +112,23 @@ func (c *StatCmd) Run(ctx context.Context, cache cache.Cache) error {
 }
 
 type PutCmd struct {
-	Key     PlatformKey       `arg:"" help:"Object key (hex or string)."`
-	Input   *os.File          `arg:"" help:"Input file (default: stdin)." default:"-"`
-	TTL     time.Duration     `help:"Time to live for the object."`
-	Headers map[string]string `short:"H" help:"Additional headers (key=value)."`
+	Key      PlatformKey       `arg:"" help:"Object key (hex or string)."`
+	Input    *os.File          `arg:"" help:"Input file (default: stdin)." default:"-"`
+	TTL      time.Duration     `help:"Time to live for the object."`
+	Headers  map[string]string `short:"H" help:"Additional headers (key=value)."`
+	IfAbsent bool              `help:"Only upload the object if it is not already cached, printing whether it was created."`
+	Restart  bool              `help:"Discard any incomplete chunked upload of the object rather than resuming it."`
 }
 
-func (c *PutCmd) Run(ctx context.Context, cache cache.Cache) error {
+func (c *PutCmd) Run(ctx context.Context, cache cache.Cache, remote *cache.Remote) error {
 	defer c.Input.Close()
 
+	if c.Restart {
+		if err := remote.AbortUpload(ctx, c.Key.Key()); err != nil {
+			return errors.Wrap(err, "failed to discard incomplete upload")
+		}
+	}
+
 	headers := make(http.Header)
 	for key, value := range c.Headers {
 		headers.Set(key, value)
@@ -111,6 +138,10 @@ func (c *PutCmd) Run(ctx context.Context, cache cache.Cache) error {
 		headers.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(filename))) //nolint:perfsprint
 	}
 
+	if c.IfAbsent {
+		return c.putIfAbsent(ctx, cache, headers)
+	}
+
 	wc, err := cache.Create(ctx, c.Key.Key(), headers, c.TTL)
 	if err != nil {
 		return errors.Wrap(err, "failed to create object")
@@ -123,6 +154,30 @@ func (c *PutCmd) Run(ctx context.Context, cache cache.Cache) error {
 	return errors.Wrap(wc.Close(), "failed to close writer")
 }
 
+// putIfAbsent uploads the object only if it does not already exist, without reading the input if it does.
+func (c *PutCmd) putIfAbsent(ctx context.Context, remote cache.Cache, headers http.Header) error {
+	wc, created, err := cache.CreateIfAbsent(ctx, remote, c.Key.Key(), headers, c.TTL)
+	if err != nil {
+		return errors.Wrap(err, "failed to create object")
+	}
+	if created {
+		if _, err := io.Copy(wc, c.Input); err != nil {
+			return errors.Join(errors.Wrap(err, "failed to copy data"), wc.Close())
+		}
+	}
+	if err := wc.Close(); errors.Is(err, cache.ErrExists) {
+		created = false
+	} else if err != nil {
+		return errors.Wrap(err, "failed to close writer")
+	}
+	if created {
+		fmt.Println("created") //nolint:forbidigo
+	} else {
+		fmt.Println("exists") //nolint:forbidigo
+	}
+	return nil
+}
+
 type DeleteCmd struct {
 	Key PlatformKey `arg:"" help:"Object key (hex or string)."`
 }
@@ -131,16 +186,200 @@ func (c *DeleteCmd) Run(ctx context.Context, cache cache.Cache) error {
 	return errors.Wrap(cache.Delete(ctx, c.Key.Key()), "failed to delete object")
 }
 
+type SignCmd struct {
+	Key        PlatformKey   `arg:"" help:"Object key (hex or string)."`
+	TTL        time.Duration `help:"How long the URL remains valid." default:"1h"`
+	SigningKey string        `help:"Secret shared with the server's signing-key." required:""`
+}
+
+func (c *SignCmd) Run(cli *CLI) error {
+	if c.TTL <= 0 {
+		return errors.New("--ttl must be positive")
+	}
+	token := cache.SignKey([]byte(c.SigningKey), c.Key.Key(), time.Now().Add(c.TTL))
+	fmt.Printf("%s/_signed/%s\n", strings.TrimSuffix(cli.URL, "/"), token) //nolint:forbidigo
+	return nil
+}
+
+type ListCmd struct {
+	Prefix  string `arg:"" optional:"" help:"Only list objects whose hex keys start with this prefix."`
+	Headers bool   `help:"Also print each object's headers."`
+	Token   string `help:"Bearer token for the server's admin-tokens." env:"CACHEW_ADMIN_TOKEN"`
+}
+
+func (c *ListCmd) Run(ctx context.Context, cli *CLI) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(cli.URL, "/")+"/_cache/keys", nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+	req.URL.RawQuery = url.Values{"prefix": {c.Prefix}}.Encode()
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024)) //nolint:errcheck
+		return errors.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	// The server aborts the response if listing fails part way, so it only decodes to the end if complete.
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var object cache.ObjectInfo
+		err := dec.Decode(&object)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "listing incomplete")
+		}
+		fmt.Printf("%s\t%d\n", object.Key.String(), object.Size) //nolint:forbidigo
+		if c.Headers {
+			for _, name := range slices.Sorted(maps.Keys(object.Headers)) {
+				for _, value := range object.Headers[name] {
+					fmt.Printf("\t%s: %s\n", name, value) //nolint:forbidigo
+				}
+			}
+		}
+	}
+}
+
+type ExportCmd struct {
+	To    string `required:"" help:"Archive to write. An existing archive from an interrupted export is resumed." type:"path"`
+	Token string `help:"Bearer token for the server's admin-tokens." env:"CACHEW_ADMIN_TOKEN"`
+}
+
+func (c *ExportCmd) Run(ctx context.Context, remote *cache.Remote) error {
+	result, err := cache.Export(ctx, remote.WithToken(c.Token), c.To)
+	if err != nil {
+		return errors.Errorf("export incomplete after %d objects, re-run to resume: %w", result.Resumed+result.Objects, err)
+	}
+	fmt.Printf("exported %d objects (%d bytes), %d already in %s\n", result.Objects, result.Bytes, result.Resumed, c.To) //nolint:forbidigo
+	return nil
+}
+
+type ImportCmd struct {
+	From   string `required:"" help:"Archive to read, as written by export." type:"existingfile"`
+	DryRun bool   `help:"List the objects that would be imported, and skipped, without writing to the cache."`
+}
+
+func (c *ImportCmd) Run(ctx context.Context, remote cache.Cache) error {
+	f, err := os.Open(c.From)
+	if err != nil {
+		return errors.Wrap(err, "failed to open archive")
+	}
+	defer f.Close()
+	if c.DryRun {
+		objects, result, err := cache.PlanImport(ctx, remote, f)
+		if err != nil {
+			return errors.Wrap(err, "dry run incomplete")
+		}
+		for _, object := range objects {
+			fmt.Printf("%s\t%d\n", object.Key.String(), object.Size) //nolint:forbidigo
+		}
+		fmt.Printf("would import %d objects (%d bytes), %d already cached, %d expired\n", result.Objects, result.Bytes, result.Existing, result.Expired) //nolint:forbidigo
+		return nil
+	}
+	result, err := cache.Import(ctx, remote, f)
+	if err != nil {
+		return errors.Errorf("import incomplete after %d objects, re-run to resume: %w", result.Objects+result.Existing, err)
+	}
+	fmt.Printf("imported %d objects (%d bytes), %d already cached, %d expired\n", result.Objects, result.Bytes, result.Existing, result.Expired) //nolint:forbidigo
+	return nil
+}
+
+type WatchCmd struct {
+	Token string `help:"Bearer token for the server's admin-tokens." env:"CACHEW_ADMIN_TOKEN"`
+}
+
+func (c *WatchCmd) Run(ctx context.Context, cli *CLI) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(cli.URL, "/")+"/_events", nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024)) //nolint:errcheck
+		return errors.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if comment, ok := strings.CutPrefix(line, ":"); ok {
+			fmt.Fprintln(os.Stderr, strings.TrimSpace(comment)) //nolint:forbidigo
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		var event events.Event
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return errors.Wrap(err, "failed to decode event")
+		}
+		fmt.Println(formatEvent(event)) //nolint:forbidigo
+	}
+	return errors.Wrap(scanner.Err(), "event stream failed")
+}
+
+// formatEvent formats an event as a single line, with its attributes in a stable order.
+func formatEvent(event events.Event) string {
+	var b strings.Builder
+	b.WriteString(event.Time.Local().Format("15:04:05.000"))
+	b.WriteString(" ")
+	b.WriteString(string(event.Type))
+	for _, key := range slices.Sorted(maps.Keys(event.Attrs)) {
+		fmt.Fprintf(&b, " %s=%q", key, event.Attrs[key])
+	}
+	return b.String()
+}
+
 type SnapshotCmd struct {
-	Key       PlatformKey   `arg:"" help:"Object key (hex or string)."`
-	Directory string        `arg:"" help:"Directory to archive." type:"path"`
-	TTL       time.Duration `help:"Time to live for the object."`
-	Exclude   []string      `help:"Patterns to exclude (tar --exclude syntax)."`
+	Key          PlatformKey   `arg:"" help:"Object key (hex or string)."`
+	Directory    string        `arg:"" help:"Directory to archive." type:"path"`
+	TTL          time.Duration `help:"Time to live for the object."`
+	Exclude      []string      `help:"Patterns to exclude (tar --exclude syntax, or .gitignore syntax with --gitignore)."`
+	ExcludeFrom  string        `help:"Read patterns to exclude from a file, one per line." type:"existingfile"`
+	Gitignore    bool          `help:"Interpret exclude patterns with .gitignore syntax, eg. to exclude the files a .gitignore does."`
+	Reproducible bool          `help:"Normalize timestamps, ownership and modes so identical trees produce identical snapshots."`
 }
 
 func (c *SnapshotCmd) Run(ctx context.Context, cache cache.Cache) error {
+	exclude := c.Exclude
+	if c.ExcludeFrom != "" {
+		f, err := os.Open(c.ExcludeFrom)
+		if err != nil {
+			return errors.Wrap(err, "failed to open exclude file")
+		}
+		defer f.Close()
+		patterns, err := snapshot.ReadPatterns(f)
+		if err != nil {
+			return errors.Wrap(err, "failed to read exclude file")
+		}
+		exclude = append(exclude, patterns...)
+	}
+
+	var opts []snapshot.Option
+	if c.Reproducible {
+		opts = append(opts, snapshot.Reproducible())
+	}
+	if c.Gitignore {
+		opts = append(opts, snapshot.Gitignore())
+	}
+
 	fmt.Fprintf(os.Stderr, "Archiving %s...\n", c.Directory) //nolint:forbidigo
-	if err := snapshot.Create(ctx, cache, c.Key.Key(), c.Directory, c.TTL, c.Exclude); err != nil {
+	if err := snapshot.Create(ctx, cache, c.Key.Key(), c.Directory, c.TTL, exclude, opts...); err != nil {
 		return errors.Wrap(err, "failed to create snapshot")
 	}
 
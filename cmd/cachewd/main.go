@@ -1,17 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"slices"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/alecthomas/chroma/v2/quick"
+	"github.com/alecthomas/errors"
 	"github.com/alecthomas/hcl/v2"
 	"github.com/alecthomas/kong"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
@@ -19,6 +23,8 @@ import (
 
 	"github.com/block/cachew/internal/cache"
 	"github.com/block/cachew/internal/config"
+	"github.com/block/cachew/internal/events"
+	"github.com/block/cachew/internal/faults"
 	"github.com/block/cachew/internal/gitclone"
 	"github.com/block/cachew/internal/httputil"
 	"github.com/block/cachew/internal/jobscheduler"
@@ -30,19 +36,45 @@ import (
 )
 
 type GlobalConfig struct {
-	Bind            string              `hcl:"bind" default:"127.0.0.1:8080" help:"Bind address for the server."`
-	URL             string              `hcl:"url" default:"http://127.0.0.1:8080/" help:"Base URL for cachewd."`
-	SchedulerConfig jobscheduler.Config `embed:"" hcl:"scheduler,block" prefix:"scheduler-"`
-	LoggingConfig   logging.Config      `embed:"" hcl:"log,block" prefix:"log-"`
-	MetricsConfig   metrics.Config      `embed:"" hcl:"metrics,block" prefix:"metrics-"`
-	GitCloneConfig  gitclone.Config     `embed:"" hcl:"git-clone,block" prefix:"git-clone-"`
+	Bind              string                    `hcl:"bind" default:"127.0.0.1:8080" help:"Bind address for the server."`
+	URL               string                    `hcl:"url" default:"http://127.0.0.1:8080/" help:"Base URL for cachewd."`
+	SchedulerConfig   jobscheduler.Config       `embed:"" hcl:"scheduler,block" prefix:"scheduler-"`
+	LoggingConfig     logging.Config            `embed:"" hcl:"log,block" prefix:"log-"`
+	MetricsConfig     metrics.Config            `embed:"" hcl:"metrics,block" prefix:"metrics-"`
+	GitCloneConfig    gitclone.Config           `embed:"" hcl:"git-clone,block" prefix:"git-clone-"`
+	TieredConfig      cache.TieredConfig        `embed:"" hcl:"tiered,block" prefix:"tiered-"`
+	QuotaConfig       httputil.QuotaConfig      `embed:"" hcl:"quota,block" prefix:"quota-"`
+	ConnectionConfig  httputil.ConnectionConfig `embed:"" hcl:"connections,block" prefix:"connections-"`
+	ProxyConfig       httputil.ProxyConfig      `embed:"" hcl:"proxy,block" prefix:"proxy-"`
+	CrawlerConfig     httputil.CrawlerConfig    `embed:"" hcl:"crawlers,block" prefix:"crawlers-"`
+	PathConfig        httputil.PathConfig       `embed:"" hcl:"paths,block" prefix:"paths-"`
+	UploadConfig      strategy.UploadConfig     `embed:"" hcl:"uploads,block" prefix:"uploads-"`
+	AdminTokens       []string                  `hcl:"admin-tokens,optional" help:"Bearer tokens allowed to access administrative and diagnostic endpoints. If empty, they are unrestricted."`
+	SigningKey        string                    `hcl:"signing-key,optional" help:"Secret verifying signed URLs minted with \"cachew sign\". If empty, signed URLs are disabled."`
+	UserAgent         string                    `hcl:"user-agent,optional" help:"User-Agent sent on upstream requests. Defaults to cachewd/<version>."`
+	ForwardUserAgent  bool                      `hcl:"forward-user-agent,optional" help:"Forward the client's User-Agent to upstreams in X-Forwarded-User-Agent."`
+	KeyLength         int                       `hcl:"key-length,optional" default:"32" help:"Length in bytes of cache keys, between 16 and 32. Changing it invalidates cached objects."`
+	WarmupGracePeriod time.Duration             `hcl:"warmup-grace-period,optional" help:"Longest time after startup that /_readiness reports 503 while warmup tasks, such as refreshing stale git clones, complete (0 disables)."`
 }
 
+// Limits on clients streaming from /_events, so that slow or numerous watchers cannot hold unbounded memory.
+const (
+	eventSubscribers = 16
+	eventBuffer      = 256
+)
+
+// version is set at build time via -ldflags.
+var version = "dev" //nolint:gochecknoglobals
+
 var cli struct { //nolint:gochecknoglobals
 	Schema bool `help:"Print the configuration file schema." xor:"command"`
 
 	Config kong.ConfigFlag `hcl:"-" help:"Configuration file path." placeholder:"PATH" required:"" default:"cachew.hcl"`
 
+	// EnableFaultInjection can only be set on the command line, so that a configuration file copied from staging
+	// cannot enable it in production.
+	EnableFaultInjection bool `hcl:"-" help:"Allow the fault-injection cache decorator and the /_faults endpoint, and inject faults into upstream requests. For resilience testing only."`
+
 	// GlobalConfig accepts command-line, but can also be parsed from HCL.
 	GlobalConfig
 }
@@ -57,17 +89,52 @@ func main() {
 	ast, err := hcl.Parse(configReader)
 	kctx.FatalIfErrorf(err)
 
-	_, providersConfig := config.Split[GlobalConfig](ast)
+	globalConfig, providersConfig := config.Split[GlobalConfig](ast)
+	kctx.FatalIfErrorf(cli.QuotaConfig.Validate(), "invalid quota")
+	kctx.FatalIfErrorf(cli.UploadConfig.Validate(), "invalid uploads")
+	kctx.FatalIfErrorf(cli.ConnectionConfig.Validate(), "invalid connections")
+	kctx.FatalIfErrorf(cache.SetKeyLength(cli.KeyLength), "invalid key-length")
+	kctx.FatalIfErrorf(httputil.SetDefaultProxy(cli.ProxyConfig), "invalid proxy")
 
 	ctx := context.Background()
 	logger, ctx := logging.Configure(ctx, cli.LoggingConfig)
 
+	bus := events.NewBus(eventSubscribers, eventBuffer)
+	ctx = events.ContextWithBus(ctx, bus)
+
+	warmup := httputil.NewWarmup(ctx, cli.WarmupGracePeriod)
+	ctx = httputil.ContextWithWarmup(ctx, warmup)
+
+	// Identify ourselves to upstreams, both over HTTP and from git.
+	userAgent := cli.UserAgent
+	if userAgent == "" {
+		userAgent = "cachewd/" + version
+	}
+	// Upstreams, including git and S3, share the configured proxy, which strategies may override.
+	transport := http.DefaultTransport.(*http.Transport).Clone() //nolint:errcheck
+	transport.Proxy = httputil.Proxy
+	http.DefaultTransport = &httputil.UserAgentTransport{ //nolint:reassign
+		Next:          transport,
+		UserAgent:     userAgent,
+		ForwardClient: cli.ForwardUserAgent,
+	}
+
+	var injector *faults.Injector
+	if cli.EnableFaultInjection {
+		logger.WarnContext(ctx, "Fault injection is enabled, do not use in production")
+		injector = faults.NewInjector()
+		http.DefaultTransport = injector.Transport(http.DefaultTransport) //nolint:reassign
+	}
+	cli.GitCloneConfig.UserAgent = userAgent
+
 	// Start initialising
 	managerProvider := gitclone.NewManagerProvider(ctx, cli.GitCloneConfig)
 
 	scheduler := jobscheduler.New(ctx, cli.SchedulerConfig)
 
-	cr, sr := newRegistries(scheduler, managerProvider)
+	authorizer := httputil.NewTokenAuthorizer(cli.AdminTokens)
+
+	cr, sr := newRegistries(scheduler, managerProvider, authorizer, injector)
 
 	// Commands
 	switch { //nolint:gocritic
@@ -76,41 +143,87 @@ func main() {
 		return
 	}
 
-	mux, err := newMux(ctx, cr, sr, providersConfig)
-	kctx.FatalIfErrorf(err)
+	makeMux := func() *http.ServeMux { return newMux(authorizer, bus, warmup, injector) }
+	mux := makeMux()
+	loaded, err := config.Load(ctx, cr, sr, providersConfig, cli.TieredConfig, mux, parseEnvars())
+	kctx.FatalIfErrorf(err, "load config")
+	handler := config.NewHandler(mux)
+	config.NewReloader(loaded, string(cli.Config), handler, makeMux, func(ast *hcl.AST) *hcl.AST {
+		global, providers := config.Split[GlobalConfig](ast)
+		if !sameHCL(global, globalConfig) {
+			logger.WarnContext(ctx, "Global configuration changed, restart to apply")
+		}
+		return providers
+	}).ReloadOnSIGHUP(ctx)
 
 	metricsClient, err := metrics.New(ctx, cli.MetricsConfig)
 	kctx.FatalIfErrorf(err, "failed to create metrics client")
-	defer func() {
+	// Fatal errors exit without running deferred functions, so metrics are flushed explicitly before exiting.
+	closeMetrics := func() {
 		if err := metricsClient.Close(); err != nil {
 			logger.ErrorContext(ctx, "failed to close metrics client", "error", err)
 		}
-	}()
+	}
 
 	if err := metricsClient.ServeMetrics(ctx); err != nil {
+		closeMetrics()
 		kctx.FatalIfErrorf(err, "failed to start metrics server")
 	}
 
 	logger.InfoContext(ctx, "Starting cachewd", slog.String("bind", cli.Bind))
 
-	server := newServer(ctx, logger, mux)
-	err = server.ListenAndServe()
+	limiter := httputil.NewConnectionLimiter(cli.ConnectionConfig)
+	server := newServer(ctx, logger, handler, limiter, authorizer)
+	listener, err := net.Listen("tcp", cli.Bind)
+	if err != nil {
+		closeMetrics()
+		kctx.FatalIfErrorf(err)
+	}
+	go shutdownOnSignal(ctx, logger, server)
+	err = server.Serve(limiter.Listener(listener))
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	closeMetrics()
 	kctx.FatalIfErrorf(err)
 }
 
-func newRegistries(scheduler jobscheduler.Scheduler, cloneManagerProvider gitclone.ManagerProvider) (*cache.Registry, *strategy.Registry) {
+// shutdownGracePeriod is how long in-flight requests are given to complete on shutdown.
+const shutdownGracePeriod = 30 * time.Second
+
+// shutdownOnSignal gracefully shuts down server on SIGINT or SIGTERM, after which Serve returns.
+func shutdownOnSignal(ctx context.Context, logger *slog.Logger, server *http.Server) {
+	signalCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-signalCtx.Done()
+	logger.InfoContext(ctx, "Shutting down", slog.Duration("grace_period", shutdownGracePeriod))
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownGracePeriod)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.WarnContext(ctx, "In-flight requests did not complete before shutdown", "error", err)
+	}
+}
+
+func newRegistries(scheduler jobscheduler.Scheduler, cloneManagerProvider gitclone.ManagerProvider, authorizer httputil.Authorizer, injector *faults.Injector) (*cache.Registry, *strategy.Registry) {
 	cr := cache.NewRegistry()
 	cache.RegisterMemory(cr)
 	cache.RegisterDisk(cr)
 	cache.RegisterS3(cr)
+	cache.RegisterEncrypted(cr)
+	cache.RegisterSalted(cr)
+	cache.RegisterWriteLock(cr)
+	cache.RegisterEpoch(cr)
+	if injector != nil {
+		faults.Register(cr, injector)
+	}
 
 	sr := strategy.NewRegistry()
-	strategy.RegisterAPIV1(sr)
+	strategy.RegisterAPIV1(sr, authorizer, cli.SigningKey, cli.UploadConfig)
 	strategy.RegisterArtifactory(sr)
 	strategy.RegisterGitHubReleases(sr)
 	strategy.RegisterHermit(sr, cli.URL)
 	strategy.RegisterHost(sr)
-	git.Register(sr, scheduler, cloneManagerProvider)
+	git.Register(sr, scheduler, cloneManagerProvider, authorizer)
 	gomod.Register(sr, cloneManagerProvider)
 
 	return cr, sr
@@ -132,28 +245,41 @@ func printSchema(kctx *kong.Context, cr *cache.Registry, sr *strategy.Registry)
 	}
 }
 
-func newMux(ctx context.Context, cr *cache.Registry, sr *strategy.Registry, providersConfig *hcl.AST) (*http.ServeMux, error) {
+// newMux creates a mux with the routes that are not provided by strategies.
+func newMux(authorizer httputil.Authorizer, bus *events.Bus, warmup *httputil.Warmup, injector *faults.Injector) *http.ServeMux {
 	mux := http.NewServeMux()
 
+	mux.Handle("GET /_events", httputil.RequireAuthorization(authorizer, events.Handler(bus)))
+
+	if injector != nil {
+		mux.Handle("GET /_faults", httputil.RequireAuthorization(authorizer, injector.Handler()))
+		mux.Handle("POST /_faults", httputil.RequireAuthorization(authorizer, injector.Handler()))
+	}
+
 	mux.HandleFunc("GET /_liveness", func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("OK")) //nolint:errcheck
 	})
 
-	mux.HandleFunc("GET /_readiness", func(w http.ResponseWriter, _ *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("OK")) //nolint:errcheck
-	})
+	mux.Handle("GET /_readiness", warmup.ReadinessHandler())
 
-	if err := config.Load(ctx, cr, sr, providersConfig, mux, parseEnvars()); err != nil {
-		return nil, fmt.Errorf("load config: %w", err)
-	}
+	mux.Handle("GET /robots.txt", httputil.RobotsHandler())
+
+	return mux
+}
 
-	return mux, nil
+// sameHCL returns true if a and b marshal to the same HCL.
+func sameHCL(a, b *hcl.AST) bool {
+	adata, aerr := hcl.MarshalAST(a)
+	bdata, berr := hcl.MarshalAST(b)
+	return aerr == nil && berr == nil && bytes.Equal(adata, bdata)
 }
 
-func newServer(ctx context.Context, logger *slog.Logger, mux *http.ServeMux) *http.Server {
-	var handler http.Handler = mux
+func newServer(ctx context.Context, logger *slog.Logger, handler http.Handler, limiter *httputil.ConnectionLimiter, authorizer httputil.Authorizer) *http.Server {
+	// Health checks must be answered even when clients hold every connection.
+	handler = limiter.Middleware(handler, "/_liveness", "/_readiness")
+	handler = httputil.NewCrawlerBlocker(cli.CrawlerConfig).Middleware(handler, "/_liveness", "/_readiness", "/robots.txt")
+	handler = httputil.NewByteQuota(cli.QuotaConfig, authorizer).Middleware(handler)
 
 	handler = otelhttp.NewMiddleware(cli.MetricsConfig.ServiceName,
 		otelhttp.WithMeterProvider(otel.GetMeterProvider()),
@@ -161,17 +287,23 @@ func newServer(ctx context.Context, logger *slog.Logger, mux *http.ServeMux) *ht
 	)(handler)
 
 	handler = httputil.LoggingMiddleware(handler)
+	handler = httputil.CaptureUserAgent(handler)
+	// Paths are cleaned before any other middleware, so that each sees the path that is routed.
+	handler = httputil.NewPathNormalizer(cli.PathConfig).Middleware(handler)
 
 	return &http.Server{
-		Addr:              cli.Bind,
-		Handler:           handler,
+		Addr:    cli.Bind,
+		Handler: handler,
+		// Bodies may be large, but headers are small, so slow clients are cut off quickly while sending them.
 		ReadTimeout:       30 * time.Minute,
 		WriteTimeout:      30 * time.Minute,
-		ReadHeaderTimeout: 30 * time.Second,
+		ReadHeaderTimeout: cli.ConnectionConfig.HeaderTimeout,
+		IdleTimeout:       cli.ConnectionConfig.IdleTimeout,
 		BaseContext: func(net.Listener) context.Context {
 			return ctx
 		},
 		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			ctx = limiter.ConnContext(ctx, c)
 			return logging.ContextWithLogger(ctx, logger.With("client", c.RemoteAddr().String()))
 		},
 	}
@@ -5,8 +5,12 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/alecthomas/errors"
@@ -19,18 +23,50 @@ var ErrNotFound = errors.New("cache backend not found")
 // ErrStatsUnavailable is returned when a cache backend cannot provide statistics.
 var ErrStatsUnavailable = errors.New("stats unavailable")
 
+// ErrPurgeUnavailable is returned when a cache backend cannot purge objects in bulk.
+var ErrPurgeUnavailable = errors.New("purge unavailable")
+
+// ErrExists is returned when an object created with [CreateExclusive] already exists.
+var ErrExists = errors.New("object already exists")
+
 type registryEntry struct {
-	schema  *hcl.Block
-	factory func(ctx context.Context, config *hcl.Block) (Cache, error)
+	schema   *hcl.Block
+	factory  func(ctx context.Context, config *hcl.Block) (Cache, error)
+	validate func(config *hcl.Block) error
+}
+
+type decoratorEntry struct {
+	schema    *hcl.Block
+	decorator func(ctx context.Context, config *hcl.Block, inner Cache) (Cache, error)
+	validate  func(config *hcl.Block) error
+}
+
+// Validator is implemented by configuration structs that can check themselves for misconfiguration.
+type Validator interface {
+	Validate() error
+}
+
+// validateBlock unmarshals config into a new Config and validates it if it implements [Validator].
+func validateBlock[Config any](config *hcl.Block) error {
+	var cfg Config
+	if err := hcl.UnmarshalBlock(config, &cfg); err != nil {
+		return errors.WithStack(err)
+	}
+	if v, ok := any(&cfg).(Validator); ok {
+		return errors.WithStack(v.Validate())
+	}
+	return nil
 }
 
 type Registry struct {
-	registry map[string]registryEntry
+	registry   map[string]registryEntry
+	decorators map[string]decoratorEntry
 }
 
 func NewRegistry() *Registry {
 	return &Registry{
-		registry: make(map[string]registryEntry),
+		registry:   make(map[string]registryEntry),
+		decorators: make(map[string]decoratorEntry),
 	}
 }
 
@@ -55,15 +91,47 @@ func Register[Config any, C Cache](r *Registry, id, description string, factory
 			}
 			return factory(ctx, cfg)
 		},
+		validate: validateBlock[Config],
+	}
+}
+
+// Decorator is a function that wraps a cache with additional behaviour, configured from the given hcl-tagged
+// configuration struct.
+type Decorator[Config any] func(ctx context.Context, config Config, inner Cache) (Cache, error)
+
+// RegisterDecorator registers a cache decorator.
+//
+// Decorators wrap the cache constructed from all configured backends.
+func RegisterDecorator[Config any](r *Registry, id, description string, decorator Decorator[Config]) {
+	var c Config
+	schema, err := hcl.BlockSchema(id, &c)
+	if err != nil {
+		panic(err)
+	}
+	block := schema.Entries[0].(*hcl.Block) //nolint:errcheck // This seems spurious
+	block.Comments = hcl.CommentList{description}
+	r.decorators[id] = decoratorEntry{
+		schema: block,
+		decorator: func(ctx context.Context, config *hcl.Block, inner Cache) (Cache, error) {
+			var cfg Config
+			if err := hcl.UnmarshalBlock(config, &cfg); err != nil {
+				return nil, errors.WithStack(err)
+			}
+			return decorator(ctx, cfg, inner)
+		},
+		validate: validateBlock[Config],
 	}
 }
 
-// Schema returns the schema for all registered cache backends.
+// Schema returns the schema for all registered cache backends and decorators.
 func (r *Registry) Schema() *hcl.AST {
 	ast := &hcl.AST{}
 	for _, entry := range r.registry {
 		ast.Entries = append(ast.Entries, entry.schema)
 	}
+	for _, entry := range r.decorators {
+		ast.Entries = append(ast.Entries, entry.schema)
+	}
 	return ast
 }
 
@@ -72,6 +140,35 @@ func (r *Registry) Exists(name string) bool {
 	return ok
 }
 
+// IsDecorator returns true if name is a registered cache decorator.
+func (r *Registry) IsDecorator(name string) bool {
+	_, ok := r.decorators[name]
+	return ok
+}
+
+// Validate the configuration of the named cache backend or decorator without constructing it.
+//
+// Will return "ErrNotFound" if neither is found.
+func (r *Registry) Validate(name string, config *hcl.Block) error {
+	if entry, ok := r.registry[name]; ok {
+		return entry.validate(config)
+	}
+	if entry, ok := r.decorators[name]; ok {
+		return entry.validate(config)
+	}
+	return errors.Errorf("%s: %w", name, ErrNotFound)
+}
+
+// Decorate wraps inner with the named decorator.
+//
+// Will return "ErrNotFound" if the decorator is not found.
+func (r *Registry) Decorate(ctx context.Context, name string, config *hcl.Block, inner Cache) (Cache, error) {
+	if entry, ok := r.decorators[name]; ok {
+		return errors.WithStack2(entry.decorator(ctx, config, inner))
+	}
+	return nil, errors.Errorf("%s: %w", name, ErrNotFound)
+}
+
 // Create a new cache instance from the given name and configuration.
 //
 // Will return "ErrNotFound" if the cache backend is not found.
@@ -83,32 +180,79 @@ func (r *Registry) Create(ctx context.Context, name string, config *hcl.Block) (
 }
 
 // Key represents a unique identifier for a cached object.
+//
+// Keys are SHA256 hashes truncated to [KeyLength] bytes, with the remaining bytes zeroed.
 type Key [32]byte
 
+const (
+	// DefaultKeyLength is the default length of keys in bytes, the full SHA256 hash.
+	DefaultKeyLength = 32
+	// MinKeyLength is the shortest key length allowed, below which collisions become a practical concern.
+	MinKeyLength = 16
+)
+
+// keyLength is the configured key length, or zero for [DefaultKeyLength].
+var keyLength atomic.Int32 //nolint:gochecknoglobals
+
+// KeyLength returns the length of keys in bytes.
+func KeyLength() int {
+	if length := keyLength.Load(); length != 0 {
+		return int(length)
+	}
+	return DefaultKeyLength
+}
+
+// SetKeyLength sets the length of keys in bytes, between [MinKeyLength] and [DefaultKeyLength].
+//
+// Shorter keys shorten storage paths and metadata, at the cost of collision resistance. It must be called before
+// any keys are created or caches opened. Objects stored with a different key length are never returned, as their
+// keys do not match, and a [Disk] cache is emptied when it is opened with a different key length.
+//
+// Keys of another length parsed by [ParseKey], such as those of clients using the default length, are hashed rather
+// than decoded, so they consistently address objects of their own but not those keyed by the server.
+func SetKeyLength(length int) error {
+	if length < MinKeyLength || length > DefaultKeyLength {
+		return errors.Errorf("key length must be between %d and %d bytes, got %d", MinKeyLength, DefaultKeyLength, length)
+	}
+	keyLength.Store(int32(length)) //nolint:gosec
+	return nil
+}
+
 // ParseKey from its hex-encoded string form.
 func ParseKey(key string) (Key, error) {
 	var k Key
 	return k, k.UnmarshalText([]byte(key))
 }
 
-func NewKey(url string) Key { return Key(sha256.Sum256([]byte(url))) }
+func NewKey(url string) Key { return truncateKey(sha256.Sum256([]byte(url))) }
+
+func (k *Key) String() string { return hex.EncodeToString(k[:KeyLength()]) }
 
-func (k *Key) String() string { return hex.EncodeToString(k[:]) }
+// Variant returns the key of a sidecar object stored alongside the object at k, such as a precompressed copy of it.
+func (k *Key) Variant(name string) Key {
+	return truncateKey(sha256.Sum256(append(k[:], "\x00"+name...)))
+}
 
+// UnmarshalText decodes a hex-encoded key of exactly [KeyLength] bytes. Any other text, including hex of another
+// length, is hashed with [NewKey], so that text is never mistaken for a key.
 func (k *Key) UnmarshalText(text []byte) error {
-	// Try to decode as SHA256 hex encoded string
-	if len(text) == 64 {
-		bytes, err := hex.DecodeString(string(text))
-		if err == nil && len(bytes) == len(*k) {
-			copy(k[:], bytes)
+	if len(text) == 2*KeyLength() {
+		var decoded Key
+		if _, err := hex.Decode(decoded[:], text); err == nil {
+			*k = decoded
 			return nil
 		}
 	}
-	// If not valid hex, treat as string and SHA256 it
 	*k = NewKey(string(text))
 	return nil
 }
 
+// truncateKey zeroes the bytes of hash beyond [KeyLength].
+func truncateKey(hash [32]byte) Key {
+	clear(hash[KeyLength():])
+	return Key(hash)
+}
+
 func (k *Key) MarshalText() ([]byte, error) {
 	return []byte(k.String()), nil
 }
@@ -120,7 +264,7 @@ func FilterTransportHeaders(headers http.Header) http.Header {
 	for key, values := range headers {
 		// Skip standard HTTP headers added by transport layer or that shouldn't be cached
 		if key == "Content-Length" || key == "Date" || key == "Accept-Encoding" ||
-			key == "User-Agent" || key == "Transfer-Encoding" || key == "Time-To-Live" {
+			key == "User-Agent" || key == "Transfer-Encoding" || key == "Time-To-Live" || key == "If-None-Match" {
 			continue
 		}
 		filtered[key] = values
@@ -138,6 +282,256 @@ type Stats struct {
 	Capacity int64 `json:"capacity"`
 }
 
+// PurgeOptions selects the objects removed by [Purger.Purge]. Zero fields are ignored.
+type PurgeOptions struct {
+	// OlderThan removes objects that were written more than this long ago.
+	OlderThan time.Duration
+	// TargetBytes removes objects, in the order the cache would evict them, until the cache is no larger than this.
+	TargetBytes int64
+	// Tag restricts the purge to objects created with this tag in their [TagHeader] header, removing all of them
+	// unless OlderThan is also set. It cannot be combined with TargetBytes.
+	Tag string
+}
+
+// validate returns an error if the options cannot be combined.
+func (o PurgeOptions) validate() error {
+	if o.Tag != "" && o.TargetBytes > 0 {
+		return errors.New("a purge by tag cannot have a target size")
+	}
+	return nil
+}
+
+// PurgeResult reports what was removed by [Purger.Purge].
+type PurgeResult struct {
+	// Objects is the number of objects removed.
+	Objects int64 `json:"objects"`
+	// Bytes is the total size of the objects removed.
+	Bytes int64 `json:"bytes"`
+}
+
+// Purger is implemented by caches that can remove objects in bulk.
+type Purger interface {
+	// Purge removes the objects selected by options. Expired objects may also be removed and are included in the
+	// result.
+	Purge(ctx context.Context, options PurgeOptions) (PurgeResult, error)
+}
+
+// Purge removes objects selected by options from c.
+//
+// Returns [ErrPurgeUnavailable] if the cache does not implement [Purger].
+func Purge(ctx context.Context, c Cache, options PurgeOptions) (PurgeResult, error) {
+	if p, ok := c.(Purger); ok {
+		return errors.WithStack2(p.Purge(ctx, options))
+	}
+	return PurgeResult{}, errors.Errorf("%s: %w", c.String(), ErrPurgeUnavailable)
+}
+
+// StaleOpener is implemented by caches that can open objects that have expired but not yet been removed.
+type StaleOpener interface {
+	// OpenStale opens an object in the cache, even if it has expired, provided it expired less than "grace" ago.
+	//
+	// Must return os.ErrNotExist if the file does not exist or expired more than "grace" ago.
+	OpenStale(ctx context.Context, key Key, grace time.Duration) (io.ReadCloser, http.Header, error)
+}
+
+// OpenStale opens an object that may have expired up to "grace" ago.
+//
+// If the cache does not implement [StaleOpener], this falls back to [Cache.Open].
+func OpenStale(ctx context.Context, c Cache, key Key, grace time.Duration) (io.ReadCloser, http.Header, error) {
+	if so, ok := c.(StaleOpener); ok {
+		return errors.WithStack3(so.OpenStale(ctx, key, grace))
+	}
+	return errors.WithStack3(c.Open(ctx, key))
+}
+
+// ExpiryReporter is implemented by caches that can report when an object expires.
+type ExpiryReporter interface {
+	// ExpiresAt returns when the object at key expires.
+	//
+	// Must return os.ErrNotExist if the object does not exist or has expired.
+	ExpiresAt(ctx context.Context, key Key) (time.Time, error)
+}
+
+// ExpiresAt returns when the object at key expires, or the zero time if the cache does not implement
+// [ExpiryReporter].
+func ExpiresAt(ctx context.Context, c Cache, key Key) (time.Time, error) {
+	if er, ok := c.(ExpiryReporter); ok {
+		return errors.WithStack2(er.ExpiresAt(ctx, key))
+	}
+	return time.Time{}, nil
+}
+
+// ExclusiveCreator is implemented by caches that can create an object only if it does not already exist.
+type ExclusiveCreator interface {
+	// CreateExclusive is like [Cache.Create], but the object is only committed if no unexpired object with the same
+	// key exists when the writer is closed. Otherwise Close discards the object and returns [ErrExists].
+	//
+	// Implementations may also return ErrExists immediately if the object already exists.
+	CreateExclusive(ctx context.Context, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error)
+}
+
+// CreateExclusive creates an object only if it does not already exist, returning [ErrExists] if it does.
+//
+// If the cache does not implement [ExclusiveCreator], this falls back to checking for the object before calling
+// [Cache.Create], so concurrent writers may still overwrite each other.
+func CreateExclusive(ctx context.Context, c Cache, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	if ec, ok := c.(ExclusiveCreator); ok {
+		return errors.WithStack2(ec.CreateExclusive(ctx, key, headers, ttl))
+	}
+	exists, err := c.Has(ctx, key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if exists {
+		return nil, errors.WithStack(ErrExists)
+	}
+	return errors.WithStack2(c.Create(ctx, key, headers, ttl))
+}
+
+// CreateIfAbsent creates an object only if no unexpired object with the same key exists, so that callers can avoid
+// uploading content that is already cached.
+//
+// If the object exists, it returns false and a writer that discards everything written to it. Existence is checked
+// before the object is created with [CreateExclusive], so if another writer commits the object first, Close on the
+// returned writer returns [ErrExists] and the object is not replaced.
+func CreateIfAbsent(ctx context.Context, c Cache, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, bool, error) {
+	exists, err := c.Has(ctx, key)
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	if !exists {
+		w, err := CreateExclusive(ctx, c, key, headers, ttl)
+		if err == nil {
+			return w, true, nil
+		} else if !errors.Is(err, ErrExists) {
+			return nil, false, err
+		}
+	}
+	return discardWriter{}, false, nil
+}
+
+// discardWriter is an [io.WriteCloser] that discards everything written to it.
+type discardWriter struct{}
+
+func (discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (discardWriter) Close() error                { return nil }
+
+// Range of bytes within an object, as requested by an HTTP "Range: bytes=" header.
+type Range struct {
+	// Start is the offset of the first byte. If negative, the range is instead the final -Start bytes of the object.
+	Start int64
+	// End is the offset of the last byte, inclusive. If negative, the range extends to the end of the object.
+	End int64
+}
+
+// Resolve the range against an object of "size" bytes, returning the absolute offset and length to read.
+//
+// Returns a [*RangeNotSatisfiableError] if the range lies entirely outside the object.
+func (r Range) Resolve(size int64) (offset, length int64, err error) {
+	if r.Start < 0 {
+		offset = max(size+r.Start, 0)
+	} else {
+		offset = r.Start
+	}
+	end := size - 1
+	if r.Start >= 0 && r.End >= 0 {
+		end = min(r.End, end)
+	}
+	if offset >= size || end < offset {
+		return 0, 0, &RangeNotSatisfiableError{Size: size}
+	}
+	return offset, end - offset + 1, nil
+}
+
+// ParseRange parses a single-range "Range: bytes=" header.
+//
+// Returns false if the header is absent, malformed or requests multiple ranges, in which case it should be ignored
+// and the full object served.
+func ParseRange(header string) (Range, bool) {
+	ranges, ok := ParseRanges(header)
+	if !ok || len(ranges) != 1 {
+		return Range{}, false
+	}
+	return ranges[0], true
+}
+
+// ParseRanges parses a "Range: bytes=" header, which may request multiple ranges.
+//
+// Returns false if the header is absent or any of its ranges is malformed, in which case it should be ignored and
+// the full object served.
+func ParseRanges(header string) ([]Range, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return nil, false
+	}
+	var ranges []Range
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		rng, ok := parseRangeSpec(part)
+		if !ok {
+			return nil, false
+		}
+		ranges = append(ranges, rng)
+	}
+	return ranges, len(ranges) > 0
+}
+
+func parseRangeSpec(spec string) (Range, bool) {
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return Range{}, false
+	}
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix <= 0 {
+			return Range{}, false
+		}
+		return Range{Start: -suffix, End: -1}, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return Range{}, false
+	}
+	if last == "" {
+		return Range{Start: start, End: -1}, true
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return Range{}, false
+	}
+	return Range{Start: start, End: end}, true
+}
+
+// RangeNotSatisfiableError is returned by [RangeOpener.OpenRange] when the requested range lies outside the object.
+type RangeNotSatisfiableError struct {
+	// Size of the object in bytes.
+	Size int64
+}
+
+func (r *RangeNotSatisfiableError) Error() string {
+	return fmt.Sprintf("range not satisfiable for object of %d bytes", r.Size)
+}
+
+// RangeOpener is implemented by caches that can read part of an object without reading all of it.
+type RangeOpener interface {
+	// OpenRange opens the bytes of an object selected by "rng".
+	//
+	// The returned headers MUST include Content-Range and Content-Length headers describing the selected bytes.
+	// Must return os.ErrNotExist if the file does not exist, or a [*RangeNotSatisfiableError] if the range lies
+	// outside the object.
+	OpenRange(ctx context.Context, key Key, rng Range) (io.ReadCloser, http.Header, error)
+}
+
+// SetRangeHeaders sets the Content-Range and Content-Length headers for "length" bytes at "offset" of an object of
+// "size" bytes.
+func SetRangeHeaders(headers http.Header, offset, length, size int64) {
+	headers.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+	headers.Set("Content-Length", strconv.FormatInt(length, 10))
+}
+
 // A Cache knows how to retrieve, create and delete objects from a cache.
 //
 // Objects in the cache are not guaranteed to persist and implementations may delete them at any time.
@@ -149,6 +543,10 @@ type Cache interface {
 	// Expired files MUST not be returned.
 	// Must return os.ErrNotExist if the file does not exist.
 	Stat(ctx context.Context, key Key) (http.Header, error)
+	// Has returns true if an unexpired object exists in the cache.
+	//
+	// It MUST NOT read the object or extend its expiry, so it is cheaper than Open and does not affect eviction.
+	Has(ctx context.Context, key Key) (bool, error)
 	// Open an existing file in the cache.
 	//
 	// Expired files MUST NOT be returned.
@@ -163,6 +561,11 @@ type Cache interface {
 	//
 	// If the context is cancelled the object MUST NOT be made available in the cache.
 	Create(ctx context.Context, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error)
+	// Touch resets the expiry of an existing object to "ttl" from now, without rewriting its body.
+	//
+	// If "ttl" is zero, a maximum TTL MUST be used by the implementation.
+	// Must return os.ErrNotExist if the file does not exist or has expired.
+	Touch(ctx context.Context, key Key, ttl time.Duration) error
 	// Delete a file from the cache.
 	//
 	// MUST be atomic.
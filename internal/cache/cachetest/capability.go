@@ -0,0 +1,66 @@
+package cachetest
+
+import (
+	"testing"
+
+	"github.com/block/cachew/internal/cache"
+)
+
+// Capability is an optional behaviour of a cache, whose conformance tests are skipped for caches that lack it.
+type Capability string
+
+const (
+	Has             Capability = "has"
+	Touch           Capability = "touch"
+	Stats           Capability = "stats"
+	Range           Capability = "range"            // Implements [cache.RangeOpener].
+	Purge           Capability = "purge"            // Implements [cache.Purger].
+	CreateExclusive Capability = "create-exclusive" // Implements [cache.ExclusiveCreator].
+	ListTagged      Capability = "list-tagged"      // Implements [cache.TagLister].
+	ListKeys        Capability = "list-keys"        // Implements [cache.Lister].
+)
+
+// Capabilities may be implemented by caches to opt out of capabilities they would otherwise be detected as supporting.
+//
+// This is for backends that satisfy an interface without honouring it, such as a wrapper that must implement every
+// method of [cache.Cache] but cannot extend TTLs.
+type Capabilities interface {
+	Supports(capability Capability) bool
+}
+
+// Supports returns true if c supports capability.
+//
+// Capabilities of the [cache.Cache] interface itself are assumed, and the others are detected from the optional
+// interface implementing them, unless c opts out by implementing [Capabilities].
+func Supports(c cache.Cache, capability Capability) bool {
+	if caps, ok := c.(Capabilities); ok && !caps.Supports(capability) {
+		return false
+	}
+	switch capability {
+	case Range:
+		_, ok := c.(cache.RangeOpener)
+		return ok
+	case Purge:
+		_, ok := c.(cache.Purger)
+		return ok
+	case CreateExclusive:
+		_, ok := c.(cache.ExclusiveCreator)
+		return ok
+	case ListTagged:
+		_, ok := c.(cache.TagLister)
+		return ok
+	case ListKeys:
+		_, ok := c.(cache.Lister)
+		return ok
+	default:
+		return true
+	}
+}
+
+// require skips the test unless c supports capability.
+func require(t *testing.T, c cache.Cache, capability Capability) {
+	t.Helper()
+	if !Supports(c, capability) {
+		t.Skipf("%s does not support %s", c.String(), capability)
+	}
+}
@@ -0,0 +1,79 @@
+package cachetest_test
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/cache/cachetest"
+	"github.com/block/cachew/internal/logging"
+)
+
+// countingTouch counts calls to Touch.
+type countingTouch struct {
+	cache.Cache
+	touches *atomic.Int64
+}
+
+func (c countingTouch) Touch(ctx context.Context, key cache.Key, ttl time.Duration) error {
+	c.touches.Add(1)
+	return errors.WithStack(c.Cache.Touch(ctx, key, ttl))
+}
+
+// withoutTouch declares that it does not support Touch, which fails if called.
+type withoutTouch struct {
+	cache.Cache
+}
+
+func (withoutTouch) Supports(capability cachetest.Capability) bool {
+	return capability != cachetest.Touch
+}
+
+func (withoutTouch) Touch(context.Context, cache.Key, time.Duration) error {
+	return errors.New("touch is not supported")
+}
+
+func TestCapabilityDetection(t *testing.T) {
+	newMemory := func(t *testing.T) cache.Cache {
+		t.Helper()
+		_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+		c, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: 100 * time.Millisecond})
+		assert.NoError(t, err)
+		return c
+	}
+
+	t.Run("Detected", func(t *testing.T) {
+		memory := newMemory(t)
+		defer memory.Close()
+		for _, capability := range []cachetest.Capability{
+			cachetest.Has, cachetest.Touch, cachetest.Stats, cachetest.Range, cachetest.Purge, cachetest.CreateExclusive,
+		} {
+			assert.True(t, cachetest.Supports(memory, capability), "%s", capability)
+		}
+		// Embedding the interface hides the optional interfaces of the underlying cache.
+		wrapped := countingTouch{Cache: memory}
+		assert.True(t, cachetest.Supports(wrapped, cachetest.Touch))
+		assert.False(t, cachetest.Supports(wrapped, cachetest.Range))
+		assert.False(t, cachetest.Supports(withoutTouch{memory}, cachetest.Touch))
+	})
+
+	t.Run("SupportedIsTested", func(t *testing.T) {
+		touches := &atomic.Int64{}
+		cachetest.Suite(t, func(t *testing.T) cache.Cache {
+			return countingTouch{Cache: newMemory(t), touches: touches}
+		})
+		assert.NotEqual(t, int64(0), touches.Load(), "the Touch conformance test should have run")
+	})
+
+	t.Run("UnsupportedIsSkipped", func(t *testing.T) {
+		cachetest.Suite(t, func(t *testing.T) cache.Cache {
+			return withoutTouch{newMemory(t)}
+		})
+	})
+}
@@ -5,16 +5,21 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/alecthomas/assert/v2"
+	"github.com/alecthomas/errors"
 
 	"github.com/block/cachew/internal/cache"
 )
 
 // Suite runs a comprehensive test suite against a cache.Cache implementation.
 // All cache implementations should pass this test suite to ensure consistent semantics.
+//
+// Tests of optional behaviour are skipped for caches that do not support it, as reported by [Supports].
 func Suite(t *testing.T, newCache func(t *testing.T) cache.Cache) {
 	t.Run("CreateAndOpen", func(t *testing.T) {
 		testCreateAndOpen(t, newCache(t))
@@ -36,6 +41,10 @@ func Suite(t *testing.T, newCache func(t *testing.T) cache.Cache) {
 		testDelete(t, newCache(t))
 	})
 
+	t.Run("Touch", func(t *testing.T) {
+		testTouch(t, newCache(t))
+	})
+
 	t.Run("MultipleWrites", func(t *testing.T) {
 		testMultipleWrites(t, newCache(t))
 	})
@@ -55,6 +64,46 @@ func Suite(t *testing.T, newCache func(t *testing.T) cache.Cache) {
 	t.Run("LastModified", func(t *testing.T) {
 		testLastModified(t, newCache(t))
 	})
+
+	t.Run("PurgeOlderThan", func(t *testing.T) {
+		testPurgeOlderThan(t, newCache(t))
+	})
+
+	t.Run("PurgeTargetBytes", func(t *testing.T) {
+		testPurgeTargetBytes(t, newCache(t))
+	})
+
+	t.Run("PurgeTag", func(t *testing.T) {
+		testPurgeTag(t, newCache(t))
+	})
+
+	t.Run("ListTagged", func(t *testing.T) {
+		testListTagged(t, newCache(t))
+	})
+
+	t.Run("ListKeys", func(t *testing.T) {
+		testListKeys(t, newCache(t))
+	})
+
+	t.Run("OpenRange", func(t *testing.T) {
+		testOpenRange(t, newCache(t))
+	})
+
+	t.Run("Has", func(t *testing.T) {
+		testHas(t, newCache(t))
+	})
+
+	t.Run("CreateExclusive", func(t *testing.T) {
+		testCreateExclusive(t, newCache(t))
+	})
+
+	t.Run("CreateIfAbsent", func(t *testing.T) {
+		testCreateIfAbsent(t, newCache(t))
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		testStats(t, newCache(t))
+	})
 }
 
 func testCreateAndOpen(t *testing.T, c cache.Cache) {
@@ -158,6 +207,43 @@ func testDelete(t *testing.T, c cache.Cache) {
 	assert.IsError(t, err, os.ErrNotExist)
 }
 
+func testTouch(t *testing.T, c cache.Cache) {
+	defer c.Close()
+	require(t, c, Touch)
+	ctx := t.Context()
+
+	key := cache.NewKey("test-key")
+
+	err := c.Touch(ctx, key, time.Hour)
+	assert.IsError(t, err, os.ErrNotExist)
+
+	writer, err := c.Create(ctx, key, http.Header{"Content-Type": []string{"text/plain"}}, time.Millisecond*50)
+	assert.NoError(t, err)
+
+	_, err = writer.Write([]byte("test data"))
+	assert.NoError(t, err)
+
+	err = writer.Close()
+	assert.NoError(t, err)
+
+	// Implementations may cap the TTL, so keep the timings within the shortest maximum TTL used by the tests.
+	time.Sleep(30 * time.Millisecond)
+	err = c.Touch(ctx, key, time.Hour)
+	assert.NoError(t, err)
+
+	// The original expiry has now passed.
+	time.Sleep(40 * time.Millisecond)
+
+	reader, headers, err := c.Open(ctx, key)
+	assert.NoError(t, err)
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "test data", string(data))
+	assert.Equal(t, "text/plain", headers.Get("Content-Type"))
+}
+
 func testMultipleWrites(t *testing.T, c cache.Cache) {
 	defer c.Close()
 	ctx := t.Context()
@@ -329,3 +415,400 @@ func testLastModified(t *testing.T, c cache.Cache) {
 
 	assert.Equal(t, explicitTime.Format(http.TimeFormat), headers2.Get("Last-Modified"))
 }
+
+// purger returns c as a [cache.Purger], skipping the test if it does not support purging.
+func purger(t *testing.T, c cache.Cache) cache.Purger {
+	t.Helper()
+	require(t, c, Purge)
+	return c.(cache.Purger) //nolint:forcetypeassert
+}
+
+func writeObject(t *testing.T, c cache.Cache, key cache.Key, data []byte, tags ...string) {
+	t.Helper()
+	var headers http.Header
+	if len(tags) > 0 {
+		headers = http.Header{cache.TagHeader: tags}
+	}
+	writer, err := c.Create(t.Context(), key, headers, 0)
+	assert.NoError(t, err)
+	_, err = writer.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+}
+
+func testPurgeOlderThan(t *testing.T, c cache.Cache) {
+	defer c.Close()
+	p := purger(t, c)
+	ctx := t.Context()
+
+	oldKey := cache.NewKey("old")
+	newKey := cache.NewKey("new")
+	writeObject(t, c, oldKey, []byte("old data"))
+	// Keep the timings within the shortest maximum TTL used by the tests.
+	time.Sleep(40 * time.Millisecond)
+	writeObject(t, c, newKey, []byte("new data"))
+
+	result, err := p.Purge(ctx, cache.PurgeOptions{OlderThan: 20 * time.Millisecond})
+	assert.NoError(t, err)
+	assert.True(t, result.Objects >= 1, "expected at least one object to be purged")
+	assert.True(t, result.Bytes > 0, "expected bytes to be reclaimed")
+
+	_, err = c.Stat(ctx, oldKey)
+	assert.IsError(t, err, os.ErrNotExist)
+	_, err = c.Stat(ctx, newKey)
+	assert.NoError(t, err)
+}
+
+func testPurgeTargetBytes(t *testing.T, c cache.Cache) {
+	defer c.Close()
+	p := purger(t, c)
+	ctx := t.Context()
+
+	keys := []cache.Key{cache.NewKey("first"), cache.NewKey("second"), cache.NewKey("third")}
+	for _, key := range keys {
+		writeObject(t, c, key, make([]byte, 1000))
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Implementations may add per-object overhead, so leave room for it.
+	result, err := p.Purge(ctx, cache.PurgeOptions{TargetBytes: 2500})
+	assert.NoError(t, err)
+	assert.True(t, result.Objects >= 1, "expected at least one object to be purged")
+
+	_, err = c.Stat(ctx, keys[0])
+	assert.IsError(t, err, os.ErrNotExist)
+	for _, key := range keys[1:] {
+		_, err = c.Stat(ctx, key)
+		assert.NoError(t, err)
+	}
+}
+
+func testPurgeTag(t *testing.T, c cache.Cache) {
+	defer c.Close()
+	p := purger(t, c)
+	ctx := t.Context()
+
+	ciKey := cache.NewKey("ci")
+	bothKey := cache.NewKey("both")
+	untaggedKey := cache.NewKey("untagged")
+	writeObject(t, c, ciKey, []byte("ci data"), "ci")
+	writeObject(t, c, bothKey, []byte("both data"), "release,ci")
+	writeObject(t, c, untaggedKey, []byte("untagged data"))
+
+	result, err := p.Purge(ctx, cache.PurgeOptions{Tag: "ci"})
+	assert.NoError(t, err)
+	assert.True(t, result.Objects >= 2, "expected both tagged objects to be purged")
+
+	for _, key := range []cache.Key{ciKey, bothKey} {
+		_, err = c.Stat(ctx, key)
+		assert.IsError(t, err, os.ErrNotExist)
+	}
+	_, err = c.Stat(ctx, untaggedKey)
+	assert.NoError(t, err)
+
+	_, err = p.Purge(ctx, cache.PurgeOptions{Tag: "ci", TargetBytes: 1})
+	assert.Error(t, err)
+}
+
+func testListTagged(t *testing.T, c cache.Cache) {
+	defer c.Close()
+	require(t, c, ListTagged)
+	tl := c.(cache.TagLister) //nolint:forcetypeassert
+	ctx := t.Context()
+
+	ciKey := cache.NewKey("ci")
+	bothKey := cache.NewKey("both")
+	writeObject(t, c, ciKey, []byte("ci data"), "ci")
+	writeObject(t, c, bothKey, []byte("both data"), "release", "ci")
+	writeObject(t, c, cache.NewKey("untagged"), []byte("untagged data"))
+
+	objects, err := tl.ListTagged(ctx, "ci")
+	assert.NoError(t, err)
+	keys := map[cache.Key]bool{}
+	for _, object := range objects {
+		keys[object.Key] = true
+	}
+	assert.Equal(t, map[cache.Key]bool{ciKey: true, bothKey: true}, keys)
+
+	objects, err = tl.ListTagged(ctx, "release")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(objects))
+	assert.Equal(t, bothKey, objects[0].Key)
+
+	// Overwriting an object without tags removes it from listings.
+	writeObject(t, c, bothKey, []byte("both data"))
+	objects, err = tl.ListTagged(ctx, "release")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(objects))
+}
+
+func testListKeys(t *testing.T, c cache.Cache) {
+	defer c.Close()
+	require(t, c, ListKeys)
+	ctx := t.Context()
+
+	// list returns the objects listed with prefix by key, failing if listing fails.
+	list := func(prefix string) map[cache.Key]cache.ObjectInfo {
+		t.Helper()
+		objects := map[cache.Key]cache.ObjectInfo{}
+		for object, err := range cache.ListKeys(ctx, c, prefix) {
+			assert.NoError(t, err)
+			objects[object.Key] = object
+		}
+		return objects
+	}
+
+	writer, err := c.Create(ctx, cache.NewKey("expired"), nil, 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	time.Sleep(100 * time.Millisecond)
+	keys := []cache.Key{cache.NewKey("first"), cache.NewKey("second"), cache.NewKey("third")}
+	writeObject(t, c, keys[0], []byte("first data"), "ci")
+	writeObject(t, c, keys[1], []byte("second data"))
+	writeObject(t, c, keys[2], []byte("third data"))
+
+	listedAt := time.Now()
+	objects := list("")
+	assert.Equal(t, 3, len(objects), "expected only the unexpired objects")
+	for _, key := range keys {
+		assert.True(t, objects[key].Size > 0, "expected %s to be listed", key.String())
+		expiresAt := objects[key].ExpiresAt
+		assert.True(t, expiresAt.IsZero() || expiresAt.After(listedAt), "expected %s to expire after it was listed", key.String())
+	}
+	assert.Equal(t, []string{"ci"}, objects[keys[0]].Headers.Values(cache.TagHeader))
+
+	// Prefixes are matched against hex keys, in any case.
+	for _, length := range []int{1, 2, 3, len(keys[1].String())} {
+		prefix := strings.ToUpper(keys[1].String()[:length])
+		for key := range list(prefix) {
+			assert.True(t, strings.HasPrefix(key.String(), strings.ToLower(prefix)), "%s does not match %s", key.String(), prefix)
+		}
+		_, ok := list(prefix)[keys[1]]
+		assert.True(t, ok, "expected %s to be listed with prefix %s", keys[1].String(), prefix)
+	}
+
+	// Iteration may stop early.
+	for range cache.ListKeys(ctx, c, "") {
+		break
+	}
+
+	for _, err := range cache.ListKeys(ctx, c, "not-hex") {
+		assert.IsError(t, err, cache.ErrInvalidKeyPrefix)
+	}
+}
+
+func testOpenRange(t *testing.T, c cache.Cache) {
+	defer c.Close()
+	require(t, c, Range)
+	ro := c.(cache.RangeOpener) //nolint:forcetypeassert
+	ctx := t.Context()
+
+	key := cache.NewKey("range")
+	writeObject(t, c, key, []byte("0123456789"))
+
+	tests := []struct {
+		name          string
+		rng           cache.Range
+		expected      string
+		contentRange  string
+		unsatisfiable bool
+	}{
+		{name: "Bounded", rng: cache.Range{Start: 2, End: 5}, expected: "2345", contentRange: "bytes 2-5/10"},
+		{name: "OpenEnded", rng: cache.Range{Start: 7, End: -1}, expected: "789", contentRange: "bytes 7-9/10"},
+		{name: "Suffix", rng: cache.Range{Start: -3, End: -1}, expected: "789", contentRange: "bytes 7-9/10"},
+		{name: "PastEnd", rng: cache.Range{Start: 8, End: 100}, expected: "89", contentRange: "bytes 8-9/10"},
+		{name: "Unsatisfiable", rng: cache.Range{Start: 10, End: -1}, unsatisfiable: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, headers, err := ro.OpenRange(ctx, key, tt.rng)
+			if tt.unsatisfiable {
+				rangeErr, ok := errors.AsType[*cache.RangeNotSatisfiableError](err)
+				assert.True(t, ok, "expected RangeNotSatisfiableError, got %v", err)
+				assert.Equal(t, int64(10), rangeErr.Size)
+				return
+			}
+			assert.NoError(t, err)
+			defer reader.Close()
+			data, err := io.ReadAll(reader)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, string(data))
+			assert.Equal(t, tt.contentRange, headers.Get("Content-Range"))
+			assert.Equal(t, strconv.Itoa(len(tt.expected)), headers.Get("Content-Length"))
+		})
+	}
+
+	_, _, err := ro.OpenRange(ctx, cache.NewKey("missing"), cache.Range{Start: 0, End: -1})
+	assert.IsError(t, err, os.ErrNotExist)
+}
+
+func testHas(t *testing.T, c cache.Cache) {
+	defer c.Close()
+	require(t, c, Has)
+	ctx := t.Context()
+
+	present := cache.NewKey("present")
+	writeObject(t, c, present, []byte("data"))
+	ok, err := c.Has(ctx, present)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = c.Has(ctx, cache.NewKey("absent"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	expired := cache.NewKey("expired")
+	writer, err := c.Create(ctx, expired, nil, 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	time.Sleep(100 * time.Millisecond)
+	ok, err = c.Has(ctx, expired)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func testCreateExclusive(t *testing.T, c cache.Cache) {
+	defer c.Close()
+	require(t, c, CreateExclusive)
+	ec := c.(cache.ExclusiveCreator) //nolint:forcetypeassert
+	ctx := t.Context()
+
+	// createExclusive returns the error from either Create or Close.
+	createExclusive := func(key cache.Key, data string) error {
+		writer, err := ec.CreateExclusive(ctx, key, nil, time.Hour)
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write([]byte(data))
+		return errors.Join(err, writer.Close())
+	}
+	readObject := func(key cache.Key) string {
+		reader, _, err := c.Open(ctx, key)
+		assert.NoError(t, err)
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		return string(data)
+	}
+
+	key := cache.NewKey("exclusive")
+	assert.NoError(t, createExclusive(key, "first"))
+	assert.IsError(t, createExclusive(key, "second"), cache.ErrExists)
+	assert.Equal(t, "first", readObject(key))
+
+	// Overwriting is unaffected.
+	writeObject(t, c, key, []byte("overwritten"))
+	assert.Equal(t, "overwritten", readObject(key))
+
+	// Of two concurrent writers, only the first to close commits.
+	racy := cache.NewKey("racy")
+	first, err := ec.CreateExclusive(ctx, racy, nil, time.Hour)
+	assert.NoError(t, err)
+	second, err := ec.CreateExclusive(ctx, racy, nil, time.Hour)
+	assert.NoError(t, err)
+	_, err = first.Write([]byte("first"))
+	assert.NoError(t, err)
+	_, err = second.Write([]byte("second"))
+	assert.NoError(t, err)
+	assert.NoError(t, first.Close())
+	assert.IsError(t, second.Close(), cache.ErrExists)
+	assert.Equal(t, "first", readObject(racy))
+
+	// Expired objects do not prevent creation.
+	expired := cache.NewKey("expired-exclusive")
+	writer, err := c.Create(ctx, expired, nil, 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, createExclusive(expired, "fresh"))
+	assert.Equal(t, "fresh", readObject(expired))
+}
+
+func testCreateIfAbsent(t *testing.T, c cache.Cache) {
+	defer c.Close()
+	ctx := t.Context()
+
+	// createIfAbsent returns whether the object was created, and the error from either CreateIfAbsent or Close.
+	createIfAbsent := func(key cache.Key, data string) (bool, error) {
+		writer, created, err := cache.CreateIfAbsent(ctx, c, key, nil, time.Hour)
+		if err != nil {
+			return false, err
+		}
+		_, err = writer.Write([]byte(data))
+		return created, errors.Join(err, writer.Close())
+	}
+	readObject := func(key cache.Key) string {
+		reader, _, err := c.Open(ctx, key)
+		assert.NoError(t, err)
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		return string(data)
+	}
+
+	key := cache.NewKey("if-absent")
+	created, err := createIfAbsent(key, "first")
+	assert.NoError(t, err)
+	assert.True(t, created)
+	created, err = createIfAbsent(key, "second")
+	assert.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, "first", readObject(key))
+
+	// Expired objects are replaced.
+	expired := cache.NewKey("expired-if-absent")
+	writer, err := c.Create(ctx, expired, nil, 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	time.Sleep(100 * time.Millisecond)
+	created, err = createIfAbsent(expired, "fresh")
+	assert.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "fresh", readObject(expired))
+}
+
+func testStats(t *testing.T, c cache.Cache) {
+	defer c.Close()
+	require(t, c, Stats)
+	ctx := t.Context()
+
+	before, err := c.Stats(ctx)
+	if errors.Is(err, cache.ErrStatsUnavailable) {
+		t.Skipf("%s does not provide statistics", c.String())
+	}
+	assert.NoError(t, err)
+
+	writeObject(t, c, cache.NewKey("stats"), make([]byte, 1000))
+	after, err := c.Stats(ctx)
+	assert.NoError(t, err)
+	// Tiered caches store the object in each tier, and implementations may add per-object overhead.
+	assert.True(t, after.Objects > before.Objects, "expected object count to grow, was %d and is %d", before.Objects, after.Objects)
+	assert.True(t, after.Size-before.Size >= 1000, "expected size to grow by at least 1000 bytes, grew by %d", after.Size-before.Size)
+	assert.True(t, after.Capacity == 0 || after.Capacity >= after.Size, "expected capacity to be unlimited or to fit the objects")
+}
+
+// SlidingExpiration tests that reading an entry created with ttl keeps it alive past its original expiry, for caches
+// configured to extend expiry on read.
+func SlidingExpiration(t *testing.T, c cache.Cache, ttl time.Duration) {
+	t.Helper()
+	defer c.Close()
+	ctx := t.Context()
+
+	key := cache.NewKey("sliding-key")
+	writer, err := c.Create(ctx, key, nil, ttl)
+	assert.NoError(t, err)
+	_, err = writer.Write([]byte("test data"))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	// Each read is past the point at which the entry would have expired had the previous read not extended it.
+	for range 2 {
+		time.Sleep(ttl * 6 / 10)
+		reader, _, err := c.Open(ctx, key)
+		assert.NoError(t, err)
+		data, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.NoError(t, reader.Close())
+		assert.Equal(t, "test data", string(data))
+	}
+}
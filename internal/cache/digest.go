@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/alecthomas/errors"
+)
+
+// DigestHeader is the header of a stored object holding the hex-encoded SHA-256 of its body.
+//
+// [Memory], [Disk] and [S3] record it as each object is written, replacing any value passed to Create, so that
+// corruption of the stored body can be detected. They likewise record the size of the body in Content-Length, so
+// that it is known for objects whose size was not known when they were created.
+const DigestHeader = "X-Cachew-Sha256"
+
+// ErrCorrupt is returned when the body of an object does not match its [DigestHeader].
+var ErrCorrupt = errors.New("object is corrupt")
+
+// recordBody sets the headers describing a completely written body.
+func recordBody(headers http.Header, digest []byte, size int64) {
+	headers.Set(DigestHeader, hex.EncodeToString(digest))
+	headers.Set("Content-Length", strconv.FormatInt(size, 10))
+}
+
+// verifyingReader verifies a body against its recorded digest as it is read, returning [ErrCorrupt] in place of
+// [io.EOF] if it does not match, so that corruption is detected without reading the body twice.
+type verifyingReader struct {
+	io.ReadCloser
+	hash      hash.Hash
+	expected  string
+	onCorrupt func(error)
+}
+
+// newVerifyingReader returns r wrapped to verify it against the digest recorded in headers, calling onCorrupt once if
+// it does not match. Bodies without a recorded digest are returned unwrapped.
+func newVerifyingReader(r io.ReadCloser, headers http.Header, onCorrupt func(error)) io.ReadCloser {
+	expected := headers.Get(DigestHeader)
+	if expected == "" {
+		return r
+	}
+	return &verifyingReader{ReadCloser: r, hash: sha256.New(), expected: expected, onCorrupt: onCorrupt}
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	_, _ = v.hash.Write(p[:n])
+	if err != io.EOF { //nolint:errorlint // io.EOF is returned unwrapped by convention.
+		return n, err //nolint:wrapcheck
+	}
+	if actual := hex.EncodeToString(v.hash.Sum(nil)); actual != v.expected {
+		err := errors.Errorf("%w: SHA-256 is %s, expected %s", ErrCorrupt, actual, v.expected)
+		if v.onCorrupt != nil {
+			v.onCorrupt(err)
+			v.onCorrupt = nil
+		}
+		return n, err
+	}
+	return n, io.EOF
+}
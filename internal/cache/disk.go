@@ -1,21 +1,33 @@
 package cache
 
 import (
+	"container/heap"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
 	"io"
 	"io/fs"
+	"iter"
 	"log/slog"
 	"maps"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
-	"sort"
+	"slices"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/alecthomas/errors"
 	"github.com/alecthomas/kong"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
 
+	"github.com/block/cachew/internal/events"
 	"github.com/block/cachew/internal/logging"
 )
 
@@ -34,6 +46,46 @@ type DiskConfig struct {
 	LimitMB       int           `hcl:"limit-mb,optional" help:"Maximum size of the disk cache in megabytes (defaults to 10GB)." default:"10240"`
 	MaxTTL        time.Duration `hcl:"max-ttl,optional" help:"Maximum time-to-live for entries in the disk cache (defaults to 1 hour)." default:"1h"`
 	EvictInterval time.Duration `hcl:"evict-interval,optional" help:"Interval at which to check files for eviction (defaults to 1 minute)." default:"1m"`
+	StaleGrace    time.Duration `hcl:"stale-grace,optional" help:"How long to retain expired entries so they can be served stale if upstream fails (defaults to 0, disabled)." default:"0s"`
+	EvictThrottle time.Duration `hcl:"evict-throttle,optional" help:"Pause after examining each batch of 1000 entries during eviction, to limit IO pressure (defaults to 0, no pause)." default:"0s"`
+	// ReconcileInterval periodically re-measures the cache directory so that files added or removed outside cachew
+	// are reflected in its size accounting.
+	ReconcileInterval time.Duration `hcl:"reconcile-interval,optional" help:"Interval at which to re-measure the cache directory to correct for files modified externally (defaults to 0, disabled)." default:"0s"`
+	// VerifyOnRead detects silent corruption, eg. bit rot or truncation, as each entry is read rather than before it is
+	// served, so that entries are only read once. Corruption is reported at the end of the body, after any headers
+	// have been sent, so the read that detects it fails and only later reads are fetched again. Partial reads are not
+	// verified.
+	VerifyOnRead bool `hcl:"verify-on-read,optional" help:"Verify entries against their SHA-256 as they are read, failing the read at the end of a body that does not match and evicting the entry (defaults to false)."`
+	// SlidingExpiration extends an entry's expiry by its TTL whenever it is opened, so that frequently read entries
+	// are retained. By default opening an entry only caps its remaining lifetime at MaxTTL.
+	SlidingExpiration bool `hcl:"sliding-expiration,optional" help:"Extend an entry's expiry by its TTL each time it is opened (defaults to false)."`
+}
+
+// Validate the configuration. Zero values are replaced with defaults by [NewDisk].
+func (c *DiskConfig) Validate() error {
+	var errs []error
+	if c.Root == "" {
+		errs = append(errs, errors.New("root is required"))
+	}
+	if c.LimitMB < 0 {
+		errs = append(errs, errors.New("limit-mb must not be negative"))
+	}
+	if c.MaxTTL < 0 {
+		errs = append(errs, errors.New("max-ttl must not be negative"))
+	}
+	if c.EvictInterval < 0 {
+		errs = append(errs, errors.New("evict-interval must not be negative"))
+	}
+	if c.StaleGrace < 0 {
+		errs = append(errs, errors.New("stale-grace must not be negative"))
+	}
+	if c.EvictThrottle < 0 {
+		errs = append(errs, errors.New("evict-throttle must not be negative"))
+	}
+	if c.ReconcileInterval < 0 {
+		errs = append(errs, errors.New("reconcile-interval must not be negative"))
+	}
+	return errors.Join(errs...)
 }
 
 type Disk struct {
@@ -41,12 +93,29 @@ type Disk struct {
 	config       DiskConfig
 	db           *diskMetaDB
 	size         atomic.Int64
+	commitMu     sync.Mutex // Serialises replacing and removing files so size accounting stays consistent.
+	evictMu      sync.Mutex // Serialises eviction passes.
 	runEviction  chan struct{}
 	stop         context.CancelFunc
 	evictionDone chan struct{}
+	diskFull     metric.Int64Counter
+	writeFile    func(f *os.File, p []byte) (int, error)
+	// While reconcile measures the cache one shard directory at a time, changes to the size of shards it has already
+	// measured are accumulated in measuredDelta. See [Disk.addSize].
+	reconciling   atomic.Bool
+	measured      [256]atomic.Bool
+	measuredDelta atomic.Int64
 }
 
-var _ Cache = (*Disk)(nil)
+var (
+	_ Cache            = (*Disk)(nil)
+	_ StaleOpener      = (*Disk)(nil)
+	_ RangeOpener      = (*Disk)(nil)
+	_ Purger           = (*Disk)(nil)
+	_ ExclusiveCreator = (*Disk)(nil)
+	_ TagLister        = (*Disk)(nil)
+	_ Lister           = (*Disk)(nil)
+)
 
 // NewDisk creates a new disk-based cache instance.
 //
@@ -55,6 +124,15 @@ var _ Cache = (*Disk)(nil)
 // This [Cache] implementation stores cache entries under a directory. If total usage exceeds the limit, entries are
 // evicted based on their last access time. TTLs are stored in a bbolt database. If an entry exceeds its
 // TTL or the default, it is evicted. The implementation is safe for concurrent use within a single Go process.
+//
+// Each writer streams to its own temporary file, so concurrent creates of the same key never corrupt each other.
+// The file is atomically renamed into place on Close, and the last writer to be closed wins.
+//
+// If the filesystem runs out of space while writing, an immediate eviction pass frees space below the current usage
+// and the write is retried once.
+//
+// The SHA-256 of each entry is recorded in its [DigestHeader]. If VerifyOnRead is set, entries are verified against it
+// as they are read, and corrupt entries are evicted once detected.
 func NewDisk(ctx context.Context, config DiskConfig) (*Disk, error) {
 	logging.FromContext(ctx).InfoContext(ctx, "Constructing disk cache", "limit-mb", config.LimitMB, "evict-interval", config.EvictInterval, "root", config.Root, "max-ttl", config.MaxTTL)
 	// Validate config
@@ -80,27 +158,29 @@ func NewDisk(ctx context.Context, config DiskConfig) (*Disk, error) {
 		return nil, errors.Errorf("failed to create TTL storage: %w", err)
 	}
 
-	// Determine the initial size.
-	var size int64
-	err = filepath.Walk(config.Root, func(_ string, info fs.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
-		// Skip metadata.db file
-		if info.Name() == "metadata.db" {
-			return nil
+	logger := logging.FromContext(ctx)
+
+	reset, err := db.resetKeyLength(KeyLength())
+	if err != nil {
+		return nil, errors.Join(errors.Errorf("failed to record key length: %w", err), db.close())
+	}
+	if reset {
+		logger.WarnContext(ctx, "Key length changed, emptying disk cache", "key-length", KeyLength())
+		if err := removeEntries(config.Root); err != nil {
+			return nil, errors.Join(err, db.close())
 		}
-		size += info.Size()
-		return nil
-	})
+	}
+
+	size, err := measureDisk(config.Root)
 	if err != nil {
-		return nil, errors.Errorf("failed to walk cache root: %w", err)
+		return nil, err
 	}
 
-	logger := logging.FromContext(ctx)
+	diskFull, err := otel.Meter("github.com/block/cachew/internal/cache").Int64Counter("cachew.cache.disk.full",
+		metric.WithDescription("Number of times the disk cache filesystem ran out of space"))
+	if err != nil {
+		return nil, errors.Errorf("failed to create metric: %w", err)
+	}
 
 	ctx, stop := context.WithCancel(ctx)
 
@@ -111,6 +191,8 @@ func NewDisk(ctx context.Context, config DiskConfig) (*Disk, error) {
 		runEviction:  make(chan struct{}),
 		stop:         stop,
 		evictionDone: make(chan struct{}),
+		diskFull:     diskFull,
+		writeFile:    (*os.File).Write,
 	}
 	disk.size.Store(size)
 
@@ -119,6 +201,44 @@ func NewDisk(ctx context.Context, config DiskConfig) (*Disk, error) {
 	return disk, nil
 }
 
+// removeEntries removes the directories holding entries under root.
+func removeEntries(root string) error {
+	dirs, err := os.ReadDir(root)
+	if err != nil {
+		return errors.Errorf("failed to read cache root: %w", err)
+	}
+	for _, dir := range dirs {
+		// Entries are stored in directories named by the first two hex digits of their key.
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(root, dir.Name())); err != nil {
+			return errors.Errorf("failed to remove entries: %w", err)
+		}
+	}
+	return nil
+}
+
+// measureDisk returns the total size of the entries under root, excluding the metadata database and in-progress
+// writes.
+func measureDisk(root string) (int64, error) {
+	var size int64
+	err := filepath.Walk(root, func(_ string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Name() == "metadata.db" || strings.HasPrefix(info.Name(), ".tmp-") {
+			return nil
+		}
+		size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Errorf("failed to walk cache root: %w", err)
+	}
+	return size, nil
+}
+
 func (d *Disk) String() string { return "disk:" + d.config.Root }
 
 func (d *Disk) Close() error {
@@ -147,6 +267,15 @@ func (d *Disk) Stats(_ context.Context) (Stats, error) {
 }
 
 func (d *Disk) Create(ctx context.Context, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	return d.create(ctx, key, headers, ttl, false)
+}
+
+// CreateExclusive creates an entry that is only committed if no unexpired entry with the same key exists.
+func (d *Disk) CreateExclusive(ctx context.Context, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	return d.create(ctx, key, headers, ttl, true)
+}
+
+func (d *Disk) create(ctx context.Context, key Key, headers http.Header, ttl time.Duration, exclusive bool) (io.WriteCloser, error) {
 	if ttl > d.config.MaxTTL || ttl == 0 {
 		ttl = d.config.MaxTTL
 	}
@@ -181,11 +310,39 @@ func (d *Disk) Create(ctx context.Context, key Key, headers http.Header, ttl tim
 		path:      fullPath,
 		tempPath:  f.Name(),
 		expiresAt: expiresAt,
+		ttl:       ttl,
 		headers:   clonedHeaders,
+		exclusive: exclusive,
 		ctx:       ctx,
+		digest:    sha256.New(),
 	}, nil
 }
 
+// Touch resets the expiry of an entry. Only the metadata database is updated.
+func (d *Disk) Touch(ctx context.Context, key Key, ttl time.Duration) error {
+	if ttl > d.config.MaxTTL || ttl == 0 {
+		ttl = d.config.MaxTTL
+	}
+
+	d.commitMu.Lock()
+	defer d.commitMu.Unlock()
+
+	if _, err := os.Stat(filepath.Join(d.config.Root, d.keyToPath(key))); err != nil {
+		return errors.Errorf("failed to stat file: %w", err)
+	}
+	expiresAt, err := d.db.getTTL(key)
+	if err != nil {
+		return errors.Errorf("failed to get TTL: %w", err)
+	}
+	if time.Now().After(expiresAt) {
+		return errors.WithStack(fs.ErrNotExist)
+	}
+	if err := d.db.setTTL(key, time.Now().Add(ttl), ttl); err != nil {
+		return errors.Errorf("failed to update expiration time: %w", err)
+	}
+	return nil
+}
+
 func (d *Disk) Delete(_ context.Context, key Key) error {
 	path := d.keyToPath(key)
 	fullPath := filepath.Join(d.config.Root, path)
@@ -197,6 +354,9 @@ func (d *Disk) Delete(_ context.Context, key Key) error {
 		expired = true
 	}
 
+	d.commitMu.Lock()
+	defer d.commitMu.Unlock()
+
 	info, err := os.Stat(fullPath)
 	if err != nil {
 		return errors.Errorf("failed to stat file: %w", err)
@@ -211,7 +371,7 @@ func (d *Disk) Delete(_ context.Context, key Key) error {
 		return errors.Errorf("failed to delete TTL metadata: %w", err)
 	}
 
-	d.size.Add(-info.Size())
+	d.addSize(key, -info.Size())
 
 	if expired {
 		return errors.Errorf("%s: %w", path, fs.ErrNotExist)
@@ -233,7 +393,7 @@ func (d *Disk) Stat(ctx context.Context, key Key) (http.Header, error) {
 	}
 
 	if time.Now().After(expiresAt) {
-		return nil, errors.Join(fs.ErrNotExist, d.Delete(ctx, key))
+		return nil, d.expired(ctx, key, expiresAt)
 	}
 
 	headers, err := d.db.getHeaders(key)
@@ -244,7 +404,83 @@ func (d *Disk) Stat(ctx context.Context, key Key) (http.Header, error) {
 	return headers, nil
 }
 
+// ExpiresAt returns when an entry expires, without extending its expiry.
+func (d *Disk) ExpiresAt(_ context.Context, key Key) (time.Time, error) {
+	expiresAt, err := d.db.getTTL(key)
+	if err != nil {
+		return time.Time{}, errors.Errorf("failed to get TTL: %w", err)
+	}
+	if time.Now().After(expiresAt) {
+		return time.Time{}, errors.WithStack(os.ErrNotExist)
+	}
+	return expiresAt, nil
+}
+
+// Has checks the entry's expiry without opening it or extending its expiry.
+func (d *Disk) Has(_ context.Context, key Key) (bool, error) {
+	expiresAt, err := d.db.getTTL(key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Errorf("failed to get TTL: %w", err)
+	}
+	if time.Now().After(expiresAt) {
+		return false, nil
+	}
+
+	if _, err := os.Stat(filepath.Join(d.config.Root, d.keyToPath(key))); errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Errorf("failed to stat file: %w", err)
+	}
+	return true, nil
+}
+
+// expired returns fs.ErrNotExist for an expired entry, deleting it if it is also beyond the stale grace period.
+func (d *Disk) expired(ctx context.Context, key Key, expiresAt time.Time) error {
+	if time.Now().Before(expiresAt.Add(d.config.StaleGrace)) {
+		return errors.WithStack(fs.ErrNotExist)
+	}
+	return errors.Join(fs.ErrNotExist, d.Delete(ctx, key))
+}
+
 func (d *Disk) Open(ctx context.Context, key Key) (io.ReadCloser, http.Header, error) {
+	f, headers, err := d.open(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return d.verify(ctx, key, f, headers), headers, nil
+}
+
+// OpenRange opens part of an entry, reading only the selected bytes of its file.
+//
+// Like Open, this extends the entry's expiry and marks it as recently used.
+func (d *Disk) OpenRange(ctx context.Context, key Key, rng Range) (io.ReadCloser, http.Header, error) {
+	f, headers, err := d.open(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, errors.Join(errors.Errorf("failed to stat file: %w", err), f.Close())
+	}
+	offset, length, err := rng.Resolve(info.Size())
+	if err != nil {
+		return nil, nil, errors.Join(err, f.Close())
+	}
+	headers = headers.Clone()
+	SetRangeHeaders(headers, offset, length, info.Size())
+	return &sectionReader{SectionReader: io.NewSectionReader(f, offset, length), Closer: f}, headers, nil
+}
+
+// sectionReader reads part of a file, closing the file when it is closed.
+type sectionReader struct {
+	*io.SectionReader
+	io.Closer
+}
+
+// open an unexpired entry, extending its expiry.
+func (d *Disk) open(ctx context.Context, key Key) (*os.File, http.Header, error) {
 	path := d.keyToPath(key)
 	fullPath := filepath.Join(d.config.Root, path)
 
@@ -260,7 +496,7 @@ func (d *Disk) Open(ctx context.Context, key Key) (io.ReadCloser, http.Header, e
 
 	now := time.Now()
 	if now.After(expiresAt) {
-		return nil, nil, errors.Join(fs.ErrNotExist, f.Close(), d.Delete(ctx, key))
+		return nil, nil, errors.Join(f.Close(), d.expired(ctx, key, expiresAt))
 	}
 
 	headers, err := d.db.getHeaders(key)
@@ -268,17 +504,76 @@ func (d *Disk) Open(ctx context.Context, key Key) (io.ReadCloser, http.Header, e
 		return nil, nil, errors.Join(errors.Errorf("failed to get headers: %w", err), f.Close())
 	}
 
-	// Reset expiration time to implement LRU
-	ttl := min(expiresAt.Sub(now), d.config.MaxTTL)
-	newExpiresAt := now.Add(ttl)
-
-	if err := d.db.setTTL(key, newExpiresAt); err != nil {
+	newExpiresAt, err := d.slideExpiry(key, expiresAt, now)
+	if err != nil {
+		return nil, nil, errors.Join(err, f.Close())
+	}
+	if err := d.db.setTTL(key, newExpiresAt, 0); err != nil {
 		return nil, nil, errors.Join(errors.Errorf("failed to update expiration time: %w", err), f.Close())
 	}
 
 	return f, headers, nil
 }
 
+// slideExpiry returns the expiry of an entry that is being opened.
+//
+// With SlidingExpiration it is reset to its lifetime from now, otherwise the remaining lifetime is only capped at
+// MaxTTL. Entries written before lifetimes were recorded do not slide.
+func (d *Disk) slideExpiry(key Key, expiresAt, now time.Time) (time.Time, error) {
+	if d.config.SlidingExpiration {
+		lifetime, err := d.db.getLifetime(key)
+		if err != nil {
+			return time.Time{}, errors.Errorf("failed to get lifetime: %w", err)
+		}
+		if lifetime > 0 {
+			return now.Add(min(lifetime, d.config.MaxTTL)), nil
+		}
+	}
+	return now.Add(min(expiresAt.Sub(now), d.config.MaxTTL)), nil
+}
+
+// OpenStale opens an entry even if it has expired, provided it expired less than grace ago.
+//
+// Entries are only retained past their expiry for up to the configured StaleGrace, so grace is effectively capped by
+// it. Unlike Open, this does not extend the entry's expiry.
+func (d *Disk) OpenStale(ctx context.Context, key Key, grace time.Duration) (io.ReadCloser, http.Header, error) {
+	expiresAt, err := d.db.getTTL(key)
+	if err != nil {
+		return nil, nil, errors.Errorf("failed to get TTL: %w", err)
+	}
+	if time.Now().After(expiresAt.Add(grace)) {
+		return nil, nil, errors.WithStack(fs.ErrNotExist)
+	}
+	if time.Now().Before(expiresAt) {
+		return d.Open(ctx, key)
+	}
+
+	f, err := os.Open(filepath.Join(d.config.Root, d.keyToPath(key)))
+	if err != nil {
+		return nil, nil, errors.Errorf("failed to open file: %w", err)
+	}
+	headers, err := d.db.getHeaders(key)
+	if err != nil {
+		return nil, nil, errors.Join(errors.Errorf("failed to get headers: %w", err), f.Close())
+	}
+	return d.verify(ctx, key, f, headers), headers, nil
+}
+
+// verify wraps an opened entry to be verified against its digest as it is read if VerifyOnRead is enabled.
+//
+// A corrupt entry is deleted once the end of its body is reached, so that it is fetched again by later reads.
+func (d *Disk) verify(ctx context.Context, key Key, f *os.File, headers http.Header) io.ReadCloser {
+	if !d.config.VerifyOnRead {
+		return f
+	}
+	return newVerifyingReader(f, headers, func(err error) {
+		d.logger.ErrorContext(ctx, "Evicting corrupt cache entry", slog.String("key", key.String()), slog.String("error", err.Error()))
+		if err := d.Delete(context.WithoutCancel(ctx), key); err != nil && !errors.Is(err, os.ErrNotExist) {
+			d.logger.ErrorContext(ctx, "Failed to evict corrupt cache entry", slog.String("key", key.String()), slog.String("error", err.Error()))
+		}
+	})
+}
+
 func (d *Disk) keyToPath(key Key) string {
 	hexKey := key.String()
 	// Use first two hex digits as directory, full hex as filename
@@ -291,36 +586,357 @@ func (d *Disk) evictionLoop(ctx context.Context) {
 	ticker := time.NewTicker(d.config.EvictInterval)
 	defer ticker.Stop()
 
+	var reconcile <-chan time.Time
+	if d.config.ReconcileInterval > 0 {
+		reconcileTicker := time.NewTicker(d.config.ReconcileInterval)
+		defer reconcileTicker.Stop()
+		reconcile = reconcileTicker.C
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-reconcile:
+			if err := d.reconcile(ctx); err != nil {
+				d.logger.ErrorContext(ctx, "reconciliation failed", "error", err)
+			}
 		case <-ticker.C:
-			if err := d.evict(); err != nil {
+			if err := d.evict(ctx); err != nil {
 				d.logger.ErrorContext(ctx, "eviction failed", "error", err)
 			}
 		case <-d.runEviction:
-			if err := d.evict(); err != nil {
+			if err := d.evict(ctx); err != nil {
 				d.logger.ErrorContext(ctx, "eviction failed", "error", err)
 			}
 		}
 	}
 }
 
-func (d *Disk) evict() error {
-	type fileInfo struct {
-		key        Key
-		path       string
-		size       int64
-		expiresAt  time.Time
-		accessedAt time.Time
+// reconcile corrects the size counter and metadata for files added or removed outside cachew.
+//
+// Metadata for entries whose files no longer exist is removed, and the size is re-measured from the filesystem. The
+// cache directory is walked without blocking commits and eviction, which are only blocked while each batch of missing
+// entries is removed and while each shard directory is measured.
+func (d *Disk) reconcile(ctx context.Context) error {
+	var missing []Key
+	removed := 0
+	err := d.db.walk(func(key Key, _ time.Time) error {
+		if _, err := os.Stat(filepath.Join(d.config.Root, d.keyToPath(key))); errors.Is(err, fs.ErrNotExist) {
+			missing = append(missing, key)
+		}
+		if len(missing) < walkBatchSize {
+			return nil
+		}
+		n, err := d.removeMissing(missing)
+		removed += n
+		missing = missing[:0]
+		return err
+	})
+	if err != nil {
+		return errors.Errorf("failed to walk TTL entries: %w", err)
+	}
+	n, err := d.removeMissing(missing)
+	removed += n
+	if err != nil {
+		return err
+	}
+
+	previous, size, err := d.remeasure()
+	if err != nil {
+		return err
+	}
+	if previous != size || removed > 0 {
+		d.logger.WarnContext(ctx, "Corrected disk cache accounting for external modifications",
+			"root", d.config.Root, "previous-size", previous, "size", size, "missing-entries", removed)
+	}
+	return nil
+}
+
+// lockAll blocks commits and eviction, returning a function that unblocks them.
+func (d *Disk) lockAll() func() {
+	// Writers may trigger eviction while holding commitMu, so it must be acquired first.
+	d.commitMu.Lock()
+	d.evictMu.Lock()
+	return func() {
+		d.evictMu.Unlock()
+		d.commitMu.Unlock()
+	}
+}
+
+// removeMissing removes the metadata for those keys whose files still do not exist, as they may have been written
+// since they were found to be missing, returning the number removed.
+func (d *Disk) removeMissing(keys []Key) (int, error) {
+	defer d.lockAll()()
+	var missing []Key
+	for _, key := range keys {
+		if _, err := os.Stat(filepath.Join(d.config.Root, d.keyToPath(key))); errors.Is(err, fs.ErrNotExist) {
+			missing = append(missing, key)
+		}
+	}
+	if err := d.db.deleteAll(missing); err != nil {
+		return 0, errors.Errorf("failed to delete TTL metadata: %w", err)
+	}
+	return len(missing), nil
+}
+
+// remeasure replaces the size counter with the size of the cache directory, returning the previous and new sizes.
+//
+// Shard directories are measured one at a time, each while blocking commits and eviction. Changes to shards that have
+// already been measured are accumulated by [Disk.addSize] and added to the total, while those to shards not yet
+// measured are included when they are.
+func (d *Disk) remeasure() (previous, size int64, err error) {
+	unlock := d.lockAll()
+	d.measuredDelta.Store(0)
+	for i := range d.measured {
+		d.measured[i].Store(false)
+	}
+	d.reconciling.Store(true)
+	unlock()
+	defer d.reconciling.Store(false)
+
+	// Files outside the shard directories are not written by cachew, so need no locking.
+	entries, err := os.ReadDir(d.config.Root)
+	if err != nil {
+		return 0, 0, errors.Errorf("failed to read cache root: %w", err)
+	}
+	for _, entry := range entries {
+		if shard, err := hex.DecodeString(entry.Name()); err == nil && len(shard) == 1 && entry.IsDir() {
+			continue
+		}
+		n, err := measureDisk(filepath.Join(d.config.Root, entry.Name()))
+		if err != nil {
+			return 0, 0, err
+		}
+		size += n
+	}
+	for shard := range d.measured {
+		unlock := d.lockAll()
+		n, err := measureDisk(filepath.Join(d.config.Root, hex.EncodeToString([]byte{byte(shard)})))
+		d.measured[shard].Store(true)
+		unlock()
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return 0, 0, err
+		}
+		size += n
+	}
+
+	defer d.lockAll()()
+	size += d.measuredDelta.Load()
+	return d.size.Swap(size), size, nil
+}
+
+// addSize adjusts the size counter for a change to the size of key's file, and must be called while holding commitMu
+// or evictMu.
+func (d *Disk) addSize(key Key, delta int64) {
+	d.size.Add(delta)
+	if d.reconciling.Load() && d.measured[key[0]].Load() {
+		d.measuredDelta.Add(delta)
+	}
+}
+
+// evict runs a periodic eviction pass, publishing an [events.Evict] event if anything was evicted.
+func (d *Disk) evict(ctx context.Context) error {
+	result, err := d.evictTo(int64(d.config.LimitMB)*1024*1024, time.Time{})
+	if result.Objects > 0 {
+		events.Publish(ctx, events.Evict, slog.String("cache", d.String()), slog.Int64("objects", result.Objects), slog.Int64("bytes", result.Bytes))
+	}
+	return err
+}
+
+// Purge removes entries written more than OlderThan ago, then evicts entries in the same order as size-based
+// eviction until the cache is no larger than TargetBytes.
+//
+// If Tag is set, only entries created with it are removed, using the tag index rather than walking every entry.
+func (d *Disk) Purge(_ context.Context, options PurgeOptions) (PurgeResult, error) {
+	if err := options.validate(); err != nil {
+		return PurgeResult{}, err
+	}
+	var cutoff time.Time
+	if options.OlderThan > 0 {
+		cutoff = time.Now().Add(-options.OlderThan)
+	}
+	if options.Tag != "" {
+		return d.purgeTagged(options.Tag, cutoff)
+	}
+	limitBytes := int64(math.MaxInt64)
+	if options.TargetBytes > 0 {
+		limitBytes = options.TargetBytes
+	}
+	return d.evictTo(limitBytes, cutoff)
+}
+
+// purgeTagged removes the entries created with tag, and written before cutoff if it is set.
+func (d *Disk) purgeTagged(tag string, cutoff time.Time) (PurgeResult, error) {
+	d.evictMu.Lock()
+	defer d.evictMu.Unlock()
+
+	keys, err := d.db.tagged(tag)
+	if err != nil {
+		return PurgeResult{}, errors.Errorf("failed to read tag index: %w", err)
+	}
+	var result PurgeResult
+	var removed []Key
+	for _, key := range keys {
+		path := d.keyToPath(key)
+		fullPath := filepath.Join(d.config.Root, path)
+		info, err := os.Stat(fullPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			removed = append(removed, key)
+			continue
+		} else if err != nil {
+			return result, errors.Errorf("failed to stat file %s: %w", path, err)
+		}
+		if !cutoff.IsZero() && !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(fullPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return result, errors.Errorf("failed to delete tagged file %s: %w", path, err)
+		}
+		removed = append(removed, key)
+		d.addSize(key, -info.Size())
+		result.Objects++
+		result.Bytes += info.Size()
+	}
+	if err := d.db.deleteAll(removed); err != nil {
+		return result, errors.Errorf("failed to delete TTL metadata: %w", err)
+	}
+	return result, nil
+}
+
+// ListTagged returns the unexpired entries created with tag.
+func (d *Disk) ListTagged(_ context.Context, tag string) ([]ObjectInfo, error) {
+	keys, err := d.db.tagged(tag)
+	if err != nil {
+		return nil, errors.Errorf("failed to read tag index: %w", err)
+	}
+	now := time.Now()
+	var objects []ObjectInfo
+	for _, key := range keys {
+		expiresAt, err := d.db.getTTL(key)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, errors.Errorf("failed to get TTL: %w", err)
+		}
+		if now.After(expiresAt) {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(d.config.Root, d.keyToPath(key)))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, errors.Errorf("failed to stat file: %w", err)
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size()})
 	}
+	return objects, nil
+}
+
+// ListKeys returns the unexpired entries whose keys start with prefix, by walking the cache directory.
+func (d *Disk) ListKeys(_ context.Context, prefix string) iter.Seq2[ObjectInfo, error] {
+	return func(yield func(ObjectInfo, error) bool) {
+		root := d.config.Root
+		if len(prefix) >= 2 && isKeyPrefix(prefix) {
+			// Entries are stored in directories named by the first two hex digits of their keys.
+			root = filepath.Join(root, prefix[:2])
+		}
+		now := time.Now()
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if path == root && errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return errors.WithStack(err)
+			}
+			if entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) {
+				return nil
+			}
+			key, err := ParseKey(entry.Name())
+			if err != nil || filepath.Join(d.config.Root, d.keyToPath(key)) != path {
+				// Not an entry, such as the metadata database or a partial write.
+				return nil
+			}
+			object, ok, err := d.listEntry(key, entry, now)
+			if err != nil {
+				return err
+			}
+			if ok && !yield(object, nil) {
+				return fs.SkipAll
+			}
+			return nil
+		})
+		if err != nil {
+			yield(ObjectInfo{}, errors.Errorf("failed to list entries: %w", err))
+		}
+	}
+}
+
+// listEntry describes the entry for key, returning false if it has expired or was removed while listing.
+func (d *Disk) listEntry(key Key, entry fs.DirEntry, now time.Time) (ObjectInfo, bool, error) {
+	expiresAt, err := d.db.getTTL(key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ObjectInfo{}, false, nil
+	} else if err != nil {
+		return ObjectInfo{}, false, errors.Errorf("failed to get TTL: %w", err)
+	}
+	if now.After(expiresAt) {
+		return ObjectInfo{}, false, nil
+	}
+	headers, err := d.db.getHeaders(key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ObjectInfo{}, false, nil
+	} else if err != nil {
+		return ObjectInfo{}, false, errors.Errorf("failed to get headers: %w", err)
+	}
+	info, err := entry.Info()
+	if errors.Is(err, fs.ErrNotExist) {
+		return ObjectInfo{}, false, nil
+	} else if err != nil {
+		return ObjectInfo{}, false, errors.Errorf("failed to stat file: %w", err)
+	}
+	return ObjectInfo{Key: key, Size: info.Size(), Headers: headers, ExpiresAt: expiresAt}, true, nil
+}
+
+// reclaimSpace runs an immediate eviction pass after the filesystem has run out of space.
+//
+// As the filesystem may be full even though the cache is within its limit, entries are evicted until usage is 10%
+// below its current level. Returns true if any space was freed.
+func (d *Disk) reclaimSpace(ctx context.Context) bool {
+	d.diskFull.Add(ctx, 1)
+	before := d.size.Load()
+	target := min(int64(d.config.LimitMB)*1024*1024, before-before/10)
+	if _, err := d.evictTo(target, time.Time{}); err != nil {
+		d.logger.ErrorContext(ctx, "Eviction after running out of disk space failed", "error", err)
+		return false
+	}
+	freed := before - d.size.Load()
+	d.logger.WarnContext(ctx, "Disk cache filesystem is full, evicted entries to free space", "root", d.config.Root, "freed", freed)
+	return freed > 0
+}
+
+// evictTo removes expired entries and those written before cutoff, if set, then the least recently written entries
+// until the cache is no larger than limitBytes.
+//
+// Rather than collecting every entry, only the oldest entries needed to bring the cache under the limit are retained
+// while walking, so memory use is proportional to the number of entries evicted rather than the size of the cache.
+func (d *Disk) evictTo(limitBytes int64, cutoff time.Time) (PurgeResult, error) {
+	d.evictMu.Lock()
+	defer d.evictMu.Unlock()
 
-	var remainingFiles []fileInfo
 	var expiredKeys []Key
+	var result PurgeResult
+	// Expired entries removed during the walk only reduce this, so candidates may cover more than is needed.
+	candidates := evictionHeap{needed: d.size.Load() - limitBytes}
 	now := time.Now()
+	examined := 0
 
 	err := d.db.walk(func(key Key, expiresAt time.Time) error {
+		examined++
+		if d.config.EvictThrottle > 0 && examined%walkBatchSize == 0 {
+			time.Sleep(d.config.EvictThrottle)
+		}
+
 		path := d.keyToPath(key)
 		fullPath := filepath.Join(d.config.Root, path)
 
@@ -332,60 +948,116 @@ func (d *Disk) evict() error {
 			return nil
 		}
 
-		if now.After(expiresAt) {
+		if now.After(expiresAt.Add(d.config.StaleGrace)) || info.ModTime().Before(cutoff) {
 			if err := os.Remove(fullPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
 				return errors.Errorf("failed to delete expired file %s: %w", path, err)
 			}
 			expiredKeys = append(expiredKeys, key)
-			d.size.Add(-info.Size())
+			d.addSize(key, -info.Size())
+			result.Objects++
+			result.Bytes += info.Size()
 		} else {
-			remainingFiles = append(remainingFiles, fileInfo{
+			candidates.offer(evictionCandidate{
 				key:        key,
 				path:       path,
 				size:       info.Size(),
-				expiresAt:  expiresAt,
 				accessedAt: info.ModTime(),
 			})
 		}
 		return nil
 	})
 	if err != nil {
-		return errors.Errorf("failed to walk TTL entries: %w", err)
+		return result, errors.Errorf("failed to walk TTL entries: %w", err)
 	}
 
 	if err := d.db.deleteAll(expiredKeys); err != nil {
-		return errors.Errorf("failed to delete TTL metadata: %w", err)
+		return result, errors.Errorf("failed to delete TTL metadata: %w", err)
 	}
 
-	limitBytes := int64(d.config.LimitMB) * 1024 * 1024
 	if d.size.Load() <= limitBytes {
-		return nil
+		return result, nil
 	}
 
-	// Sort by access time (oldest first)
-	sort.Slice(remainingFiles, func(i, j int) bool {
-		return remainingFiles[i].accessedAt.Before(remainingFiles[j].accessedAt)
-	})
-
 	var sizeEvictedKeys []Key
-	for _, f := range remainingFiles {
+	for _, f := range candidates.oldestFirst() {
 		if d.size.Load() <= limitBytes {
 			break
 		}
 
 		fullPath := filepath.Join(d.config.Root, f.path)
 		if err := os.Remove(fullPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
-			return errors.Errorf("failed to delete file during size eviction %s: %w", f.path, err)
+			return result, errors.Errorf("failed to delete file during size eviction %s: %w", f.path, err)
 		}
 		sizeEvictedKeys = append(sizeEvictedKeys, f.key)
-		d.size.Add(-f.size)
+		d.addSize(f.key, -f.size)
+		result.Objects++
+		result.Bytes += f.size
 	}
 
 	if err := d.db.deleteAll(sizeEvictedKeys); err != nil {
-		return errors.Errorf("failed to delete TTL metadata: %w", err)
+		return result, errors.Errorf("failed to delete TTL metadata: %w", err)
 	}
 
-	return nil
+	return result, nil
+}
+
+type evictionCandidate struct {
+	key        Key
+	path       string
+	size       int64
+	accessedAt time.Time
+}
+
+// evictionHeap retains the oldest entries whose combined size covers "needed" bytes.
+//
+// It is a max-heap on access time, so the newest retained entry is at the root and is discarded as soon as the
+// older entries cover "needed" without it.
+type evictionHeap struct {
+	needed  int64
+	entries []evictionCandidate
+	size    int64
+}
+
+var _ heap.Interface = (*evictionHeap)(nil)
+
+func (h *evictionHeap) Len() int { return len(h.entries) }
+
+func (h *evictionHeap) Less(i, j int) bool {
+	return h.entries[i].accessedAt.After(h.entries[j].accessedAt)
+}
+
+func (h *evictionHeap) Swap(i, j int) { h.entries[i], h.entries[j] = h.entries[j], h.entries[i] }
+
+func (h *evictionHeap) Push(x any) {
+	h.entries = append(h.entries, x.(evictionCandidate)) //nolint:forcetypeassert
+}
+
+func (h *evictionHeap) Pop() any {
+	last := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+	return last
+}
+
+// offer an entry for eviction, retaining it only if it is among the oldest entries needed.
+func (h *evictionHeap) offer(c evictionCandidate) {
+	if h.needed <= 0 {
+		return
+	}
+	if h.size >= h.needed && !c.accessedAt.Before(h.entries[0].accessedAt) {
+		return
+	}
+	heap.Push(h, c)
+	h.size += c.size
+	for len(h.entries) > 1 && h.size-h.entries[0].size >= h.needed {
+		h.size -= heap.Pop(h).(evictionCandidate).size //nolint:forcetypeassert
+	}
+}
+
+// oldestFirst returns the retained entries ordered from least to most recently written.
+func (h *evictionHeap) oldestFirst() []evictionCandidate {
+	sorted := slices.Clone(h.entries)
+	slices.SortFunc(sorted, func(a, b evictionCandidate) int { return a.accessedAt.Compare(b.accessedAt) })
+	return sorted
 }
 
 type diskWriter struct {
@@ -395,17 +1067,41 @@ type diskWriter struct {
 	path      string
 	tempPath  string
 	expiresAt time.Time
+	ttl       time.Duration
 	headers   http.Header
 	size      int64
+	exclusive bool // Only commit if no unexpired entry exists.
 	ctx       context.Context
+	reclaimed bool  // Space has already been reclaimed once for this writer.
+	writeErr  error // A failed write means the entry is incomplete and must not be committed.
+	digest    hash.Hash
 }
 
 func (w *diskWriter) Write(p []byte) (int, error) {
-	n, err := w.file.Write(p)
+	n, err := w.disk.writeFile(w.file, p)
+	if w.retryNoSpace(err) {
+		var retried int
+		retried, err = w.disk.writeFile(w.file, p[n:])
+		n += retried
+	}
 	w.size += int64(n)
+	w.digest.Write(p[:n])
+	if err != nil {
+		w.writeErr = err
+	}
 	return n, errors.WithStack(err)
 }
 
+// retryNoSpace returns true if err indicates the filesystem is full and space was reclaimed so the operation can be
+// retried. Space is only reclaimed once per writer.
+func (w *diskWriter) retryNoSpace(err error) bool {
+	if !errors.Is(err, syscall.ENOSPC) || w.reclaimed {
+		return false
+	}
+	w.reclaimed = true
+	return w.disk.reclaimSpace(w.ctx)
+}
+
 func (w *diskWriter) Close() error {
 	if err := w.file.Close(); err != nil {
 		return errors.Errorf("failed to close file: %w", err)
@@ -417,26 +1113,43 @@ func (w *diskWriter) Close() error {
 		return errors.Join(errors.Wrap(err, "create operation cancelled"), os.Remove(w.tempPath))
 	}
 
+	if w.writeErr != nil {
+		return errors.Join(errors.Errorf("not committing incomplete entry: %w", w.writeErr), os.Remove(w.tempPath))
+	}
+
 	// Ensure directory exists (eviction may have removed it)
 	dir := filepath.Dir(w.path)
 	if err := os.MkdirAll(dir, 0750); err != nil {
 		return errors.Errorf("failed to create directory: %w", err)
 	}
 
+	w.disk.commitMu.Lock()
+	defer w.disk.commitMu.Unlock()
+
 	// Check if we're overwriting an existing file and subtract its size
 	if info, err := os.Stat(w.path); err == nil {
-		w.disk.size.Add(-info.Size())
+		if w.exclusive {
+			if expiresAt, err := w.disk.db.getTTL(w.key); err == nil && time.Now().Before(expiresAt) {
+				return errors.Join(errors.WithStack(ErrExists), os.Remove(w.tempPath))
+			}
+		}
+		w.disk.addSize(w.key, -info.Size())
 	}
 
 	if err := os.Rename(w.tempPath, w.path); err != nil {
-		return errors.Errorf("failed to rename temp file: %w", err)
+		return errors.Join(errors.Errorf("failed to rename temp file: %w", err), os.Remove(w.tempPath))
 	}
 
-	if err := w.disk.db.set(w.key, w.expiresAt, w.headers); err != nil {
+	recordBody(w.headers, w.digest.Sum(nil), w.size)
+	err := w.disk.db.set(w.key, w.expiresAt, w.ttl, w.headers)
+	if w.retryNoSpace(err) {
+		err = w.disk.db.set(w.key, w.expiresAt, w.ttl, w.headers)
+	}
+	if err != nil {
 		return errors.Join(errors.Errorf("failed to set metadata: %w", err), os.Remove(w.path))
 	}
 
-	w.disk.size.Add(w.size)
+	w.disk.addSize(w.key, w.size)
 
 	select {
 	case w.disk.runEviction <- struct{}{}:
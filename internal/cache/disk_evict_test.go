@@ -0,0 +1,89 @@
+package cache //nolint:testpackage // white-box testing required to control entry ages and inspect eviction candidates
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/logging"
+)
+
+func TestEvictionHeapRetainsOnlyOldestNeeded(t *testing.T) {
+	base := time.Now()
+	h := evictionHeap{needed: 250}
+	// Offer newest first so that every retained entry is eventually displaced by an older one.
+	for i := 10000; i > 0; i-- {
+		h.offer(evictionCandidate{key: NewKey(fmt.Sprint(i)), size: 100, accessedAt: base.Add(time.Duration(i) * time.Second)})
+		assert.True(t, h.Len() <= 3, "heap grew to %d entries", h.Len())
+	}
+	var ages []time.Duration
+	for _, c := range h.oldestFirst() {
+		ages = append(ages, c.accessedAt.Sub(base))
+	}
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, ages)
+}
+
+func TestDiskEvictsOldestEntries(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	d, err := NewDisk(ctx, DiskConfig{Root: t.TempDir(), MaxTTL: time.Hour, EvictInterval: time.Hour})
+	assert.NoError(t, err)
+	defer d.Close()
+
+	// Write entries in an order unrelated to their age.
+	base := time.Now().Add(-time.Hour)
+	ages := map[string]time.Duration{"c": 3, "a": 1, "e": 5, "b": 2, "d": 4}
+	for name, age := range ages {
+		writeDiskEntry(t, d, name, 1000, base.Add(age*time.Minute))
+	}
+
+	result, err := d.evictTo(2500, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, PurgeResult{Objects: 3, Bytes: 3000}, result)
+	for name := range ages {
+		ok, err := d.Has(ctx, NewKey(name))
+		assert.NoError(t, err)
+		assert.Equal(t, name == "d" || name == "e", ok, "%s", name)
+	}
+}
+
+// BenchmarkDiskEvict measures an eviction pass over a large cache that only needs to evict a single entry. Memory
+// allocated per pass should not grow with the number of entries retained in the cache.
+func BenchmarkDiskEvict(b *testing.B) {
+	_, ctx := logging.Configure(b.Context(), logging.Config{Level: slog.LevelError})
+	d, err := NewDisk(ctx, DiskConfig{Root: b.TempDir(), MaxTTL: time.Hour, EvictInterval: time.Hour})
+	assert.NoError(b, err)
+	defer d.Close()
+
+	const entries = 10000
+	base := time.Now().Add(-time.Hour)
+	for i := range entries {
+		writeDiskEntry(b, d, fmt.Sprint(i), 100, base.Add(time.Duration(i)*time.Millisecond))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := range b.N {
+		b.StopTimer()
+		writeDiskEntry(b, d, fmt.Sprint("new", i), 100, time.Now())
+		b.StartTimer()
+		result, err := d.evictTo(entries*100, time.Time{})
+		assert.NoError(b, err)
+		assert.Equal(b, int64(1), result.Objects)
+	}
+}
+
+func writeDiskEntry(t testing.TB, d *Disk, name string, size int, modTime time.Time) {
+	t.Helper()
+	key := NewKey(name)
+	w, err := d.Create(t.Context(), key, nil, 0)
+	assert.NoError(t, err)
+	_, err = w.Write(make([]byte, size))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.NoError(t, os.Chtimes(filepath.Join(d.config.Root, d.keyToPath(key)), modTime, modTime))
+}
@@ -0,0 +1,82 @@
+package cache //nolint:testpackage // white-box testing required to simulate a full filesystem
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/logging"
+)
+
+func TestDiskReclaimsSpaceWhenFull(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int32
+		expectErr bool
+	}{
+		{name: "RetrySucceeds", failures: 1},
+		{name: "StillFull", failures: 100, expectErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+			d, err := NewDisk(ctx, DiskConfig{Root: t.TempDir(), LimitMB: 1, MaxTTL: time.Hour, EvictInterval: time.Hour})
+			assert.NoError(t, err)
+			defer d.Close()
+
+			data := []byte(strings.Repeat("x", 100*1024))
+			for i := range 5 {
+				w, err := d.Create(ctx, NewKey(string(rune('a'+i))), nil, time.Hour)
+				assert.NoError(t, err)
+				_, err = w.Write(data)
+				assert.NoError(t, err)
+				assert.NoError(t, w.Close())
+			}
+			before, err := d.Stats(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(5), before.Objects)
+
+			var failures atomic.Int32
+			failures.Store(tt.failures)
+			d.writeFile = func(f *os.File, p []byte) (int, error) {
+				if failures.Add(-1) >= 0 {
+					return 0, syscall.ENOSPC
+				}
+				return f.Write(p)
+			}
+
+			key := NewKey("new")
+			w, err := d.Create(ctx, key, nil, time.Hour)
+			assert.NoError(t, err)
+			_, writeErr := w.Write(data)
+			closeErr := w.Close()
+
+			after, err := d.Stats(ctx)
+			assert.NoError(t, err)
+			remaining := after.Objects
+			if !tt.expectErr {
+				remaining-- // The new entry.
+			}
+			assert.True(t, remaining < before.Objects, "eviction should have removed existing entries")
+
+			if tt.expectErr {
+				assert.IsError(t, writeErr, syscall.ENOSPC)
+				assert.IsError(t, closeErr, syscall.ENOSPC)
+				_, err = d.Stat(ctx, key)
+				assert.IsError(t, err, os.ErrNotExist)
+				return
+			}
+			assert.NoError(t, writeErr)
+			assert.NoError(t, closeErr)
+			r, _, err := d.Open(ctx, key)
+			assert.NoError(t, err)
+			assert.NoError(t, r.Close())
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"io/fs"
 	"net/http"
@@ -12,11 +14,21 @@ import (
 
 //nolint:gochecknoglobals
 var (
-	ttlBucketName     = []byte("ttl")
-	headersBucketName = []byte("headers")
+	ttlBucketName       = []byte("ttl")
+	lifetimesBucketName = []byte("lifetimes")
+	headersBucketName   = []byte("headers")
+	tagsBucketName      = []byte("tags")
+	metaBucketName      = []byte("meta")
+	keyLengthName       = []byte("key-length")
 )
 
 // diskMetaDB manages expiration times and headers for cache entries using bbolt.
+//
+// The TTL each entry was last written or touched with is recorded as its lifetime, so that its expiry can be
+// extended by the same amount when it is read.
+//
+// Entries are also indexed by their tags, under keys of the tag followed by a NUL byte and the entry's key, so that
+// the entries with a tag can be found without reading every entry's headers.
 type diskMetaDB struct {
 	db *bbolt.DB
 }
@@ -34,9 +46,15 @@ func newDiskMetaDB(dbPath string) (*diskMetaDB, error) {
 		if _, err := tx.CreateBucketIfNotExists(ttlBucketName); err != nil {
 			return errors.WithStack(err)
 		}
+		if _, err := tx.CreateBucketIfNotExists(lifetimesBucketName); err != nil {
+			return errors.WithStack(err)
+		}
 		if _, err := tx.CreateBucketIfNotExists(headersBucketName); err != nil {
 			return errors.WithStack(err)
 		}
+		if _, err := tx.CreateBucketIfNotExists(tagsBucketName); err != nil {
+			return errors.WithStack(err)
+		}
 		return nil
 	}); err != nil {
 		return nil, errors.Join(errors.Errorf("failed to create buckets: %w", err), db.Close())
@@ -45,7 +63,8 @@ func newDiskMetaDB(dbPath string) (*diskMetaDB, error) {
 	return &diskMetaDB{db: db}, nil
 }
 
-func (s *diskMetaDB) setTTL(key Key, expiresAt time.Time) error {
+// setTTL updates the expiry of an entry, and its lifetime if lifetime is non-zero.
+func (s *diskMetaDB) setTTL(key Key, expiresAt time.Time, lifetime time.Duration) error {
 	ttlBytes, err := expiresAt.MarshalBinary()
 	if err != nil {
 		return errors.Errorf("failed to marshal TTL: %w", err)
@@ -53,11 +72,23 @@ func (s *diskMetaDB) setTTL(key Key, expiresAt time.Time) error {
 
 	return errors.WithStack(s.db.Update(func(tx *bbolt.Tx) error {
 		ttlBucket := tx.Bucket(ttlBucketName)
-		return errors.WithStack(ttlBucket.Put(key[:], ttlBytes))
+		if err := ttlBucket.Put(key[:], ttlBytes); err != nil {
+			return errors.WithStack(err)
+		}
+		return putLifetime(tx, key, lifetime)
 	}))
 }
 
-func (s *diskMetaDB) set(key Key, expiresAt time.Time, headers http.Header) error {
+// putLifetime records the lifetime of an entry, if non-zero.
+func putLifetime(tx *bbolt.Tx, key Key, lifetime time.Duration) error {
+	if lifetime <= 0 {
+		return nil
+	}
+	lifetimeBytes := binary.BigEndian.AppendUint64(nil, uint64(lifetime))
+	return errors.WithStack(tx.Bucket(lifetimesBucketName).Put(key[:], lifetimeBytes))
+}
+
+func (s *diskMetaDB) set(key Key, expiresAt time.Time, lifetime time.Duration, headers http.Header) error {
 	ttlBytes, err := expiresAt.MarshalBinary()
 	if err != nil {
 		return errors.Errorf("failed to marshal TTL: %w", err)
@@ -73,12 +104,65 @@ func (s *diskMetaDB) set(key Key, expiresAt time.Time, headers http.Header) erro
 		if err := ttlBucket.Put(key[:], ttlBytes); err != nil {
 			return errors.WithStack(err)
 		}
+		if err := putLifetime(tx, key, lifetime); err != nil {
+			return err
+		}
+
+		if err := deleteTags(tx, key); err != nil {
+			return err
+		}
+		for _, tag := range Tags(headers) {
+			if err := tx.Bucket(tagsBucketName).Put(tagIndexKey(tag, key), nil); err != nil {
+				return errors.WithStack(err)
+			}
+		}
 
 		headersBucket := tx.Bucket(headersBucketName)
 		return errors.WithStack(headersBucket.Put(key[:], headersBytes))
 	}))
 }
 
+// tagIndexKey returns the key under which key is indexed by tag.
+func tagIndexKey(tag string, key Key) []byte {
+	return append(append([]byte(tag), 0), key[:]...)
+}
+
+// deleteTags removes key from the tag index, using the tags in its stored headers.
+func deleteTags(tx *bbolt.Tx, key Key) error {
+	headersBytes := tx.Bucket(headersBucketName).Get(key[:])
+	if headersBytes == nil {
+		return nil
+	}
+	var headers http.Header
+	if err := json.Unmarshal(headersBytes, &headers); err != nil {
+		// Unreadable headers cannot have been indexed.
+		return nil //nolint:nilerr
+	}
+	for _, tag := range Tags(headers) {
+		if err := tx.Bucket(tagsBucketName).Delete(tagIndexKey(tag, key)); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// tagged returns the keys of the entries indexed by tag.
+func (s *diskMetaDB) tagged(tag string) ([]Key, error) {
+	prefix := append([]byte(tag), 0)
+	var keys []Key
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(tagsBucketName).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			var key Key
+			if copy(key[:], k[len(prefix):]) == len(key) {
+				keys = append(keys, key)
+			}
+		}
+		return nil
+	})
+	return keys, errors.WithStack(err)
+}
+
 func (s *diskMetaDB) getTTL(key Key) (time.Time, error) {
 	var expiresAt time.Time
 	err := s.db.View(func(tx *bbolt.Tx) error {
@@ -92,6 +176,18 @@ func (s *diskMetaDB) getTTL(key Key) (time.Time, error) {
 	return expiresAt, errors.WithStack(err)
 }
 
+// getLifetime returns the lifetime of an entry, or zero if none was recorded.
+func (s *diskMetaDB) getLifetime(key Key) (time.Duration, error) {
+	var lifetime time.Duration
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		if lifetimeBytes := tx.Bucket(lifetimesBucketName).Get(key[:]); len(lifetimeBytes) == 8 {
+			lifetime = time.Duration(binary.BigEndian.Uint64(lifetimeBytes)) //nolint:gosec
+		}
+		return nil
+	})
+	return lifetime, errors.WithStack(err)
+}
+
 func (s *diskMetaDB) getHeaders(key Key) (http.Header, error) {
 	var headers http.Header
 	err := s.db.View(func(tx *bbolt.Tx) error {
@@ -111,6 +207,12 @@ func (s *diskMetaDB) delete(key Key) error {
 		if err := ttlBucket.Delete(key[:]); err != nil {
 			return errors.WithStack(err)
 		}
+		if err := tx.Bucket(lifetimesBucketName).Delete(key[:]); err != nil {
+			return errors.WithStack(err)
+		}
+		if err := deleteTags(tx, key); err != nil {
+			return err
+		}
 
 		headersBucket := tx.Bucket(headersBucketName)
 		return errors.WithStack(headersBucket.Delete(key[:]))
@@ -123,12 +225,19 @@ func (s *diskMetaDB) deleteAll(keys []Key) error {
 	}
 	return errors.WithStack(s.db.Update(func(tx *bbolt.Tx) error {
 		ttlBucket := tx.Bucket(ttlBucketName)
+		lifetimesBucket := tx.Bucket(lifetimesBucketName)
 		headersBucket := tx.Bucket(headersBucketName)
 
 		for _, key := range keys {
 			if err := ttlBucket.Delete(key[:]); err != nil {
 				return errors.Errorf("failed to delete TTL: %w", err)
 			}
+			if err := lifetimesBucket.Delete(key[:]); err != nil {
+				return errors.Errorf("failed to delete lifetime: %w", err)
+			}
+			if err := deleteTags(tx, key); err != nil {
+				return errors.Errorf("failed to delete tags: %w", err)
+			}
 			if err := headersBucket.Delete(key[:]); err != nil {
 				return errors.Errorf("failed to delete headers: %w", err)
 			}
@@ -137,25 +246,61 @@ func (s *diskMetaDB) deleteAll(keys []Key) error {
 	}))
 }
 
+// walkBatchSize is the number of TTL entries read per transaction by [diskMetaDB.walk].
+const walkBatchSize = 1000
+
+// walk calls fn for each TTL entry.
+//
+// Entries are read in batches, each in its own short read transaction, and fn is called outside of any transaction
+// so that slow callbacks do not hold the database open. Entries added or removed during the walk may or may not be
+// visited.
 func (s *diskMetaDB) walk(fn func(key Key, expiresAt time.Time) error) error {
-	return errors.WithStack(s.db.View(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket(ttlBucketName)
-		if bucket == nil {
-			return nil
-		}
-		return bucket.ForEach(func(k, v []byte) error {
-			if len(k) != 32 {
+	type entry struct {
+		key       Key
+		expiresAt time.Time
+	}
+	var after []byte
+	for {
+		batch := make([]entry, 0, walkBatchSize)
+		err := s.db.View(func(tx *bbolt.Tx) error {
+			bucket := tx.Bucket(ttlBucketName)
+			if bucket == nil {
 				return nil
 			}
-			var key Key
-			copy(key[:], k)
-			var expiresAt time.Time
-			if err := expiresAt.UnmarshalBinary(v); err != nil {
-				return nil //nolint:nilerr
+			c := bucket.Cursor()
+			k, v := c.First()
+			if after != nil {
+				k, v = c.Seek(after)
+				if bytes.Equal(k, after) {
+					k, v = c.Next()
+				}
 			}
-			return fn(key, expiresAt)
+			for ; k != nil && len(batch) < walkBatchSize; k, v = c.Next() {
+				if len(k) != 32 {
+					continue
+				}
+				var e entry
+				copy(e.key[:], k)
+				if err := e.expiresAt.UnmarshalBinary(v); err != nil {
+					continue
+				}
+				batch = append(batch, e)
+			}
+			return nil
 		})
-	}))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for _, e := range batch {
+			if err := fn(e.key, e.expiresAt); err != nil {
+				return err
+			}
+		}
+		if len(batch) < walkBatchSize {
+			return nil
+		}
+		after = batch[len(batch)-1].key[:]
+	}
 }
 
 func (s *diskMetaDB) count() (int64, error) {
@@ -171,6 +316,37 @@ func (s *diskMetaDB) count() (int64, error) {
 	return count, errors.WithStack(err)
 }
 
+// resetKeyLength records the key length that entries are stored with. If entries were previously stored with a
+// different length they can never be looked up, so they are all removed and true is returned.
+//
+// Databases that predate recording the key length used [DefaultKeyLength].
+func (s *diskMetaDB) resetKeyLength(length int) (bool, error) {
+	reset := false
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucketName)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		previous := DefaultKeyLength
+		if value := meta.Get(keyLengthName); len(value) == 1 {
+			previous = int(value[0])
+		}
+		if previous != length {
+			reset = true
+			for _, name := range [][]byte{ttlBucketName, lifetimesBucketName, headersBucketName, tagsBucketName} {
+				if err := tx.DeleteBucket(name); err != nil {
+					return errors.Errorf("failed to delete bucket %s: %w", name, err)
+				}
+				if _, err := tx.CreateBucket(name); err != nil {
+					return errors.Errorf("failed to create bucket %s: %w", name, err)
+				}
+			}
+		}
+		return errors.WithStack(meta.Put(keyLengthName, []byte{byte(length)}))
+	})
+	return reset, errors.WithStack(err)
+}
+
 func (s *diskMetaDB) close() error {
 	if err := s.db.Close(); err != nil {
 		return errors.Errorf("failed to close bbolt database: %w", err)
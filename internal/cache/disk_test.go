@@ -1,8 +1,17 @@
 package cache_test
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -26,6 +35,17 @@ func TestDiskCache(t *testing.T) {
 	})
 }
 
+func TestDiskCacheSlidingExpiration(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	c, err := cache.NewDisk(ctx, cache.DiskConfig{
+		Root:              t.TempDir(),
+		MaxTTL:            time.Second,
+		SlidingExpiration: true,
+	})
+	assert.NoError(t, err)
+	cachetest.SlidingExpiration(t, c, 200*time.Millisecond)
+}
+
 func TestDiskCacheSoak(t *testing.T) {
 	if os.Getenv("SOAK_TEST") == "" {
 		t.Skip("Skipping soak test; set SOAK_TEST=1 to run")
@@ -52,3 +72,301 @@ func TestDiskCacheSoak(t *testing.T) {
 		TTL:              5 * time.Minute,
 	})
 }
+
+func TestDiskOpenStale(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	c, err := cache.NewDisk(ctx, cache.DiskConfig{
+		Root:       t.TempDir(),
+		MaxTTL:     time.Hour,
+		StaleGrace: time.Hour,
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	key := cache.NewKey("stale")
+	w, err := c.Create(ctx, key, nil, 50*time.Millisecond)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("stale data"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	time.Sleep(100 * time.Millisecond)
+
+	_, _, err = c.Open(ctx, key)
+	assert.IsError(t, err, os.ErrNotExist)
+
+	_, _, err = c.OpenStale(ctx, key, time.Millisecond)
+	assert.IsError(t, err, os.ErrNotExist)
+
+	r, _, err := c.OpenStale(ctx, key, time.Minute)
+	assert.NoError(t, err)
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, "stale data", string(data))
+}
+
+func TestDiskOpenRangeSlidesExpiry(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	c, err := cache.NewDisk(ctx, cache.DiskConfig{
+		Root:              t.TempDir(),
+		MaxTTL:            time.Hour,
+		SlidingExpiration: true,
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	key := cache.NewKey("range")
+	w, err := c.Create(ctx, key, nil, 200*time.Millisecond)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("0123456789"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	// Each range read extends the entry's expiry, as a full read does.
+	for range 3 {
+		time.Sleep(100 * time.Millisecond)
+		r, _, err := c.OpenRange(ctx, key, cache.Range{Start: 2, End: 4})
+		assert.NoError(t, err)
+		data, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.NoError(t, r.Close())
+		assert.Equal(t, "234", string(data))
+	}
+	ok, err := c.Has(ctx, key)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestDiskConcurrentCreate(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	c, err := cache.NewDisk(ctx, cache.DiskConfig{
+		Root:   t.TempDir(),
+		MaxTTL: time.Hour,
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	key := cache.NewKey("contended")
+	bodies := map[string]string{}
+	for i := range 16 {
+		bodies[fmt.Sprint(i)] = strings.Repeat(fmt.Sprint(i), 1000*(i+1))
+	}
+
+	// Open every writer before any commits so all of them are in flight at once.
+	var ready, start sync.WaitGroup
+	ready.Add(len(bodies))
+	start.Add(1)
+	wg := sync.WaitGroup{}
+	for writer, body := range bodies {
+		wg.Go(func() {
+			w, err := c.Create(ctx, key, http.Header{"X-Writer": {writer}}, 0)
+			assert.NoError(t, err)
+			ready.Done()
+			start.Wait()
+			for chunk := range strings.SplitSeq(body, "") {
+				_, err = w.Write([]byte(chunk))
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, w.Close())
+		})
+	}
+	ready.Wait()
+	start.Done()
+	wg.Wait()
+
+	r, headers, err := c.Open(ctx, key)
+	assert.NoError(t, err)
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	writer := headers.Get("X-Writer")
+	assert.True(t, bodies[writer] == string(data), "body must be the one written by writer %q", writer)
+	assert.Equal(t, int64(len(data)), c.Size())
+}
+
+func TestDiskReconcilesExternalModifications(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	root := t.TempDir()
+	c, err := cache.NewDisk(ctx, cache.DiskConfig{
+		Root:              root,
+		MaxTTL:            time.Hour,
+		ReconcileInterval: 10 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	for _, name := range []string{"kept", "removed"} {
+		w, err := c.Create(ctx, cache.NewKey(name), nil, 0)
+		assert.NoError(t, err)
+		_, err = w.Write([]byte(strings.Repeat("x", 1000)))
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+	}
+	stats, err := c.Stats(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, cache.Stats{Objects: 2, Size: 2000, Capacity: stats.Capacity}, stats)
+
+	key := cache.NewKey("removed")
+	assert.NoError(t, os.Remove(filepath.Join(root, key.String()[:2], key.String())))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats, err = c.Stats(ctx)
+		assert.NoError(t, err)
+		if stats.Size == 1000 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, cache.Stats{Objects: 1, Size: 1000, Capacity: stats.Capacity}, stats)
+}
+
+// Reconciliation does not block commits while walking the cache, so commits made during a walk must not be lost or
+// counted twice.
+func TestDiskReconcileDuringCommits(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	root := t.TempDir()
+	c, err := cache.NewDisk(ctx, cache.DiskConfig{
+		Root:              root,
+		MaxTTL:            time.Hour,
+		ReconcileInterval: time.Millisecond,
+	})
+	assert.NoError(t, err)
+
+	// Writers continue until after the cache is closed, so that the last reconciliation overlaps commits. Commits fail
+	// once it is closed, which leaves the cache as it was.
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for writer := range 4 {
+		wg.Go(func() {
+			for i := 0; !stop.Load(); i++ {
+				// Objects are overwritten with different sizes, so their size is subtracted as well as added.
+				w, err := c.Create(ctx, cache.NewKey(fmt.Sprintf("object-%d", i%50)), nil, 0)
+				if err != nil {
+					continue
+				}
+				_, _ = w.Write([]byte(strings.Repeat("x", 100*(writer+1)+i%100))) //nolint:errcheck
+				_ = w.Close()                                                     //nolint:errcheck
+			}
+		})
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, c.Close())
+	stop.Store(true)
+	wg.Wait()
+
+	var size int64
+	err = filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() == "metadata.db" || strings.HasPrefix(d.Name(), ".tmp-") {
+			return err
+		}
+		info, err := d.Info()
+		size += info.Size()
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, size, c.Size())
+}
+
+func TestDiskKeyLengthChange(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	root := t.TempDir()
+	open := func() *cache.Disk {
+		c, err := cache.NewDisk(ctx, cache.DiskConfig{Root: root, MaxTTL: time.Hour})
+		assert.NoError(t, err)
+		return c
+	}
+
+	c := open()
+	w, err := c.Create(ctx, cache.NewKey("object"), nil, 0)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("data"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.NoError(t, c.Close())
+
+	// Reopening with the same key length keeps objects.
+	c = open()
+	_, err = c.Stat(ctx, cache.NewKey("object"))
+	assert.NoError(t, err)
+	assert.NoError(t, c.Close())
+
+	setKeyLength(t, cache.MinKeyLength)
+	c = open()
+	defer c.Close()
+	_, err = c.Stat(ctx, cache.NewKey("object"))
+	assert.IsError(t, err, os.ErrNotExist)
+	stats, err := c.Stats(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, cache.Stats{Capacity: stats.Capacity}, stats)
+}
+
+// Disk paths are derived from the hex encoding of a key's hash, so keys derived from strings containing ".." can never
+// address files outside the root.
+func TestDiskKeysStayWithinRoot(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	parent := t.TempDir()
+	root := filepath.Join(parent, "root")
+	c, err := cache.NewDisk(ctx, cache.DiskConfig{Root: root, MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	key := cache.NewKey("../escape")
+	w, err := c.Create(ctx, key, nil, time.Hour)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("data"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.NoError(t, c.Delete(ctx, key))
+
+	entries, err := os.ReadDir(parent)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "root", entries[0].Name())
+
+	// Keys supplied as text, eg. to the API strategy, are hashed unless they are already hex encoded hashes.
+	parsed, err := cache.ParseKey("../escape")
+	assert.NoError(t, err)
+	assert.Equal(t, key, parsed)
+}
+
+func TestDiskVerifyOnRead(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	root := t.TempDir()
+	c, err := cache.NewDisk(ctx, cache.DiskConfig{Root: root, MaxTTL: time.Hour, VerifyOnRead: true})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	key := cache.NewKey("verified")
+	w, err := c.Create(ctx, key, nil, time.Hour)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("hello world"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	r, headers, err := c.Open(ctx, key)
+	assert.NoError(t, err)
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, "hello world", string(data))
+	sum := sha256.Sum256([]byte("hello world"))
+	assert.Equal(t, hex.EncodeToString(sum[:]), headers.Get(cache.DigestHeader))
+	assert.Equal(t, "11", headers.Get("Content-Length"))
+
+	path := filepath.Join(root, key.String()[:2], key.String())
+	assert.NoError(t, os.WriteFile(path, []byte("hello World"), 0o600))
+
+	r, _, err = c.Open(ctx, key)
+	assert.NoError(t, err)
+	data, err = io.ReadAll(r)
+	assert.IsError(t, err, cache.ErrCorrupt)
+	assert.Equal(t, "hello World", string(data))
+	assert.NoError(t, r.Close())
+
+	_, err = os.Stat(path)
+	assert.IsError(t, err, os.ErrNotExist, "corrupt object should be evicted")
+	_, _, err = c.Open(ctx, key)
+	assert.IsError(t, err, os.ErrNotExist)
+}
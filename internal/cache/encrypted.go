@@ -0,0 +1,427 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"iter"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/errors"
+)
+
+// RegisterEncrypted cache decorator with the given registry.
+func RegisterEncrypted(r *Registry) {
+	RegisterDecorator(
+		r,
+		"encrypted",
+		"Transparently encrypts cached objects and their headers at rest with AES-256-GCM",
+		func(_ context.Context, config EncryptedConfig, inner Cache) (Cache, error) {
+			if len(config.Keys) == 0 {
+				return nil, errors.New("at least one key is required")
+			}
+			keys := make([][]byte, len(config.Keys))
+			for i, key := range config.Keys {
+				decoded, err := hex.DecodeString(key)
+				if err != nil {
+					return nil, errors.Errorf("key %d: %w", i, err)
+				}
+				keys[i] = decoded
+			}
+			return errors.WithStack2(NewEncrypted(inner, keys[0], keys[1:]...))
+		},
+	)
+}
+
+type EncryptedConfig struct {
+	Keys []string `hcl:"keys" help:"Hex-encoded 256-bit keys. New objects are encrypted with the first key, the remainder are only used to decrypt existing objects."`
+}
+
+// Validate the configuration.
+func (c *EncryptedConfig) Validate() error {
+	if len(c.Keys) == 0 {
+		return errors.New("at least one key is required")
+	}
+	var errs []error
+	for i, key := range c.Keys {
+		if decoded, err := hex.DecodeString(key); err != nil || len(decoded) != 32 {
+			errs = append(errs, errors.Errorf("key %d must be 64 hex characters (256 bits)", i))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+const (
+	encryptionHeader    = "X-Cachew-Encryption"
+	encryptionSaltSize  = 32
+	encryptionChunkSize = 64 * 1024
+)
+
+// Encrypted is a [Cache] decorator that encrypts object bodies and headers before they reach the underlying cache.
+//
+// Each object is encrypted with AES-256-GCM under a key derived from the master key and a random per-object salt.
+// Bodies are split into fixed size chunks that are sealed individually, so objects are never fully buffered, and
+// the final chunk is flagged so truncation is detected. The cache key is bound to every ciphertext, preventing
+// objects from being swapped between keys.
+//
+// The original headers are stored encrypted in a single [encryptionHeader] header. Only an object's tags, from its
+// [TagHeader] header, are also stored in the clear.
+type Encrypted struct {
+	inner     Cache
+	currentID string
+	keys      map[string][]byte
+}
+
+var (
+	_ Cache            = (*Encrypted)(nil)
+	_ StaleOpener      = (*Encrypted)(nil)
+	_ Purger           = (*Encrypted)(nil)
+	_ ExclusiveCreator = (*Encrypted)(nil)
+	_ TagLister        = (*Encrypted)(nil)
+	_ Lister           = (*Encrypted)(nil)
+	_ Epocher          = (*Encrypted)(nil)
+)
+
+// NewEncrypted creates a new [Encrypted] cache wrapping inner.
+//
+// New objects are encrypted with key, while objects previously encrypted with any of the "previous" keys can still be
+// decrypted, allowing keys to be rotated without invalidating the cache. All keys must be 32 bytes.
+func NewEncrypted(inner Cache, key []byte, previous ...[]byte) (*Encrypted, error) {
+	e := &Encrypted{inner: inner, keys: map[string][]byte{}}
+	for i, k := range append([][]byte{key}, previous...) {
+		if len(k) != 32 {
+			return nil, errors.Errorf("encryption key %d must be 32 bytes but is %d", i, len(k))
+		}
+		id := encryptionKeyID(k)
+		if i == 0 {
+			e.currentID = id
+		}
+		e.keys[id] = k
+	}
+	return e, nil
+}
+
+func (e *Encrypted) String() string { return "encrypted:" + e.inner.String() }
+
+func (e *Encrypted) Stat(ctx context.Context, key Key) (http.Header, error) {
+	stored, err := e.inner.Stat(ctx, key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	headers, _, err := e.decryptHeaders(key, stored)
+	return headers, err
+}
+
+func (e *Encrypted) Has(ctx context.Context, key Key) (bool, error) {
+	return errors.WithStack2(e.inner.Has(ctx, key))
+}
+
+func (e *Encrypted) Open(ctx context.Context, key Key) (io.ReadCloser, http.Header, error) {
+	return e.decryptObject(key)(e.inner.Open(ctx, key))
+}
+
+// Purge removes objects from the underlying cache. Sizes are those of the encrypted objects.
+func (e *Encrypted) Purge(ctx context.Context, options PurgeOptions) (PurgeResult, error) {
+	return errors.WithStack2(Purge(ctx, e.inner, options))
+}
+
+// ListTagged returns the objects in the underlying cache created with tag. Sizes are those of the encrypted objects.
+func (e *Encrypted) ListTagged(ctx context.Context, tag string) ([]ObjectInfo, error) {
+	return errors.WithStack2(ListTagged(ctx, e.inner, tag))
+}
+
+// ListKeys returns the objects in the underlying cache whose keys start with prefix, with their decrypted headers.
+// Sizes are those of the encrypted objects, and headers are omitted for objects that cannot be decrypted.
+func (e *Encrypted) ListKeys(ctx context.Context, prefix string) iter.Seq2[ObjectInfo, error] {
+	return func(yield func(ObjectInfo, error) bool) {
+		for object, err := range ListKeys(ctx, e.inner, prefix) {
+			if err == nil {
+				object.Headers, _, _ = e.decryptHeaders(object.Key, object.Headers) //nolint:errcheck
+			}
+			if !yield(object, err) {
+				return
+			}
+		}
+	}
+}
+
+// CurrentEpoch returns the epoch of the underlying cache.
+func (e *Encrypted) CurrentEpoch(ctx context.Context) (uint64, error) {
+	return errors.WithStack2(CurrentEpoch(ctx, e.inner))
+}
+
+// AdvanceEpoch advances the epoch of the underlying cache.
+func (e *Encrypted) AdvanceEpoch(ctx context.Context) (uint64, error) {
+	return errors.WithStack2(AdvanceEpoch(ctx, e.inner))
+}
+
+// OpenStale opens an object from the underlying cache that may have expired up to "grace" ago.
+func (e *Encrypted) OpenStale(ctx context.Context, key Key, grace time.Duration) (io.ReadCloser, http.Header, error) {
+	return e.decryptObject(key)(OpenStale(ctx, e.inner, key, grace))
+}
+
+func (e *Encrypted) Create(ctx context.Context, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	return e.create(ctx, key, headers, ttl, e.inner.Create)
+}
+
+// CreateExclusive creates an object only if it does not already exist in the underlying cache.
+func (e *Encrypted) CreateExclusive(ctx context.Context, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	return e.create(ctx, key, headers, ttl, func(ctx context.Context, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+		return CreateExclusive(ctx, e.inner, key, headers, ttl)
+	})
+}
+
+func (e *Encrypted) create(ctx context.Context, key Key, headers http.Header, ttl time.Duration,
+	create func(context.Context, Key, http.Header, time.Duration) (io.WriteCloser, error)) (io.WriteCloser, error) {
+	salt := make([]byte, encryptionSaltSize)
+	_, _ = rand.Read(salt)
+	master := e.keys[e.currentID]
+	headerAEAD, err := newObjectAEAD(master, salt, "headers")
+	if err != nil {
+		return nil, err
+	}
+	bodyAEAD, err := newObjectAEAD(master, salt, "body")
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := json.Marshal(headers)
+	if err != nil {
+		return nil, errors.Errorf("failed to marshal headers: %w", err)
+	}
+	sealed := headerAEAD.Seal(nil, make([]byte, headerAEAD.NonceSize()), plaintext, key[:])
+	stored := http.Header{}
+	stored.Set(encryptionHeader, strings.Join([]string{
+		e.currentID,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(sealed),
+	}, "."))
+	// Tags are left in the clear so that the underlying cache can list and purge objects by them.
+	for _, tag := range Tags(headers) {
+		stored.Add(TagHeader, tag)
+	}
+	w, err := create(ctx, key, stored, ttl)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &encryptedWriter{
+		w:    w,
+		aead: bodyAEAD,
+		ad:   key[:],
+		buf:  make([]byte, 0, encryptionChunkSize),
+	}, nil
+}
+
+func (e *Encrypted) Touch(ctx context.Context, key Key, ttl time.Duration) error {
+	return errors.WithStack(e.inner.Touch(ctx, key, ttl))
+}
+
+func (e *Encrypted) Delete(ctx context.Context, key Key) error {
+	return errors.WithStack(e.inner.Delete(ctx, key))
+}
+
+// Stats returns the statistics of the underlying cache, which include encryption overhead.
+func (e *Encrypted) Stats(ctx context.Context) (Stats, error) {
+	return errors.WithStack2(e.inner.Stats(ctx))
+}
+
+func (e *Encrypted) Close() error { return errors.WithStack(e.inner.Close()) }
+
+func (e *Encrypted) decryptObject(key Key) func(io.ReadCloser, http.Header, error) (io.ReadCloser, http.Header, error) {
+	return func(r io.ReadCloser, stored http.Header, err error) (io.ReadCloser, http.Header, error) {
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		headers, bodyAEAD, err := e.decryptHeaders(key, stored)
+		if err != nil {
+			return nil, nil, errors.Join(err, r.Close())
+		}
+		return &encryptedReader{
+			r:      bufio.NewReaderSize(r, encryptionChunkSize+bodyAEAD.Overhead()),
+			closer: r,
+			aead:   bodyAEAD,
+			ad:     key[:],
+			buf:    make([]byte, encryptionChunkSize+bodyAEAD.Overhead()),
+		}, headers, nil
+	}
+}
+
+// decryptHeaders decrypts the original headers of an object, returning them and the AEAD for its body.
+func (e *Encrypted) decryptHeaders(key Key, stored http.Header) (http.Header, cipher.AEAD, error) {
+	parts := strings.Split(stored.Get(encryptionHeader), ".")
+	if len(parts) != 3 {
+		return nil, nil, errors.Errorf("%s: object is not encrypted", key.String())
+	}
+	master, ok := e.keys[parts[0]]
+	if !ok {
+		return nil, nil, errors.Errorf("%s: object is encrypted with unknown key %s", key.String(), parts[0])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, errors.Errorf("%s: invalid salt: %w", key.String(), err)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, errors.Errorf("%s: invalid headers: %w", key.String(), err)
+	}
+	headerAEAD, err := newObjectAEAD(master, salt, "headers")
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := headerAEAD.Open(nil, make([]byte, headerAEAD.NonceSize()), sealed, key[:])
+	if err != nil {
+		return nil, nil, errors.Errorf("%s: failed to decrypt headers: %w", key.String(), err)
+	}
+	var headers http.Header
+	if err := json.Unmarshal(plaintext, &headers); err != nil {
+		return nil, nil, errors.Errorf("%s: failed to unmarshal headers: %w", key.String(), err)
+	}
+	if headers == nil {
+		headers = http.Header{}
+	}
+	// The underlying cache may have added its own Last-Modified header.
+	if headers.Get("Last-Modified") == "" && stored.Get("Last-Modified") != "" {
+		headers.Set("Last-Modified", stored.Get("Last-Modified"))
+	}
+	bodyAEAD, err := newObjectAEAD(master, salt, "body")
+	if err != nil {
+		return nil, nil, err
+	}
+	return headers, bodyAEAD, nil
+}
+
+func encryptionKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+func newObjectAEAD(master, salt []byte, purpose string) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, master, salt, "cachew "+purpose, 32)
+	if err != nil {
+		return nil, errors.Errorf("failed to derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return errors.WithStack2(cipher.NewGCM(block))
+}
+
+// chunkNonce returns the nonce for the given chunk, with the final chunk flagged so truncation can be detected.
+func chunkNonce(counter uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, counter)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type encryptedWriter struct {
+	w       io.WriteCloser
+	aead    cipher.AEAD
+	ad      []byte
+	buf     []byte
+	out     []byte
+	counter uint64
+	closed  bool
+}
+
+func (w *encryptedWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("writer closed")
+	}
+	n := 0
+	for len(p) > 0 {
+		// Only flush a full chunk once more data arrives, so the final chunk is always sealed in Close.
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(false); err != nil {
+				return n, err
+			}
+		}
+		copied := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+copied]
+		p = p[copied:]
+		n += copied
+	}
+	return n, nil
+}
+
+func (w *encryptedWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return errors.Join(w.flush(true), errors.WithStack(w.w.Close()))
+}
+
+func (w *encryptedWriter) flush(final bool) error {
+	w.out = w.aead.Seal(w.out[:0], chunkNonce(w.counter, final), w.buf, w.ad)
+	w.counter++
+	w.buf = w.buf[:0]
+	_, err := w.w.Write(w.out)
+	return errors.WithStack(err)
+}
+
+type encryptedReader struct {
+	r       *bufio.Reader
+	closer  io.Closer
+	aead    cipher.AEAD
+	ad      []byte
+	buf     []byte
+	plain   []byte
+	counter uint64
+	done    bool
+}
+
+func (r *encryptedReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+func (r *encryptedReader) Close() error { return errors.WithStack(r.closer.Close()) }
+
+// next reads and decrypts the next chunk.
+func (r *encryptedReader) next() error {
+	n, err := io.ReadFull(r.r, r.buf)
+	final := false
+	switch {
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		final = true
+	case err != nil:
+		return errors.WithStack(err)
+	default:
+		if _, err := r.r.Peek(1); errors.Is(err, io.EOF) {
+			final = true
+		} else if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	plain, err := r.aead.Open(r.buf[:0], chunkNonce(r.counter, final), r.buf[:n], r.ad)
+	if err != nil {
+		return errors.Errorf("failed to decrypt object, it may be corrupt or truncated: %w", err)
+	}
+	r.counter++
+	r.plain = plain
+	r.done = final
+	return nil
+}
@@ -0,0 +1,155 @@
+package cache_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/cache/cachetest"
+	"github.com/block/cachew/internal/logging"
+)
+
+func newEncryptionKey() []byte {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return key
+}
+
+func TestEncryptedCache(t *testing.T) {
+	cachetest.Suite(t, func(t *testing.T) cache.Cache {
+		_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+		inner, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: 100 * time.Millisecond})
+		assert.NoError(t, err)
+		c, err := cache.NewEncrypted(inner, newEncryptionKey())
+		assert.NoError(t, err)
+		return c
+	})
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{name: "Empty", size: 0},
+		{name: "Small", size: 10},
+		{name: "ExactChunk", size: 64 * 1024},
+		{name: "MultipleChunks", size: 3*64*1024 + 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+			inner, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+			assert.NoError(t, err)
+			c, err := cache.NewEncrypted(inner, newEncryptionKey())
+			assert.NoError(t, err)
+
+			data := make([]byte, tt.size)
+			_, _ = rand.Read(data)
+			key := cache.NewKey("round-trip")
+			w, err := c.Create(ctx, key, http.Header{"Content-Type": {"application/octet-stream"}}, 0)
+			assert.NoError(t, err)
+			_, err = w.Write(data)
+			assert.NoError(t, err)
+			assert.NoError(t, w.Close())
+
+			r, headers, err := c.Open(ctx, key)
+			assert.NoError(t, err)
+			defer r.Close()
+			assert.Equal(t, "application/octet-stream", headers.Get("Content-Type"))
+			got, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.True(t, bytes.Equal(data, got))
+		})
+	}
+}
+
+func TestEncryptedStoresCiphertext(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	dir := t.TempDir()
+	inner, err := cache.NewDisk(ctx, cache.DiskConfig{Root: dir, MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	c, err := cache.NewEncrypted(inner, newEncryptionKey())
+	assert.NoError(t, err)
+
+	plaintext := bytes.Repeat([]byte("top secret content "), 1000)
+	key := cache.NewKey("secret")
+	w, err := c.Create(ctx, key, http.Header{"X-Secret-Header": {"classified-value"}}, 0)
+	assert.NoError(t, err)
+	_, err = w.Write(plaintext)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.NoError(t, c.Close())
+
+	err = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.False(t, bytes.Contains(data, []byte("top secret content")), "plaintext body found in %s", path)
+		assert.False(t, bytes.Contains(data, []byte("classified-value")), "plaintext header found in %s", path)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestEncryptedKeys(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	inner, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	oldKey := newEncryptionKey()
+	oldCache, err := cache.NewEncrypted(inner, oldKey)
+	assert.NoError(t, err)
+
+	key := cache.NewKey("rotated")
+	w, err := oldCache.Create(ctx, key, http.Header{}, 0)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("encrypted with old key"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	t.Run("WrongKey", func(t *testing.T) {
+		c, err := cache.NewEncrypted(inner, newEncryptionKey())
+		assert.NoError(t, err)
+		_, _, err = c.Open(ctx, key)
+		assert.Error(t, err)
+	})
+
+	t.Run("Rotated", func(t *testing.T) {
+		c, err := cache.NewEncrypted(inner, newEncryptionKey(), oldKey)
+		assert.NoError(t, err)
+		r, _, err := c.Open(ctx, key)
+		assert.NoError(t, err)
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, "encrypted with old key", string(data))
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		tampered := cache.NewKey("tampered")
+		r, headers, err := inner.Open(ctx, key)
+		assert.NoError(t, err)
+		data, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		data[len(data)-1] ^= 0xff
+		w, err := inner.Create(ctx, tampered, headers, 0)
+		assert.NoError(t, err)
+		_, err = w.Write(data)
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+
+		_, _, err = oldCache.Open(ctx, tampered)
+		assert.Error(t, err, "ciphertext must be bound to its cache key")
+	})
+}
@@ -0,0 +1,324 @@
+package cache
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/logging"
+)
+
+// ErrEpochUnavailable is returned when a cache does not support coordinated invalidation with an epoch.
+var ErrEpochUnavailable = errors.New("epoch unavailable")
+
+// RegisterEpoch cache decorator with the given registry.
+func RegisterEpoch(r *Registry) {
+	RegisterDecorator(
+		r,
+		"epoch",
+		"Mixes a fleet-wide epoch, stored in the cache itself, into every cache key so that advancing it invalidates all existing objects",
+		func(ctx context.Context, config EpochConfig, inner Cache) (Cache, error) {
+			return errors.WithStack2(NewEpochCache(ctx, inner, config.RefreshInterval))
+		},
+	)
+}
+
+type EpochConfig struct {
+	RefreshInterval time.Duration `hcl:"refresh-interval,optional" help:"How often the epoch is re-read from the cache, bounding how long other instances serve objects invalidated by an epoch advanced elsewhere." default:"10s"`
+}
+
+// Validate the configuration.
+func (c *EpochConfig) Validate() error {
+	if c.RefreshInterval <= 0 {
+		return errors.New("refresh-interval must be positive")
+	}
+	return nil
+}
+
+// Epocher is implemented by caches whose objects can all be invalidated at once by advancing an epoch.
+type Epocher interface {
+	// CurrentEpoch returns the current epoch, after re-reading it from the underlying storage.
+	CurrentEpoch(ctx context.Context) (uint64, error)
+	// AdvanceEpoch increments the epoch, after which all objects created in earlier epochs miss, and returns it.
+	AdvanceEpoch(ctx context.Context) (uint64, error)
+}
+
+// CurrentEpoch returns the current epoch of c.
+//
+// Returns [ErrEpochUnavailable] if the cache does not implement [Epocher].
+func CurrentEpoch(ctx context.Context, c Cache) (uint64, error) {
+	if e, ok := c.(Epocher); ok {
+		return errors.WithStack2(e.CurrentEpoch(ctx))
+	}
+	return 0, errors.Errorf("%s: %w", c.String(), ErrEpochUnavailable)
+}
+
+// AdvanceEpoch increments the epoch of c, invalidating all of its objects, and returns the new epoch.
+//
+// Returns [ErrEpochUnavailable] if the cache does not implement [Epocher].
+func AdvanceEpoch(ctx context.Context, c Cache) (uint64, error) {
+	if e, ok := c.(Epocher); ok {
+		return errors.WithStack2(e.AdvanceEpoch(ctx))
+	}
+	return 0, errors.Errorf("%s: %w", c.String(), ErrEpochUnavailable)
+}
+
+// maxEpochSize is the largest epoch object that is read.
+const maxEpochSize = 32
+
+// Epoch is a [Cache] decorator that derives the key used in the underlying cache from the caller's key and an epoch
+// shared by every instance using the same underlying cache.
+//
+// Advancing the epoch invalidates every object without deleting anything: objects created in earlier epochs are
+// stored under keys that are no longer derived, and expire as usual. Keys are unchanged in epoch 0, so enabling the
+// decorator does not invalidate existing objects.
+//
+// The epoch is stored in the underlying cache itself, in a well-known object holding the current epoch and a marker
+// object per epoch. Instances re-read it periodically, so an epoch advanced by one instance is picked up by the others
+// within the refresh interval. Markers are checked with [Cache.Has], so that a copy of the epoch object in a tier
+// local to an instance cannot hold it back. The epoch never decreases within an instance, and an instance that finds
+// the epoch object missing or behind rewrites it, so that it survives expiry while any instance is running.
+type Epoch struct {
+	inner    Cache
+	logger   *slog.Logger
+	epoch    atomic.Uint64
+	mu       sync.Mutex // Serializes refreshes and advances.
+	stop     context.CancelFunc
+	finished chan struct{}
+}
+
+var (
+	_ Cache            = (*Epoch)(nil)
+	_ StaleOpener      = (*Epoch)(nil)
+	_ Purger           = (*Epoch)(nil)
+	_ ExclusiveCreator = (*Epoch)(nil)
+	_ Epocher          = (*Epoch)(nil)
+)
+
+// NewEpoch creates a new [Epoch] cache wrapping inner, reading the current epoch from it and re-reading it every
+// refreshInterval until the cache is closed.
+func NewEpoch(ctx context.Context, inner Cache, refreshInterval time.Duration) (*Epoch, error) {
+	if refreshInterval <= 0 {
+		return nil, errors.New("epoch refresh interval must be positive")
+	}
+	ctx, stop := context.WithCancel(ctx)
+	e := &Epoch{inner: inner, logger: logging.FromContext(ctx), stop: stop, finished: make(chan struct{})}
+	if _, err := e.CurrentEpoch(ctx); err != nil {
+		stop()
+		return nil, errors.Wrap(err, "failed to read cache epoch")
+	}
+	go e.refreshLoop(ctx, refreshInterval)
+	return e, nil
+}
+
+// NewEpochCache is like [NewEpoch], but the returned cache also implements [RangeOpener] if inner does.
+func NewEpochCache(ctx context.Context, inner Cache, refreshInterval time.Duration) (Cache, error) {
+	e, err := NewEpoch(ctx, inner, refreshInterval)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := inner.(RangeOpener); ok {
+		return epochRangeOpener{e}, nil
+	}
+	return e, nil
+}
+
+// epochKey is the key of the object holding the current epoch.
+var epochKey = NewKey("cachew:epoch") //nolint:gochecknoglobals
+
+// epochMarkerKey is the key of the object marking that epoch has begun.
+func epochMarkerKey(epoch uint64) Key {
+	return NewKey("cachew:epoch:" + strconv.FormatUint(epoch, 10))
+}
+
+// Key returns the key under which key is stored in the underlying cache in the current epoch.
+func (e *Epoch) Key(key Key) Key {
+	epoch := e.epoch.Load()
+	if epoch == 0 {
+		return key
+	}
+	mac := hmac.New(sha256.New, []byte("epoch:"+strconv.FormatUint(epoch, 10)))
+	_, _ = mac.Write(key[:])
+	return truncateKey([32]byte(mac.Sum(nil)))
+}
+
+func (e *Epoch) CurrentEpoch(ctx context.Context) (uint64, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.refresh(ctx); err != nil {
+		return 0, err
+	}
+	return e.epoch.Load(), nil
+}
+
+// AdvanceEpoch begins the epoch after the latest one, in every instance sharing the underlying cache once they next
+// refresh it.
+//
+// Instances advancing the epoch concurrently may begin the same epoch, so that objects are invalidated only once.
+func (e *Epoch) AdvanceEpoch(ctx context.Context) (uint64, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.refresh(ctx); err != nil {
+		return 0, err
+	}
+	epoch := e.epoch.Load() + 1
+	if err := e.write(ctx, epochMarkerKey(epoch), ""); err != nil {
+		return 0, errors.Wrap(err, "failed to create epoch marker")
+	}
+	if err := e.write(ctx, epochKey, strconv.FormatUint(epoch, 10)); err != nil {
+		return 0, errors.Wrap(err, "failed to store epoch")
+	}
+	e.epoch.Store(epoch)
+	e.logger.InfoContext(ctx, "Advanced cache epoch", slog.Uint64("epoch", epoch))
+	return epoch, nil
+}
+
+// refresh reads the latest epoch from the underlying cache. Must be called with mu held.
+func (e *Epoch) refresh(ctx context.Context) error {
+	current := e.epoch.Load()
+	stored, err := e.read(ctx)
+	if err != nil {
+		return err
+	}
+	epoch := max(current, stored)
+	for {
+		ok, err := e.inner.Has(ctx, epochMarkerKey(epoch+1))
+		if err != nil {
+			return errors.Wrap(err, "failed to check epoch marker")
+		}
+		if !ok {
+			break
+		}
+		epoch++
+	}
+	if epoch > stored {
+		if err := e.write(ctx, epochKey, strconv.FormatUint(epoch, 10)); err != nil {
+			return errors.Wrap(err, "failed to store epoch")
+		}
+	}
+	if epoch != current {
+		e.logger.InfoContext(ctx, "Cache epoch changed", slog.Uint64("epoch", epoch), slog.Uint64("previous", current))
+		e.epoch.Store(epoch)
+	}
+	return nil
+}
+
+// read returns the epoch stored in the underlying cache, or 0 if none is.
+func (e *Epoch) read(ctx context.Context) (uint64, error) {
+	r, _, err := e.inner.Open(ctx, epochKey)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, errors.Wrap(err, "failed to open epoch")
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, maxEpochSize))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read epoch")
+	}
+	epoch, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid epoch")
+	}
+	return epoch, nil
+}
+
+// write stores an epoch object in the underlying cache for as long as it allows.
+func (e *Epoch) write(ctx context.Context, key Key, content string) error {
+	w, err := e.inner.Create(ctx, key, http.Header{"Content-Type": {"text/plain"}}, 0)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		return errors.Join(errors.WithStack(err), w.Close())
+	}
+	return errors.WithStack(w.Close())
+}
+
+func (e *Epoch) refreshLoop(ctx context.Context, interval time.Duration) {
+	defer close(e.finished)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := e.CurrentEpoch(ctx); err != nil && ctx.Err() == nil {
+				e.logger.WarnContext(ctx, "Failed to refresh cache epoch", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+func (e *Epoch) String() string { return "epoch:" + e.inner.String() }
+
+func (e *Epoch) Stat(ctx context.Context, key Key) (http.Header, error) {
+	return errors.WithStack2(e.inner.Stat(ctx, e.Key(key)))
+}
+
+func (e *Epoch) Has(ctx context.Context, key Key) (bool, error) {
+	return errors.WithStack2(e.inner.Has(ctx, e.Key(key)))
+}
+
+func (e *Epoch) Open(ctx context.Context, key Key) (io.ReadCloser, http.Header, error) {
+	return errors.WithStack3(e.inner.Open(ctx, e.Key(key)))
+}
+
+// OpenStale opens an object from the underlying cache that may have expired up to "grace" ago. Objects from earlier
+// epochs are never returned.
+func (e *Epoch) OpenStale(ctx context.Context, key Key, grace time.Duration) (io.ReadCloser, http.Header, error) {
+	return errors.WithStack3(OpenStale(ctx, e.inner, e.Key(key), grace))
+}
+
+// Purge removes objects from the underlying cache, including those from earlier epochs.
+func (e *Epoch) Purge(ctx context.Context, options PurgeOptions) (PurgeResult, error) {
+	return errors.WithStack2(Purge(ctx, e.inner, options))
+}
+
+func (e *Epoch) Create(ctx context.Context, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	return errors.WithStack2(e.inner.Create(ctx, e.Key(key), headers, ttl))
+}
+
+// CreateExclusive creates an object only if it does not already exist in the underlying cache.
+func (e *Epoch) CreateExclusive(ctx context.Context, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	return errors.WithStack2(CreateExclusive(ctx, e.inner, e.Key(key), headers, ttl))
+}
+
+func (e *Epoch) Touch(ctx context.Context, key Key, ttl time.Duration) error {
+	return errors.WithStack(e.inner.Touch(ctx, e.Key(key), ttl))
+}
+
+func (e *Epoch) Delete(ctx context.Context, key Key) error {
+	return errors.WithStack(e.inner.Delete(ctx, e.Key(key)))
+}
+
+func (e *Epoch) Stats(ctx context.Context) (Stats, error) {
+	return errors.WithStack2(e.inner.Stats(ctx))
+}
+
+func (e *Epoch) Close() error {
+	e.stop()
+	<-e.finished
+	return errors.WithStack(e.inner.Close())
+}
+
+// epochRangeOpener is an [Epoch] cache over a [RangeOpener].
+type epochRangeOpener struct{ *Epoch }
+
+var _ RangeOpener = epochRangeOpener{}
+
+func (e epochRangeOpener) OpenRange(ctx context.Context, key Key, rng Range) (io.ReadCloser, http.Header, error) {
+	return errors.WithStack3(e.inner.(RangeOpener).OpenRange(ctx, e.Key(key), rng)) //nolint:forcetypeassert
+}
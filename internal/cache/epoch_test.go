@@ -0,0 +1,157 @@
+package cache_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/cache/cachetest"
+	"github.com/block/cachew/internal/logging"
+)
+
+func TestEpochCache(t *testing.T) {
+	cachetest.Suite(t, func(t *testing.T) cache.Cache {
+		_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+		inner, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: 100 * time.Millisecond})
+		assert.NoError(t, err)
+		c, err := cache.NewEpochCache(ctx, inner, time.Hour)
+		assert.NoError(t, err)
+		return c
+	})
+}
+
+func TestEpochInvalidation(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	shared, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer shared.Close()
+
+	tests := []struct {
+		name string
+		// instance returns a cache of a separate instance sharing the shared cache.
+		instance func(t *testing.T) cache.Cache
+	}{
+		{name: "Shared", instance: func(*testing.T) cache.Cache { return shared }},
+		{name: "Tiered", instance: func(t *testing.T) cache.Cache {
+			t.Helper()
+			local, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+			assert.NoError(t, err)
+			tiered, err := cache.MaybeNewTiered(ctx, cache.TieredConfig{}, []cache.Cache{local, shared})
+			assert.NoError(t, err)
+			return tiered
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := cache.NewEpoch(ctx, tt.instance(t), time.Hour)
+			assert.NoError(t, err)
+			b, err := cache.NewEpoch(ctx, tt.instance(t), 10*time.Millisecond)
+			assert.NoError(t, err)
+			start, err := a.CurrentEpoch(ctx)
+			assert.NoError(t, err)
+
+			key := cache.NewKey(t.Name())
+			writeObject(ctx, t, a, key, "first")
+			assertObject(ctx, t, b, key, "first")
+
+			epoch, err := a.AdvanceEpoch(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, start+1, epoch)
+			_, _, err = a.Open(ctx, key)
+			assert.IsError(t, err, os.ErrNotExist)
+
+			// b picks up the epoch in the background.
+			waitForMiss(ctx, t, b, key)
+
+			writeObject(ctx, t, b, key, "second")
+			assertObject(ctx, t, a, key, "second")
+
+			// Advancing from b, whose local tier holds an earlier epoch, is picked up by a and vice versa.
+			epoch, err = b.AdvanceEpoch(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, start+2, epoch)
+			epoch, err = a.AdvanceEpoch(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, start+3, epoch)
+			epoch, err = b.CurrentEpoch(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, start+3, epoch)
+
+			// A new instance starts in the current epoch.
+			c, err := cache.NewEpoch(ctx, tt.instance(t), time.Hour)
+			assert.NoError(t, err)
+			epoch, err = c.CurrentEpoch(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, start+3, epoch)
+		})
+	}
+}
+
+func TestEpochRecoversExpiredEpoch(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	shared, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer shared.Close()
+
+	a, err := cache.NewEpoch(ctx, shared, time.Hour)
+	assert.NoError(t, err)
+	_, err = a.AdvanceEpoch(ctx)
+	assert.NoError(t, err)
+	epoch, err := a.AdvanceEpoch(ctx)
+	assert.NoError(t, err)
+
+	// Purging every object, eg. to free space, removes the epoch objects, which a running instance restores.
+	_, err = cache.Purge(ctx, shared, cache.PurgeOptions{TargetBytes: 1})
+	assert.NoError(t, err)
+	current, err := a.CurrentEpoch(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, epoch, current)
+
+	b, err := cache.NewEpoch(ctx, shared, time.Hour)
+	assert.NoError(t, err)
+	current, err = b.CurrentEpoch(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, epoch, current)
+}
+
+// waitForMiss waits for c to stop serving key, without refreshing its epoch.
+func waitForMiss(ctx context.Context, t *testing.T, c cache.Cache, key cache.Key) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ok, err := c.Has(ctx, key)
+		assert.NoError(t, err)
+		if !ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("object still cached after the epoch was advanced")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func writeObject(ctx context.Context, t *testing.T, c cache.Cache, key cache.Key, content string) {
+	t.Helper()
+	w, err := c.Create(ctx, key, nil, time.Hour)
+	assert.NoError(t, err)
+	_, err = io.WriteString(w, content)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+}
+
+func assertObject(ctx context.Context, t *testing.T, c cache.Cache, key cache.Key, expected string) {
+	t.Helper()
+	r, _, err := c.Open(ctx, key)
+	assert.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(data))
+}
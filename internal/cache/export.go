@@ -0,0 +1,403 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/alecthomas/errors"
+)
+
+// An archive holds the objects of a cache so that they can be loaded into another cache with [Import], eg. to move
+// a cache to an air-gapped network. It is a header line, then for each object a JSON line describing it followed by
+// its body as a sequence of length-prefixed chunks ending with an empty chunk, and finally a trailer line.
+//
+// Bodies are chunked because their size is not known until they have been read, so that they can be streamed rather
+// than buffered.
+const (
+	archiveHeader  = "cachew-archive 1\n"
+	archiveTrailer = "end\n"
+	// maxArchiveChunk bounds the chunks read from an archive.
+	maxArchiveChunk = 1 << 20
+)
+
+// archiveEntry describes an object in an archive.
+type archiveEntry struct {
+	Key       Key         `json:"key"`
+	Headers   http.Header `json:"headers,omitempty"`
+	ExpiresAt time.Time   `json:"expiresAt,omitzero"`
+}
+
+// ErrTruncatedArchive is returned when reading an archive that ends before its trailer.
+var ErrTruncatedArchive = errors.New("archive is truncated")
+
+// ExportResult reports the objects written to an archive by [Export].
+type ExportResult struct {
+	Objects int   `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+	// Resumed is the number of objects already in the archive from an earlier export.
+	Resumed int `json:"resumed"`
+}
+
+// Export writes the unexpired objects of c to the archive at path, creating it if necessary, with their headers and
+// expiry. The cache must implement [Lister].
+//
+// If path holds an archive from an earlier export that was interrupted, or that completed, the objects it holds are
+// kept and only the remaining objects are added, so that re-running an export resumes it. Objects are streamed, so
+// memory use is independent of their size.
+func Export(ctx context.Context, c Cache, path string) (ExportResult, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return ExportResult{}, errors.Wrap(err, "failed to open archive")
+	}
+	defer f.Close()
+
+	exported, offset, err := scanArchive(f)
+	if err != nil {
+		return ExportResult{}, errors.Errorf("%s: %w", path, err)
+	}
+	// Remove the trailer, or a partial object from an interrupted export, so that objects can be appended.
+	if err := f.Truncate(offset); err != nil {
+		return ExportResult{}, errors.Wrap(err, "failed to truncate archive")
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return ExportResult{}, errors.Wrap(err, "failed to seek archive")
+	}
+	w := bufio.NewWriter(f)
+	if offset == 0 {
+		if _, err := w.WriteString(archiveHeader); err != nil {
+			return ExportResult{}, errors.Wrap(err, "failed to write archive")
+		}
+	}
+
+	result := ExportResult{Resumed: len(exported)}
+	for object, err := range ListKeys(ctx, c, "") {
+		if err != nil {
+			// Objects written so far are kept so that the export can be resumed.
+			return result, errors.Join(errors.Wrap(err, "failed to list objects"), w.Flush())
+		}
+		if exported[object.Key] {
+			continue
+		}
+		n, ok, err := exportObject(ctx, c, w, object)
+		if err != nil {
+			return result, errors.Join(errors.Errorf("%s: %w", object.Key, err), w.Flush())
+		} else if ok {
+			result.Objects++
+			result.Bytes += n
+		}
+	}
+	if _, err := w.WriteString(archiveTrailer); err != nil {
+		return result, errors.Wrap(err, "failed to write archive")
+	}
+	if err := w.Flush(); err != nil {
+		return result, errors.Wrap(err, "failed to write archive")
+	}
+	return result, errors.Wrap(f.Sync(), "failed to sync archive")
+}
+
+// exportObject writes object to w, returning its size and false if it no longer exists.
+func exportObject(ctx context.Context, c Cache, w *bufio.Writer, object ObjectInfo) (int64, bool, error) {
+	r, _, err := c.Open(ctx, object.Key)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, false, nil // Expired or deleted since it was listed.
+	} else if err != nil {
+		return 0, false, errors.Wrap(err, "failed to open object")
+	}
+	defer r.Close()
+	// Headers are those listed, as those returned by Open may include transport headers of a remote cache.
+	line, err := json.Marshal(&archiveEntry{Key: object.Key, Headers: object.Headers, ExpiresAt: object.ExpiresAt})
+	if err != nil {
+		return 0, false, errors.WithStack(err)
+	}
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		return 0, false, errors.Wrap(err, "failed to write archive")
+	}
+	n, err := io.CopyBuffer(chunkWriter{w}, r, make([]byte, 32*1024))
+	if err != nil {
+		return n, false, errors.Wrap(err, "failed to copy object")
+	}
+	return n, true, errors.Wrap(writeChunk(w, nil), "failed to write archive")
+}
+
+// scanArchive reads the archive in f, returning the keys of the complete objects it holds and the offset following
+// the last of them, or 0 if f is empty.
+func scanArchive(f *os.File) (map[Key]bool, int64, error) {
+	exported := map[Key]bool{}
+	r := newArchiveReader(f)
+	if err := r.readHeader(); errors.Is(err, io.EOF) {
+		return exported, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	offset := r.offset
+	for {
+		entry, err := r.next()
+		if errors.Is(err, io.EOF) || errors.Is(err, ErrTruncatedArchive) {
+			return exported, offset, nil
+		} else if err != nil {
+			return nil, 0, err
+		}
+		if _, err := io.Copy(io.Discard, r.body()); errors.Is(err, ErrTruncatedArchive) {
+			return exported, offset, nil
+		} else if err != nil {
+			return nil, 0, err
+		}
+		exported[entry.Key] = true
+		offset = r.offset
+	}
+}
+
+// ImportResult reports the objects read from an archive by [Import].
+type ImportResult struct {
+	Objects int   `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+	// Existing is the number of objects skipped because they were already cached, eg. by an interrupted import.
+	Existing int `json:"existing"`
+	// Expired is the number of objects skipped because they expired after they were exported.
+	Expired int `json:"expired"`
+}
+
+// Import creates the objects in the archive read from r in c, with their headers and remaining time to live.
+//
+// Objects that already exist in c are skipped, so that re-running an import resumes it. Objects without a recorded
+// expiry are created with the maximum time to live of c.
+func Import(ctx context.Context, c Cache, r io.Reader) (ImportResult, error) {
+	ar := newArchiveReader(r)
+	if err := ar.readHeader(); errors.Is(err, io.EOF) {
+		return ImportResult{}, errors.WithStack(ErrTruncatedArchive)
+	} else if err != nil {
+		return ImportResult{}, err
+	}
+	var result ImportResult
+	for {
+		entry, err := ar.next()
+		if errors.Is(err, io.EOF) {
+			return result, nil
+		} else if err != nil {
+			return result, err
+		}
+		ttl, expired := entry.ttl()
+		if expired {
+			result.Expired++
+			if _, err := io.Copy(io.Discard, ar.body()); err != nil {
+				return result, err
+			}
+			continue
+		}
+		n, created, err := importObject(ctx, c, entry, ttl, ar.body())
+		if err != nil {
+			return result, errors.Errorf("%s: %w", entry.Key, err)
+		}
+		if created {
+			result.Objects++
+			result.Bytes += n
+		} else {
+			result.Existing++
+		}
+	}
+}
+
+// PlanImport reports what [Import] would do with the archive read from r without writing to c, returning the objects
+// that would be created along with the result the import would have.
+//
+// Objects are checked against c as the archive is read, so the plan is only accurate if c is not concurrently
+// modified.
+func PlanImport(ctx context.Context, c Cache, r io.Reader) ([]ObjectInfo, ImportResult, error) {
+	ar := newArchiveReader(r)
+	if err := ar.readHeader(); errors.Is(err, io.EOF) {
+		return nil, ImportResult{}, errors.WithStack(ErrTruncatedArchive)
+	} else if err != nil {
+		return nil, ImportResult{}, err
+	}
+	var (
+		objects []ObjectInfo
+		result  ImportResult
+	)
+	for {
+		entry, err := ar.next()
+		if errors.Is(err, io.EOF) {
+			return objects, result, nil
+		} else if err != nil {
+			return objects, result, err
+		}
+		n, err := io.Copy(io.Discard, ar.body())
+		if err != nil {
+			return objects, result, err
+		}
+		if _, expired := entry.ttl(); expired {
+			result.Expired++
+			continue
+		}
+		exists, err := c.Has(ctx, entry.Key)
+		if err != nil {
+			return objects, result, errors.Errorf("%s: failed to check object: %w", entry.Key, err)
+		}
+		if exists {
+			result.Existing++
+			continue
+		}
+		objects = append(objects, ObjectInfo{Key: entry.Key, Size: n, Headers: entry.Headers, ExpiresAt: entry.ExpiresAt})
+		result.Objects++
+		result.Bytes += n
+	}
+}
+
+// ttl returns the remaining time to live of an archived object, which is zero if it has no recorded expiry, and true
+// if it has expired.
+func (e archiveEntry) ttl() (time.Duration, bool) {
+	if e.ExpiresAt.IsZero() {
+		return 0, false
+	}
+	ttl := time.Until(e.ExpiresAt)
+	return ttl, ttl <= 0
+}
+
+// importObject creates the object described by entry from body, returning false if it already exists.
+func importObject(ctx context.Context, c Cache, entry archiveEntry, ttl time.Duration, body io.Reader) (int64, bool, error) {
+	// Cancelling the object's context abandons it, so that a partially read object is never committed.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, created, err := CreateIfAbsent(ctx, c, entry.Key, entry.Headers, ttl)
+	if err != nil {
+		return 0, false, errors.Wrap(err, "failed to create object")
+	}
+	n, err := io.Copy(w, body)
+	if err != nil {
+		cancel()
+		return n, false, errors.Join(err, w.Close())
+	}
+	if err := w.Close(); errors.Is(err, ErrExists) {
+		return n, false, nil
+	} else if err != nil {
+		return n, false, errors.Wrap(err, "failed to create object")
+	}
+	return n, created, nil
+}
+
+// archiveReader reads the objects of an archive in order, tracking the offset of what it has read.
+type archiveReader struct {
+	r      *bufio.Reader
+	offset int64
+}
+
+func newArchiveReader(r io.Reader) *archiveReader {
+	return &archiveReader{r: bufio.NewReader(r)}
+}
+
+// readHeader reads the archive header, returning [io.EOF] if the archive is empty.
+func (a *archiveReader) readHeader() error {
+	line, err := a.readLine()
+	if errors.Is(err, io.EOF) && line == "" {
+		return io.EOF
+	} else if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if line != archiveHeader {
+		return errors.New("not a cachew archive")
+	}
+	return nil
+}
+
+// next reads the description of the next object, after which its body must be read with body. Returns [io.EOF] at
+// the trailer.
+func (a *archiveReader) next() (archiveEntry, error) {
+	line, err := a.readLine()
+	if errors.Is(err, io.EOF) {
+		return archiveEntry{}, errors.WithStack(ErrTruncatedArchive)
+	} else if err != nil {
+		return archiveEntry{}, err
+	}
+	if line == archiveTrailer {
+		return archiveEntry{}, io.EOF
+	}
+	var entry archiveEntry
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		return archiveEntry{}, errors.Wrap(err, "invalid archive entry")
+	}
+	return entry, nil
+}
+
+// body returns a reader for the body of the object most recently returned by next.
+func (a *archiveReader) body() io.Reader { return &chunkReader{a: a} }
+
+func (a *archiveReader) readLine() (string, error) {
+	line, err := a.r.ReadString('\n')
+	a.offset += int64(len(line))
+	if err != nil {
+		return line, errors.WithStack(err)
+	}
+	return line, nil
+}
+
+// chunkReader reads a chunked body, returning [io.EOF] at its empty chunk.
+type chunkReader struct {
+	a         *archiveReader
+	remaining uint32
+	done      bool
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		var size [4]byte
+		if _, err := io.ReadFull(c.a.r, size[:]); err != nil {
+			return 0, truncated(err)
+		}
+		c.a.offset += int64(len(size))
+		c.remaining = binary.BigEndian.Uint32(size[:])
+		if c.remaining > maxArchiveChunk {
+			return 0, errors.Errorf("invalid archive chunk of %d bytes", c.remaining)
+		}
+		c.done = c.remaining == 0
+	}
+	n, err := c.a.r.Read(p[:min(len(p), int(c.remaining))])
+	c.a.offset += int64(n)
+	c.remaining -= uint32(n) //nolint:gosec // n is at most remaining.
+	switch {
+	case errors.Is(err, io.EOF) && c.remaining > 0:
+		return n, errors.WithStack(ErrTruncatedArchive)
+	case err != nil && !errors.Is(err, io.EOF):
+		return n, errors.WithStack(err)
+	}
+	return n, nil
+}
+
+// truncated returns err as [ErrTruncatedArchive] if it indicates the archive ended early.
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return errors.WithStack(ErrTruncatedArchive)
+	}
+	return errors.WithStack(err)
+}
+
+// chunkWriter writes each write as one or more chunks of a chunked body.
+type chunkWriter struct{ w *bufio.Writer }
+
+func (c chunkWriter) Write(p []byte) (int, error) {
+	for chunk := range slices.Chunk(p, maxArchiveChunk) {
+		if err := writeChunk(c.w, chunk); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// writeChunk writes a chunk of a chunked body, which ends the body if empty.
+func writeChunk(w *bufio.Writer, chunk []byte) error {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(chunk))) //nolint:gosec // Chunks are at most maxArchiveChunk.
+	if _, err := w.Write(size[:]); err != nil {
+		return errors.WithStack(err)
+	}
+	_, err := w.Write(chunk)
+	return errors.WithStack(err)
+}
@@ -0,0 +1,172 @@
+package cache_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/logging"
+)
+
+func TestExportImport(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	src, err := cache.NewMemory(ctx, cache.MemoryConfig{LimitMB: 16, MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer src.Close()
+
+	objects := map[string]string{
+		"empty": "",
+		"small": "hello",
+		"large": string(make([]byte, 3<<20)), // Spans several chunks.
+	}
+	for name, body := range objects {
+		storeObject(t, src, name, http.Header{"Content-Type": {"text/" + name}}, body, 30*time.Minute)
+	}
+
+	path := filepath.Join(t.TempDir(), "cache.archive")
+	exported, err := cache.Export(ctx, src, path)
+	assert.NoError(t, err)
+	assert.Equal(t, cache.ExportResult{Objects: 3, Bytes: 3<<20 + 5}, exported)
+
+	dst, err := cache.NewDisk(ctx, cache.DiskConfig{Root: t.TempDir(), MaxTTL: 2 * time.Hour})
+	assert.NoError(t, err)
+	defer dst.Close()
+
+	imported := importArchive(t, dst, path)
+	assert.Equal(t, cache.ImportResult{Objects: 3, Bytes: 3<<20 + 5}, imported)
+
+	for name, body := range objects {
+		r, headers, err := dst.Open(ctx, cache.NewKey(name))
+		assert.NoError(t, err, name)
+		data, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.NoError(t, r.Close())
+		assert.Equal(t, body, string(data), name)
+		assert.Equal(t, "text/"+name, headers.Get("Content-Type"), name)
+	}
+	// Objects keep the remaining time to live they had when exported, rather than the maximum of the destination.
+	for object, err := range cache.ListKeys(ctx, dst, "") {
+		assert.NoError(t, err)
+		assert.True(t, time.Until(object.ExpiresAt) <= 30*time.Minute, "%s expires at %s", object.Key, object.ExpiresAt)
+		assert.True(t, time.Until(object.ExpiresAt) > 29*time.Minute, "%s expires at %s", object.Key, object.ExpiresAt)
+	}
+
+	// Importing again skips the objects that already exist.
+	imported = importArchive(t, dst, path)
+	assert.Equal(t, cache.ImportResult{Existing: 3}, imported)
+}
+
+func TestExportResume(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	src, err := cache.NewMemory(ctx, cache.MemoryConfig{LimitMB: 16, MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer src.Close()
+	for _, name := range []string{"a", "b", "c"} {
+		storeObject(t, src, name, http.Header{}, name+" body", time.Hour)
+	}
+
+	path := filepath.Join(t.TempDir(), "cache.archive")
+	_, err = cache.Export(ctx, src, path)
+	assert.NoError(t, err)
+
+	// Simulate an export interrupted part way through an object.
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Truncate(path, info.Size()-10))
+	_, err = cache.Import(ctx, src, openArchive(t, path))
+	assert.IsError(t, err, cache.ErrTruncatedArchive)
+
+	exported, err := cache.Export(ctx, src, path)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, exported.Resumed)
+	assert.Equal(t, 1, exported.Objects)
+
+	dst, err := cache.NewMemory(ctx, cache.MemoryConfig{LimitMB: 16, MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer dst.Close()
+	imported := importArchive(t, dst, path)
+	assert.Equal(t, 3, imported.Objects)
+}
+
+func TestPlanImport(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	src, err := cache.NewMemory(ctx, cache.MemoryConfig{LimitMB: 16, MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer src.Close()
+	for _, name := range []string{"cached", "new", "newer"} {
+		storeObject(t, src, name, http.Header{}, name+" body", time.Hour)
+	}
+	storeObject(t, src, "expiring", http.Header{}, "expiring body", 100*time.Millisecond)
+	path := filepath.Join(t.TempDir(), "cache.archive")
+	_, err = cache.Export(ctx, src, path)
+	assert.NoError(t, err)
+	time.Sleep(150 * time.Millisecond)
+
+	memory, err := cache.NewMemory(ctx, cache.MemoryConfig{LimitMB: 16, MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer memory.Close()
+	storeObject(t, memory, "cached", http.Header{}, "cached body", time.Hour)
+	dst := &createCountingCache{Cache: memory}
+
+	objects, result, err := cache.PlanImport(ctx, dst, openArchive(t, path))
+	assert.NoError(t, err)
+	assert.Equal(t, cache.ImportResult{Objects: 2, Bytes: int64(len("new body") + len("newer body")), Existing: 1, Expired: 1}, result)
+	planned := map[cache.Key]int64{}
+	for _, object := range objects {
+		planned[object.Key] = object.Size
+	}
+	assert.Equal(t, map[cache.Key]int64{cache.NewKey("new"): 8, cache.NewKey("newer"): 10}, planned)
+	assert.Equal(t, int32(0), dst.creates.Load())
+	exists, err := memory.Has(ctx, cache.NewKey("new"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// The import creates exactly the planned objects.
+	imported := importArchive(t, dst, path)
+	assert.Equal(t, result, imported)
+	assert.Equal(t, int32(2), dst.creates.Load())
+}
+
+// createCountingCache counts writes.
+type createCountingCache struct {
+	cache.Cache
+	creates atomic.Int32
+}
+
+func (c *createCountingCache) Create(ctx context.Context, key cache.Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	c.creates.Add(1)
+	return c.Cache.Create(ctx, key, headers, ttl)
+}
+
+func storeObject(t *testing.T, c cache.Cache, name string, headers http.Header, body string, ttl time.Duration) {
+	t.Helper()
+	w, err := c.Create(t.Context(), cache.NewKey(name), headers, ttl)
+	assert.NoError(t, err)
+	_, err = io.WriteString(w, body)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+}
+
+func importArchive(t *testing.T, c cache.Cache, path string) cache.ImportResult {
+	t.Helper()
+	result, err := cache.Import(t.Context(), c, openArchive(t, path))
+	assert.NoError(t, err)
+	return result
+}
+
+func openArchive(t *testing.T, path string) io.Reader {
+	t.Helper()
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = f.Close() })
+	return f
+}
@@ -0,0 +1,56 @@
+package cache_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/cache"
+)
+
+// setKeyLength sets the key length for the duration of the test.
+func setKeyLength(t *testing.T, length int) {
+	t.Helper()
+	assert.NoError(t, cache.SetKeyLength(length))
+	t.Cleanup(func() { assert.NoError(t, cache.SetKeyLength(cache.DefaultKeyLength)) })
+}
+
+func TestKeyLength(t *testing.T) {
+	full := cache.NewKey("github.com/block/cachew")
+	fullHex := full.String()
+	for _, length := range []int{cache.MinKeyLength, 24, cache.DefaultKeyLength} {
+		t.Run(strconv.Itoa(length), func(t *testing.T) {
+			setKeyLength(t, length)
+
+			seen := map[string]bool{}
+			for i := range 100000 {
+				key := cache.NewKey("https://example.com/object/" + strconv.Itoa(i))
+				seen[key.String()] = true
+				if len(key.String()) != 2*length {
+					t.Fatalf("expected a %d byte key, got %s", length, key.String())
+				}
+			}
+			assert.Equal(t, 100000, len(seen), "keys collided at length %d", length)
+
+			key := cache.NewKey("object")
+			parsed, err := cache.ParseKey(key.String())
+			assert.NoError(t, err)
+			assert.Equal(t, key, parsed)
+
+			// Keys are prefixes of the full hash, but hex of any other length is text rather than a key.
+			key = cache.NewKey("github.com/block/cachew")
+			assert.Equal(t, fullHex[:2*length], key.String())
+			parsed, err = cache.ParseKey(fullHex)
+			assert.NoError(t, err)
+			if length == cache.DefaultKeyLength {
+				assert.Equal(t, key, parsed)
+			} else {
+				assert.Equal(t, cache.NewKey(fullHex), parsed)
+			}
+		})
+	}
+
+	assert.Error(t, cache.SetKeyLength(cache.MinKeyLength-1))
+	assert.Error(t, cache.SetKeyLength(cache.DefaultKeyLength+1))
+}
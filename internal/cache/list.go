@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"context"
+	"iter"
+	"strings"
+
+	"github.com/alecthomas/errors"
+)
+
+// ErrInvalidKeyPrefix is returned when listing keys with a prefix that is not hexadecimal.
+var ErrInvalidKeyPrefix = errors.New("invalid key prefix")
+
+// Lister is implemented by caches that can enumerate their objects.
+type Lister interface {
+	// ListKeys returns the unexpired objects whose hex-encoded keys start with prefix, with their sizes and headers.
+	//
+	// Ordering is unspecified. If listing fails the error is yielded and iteration ends, so a listing is only
+	// complete if the iterator ends without yielding an error.
+	ListKeys(ctx context.Context, prefix string) iter.Seq2[ObjectInfo, error]
+}
+
+// ListKeys returns the unexpired objects in c whose hex-encoded keys start with prefix. See [Lister.ListKeys].
+//
+// Yields [ErrListUnavailable] if the cache does not implement [Lister].
+func ListKeys(ctx context.Context, c Cache, prefix string) iter.Seq2[ObjectInfo, error] {
+	prefix = strings.ToLower(prefix)
+	if !isKeyPrefix(prefix) {
+		return func(yield func(ObjectInfo, error) bool) {
+			yield(ObjectInfo{}, errors.Errorf("%w %q, must be hexadecimal", ErrInvalidKeyPrefix, prefix))
+		}
+	}
+	if l, ok := c.(Lister); ok {
+		return l.ListKeys(ctx, prefix)
+	}
+	return func(yield func(ObjectInfo, error) bool) {
+		yield(ObjectInfo{}, errors.Errorf("%s: %w", c.String(), ErrListUnavailable))
+	}
+}
+
+// isKeyPrefix returns true if prefix is a lowercase hex prefix of a key.
+func isKeyPrefix(prefix string) bool {
+	return len(prefix) <= 2*len(Key{}) && !strings.ContainsFunc(prefix, func(r rune) bool {
+		return (r < '0' || r > '9') && (r < 'a' || r > 'f')
+	})
+}
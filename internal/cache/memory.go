@@ -3,11 +3,14 @@ package cache
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"fmt"
 	"io"
+	"iter"
 	"maps"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -28,11 +31,28 @@ func RegisterMemory(r *Registry) {
 type MemoryConfig struct {
 	LimitMB int           `hcl:"limit-mb,optional" help:"Maximum size of the disk cache in megabytes (defaults to 1GB)." default:"1024"`
 	MaxTTL  time.Duration `hcl:"max-ttl,optional" help:"Maximum time-to-live for entries in the disk cache (defaults to 1 hour)." default:"1h"`
+	// SlidingExpiration extends an entry's expiry by its TTL whenever it is opened, so that frequently read entries
+	// are retained. By default entries expire their TTL after they were written or last touched, however often read.
+	SlidingExpiration bool `hcl:"sliding-expiration,optional" help:"Extend an entry's expiry by its TTL each time it is opened (defaults to false)."`
+}
+
+// Validate the configuration.
+func (c *MemoryConfig) Validate() error {
+	var errs []error
+	if c.LimitMB <= 0 {
+		errs = append(errs, errors.New("limit-mb must be positive"))
+	}
+	if c.MaxTTL <= 0 {
+		errs = append(errs, errors.New("max-ttl must be positive"))
+	}
+	return errors.Join(errs...)
 }
 
 type memoryEntry struct {
 	data      []byte
+	createdAt time.Time
 	expiresAt time.Time
+	ttl       time.Duration
 	headers   http.Header
 }
 
@@ -43,6 +63,13 @@ type Memory struct {
 	currentSize int64
 }
 
+var (
+	_ Cache            = (*Memory)(nil)
+	_ ExclusiveCreator = (*Memory)(nil)
+	_ TagLister        = (*Memory)(nil)
+	_ Lister           = (*Memory)(nil)
+)
+
 func NewMemory(ctx context.Context, config MemoryConfig) (*Memory, error) {
 	logging.FromContext(ctx).InfoContext(ctx, "Constructing in-memory Cache", "limit-mb", config.LimitMB, "max-ttl", config.MaxTTL)
 	return &Memory{
@@ -69,7 +96,77 @@ func (m *Memory) Stat(_ context.Context, key Key) (http.Header, error) {
 	return entry.headers, nil
 }
 
+// ExpiresAt returns when an entry expires.
+func (m *Memory) ExpiresAt(_ context.Context, key Key) (time.Time, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entry, exists := m.entries[key]
+	if !exists || time.Now().After(entry.expiresAt) {
+		return time.Time{}, os.ErrNotExist
+	}
+	return entry.expiresAt, nil
+}
+
+func (m *Memory) Has(_ context.Context, key Key) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry, exists := m.entries[key]
+	return exists && time.Now().Before(entry.expiresAt), nil
+}
+
 func (m *Memory) Open(_ context.Context, key Key) (io.ReadCloser, http.Header, error) {
+	entry, err := m.openEntry(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return io.NopCloser(bytes.NewReader(entry.data)), entry.headers, nil
+}
+
+// openEntry returns an unexpired entry, extending its expiry if SlidingExpiration is enabled.
+func (m *Memory) openEntry(key Key) (*memoryEntry, error) {
+	if m.config.SlidingExpiration {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+	} else {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+	}
+
+	entry, exists := m.entries[key]
+	if !exists {
+		return nil, os.ErrNotExist
+	}
+
+	now := time.Now()
+	if now.After(entry.expiresAt) {
+		return nil, os.ErrNotExist
+	}
+	if m.config.SlidingExpiration {
+		entry.expiresAt = now.Add(entry.ttl)
+	}
+	return entry, nil
+}
+
+// OpenRange opens part of an entry.
+func (m *Memory) OpenRange(_ context.Context, key Key, rng Range) (io.ReadCloser, http.Header, error) {
+	entry, err := m.openEntry(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	size := int64(len(entry.data))
+	offset, length, err := rng.Resolve(size)
+	if err != nil {
+		return nil, nil, err
+	}
+	headers := entry.headers.Clone()
+	SetRangeHeaders(headers, offset, length, size)
+	return io.NopCloser(bytes.NewReader(entry.data[offset : offset+length])), headers, nil
+}
+
+// OpenStale opens an entry even if it has expired, provided it expired less than grace ago.
+func (m *Memory) OpenStale(_ context.Context, key Key, grace time.Duration) (io.ReadCloser, http.Header, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -78,7 +175,7 @@ func (m *Memory) Open(_ context.Context, key Key) (io.ReadCloser, http.Header, e
 		return nil, nil, os.ErrNotExist
 	}
 
-	if time.Now().After(entry.expiresAt) {
+	if time.Now().After(entry.expiresAt.Add(grace)) {
 		return nil, nil, os.ErrNotExist
 	}
 
@@ -86,6 +183,15 @@ func (m *Memory) Open(_ context.Context, key Key) (io.ReadCloser, http.Header, e
 }
 
 func (m *Memory) Create(ctx context.Context, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	return m.create(ctx, key, headers, ttl, false), nil
+}
+
+// CreateExclusive creates an entry that is only committed if no unexpired entry with the same key exists.
+func (m *Memory) CreateExclusive(ctx context.Context, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	return m.create(ctx, key, headers, ttl, true), nil
+}
+
+func (m *Memory) create(ctx context.Context, key Key, headers http.Header, ttl time.Duration, exclusive bool) *memoryWriter {
 	if ttl == 0 {
 		ttl = m.config.MaxTTL
 	}
@@ -102,12 +208,32 @@ func (m *Memory) Create(ctx context.Context, key Key, headers http.Header, ttl t
 		cache:     m,
 		key:       key,
 		buf:       &bytes.Buffer{},
+		createdAt: now,
 		expiresAt: now.Add(ttl),
+		ttl:       ttl,
 		headers:   clonedHeaders,
+		exclusive: exclusive,
 		ctx:       ctx,
 	}
 
-	return writer, nil
+	return writer
+}
+
+func (m *Memory) Touch(_ context.Context, key Key, ttl time.Duration) error {
+	if ttl == 0 {
+		ttl = m.config.MaxTTL
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, exists := m.entries[key]
+	if !exists || time.Now().After(entry.expiresAt) {
+		return os.ErrNotExist
+	}
+	entry.expiresAt = time.Now().Add(ttl)
+	entry.ttl = ttl
+	return nil
 }
 
 func (m *Memory) Delete(_ context.Context, key Key) error {
@@ -142,7 +268,80 @@ func (m *Memory) Stats(_ context.Context) (Stats, error) {
 	}, nil
 }
 
-func (m *Memory) evictOldest(neededSpace int64) {
+// Purge removes entries written more than OlderThan ago or created with Tag, or both if both are set, then evicts
+// entries in expiry order until the cache is no larger than TargetBytes.
+func (m *Memory) Purge(_ context.Context, options PurgeOptions) (PurgeResult, error) {
+	if err := options.validate(); err != nil {
+		return PurgeResult{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result PurgeResult
+	if options.OlderThan > 0 || options.Tag != "" {
+		cutoff := time.Now().Add(-options.OlderThan)
+		for k, e := range m.entries {
+			if options.OlderThan > 0 && !e.createdAt.Before(cutoff) {
+				continue
+			}
+			if options.Tag != "" && !hasTag(e.headers, options.Tag) {
+				continue
+			}
+			size := int64(len(e.data))
+			m.currentSize -= size
+			delete(m.entries, k)
+			result.Objects++
+			result.Bytes += size
+		}
+	}
+	if options.TargetBytes > 0 && m.currentSize > options.TargetBytes {
+		evicted := m.evictOldest(m.currentSize - options.TargetBytes)
+		result.Objects += evicted.Objects
+		result.Bytes += evicted.Bytes
+	}
+	return result, nil
+}
+
+// ListTagged returns the unexpired entries created with tag.
+func (m *Memory) ListTagged(_ context.Context, tag string) ([]ObjectInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	var objects []ObjectInfo
+	for k, e := range m.entries {
+		if now.After(e.expiresAt) || !hasTag(e.headers, tag) {
+			continue
+		}
+		objects = append(objects, ObjectInfo{Key: k, Size: int64(len(e.data))})
+	}
+	return objects, nil
+}
+
+// ListKeys returns the unexpired entries whose keys start with prefix.
+//
+// The entries are collected under the lock when iteration starts, so that the cache may be used while iterating.
+func (m *Memory) ListKeys(_ context.Context, prefix string) iter.Seq2[ObjectInfo, error] {
+	return func(yield func(ObjectInfo, error) bool) {
+		m.mu.RLock()
+		now := time.Now()
+		var objects []ObjectInfo
+		for k, e := range m.entries {
+			if now.After(e.expiresAt) || !strings.HasPrefix(k.String(), prefix) {
+				continue
+			}
+			objects = append(objects, ObjectInfo{Key: k, Size: int64(len(e.data)), Headers: e.headers.Clone(), ExpiresAt: e.expiresAt})
+		}
+		m.mu.RUnlock()
+		for _, object := range objects {
+			if !yield(object, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (m *Memory) evictOldest(neededSpace int64) PurgeResult {
 	type entryInfo struct {
 		key       Key
 		size      int64
@@ -167,24 +366,29 @@ func (m *Memory) evictOldest(neededSpace int64) {
 		}
 	}
 
-	freedSpace := int64(0)
+	var result PurgeResult
 	for _, e := range entries {
-		if freedSpace >= neededSpace {
+		if result.Bytes >= neededSpace {
 			break
 		}
 		m.currentSize -= e.size
 		delete(m.entries, e.key)
-		freedSpace += e.size
+		result.Objects++
+		result.Bytes += e.size
 	}
+	return result
 }
 
 type memoryWriter struct {
 	cache     *Memory
 	key       Key
 	buf       *bytes.Buffer
+	createdAt time.Time
 	expiresAt time.Time
+	ttl       time.Duration
 	headers   http.Header
 	closed    bool
+	exclusive bool // Only commit if no unexpired entry exists.
 	ctx       context.Context
 }
 
@@ -206,15 +410,26 @@ func (w *memoryWriter) Close() error {
 		return errors.Wrap(err, "create operation cancelled")
 	}
 
+	digest := sha256.Sum256(w.buf.Bytes())
+	recordBody(w.headers, digest[:], int64(w.buf.Len()))
+
 	w.cache.mu.Lock()
 	defer w.cache.mu.Unlock()
 
+	if w.cache.entries == nil {
+		return errors.New("cache is closed")
+	}
+
 	newSize := int64(w.buf.Len())
 	limitBytes := int64(w.cache.config.LimitMB) * 1024 * 1024
 
 	// Remove old entry size if it exists
 	oldSize := int64(0)
 	if oldEntry, exists := w.cache.entries[w.key]; exists {
+		if w.exclusive && time.Now().Before(oldEntry.expiresAt) {
+			w.buf.Reset()
+			return errors.WithStack(ErrExists)
+		}
 		oldSize = int64(len(oldEntry.data))
 	}
 
@@ -233,7 +448,9 @@ func (w *memoryWriter) Close() error {
 	w.buf.Reset()
 	w.cache.entries[w.key] = &memoryEntry{
 		data:      data,
+		createdAt: w.createdAt,
 		expiresAt: w.expiresAt,
+		ttl:       w.ttl,
 		headers:   w.headers,
 	}
 	w.cache.currentSize += newSize
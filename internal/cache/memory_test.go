@@ -22,6 +22,13 @@ func TestMemoryCache(t *testing.T) {
 	})
 }
 
+func TestMemoryCacheSlidingExpiration(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	c, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Second, SlidingExpiration: true})
+	assert.NoError(t, err)
+	cachetest.SlidingExpiration(t, c, 200*time.Millisecond)
+}
+
 func TestMemoryCacheSoak(t *testing.T) {
 	if os.Getenv("SOAK_TEST") == "" {
 		t.Skip("Skipping soak test; set SOAK_TEST=1 to run")
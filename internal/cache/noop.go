@@ -26,6 +26,10 @@ func (n *noOpCache) Stat(_ context.Context, _ Key) (http.Header, error) {
 	return nil, os.ErrNotExist
 }
 
+func (n *noOpCache) Has(_ context.Context, _ Key) (bool, error) {
+	return false, nil
+}
+
 func (n *noOpCache) Open(_ context.Context, _ Key) (io.ReadCloser, http.Header, error) {
 	return nil, nil, os.ErrNotExist
 }
@@ -35,6 +39,10 @@ func (n *noOpCache) Create(_ context.Context, _ Key, _ http.Header, _ time.Durat
 	return &noOpWriter{}, nil
 }
 
+func (n *noOpCache) Touch(_ context.Context, _ Key, _ time.Duration) error {
+	return os.ErrNotExist
+}
+
 func (n *noOpCache) Delete(_ context.Context, _ Key) error {
 	return nil
 }
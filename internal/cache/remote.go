@@ -1,25 +1,56 @@
 package cache
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
+	"iter"
 	"maps"
 	"net/http"
+	neturl "net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/retry"
 )
 
+// UploadOffsetHeader carries the number of bytes committed to a resumable upload.
+const UploadOffsetHeader = "Upload-Offset"
+
+// UploadDigestHeader carries the hex SHA-256 digest of the bytes committed to a resumable upload.
+const UploadDigestHeader = "Upload-Digest"
+
+// uploadRetry retries the requests of resumable uploads.
+var uploadRetry = retry.Config{MaxAttempts: 8, InitialBackoff: 250 * time.Millisecond, MaxBackoff: 10 * time.Second} //nolint:gochecknoglobals
+
+// errUploadRejected is returned for requests of resumable uploads that the remote rejected, which are not retried.
+var errUploadRejected = errors.New("upload rejected")
+
+// errUploadMismatch is returned when the data written to resume an upload differs from the data already committed.
+var errUploadMismatch = errors.New("object differs from its incomplete upload")
+
 // Remote implements Cache as a client for the remote cache server.
 type Remote struct {
-	baseURL string
-	client  *http.Client
+	baseURL   string
+	client    *http.Client
+	chunkSize int
+	token     string
 }
 
-var _ Cache = (*Remote)(nil)
+var (
+	_ Cache            = (*Remote)(nil)
+	_ ExclusiveCreator = (*Remote)(nil)
+	_ Lister           = (*Remote)(nil)
+)
 
 // NewRemote creates a new remote cache client.
 func NewRemote(baseURL string) *Remote {
@@ -35,6 +66,38 @@ func NewRemote(baseURL string) *Remote {
 
 func (c *Remote) String() string { return "remote:" + c.baseURL }
 
+// WithChunkSize returns a copy of the client that uploads objects in chunks of size bytes with the resumable upload
+// API.
+//
+// Each chunk is buffered in memory and retried until the remote commits it, so that an upload interrupted by an
+// unreliable link resumes from its last committed chunk rather than restarting. The object is created once every
+// chunk has been committed.
+//
+// An upload that fails is kept by the remote, and resumed by the next writer of the same key: the bytes the remote
+// has already committed are checked against its digest of them rather than sent again. If they differ, the write
+// fails and the incomplete upload is discarded. Cancelling the writer's context, or calling [Remote.AbortUpload],
+// also discards it.
+func (c *Remote) WithChunkSize(size int) *Remote {
+	chunked := *c
+	chunked.chunkSize = size
+	return &chunked
+}
+
+// WithToken returns a copy of the client that authenticates requests to the remote's admin endpoints, such as
+// listing keys and stats, with the bearer token.
+func (c *Remote) WithToken(token string) *Remote {
+	authenticated := *c
+	authenticated.token = token
+	return &authenticated
+}
+
+// authorize adds the client's admin token, if any, to req.
+func (c *Remote) authorize(req *http.Request) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+}
+
 // Open retrieves an object from the remote.
 func (c *Remote) Open(ctx context.Context, key Key) (io.ReadCloser, http.Header, error) {
 	url := fmt.Sprintf("%s/object/%s", c.baseURL, key.String())
@@ -92,8 +155,30 @@ func (c *Remote) Stat(ctx context.Context, key Key) (http.Header, error) {
 	return headers, nil
 }
 
+// Has checks for the object with a HEAD request.
+func (c *Remote) Has(ctx context.Context, key Key) (bool, error) {
+	_, err := c.Stat(ctx, key)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 // Create stores a new object in the remote.
 func (c *Remote) Create(ctx context.Context, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	return c.create(ctx, key, headers, ttl, false)
+}
+
+// CreateExclusive creates an object in the remote with "If-None-Match: *", so that it is only committed if it does
+// not already exist.
+func (c *Remote) CreateExclusive(ctx context.Context, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	return c.create(ctx, key, headers, ttl, true)
+}
+
+func (c *Remote) create(ctx context.Context, key Key, headers http.Header, ttl time.Duration, exclusive bool) (io.WriteCloser, error) {
+	if c.chunkSize > 0 {
+		return c.createChunked(ctx, key, headers, ttl, exclusive)
+	}
 	pr, pw := io.Pipe()
 
 	url := fmt.Sprintf("%s/object/%s", c.baseURL, key.String())
@@ -107,6 +192,9 @@ func (c *Remote) Create(ctx context.Context, key Key, headers http.Header, ttl t
 	if ttl > 0 {
 		req.Header.Set("Time-To-Live", ttl.String())
 	}
+	if exclusive {
+		req.Header.Set("If-None-Match", "*")
+	}
 
 	wc := &writeCloser{
 		pw:   pw,
@@ -123,6 +211,10 @@ func (c *Remote) Create(ctx context.Context, key Key, headers http.Header, ttl t
 		_, _ = io.Copy(io.Discard, resp.Body) //nolint:errcheck,gosec
 		_ = resp.Body.Close()                 //nolint:gosec
 
+		if exclusive && resp.StatusCode == http.StatusPreconditionFailed {
+			wc.done <- errors.WithStack(ErrExists)
+			return
+		}
 		if resp.StatusCode != http.StatusOK {
 			wc.done <- errors.Errorf("unexpected status code: %d", resp.StatusCode)
 			return
@@ -134,6 +226,34 @@ func (c *Remote) Create(ctx context.Context, key Key, headers http.Header, ttl t
 	return wc, nil
 }
 
+// Touch resets the expiry of an object in the remote.
+func (c *Remote) Touch(ctx context.Context, key Key, ttl time.Duration) error {
+	url := fmt.Sprintf("%s/object/%s", c.baseURL, key.String())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, url, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+	if ttl > 0 {
+		req.Header.Set("Time-To-Live", ttl.String())
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to execute request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return os.ErrNotExist
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
 // Delete removes an object from the remote.
 func (c *Remote) Delete(ctx context.Context, key Key) error {
 	url := fmt.Sprintf("%s/object/%s", c.baseURL, key.String())
@@ -165,13 +285,15 @@ func (c *Remote) Close() error {
 	return nil
 }
 
-// Stats retrieves cache statistics from the remote server.
+// Stats retrieves cache statistics from the remote server, which requires the admin token set with [Remote.WithToken]
+// if the server restricts its admin endpoints.
 func (c *Remote) Stats(ctx context.Context) (Stats, error) {
 	url := c.baseURL + "/stats"
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return Stats{}, errors.Wrap(err, "failed to create request")
 	}
+	c.authorize(req)
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -195,6 +317,52 @@ func (c *Remote) Stats(ctx context.Context) (Stats, error) {
 	return stats, nil
 }
 
+// ListKeys lists the remote's objects whose keys start with prefix, which requires an admin token if the remote is
+// configured with any. See [Remote.WithToken].
+func (c *Remote) ListKeys(ctx context.Context, prefix string) iter.Seq2[ObjectInfo, error] {
+	return func(yield func(ObjectInfo, error) bool) {
+		url := strings.TrimSuffix(c.baseURL, "/api/v1") + "/_cache/keys?prefix=" + neturl.QueryEscape(prefix)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			yield(ObjectInfo{}, errors.Wrap(err, "failed to create request"))
+			return
+		}
+		c.authorize(req)
+		resp, err := c.client.Do(req)
+		if err != nil {
+			yield(ObjectInfo{}, errors.Wrap(err, "failed to execute request"))
+			return
+		}
+		defer resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+		case http.StatusNotImplemented:
+			yield(ObjectInfo{}, errors.Errorf("%s: %w", c.String(), ErrListUnavailable))
+			return
+		default:
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024)) //nolint:errcheck
+			yield(ObjectInfo{}, errors.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body))))
+			return
+		}
+
+		// The server aborts the response if listing fails part way, so it only decodes to the end if complete.
+		dec := json.NewDecoder(resp.Body)
+		for {
+			var object ObjectInfo
+			err := dec.Decode(&object)
+			if errors.Is(err, io.EOF) {
+				return
+			} else if err != nil {
+				yield(ObjectInfo{}, errors.Wrap(err, "listing incomplete"))
+				return
+			}
+			if !yield(object, nil) {
+				return
+			}
+		}
+	}
+}
+
 // writeCloser wraps a pipe writer and waits for the HTTP request to complete.
 type writeCloser struct {
 	pw   *io.PipeWriter
@@ -223,3 +391,237 @@ func (wc *writeCloser) Close() error {
 	}
 	return nil
 }
+
+// createChunked starts a resumable upload of an object, or resumes an earlier upload of it that did not complete.
+func (c *Remote) createChunked(ctx context.Context, key Key, headers http.Header, ttl time.Duration, exclusive bool) (io.WriteCloser, error) {
+	url := c.uploadURL(key)
+	committed, digest, err := c.uploadOffset(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	headers = maps.Clone(headers)
+	if headers == nil {
+		headers = http.Header{}
+	}
+	if ttl > 0 {
+		headers.Set("Time-To-Live", ttl.String())
+	}
+	if exclusive {
+		headers.Set("If-None-Match", "*")
+	}
+	return &chunkedWriter{
+		remote:    c,
+		ctx:       ctx,
+		url:       url,
+		headers:   headers,
+		exclusive: exclusive,
+		buf:       make([]byte, 0, c.chunkSize),
+		offset:    committed,
+		skip:      committed,
+		digest:    digest,
+		skipped:   sha256.New(),
+	}, nil
+}
+
+func (c *Remote) uploadURL(key Key) string {
+	return fmt.Sprintf("%s/upload/%s", c.baseURL, key.String())
+}
+
+// AbortUpload discards any incomplete resumable upload of the object at key, so that the next chunked write of it
+// starts again from the beginning.
+func (c *Remote) AbortUpload(ctx context.Context, key Key) error {
+	return c.abortUpload(ctx, c.uploadURL(key))
+}
+
+// uploadOffset returns the size committed to the resumable upload at url and the digest of the committed data, or
+// zero if there is no upload.
+func (c *Remote) uploadOffset(ctx context.Context, url string) (committed int64, digest string, err error) {
+	err = retry.Do(ctx, uploadRetry, retryableUpload, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+		if err != nil {
+			return errors.Errorf("%w: failed to create request: %w", errUploadRejected, err)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return errors.Wrap(err, "failed to execute request")
+		}
+		defer resp.Body.Close()
+		if err := uploadStatusError(resp, http.StatusOK, http.StatusNotFound); err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			committed, digest = 0, ""
+			return nil
+		}
+		if committed, err = strconv.ParseInt(resp.Header.Get(UploadOffsetHeader), 10, 64); err != nil {
+			return errors.Errorf("%w: invalid %s header: %w", errUploadRejected, UploadOffsetHeader, err)
+		}
+		digest = resp.Header.Get(UploadDigestHeader)
+		return nil
+	})
+	return committed, digest, errors.WithStack(err)
+}
+
+// abortUpload discards the resumable upload at url, if any.
+func (c *Remote) abortUpload(ctx context.Context, url string) error {
+	return errors.WithStack(retry.Do(ctx, uploadRetry, retryableUpload, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodDelete, url, nil)
+		if err != nil {
+			return errors.Errorf("%w: failed to create request: %w", errUploadRejected, err)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return errors.Wrap(err, "failed to execute request")
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body) //nolint:errcheck,gosec
+		return uploadStatusError(resp, http.StatusOK, http.StatusNotFound)
+	}))
+}
+
+// putChunk sends a chunk of the resumable upload at url starting at offset, returning the size the remote has
+// committed.
+//
+// A chunk that the remote already committed, eg. because the response to it was lost, is not committed again.
+func (c *Remote) putChunk(ctx context.Context, url string, offset int64, chunk []byte) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(chunk))
+	if err != nil {
+		return 0, errors.Errorf("%w: failed to create request: %w", errUploadRejected, err)
+	}
+	req.Header.Set(UploadOffsetHeader, strconv.FormatInt(offset, 10))
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to execute request")
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body) //nolint:errcheck,gosec
+	if err := uploadStatusError(resp, http.StatusNoContent, http.StatusConflict); err != nil {
+		return 0, err
+	}
+	committed, err := strconv.ParseInt(resp.Header.Get(UploadOffsetHeader), 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("%w: invalid %s header: %w", errUploadRejected, UploadOffsetHeader, err)
+	}
+	if expected := offset + int64(len(chunk)); committed != expected {
+		return 0, errors.Errorf("%w: remote committed %d bytes, expected %d", errUploadRejected, committed, expected)
+	}
+	return committed, nil
+}
+
+// uploadStatusError returns nil if the status of resp is one of expected, and otherwise an error that is only retried
+// if the remote failed.
+func uploadStatusError(resp *http.Response, expected ...int) error {
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return nil
+		}
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return errors.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return errors.Errorf("%w: unexpected status code: %d", errUploadRejected, resp.StatusCode)
+}
+
+func retryableUpload(err error) bool { return !errors.Is(err, errUploadRejected) }
+
+// chunkedWriter uploads an object with the resumable upload API, committing it on Close.
+//
+// When resuming an upload, the first skip bytes written are those the remote has already committed, which are hashed
+// and compared with the remote's digest of them rather than sent.
+type chunkedWriter struct {
+	remote    *Remote
+	ctx       context.Context
+	url       string
+	headers   http.Header
+	exclusive bool
+	buf       []byte
+	offset    int64
+	skip      int64
+	digest    string
+	skipped   hash.Hash
+	err       error
+}
+
+func (w *chunkedWriter) Write(p []byte) (int, error) {
+	written := 0
+	if w.skip > 0 && w.err == nil {
+		n := int(min(int64(len(p)), w.skip))
+		_, _ = w.skipped.Write(p[:n]) //nolint:errcheck // Hashes never fail to write.
+		w.skip -= int64(n)
+		p = p[n:]
+		written += n
+		if w.skip == 0 && hex.EncodeToString(w.skipped.Sum(nil)) != w.digest {
+			w.err = errors.Errorf("%w: the first %d bytes differ", errUploadMismatch, w.offset)
+		}
+	}
+	for len(p) > 0 {
+		if w.err != nil {
+			return written, w.err
+		}
+		n := min(len(p), cap(w.buf)-len(w.buf))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(w.buf) == cap(w.buf) {
+			w.err = w.flush()
+		}
+	}
+	return written, w.err
+}
+
+// flush sends the buffered chunk, retrying it until it is committed.
+func (w *chunkedWriter) flush() error {
+	err := retry.Do(w.ctx, uploadRetry, retryableUpload, func(ctx context.Context) error {
+		committed, err := w.remote.putChunk(ctx, w.url, w.offset, w.buf)
+		if err == nil {
+			w.offset = committed
+		}
+		return err
+	})
+	if err != nil {
+		return errors.Errorf("failed to upload chunk at offset %d: %w", w.offset, err)
+	}
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close sends the last chunk and creates the object from the upload.
+//
+// If ctx was cancelled, or the object differs from the upload it resumed, the upload is discarded. If sending a chunk
+// failed, the upload is kept so that the next writer of the object resumes it.
+func (w *chunkedWriter) Close() error {
+	if err := w.ctx.Err(); err != nil && w.err == nil {
+		w.err = errors.Wrap(err, "create operation cancelled")
+	}
+	if w.skip > 0 && w.err == nil {
+		w.err = errors.Errorf("%w: it is shorter than the %d bytes committed", errUploadMismatch, w.offset)
+	}
+	// Empty objects are uploaded as a single empty chunk, which starts the upload.
+	if w.err == nil && (len(w.buf) > 0 || w.offset == 0) {
+		w.err = w.flush()
+	}
+	if w.ctx.Err() != nil || errors.Is(w.err, errUploadMismatch) {
+		return errors.Join(w.err, w.remote.abortUpload(context.WithoutCancel(w.ctx), w.url))
+	}
+	if w.err != nil {
+		return w.err
+	}
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, w.url, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+	maps.Copy(req.Header, w.headers)
+	resp, err := w.remote.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to execute request")
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body) //nolint:errcheck,gosec
+	if w.exclusive && resp.StatusCode == http.StatusPreconditionFailed {
+		return errors.WithStack(ErrExists)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -1,17 +1,23 @@
 package cache_test
 
 import (
+	"bytes"
+	"crypto/rand"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/alecthomas/assert/v2"
+	"github.com/alecthomas/errors"
 
 	"github.com/block/cachew/internal/cache"
 	"github.com/block/cachew/internal/cache/cachetest"
+	"github.com/block/cachew/internal/httputil"
 	"github.com/block/cachew/internal/logging"
 	"github.com/block/cachew/internal/strategy"
 )
@@ -27,7 +33,7 @@ func TestRemoteCache(t *testing.T) {
 		t.Cleanup(func() { memCache.Close() })
 
 		mux := http.NewServeMux()
-		_, err = strategy.NewAPIV1(ctx, struct{}{}, memCache, mux)
+		_, err = strategy.NewAPIV1(ctx, struct{}{}, memCache, mux, nil, "", strategy.UploadConfig{})
 		assert.NoError(t, err)
 		ts := httptest.NewServer(mux)
 		t.Cleanup(ts.Close)
@@ -37,6 +43,210 @@ func TestRemoteCache(t *testing.T) {
 	})
 }
 
+func TestRemoteCacheChunked(t *testing.T) {
+	cachetest.Suite(t, func(t *testing.T) cache.Cache {
+		ctx := t.Context()
+		_, ctx = logging.Configure(ctx, logging.Config{Level: slog.LevelError})
+		memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{
+			MaxTTL: 100 * time.Millisecond,
+		})
+		assert.NoError(t, err)
+		t.Cleanup(func() { memCache.Close() })
+
+		mux := http.NewServeMux()
+		_, err = strategy.NewAPIV1(ctx, struct{}{}, memCache, mux, nil, "", strategy.UploadConfig{})
+		assert.NoError(t, err)
+		ts := httptest.NewServer(mux)
+		t.Cleanup(ts.Close)
+
+		return cache.NewRemote(ts.URL).WithChunkSize(4096)
+	})
+}
+
+// failingReader returns an error once n bytes have been read from r.
+type failingReader struct {
+	r io.Reader
+	n int
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.n <= 0 {
+		return 0, errors.New("connection reset")
+	}
+	n, err := f.r.Read(p[:min(len(p), f.n)])
+	f.n -= n
+	return n, errors.WithStack(err)
+}
+
+func TestRemoteResumableUpload(t *testing.T) {
+	tests := []struct {
+		name string
+		// interrupt handles the second chunk of the upload, simulating a failure of the link or the server.
+		interrupt func(w http.ResponseWriter, r *http.Request, next http.Handler)
+	}{
+		{"ChunkTruncated", func(w http.ResponseWriter, r *http.Request, next http.Handler) {
+			r.Body = io.NopCloser(&failingReader{r: r.Body, n: 1000})
+			next.ServeHTTP(w, r)
+		}},
+		{"ResponseLost", func(_ http.ResponseWriter, r *http.Request, next http.Handler) {
+			next.ServeHTTP(httptest.NewRecorder(), r)
+			panic(http.ErrAbortHandler)
+		}},
+		{"ServerUnavailable", func(w http.ResponseWriter, _ *http.Request, _ http.Handler) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+			memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+			assert.NoError(t, err)
+			defer memCache.Close()
+			mux := http.NewServeMux()
+			_, err = strategy.NewAPIV1(ctx, struct{}{}, memCache, mux, nil, "", strategy.UploadConfig{})
+			assert.NoError(t, err)
+
+			var chunks atomic.Int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPut && chunks.Add(1) == 2 {
+					tt.interrupt(w, r, mux)
+					return
+				}
+				mux.ServeHTTP(w, r)
+			}))
+			defer ts.Close()
+
+			data := make([]byte, 10*4096+123)
+			_, err = rand.Read(data)
+			assert.NoError(t, err)
+			client := cache.NewRemote(ts.URL).WithChunkSize(4096)
+			key := cache.NewKey("resumable")
+			w, err := client.Create(ctx, key, http.Header{"Content-Type": []string{"application/zstd"}}, time.Hour)
+			assert.NoError(t, err)
+			_, err = io.Copy(w, bytes.NewReader(data))
+			assert.NoError(t, err)
+			assert.NoError(t, w.Close())
+
+			assert.Equal(t, int32(12), chunks.Load(), "only the interrupted chunk should be sent again")
+			r, headers, err := memCache.Open(ctx, key)
+			assert.NoError(t, err)
+			defer r.Close()
+			stored, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Equal(t, data, stored)
+			assert.Equal(t, "application/zstd", headers.Get("Content-Type"))
+		})
+	}
+}
+
+func TestRemoteResumeUpload(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer memCache.Close()
+	uploadDir := t.TempDir()
+	mux := http.NewServeMux()
+	_, err = strategy.NewAPIV1(ctx, struct{}{}, memCache, mux, nil, "", strategy.UploadConfig{Dir: uploadDir})
+	assert.NoError(t, err)
+
+	// The fourth chunk is rejected, failing the first writer without retrying.
+	var chunks atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && chunks.Add(1) == 4 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	data := make([]byte, 10*4096+123)
+	_, err = rand.Read(data)
+	assert.NoError(t, err)
+	client := cache.NewRemote(ts.URL).WithChunkSize(4096)
+	key := cache.NewKey("resumed")
+	upload := func(data []byte) error {
+		w, err := client.Create(ctx, key, http.Header{}, time.Hour)
+		assert.NoError(t, err)
+		_, err = w.Write(data)
+		return errors.Join(err, w.Close())
+	}
+
+	assert.Error(t, upload(data))
+	spooled, err := os.ReadDir(uploadDir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(spooled), "the failed upload should be kept")
+
+	t.Run("DifferentData", func(t *testing.T) {
+		other := bytes.Clone(data)
+		other[0]++
+		chunks.Store(100)
+		err := upload(other)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "differs from its incomplete upload")
+		assert.Equal(t, int32(100), chunks.Load(), "no chunks should be sent")
+		// The incomplete upload is discarded rather than combined with different data, so the next writer restarts.
+		spooled, err := os.ReadDir(uploadDir)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, len(spooled))
+	})
+
+	// Fail an upload at the fourth chunk again, after which the next writer of the same data resumes it from the three
+	// committed chunks.
+	chunks.Store(0)
+	assert.Error(t, upload(data))
+	assert.NoError(t, upload(data))
+	assert.Equal(t, int32(4+8), chunks.Load(), "only the chunks after the committed ones should be sent")
+	r, _, err := memCache.Open(ctx, key)
+	assert.NoError(t, err)
+	defer r.Close()
+	stored, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, data, stored)
+}
+
+func TestRemoteUploadMaxSize(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer memCache.Close()
+	mux := http.NewServeMux()
+	_, err = strategy.NewAPIV1(ctx, struct{}{}, memCache, mux, nil, "", strategy.UploadConfig{MaxSizeMB: 1})
+	assert.NoError(t, err)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	client := cache.NewRemote(ts.URL).WithChunkSize(256 << 10)
+	w, err := client.Create(ctx, cache.NewKey("large"), http.Header{}, time.Hour)
+	assert.NoError(t, err)
+	_, err = w.Write(make([]byte, 1<<20+1))
+	err = errors.Join(err, w.Close())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "413")
+}
+
+func TestRemoteStatsAuthorization(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer memCache.Close()
+	mux := http.NewServeMux()
+	_, err = strategy.NewAPIV1(ctx, struct{}{}, memCache, mux, httputil.NewTokenAuthorizer([]string{"secret"}), "", strategy.UploadConfig{})
+	assert.NoError(t, err)
+	// Authorization failures are logged with the request's logger.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, r.WithContext(ctx))
+	}))
+	defer ts.Close()
+
+	_, err = cache.NewRemote(ts.URL).Stats(ctx)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "401")
+
+	_, err = cache.NewRemote(ts.URL).WithToken("secret").Stats(ctx)
+	assert.NoError(t, err)
+}
+
 func TestRemoteCacheSoak(t *testing.T) {
 	if os.Getenv("SOAK_TEST") == "" {
 		t.Skip("Skipping soak test; set SOAK_TEST=1 to run")
@@ -52,7 +262,7 @@ func TestRemoteCacheSoak(t *testing.T) {
 	defer memCache.Close()
 
 	mux := http.NewServeMux()
-	_, err = strategy.NewAPIV1(ctx, struct{}{}, memCache, mux)
+	_, err = strategy.NewAPIV1(ctx, struct{}{}, memCache, mux, nil, "", strategy.UploadConfig{})
 	assert.NoError(t, err)
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
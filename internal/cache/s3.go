@@ -2,21 +2,26 @@ package cache
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
 	"log/slog"
 	"maps"
 	"net/http"
 	"os"
 	"runtime"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/alecthomas/errors"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 
+	"github.com/block/cachew/internal/httputil"
 	"github.com/block/cachew/internal/logging"
 )
 
@@ -37,16 +42,81 @@ type S3Config struct {
 	SkipSSLVerify     bool          `hcl:"skip-ssl-verify,optional" help:"Skip SSL certificate verification (defaults to false)." default:"false"`
 	MaxTTL            time.Duration `hcl:"max-ttl,optional" help:"Maximum time-to-live for entries in the S3 cache (defaults to 1 hour)." default:"1h"`
 	UploadConcurrency uint          `hcl:"upload-concurrency,optional" help:"Number of concurrent workers for multi-part uploads (0 = use all CPU cores, defaults to 1)." default:"1"`
-	UploadPartSizeMB  uint          `hcl:"upload-part-size-mb,optional" help:"Size of each part for multi-part uploads in megabytes (defaults to 16MB, minimum 5MB)." default:"16"`
+	UploadPartSizeMB  uint          `hcl:"upload-part-size-mb,optional" help:"Size of each part for multi-part uploads in megabytes (defaults to 16MB, minimum 5MB). Each upload buffers a part per worker in memory, and objects are limited to 10,000 parts." default:"16"`
+	MaxIdleConns      int           `hcl:"max-idle-conns,optional" help:"Maximum number of idle connections kept open to S3 (0 uses the minio default)."`
+	IdleConnTimeout   time.Duration `hcl:"idle-conn-timeout,optional" help:"How long an idle connection is kept open before being closed, eg. to stay below a load balancer's idle timeout (0 uses the minio default)."`
+	MaxConnsPerHost   int           `hcl:"max-conns-per-host,optional" help:"Maximum number of connections to each S3 host, including those in use (0 is unlimited)."`
+	ClockSkew         time.Duration `hcl:"clock-skew,optional" help:"How long past their expiry objects are still served, to tolerate skew between the clocks of S3 and cachew instances (defaults to 30s)." default:"30s"`
+	// SlidingExpiration extends an object's expiry by its TTL when it is opened. As this rewrites the object's
+	// metadata, it is only done once less than half of the TTL remains, so that hot objects are not rewritten on
+	// every read.
+	SlidingExpiration bool `hcl:"sliding-expiration,optional" help:"Extend an object's expiry by its TTL when it is opened and less than half of its TTL remains (defaults to false)."`
+	// VerifyOnRead detects corruption, eg. a truncated upload, as the object is streamed rather than before it is
+	// served, so that objects are only downloaded once. Corruption surfaces mid-body, after the status and headers
+	// have been sent, so the handler cannot re-fetch the object from upstream for that read; the client sees a failed
+	// transfer and only later reads, after the object has been deleted, are fetched again. Partial reads are not
+	// verified.
+	VerifyOnRead bool `hcl:"verify-on-read,optional" help:"Verify objects against their SHA-256 as they are read, failing the read at the end of a body that does not match and deleting the object (defaults to false)."`
+
+	ReadWeight   int                   `hcl:"read-weight,optional" help:"Relative share of reads sent to the primary endpoint when read replicas are configured (defaults to 1)." default:"1"`
+	ReadReplicas []S3ReadReplicaConfig `hcl:"read-replica,block" help:"Additional endpoints serving the same bucket that reads are distributed across. Writes always go to the primary endpoint."`
+	// Transport, if set, is used for all S3 and credential requests instead of a transport built from the above.
+	Transport http.RoundTripper `hcl:"-"`
+}
+
+// S3ReadReplicaConfig configures an additional endpoint that objects are read from.
+type S3ReadReplicaConfig struct {
+	Endpoint string `hcl:"endpoint,label" help:"S3 endpoint of the replica (e.g., s3.us-east-1.amazonaws.com)."`
+	Weight   int    `hcl:"weight,optional" help:"Relative share of reads sent to this replica (defaults to 1)." default:"1"`
+}
+
+// Validate the configuration.
+func (c *S3Config) Validate() error {
+	var errs []error
+	if c.Bucket == "" {
+		errs = append(errs, errors.New("bucket must be set"))
+	}
+	if strings.Contains(c.Endpoint, "://") {
+		errs = append(errs, errors.Errorf("endpoint must be a host[:port] without a scheme, got %q", c.Endpoint))
+	}
+	if c.UploadPartSizeMB < 5 {
+		errs = append(errs, errors.New("upload-part-size-mb must be at least 5MB (S3 minimum part size)"))
+	}
+	if c.MaxIdleConns < 0 || c.IdleConnTimeout < 0 || c.MaxConnsPerHost < 0 {
+		errs = append(errs, errors.New("max-idle-conns, idle-conn-timeout and max-conns-per-host must not be negative"))
+	}
+	if c.ReadWeight < 0 {
+		errs = append(errs, errors.New("read-weight must not be negative"))
+	}
+	if c.ClockSkew < 0 {
+		errs = append(errs, errors.New("clock-skew must not be negative"))
+	}
+	for _, replica := range c.ReadReplicas {
+		if strings.Contains(replica.Endpoint, "://") {
+			errs = append(errs, errors.Errorf("read-replica endpoint must be a host[:port] without a scheme, got %q", replica.Endpoint))
+		}
+		if replica.Weight < 0 {
+			errs = append(errs, errors.Errorf("read-replica %q: weight must not be negative", replica.Endpoint))
+		}
+	}
+	return errors.Join(errs...)
 }
 
 type S3 struct {
 	logger *slog.Logger
 	config S3Config
 	client *minio.Client
+	// readers holds the primary and replica clients, each repeated according to its weight, and is cycled through
+	// by reads. It is empty if no replicas are configured.
+	readers  []*minio.Client
+	nextRead atomic.Uint64
 }
 
-var _ Cache = (*S3)(nil)
+var (
+	_ Cache            = (*S3)(nil)
+	_ RangeOpener      = (*S3)(nil)
+	_ ExclusiveCreator = (*S3)(nil)
+)
 
 // NewS3 creates a new S3-based cache instance using the minio SDK.
 //
@@ -60,6 +130,17 @@ var _ Cache = (*S3)(nil)
 // This [Cache] implementation stores cache entries in an S3-compatible object storage service.
 // Metadata (headers and expiration time) are stored as object user metadata. The implementation
 // uses the lightweight minio-go SDK to reduce overhead compared to the AWS SDK.
+//
+// Each object records its TTL alongside its expiry, so that if the clock of the instance that wrote it was skewed
+// its expiry is instead computed from the LastModified time assigned by S3. Objects are only considered expired
+// once they are more than ClockSkew past their expiry by the local clock.
+//
+// Tags in the [TagHeader] header are recorded as S3 object tags. Listing and purging by tag read the tags of every
+// object in the bucket, so are slow for large buckets.
+//
+// If read replicas are configured, reads are distributed across the primary and the replicas by weighted
+// round-robin. A read that fails on a replica, including because the object has not yet been replicated, is retried
+// against the primary.
 func NewS3(ctx context.Context, config S3Config) (*S3, error) {
 	// Set defaults and validate configuration
 	if config.UploadConcurrency == 0 {
@@ -80,27 +161,13 @@ func NewS3(ctx context.Context, config S3Config) (*S3, error) {
 		"upload-concurrency", config.UploadConcurrency,
 		"upload-part-size-mb", config.UploadPartSizeMB)
 
-	// Create default transport for credential chain
-	defaultTransport, err := minio.DefaultTransport(config.UseSSL)
-	if err != nil {
-		return nil, errors.Errorf("failed to create default transport: %w", err)
-	}
-
-	// Apply SSL verification settings if needed
-	var transport http.RoundTripper
-	if config.SkipSSLVerify {
-		// Clone the default transport and disable SSL verification
-		customTransport := defaultTransport.Clone()
-		if customTransport.TLSClientConfig == nil {
-			customTransport.TLSClientConfig = &tls.Config{
-				MinVersion: tls.VersionTLS12,
-			}
-		} else {
-			customTransport.TLSClientConfig.MinVersion = tls.VersionTLS12
+	transport := config.Transport
+	if transport == nil {
+		var err error
+		transport, err = newS3Transport(config)
+		if err != nil {
+			return nil, err
 		}
-		customTransport.TLSClientConfig.InsecureSkipVerify = true
-		transport = customTransport
-		defaultTransport = customTransport
 	}
 
 	// Use AWS credential chain
@@ -110,21 +177,17 @@ func NewS3(ctx context.Context, config S3Config) (*S3, error) {
 			&credentials.FileAWSCredentials{}, // Check ~/.aws/credentials
 			&credentials.IAM{
 				Client: &http.Client{
-					Transport: defaultTransport,
+					Transport: transport,
 				},
 			}, // Check EC2 instance metadata or ECS container credentials
 		})
 
 	// Create minio client options
 	options := &minio.Options{
-		Creds:  creds,
-		Secure: config.UseSSL,
-		Region: config.Region,
-	}
-
-	// Only set custom transport if needed (for SkipSSLVerify)
-	if transport != nil {
-		options.Transport = transport
+		Creds:     creds,
+		Secure:    config.UseSSL,
+		Region:    config.Region,
+		Transport: transport,
 	}
 
 	client, err := minio.New(config.Endpoint, options)
@@ -141,11 +204,50 @@ func NewS3(ctx context.Context, config S3Config) (*S3, error) {
 		return nil, errors.Errorf("bucket %s does not exist", config.Bucket)
 	}
 
-	return &S3{
+	s := &S3{
 		logger: logging.FromContext(ctx),
 		config: config,
 		client: client,
-	}, nil
+	}
+	if len(config.ReadReplicas) > 0 {
+		s.addReaders(client, config.ReadWeight)
+		// Replica failures fall back to the primary, so retrying them would only delay the read.
+		replicaOptions := *options
+		replicaOptions.MaxRetries = 1
+		for _, replica := range config.ReadReplicas {
+			replicaClient, err := minio.New(replica.Endpoint, &replicaOptions)
+			if err != nil {
+				return nil, errors.Errorf("failed to create minio client for read replica %s: %w", replica.Endpoint, err)
+			}
+			s.addReaders(replicaClient, replica.Weight)
+		}
+	}
+	return s, nil
+}
+
+// addReaders adds client to the read rotation "weight" times, treating a zero weight as 1.
+func (s *S3) addReaders(client *minio.Client, weight int) {
+	for range max(weight, 1) {
+		s.readers = append(s.readers, client)
+	}
+}
+
+// statReadObject stats an object on the next client in the read rotation, falling back to the primary if a replica
+// fails. The client that answered is returned so the object body can be fetched from the same endpoint.
+func (s *S3) statReadObject(ctx context.Context, objectName string) (*minio.Client, minio.ObjectInfo, error) {
+	if len(s.readers) > 0 {
+		client := s.readers[(s.nextRead.Add(1)-1)%uint64(len(s.readers))]
+		if client != s.client {
+			objInfo, err := client.StatObject(ctx, s.config.Bucket, objectName, minio.StatObjectOptions{})
+			if err == nil {
+				return client, objInfo, nil
+			}
+			s.logger.DebugContext(ctx, "Read replica failed, falling back to primary",
+				"replica", client.EndpointURL().Host, "error", err)
+		}
+	}
+	objInfo, err := s.client.StatObject(ctx, s.config.Bucket, objectName, minio.StatObjectOptions{})
+	return s.client, objInfo, err //nolint:wrapcheck // Callers inspect the minio error response.
 }
 
 func (s *S3) String() string {
@@ -163,37 +265,63 @@ func (s *S3) keyToPath(key Key) string {
 }
 
 func (s *S3) Stat(ctx context.Context, key Key) (http.Header, error) {
+	_, _, headers, err := s.statObject(ctx, key)
+	return headers, err
+}
+
+// Has checks the object's metadata without downloading it. Expired objects are reported as absent but not deleted.
+func (s *S3) Has(ctx context.Context, key Key) (bool, error) {
+	_, objInfo, err := s.statReadObject(ctx, s.keyToPath(key))
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == s3ErrNoSuchKey {
+			return false, nil
+		}
+		return false, errors.Errorf("failed to stat object: %w", err)
+	}
+	return !s.expired(objInfo, time.Now()), nil
+}
+
+// ExpiresAt returns when an object expires, from its metadata, without downloading it. Objects without a recorded
+// expiry are reported as expiring at the zero time.
+func (s *S3) ExpiresAt(ctx context.Context, key Key) (time.Time, error) {
+	_, objInfo, err := s.statReadObject(ctx, s.keyToPath(key))
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == s3ErrNoSuchKey {
+			return time.Time{}, errors.WithStack(os.ErrNotExist)
+		}
+		return time.Time{}, errors.Errorf("failed to stat object: %w", err)
+	}
+	if s.expired(objInfo, time.Now()) {
+		return time.Time{}, errors.WithStack(os.ErrNotExist)
+	}
+	return s3ExpiresAt(objInfo), nil
+}
+
+// statObject retrieves the object's info and stored headers, deleting it and returning os.ErrNotExist if it has
+// expired. The client that the object was found on is also returned.
+func (s *S3) statObject(ctx context.Context, key Key) (*minio.Client, minio.ObjectInfo, http.Header, error) {
 	objectName := s.keyToPath(key)
 
 	// Get object info to check metadata
-	objInfo, err := s.client.StatObject(ctx, s.config.Bucket, objectName, minio.StatObjectOptions{})
+	client, objInfo, err := s.statReadObject(ctx, objectName)
 	if err != nil {
 		errResponse := minio.ToErrorResponse(err)
 		if errResponse.Code == s3ErrNoSuchKey {
-			return nil, os.ErrNotExist
+			return nil, objInfo, nil, os.ErrNotExist
 		}
-		return nil, errors.Errorf("failed to stat object: %w", err)
+		return nil, objInfo, nil, errors.Errorf("failed to stat object: %w", err)
 	}
 
-	// Check if object has expired
-	// Note: UserMetadata keys are returned WITHOUT the "X-Amz-Meta-" prefix by minio-go
-	expiresAtStr := objInfo.UserMetadata["Expires-At"]
-	if expiresAtStr != "" {
-		var expiresAt time.Time
-		if err := expiresAt.UnmarshalText([]byte(expiresAtStr)); err == nil {
-			if time.Now().After(expiresAt) {
-				// Object expired, delete it and return not found
-				return nil, errors.Join(os.ErrNotExist, s.Delete(ctx, key))
-			}
-		}
+	if s.expired(objInfo, time.Now()) {
+		// Object expired, delete it and return not found
+		return nil, objInfo, nil, errors.Join(os.ErrNotExist, s.Delete(ctx, key))
 	}
 
 	// Retrieve headers from metadata
-	// Note: UserMetadata keys are returned WITHOUT the "X-Amz-Meta-" prefix by minio-go
 	headers := make(http.Header)
 	if headersJSON := objInfo.UserMetadata["Headers"]; headersJSON != "" {
 		if err := json.Unmarshal([]byte(headersJSON), &headers); err != nil {
-			return nil, errors.Errorf("failed to unmarshal headers: %w", err)
+			return nil, objInfo, nil, errors.Errorf("failed to unmarshal headers: %w", err)
 		}
 	}
 
@@ -202,56 +330,143 @@ func (s *S3) Stat(ctx context.Context, key Key) (http.Header, error) {
 		headers.Set("Last-Modified", objInfo.LastModified.UTC().Format(http.TimeFormat))
 	}
 
-	return headers, nil
+	return client, objInfo, headers, nil
+}
+
+// expired returns true if the object has expired at now, allowing for the configured clock skew.
+func (s *S3) expired(objInfo minio.ObjectInfo, now time.Time) bool {
+	expiresAt := s3ExpiresAt(objInfo)
+	return !expiresAt.IsZero() && now.After(expiresAt.Add(s.config.ClockSkew))
+}
+
+// s3TimePrecision is the largest difference between an object's LastModified time and the time its writer recorded
+// it that is not considered to be clock skew, as LastModified is truncated to the second.
+const s3TimePrecision = 2 * time.Second
+
+// s3ExpiresAt returns when an object expires, or the zero time if it has no recorded expiry.
+//
+// The writer records both the absolute expiry by its own clock and the TTL. If the time it wrote the object by its
+// clock differs from the LastModified time assigned by S3, its clock was skewed, and expiry is instead the TTL after
+// LastModified, so that it is consistent regardless of which instance wrote the object. Objects written before TTLs
+// were recorded only have the absolute expiry.
+func s3ExpiresAt(objInfo minio.ObjectInfo) time.Time {
+	// Note: UserMetadata keys are returned WITHOUT the "X-Amz-Meta-" prefix by minio-go
+	var expiresAt time.Time
+	if err := expiresAt.UnmarshalText([]byte(objInfo.UserMetadata["Expires-At"])); err != nil {
+		return time.Time{}
+	}
+	ttl, err := time.ParseDuration(objInfo.UserMetadata["Ttl"])
+	if err != nil || objInfo.LastModified.IsZero() {
+		return expiresAt
+	}
+	if skew := objInfo.LastModified.Sub(expiresAt.Add(-ttl)); skew.Abs() <= s3TimePrecision {
+		return expiresAt
+	}
+	return objInfo.LastModified.Add(ttl)
 }
 
 func (s *S3) Open(ctx context.Context, key Key) (io.ReadCloser, http.Header, error) {
-	objectName := s.keyToPath(key)
+	client, objInfo, headers, err := s.statObject(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.slideExpiry(ctx, key, objInfo)
 
-	// Get object info to retrieve metadata and check expiration
-	objInfo, err := s.client.StatObject(ctx, s.config.Bucket, objectName, minio.StatObjectOptions{})
+	obj, err := client.GetObject(ctx, s.config.Bucket, s.keyToPath(key), minio.GetObjectOptions{})
 	if err != nil {
-		errResponse := minio.ToErrorResponse(err)
-		if errResponse.Code == s3ErrNoSuchKey {
-			return nil, nil, os.ErrNotExist
-		}
-		return nil, nil, errors.Errorf("failed to stat object: %w", err)
+		return nil, nil, errors.Errorf("failed to get object: %w", err)
 	}
 
-	// Check if object has expired
-	expiresAtStr := objInfo.UserMetadata["Expires-At"]
-	if expiresAtStr != "" {
-		var expiresAt time.Time
-		if err := expiresAt.UnmarshalText([]byte(expiresAtStr)); err == nil {
-			if time.Now().After(expiresAt) {
-				return nil, nil, errors.Join(os.ErrNotExist, s.Delete(ctx, key))
+	var reader io.ReadCloser = &s3Reader{obj: obj}
+	if s.config.VerifyOnRead {
+		reader = newVerifyingReader(reader, headers, func(err error) {
+			s.logger.ErrorContext(ctx, "Deleting corrupt object", slog.String("key", key.String()), slog.String("error", err.Error()))
+			if err := s.Delete(context.WithoutCancel(ctx), key); err != nil && !errors.Is(err, os.ErrNotExist) {
+				s.logger.ErrorContext(ctx, "Failed to delete corrupt object", slog.String("key", key.String()), slog.String("error", err.Error()))
 			}
-		}
+		})
 	}
+	return reader, headers, nil
+}
 
-	// Retrieve headers from metadata
-	headers := make(http.Header)
-	if headersJSON := objInfo.UserMetadata["Headers"]; headersJSON != "" {
-		if err := json.Unmarshal([]byte(headersJSON), &headers); err != nil {
-			return nil, nil, errors.Errorf("failed to unmarshal headers: %w", err)
-		}
+// OpenRange fetches only the requested bytes of an object from S3.
+func (s *S3) OpenRange(ctx context.Context, key Key, rng Range) (io.ReadCloser, http.Header, error) {
+	client, objInfo, headers, err := s.statObject(ctx, key)
+	if err != nil {
+		return nil, nil, err
 	}
+	s.slideExpiry(ctx, key, objInfo)
 
-	// Add Last-Modified header from S3 object metadata if not already present
-	if headers.Get("Last-Modified") == "" && !objInfo.LastModified.IsZero() {
-		headers.Set("Last-Modified", objInfo.LastModified.UTC().Format(http.TimeFormat))
+	offset, length, err := rng.Resolve(objInfo.Size)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// Get object
-	obj, err := s.client.GetObject(ctx, s.config.Bucket, objectName, minio.GetObjectOptions{})
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(offset, offset+length-1); err != nil {
+		return nil, nil, errors.Errorf("failed to set range: %w", err)
+	}
+	obj, err := client.GetObject(ctx, s.config.Bucket, s.keyToPath(key), opts)
 	if err != nil {
 		return nil, nil, errors.Errorf("failed to get object: %w", err)
 	}
 
+	SetRangeHeaders(headers, offset, length, objInfo.Size)
 	return &s3Reader{obj: obj}, headers, nil
 }
 
-const s3ErrNoSuchKey = "NoSuchKey"
+// slideExpiry extends the expiry of an object being opened if SlidingExpiration is enabled and less than half of its
+// TTL remains. Failures are logged rather than failing the read.
+func (s *S3) slideExpiry(ctx context.Context, key Key, objInfo minio.ObjectInfo) {
+	if !s.config.SlidingExpiration {
+		return
+	}
+	ttl, err := time.ParseDuration(objInfo.UserMetadata["Ttl"])
+	if err != nil || time.Until(s3ExpiresAt(objInfo)) > ttl/2 {
+		return
+	}
+	if err := s.Touch(ctx, key, ttl); err != nil {
+		s.logger.WarnContext(ctx, "Failed to extend object expiry", slog.String("key", key.String()), slog.String("error", err.Error()))
+	}
+}
+
+// newS3Transport builds minio's default transport with the configured TLS and connection pool settings applied.
+func newS3Transport(config S3Config) (*http.Transport, error) {
+	transport, err := minio.DefaultTransport(config.UseSSL)
+	if err != nil {
+		return nil, errors.Errorf("failed to create default transport: %w", err)
+	}
+	transport.Proxy = httputil.Proxy
+
+	if config.SkipSSLVerify {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{
+				MinVersion: tls.VersionTLS12,
+			}
+		} else {
+			transport.TLSClientConfig.MinVersion = tls.VersionTLS12
+		}
+		transport.TLSClientConfig.InsecureSkipVerify = true
+	}
+
+	if config.MaxIdleConns > 0 {
+		// S3 is usually a single host, so the per-host limit is raised or lowered with the overall limit.
+		transport.MaxIdleConns = config.MaxIdleConns
+		transport.MaxIdleConnsPerHost = config.MaxIdleConns
+	}
+	if config.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = config.IdleConnTimeout
+	}
+	if config.MaxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = config.MaxConnsPerHost
+	}
+	return transport, nil
+}
+
+const (
+	s3ErrNoSuchKey          = "NoSuchKey"
+	s3ErrPreconditionFailed = "PreconditionFailed"
+)
 
 // s3Reader wraps minio.Object to convert S3 errors to standard errors.
 type s3Reader struct {
@@ -276,6 +491,23 @@ func (r *s3Reader) Close() error {
 }
 
 func (s *S3) Create(ctx context.Context, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	return s.create(ctx, key, headers, ttl, false), nil
+}
+
+// CreateExclusive uploads an object with "If-None-Match: *", so that it is only committed if no object with the same
+// key exists.
+//
+// Expired objects are deleted by the existence check made before uploading, so they do not prevent the upload.
+func (s *S3) CreateExclusive(ctx context.Context, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	if _, err := s.Stat(ctx, key); err == nil {
+		return nil, errors.WithStack(ErrExists)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return s.create(ctx, key, headers, ttl, true), nil
+}
+
+func (s *S3) create(ctx context.Context, key Key, headers http.Header, ttl time.Duration, exclusive bool) *s3Writer {
 	if ttl > s.config.MaxTTL || ttl == 0 {
 		ttl = s.config.MaxTTL
 	}
@@ -284,24 +516,70 @@ func (s *S3) Create(ctx context.Context, key Key, headers http.Header, ttl time.
 	clonedHeaders := make(http.Header)
 	maps.Copy(clonedHeaders, headers)
 
-	expiresAt := time.Now().Add(ttl)
-
 	pr, pw := io.Pipe()
 
 	writer := &s3Writer{
 		s3:        s,
 		key:       key,
 		pipe:      pw,
-		expiresAt: expiresAt,
+		ttl:       ttl,
+		expiresAt: time.Now().Add(ttl),
 		headers:   clonedHeaders,
+		exclusive: exclusive,
 		ctx:       ctx,
 		errCh:     make(chan error, 1),
+		digest:    sha256.New(),
 	}
 
 	// Start upload in background goroutine
 	go writer.upload(pr)
 
-	return writer, nil
+	return writer
+}
+
+// Touch resets the expiry of an object by copying it onto itself with updated metadata.
+//
+// The copy happens server-side so the body is not re-uploaded, but S3 limits single copies to 5GB.
+func (s *S3) Touch(ctx context.Context, key Key, ttl time.Duration) error {
+	if ttl > s.config.MaxTTL || ttl == 0 {
+		ttl = s.config.MaxTTL
+	}
+
+	objectName := s.keyToPath(key)
+	objInfo, err := s.client.StatObject(ctx, s.config.Bucket, objectName, minio.StatObjectOptions{})
+	if err != nil {
+		errResponse := minio.ToErrorResponse(err)
+		if errResponse.Code == s3ErrNoSuchKey {
+			return os.ErrNotExist
+		}
+		return errors.Errorf("failed to stat object: %w", err)
+	}
+
+	if s.expired(objInfo, time.Now()) {
+		return errors.Join(os.ErrNotExist, s.Delete(ctx, key))
+	}
+
+	expiresAtBytes, err := time.Now().Add(ttl).MarshalText()
+	if err != nil {
+		return errors.Errorf("failed to marshal expiration time: %w", err)
+	}
+	userMetadata := maps.Clone(objInfo.UserMetadata)
+	userMetadata["Expires-At"] = string(expiresAtBytes)
+	userMetadata["Ttl"] = ttl.String()
+
+	_, err = s.client.CopyObject(ctx,
+		minio.CopyDestOptions{
+			Bucket:          s.config.Bucket,
+			Object:          objectName,
+			UserMetadata:    userMetadata,
+			ReplaceMetadata: true,
+		},
+		minio.CopySrcOptions{Bucket: s.config.Bucket, Object: objectName},
+	)
+	if err != nil {
+		return errors.Errorf("failed to copy object: %w", err)
+	}
+	return nil
 }
 
 func (s *S3) Delete(ctx context.Context, key Key) error {
@@ -315,25 +593,45 @@ func (s *S3) Delete(ctx context.Context, key Key) error {
 	return nil
 }
 
-func (s *S3) Stats(_ context.Context) (Stats, error) {
-	// S3 doesn't provide efficient count/size operations without listing the entire bucket,
-	// which would be prohibitively slow and expensive.
-	return Stats{}, ErrStatsUnavailable
+// Stats counts the objects in the bucket and sums their sizes.
+//
+// S3 has no efficient way to do this, so every object is listed, taking a request per thousand objects, which for a
+// large bucket takes minutes and is billed by S3. The server's stats endpoint is therefore restricted to authorized
+// requests. Expired objects that the bucket's lifecycle rules have not yet removed are included, as listings do not
+// include the expiry recorded in each object's metadata. Capacity is reported as unlimited.
+func (s *S3) Stats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	err := s.listObjects(ctx, "", func(_ Key, objInfo minio.ObjectInfo) error {
+		stats.Objects++
+		stats.Size += objInfo.Size
+		return nil
+	})
+	if err != nil {
+		return Stats{}, err
+	}
+	return stats, nil
 }
 
 type s3Writer struct {
 	s3        *S3
 	key       Key
 	pipe      *io.PipeWriter
+	ttl       time.Duration
 	expiresAt time.Time
 	headers   http.Header
+	exclusive bool // Only commit if no object exists.
 	ctx       context.Context
 	errCh     chan error
 	uploadErr error
+	digest    hash.Hash
+	size      int64
+	etag      string // Of the uploaded object, set before the upload reports success.
 }
 
 func (w *s3Writer) Write(p []byte) (int, error) {
 	n, err := w.pipe.Write(p)
+	w.digest.Write(p[:n])
+	w.size += int64(n)
 	if err != nil {
 		// Check if upload failed - if so, return that error instead
 		select {
@@ -366,9 +664,71 @@ func (w *s3Writer) Close() error {
 		return err
 	}
 
+	return w.recordDigest()
+}
+
+// recordDigest adds the digest and size of the uploaded body to the object's headers. Metadata cannot be changed once an
+// upload has started, so the object is copied onto itself with the digest added.
+//
+// The copy is conditional on the object being unchanged, as the digest of another writer's object is recorded by that
+// writer. If the digest cannot be recorded the object is deleted, so that every object has a digest.
+func (w *s3Writer) recordDigest() error {
+	headers := maps.Clone(w.headers)
+	if headers == nil {
+		headers = http.Header{}
+	}
+	recordBody(headers, w.digest.Sum(nil), w.size)
+	// The expiry is reset to be relative to the copy, whose LastModified time is compared to it to detect skew.
+	userMetadata, err := s3Metadata(w.ttl, time.Now().Add(w.ttl), headers)
+	if err != nil {
+		return err
+	}
+	objectName := w.s3.keyToPath(w.key)
+	// Compose rather than copy, as single copies are limited to 5GB.
+	_, err = w.s3.client.ComposeObject(w.ctx,
+		minio.CopyDestOptions{
+			Bucket:          w.s3.config.Bucket,
+			Object:          objectName,
+			UserMetadata:    userMetadata,
+			ReplaceMetadata: true,
+			// Tags are not returned when the source is stat'd, so would otherwise be lost.
+			UserTags:    s3UserTags(headers),
+			ReplaceTags: true,
+		},
+		minio.CopySrcOptions{Bucket: w.s3.config.Bucket, Object: objectName, MatchETag: w.etag},
+	)
+	if minio.ToErrorResponse(err).Code == s3ErrPreconditionFailed {
+		return nil
+	} else if err != nil {
+		return errors.Join(errors.Errorf("failed to record digest: %w", err), w.s3.Delete(context.WithoutCancel(w.ctx), w.key))
+	}
 	return nil
 }
 
+// s3Metadata returns the user metadata storing the expiry and headers of an object.
+//
+// The absolute expiry is stored alongside the TTL for instances that predate the TTL being stored.
+func s3Metadata(ttl time.Duration, expiresAt time.Time, headers http.Header) (map[string]string, error) {
+	userMetadata := map[string]string{"Ttl": ttl.String()}
+
+	// Store expiration time
+	expiresAtBytes, err := expiresAt.MarshalText()
+	if err != nil {
+		return nil, errors.Errorf("failed to marshal expiration time: %w", err)
+	}
+	userMetadata["Expires-At"] = string(expiresAtBytes)
+
+	// Store headers as JSON
+	if len(headers) > 0 {
+		headersJSON, err := json.Marshal(headers)
+		if err != nil {
+			return nil, errors.Errorf("failed to marshal headers: %w", err)
+		}
+		userMetadata["Headers"] = string(headersJSON)
+	}
+	return userMetadata, nil
+}
+
 func (w *s3Writer) upload(pr *io.PipeReader) {
 	var uploadErr error
 	defer func() {
@@ -378,55 +738,53 @@ func (w *s3Writer) upload(pr *io.PipeReader) {
 
 	objectName := w.s3.keyToPath(w.key)
 
-	// Prepare user metadata
-	userMetadata := make(map[string]string)
-
-	// Store expiration time
-	expiresAtBytes, err := w.expiresAt.MarshalText()
+	userMetadata, err := s3Metadata(w.ttl, w.expiresAt, w.headers)
 	if err != nil {
-		uploadErr = errors.Errorf("failed to marshal expiration time: %w", err)
+		uploadErr = err
 		w.errCh <- uploadErr
 		return
 	}
-	userMetadata["Expires-At"] = string(expiresAtBytes)
-
-	// Store headers as JSON
-	if len(w.headers) > 0 {
-		headersJSON, err := json.Marshal(w.headers)
-		if err != nil {
-			uploadErr = errors.Errorf("failed to marshal headers: %w", err)
-			w.errCh <- uploadErr
-			return
-		}
-		userMetadata["Headers"] = string(headersJSON)
-	}
 
 	// Configure upload options
 	opts := minio.PutObjectOptions{
 		UserMetadata: userMetadata,
+		UserTags:     s3UserTags(w.headers),
+	}
+	if w.exclusive {
+		opts.SetMatchETagExcept("*")
 	}
 
+	// Parts of an object of unknown size are buffered in memory, and would otherwise default to over 500MB.
+	opts.PartSize = uint64(w.s3.config.UploadPartSizeMB) * 1024 * 1024 // Convert MB to bytes
 	// Enable concurrent streaming for multi-part uploads if configured
 	if w.s3.config.UploadConcurrency > 1 {
 		opts.ConcurrentStreamParts = true
 		opts.NumThreads = w.s3.config.UploadConcurrency
-		opts.PartSize = uint64(w.s3.config.UploadPartSizeMB) * 1024 * 1024 // Convert MB to bytes
 	}
 
+	// Cancelling the writer fails the next read of the body rather than the upload's requests, so that the multipart
+	// upload is aborted rather than left behind, as minio aborts it in the upload's context.
+	stop := context.AfterFunc(w.ctx, func() { _ = pr.CloseWithError(context.Cause(w.ctx)) })
 	// Upload object with streaming (size -1 means unknown size, will use chunked encoding)
-	_, err = w.s3.client.PutObject(
-		w.ctx,
+	info, err := w.s3.client.PutObject(
+		context.WithoutCancel(w.ctx),
 		w.s3.config.Bucket,
 		objectName,
 		pr,
 		-1,
 		opts,
 	)
+	stop()
 	if err != nil {
-		uploadErr = errors.Errorf("failed to put object: %w", err)
+		if w.exclusive && minio.ToErrorResponse(err).Code == s3ErrPreconditionFailed {
+			uploadErr = errors.WithStack(ErrExists)
+		} else {
+			uploadErr = errors.Errorf("failed to put object: %w", err)
+		}
 		w.errCh <- uploadErr
 		return
 	}
 
+	w.etag = info.ETag
 	w.errCh <- nil
 }
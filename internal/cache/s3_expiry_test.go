@@ -0,0 +1,77 @@
+package cache //nolint:testpackage // white-box testing required to evaluate expiry against a skewed clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+func TestS3Expiry(t *testing.T) {
+	lastModified := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	expiresAt := func(writerSkew time.Duration) string {
+		text, err := lastModified.Add(writerSkew + time.Hour).MarshalText()
+		assert.NoError(t, err)
+		return string(text)
+	}
+
+	tests := []struct {
+		name      string
+		metadata  map[string]string
+		clockSkew time.Duration
+		now       time.Time
+		expired   bool
+	}{
+		{
+			// The writer's clock ran five minutes slow, so by its clock the object has already expired.
+			name:     "SlowWriterWithinTTL",
+			metadata: map[string]string{"Ttl": "1h0m0s", "Expires-At": expiresAt(-5 * time.Minute)},
+			now:      lastModified.Add(59 * time.Minute),
+		},
+		{
+			// The writer's clock ran five minutes fast, so by its clock the object has not yet expired.
+			name:     "FastWriterPastTTL",
+			metadata: map[string]string{"Ttl": "1h0m0s", "Expires-At": expiresAt(5 * time.Minute)},
+			now:      lastModified.Add(61 * time.Minute),
+			expired:  true,
+		},
+		{
+			// LastModified is truncated to the second, so sub-second differences are not skew.
+			name:     "SubSecondExpiryKept",
+			metadata: map[string]string{"Ttl": "1h0m0s", "Expires-At": expiresAt(500 * time.Millisecond)},
+			now:      lastModified.Add(time.Hour + 400*time.Millisecond),
+		},
+		{
+			name:      "WithinSkewTolerance",
+			metadata:  map[string]string{"Ttl": "1h0m0s", "Expires-At": expiresAt(0)},
+			clockSkew: 30 * time.Second,
+			now:       lastModified.Add(time.Hour + 20*time.Second),
+		},
+		{
+			name:      "BeyondSkewTolerance",
+			metadata:  map[string]string{"Ttl": "1h0m0s", "Expires-At": expiresAt(0)},
+			clockSkew: 30 * time.Second,
+			now:       lastModified.Add(time.Hour + time.Minute),
+			expired:   true,
+		},
+		{
+			name:     "LegacyExpiresAt",
+			metadata: map[string]string{"Expires-At": expiresAt(-5 * time.Minute)},
+			now:      lastModified.Add(59 * time.Minute),
+			expired:  true,
+		},
+		{
+			name:     "NoExpiry",
+			metadata: map[string]string{},
+			now:      lastModified.Add(24 * time.Hour),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3 := &S3{config: S3Config{ClockSkew: tt.clockSkew}}
+			objInfo := minio.ObjectInfo{LastModified: lastModified, UserMetadata: tt.metadata}
+			assert.Equal(t, tt.expired, s3.expired(objInfo, tt.now))
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package cache
+
+import (
+	"context"
+	"iter"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/alecthomas/errors"
+	"github.com/minio/minio-go/v7"
+)
+
+var (
+	_ Purger    = (*S3)(nil)
+	_ TagLister = (*S3)(nil)
+	_ Lister    = (*S3)(nil)
+)
+
+// s3TagPrefix namespaces the S3 object tags that record an object's tags.
+const s3TagPrefix = "cachew-tag:"
+
+// s3UserTags returns the S3 object tags recording the tags in headers.
+func s3UserTags(headers http.Header) map[string]string {
+	tags := Tags(headers)
+	if len(tags) == 0 {
+		return nil
+	}
+	userTags := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		userTags[s3TagPrefix+tag] = "true"
+	}
+	return userTags
+}
+
+// Purge removes objects selected by listing the whole bucket, so takes time proportional to its size.
+//
+// Objects last modified more than OlderThan ago are removed, then the least recently modified objects until the
+// bucket is no larger than TargetBytes. If Tag is set, only objects created with it are removed, which also requires
+// reading the tags of every object.
+func (s *S3) Purge(ctx context.Context, options PurgeOptions) (PurgeResult, error) {
+	if err := options.validate(); err != nil {
+		return PurgeResult{}, err
+	}
+	var cutoff time.Time
+	if options.OlderThan > 0 {
+		cutoff = time.Now().Add(-options.OlderThan)
+	}
+
+	var result PurgeResult
+	var retained []minio.ObjectInfo
+	var retainedBytes int64
+	err := s.listObjects(ctx, "", func(_ Key, objInfo minio.ObjectInfo) error {
+		if options.Tag != "" {
+			tagged, err := s.hasTag(ctx, objInfo.Key, options.Tag)
+			if err != nil || !tagged {
+				return err
+			}
+		}
+		if (options.Tag != "" && cutoff.IsZero()) || objInfo.LastModified.Before(cutoff) {
+			return s.purgeObject(ctx, objInfo, &result)
+		}
+		if options.TargetBytes > 0 {
+			retained = append(retained, objInfo)
+			retainedBytes += objInfo.Size
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	slices.SortFunc(retained, func(a, b minio.ObjectInfo) int { return a.LastModified.Compare(b.LastModified) })
+	for _, objInfo := range retained {
+		if retainedBytes <= options.TargetBytes {
+			break
+		}
+		if err := s.purgeObject(ctx, objInfo, &result); err != nil {
+			return result, err
+		}
+		retainedBytes -= objInfo.Size
+	}
+	return result, nil
+}
+
+func (s *S3) purgeObject(ctx context.Context, objInfo minio.ObjectInfo, result *PurgeResult) error {
+	if err := s.client.RemoveObject(ctx, s.config.Bucket, objInfo.Key, minio.RemoveObjectOptions{}); err != nil {
+		return errors.Errorf("failed to remove object: %w", err)
+	}
+	result.Objects++
+	result.Bytes += objInfo.Size
+	return nil
+}
+
+// ListTagged returns the unexpired objects created with tag, by listing the whole bucket and reading the tags of
+// every object.
+func (s *S3) ListTagged(ctx context.Context, tag string) ([]ObjectInfo, error) {
+	now := time.Now()
+	var objects []ObjectInfo
+	err := s.listObjects(ctx, "", func(key Key, objInfo minio.ObjectInfo) error {
+		tagged, err := s.hasTag(ctx, objInfo.Key, tag)
+		if err != nil || !tagged {
+			return err
+		}
+		// Listings do not include user metadata, so the expiry is read from the object itself.
+		objInfo, err = s.client.StatObject(ctx, s.config.Bucket, objInfo.Key, minio.StatObjectOptions{})
+		if minio.ToErrorResponse(err).Code == s3ErrNoSuchKey {
+			return nil
+		} else if err != nil {
+			return errors.Errorf("failed to stat object: %w", err)
+		}
+		if !s.expired(objInfo, now) {
+			objects = append(objects, ObjectInfo{Key: key, Size: objInfo.Size})
+		}
+		return nil
+	})
+	return objects, err
+}
+
+// errStopListing is returned by listObjects callbacks to end the listing early.
+var errStopListing = errors.New("stop listing")
+
+// ListKeys returns the unexpired objects whose keys start with prefix, by listing the objects with the prefix and
+// reading the headers of each.
+func (s *S3) ListKeys(ctx context.Context, prefix string) iter.Seq2[ObjectInfo, error] {
+	return func(yield func(ObjectInfo, error) bool) {
+		err := s.listObjects(ctx, prefix, func(key Key, _ minio.ObjectInfo) error {
+			// Listings do not include user metadata, so the headers and expiry are read from the object itself.
+			_, objInfo, headers, err := s.statObject(ctx, key)
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if !yield(ObjectInfo{Key: key, Size: objInfo.Size, Headers: headers, ExpiresAt: s3ExpiresAt(objInfo)}, nil) {
+				return errStopListing
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errStopListing) {
+			yield(ObjectInfo{}, err)
+		}
+	}
+}
+
+// listObjects calls fn for each cache object in the bucket whose key starts with the hex prefix, ignoring any other
+// objects.
+//
+// Listings are paginated, with the next page fetched as the previous one is consumed. If ctx is cancelled before the
+// listing is complete its error is returned, so that a partial listing is never mistaken for a complete one.
+func (s *S3) listObjects(ctx context.Context, prefix string, fn func(key Key, objInfo minio.ObjectInfo) error) error {
+	if len(prefix) >= 2 {
+		// Objects are stored under directories named by the first two hex digits of their keys.
+		prefix = prefix[:2] + "/" + prefix
+	}
+	for objInfo := range s.client.ListObjectsIter(ctx, s.config.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if objInfo.Err != nil {
+			return errors.Errorf("failed to list objects: %w", objInfo.Err)
+		}
+		key, err := ParseKey(path.Base(objInfo.Key))
+		if err != nil || s.keyToPath(key) != objInfo.Key {
+			continue
+		}
+		if err := fn(key, objInfo); err != nil {
+			return err
+		}
+	}
+	return errors.WithStack(ctx.Err())
+}
+
+// hasTag returns true if the named object was created with tag.
+func (s *S3) hasTag(ctx context.Context, objectName, tag string) (bool, error) {
+	objectTags, err := s.client.GetObjectTagging(ctx, s.config.Bucket, objectName, minio.GetObjectTaggingOptions{})
+	if minio.ToErrorResponse(err).Code == s3ErrNoSuchKey {
+		// Removed since it was listed.
+		return false, nil
+	} else if err != nil {
+		return false, errors.Errorf("failed to get object tags: %w", err)
+	}
+	_, ok := objectTags.ToMap()[s3TagPrefix+tag]
+	return ok, nil
+}
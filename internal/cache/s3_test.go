@@ -1,9 +1,19 @@
 package cache_test
 
 import (
+	"bytes"
+	"context"
+	"io"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -147,6 +157,26 @@ func TestS3Cache(t *testing.T) {
 	})
 }
 
+func TestS3CacheSlidingExpiration(t *testing.T) {
+	startMinio(t)
+	cleanBucket(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", minioUsername)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", minioPassword)
+
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	c, err := cache.NewS3(ctx, cache.S3Config{
+		Endpoint:          minioAddr,
+		Bucket:            minioBucket,
+		UseSSL:            false,
+		MaxTTL:            time.Minute,
+		UploadPartSizeMB:  16,
+		SlidingExpiration: true,
+	})
+	assert.NoError(t, err)
+	// Expiry is stored with a precision of one second, so the TTL must be well above that.
+	cachetest.SlidingExpiration(t, c, 5*time.Second)
+}
+
 func TestS3CacheSoak(t *testing.T) {
 	if os.Getenv("SOAK_TEST") == "" {
 		t.Skip("Skipping soak test; set SOAK_TEST=1 to run")
@@ -184,3 +214,481 @@ func TestS3CacheSoak(t *testing.T) {
 		TTL:              5 * time.Minute,
 	})
 }
+
+// TestS3OpenRange verifies that ranged reads only fetch the requested bytes from S3, using a fake S3 server.
+func TestS3OpenRange(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	key := cache.NewKey("large")
+	objectPath := "/" + minioBucket + "/" + key.String()[:2] + "/" + key.String()
+	content := []byte("0123456789abcdefghij")
+	lastModified := time.Now().Add(-time.Hour)
+
+	var getRanges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/"+minioBucket+"/" && r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == objectPath:
+			w.Header().Set("ETag", `"etag"`)
+			w.Header().Set("X-Amz-Meta-Headers", `{"Content-Type":["application/octet-stream"]}`)
+			if r.Method == http.MethodGet {
+				getRanges = append(getRanges, r.Header.Get("Range"))
+			}
+			http.ServeContent(w, r, "", lastModified, bytes.NewReader(content))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", minioUsername)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", minioPassword)
+	c, err := cache.NewS3(ctx, cache.S3Config{
+		Endpoint:         strings.TrimPrefix(server.URL, "http://"),
+		Bucket:           minioBucket,
+		Region:           "us-west-2",
+		MaxTTL:           time.Hour,
+		UploadPartSizeMB: 16,
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	reader, headers, err := c.OpenRange(ctx, key, cache.Range{Start: 5, End: 9})
+	assert.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.NoError(t, reader.Close())
+	assert.Equal(t, "56789", string(data))
+	assert.Equal(t, "bytes 5-9/20", headers.Get("Content-Range"))
+	assert.Equal(t, "5", headers.Get("Content-Length"))
+	assert.Equal(t, "application/octet-stream", headers.Get("Content-Type"))
+	assert.Equal(t, []string{"bytes=5-9"}, getRanges)
+
+	_, _, err = c.OpenRange(ctx, key, cache.Range{Start: 20, End: -1})
+	_, ok := errors.AsType[*cache.RangeNotSatisfiableError](err)
+	assert.True(t, ok, "expected RangeNotSatisfiableError, got %v", err)
+	assert.Equal(t, 1, len(getRanges), "unsatisfiable ranges should not be fetched")
+}
+
+// fakeMultipartS3 accepts multipart uploads, discarding their bodies.
+type fakeMultipartS3 struct {
+	server   *httptest.Server
+	uploaded atomic.Int64
+	aborted  atomic.Int32
+}
+
+func newFakeMultipartS3(t *testing.T) *fakeMultipartS3 {
+	t.Helper()
+	f := &fakeMultipartS3{}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path == "/"+minioBucket+"/" && r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodPost && query.Has("uploads"):
+			_, _ = io.WriteString(w, `<InitiateMultipartUploadResult><UploadId>upload</UploadId></InitiateMultipartUploadResult>`)
+		case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+			_, _ = io.WriteString(w, `<CopyObjectResult><LastModified>2024-01-01T00:00:00.000Z</LastModified><ETag>"copy"</ETag></CopyObjectResult>`)
+		case r.Method == http.MethodPut && query.Has("partNumber"):
+			n, _ := io.Copy(io.Discard, r.Body)
+			// Signed bodies are framed in chunks, with the length of the part in a header.
+			if decoded, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64); err == nil {
+				n = decoded
+			}
+			f.uploaded.Add(n)
+			w.Header().Set("ETag", `"part"`)
+		case r.Method == http.MethodPost && query.Has("uploadId"):
+			_, _ = io.WriteString(w, `<CompleteMultipartUploadResult><Bucket>`+minioBucket+`</Bucket><ETag>"object"</ETag></CompleteMultipartUploadResult>`)
+		case r.Method == http.MethodDelete && query.Has("uploadId"):
+			f.aborted.Add(1)
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodHead:
+			w.Header().Set("ETag", `"object"`)
+			w.Header().Set("Content-Length", strconv.FormatInt(f.uploaded.Load(), 10))
+			w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func TestS3StreamingUpload(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	fake := newFakeMultipartS3(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", minioUsername)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", minioPassword)
+	c, err := cache.NewS3(ctx, cache.S3Config{
+		Endpoint:          strings.TrimPrefix(fake.server.URL, "http://"),
+		Bucket:            minioBucket,
+		Region:            "us-west-2",
+		MaxTTL:            time.Hour,
+		UploadConcurrency: 1,
+		UploadPartSizeMB:  5,
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	t.Run("HeapBounded", func(t *testing.T) {
+		const size = 100 << 20
+		chunk := bytes.Repeat([]byte{'x'}, 1<<20)
+		var stats runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		baseline := stats.HeapAlloc
+		var peak uint64
+
+		w, err := c.Create(ctx, cache.NewKey("large"), http.Header{}, time.Hour)
+		assert.NoError(t, err)
+		for written := 0; written < size; written += len(chunk) {
+			_, err := w.Write(chunk)
+			assert.NoError(t, err)
+			runtime.ReadMemStats(&stats)
+			peak = max(peak, stats.HeapAlloc)
+		}
+		assert.NoError(t, w.Close())
+
+		assert.Equal(t, int64(size), fake.uploaded.Load())
+		growth := int64(peak) - int64(baseline) //nolint:gosec
+		assert.True(t, growth < 64<<20, "heap grew by %dMB while uploading", growth>>20)
+	})
+
+	t.Run("CancelAborts", func(t *testing.T) {
+		aborted := fake.aborted.Load()
+		ctx, cancel := context.WithCancel(ctx)
+		w, err := c.Create(ctx, cache.NewKey("cancelled"), http.Header{}, time.Hour)
+		assert.NoError(t, err)
+		_, err = w.Write(bytes.Repeat([]byte{'x'}, 6<<20))
+		assert.NoError(t, err)
+		cancel()
+		assert.Error(t, w.Close())
+		assert.Equal(t, aborted+1, fake.aborted.Load())
+	})
+}
+
+func TestS3Stats(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	objectPath := func(key cache.Key) string { return key.String()[:2] + "/" + key.String() }
+	pages := []string{
+		`<Contents><Key>` + objectPath(cache.NewKey("a")) + `</Key><Size>10</Size></Contents>` +
+			`<Contents><Key>` + objectPath(cache.NewKey("b")) + `</Key><Size>20</Size></Contents>` +
+			`<Contents><Key>not-a-cache-object</Key><Size>1000</Size></Contents>`,
+		`<Contents><Key>` + objectPath(cache.NewKey("c")) + `</Key><Size>30</Size></Contents>`,
+	}
+
+	var listRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/"+minioBucket+"/" && r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/"+minioBucket+"/" && r.URL.Query().Get("list-type") == "2":
+			listRequests++
+			page := 0
+			if r.URL.Query().Get("continuation-token") == "page-1" {
+				page = 1
+			}
+			truncated := `<IsTruncated>false</IsTruncated>`
+			if page == 0 {
+				truncated = `<IsTruncated>true</IsTruncated><NextContinuationToken>page-1</NextContinuationToken>`
+			}
+			w.Header().Set("Content-Type", "application/xml")
+			_, _ = io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+				`<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`+
+				`<Name>`+minioBucket+`</Name>`+truncated+pages[page]+`</ListBucketResult>`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", minioUsername)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", minioPassword)
+	c, err := cache.NewS3(ctx, cache.S3Config{
+		Endpoint:         strings.TrimPrefix(server.URL, "http://"),
+		Bucket:           minioBucket,
+		Region:           "us-west-2",
+		MaxTTL:           time.Hour,
+		UploadPartSizeMB: 16,
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	stats, err := c.Stats(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, cache.Stats{Objects: 3, Size: 60}, stats)
+	assert.Equal(t, 2, listRequests)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = c.Stats(cancelled)
+	assert.IsError(t, err, context.Canceled)
+}
+
+func TestS3ListKeys(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	objectPath := func(key cache.Key) string { return key.String()[:2] + "/" + key.String() }
+	keys := []cache.Key{cache.NewKey("a"), cache.NewKey("b")}
+
+	var listPrefixes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/"+minioBucket+"/" && r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/"+minioBucket+"/" && r.URL.Query().Get("list-type") == "2":
+			prefix := r.URL.Query().Get("prefix")
+			listPrefixes = append(listPrefixes, prefix)
+			contents := ""
+			for _, key := range keys {
+				if strings.HasPrefix(objectPath(key), prefix) {
+					contents += `<Contents><Key>` + objectPath(key) + `</Key><Size>10</Size></Contents>`
+				}
+			}
+			w.Header().Set("Content-Type", "application/xml")
+			_, _ = io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+				`<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`+
+				`<Name>`+minioBucket+`</Name><IsTruncated>false</IsTruncated>`+contents+`</ListBucketResult>`)
+		case r.Method == http.MethodHead:
+			w.Header().Set("Content-Length", "10")
+			w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+			w.Header().Set("ETag", `"etag"`)
+			w.Header().Set("X-Amz-Meta-Headers", `{"Content-Type":["text/plain"]}`)
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", minioUsername)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", minioPassword)
+	c, err := cache.NewS3(ctx, cache.S3Config{
+		Endpoint:         strings.TrimPrefix(server.URL, "http://"),
+		Bucket:           minioBucket,
+		Region:           "us-west-2",
+		MaxTTL:           time.Hour,
+		UploadPartSizeMB: 16,
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	list := func(prefix string) []cache.ObjectInfo {
+		t.Helper()
+		var objects []cache.ObjectInfo
+		for object, err := range cache.ListKeys(ctx, c, prefix) {
+			assert.NoError(t, err)
+			objects = append(objects, object)
+		}
+		return objects
+	}
+
+	objects := list("")
+	assert.Equal(t, 2, len(objects))
+	assert.Equal(t, "text/plain", objects[0].Headers.Get("Content-Type"))
+	assert.Equal(t, int64(10), objects[0].Size)
+
+	prefix := keys[1].String()[:4]
+	objects = list(prefix)
+	assert.Equal(t, 1, len(objects))
+	assert.Equal(t, keys[1], objects[0].Key)
+	assert.Equal(t, []string{"", prefix[:2] + "/" + prefix}, listPrefixes)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	var listErr error
+	for _, err := range cache.ListKeys(cancelled, c, "") {
+		listErr = err
+	}
+	assert.IsError(t, listErr, context.Canceled)
+}
+
+type countingRoundTripper struct {
+	requests atomic.Int32
+}
+
+func (c *countingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests.Add(1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestS3CustomTransport(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/"+minioBucket+"/" && r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", minioUsername)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", minioPassword)
+	transport := &countingRoundTripper{}
+	c, err := cache.NewS3(ctx, cache.S3Config{
+		Endpoint:         strings.TrimPrefix(server.URL, "http://"),
+		Bucket:           minioBucket,
+		Region:           "us-west-2",
+		MaxTTL:           time.Hour,
+		UploadPartSizeMB: 16,
+		Transport:        transport,
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, int32(1), transport.requests.Load(), "bucket check should use the configured transport")
+}
+
+// fakeS3Endpoint serves a single object from a bucket, recording the methods of requests for it.
+type fakeS3Endpoint struct {
+	server  *httptest.Server
+	methods []string
+	failing atomic.Bool
+	// headers is the JSON encoded headers metadata the object is served with, if any.
+	headers string
+}
+
+func newFakeS3Endpoint(t *testing.T, objectPath string, content []byte) *fakeS3Endpoint {
+	t.Helper()
+	e := &fakeS3Endpoint{}
+	var mu sync.Mutex
+	e.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/"+minioBucket+"/" && r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == objectPath:
+			mu.Lock()
+			e.methods = append(e.methods, r.Method)
+			mu.Unlock()
+			switch {
+			case e.failing.Load():
+				w.WriteHeader(http.StatusServiceUnavailable)
+			case r.Method == http.MethodDelete:
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				w.Header().Set("ETag", `"etag"`)
+				if e.headers != "" {
+					w.Header().Set("X-Amz-Meta-Headers", e.headers)
+				}
+				http.ServeContent(w, r, "", time.Now(), bytes.NewReader(content))
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(e.server.Close)
+	return e
+}
+
+func (e *fakeS3Endpoint) host() string { return strings.TrimPrefix(e.server.URL, "http://") }
+
+func (e *fakeS3Endpoint) reset() []string {
+	methods := e.methods
+	e.methods = nil
+	return methods
+}
+
+func TestS3ReadReplicas(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	key := cache.NewKey("replicated")
+	objectPath := "/" + minioBucket + "/" + key.String()[:2] + "/" + key.String()
+	primary := newFakeS3Endpoint(t, objectPath, []byte("content"))
+	replica := newFakeS3Endpoint(t, objectPath, []byte("content"))
+
+	t.Setenv("AWS_ACCESS_KEY_ID", minioUsername)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", minioPassword)
+	c, err := cache.NewS3(ctx, cache.S3Config{
+		Endpoint:         primary.host(),
+		Bucket:           minioBucket,
+		Region:           "us-west-2",
+		MaxTTL:           time.Hour,
+		UploadPartSizeMB: 16,
+		ReadWeight:       1,
+		ReadReplicas:     []cache.S3ReadReplicaConfig{{Endpoint: replica.host(), Weight: 2}},
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	// Reads are distributed according to weight.
+	for range 6 {
+		_, err := c.Stat(ctx, key)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, len(primary.reset()))
+	assert.Equal(t, 4, len(replica.reset()))
+
+	// The body is fetched from the endpoint the object was found on, and a failing replica falls back to the primary.
+	replica.failing.Store(true)
+	for range 3 {
+		r, _, err := c.Open(ctx, key)
+		assert.NoError(t, err)
+		data, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.NoError(t, r.Close())
+		assert.Equal(t, "content", string(data))
+	}
+	assert.Equal(t, []string{http.MethodHead, http.MethodGet, http.MethodHead, http.MethodGet, http.MethodHead, http.MethodGet}, primary.reset())
+	assert.Equal(t, []string{http.MethodHead, http.MethodHead}, replica.reset())
+
+	// Writes only go to the primary.
+	replica.failing.Store(false)
+	assert.NoError(t, c.Delete(ctx, key))
+	assert.Equal(t, []string{http.MethodDelete}, primary.reset())
+	assert.Equal(t, []string(nil), replica.reset())
+}
+
+func TestS3VerifyOnRead(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	key := cache.NewKey("verified")
+	objectPath := "/" + minioBucket + "/" + key.String()[:2] + "/" + key.String()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", minioUsername)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", minioPassword)
+	open := func(t *testing.T, endpoint *fakeS3Endpoint, verify bool) (string, error) {
+		t.Helper()
+		c, err := cache.NewS3(ctx, cache.S3Config{
+			Endpoint:         endpoint.host(),
+			Bucket:           minioBucket,
+			Region:           "us-west-2",
+			MaxTTL:           time.Hour,
+			UploadPartSizeMB: 16,
+			VerifyOnRead:     verify,
+		})
+		assert.NoError(t, err)
+		defer c.Close()
+		r, _, err := c.Open(ctx, key)
+		assert.NoError(t, err)
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		return string(data), err
+	}
+
+	// SHA-256 of "content".
+	const digest = "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73"
+	tests := []struct {
+		name          string
+		content       string
+		headers       string
+		verify        bool
+		expectErr     error
+		expectMethods []string
+	}{
+		{"Intact", "content", `{"X-Cachew-Sha256":["` + digest + `"]}`, true, nil, []string{http.MethodHead, http.MethodGet}},
+		{"Truncated", "cont", `{"X-Cachew-Sha256":["` + digest + `"]}`, true, cache.ErrCorrupt, []string{http.MethodHead, http.MethodGet, http.MethodDelete}},
+		{"NoDigest", "cont", `{}`, true, nil, []string{http.MethodHead, http.MethodGet}},
+		{"Disabled", "cont", `{"X-Cachew-Sha256":["` + digest + `"]}`, false, nil, []string{http.MethodHead, http.MethodGet}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint := newFakeS3Endpoint(t, objectPath, []byte(tt.content))
+			endpoint.headers = tt.headers
+			data, err := open(t, endpoint, tt.verify)
+			if tt.expectErr != nil {
+				assert.IsError(t, err, tt.expectErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.content, data)
+			}
+			assert.Equal(t, tt.expectMethods, endpoint.reset())
+		})
+	}
+}
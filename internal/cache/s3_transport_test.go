@@ -0,0 +1,30 @@
+package cache //nolint:testpackage // white-box testing required to inspect the transport given to the minio client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestNewS3Transport(t *testing.T) {
+	defaults, err := newS3Transport(S3Config{})
+	assert.NoError(t, err)
+
+	transport, err := newS3Transport(S3Config{
+		SkipSSLVerify:   true,
+		MaxIdleConns:    7,
+		IdleConnTimeout: 42 * time.Second,
+		MaxConnsPerHost: 3,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 7, transport.MaxIdleConns)
+	assert.Equal(t, 7, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, 42*time.Second, transport.IdleConnTimeout)
+	assert.Equal(t, 3, transport.MaxConnsPerHost)
+	assert.True(t, transport.TLSClientConfig.InsecureSkipVerify)
+
+	// Unset values keep minio's defaults.
+	assert.NotEqual(t, 0, defaults.MaxIdleConns)
+	assert.NotEqual(t, time.Duration(0), defaults.IdleConnTimeout)
+}
@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/alecthomas/errors"
+)
+
+// RegisterSalted cache decorator with the given registry.
+func RegisterSalted(r *Registry) {
+	RegisterDecorator(
+		r,
+		"salted",
+		"Mixes a per-deployment salt into every cache key so deployments sharing a backend never collide",
+		func(_ context.Context, config SaltedConfig, inner Cache) (Cache, error) {
+			return errors.WithStack2(NewSaltedCache(inner, config.KeySalt))
+		},
+	)
+}
+
+type SaltedConfig struct {
+	KeySalt string `hcl:"key-salt" help:"Secret mixed into every cache key. Changing it orphans all existing objects."`
+}
+
+// Validate the configuration.
+func (c *SaltedConfig) Validate() error {
+	if c.KeySalt == "" {
+		return errors.New("key-salt is required")
+	}
+	return nil
+}
+
+// Salted is a [Cache] decorator that derives the key used in the underlying cache from the caller's key and a salt.
+//
+// Two deployments sharing a backend, such as an S3 bucket, with different salts store the same logical object under
+// different keys. Unlike a storage prefix this changes key derivation itself, so one deployment cannot address
+// another's objects even if it knows their logical keys.
+type Salted struct {
+	inner Cache
+	salt  []byte
+}
+
+var (
+	_ Cache            = (*Salted)(nil)
+	_ StaleOpener      = (*Salted)(nil)
+	_ Purger           = (*Salted)(nil)
+	_ ExclusiveCreator = (*Salted)(nil)
+	_ Epocher          = (*Salted)(nil)
+)
+
+// NewSalted creates a new [Salted] cache wrapping inner.
+func NewSalted(inner Cache, salt string) (*Salted, error) {
+	if salt == "" {
+		return nil, errors.New("key salt must not be empty")
+	}
+	return &Salted{inner: inner, salt: []byte(salt)}, nil
+}
+
+// NewSaltedCache is like [NewSalted], but the returned cache also implements [RangeOpener] if inner does.
+func NewSaltedCache(inner Cache, salt string) (Cache, error) {
+	s, err := NewSalted(inner, salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := inner.(RangeOpener); ok {
+		return saltedRangeOpener{s}, nil
+	}
+	return s, nil
+}
+
+// Key returns the key under which key is stored in the underlying cache.
+func (s *Salted) Key(key Key) Key {
+	mac := hmac.New(sha256.New, s.salt)
+	_, _ = mac.Write(key[:])
+	return truncateKey([32]byte(mac.Sum(nil)))
+}
+
+func (s *Salted) String() string { return "salted:" + s.inner.String() }
+
+func (s *Salted) Stat(ctx context.Context, key Key) (http.Header, error) {
+	return errors.WithStack2(s.inner.Stat(ctx, s.Key(key)))
+}
+
+func (s *Salted) Has(ctx context.Context, key Key) (bool, error) {
+	return errors.WithStack2(s.inner.Has(ctx, s.Key(key)))
+}
+
+func (s *Salted) Open(ctx context.Context, key Key) (io.ReadCloser, http.Header, error) {
+	return errors.WithStack3(s.inner.Open(ctx, s.Key(key)))
+}
+
+// OpenStale opens an object from the underlying cache that may have expired up to "grace" ago.
+func (s *Salted) OpenStale(ctx context.Context, key Key, grace time.Duration) (io.ReadCloser, http.Header, error) {
+	return errors.WithStack3(OpenStale(ctx, s.inner, s.Key(key), grace))
+}
+
+// Purge removes objects from the underlying cache, including those stored by deployments with other salts.
+func (s *Salted) Purge(ctx context.Context, options PurgeOptions) (PurgeResult, error) {
+	return errors.WithStack2(Purge(ctx, s.inner, options))
+}
+
+// CurrentEpoch returns the epoch of the underlying cache.
+func (s *Salted) CurrentEpoch(ctx context.Context) (uint64, error) {
+	return errors.WithStack2(CurrentEpoch(ctx, s.inner))
+}
+
+// AdvanceEpoch advances the epoch of the underlying cache, invalidating objects stored with every salt.
+func (s *Salted) AdvanceEpoch(ctx context.Context) (uint64, error) {
+	return errors.WithStack2(AdvanceEpoch(ctx, s.inner))
+}
+
+func (s *Salted) Create(ctx context.Context, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	return errors.WithStack2(s.inner.Create(ctx, s.Key(key), headers, ttl))
+}
+
+// CreateExclusive creates an object only if it does not already exist in the underlying cache.
+func (s *Salted) CreateExclusive(ctx context.Context, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	return errors.WithStack2(CreateExclusive(ctx, s.inner, s.Key(key), headers, ttl))
+}
+
+func (s *Salted) Touch(ctx context.Context, key Key, ttl time.Duration) error {
+	return errors.WithStack(s.inner.Touch(ctx, s.Key(key), ttl))
+}
+
+func (s *Salted) Delete(ctx context.Context, key Key) error {
+	return errors.WithStack(s.inner.Delete(ctx, s.Key(key)))
+}
+
+func (s *Salted) Stats(ctx context.Context) (Stats, error) {
+	return errors.WithStack2(s.inner.Stats(ctx))
+}
+
+func (s *Salted) Close() error { return errors.WithStack(s.inner.Close()) }
+
+// saltedRangeOpener is a [Salted] cache over a [RangeOpener].
+type saltedRangeOpener struct{ *Salted }
+
+var _ RangeOpener = saltedRangeOpener{}
+
+func (s saltedRangeOpener) OpenRange(ctx context.Context, key Key, rng Range) (io.ReadCloser, http.Header, error) {
+	return errors.WithStack3(s.inner.(RangeOpener).OpenRange(ctx, s.Key(key), rng)) //nolint:forcetypeassert
+}
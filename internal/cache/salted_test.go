@@ -0,0 +1,68 @@
+package cache_test
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/cache/cachetest"
+	"github.com/block/cachew/internal/logging"
+)
+
+func TestSaltedCache(t *testing.T) {
+	cachetest.Suite(t, func(t *testing.T) cache.Cache {
+		_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+		inner, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: 100 * time.Millisecond})
+		assert.NoError(t, err)
+		c, err := cache.NewSaltedCache(inner, "salt")
+		assert.NoError(t, err)
+		return c
+	})
+}
+
+func TestSaltedKeys(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	inner, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer inner.Close()
+
+	tenantA, err := cache.NewSalted(inner, "tenant-a")
+	assert.NoError(t, err)
+	tenantB, err := cache.NewSalted(inner, "tenant-b")
+	assert.NoError(t, err)
+	tenantAAgain, err := cache.NewSalted(inner, "tenant-a")
+	assert.NoError(t, err)
+
+	key := cache.NewKey("https://example.com/artifact.zip")
+	assert.NotEqual(t, tenantA.Key(key), tenantB.Key(key))
+	assert.Equal(t, tenantA.Key(key), tenantAAgain.Key(key))
+	assert.NotEqual(t, key, tenantA.Key(key))
+
+	w, err := tenantA.Create(ctx, key, nil, 0)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("tenant a"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	// Only deployments with the same salt can see the object.
+	ok, err := tenantB.Has(ctx, key)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = inner.Has(ctx, key)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	r, _, err := tenantAAgain.Open(ctx, key)
+	assert.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "tenant a", string(data))
+
+	_, err = cache.NewSalted(inner, "")
+	assert.Error(t, err)
+}
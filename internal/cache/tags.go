@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/errors"
+)
+
+// TagHeader labels an object with tags when it is created, so that objects can be listed with [ListTagged] and
+// purged with [PurgeOptions.Tag] by tag, such as all those written by a CI run.
+//
+// The header may be repeated, and each value may be a comma separated list of tags.
+const TagHeader = "X-Cachew-Tag"
+
+// MaxTags is the maximum number of tags on an object, as limited by S3 object tagging.
+const MaxTags = 10
+
+// maxTagLength bounds tags so that they fit in an S3 object tag key.
+const maxTagLength = 64
+
+// ErrListUnavailable is returned when a cache backend cannot enumerate objects.
+var ErrListUnavailable = errors.New("list unavailable")
+
+// ObjectInfo describes an object enumerated from a cache.
+type ObjectInfo struct {
+	Key  Key   `json:"key"`
+	Size int64 `json:"size"`
+	// Headers are only populated by [ListKeys].
+	Headers http.Header `json:"headers,omitempty"`
+	// ExpiresAt is when the object expires. It is only populated by [ListKeys], and is zero if the cache cannot tell.
+	ExpiresAt time.Time `json:"expiresAt,omitzero"`
+}
+
+// TagLister is implemented by caches that can enumerate objects by tag.
+type TagLister interface {
+	// ListTagged returns the unexpired objects created with tag in their [TagHeader] header.
+	ListTagged(ctx context.Context, tag string) ([]ObjectInfo, error)
+}
+
+// ListTagged returns the unexpired objects in c created with tag.
+//
+// Returns [ErrListUnavailable] if the cache does not implement [TagLister].
+func ListTagged(ctx context.Context, c Cache, tag string) ([]ObjectInfo, error) {
+	if tl, ok := c.(TagLister); ok {
+		return errors.WithStack2(tl.ListTagged(ctx, tag))
+	}
+	return nil, errors.Errorf("%s: %w", c.String(), ErrListUnavailable)
+}
+
+// ValidateTag returns an error if tag cannot be used to label objects.
+//
+// Tags are limited to the characters S3 allows in object tags, excluding spaces.
+func ValidateTag(tag string) error {
+	if tag == "" || len(tag) > maxTagLength {
+		return errors.Errorf("tag %q must be between 1 and %d characters", tag, maxTagLength)
+	}
+	for _, r := range tag {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune("+-=._:/@", r):
+		default:
+			return errors.Errorf("tag %q may only contain letters, digits and any of +-=._:/@", tag)
+		}
+	}
+	return nil
+}
+
+// Tags returns the distinct tags in the [TagHeader] headers, in the order they first appear.
+//
+// Invalid tags, and any beyond [MaxTags], are ignored so that every backend labels an object with the same tags.
+func Tags(headers http.Header) []string {
+	var tags []string
+	for _, value := range headers.Values(TagHeader) {
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.TrimSpace(tag)
+			if len(tags) == MaxTags || ValidateTag(tag) != nil || slices.Contains(tags, tag) {
+				continue
+			}
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// hasTag returns true if headers label an object with tag.
+func hasTag(headers http.Header, tag string) bool {
+	return slices.Contains(Tags(headers), tag)
+}
@@ -0,0 +1,35 @@
+package cache_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/cache"
+)
+
+func TestTags(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		expect []string
+	}{
+		{name: "None"},
+		{name: "Repeated", values: []string{"ci", "run-1"}, expect: []string{"ci", "run-1"}},
+		{name: "CommaSeparated", values: []string{"ci, run-1", "release"}, expect: []string{"ci", "run-1", "release"}},
+		{name: "Duplicates", values: []string{"ci,ci", "ci"}, expect: []string{"ci"}},
+		{name: "Invalid", values: []string{"ci,not a tag,,", strings.Repeat("x", 65)}, expect: []string{"ci"}},
+		{name: "TooMany", values: []string{"0,1,2,3,4,5,6,7,8,9,10"}, expect: []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := http.Header{}
+			for _, value := range tt.values {
+				headers.Add(cache.TagHeader, value)
+			}
+			assert.Equal(t, tt.expect, cache.Tags(headers))
+		})
+	}
+}
@@ -3,43 +3,177 @@ package cache
 import (
 	"context"
 	"io"
+	"iter"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/alecthomas/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
 
 	"github.com/block/cachew/internal/logging"
 )
 
+// TieredConfig configures how a [Tiered] cache writes to its tiers.
+type TieredConfig struct {
+	AsyncTiers     []string      `hcl:"async-tiers,optional" help:"Backends, eg. \"s3\", to populate asynchronously once writes to the other tiers have committed."`
+	AsyncQueueSize int           `hcl:"async-queue-size,optional" help:"Maximum asynchronous writes in flight per tier before writers block." default:"16"`
+	AsyncRetries   int           `hcl:"async-retries,optional" help:"Number of times to retry a failed asynchronous write." default:"0"`
+	HedgeTiers     []string      `hcl:"hedge-tiers,optional" help:"Backends, eg. \"disk\", to also read from once a read from the other tiers takes longer than hedge-after, serving whichever returns first. They are populated when the other tiers serve an object they do not hold."`
+	HedgeAfter     time.Duration `hcl:"hedge-after,optional" help:"How long to wait for a read from the other tiers before also reading from hedge-tiers." default:"100ms"`
+	Promote        bool          `hcl:"promote,optional" help:"Copy objects served by a tier into the earlier tiers that do not hold them, so that later reads are served by the fastest tier."`
+	SmallTiers     []string      `hcl:"small-tiers,optional" help:"Backends, eg. \"memory\", that only store objects of at most small-object-max bytes. Larger objects are stored in the other tiers only."`
+	SmallObjectMax int64         `hcl:"small-object-max,optional" help:"Largest object, in bytes, stored in small-tiers." default:"1048576"`
+}
+
+// Validate the configuration.
+func (c *TieredConfig) Validate() error {
+	var errs []error
+	if len(c.AsyncTiers) > 0 && c.AsyncQueueSize < 1 {
+		errs = append(errs, errors.New("async-queue-size must be at least 1"))
+	}
+	if c.AsyncRetries < 0 {
+		errs = append(errs, errors.New("async-retries must not be negative"))
+	}
+	if c.HedgeAfter < 0 {
+		errs = append(errs, errors.New("hedge-after must not be negative"))
+	}
+	if len(c.SmallTiers) > 0 && c.SmallObjectMax < 1 {
+		errs = append(errs, errors.New("small-object-max must be at least 1"))
+	}
+	return errors.Join(errs...)
+}
+
 // The Tiered cache combines multiple caches.
 //
 // It is not directly selectable from configuration, but instead is automatically used if multiple caches are
 // configured.
+//
+// Writes to synchronous tiers complete before the writer is closed. Asynchronous tiers are populated afterwards by
+// copying the committed object from a synchronous tier, so reads served by the synchronous tiers always observe a
+// completed write, and writers only block on the asynchronous tiers once [TieredConfig.AsyncQueueSize] copies are
+// already in flight. Failed copies are logged and counted, and retried up to [TieredConfig.AsyncRetries] times.
+//
+// Reads normally try each tier in turn. If hedge tiers are configured, reads try the other tiers in turn, and if they
+// have not returned within [TieredConfig.HedgeAfter], eg. because S3 is slow or throttled, race them against a read
+// from the hedge tiers, serving whichever succeeds first. Objects served by the other tiers are copied to the hedge
+// tiers that do not hold them as they are read. If any tier is a [RangeOpener], so is the tiered cache, and ranged
+// reads are served by the first such tier that holds the object.
+//
+// If promotion is enabled, objects served by a tier are copied to the earlier tiers that missed them as they are read,
+// so that a memory tier in front of S3 serves subsequent reads.
+//
+// Copies expire when the object does in the tier it was read from, if that tier implements [ExpiryReporter], and
+// otherwise after the maximum TTL of the tier they are copied to.
+//
+// Small tiers, typically fast tiers with little capacity such as memory, only admit objects of at most
+// [TieredConfig.SmallObjectMax] bytes. Larger objects are not written to them, and any earlier version they hold is
+// deleted so that it is not served in place of the new object. Reads still check every tier.
 type Tiered struct {
 	caches []Cache
+	async  []bool
+	// slots bounds the number of asynchronous copies in flight per tier.
+	slots        []chan struct{}
+	pending      *sync.WaitGroup
+	retries      int
+	asyncFailure metric.Int64Counter
+	// hedge marks the tiers that reads are hedged against, if any.
+	hedge      []bool
+	hedgeAfter time.Duration
+	promote    bool
+	// maxSize is the size of the largest object each tier admits, or 0 if it admits objects of any size.
+	maxSize []int64
 }
 
 // MaybeNewTiered creates a [Tiered] cache if multiple are provided, or if there is only one it will return that cache.
 //
+// Tiers are selected for asynchronous writes by their backend name, the part of [Cache.String] before the first ":".
+//
 // If no caches are passed it will panic.
-func MaybeNewTiered(ctx context.Context, caches []Cache) Cache {
-	logging.FromContext(ctx).InfoContext(ctx, "Constructing tiered cache", "tiers", len(caches))
+func MaybeNewTiered(ctx context.Context, config TieredConfig, caches []Cache) (Cache, error) {
+	logging.FromContext(ctx).InfoContext(ctx, "Constructing tiered cache", "tiers", len(caches), "async", config.AsyncTiers)
 	if len(caches) == 0 {
 		panic("Tiered cache requires at least one backing cache")
 	}
 	if len(caches) == 1 {
-		return caches[0]
+		return caches[0], nil
 	}
-	return Tiered{caches}
+	asyncFailure, err := otel.Meter("github.com/block/cachew/internal/cache").Int64Counter("cachew.cache.tiered.async_write_failures",
+		metric.WithDescription("Number of failed attempts to populate an asynchronous cache tier"))
+	if err != nil {
+		return nil, errors.Errorf("failed to create metric: %w", err)
+	}
+	t := Tiered{
+		caches:       caches,
+		async:        make([]bool, len(caches)),
+		slots:        make([]chan struct{}, len(caches)),
+		pending:      &sync.WaitGroup{},
+		retries:      config.AsyncRetries,
+		asyncFailure: asyncFailure,
+		hedgeAfter:   config.HedgeAfter,
+		promote:      config.Promote,
+		maxSize:      make([]int64, len(caches)),
+	}
+	synchronous := 0
+	unlimited := 0
+	hedged := 0
+	for i, c := range caches {
+		name, _, _ := strings.Cut(c.String(), ":")
+		if slices.Contains(config.SmallTiers, name) {
+			t.maxSize[i] = max(config.SmallObjectMax, 1)
+		}
+		if slices.Contains(config.HedgeTiers, name) {
+			if t.hedge == nil {
+				t.hedge = make([]bool, len(caches))
+			}
+			t.hedge[i] = true
+			hedged++
+		}
+		if !slices.Contains(config.AsyncTiers, name) {
+			synchronous++
+			if t.maxSize[i] == 0 {
+				unlimited++
+			}
+			continue
+		}
+		t.async[i] = true
+		t.slots[i] = make(chan struct{}, max(config.AsyncQueueSize, 1))
+	}
+	if synchronous == 0 {
+		return nil, errors.New("at least one cache tier must be written synchronously")
+	}
+	if unlimited == 0 {
+		return nil, errors.New("at least one synchronously written cache tier must not be a small tier")
+	}
+	if hedged == len(caches) {
+		return nil, errors.New("at least one cache tier must not be a hedge tier")
+	}
+	for _, c := range caches {
+		if _, ok := c.(RangeOpener); ok {
+			return tieredRangeOpener{t}, nil
+		}
+	}
+	return t, nil
 }
 
-var _ Cache = (*Tiered)(nil)
+var (
+	_ Cache       = (*Tiered)(nil)
+	_ StaleOpener = (*Tiered)(nil)
+	_ Purger      = (*Tiered)(nil)
+	_ TagLister   = (*Tiered)(nil)
+	_ Lister      = (*Tiered)(nil)
+
+	_ ExclusiveCreator = (*Tiered)(nil)
+)
 
-// Close all underlying caches.
+// Close all underlying caches, after waiting for outstanding asynchronous writes.
 func (t Tiered) Close() error {
+	t.pending.Wait()
 	wg := sync.WaitGroup{}
 	errs := make([]error, len(t.caches))
 	for i, cache := range t.caches {
@@ -49,34 +183,169 @@ func (t Tiered) Close() error {
 	return errors.Join(errs...)
 }
 
-// Create a new object. All underlying caches will be written to in sequence.
+// Create a new object. All synchronous caches will be written to in sequence, and asynchronous caches populated
+// once the object is committed.
 func (t Tiered) Create(ctx context.Context, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	return t.createTiers(ctx, key, headers, ttl, -1)
+}
+
+// CreateExclusive creates an object only if it does not already exist in any tier.
+//
+// The object is created exclusively in the authoritative tier, the last synchronous tier that admits objects of any
+// size, and the writes to the other synchronous tiers are only committed once it has been committed there, so that of
+// concurrent writers only the first to close commits to any tier.
+func (t Tiered) CreateExclusive(ctx context.Context, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	exists, err := t.Has(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, errors.WithStack(ErrExists)
+	}
+	authoritative := -1
+	for i := range t.caches {
+		if !t.async[i] && t.maxSize[i] == 0 {
+			authoritative = i
+		}
+	}
+	return t.createTiers(ctx, key, headers, ttl, authoritative)
+}
+
+// createTiers creates an object in the synchronous tiers, exclusively in the tier at index "exclusive" if it is not -1.
+func (t Tiered) createTiers(ctx context.Context, key Key, headers http.Header, ttl time.Duration, exclusive int) (io.WriteCloser, error) {
 	// The first error will cancel all outstanding writes.
 	ctx, cancel := context.WithCancelCause(ctx)
 
-	tw := tieredWriter{make([]io.WriteCloser, len(t.caches)), cancel}
+	tw := tieredWriter{tiered: t, ctx: ctx, key: key, ttl: ttl, cancel: cancel}
+	var exclusiveWriter io.WriteCloser
 	// Note: we can't use errgroup here because we do not want to cancel the context on Wait().
+	var mu sync.Mutex
 	wg := sync.WaitGroup{}
-	for i, cache := range t.caches {
+	for i := range t.caches {
+		if t.async[i] {
+			continue
+		}
 		wg.Go(func() {
-			w, err := cache.Create(ctx, key, headers, ttl)
+			var w io.WriteCloser
+			var err error
+			if i == exclusive {
+				w, err = CreateExclusive(ctx, t.caches[i], key, headers, ttl)
+			} else {
+				w, err = t.create(ctx, i, key, headers, ttl)
+			}
 			if err != nil {
 				cancel(err)
+				return
+			}
+			mu.Lock()
+			if i == exclusive {
+				exclusiveWriter = w
+			} else {
+				tw.writers = append(tw.writers, w)
 			}
-			tw.writers[i] = w
+			mu.Unlock()
 		})
 	}
 	done := make(chan struct{})
 	go func() { wg.Wait(); close(done) }()
 	select {
 	case <-done:
-		return tw, nil
+		if exclusiveWriter != nil {
+			tw.writers = append([]io.WriteCloser{exclusiveWriter}, tw.writers...)
+			tw.exclusive = true
+		}
+		if ctx.Err() == nil {
+			return tw, nil
+		}
+		// A Create failed after the others completed, so Done may not have been selected.
+		for _, w := range tw.writers {
+			_ = w.Close()
+		}
+		return nil, errors.WithStack(context.Cause(ctx))
 
 	case <-ctx.Done():
 		return nil, errors.WithStack(context.Cause(ctx))
 	}
 }
 
+// create an object in the tier at index "tier", which is only committed if the tier admits objects of its size.
+func (t Tiered) create(ctx context.Context, tier int, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	cache := t.caches[tier]
+	limit := t.maxSize[tier]
+	if limit == 0 {
+		return errors.WithStack2(cache.Create(ctx, key, headers, ttl))
+	}
+	w := &sizeLimitedWriter{ctx: ctx, cache: cache, key: key, limit: limit}
+	if size, err := strconv.ParseInt(headers.Get("Content-Length"), 10, 64); err == nil && size > limit {
+		w.skipped = true
+		return w, nil
+	}
+	// Cancelling the write before closing discards the partial object.
+	writeCtx, cancel := context.WithCancel(ctx)
+	inner, err := cache.Create(writeCtx, key, headers, ttl)
+	if err != nil {
+		cancel()
+		return nil, errors.WithStack(err)
+	}
+	w.inner, w.cancel = inner, cancel
+	return w, nil
+}
+
+// populate copies a committed object from the synchronous tiers to the asynchronous tier at index "tier".
+func (t Tiered) populate(ctx context.Context, tier int, key Key, ttl time.Duration) {
+	logger := logging.FromContext(ctx)
+	cache := t.caches[tier]
+	for attempt := 0; ; attempt++ {
+		err := t.copyTo(ctx, tier, key, ttl)
+		if err == nil {
+			return
+		}
+		t.asyncFailure.Add(ctx, 1)
+		logger.WarnContext(ctx, "Asynchronous cache write failed",
+			"tier", cache.String(), "key", key.String(), "attempt", attempt+1, "error", err.Error())
+		if attempt >= t.retries || errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		select {
+		case <-time.After(time.Duration(attempt+1) * time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (t Tiered) copyTo(ctx context.Context, dst int, key Key, ttl time.Duration) error {
+	var r io.ReadCloser
+	var headers http.Header
+	err := error(os.ErrNotExist)
+	for i, c := range t.caches {
+		if t.async[i] {
+			continue
+		}
+		r, headers, err = c.Open(ctx, key)
+		if !errors.Is(err, os.ErrNotExist) {
+			break
+		}
+	}
+	if err != nil {
+		return errors.Wrap(err, "open committed object")
+	}
+	defer r.Close()
+	// Cancelling the write before closing discards the partial object.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := t.create(ctx, dst, key, headers, ttl)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		cancel()
+		_ = w.Close()
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(w.Close())
+}
+
 // Delete from all underlying caches. All errors are returned.
 func (t Tiered) Delete(ctx context.Context, key Key) error {
 	wg := sync.WaitGroup{}
@@ -88,6 +357,35 @@ func (t Tiered) Delete(ctx context.Context, key Key) error {
 	return errors.Join(errs...)
 }
 
+// Touch all underlying caches that contain the object.
+//
+// Returns os.ErrNotExist only if no cache contains the object.
+func (t Tiered) Touch(ctx context.Context, key Key, ttl time.Duration) error {
+	wg := sync.WaitGroup{}
+	errs := make([]error, len(t.caches))
+	for i, cache := range t.caches {
+		wg.Go(func() { errs[i] = errors.WithStack(cache.Touch(ctx, key, ttl)) })
+	}
+	wg.Wait()
+	var touched bool
+	var failed []error
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			touched = true
+		case !errors.Is(err, os.ErrNotExist):
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Join(failed...)
+	}
+	if !touched {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
 // Stat returns headers from the first cache that succeeds.
 //
 // If all caches fail, all errors are returned.
@@ -106,13 +404,293 @@ func (t Tiered) Stat(ctx context.Context, key Key) (http.Header, error) {
 	return nil, errors.Join(errs...)
 }
 
-// Open returns a reader from the first cache that succeeds.
+// Has returns true if any cache has the object.
+func (t Tiered) Has(ctx context.Context, key Key) (bool, error) {
+	var errs []error
+	for _, c := range t.caches {
+		ok, err := c.Has(ctx, key)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, errors.Join(errs...)
+}
+
+// Open returns a reader from the first cache that succeeds, hedging the read if hedge tiers are configured.
 //
 // If all caches fail, all errors are returned.
 func (t Tiered) Open(ctx context.Context, key Key) (io.ReadCloser, http.Header, error) {
-	errs := make([]error, len(t.caches))
+	if t.hedge != nil {
+		return t.openHedged(ctx, key)
+	}
+	return t.openTiers(ctx, key, func(int) bool { return true })
+}
+
+// openTiers returns a reader from the first of the tiers selected by include that succeeds, promoting the object to
+// the tiers that missed it if promotion is enabled.
+func (t Tiered) openTiers(ctx context.Context, key Key, include func(tier int) bool) (io.ReadCloser, http.Header, error) {
+	var errs []error
+	var missed []int
 	for i, c := range t.caches {
+		if !include(i) {
+			continue
+		}
 		r, headers, err := c.Open(ctx, key)
+		if errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+			missed = append(missed, i)
+			continue
+		} else if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		if t.promote && len(missed) > 0 {
+			if expiresAt, err := ExpiresAt(ctx, c, key); err == nil {
+				return t.populateTiers(ctx, key, r, headers, expiresAt, missed), headers, nil
+			}
+		}
+		return r, headers, nil
+	}
+	return nil, nil, errors.Join(errs...)
+}
+
+// tieredRangeOpener is a [Tiered] cache with at least one tier that is a [RangeOpener].
+type tieredRangeOpener struct{ Tiered }
+
+var _ RangeOpener = tieredRangeOpener{}
+
+// OpenRange reads the range from the first tier that supports ranges and holds the object. Tiers that do not support
+// ranges are skipped, and ranged reads are neither hedged nor promoted.
+//
+// If no tier holds the object, all errors are returned.
+func (t tieredRangeOpener) OpenRange(ctx context.Context, key Key, rng Range) (io.ReadCloser, http.Header, error) {
+	var errs []error
+	for _, c := range t.caches {
+		ro, ok := c.(RangeOpener)
+		if !ok {
+			continue
+		}
+		r, headers, err := ro.OpenRange(ctx, key, rng)
+		if errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		} else if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		return r, headers, nil
+	}
+	return nil, nil, errors.Join(errs...)
+}
+
+// openResult is the outcome of one side of a hedged read.
+type openResult struct {
+	r       io.ReadCloser
+	headers http.Header
+	err     error
+}
+
+// openHedged reads from the tiers that are not hedge tiers, racing them against the hedge tiers if they have not
+// returned within the hedge delay, or falling back to the hedge tiers if they fail.
+//
+// Each side of the race reads in its own context, which is cancelled when the reader it returns is closed, or
+// immediately if it loses.
+func (t Tiered) openHedged(ctx context.Context, key Key) (io.ReadCloser, http.Header, error) {
+	primaryCtx, cancelPrimary := context.WithCancel(ctx)
+	primary := make(chan openResult, 1)
+	go func() {
+		r, headers, err := t.openTiers(primaryCtx, key, func(i int) bool { return !t.hedge[i] })
+		primary <- openResult{r: r, headers: headers, err: err}
+	}()
+	timer := time.NewTimer(t.hedgeAfter)
+	defer timer.Stop()
+	select {
+	case res := <-primary:
+		if res.err == nil {
+			return t.populateHedgeTiers(ctx, key, &cancelReader{ReadCloser: res.r, cancel: cancelPrimary}, res.headers), res.headers, nil
+		}
+		cancelPrimary()
+		r, headers, err := t.openTiers(ctx, key, func(i int) bool { return t.hedge[i] })
+		if err != nil {
+			return nil, nil, hedgeError(res.err, err)
+		}
+		return r, headers, nil
+	case <-timer.C:
+	}
+
+	hedgeCtx, cancelHedge := context.WithCancel(ctx)
+	hedged := make(chan openResult, 1)
+	go func() {
+		r, headers, err := t.openTiers(hedgeCtx, key, func(i int) bool { return t.hedge[i] })
+		hedged <- openResult{r: r, headers: headers, err: err}
+	}()
+	var primaryErr, hedgeErr error
+	for primary != nil || hedged != nil {
+		select {
+		case res := <-primary:
+			if res.err == nil {
+				go discardOpen(hedged, cancelHedge)
+				return t.populateHedgeTiers(ctx, key, &cancelReader{ReadCloser: res.r, cancel: cancelPrimary}, res.headers), res.headers, nil
+			}
+			primary, primaryErr = nil, res.err
+		case res := <-hedged:
+			if res.err == nil {
+				go discardOpen(primary, cancelPrimary)
+				return &cancelReader{ReadCloser: res.r, cancel: cancelHedge}, res.headers, nil
+			}
+			hedged, hedgeErr = nil, res.err
+		}
+	}
+	cancelPrimary()
+	cancelHedge()
+	return nil, nil, hedgeError(primaryErr, hedgeErr)
+}
+
+// hedgeError combines the errors of both sides of a failed hedged read, returning only those that are not
+// os.ErrNotExist if there are any, so that a failure is not reported as a miss.
+func hedgeError(primaryErr, hedgeErr error) error {
+	switch {
+	case errors.Is(primaryErr, os.ErrNotExist) && errors.Is(hedgeErr, os.ErrNotExist):
+		return errors.Join(primaryErr, hedgeErr)
+	case errors.Is(primaryErr, os.ErrNotExist):
+		return hedgeErr
+	case errors.Is(hedgeErr, os.ErrNotExist):
+		return primaryErr
+	default:
+		return errors.Join(primaryErr, hedgeErr)
+	}
+}
+
+// discardOpen cancels the losing side of a hedged read and closes its reader, if it returns one. A nil result
+// channel is a side that has already failed.
+func discardOpen(result chan openResult, cancel context.CancelFunc) {
+	cancel()
+	if result == nil {
+		return
+	}
+	if res := <-result; res.err == nil {
+		_ = res.r.Close()
+	}
+}
+
+// populateHedgeTiers copies an object read from the other tiers to the hedge tiers that do not hold it as r is read.
+func (t Tiered) populateHedgeTiers(ctx context.Context, key Key, r io.ReadCloser, headers http.Header) io.ReadCloser {
+	var tiers []int
+	var expiresAt time.Time
+	found := false
+	for i, c := range t.caches {
+		if !t.hedge[i] {
+			// The object was read from the first of the other tiers that holds it.
+			if !found {
+				var err error
+				expiresAt, err = ExpiresAt(ctx, c, key)
+				found = err == nil
+			}
+			continue
+		}
+		if ok, err := c.Has(ctx, key); err != nil || ok {
+			continue
+		}
+		tiers = append(tiers, i)
+	}
+	if !found {
+		return r
+	}
+	return t.populateTiers(ctx, key, r, headers, expiresAt, tiers)
+}
+
+// populateTiers copies an object that expires at expiresAt to the tiers at the given indexes as r is read, so that it
+// expires in them no later than in the tier it was read from. An object without a known expiry is copied with the
+// maximum TTL of each tier. The copies are committed if r is read to the end, and discarded otherwise.
+func (t Tiered) populateTiers(ctx context.Context, key Key, r io.ReadCloser, headers http.Header, expiresAt time.Time, tiers []int) io.ReadCloser {
+	var ttl time.Duration
+	if !expiresAt.IsZero() {
+		if ttl = time.Until(expiresAt); ttl <= 0 {
+			return r
+		}
+	}
+	if len(tiers) == 0 {
+		return r
+	}
+	logger := logging.FromContext(ctx)
+	writeCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	var writers []io.WriteCloser
+	for _, i := range tiers {
+		w, err := t.create(writeCtx, i, key, headers, ttl)
+		if err != nil {
+			logger.WarnContext(ctx, "Failed to populate cache tier", "tier", t.caches[i].String(), "key", key.String(), "error", err.Error())
+			continue
+		}
+		writers = append(writers, w)
+	}
+	if len(writers) == 0 {
+		cancel()
+		return r
+	}
+	return &populatingReader{ReadCloser: r, writers: writers, cancel: cancel}
+}
+
+// cancelReader cancels the context it was opened in when it is closed.
+type cancelReader struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelReader) Close() error {
+	defer c.cancel()
+	return errors.WithStack(c.ReadCloser.Close())
+}
+
+// populatingReader copies everything read to writers, committing them once the end is reached. If a copy fails, all
+// are discarded without failing the read.
+type populatingReader struct {
+	io.ReadCloser
+	writers []io.WriteCloser
+	cancel  context.CancelFunc
+}
+
+func (p *populatingReader) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+	for _, w := range p.writers {
+		if _, werr := w.Write(b[:n]); werr != nil {
+			p.discard()
+			break
+		}
+	}
+	if errors.Is(err, io.EOF) {
+		for _, w := range p.writers {
+			_ = w.Close()
+		}
+		p.writers = nil
+		p.cancel()
+	}
+	return n, err //nolint:wrapcheck
+}
+
+// Close the reader, discarding the copies if the end was not reached.
+func (p *populatingReader) Close() error {
+	p.discard()
+	return errors.WithStack(p.ReadCloser.Close())
+}
+
+// discard cancels the copies before closing them, so that they are not committed.
+func (p *populatingReader) discard() {
+	p.cancel()
+	for _, w := range p.writers {
+		_ = w.Close()
+	}
+	p.writers = nil
+}
+
+// OpenStale returns a reader from the first cache that has the object, fresh or stale.
+//
+// If all caches fail, all errors are returned.
+func (t Tiered) OpenStale(ctx context.Context, key Key, grace time.Duration) (io.ReadCloser, http.Header, error) {
+	errs := make([]error, len(t.caches))
+	for i, c := range t.caches {
+		r, headers, err := OpenStale(ctx, c, key, grace)
 		errs[i] = err
 		if errors.Is(err, os.ErrNotExist) {
 			continue
@@ -149,22 +727,143 @@ func (t Tiered) Stats(ctx context.Context) (Stats, error) {
 	return combined, nil
 }
 
+// Purge removes objects from every tier that supports purging. TargetBytes applies to each tier individually.
+func (t Tiered) Purge(ctx context.Context, options PurgeOptions) (PurgeResult, error) {
+	var combined PurgeResult
+	purged := false
+	for _, c := range t.caches {
+		r, err := Purge(ctx, c, options)
+		if errors.Is(err, ErrPurgeUnavailable) {
+			continue
+		}
+		if err != nil {
+			return combined, errors.Wrap(err, c.String())
+		}
+		purged = true
+		combined.Objects += r.Objects
+		combined.Bytes += r.Bytes
+	}
+	if !purged {
+		return combined, errors.WithStack(ErrPurgeUnavailable)
+	}
+	return combined, nil
+}
+
+// ListTagged returns the objects created with tag in any tier that can list them, as sized by the first such tier
+// holding each object.
+func (t Tiered) ListTagged(ctx context.Context, tag string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	seen := map[Key]bool{}
+	listed := false
+	for _, c := range t.caches {
+		tierObjects, err := ListTagged(ctx, c, tag)
+		if errors.Is(err, ErrListUnavailable) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, c.String())
+		}
+		listed = true
+		for _, object := range tierObjects {
+			if !seen[object.Key] {
+				seen[object.Key] = true
+				objects = append(objects, object)
+			}
+		}
+	}
+	if !listed {
+		return nil, errors.WithStack(ErrListUnavailable)
+	}
+	return objects, nil
+}
+
+// ListKeys returns the objects whose keys start with prefix in any tier that can list them, as described by the first
+// such tier holding each object.
+//
+// Keys already listed are recorded to skip them in later tiers, so memory use grows with the number of objects.
+func (t Tiered) ListKeys(ctx context.Context, prefix string) iter.Seq2[ObjectInfo, error] {
+	return func(yield func(ObjectInfo, error) bool) {
+		seen := map[Key]bool{}
+		listed := false
+		for _, c := range t.caches {
+			unavailable := false
+			for object, err := range ListKeys(ctx, c, prefix) {
+				if errors.Is(err, ErrListUnavailable) {
+					unavailable = true
+					break
+				}
+				if err != nil {
+					yield(ObjectInfo{}, errors.Wrap(err, c.String()))
+					return
+				}
+				if seen[object.Key] {
+					continue
+				}
+				seen[object.Key] = true
+				if !yield(object, nil) {
+					return
+				}
+			}
+			listed = listed || !unavailable
+		}
+		if !listed {
+			yield(ObjectInfo{}, errors.WithStack(ErrListUnavailable))
+		}
+	}
+}
+
 type tieredWriter struct {
+	tiered  Tiered
+	ctx     context.Context //nolint:containedctx // Asynchronous tiers are populated in the writer's context on Close.
+	key     Key
+	ttl     time.Duration
 	writers []io.WriteCloser
 	cancel  context.CancelCauseFunc
+	// exclusive is set if the first writer creates the object exclusively, so must commit before the others.
+	exclusive bool
 }
 
 var _ io.WriteCloser = (*tieredWriter)(nil)
 
 // Close all writers and return all errors.
+//
+// If the object is created exclusively and already exists in the authoritative tier, the writes to the other tiers
+// are discarded. If every write succeeded, copies to the asynchronous tiers are started before returning.
 func (t tieredWriter) Close() error {
+	writers := t.writers
+	if t.exclusive {
+		if err := writers[0].Close(); err != nil {
+			// Cancelling the writes before closing them discards the object from the other tiers.
+			t.cancel(err)
+			for _, w := range writers[1:] {
+				_ = w.Close()
+			}
+			return errors.WithStack(err)
+		}
+		writers = writers[1:]
+	}
 	wg := sync.WaitGroup{}
-	errs := make([]error, len(t.writers))
-	for i, cache := range t.writers {
+	errs := make([]error, len(writers))
+	for i, cache := range writers {
 		wg.Go(func() { errs[i] = errors.WithStack(cache.Close()) })
 	}
 	wg.Wait()
-	return errors.Join(errs...)
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	ctx := context.WithoutCancel(t.ctx)
+	for i, async := range t.tiered.async {
+		if !async {
+			continue
+		}
+		// Block once the tier has too many copies in flight.
+		t.tiered.slots[i] <- struct{}{}
+		t.tiered.pending.Go(func() {
+			defer func() { <-t.tiered.slots[i] }()
+			t.tiered.populate(ctx, i, t.key, t.ttl)
+		})
+	}
+	return nil
 }
 
 func (t tieredWriter) Write(p []byte) (n int, err error) {
@@ -179,3 +878,50 @@ func (t tieredWriter) Write(p []byte) (n int, err error) {
 	}
 	return
 }
+
+// sizeLimitedWriter writes an object to a tier that only admits objects of at most limit bytes. Once the object
+// exceeds the limit, the write is discarded and the rest of the object is ignored, and on Close any earlier version of
+// the object is deleted from the tier.
+type sizeLimitedWriter struct {
+	ctx     context.Context //nolint:containedctx // Earlier versions are deleted in the writer's context on Close.
+	cache   Cache
+	key     Key
+	limit   int64
+	written int64
+	inner   io.WriteCloser
+	cancel  context.CancelFunc
+	skipped bool
+}
+
+func (s *sizeLimitedWriter) Write(p []byte) (int, error) {
+	if s.skipped {
+		return len(p), nil
+	}
+	s.written += int64(len(p))
+	if s.written > s.limit {
+		s.skip()
+		return len(p), nil
+	}
+	return errors.WithStack2(s.inner.Write(p))
+}
+
+// skip discards the write in progress.
+func (s *sizeLimitedWriter) skip() {
+	s.skipped = true
+	s.cancel()
+	_ = s.inner.Close()
+}
+
+func (s *sizeLimitedWriter) Close() error {
+	if !s.skipped {
+		defer s.cancel()
+		return errors.WithStack(s.inner.Close())
+	}
+	if s.ctx.Err() != nil {
+		return nil
+	}
+	if err := s.cache.Delete(s.ctx, s.key); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.Wrap(err, "delete earlier version of large object")
+	}
+	return nil
+}
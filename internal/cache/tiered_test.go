@@ -1,8 +1,14 @@
 package cache_test
 
 import (
+	"context"
+	"io"
 	"log/slog"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -20,7 +26,9 @@ func TestTieredCache(t *testing.T) {
 		assert.NoError(t, err)
 		disk, err := cache.NewDisk(ctx, cache.DiskConfig{Root: t.TempDir(), LimitMB: 1024, MaxTTL: time.Hour})
 		assert.NoError(t, err)
-		return cache.MaybeNewTiered(ctx, []cache.Cache{memory, disk})
+		c, err := cache.MaybeNewTiered(ctx, cache.TieredConfig{}, []cache.Cache{memory, disk})
+		assert.NoError(t, err)
+		return c
 	})
 }
 
@@ -42,7 +50,8 @@ func TestTieredCacheSoak(t *testing.T) {
 		EvictInterval: time.Second,
 	})
 	assert.NoError(t, err)
-	c := cache.MaybeNewTiered(ctx, []cache.Cache{memory, disk})
+	c, err := cache.MaybeNewTiered(ctx, cache.TieredConfig{}, []cache.Cache{memory, disk})
+	assert.NoError(t, err)
 	defer c.Close()
 
 	cachetest.Soak(t, c, cachetest.SoakConfig{
@@ -55,3 +64,382 @@ func TestTieredCacheSoak(t *testing.T) {
 		TTL:              5 * time.Minute,
 	})
 }
+
+// slowCache blocks writes until released.
+type slowCache struct {
+	*cache.Memory
+	release chan struct{}
+}
+
+func (s *slowCache) String() string { return "slow:" + s.Memory.String() }
+
+func (s *slowCache) Create(ctx context.Context, key cache.Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return s.Memory.Create(ctx, key, headers, ttl)
+}
+
+func TestTieredCacheAsyncTiers(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	fast, err := cache.NewMemory(ctx, cache.MemoryConfig{LimitMB: 1, MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	slowMemory, err := cache.NewMemory(ctx, cache.MemoryConfig{LimitMB: 1, MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	slow := &slowCache{Memory: slowMemory, release: make(chan struct{})}
+	c, err := cache.MaybeNewTiered(ctx, cache.TieredConfig{AsyncTiers: []string{"slow"}, AsyncQueueSize: 1},
+		[]cache.Cache{fast, slow})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	key := cache.NewKey("object")
+	w, err := c.Create(ctx, key, http.Header{"Content-Type": {"text/plain"}}, time.Hour)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	// The write returned once the fast tier committed, and is readable through it.
+	r, headers, err := c.Open(ctx, key)
+	assert.NoError(t, err)
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, "hello", string(data))
+	assert.Equal(t, "text/plain", headers.Get("Content-Type"))
+	ok, err := slow.Has(ctx, key)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	close(slow.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ok, err = slow.Has(ctx, key)
+		assert.NoError(t, err)
+		if ok || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	r, headers, err = slow.Open(ctx, key)
+	assert.NoError(t, err)
+	data, err = io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, "hello", string(data))
+	assert.Equal(t, "text/plain", headers.Get("Content-Type"))
+}
+
+func TestTieredCacheRequiresSynchronousTier(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	a, err := cache.NewMemory(ctx, cache.MemoryConfig{LimitMB: 1, MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	b, err := cache.NewMemory(ctx, cache.MemoryConfig{LimitMB: 1, MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	_, err = cache.MaybeNewTiered(ctx, cache.TieredConfig{AsyncTiers: []string{"memory"}, AsyncQueueSize: 1}, []cache.Cache{a, b})
+	assert.EqualError(t, err, "at least one cache tier must be written synchronously")
+}
+
+// delayedCache delays reads until a delay has passed or the read is cancelled.
+type delayedCache struct {
+	*cache.Memory
+	delay time.Duration
+}
+
+func (d *delayedCache) String() string { return "s3:" + d.Memory.String() }
+
+func (d *delayedCache) Open(ctx context.Context, key cache.Key) (io.ReadCloser, http.Header, error) {
+	select {
+	case <-time.After(d.delay):
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+	return d.Memory.Open(ctx, key)
+}
+
+func TestTieredCacheHedgedReads(t *testing.T) {
+	setup := func(t *testing.T, delay time.Duration) (cache.Cache, *delayedCache, *cache.Disk, context.Context) {
+		t.Helper()
+		_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+		memory, err := cache.NewMemory(ctx, cache.MemoryConfig{LimitMB: 1, MaxTTL: time.Hour})
+		assert.NoError(t, err)
+		slow := &delayedCache{Memory: memory, delay: delay}
+		disk, err := cache.NewDisk(ctx, cache.DiskConfig{Root: t.TempDir(), LimitMB: 1, MaxTTL: time.Hour})
+		assert.NoError(t, err)
+		c, err := cache.MaybeNewTiered(ctx, cache.TieredConfig{HedgeTiers: []string{"disk"}, HedgeAfter: 20 * time.Millisecond},
+			[]cache.Cache{slow, disk})
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = c.Close() })
+		return c, slow, disk, ctx
+	}
+	write := func(t *testing.T, ctx context.Context, c cache.Cache, key cache.Key) {
+		t.Helper()
+		w, err := c.Create(ctx, key, http.Header{"Content-Type": {"text/plain"}}, time.Hour)
+		assert.NoError(t, err)
+		_, err = w.Write([]byte("hello"))
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+	}
+	read := func(t *testing.T, ctx context.Context, c cache.Cache, key cache.Key) string {
+		t.Helper()
+		r, headers, err := c.Open(ctx, key)
+		assert.NoError(t, err)
+		data, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.NoError(t, r.Close())
+		assert.Equal(t, "text/plain", headers.Get("Content-Type"))
+		return string(data)
+	}
+
+	t.Run("SlowTierHedged", func(t *testing.T) {
+		c, _, _, ctx := setup(t, time.Minute)
+		key := cache.NewKey("object")
+		write(t, ctx, c, key)
+
+		start := time.Now()
+		assert.Equal(t, "hello", read(t, ctx, c, key))
+		assert.True(t, time.Since(start) < 5*time.Second, "read should be served by the hedge tier")
+	})
+
+	t.Run("PopulatesHedgeTier", func(t *testing.T) {
+		c, slow, disk, ctx := setup(t, 0)
+		key := cache.NewKey("object")
+		write(t, ctx, slow, key)
+
+		assert.Equal(t, "hello", read(t, ctx, c, key))
+		ok, err := disk.Has(ctx, key)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("PopulatedWithSourceExpiry", func(t *testing.T) {
+		c, slow, disk, ctx := setup(t, 0)
+		key := cache.NewKey("object")
+		w, err := slow.Create(ctx, key, http.Header{"Content-Type": {"text/plain"}}, 10*time.Minute)
+		assert.NoError(t, err)
+		_, err = w.Write([]byte("hello"))
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+
+		assert.Equal(t, "hello", read(t, ctx, c, key))
+		expiresAt, err := disk.ExpiresAt(ctx, key)
+		assert.NoError(t, err)
+		assert.True(t, time.Until(expiresAt) <= 10*time.Minute, "populated copy expires at %s", expiresAt)
+	})
+
+	t.Run("PartialReadNotPopulated", func(t *testing.T) {
+		c, slow, disk, ctx := setup(t, 0)
+		key := cache.NewKey("object")
+		write(t, ctx, slow, key)
+
+		r, _, err := c.Open(ctx, key)
+		assert.NoError(t, err)
+		assert.NoError(t, r.Close())
+		ok, err := disk.Has(ctx, key)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("FallsBackToHedgeTier", func(t *testing.T) {
+		c, _, disk, ctx := setup(t, 0)
+		key := cache.NewKey("object")
+		write(t, ctx, disk, key)
+
+		assert.Equal(t, "hello", read(t, ctx, c, key))
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		c, _, _, ctx := setup(t, 100*time.Millisecond)
+
+		_, _, err := c.Open(ctx, cache.NewKey("missing"))
+		assert.IsError(t, err, os.ErrNotExist)
+	})
+}
+
+func TestTieredCacheSmallTiers(t *testing.T) {
+	setup := func(t *testing.T) (cache.Cache, *cache.Memory, *cache.Disk, context.Context) {
+		t.Helper()
+		_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+		memory, err := cache.NewMemory(ctx, cache.MemoryConfig{LimitMB: 1, MaxTTL: time.Hour})
+		assert.NoError(t, err)
+		disk, err := cache.NewDisk(ctx, cache.DiskConfig{Root: t.TempDir(), MaxTTL: time.Hour})
+		assert.NoError(t, err)
+		c, err := cache.MaybeNewTiered(ctx, cache.TieredConfig{SmallTiers: []string{"memory"}, SmallObjectMax: 1024},
+			[]cache.Cache{memory, disk})
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = c.Close() })
+		return c, memory, disk, ctx
+	}
+	write := func(t *testing.T, c cache.Cache, key cache.Key, headers http.Header, data []byte) {
+		t.Helper()
+		w, err := c.Create(t.Context(), key, headers, time.Hour)
+		assert.NoError(t, err)
+		for len(data) > 0 {
+			n := min(len(data), 100)
+			_, err = w.Write(data[:n])
+			assert.NoError(t, err)
+			data = data[n:]
+		}
+		assert.NoError(t, w.Close())
+	}
+	read := func(t *testing.T, c cache.Cache, key cache.Key) string {
+		t.Helper()
+		r, _, err := c.Open(t.Context(), key)
+		assert.NoError(t, err)
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		return string(data)
+	}
+	has := func(t *testing.T, c cache.Cache, key cache.Key) bool {
+		t.Helper()
+		ok, err := c.Has(t.Context(), key)
+		assert.NoError(t, err)
+		return ok
+	}
+	small := strings.Repeat("s", 1024)
+	large := strings.Repeat("l", 1025)
+
+	t.Run("SmallObject", func(t *testing.T) {
+		c, memory, disk, _ := setup(t)
+		key := cache.NewKey("small")
+		write(t, c, key, http.Header{}, []byte(small))
+		assert.True(t, has(t, memory, key))
+		assert.True(t, has(t, disk, key))
+		assert.Equal(t, small, read(t, c, key))
+	})
+
+	t.Run("LargeObject", func(t *testing.T) {
+		c, memory, disk, _ := setup(t)
+		key := cache.NewKey("large")
+		write(t, c, key, http.Header{}, []byte(large))
+		assert.False(t, has(t, memory, key))
+		assert.True(t, has(t, disk, key))
+		assert.Equal(t, large, read(t, c, key))
+	})
+
+	t.Run("LargeContentLength", func(t *testing.T) {
+		c, memory, disk, _ := setup(t)
+		key := cache.NewKey("large")
+		write(t, c, key, http.Header{"Content-Length": {strconv.Itoa(len(large))}}, []byte(large))
+		assert.False(t, has(t, memory, key))
+		assert.True(t, has(t, disk, key))
+	})
+
+	t.Run("ReplacedByLargeObject", func(t *testing.T) {
+		c, memory, disk, _ := setup(t)
+		key := cache.NewKey("object")
+		write(t, c, key, http.Header{}, []byte(small))
+		assert.True(t, has(t, memory, key))
+		write(t, c, key, http.Header{}, []byte(large))
+		assert.False(t, has(t, memory, key))
+		assert.True(t, has(t, disk, key))
+		assert.Equal(t, large, read(t, c, key))
+	})
+
+	t.Run("RequiresUnlimitedTier", func(t *testing.T) {
+		_, memory, disk, ctx := setup(t)
+		_, err := cache.MaybeNewTiered(ctx, cache.TieredConfig{SmallTiers: []string{"memory", "disk"}, SmallObjectMax: 1024},
+			[]cache.Cache{memory, disk})
+		assert.EqualError(t, err, "at least one synchronously written cache tier must not be a small tier")
+	})
+}
+
+// countingCache counts reads.
+type countingCache struct {
+	*cache.Memory
+	opens atomic.Int32
+}
+
+func (c *countingCache) String() string { return "s3:" + c.Memory.String() }
+
+func (c *countingCache) Open(ctx context.Context, key cache.Key) (io.ReadCloser, http.Header, error) {
+	c.opens.Add(1)
+	return c.Memory.Open(ctx, key)
+}
+
+func TestTieredCachePromotion(t *testing.T) {
+	cachetest.Suite(t, func(t *testing.T) cache.Cache {
+		_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+		near, err := cache.NewMemory(ctx, cache.MemoryConfig{LimitMB: 1024, MaxTTL: time.Hour})
+		assert.NoError(t, err)
+		far, err := cache.NewDisk(ctx, cache.DiskConfig{Root: t.TempDir(), LimitMB: 1024, MaxTTL: time.Hour})
+		assert.NoError(t, err)
+		c, err := cache.MaybeNewTiered(ctx, cache.TieredConfig{Promote: true}, []cache.Cache{near, far})
+		assert.NoError(t, err)
+		return c
+	})
+
+	setup := func(t *testing.T) (cache.Cache, *cache.Memory, *countingCache, cache.Key, context.Context) {
+		t.Helper()
+		_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+		near, err := cache.NewMemory(ctx, cache.MemoryConfig{LimitMB: 1, MaxTTL: time.Hour})
+		assert.NoError(t, err)
+		farMemory, err := cache.NewMemory(ctx, cache.MemoryConfig{LimitMB: 1, MaxTTL: time.Hour})
+		assert.NoError(t, err)
+		far := &countingCache{Memory: farMemory}
+		c, err := cache.MaybeNewTiered(ctx, cache.TieredConfig{Promote: true}, []cache.Cache{near, far})
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = c.Close() })
+
+		// The object is only held by the far tier, eg. because it was written by another instance.
+		key := cache.NewKey("object")
+		w, err := far.Create(ctx, key, http.Header{"Content-Type": {"text/plain"}}, time.Hour)
+		assert.NoError(t, err)
+		_, err = w.Write([]byte("hello"))
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+		return c, near, far, key, ctx
+	}
+
+	t.Run("PromotedOnFarHit", func(t *testing.T) {
+		c, near, far, key, ctx := setup(t)
+		for range 2 {
+			r, headers, err := c.Open(ctx, key)
+			assert.NoError(t, err)
+			data, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.NoError(t, r.Close())
+			assert.Equal(t, "hello", string(data))
+			assert.Equal(t, "text/plain", headers.Get("Content-Type"))
+		}
+		assert.Equal(t, int32(1), far.opens.Load(), "second read should be served by the near tier")
+		ok, err := near.Has(ctx, key)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("PromotedWithSourceExpiry", func(t *testing.T) {
+		c, near, far, _, ctx := setup(t)
+		key := cache.NewKey("short-lived")
+		w, err := far.Create(ctx, key, http.Header{}, 10*time.Minute)
+		assert.NoError(t, err)
+		_, err = w.Write([]byte("hello"))
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+
+		r, _, err := c.Open(ctx, key)
+		assert.NoError(t, err)
+		_, err = io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.NoError(t, r.Close())
+		// The promoted copy must not outlive the original, which would extend the object's life.
+		expiresAt, err := near.ExpiresAt(ctx, key)
+		assert.NoError(t, err)
+		assert.True(t, time.Until(expiresAt) <= 10*time.Minute, "promoted copy expires at %s", expiresAt)
+	})
+
+	t.Run("NotPromotedOnPartialRead", func(t *testing.T) {
+		c, near, _, key, ctx := setup(t)
+		r, _, err := c.Open(ctx, key)
+		assert.NoError(t, err)
+		_, err = r.Read(make([]byte, 2))
+		assert.NoError(t, err)
+		assert.NoError(t, r.Close())
+		ok, err := near.Has(ctx, key)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
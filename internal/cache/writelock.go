@@ -0,0 +1,224 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"iter"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/errors"
+)
+
+// RegisterWriteLock cache decorator with the given registry.
+func RegisterWriteLock(r *Registry) {
+	RegisterDecorator(
+		r,
+		"write-lock",
+		"Serializes concurrent writes to the same object within this instance, so that only one is in flight at a time",
+		func(_ context.Context, config WriteLockConfig, inner Cache) (Cache, error) {
+			return NewWriteLockedCache(inner, config.Timeout), nil
+		},
+	)
+}
+
+type WriteLockConfig struct {
+	Timeout time.Duration `hcl:"timeout,optional" help:"Maximum time a writer waits for an earlier writer of the same object, after which it fails. 0 waits until the writer's context is cancelled."`
+}
+
+// Validate the configuration.
+func (c *WriteLockConfig) Validate() error {
+	if c.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	return nil
+}
+
+// WriteLocked is a [Cache] decorator that serializes writes to the same key.
+//
+// Create blocks while another writer of the same key is open, and the lock is held until the returned writer is
+// closed or its context is cancelled. This prevents writers that refresh the same object, such as a request and a
+// background refresh, from racing in the underlying cache. Reads and writes to other keys never block.
+//
+// Locks are only held within the process, so writers in other instances sharing a backend are not serialized.
+type WriteLocked struct {
+	inner   Cache
+	timeout time.Duration
+	mu      sync.Mutex
+	locks   map[Key]*keyLock
+}
+
+// keyLock is the write lock of a key, which is removed once no writers hold or wait for it.
+type keyLock struct {
+	held chan struct{}
+	refs int
+}
+
+var (
+	_ Cache            = (*WriteLocked)(nil)
+	_ StaleOpener      = (*WriteLocked)(nil)
+	_ Purger           = (*WriteLocked)(nil)
+	_ ExclusiveCreator = (*WriteLocked)(nil)
+	_ TagLister        = (*WriteLocked)(nil)
+	_ Lister           = (*WriteLocked)(nil)
+	_ Epocher          = (*WriteLocked)(nil)
+)
+
+// NewWriteLocked creates a new [WriteLocked] cache wrapping inner. If timeout is non-zero, writers waiting for longer
+// than it fail.
+func NewWriteLocked(inner Cache, timeout time.Duration) *WriteLocked {
+	return &WriteLocked{inner: inner, timeout: timeout, locks: map[Key]*keyLock{}}
+}
+
+// NewWriteLockedCache is like [NewWriteLocked], but the returned cache also implements [RangeOpener] if inner does.
+func NewWriteLockedCache(inner Cache, timeout time.Duration) Cache {
+	w := NewWriteLocked(inner, timeout)
+	if _, ok := inner.(RangeOpener); ok {
+		return writeLockedRangeOpener{w}
+	}
+	return w
+}
+
+func (w *WriteLocked) String() string { return "write-lock:" + w.inner.String() }
+
+func (w *WriteLocked) Stat(ctx context.Context, key Key) (http.Header, error) {
+	return errors.WithStack2(w.inner.Stat(ctx, key))
+}
+
+func (w *WriteLocked) Has(ctx context.Context, key Key) (bool, error) {
+	return errors.WithStack2(w.inner.Has(ctx, key))
+}
+
+func (w *WriteLocked) Open(ctx context.Context, key Key) (io.ReadCloser, http.Header, error) {
+	return errors.WithStack3(w.inner.Open(ctx, key))
+}
+
+// OpenStale opens an object from the underlying cache that may have expired up to "grace" ago.
+func (w *WriteLocked) OpenStale(ctx context.Context, key Key, grace time.Duration) (io.ReadCloser, http.Header, error) {
+	return errors.WithStack3(OpenStale(ctx, w.inner, key, grace))
+}
+
+// CurrentEpoch returns the epoch of the underlying cache.
+func (w *WriteLocked) CurrentEpoch(ctx context.Context) (uint64, error) {
+	return errors.WithStack2(CurrentEpoch(ctx, w.inner))
+}
+
+// AdvanceEpoch advances the epoch of the underlying cache.
+func (w *WriteLocked) AdvanceEpoch(ctx context.Context) (uint64, error) {
+	return errors.WithStack2(AdvanceEpoch(ctx, w.inner))
+}
+
+// CreateExclusive creates an object only if it does not already exist in the underlying cache.
+//
+// Exclusive writers are not serialized, as the underlying cache only commits the first of them to close and they
+// never replace an existing object.
+func (w *WriteLocked) CreateExclusive(ctx context.Context, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	return errors.WithStack2(CreateExclusive(ctx, w.inner, key, headers, ttl))
+}
+
+// Create a new object once no other writer of key is open. The lock is released when the returned writer is closed or
+// ctx is cancelled.
+func (w *WriteLocked) Create(ctx context.Context, key Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	unlock, err := w.lock(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := w.inner.Create(ctx, key, headers, ttl)
+	if err != nil {
+		unlock()
+		return nil, errors.WithStack(err)
+	}
+	unlock = sync.OnceFunc(unlock)
+	// The underlying writer discards the object if ctx is cancelled, so the lock is released without waiting for
+	// Close.
+	stop := context.AfterFunc(ctx, unlock)
+	return &writeLockedWriter{WriteCloser: writer, unlock: func() { stop(); unlock() }}, nil
+}
+
+// lock waits until no other writer holds the lock of key and takes it, returning the function that releases it.
+func (w *WriteLocked) lock(ctx context.Context, key Key) (func(), error) {
+	w.mu.Lock()
+	kl, ok := w.locks[key]
+	if !ok {
+		kl = &keyLock{held: make(chan struct{}, 1)}
+		w.locks[key] = kl
+	}
+	kl.refs++
+	w.mu.Unlock()
+
+	if w.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.timeout)
+		defer cancel()
+	}
+	select {
+	case kl.held <- struct{}{}:
+		return func() {
+			<-kl.held
+			w.release(key, kl)
+		}, nil
+	case <-ctx.Done():
+		w.release(key, kl)
+		return nil, errors.Errorf("waiting for another writer of %s: %w", key.String(), ctx.Err())
+	}
+}
+
+// release drops a reference to the lock of key, removing it once it is unused.
+func (w *WriteLocked) release(key Key, kl *keyLock) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	kl.refs--
+	if kl.refs == 0 {
+		delete(w.locks, key)
+	}
+}
+
+func (w *WriteLocked) Touch(ctx context.Context, key Key, ttl time.Duration) error {
+	return errors.WithStack(w.inner.Touch(ctx, key, ttl))
+}
+
+func (w *WriteLocked) Delete(ctx context.Context, key Key) error {
+	return errors.WithStack(w.inner.Delete(ctx, key))
+}
+
+// Purge removes objects from the underlying cache.
+func (w *WriteLocked) Purge(ctx context.Context, options PurgeOptions) (PurgeResult, error) {
+	return errors.WithStack2(Purge(ctx, w.inner, options))
+}
+
+// ListTagged returns the objects created with tag in the underlying cache.
+func (w *WriteLocked) ListTagged(ctx context.Context, tag string) ([]ObjectInfo, error) {
+	return errors.WithStack2(ListTagged(ctx, w.inner, tag))
+}
+
+// ListKeys returns the objects in the underlying cache whose keys start with prefix.
+func (w *WriteLocked) ListKeys(ctx context.Context, prefix string) iter.Seq2[ObjectInfo, error] {
+	return ListKeys(ctx, w.inner, prefix)
+}
+
+func (w *WriteLocked) Stats(ctx context.Context) (Stats, error) {
+	return errors.WithStack2(w.inner.Stats(ctx))
+}
+
+func (w *WriteLocked) Close() error { return errors.WithStack(w.inner.Close()) }
+
+// writeLockedRangeOpener is a [WriteLocked] cache over a [RangeOpener].
+type writeLockedRangeOpener struct{ *WriteLocked }
+
+var _ RangeOpener = writeLockedRangeOpener{}
+
+func (w writeLockedRangeOpener) OpenRange(ctx context.Context, key Key, rng Range) (io.ReadCloser, http.Header, error) {
+	return errors.WithStack3(w.inner.(RangeOpener).OpenRange(ctx, key, rng)) //nolint:forcetypeassert
+}
+
+// writeLockedWriter releases the write lock of its key once it is closed.
+type writeLockedWriter struct {
+	io.WriteCloser
+	unlock func()
+}
+
+func (w *writeLockedWriter) Close() error {
+	defer w.unlock()
+	return errors.WithStack(w.WriteCloser.Close())
+}
@@ -0,0 +1,124 @@
+package cache_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/cache/cachetest"
+	"github.com/block/cachew/internal/logging"
+)
+
+func TestWriteLockedCache(t *testing.T) {
+	cachetest.Suite(t, func(t *testing.T) cache.Cache {
+		_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+		inner, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: 100 * time.Millisecond})
+		assert.NoError(t, err)
+		return cache.NewWriteLockedCache(inner, 0)
+	})
+}
+
+func TestWriteLockSerializesWriters(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	root := t.TempDir()
+	disk, err := cache.NewDisk(ctx, cache.DiskConfig{Root: root, MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	c := cache.NewWriteLocked(disk, 0)
+	defer c.Close()
+
+	key := cache.NewKey("object")
+	const writers = 8
+	var active, maxActive atomic.Int32
+	payloads := make([][]byte, writers)
+	var wg sync.WaitGroup
+	for i := range writers {
+		payloads[i] = bytes.Repeat([]byte{byte('a' + i)}, 4096)
+		wg.Go(func() {
+			w, err := c.Create(ctx, key, http.Header{}, time.Hour)
+			assert.NoError(t, err)
+			n := active.Add(1)
+			for {
+				current := maxActive.Load()
+				if n <= current || maxActive.CompareAndSwap(current, n) {
+					break
+				}
+			}
+			for chunk := range slices.Chunk(payloads[i], 1024) {
+				_, err := w.Write(chunk)
+				assert.NoError(t, err)
+				time.Sleep(time.Millisecond)
+			}
+			active.Add(-1)
+			assert.NoError(t, w.Close())
+		})
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), maxActive.Load(), "writers of the same key should not overlap")
+	r, _, err := c.Open(ctx, key)
+	assert.NoError(t, err)
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	intact := false
+	for _, payload := range payloads {
+		intact = intact || bytes.Equal(payload, data)
+	}
+	assert.True(t, intact, "object should be the complete payload of one writer")
+
+	var tempFiles []string
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && strings.HasPrefix(d.Name(), ".tmp-") {
+			tempFiles = append(tempFiles, path)
+		}
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string(nil), tempFiles)
+}
+
+func TestWriteLockTimeout(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	inner, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	c := cache.NewWriteLocked(inner, 50*time.Millisecond)
+	defer c.Close()
+
+	key := cache.NewKey("object")
+	first, err := c.Create(ctx, key, http.Header{}, time.Hour)
+	assert.NoError(t, err)
+
+	_, err = c.Create(ctx, key, http.Header{}, time.Hour)
+	assert.IsError(t, err, context.DeadlineExceeded)
+
+	// Writers of other keys and readers are not blocked.
+	other, err := c.Create(ctx, cache.NewKey("other"), http.Header{}, time.Hour)
+	assert.NoError(t, err)
+	assert.NoError(t, other.Close())
+	_, err = c.Has(ctx, key)
+	assert.NoError(t, err)
+
+	// Cancelling the first writer releases the lock without closing it.
+	cancelled, cancel := context.WithCancel(ctx)
+	assert.NoError(t, first.Close())
+	first, err = c.Create(cancelled, key, http.Header{}, time.Hour)
+	assert.NoError(t, err)
+	cancel()
+	second, err := c.Create(ctx, key, http.Header{}, time.Hour)
+	assert.NoError(t, err)
+	assert.NoError(t, second.Close())
+	_ = first.Close()
+}
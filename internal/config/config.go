@@ -3,9 +3,12 @@ package config
 
 import (
 	"context"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/alecthomas/errors"
 	"github.com/alecthomas/hcl/v2"
@@ -17,19 +20,27 @@ import (
 	_ "github.com/block/cachew/internal/strategy/gomod" // Register gomod strategy
 )
 
+// loggingMux logs and records the routes registered by a strategy, so that they can be bound to another mux when the
+// configuration is reloaded.
 type loggingMux struct {
 	logger *slog.Logger
 	mux    *http.ServeMux
+	routes []route
+}
+
+type route struct {
+	pattern string
+	handler http.Handler
 }
 
 func (l *loggingMux) Handle(pattern string, handler http.Handler) {
 	l.logger.Debug("Registered strategy handler", "pattern", pattern)
 	l.mux.Handle(pattern, handler)
+	l.routes = append(l.routes, route{pattern, handler})
 }
 
 func (l *loggingMux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
-	l.logger.Debug("Registered strategy handler", "pattern", pattern)
-	l.mux.HandleFunc(pattern, handler)
+	l.Handle(pattern, http.HandlerFunc(handler))
 }
 
 var _ strategy.Mux = (*loggingMux)(nil)
@@ -83,18 +94,43 @@ func Split[GlobalConfig any](ast *hcl.AST) (global, providers *hcl.AST) {
 	return global, providers
 }
 
+// Loaded is a configuration whose cache backend has been constructed and whose strategies are bound to a mux.
+type Loaded struct {
+	cr         *cache.Registry
+	sr         *strategy.Registry
+	vars       map[string]string
+	cache      cache.Cache
+	caches     string // Source of the cache and decorator blocks.
+	strategies []*loadedStrategy
+	// removed holds the strategies replaced or removed by [Loaded.Reload], to be closed once requests are no longer
+	// routed to them.
+	removed []*loadedStrategy
+}
+
+type loadedStrategy struct {
+	name     string
+	source   string
+	routes   []route
+	strategy strategy.Strategy
+}
+
 // Load HCL configuration and use that to construct the cache backend, and proxy strategies.
 func Load(
 	ctx context.Context,
 	cr *cache.Registry,
 	sr *strategy.Registry,
 	ast *hcl.AST,
+	tiered cache.TieredConfig,
 	mux *http.ServeMux,
 	vars map[string]string,
-) error {
+) (*Loaded, error) {
 	logger := logging.FromContext(ctx)
 	expandVars(ast, vars)
 
+	if err := validate(cr, sr, ast, vars); err != nil {
+		return nil, err
+	}
+
 	strategyCandidates := []*hcl.Block{
 		// Always enable the default API strategy
 		{Name: "apiv1"},
@@ -102,42 +138,244 @@ func Load(
 
 	// First pass, instantiate caches
 	var caches []cache.Cache
+	var cacheBlocks, decorators []*hcl.Block
 	for _, node := range ast.Entries {
 		switch node := node.(type) {
 		case *hcl.Block:
+			if cr.IsDecorator(node.Name) {
+				decorators = append(decorators, node)
+				cacheBlocks = append(cacheBlocks, node)
+				continue
+			}
 			c, err := cr.Create(ctx, node.Name, node)
 			if errors.Is(err, cache.ErrNotFound) {
 				strategyCandidates = append(strategyCandidates, node)
 				continue
 			} else if err != nil {
-				return errors.Errorf("%s: %w", node.Pos, err)
+				return nil, errors.Errorf("%s: %w", node.Pos, err)
 			}
 			caches = append(caches, c)
+			cacheBlocks = append(cacheBlocks, node)
 
 		case *hcl.Attribute:
-			return errors.Errorf("%s: attributes are not allowed", node.Pos)
+			return nil, errors.Errorf("%s: attributes are not allowed", node.Pos)
 		}
 	}
 	if len(caches) == 0 {
-		return errors.Errorf("%s: expected at least one cache backend", ast.Pos)
+		return nil, errors.Errorf("%s: expected at least one cache backend", ast.Pos)
 	}
 
-	cache := cache.MaybeNewTiered(ctx, caches)
+	if err := tiered.Validate(); err != nil {
+		return nil, errors.Errorf("tiered: %w", err)
+	}
+	cache, err := cache.MaybeNewTiered(ctx, tiered, caches)
+	if err != nil {
+		return nil, errors.Errorf("tiered: %w", err)
+	}
+	for _, block := range decorators {
+		decorated, err := cr.Decorate(ctx, block.Name, block, cache)
+		if err != nil {
+			return nil, errors.Errorf("%s: %w", block.Pos, err)
+		}
+		cache = decorated
+	}
 
 	logger.DebugContext(ctx, "Cache backend", "cache", cache)
 
+	loaded := &Loaded{cr: cr, sr: sr, vars: vars, cache: cache, caches: source(cacheBlocks...)}
+
 	// Second pass, instantiate strategies and bind them to the mux.
 	for _, block := range strategyCandidates {
-		logger := logger.With("strategy", block.Name)
-		mlog := &loggingMux{logger: logger, mux: mux}
-		_, err := sr.Create(ctx, block.Name, block, cache, mlog, vars)
+		s, err := loaded.createStrategy(ctx, block, mux)
+		if err != nil {
+			return nil, err
+		}
+		loaded.strategies = append(loaded.strategies, s)
+	}
+	return loaded, nil
+}
+
+// Reload binds the strategies of a new configuration to mux, returning the reloaded configuration.
+//
+// The existing cache backend is reused, as constructing it again would discard its state, so changes to cache blocks
+// are logged as requiring a restart. Strategies whose configuration is unchanged keep their existing handlers, and
+// changed, added or removed strategies take effect immediately, except for those registered with
+// [strategy.RequiresRestart], which keep their existing configuration until restarted. Strategies that are replaced or
+// removed must be closed with [Loaded.closeRemoved] once mux is serving requests.
+func (l *Loaded) Reload(ctx context.Context, ast *hcl.AST, mux *http.ServeMux) (_ *Loaded, err error) {
+	logger := logging.FromContext(ctx)
+	expandVars(ast, l.vars)
+
+	if err := validate(l.cr, l.sr, ast, l.vars); err != nil {
+		return nil, err
+	}
+
+	blocks := []*hcl.Block{{Name: "apiv1"}}
+	var cacheBlocks []*hcl.Block
+	for _, node := range ast.Entries {
+		switch node := node.(type) {
+		case *hcl.Block:
+			if l.cr.Exists(node.Name) || l.cr.IsDecorator(node.Name) {
+				cacheBlocks = append(cacheBlocks, node)
+			} else {
+				blocks = append(blocks, node)
+			}
+
+		case *hcl.Attribute:
+			return nil, errors.Errorf("%s: attributes are not allowed", node.Pos)
+		}
+	}
+	if source(cacheBlocks...) != l.caches {
+		logger.WarnContext(ctx, "Cache configuration changed, restart to apply")
+	}
+
+	reloaded := &Loaded{cr: l.cr, sr: l.sr, vars: l.vars, cache: l.cache, caches: l.caches}
+	var created []*loadedStrategy
+	defer func() {
 		if err != nil {
-			return errors.Errorf("%s: %w", block.Pos, err)
+			closeStrategies(ctx, created)
+		}
+	}()
+	previous := slices.Clone(l.strategies)
+	// take removes and returns the previous instance of a strategy with the given source, if any.
+	take := func(source string) *loadedStrategy {
+		i := slices.IndexFunc(previous, func(s *loadedStrategy) bool { return s.source == source })
+		if i < 0 {
+			return nil
+		}
+		s := previous[i]
+		previous = slices.Delete(previous, i, i+1)
+		return s
+	}
+	for _, block := range blocks {
+		s := take(source(block))
+		switch {
+		case s != nil:
+		case l.sr.Reloadable(block.Name):
+			logger.InfoContext(ctx, "Reloading strategy", "strategy", block.Name, "pos", block.Pos)
+			if s, err = reloaded.createStrategy(ctx, block, mux); err != nil {
+				return nil, err
+			}
+			created = append(created, s)
+			reloaded.strategies = append(reloaded.strategies, s)
+			continue
+		default:
+			logger.WarnContext(ctx, "Strategy configuration changed, restart to apply", "strategy", block.Name, "pos", block.Pos)
+			continue
+		}
+		if err := bind(mux, s.routes); err != nil {
+			return nil, errors.Errorf("%s: %w", block.Pos, err)
+		}
+		reloaded.strategies = append(reloaded.strategies, s)
+	}
+	// Strategies that were removed or changed, but cannot be reloaded, keep running until a restart.
+	for _, s := range previous {
+		if l.sr.Reloadable(s.name) {
+			logger.InfoContext(ctx, "Removing strategy", "strategy", s.name)
+			reloaded.removed = append(reloaded.removed, s)
+			continue
+		}
+		if err := bind(mux, s.routes); err != nil {
+			return nil, errors.Errorf("%s: %w", s.name, err)
+		}
+		reloaded.strategies = append(reloaded.strategies, s)
+	}
+	return reloaded, nil
+}
+
+func (l *Loaded) createStrategy(ctx context.Context, block *hcl.Block, mux *http.ServeMux) (s *loadedStrategy, err error) {
+	logger := logging.FromContext(ctx).With("strategy", block.Name)
+	mlog := &loggingMux{logger: logger, mux: mux}
+	defer func() {
+		// ServeMux panics if a pattern conflicts with one already registered.
+		if r := recover(); r != nil {
+			err = errors.Errorf("%s: %v", block.Pos, r)
+		}
+	}()
+	created, err := l.sr.Create(ctx, block.Name, block, l.cache, mlog, l.vars)
+	if err != nil {
+		return nil, errors.Errorf("%s: %w", block.Pos, err)
+	}
+	return &loadedStrategy{name: block.Name, source: source(block), routes: mlog.routes, strategy: created}, nil
+}
+
+// closeRemoved closes the strategies replaced or removed when l was reloaded.
+func (l *Loaded) closeRemoved(ctx context.Context) {
+	closeStrategies(ctx, l.removed)
+	l.removed = nil
+}
+
+// closeStrategies closes those strategies that hold resources, such as the shadow cache of a strategy in observe mode,
+// logging any errors.
+func closeStrategies(ctx context.Context, strategies []*loadedStrategy) {
+	for _, s := range strategies {
+		closer, ok := s.strategy.(io.Closer)
+		if !ok {
+			continue
+		}
+		if err := closer.Close(); err != nil {
+			logging.FromContext(ctx).WarnContext(ctx, "Failed to close strategy", "strategy", s.name, "error", err.Error())
+		}
+	}
+}
+
+// bind registers routes recorded from a strategy with mux.
+func bind(mux *http.ServeMux, routes []route) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("%v", r)
 		}
+	}()
+	for _, route := range routes {
+		mux.Handle(route.pattern, route.handler)
 	}
 	return nil
 }
 
+// source returns the canonical HCL of blocks, for detecting changes between configurations.
+func source(blocks ...*hcl.Block) string {
+	ast := &hcl.AST{}
+	for _, block := range blocks {
+		ast.Entries = append(ast.Entries, block)
+	}
+	data, err := hcl.MarshalAST(ast)
+	if err != nil {
+		// Unreachable for a parsed AST, but treat it as a change rather than masking one.
+		return err.Error()
+	}
+	return string(data)
+}
+
+// validate every cache and strategy block before anything is constructed, reporting all problems at once rather than
+// just the first.
+func validate(cr *cache.Registry, sr *strategy.Registry, ast *hcl.AST, vars map[string]string) error {
+	var errs []error
+	for _, node := range ast.Entries {
+		block, ok := node.(*hcl.Block)
+		if !ok {
+			continue
+		}
+		var err error
+		switch {
+		case cr.Exists(block.Name) || cr.IsDecorator(block.Name):
+			err = cr.Validate(block.Name, block)
+		case sr.Exists(block.Name):
+			err = sr.Validate(block.Name, block, vars)
+		default:
+			errs = append(errs, errors.Errorf("%s: unknown block %q", block.Pos, block.Name))
+			continue
+		}
+		if err == nil {
+			continue
+		}
+		// Attribute each aggregated error to the block it came from.
+		for line := range strings.Lines(err.Error()) {
+			errs = append(errs, errors.Errorf("%s: %s: %s", block.Pos, block.Name, strings.TrimSpace(line)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
 func expandVars(ast *hcl.AST, vars map[string]string) {
 	_ = hcl.Visit(ast, func(node hcl.Node, next func() error) error { //nolint:errcheck
 		attr, ok := node.(*hcl.Attribute)
@@ -0,0 +1,198 @@
+package config //nolint:testpackage
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/alecthomas/hcl/v2"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy"
+	"github.com/block/cachew/internal/strategy/git"
+	"github.com/block/cachew/internal/strategy/gomod"
+)
+
+func TestValidate(t *testing.T) {
+	cr := cache.NewRegistry()
+	cache.RegisterMemory(cr)
+	cache.RegisterDisk(cr)
+	cache.RegisterS3(cr)
+	cache.RegisterEncrypted(cr)
+	cache.RegisterSalted(cr)
+	cache.RegisterWriteLock(cr)
+	sr := strategy.NewRegistry()
+	strategy.RegisterArtifactory(sr)
+	strategy.RegisterHost(sr)
+	git.Register(sr, nil, nil, nil)
+	gomod.Register(sr, nil)
+
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name: "Valid",
+			input: `
+				disk { root = "./cache" }
+				encrypted { keys = ["000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"] }
+				salted { key-salt = "tenant-a" }
+				artifactory "https://example.jfrog.io" {}
+				host "https://w3.org" {}
+				host "https://example.com" {
+					proxy {
+						url = "http://proxy.example.com:3128"
+						no-proxy = [".internal.example.com"]
+					}
+				}
+				gomod {
+					max-request-duration = "30s"
+					max-response-bytes = 104857600
+				}
+				git {
+					repo "https://github.com/myorg/*" { fetch-interval = "1m" }
+				}
+			`,
+		},
+		{
+			name:     "NegativeBudget",
+			input:    `host "https://w3.org" { max-response-bytes = -1 }`,
+			expected: []string{"host: max-response-bytes must not be negative"},
+		},
+		{
+			name:     "ProxyNotURL",
+			input:    `host "https://w3.org" { proxy { url = "proxy.example.com:3128" } }`,
+			expected: []string{`host: proxy url must be an absolute URL, got "proxy.example.com:3128"`},
+		},
+		{
+			name:     "ArtifactoryTargetNotURL",
+			input:    `artifactory "example.jfrog.io" {}`,
+			expected: []string{`artifactory: target must be an absolute http(s) URL, got "example.jfrog.io"`},
+		},
+		{
+			name:     "S3WithoutBucket",
+			input:    `s3 { bucket = "" }`,
+			expected: []string{"s3: bucket must be set"},
+		},
+		{
+			name:     "DiskWithoutRoot",
+			input:    `disk { root = "" }`,
+			expected: []string{"disk: root is required"},
+		},
+		{
+			name:     "UnknownBlock",
+			input:    `nonsense {}`,
+			expected: []string{`unknown block "nonsense"`},
+		},
+		{
+			name: "Aggregated",
+			input: `
+				s3 {
+					bucket = ""
+					endpoint = "https://s3.amazonaws.com"
+				}
+				encrypted { keys = ["abcd"] }
+				gomod { allowed-extensions = ["zip"] }
+				git {
+					repo "[" {}
+				}
+			`,
+			expected: []string{
+				"s3: bucket must be set",
+				`s3: endpoint must be a host[:port] without a scheme, got "https://s3.amazonaws.com"`,
+				"encrypted: key 0 must be 64 hex characters (256 bits)",
+				`gomod: allowed-extensions: "zip" must start with "."`,
+				`git: repo "[": syntax error in pattern`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ast, err := hcl.Parse(strings.NewReader(tt.input))
+			assert.NoError(t, err)
+			err = validate(cr, sr, ast, nil)
+			if len(tt.expected) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			lines := strings.Split(err.Error(), "\n")
+			assert.Equal(t, len(tt.expected), len(lines), "%s", err)
+			for i, expected := range tt.expected {
+				assert.Contains(t, lines[i], expected)
+			}
+		})
+	}
+}
+
+func TestReloadOnSIGHUP(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	var fetches atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fetches.Add(1)
+		_, _ = w.Write([]byte("response"))
+	}))
+	defer backend.Close()
+	u, err := url.Parse(backend.URL)
+	assert.NoError(t, err)
+
+	cr := cache.NewRegistry()
+	cache.RegisterMemory(cr)
+	sr := strategy.NewRegistry()
+	strategy.RegisterAPIV1(sr, nil, "", strategy.UploadConfig{})
+	strategy.RegisterHost(sr)
+	configFile := filepath.Join(t.TempDir(), "cachew.hcl")
+	writeConfig := func(ttl string) {
+		t.Helper()
+		config := "memory { max-ttl = \"1h\" }\nhost \"" + backend.URL + "\" { ttl = \"" + ttl + "\" }\n"
+		assert.NoError(t, os.WriteFile(configFile, []byte(config), 0o600))
+	}
+	writeConfig("1h")
+
+	data, err := os.ReadFile(configFile)
+	assert.NoError(t, err)
+	ast, err := hcl.ParseBytes(data)
+	assert.NoError(t, err)
+	mux := http.NewServeMux()
+	loaded, err := Load(ctx, cr, sr, ast, cache.TieredConfig{}, mux, nil)
+	assert.NoError(t, err)
+	handler := NewHandler(mux)
+	NewReloader(loaded, configFile, handler, http.NewServeMux, func(ast *hcl.AST) *hcl.AST { return ast }).ReloadOnSIGHUP(ctx)
+
+	get := func(path string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/"+u.Host+path, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	get("/before")
+	get("/before")
+	assert.Equal(t, int32(1), fetches.Load())
+
+	writeConfig("50ms")
+	assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	for deadline := time.Now().Add(5 * time.Second); handler.mux.Load() == mux; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("configuration was not reloaded")
+		}
+	}
+
+	// Objects cached after the reload use the new TTL, while those cached before it are unaffected.
+	get("/after")
+	time.Sleep(100 * time.Millisecond)
+	get("/after")
+	assert.Equal(t, int32(3), fetches.Load())
+	get("/before")
+	assert.Equal(t, int32(3), fetches.Load())
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/alecthomas/errors"
+	"github.com/alecthomas/hcl/v2"
+
+	"github.com/block/cachew/internal/logging"
+)
+
+// Handler serves requests with the mux most recently stored in it, so that the mux can be replaced when the
+// configuration is reloaded without interrupting requests in flight.
+type Handler struct {
+	mux atomic.Pointer[http.ServeMux]
+}
+
+var _ http.Handler = (*Handler)(nil)
+
+// NewHandler creates a [Handler] serving mux.
+func NewHandler(mux *http.ServeMux) *Handler {
+	h := &Handler{}
+	h.Store(mux)
+	return h
+}
+
+// Store replaces the mux serving new requests.
+func (h *Handler) Store(mux *http.ServeMux) { h.mux.Store(mux) }
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) { h.mux.Load().ServeHTTP(w, r) }
+
+// Reloader reloads a configuration file, replacing the mux served by a [Handler].
+type Reloader struct {
+	path      string
+	handler   *Handler
+	newMux    func() *http.ServeMux
+	providers func(*hcl.AST) *hcl.AST
+
+	mu     sync.Mutex
+	loaded *Loaded
+}
+
+// NewReloader creates a [Reloader] for the configuration file at path, which has already been loaded.
+//
+// newMux creates a mux with the routes that are not provided by strategies, and providers extracts the cache and
+// strategy configuration from the file, eg. with [Split].
+func NewReloader(loaded *Loaded, path string, handler *Handler, newMux func() *http.ServeMux, providers func(*hcl.AST) *hcl.AST) *Reloader {
+	return &Reloader{path: path, handler: handler, newMux: newMux, providers: providers, loaded: loaded}
+}
+
+// Reload the configuration file. If it cannot be read or loaded, the current configuration remains in place.
+func (r *Reloader) Reload(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, err := os.Open(r.path)
+	if err != nil {
+		return errors.Wrap(err, "open config")
+	}
+	defer f.Close()
+	ast, err := hcl.Parse(f)
+	if err != nil {
+		return errors.Wrap(err, "parse config")
+	}
+	mux := r.newMux()
+	loaded, err := r.loaded.Reload(ctx, r.providers(ast), mux)
+	if err != nil {
+		return err
+	}
+	r.loaded = loaded
+	r.handler.Store(mux)
+	loaded.closeRemoved(ctx)
+	return nil
+}
+
+// ReloadOnSIGHUP reloads the configuration each time the process receives SIGHUP, until ctx is cancelled.
+func (r *Reloader) ReloadOnSIGHUP(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(signals)
+		logger := logging.FromContext(ctx)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				logger.InfoContext(ctx, "Reloading configuration", "path", r.path)
+				if err := r.Reload(ctx); err != nil {
+					logger.ErrorContext(ctx, "Failed to reload configuration", "path", r.path, "error", err.Error())
+					continue
+				}
+				logger.InfoContext(ctx, "Reloaded configuration", "path", r.path)
+			}
+		}
+	}()
+}
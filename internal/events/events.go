@@ -0,0 +1,123 @@
+// Package events provides an in-process bus of structured events, such as cache hits and evictions, that can be
+// streamed to clients for live debugging.
+package events
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecthomas/errors"
+)
+
+// Type identifies the kind of an [Event].
+type Type string
+
+const (
+	Cache       Type = "cache"        // A request was served, with its X-Cache status in the "status" attribute.
+	Evict       Type = "evict"        // Entries were evicted from a cache.
+	CloneStart  Type = "clone-start"  // A git clone started.
+	CloneFinish Type = "clone-finish" // A git clone finished, with any error in the "error" attribute.
+	Error       Type = "error"        // An error response was returned to a client.
+)
+
+// ErrTooManySubscribers is returned by [Bus.Subscribe] when the bus is at its subscriber limit.
+var ErrTooManySubscribers = errors.New("too many subscribers")
+
+// Event is a structured event published to a [Bus].
+type Event struct {
+	Time  time.Time         `json:"time"`
+	Type  Type              `json:"type"`
+	Attrs map[string]string `json:"attrs,omitempty"`
+}
+
+// Bus fans out published events to its subscribers.
+//
+// Publishing never blocks: each subscriber has a bounded buffer, and events are dropped for subscribers that fall
+// behind rather than slowing the publisher.
+type Bus struct {
+	maxSubscribers int
+	bufferSize     int
+
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+}
+
+// NewBus creates a [Bus] allowing up to maxSubscribers concurrent subscribers, each buffering up to bufferSize events.
+func NewBus(maxSubscribers, bufferSize int) *Bus {
+	return &Bus{
+		maxSubscribers: maxSubscribers,
+		bufferSize:     bufferSize,
+		subscribers:    map[*Subscription]struct{}{},
+	}
+}
+
+// Publish an event to all current subscribers.
+func (b *Bus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribe to events published from now on. The subscription must be closed when no longer needed.
+func (b *Bus) Subscribe() (*Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.subscribers) >= b.maxSubscribers {
+		return nil, errors.WithStack(ErrTooManySubscribers)
+	}
+	sub := &Subscription{bus: b, events: make(chan Event, b.bufferSize)}
+	b.subscribers[sub] = struct{}{}
+	return sub, nil
+}
+
+// Subscription receives the events published to a [Bus].
+type Subscription struct {
+	bus     *Bus
+	events  chan Event
+	dropped atomic.Int64
+}
+
+// Events returns the channel on which events are received.
+func (s *Subscription) Events() <-chan Event { return s.events }
+
+// Dropped returns and resets the number of events dropped because the subscriber fell behind.
+func (s *Subscription) Dropped() int64 { return s.dropped.Swap(0) }
+
+// Close the subscription.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	delete(s.bus.subscribers, s)
+}
+
+type busKey struct{}
+
+// ContextWithBus returns a new context with the given bus, to which [Publish] sends events.
+func ContextWithBus(ctx context.Context, bus *Bus) context.Context {
+	return context.WithValue(ctx, busKey{}, bus)
+}
+
+// Publish an event of type typ to the bus in ctx, if any. Attribute values are formatted as strings.
+func Publish(ctx context.Context, typ Type, attrs ...slog.Attr) {
+	bus, ok := ctx.Value(busKey{}).(*Bus)
+	if !ok {
+		return
+	}
+	event := Event{Time: time.Now(), Type: typ}
+	if len(attrs) > 0 {
+		event.Attrs = make(map[string]string, len(attrs))
+		for _, attr := range attrs {
+			event.Attrs[attr.Key] = attr.Value.String()
+		}
+	}
+	bus.Publish(event)
+}
@@ -0,0 +1,98 @@
+package events_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/events"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy/handler"
+)
+
+func TestEventStream(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	bus := events.NewBus(1, 16)
+	ctx = events.ContextWithBus(ctx, bus)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(w, "response")
+	}))
+	defer upstream.Close()
+
+	memory, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	mux := http.NewServeMux()
+	mux.Handle("GET /_events", events.Handler(bus))
+	mux.Handle("GET /", handler.New(http.DefaultClient, memory).
+		Transform(func(r *http.Request) (*http.Request, error) {
+			return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL+r.URL.Path, nil)
+		}))
+	server := httptest.NewUnstartedServer(mux)
+	server.Config.BaseContext = func(net.Listener) context.Context { return ctx }
+	server.Start()
+	defer server.Close()
+
+	stream, err := http.Get(server.URL + "/_events") //nolint:noctx
+	assert.NoError(t, err)
+	defer stream.Body.Close()
+	assert.Equal(t, http.StatusOK, stream.StatusCode)
+	assert.Equal(t, "text/event-stream", stream.Header.Get("Content-Type"))
+
+	// The bus is at its subscriber limit.
+	rejected, err := http.Get(server.URL + "/_events") //nolint:noctx
+	assert.NoError(t, err)
+	_ = rejected.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, rejected.StatusCode)
+
+	for range 2 {
+		resp, err := http.Get(server.URL + "/object") //nolint:noctx
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+
+	scanner := bufio.NewScanner(stream.Body)
+	var statuses []string
+	for len(statuses) < 2 && scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event events.Event
+		assert.NoError(t, json.Unmarshal([]byte(data), &event))
+		assert.Equal(t, events.Cache, event.Type)
+		assert.Equal(t, "/object", event.Attrs["url"])
+		statuses = append(statuses, event.Attrs["status"])
+	}
+	assert.NoError(t, scanner.Err())
+	assert.Equal(t, []string{handler.CacheMiss, handler.CacheHit}, statuses)
+}
+
+func TestBusDropsEventsForSlowSubscribers(t *testing.T) {
+	bus := events.NewBus(1, 2)
+	sub, err := bus.Subscribe()
+	assert.NoError(t, err)
+
+	for range 5 {
+		bus.Publish(events.Event{Type: events.Cache})
+	}
+	assert.Equal(t, 2, len(sub.Events()))
+	assert.Equal(t, int64(3), sub.Dropped())
+	assert.Equal(t, int64(0), sub.Dropped())
+
+	sub.Close()
+	sub, err = bus.Subscribe()
+	assert.NoError(t, err, "closed subscriptions should not count towards the limit")
+	sub.Close()
+}
@@ -0,0 +1,66 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/logging"
+)
+
+// Handler streams the events published to bus as Server-Sent Events, until the client disconnects.
+//
+// Each event is sent with its [Type] as the SSE event name and the JSON encoded [Event] as its data. If the client
+// falls behind, a comment reporting the number of dropped events is sent in their place.
+func Handler(bus *Bus) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sub, err := bus.Subscribe()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer sub.Close()
+
+		rc := http.NewResponseController(w)
+		// Streams outlive the server's write timeout.
+		_ = rc.SetWriteDeadline(time.Time{}) //nolint:errcheck
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			logging.FromContext(r.Context()).ErrorContext(r.Context(), "Event stream does not support flushing", "error", err)
+			return
+		}
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case event := <-sub.Events():
+				if err := writeEvent(w, sub, event); err != nil {
+					return
+				}
+				if err := rc.Flush(); err != nil {
+					return
+				}
+			}
+		}
+	})
+}
+
+func writeEvent(w http.ResponseWriter, sub *Subscription, event Event) error {
+	if dropped := sub.Dropped(); dropped > 0 {
+		if _, err := fmt.Fprintf(w, ": dropped %d events\n\n", dropped); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode event")
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return errors.WithStack(err)
+}
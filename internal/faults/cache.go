@@ -0,0 +1,166 @@
+package faults
+
+import (
+	"context"
+	"io"
+	"iter"
+	"net/http"
+	"time"
+
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/cache"
+)
+
+// Register the "fault-injection" cache decorator, which injects the [Cache] fault of injector into cache
+// operations, starting with the fault configured in its block.
+func Register(r *cache.Registry, injector *Injector) {
+	cache.RegisterDecorator(
+		r,
+		"fault-injection",
+		"Injects failures and latency into cache operations for resilience testing. Never use in production.",
+		func(_ context.Context, config Fault, inner cache.Cache) (cache.Cache, error) {
+			if err := injector.Set(Cache, config); err != nil {
+				return nil, err
+			}
+			return NewCache(inner, injector), nil
+		},
+	)
+}
+
+// FaultyCache is a [cache.Cache] decorator that injects the [Cache] fault of an [Injector] into every operation
+// except Stats and Close.
+//
+// Range reads are not supported, so the handler reads whole objects.
+type FaultyCache struct {
+	inner    cache.Cache
+	injector *Injector
+}
+
+var (
+	_ cache.Cache            = (*FaultyCache)(nil)
+	_ cache.StaleOpener      = (*FaultyCache)(nil)
+	_ cache.Purger           = (*FaultyCache)(nil)
+	_ cache.ExclusiveCreator = (*FaultyCache)(nil)
+	_ cache.TagLister        = (*FaultyCache)(nil)
+	_ cache.Lister           = (*FaultyCache)(nil)
+	_ cache.Epocher          = (*FaultyCache)(nil)
+)
+
+// NewCache creates a new [FaultyCache] wrapping inner.
+func NewCache(inner cache.Cache, injector *Injector) *FaultyCache {
+	return &FaultyCache{inner: inner, injector: injector}
+}
+
+func (f *FaultyCache) String() string { return "fault-injection:" + f.inner.String() }
+
+func (f *FaultyCache) Stat(ctx context.Context, key cache.Key) (http.Header, error) {
+	if err := f.injector.inject(ctx, Cache); err != nil {
+		return nil, err
+	}
+	return errors.WithStack2(f.inner.Stat(ctx, key))
+}
+
+func (f *FaultyCache) Has(ctx context.Context, key cache.Key) (bool, error) {
+	if err := f.injector.inject(ctx, Cache); err != nil {
+		return false, err
+	}
+	return errors.WithStack2(f.inner.Has(ctx, key))
+}
+
+func (f *FaultyCache) Open(ctx context.Context, key cache.Key) (io.ReadCloser, http.Header, error) {
+	if err := f.injector.inject(ctx, Cache); err != nil {
+		return nil, nil, err
+	}
+	return errors.WithStack3(f.inner.Open(ctx, key))
+}
+
+// OpenStale opens an object from the underlying cache that may have expired up to "grace" ago.
+func (f *FaultyCache) OpenStale(ctx context.Context, key cache.Key, grace time.Duration) (io.ReadCloser, http.Header, error) {
+	if err := f.injector.inject(ctx, Cache); err != nil {
+		return nil, nil, err
+	}
+	return errors.WithStack3(cache.OpenStale(ctx, f.inner, key, grace))
+}
+
+// Purge removes objects from the underlying cache.
+func (f *FaultyCache) Purge(ctx context.Context, options cache.PurgeOptions) (cache.PurgeResult, error) {
+	if err := f.injector.inject(ctx, Cache); err != nil {
+		return cache.PurgeResult{}, err
+	}
+	return errors.WithStack2(cache.Purge(ctx, f.inner, options))
+}
+
+// ListTagged returns the objects in the underlying cache created with tag.
+func (f *FaultyCache) ListTagged(ctx context.Context, tag string) ([]cache.ObjectInfo, error) {
+	if err := f.injector.inject(ctx, Cache); err != nil {
+		return nil, err
+	}
+	return errors.WithStack2(cache.ListTagged(ctx, f.inner, tag))
+}
+
+// ListKeys returns the objects in the underlying cache whose keys start with prefix.
+func (f *FaultyCache) ListKeys(ctx context.Context, prefix string) iter.Seq2[cache.ObjectInfo, error] {
+	return func(yield func(cache.ObjectInfo, error) bool) {
+		if err := f.injector.inject(ctx, Cache); err != nil {
+			yield(cache.ObjectInfo{}, err)
+			return
+		}
+		for object, err := range cache.ListKeys(ctx, f.inner, prefix) {
+			if !yield(object, err) {
+				return
+			}
+		}
+	}
+}
+
+// CurrentEpoch returns the epoch of the underlying cache.
+func (f *FaultyCache) CurrentEpoch(ctx context.Context) (uint64, error) {
+	if err := f.injector.inject(ctx, Cache); err != nil {
+		return 0, err
+	}
+	return errors.WithStack2(cache.CurrentEpoch(ctx, f.inner))
+}
+
+// AdvanceEpoch advances the epoch of the underlying cache.
+func (f *FaultyCache) AdvanceEpoch(ctx context.Context) (uint64, error) {
+	if err := f.injector.inject(ctx, Cache); err != nil {
+		return 0, err
+	}
+	return errors.WithStack2(cache.AdvanceEpoch(ctx, f.inner))
+}
+
+func (f *FaultyCache) Create(ctx context.Context, key cache.Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	if err := f.injector.inject(ctx, Cache); err != nil {
+		return nil, err
+	}
+	return errors.WithStack2(f.inner.Create(ctx, key, headers, ttl))
+}
+
+// CreateExclusive creates an object only if it does not already exist in the underlying cache.
+func (f *FaultyCache) CreateExclusive(ctx context.Context, key cache.Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	if err := f.injector.inject(ctx, Cache); err != nil {
+		return nil, err
+	}
+	return errors.WithStack2(cache.CreateExclusive(ctx, f.inner, key, headers, ttl))
+}
+
+func (f *FaultyCache) Touch(ctx context.Context, key cache.Key, ttl time.Duration) error {
+	if err := f.injector.inject(ctx, Cache); err != nil {
+		return err
+	}
+	return errors.WithStack(f.inner.Touch(ctx, key, ttl))
+}
+
+func (f *FaultyCache) Delete(ctx context.Context, key cache.Key) error {
+	if err := f.injector.inject(ctx, Cache); err != nil {
+		return err
+	}
+	return errors.WithStack(f.inner.Delete(ctx, key))
+}
+
+func (f *FaultyCache) Stats(ctx context.Context) (cache.Stats, error) {
+	return errors.WithStack2(f.inner.Stats(ctx))
+}
+
+func (f *FaultyCache) Close() error { return errors.WithStack(f.inner.Close()) }
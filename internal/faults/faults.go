@@ -0,0 +1,172 @@
+// Package faults injects failures and latency into cache operations and upstream requests, to test how cachew
+// behaves when its dependencies misbehave.
+//
+// It is intended for chaos testing in staging, so cachewd only installs it when started with an explicit flag.
+package faults
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/httputil"
+)
+
+// ErrInjected is returned by operations that an [Injector] failed.
+var ErrInjected = errors.New("injected failure") //nolint:gochecknoglobals
+
+// Target is a kind of operation that faults are injected into.
+type Target string
+
+const (
+	// Cache operations, injected by the decorator registered with [Register].
+	Cache Target = "cache"
+	// Upstream requests, injected by [Injector.Transport].
+	Upstream Target = "upstream"
+)
+
+// Fault is the failure rate and latency injected into a [Target].
+type Fault struct {
+	ErrorRate float64       `hcl:"error-rate,optional" help:"Fraction of operations, between 0 and 1, that fail."`
+	Delay     time.Duration `hcl:"delay,optional" help:"Latency added to each operation."`
+}
+
+// Validate the fault.
+func (f *Fault) Validate() error {
+	var errs []error
+	if f.ErrorRate < 0 || f.ErrorRate > 1 {
+		errs = append(errs, errors.Errorf("error-rate must be between 0 and 1, got %v", f.ErrorRate))
+	}
+	if f.Delay < 0 {
+		errs = append(errs, errors.New("delay must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// Injector holds the faults currently injected into each [Target]. Faults can be changed at any time, including
+// through [Injector.Handler].
+type Injector struct {
+	mu     sync.Mutex
+	faults map[Target]Fault
+}
+
+// NewInjector creates an [Injector] that initially injects no faults.
+func NewInjector() *Injector {
+	return &Injector{faults: map[Target]Fault{}}
+}
+
+// Set the fault injected into target.
+func (i *Injector) Set(target Target, fault Fault) error {
+	if target != Cache && target != Upstream {
+		return errors.Errorf("unknown fault target %q", target)
+	}
+	if err := fault.Validate(); err != nil {
+		return err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.faults[target] = fault
+	return nil
+}
+
+// Faults returns the fault injected into each target.
+func (i *Injector) Faults() map[Target]Fault {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return map[Target]Fault{Cache: i.faults[Cache], Upstream: i.faults[Upstream]}
+}
+
+// inject delays an operation on target, then returns [ErrInjected] if it should fail.
+func (i *Injector) inject(ctx context.Context, target Target) error {
+	i.mu.Lock()
+	fault := i.faults[target]
+	i.mu.Unlock()
+	if fault.Delay > 0 {
+		select {
+		case <-time.After(fault.Delay):
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		}
+	}
+	if fault.ErrorRate > 0 && rand.Float64() < fault.ErrorRate { //nolint:gosec // Fault selection does not need to be cryptographically secure.
+		return errors.Errorf("%s: %w", target, ErrInjected)
+	}
+	return nil
+}
+
+// Handler returns the endpoint reporting and changing the injected faults.
+//
+// GET responds with the faults of every target as JSON. POST sets the fault of the target named by the "target"
+// query parameter from its "error-rate" and "delay" parameters, which default to zero.
+func (i *Injector) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			fault, err := parseFault(r)
+			if err == nil {
+				err = i.Set(Target(r.URL.Query().Get("target")), fault)
+			}
+			if err != nil {
+				httputil.ErrorResponse(w, r, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		type faultJSON struct {
+			ErrorRate float64 `json:"error_rate"`
+			Delay     string  `json:"delay"`
+		}
+		faults := map[Target]faultJSON{}
+		for target, fault := range i.Faults() {
+			faults[target] = faultJSON{ErrorRate: fault.ErrorRate, Delay: fault.Delay.String()}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(faults); err != nil {
+			httputil.ErrorResponse(w, r, http.StatusInternalServerError, "failed to encode faults", "error", err)
+		}
+	})
+}
+
+func parseFault(r *http.Request) (Fault, error) {
+	var fault Fault
+	query := r.URL.Query()
+	if value := query.Get("error-rate"); value != "" {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return Fault{}, errors.Errorf("invalid error-rate: %w", err)
+		}
+		fault.ErrorRate = rate
+	}
+	if value := query.Get("delay"); value != "" {
+		delay, err := time.ParseDuration(value)
+		if err != nil {
+			return Fault{}, errors.Errorf("invalid delay: %w", err)
+		}
+		fault.Delay = delay
+	}
+	return fault, nil
+}
+
+// Transport returns a [http.RoundTripper] that injects the [Upstream] fault into requests sent through next.
+func (i *Injector) Transport(next http.RoundTripper) http.RoundTripper {
+	return &transport{injector: i, next: next}
+}
+
+type transport struct {
+	injector *Injector
+	next     http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.injector.inject(req.Context(), Upstream); err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+	return errors.WithStack2(t.next.RoundTrip(req))
+}
@@ -0,0 +1,128 @@
+package faults_test
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/faults"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy/handler"
+)
+
+func TestInjectedFaults(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(w, "fresh response")
+	}))
+	defer upstream.Close()
+
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	memory, err := cache.NewMemory(ctx, cache.MemoryConfig{LimitMB: 1, MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	injector := faults.NewInjector()
+	client := &http.Client{Transport: injector.Transport(http.DefaultTransport)}
+	newHandler := func() *handler.Handler {
+		return handler.New(client, faults.NewCache(memory, injector)).
+			TTL(func(_ *http.Request) time.Duration { return 50 * time.Millisecond }).
+			StaleIfError(time.Hour).
+			Transform(func(r *http.Request) (*http.Request, error) {
+				return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+			})
+	}
+	h := newHandler()
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/test", nil))
+		return w
+	}
+
+	w := serve()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, handler.CacheMiss, w.Header().Get("X-Cache"))
+	time.Sleep(100 * time.Millisecond)
+
+	t.Run("UpstreamFailureServesStale", func(t *testing.T) {
+		assert.NoError(t, injector.Set(faults.Upstream, faults.Fault{ErrorRate: 1}))
+		t.Cleanup(func() { _ = injector.Set(faults.Upstream, faults.Fault{}) })
+
+		w := serve()
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, handler.CacheStale, w.Header().Get("X-Cache"))
+		assert.Equal(t, "fresh response", w.Body.String())
+	})
+
+	t.Run("CacheFailureFetchesUpstream", func(t *testing.T) {
+		assert.NoError(t, injector.Set(faults.Cache, faults.Fault{ErrorRate: 1}))
+		t.Cleanup(func() { _ = injector.Set(faults.Cache, faults.Fault{}) })
+
+		w := serve()
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "fresh response", w.Body.String())
+	})
+
+	t.Run("CacheFailureFailsClosedRequest", func(t *testing.T) {
+		assert.NoError(t, injector.Set(faults.Cache, faults.Fault{ErrorRate: 1}))
+		t.Cleanup(func() {
+			_ = injector.Set(faults.Cache, faults.Fault{})
+			h = newHandler()
+		})
+
+		h = newHandler().FailClosed()
+		w := serve()
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+
+	t.Run("Recovered", func(t *testing.T) {
+		w := serve()
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, handler.CacheMiss, w.Header().Get("X-Cache"))
+	})
+
+	t.Run("Delay", func(t *testing.T) {
+		assert.NoError(t, injector.Set(faults.Upstream, faults.Fault{Delay: 50 * time.Millisecond}))
+		t.Cleanup(func() { _ = injector.Set(faults.Upstream, faults.Fault{}) })
+
+		time.Sleep(100 * time.Millisecond)
+		start := time.Now()
+		w := serve()
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	})
+}
+
+func TestInjectorHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		query    string
+		status   int
+		contains string
+	}{
+		{name: "Get", method: http.MethodGet, status: http.StatusOK, contains: `"cache":{"error_rate":0,"delay":"0s"}`},
+		{name: "Set", method: http.MethodPost, query: "target=upstream&error-rate=0.25&delay=100ms", status: http.StatusOK,
+			contains: `"upstream":{"error_rate":0.25,"delay":"100ms"}`},
+		{name: "UnknownTarget", method: http.MethodPost, query: "target=disk&error-rate=1", status: http.StatusBadRequest,
+			contains: "unknown fault target"},
+		{name: "RateOutOfRange", method: http.MethodPost, query: "target=cache&error-rate=2", status: http.StatusBadRequest,
+			contains: "error-rate must be between 0 and 1"},
+		{name: "InvalidDelay", method: http.MethodPost, query: "target=cache&delay=soon", status: http.StatusBadRequest,
+			contains: "invalid delay"},
+	}
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			injector := faults.NewInjector()
+			w := httptest.NewRecorder()
+			injector.Handler().ServeHTTP(w, httptest.NewRequestWithContext(ctx, tt.method, "/_faults?"+tt.query, nil))
+			assert.Equal(t, tt.status, w.Code)
+			assert.Contains(t, w.Body.String(), tt.contains)
+		})
+	}
+}
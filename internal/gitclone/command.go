@@ -7,10 +7,101 @@ import (
 	"context"
 	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/httputil"
+	"github.com/block/cachew/internal/retry"
 )
 
+// runGit runs a git command, returning its combined output. It is a variable so tests can inject failures.
+var runGit = func(cmd *exec.Cmd) ([]byte, error) { return cmd.CombinedOutput() } //nolint:gochecknoglobals
+
+// networkRetry controls retries of git commands that talk to an upstream.
+var networkRetry = retry.Config{MaxAttempts: 3, InitialBackoff: 2 * time.Second, MaxBackoff: 30 * time.Second} //nolint:gochecknoglobals
+
+// transientGitErrors are fragments of git output indicating a network failure that may succeed if retried.
+var transientGitErrors = []string{ //nolint:gochecknoglobals
+	"Could not resolve host",
+	"Connection timed out",
+	"Connection refused",
+	"Connection reset by peer",
+	"Operation timed out",
+	"The remote end hung up unexpectedly",
+	"early EOF",
+	"RPC failed",
+	"The requested URL returned error: 502",
+	"The requested URL returned error: 503",
+	"The requested URL returned error: 504",
+}
+
+// gitError is returned by [Repository.runNetworkGit] when git exits unsuccessfully.
+type gitError struct {
+	err    error
+	output []byte
+}
+
+func (e *gitError) Error() string { return e.err.Error() }
+func (e *gitError) Unwrap() error { return e.err }
+
+// isTransientGitError returns true if err is a git failure caused by a network error.
+//
+// Other failures, such as missing refs or authentication errors, are permanent.
+func isTransientGitError(err error) bool {
+	gitErr, ok := errors.AsType[*gitError](err)
+	if !ok {
+		return false
+	}
+	for _, fragment := range transientGitErrors {
+		if strings.Contains(string(gitErr.output), fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// runNetworkGit runs a git command against the repository's upstream, retrying transient network failures with
+// exponential backoff.
+//
+// The output of the last attempt is returned.
+func (r *Repository) runNetworkGit(ctx context.Context, args ...string) ([]byte, error) {
+	if r.config.UserAgent != "" {
+		args = append([]string{"-c", "http.userAgent=" + r.config.UserAgent}, args...)
+	}
+	args = append(proxyArgs(ctx, r.upstreamURL), args...)
+	var output []byte
+	err := retry.Do(ctx, networkRetry, isTransientGitError, func(ctx context.Context) error {
+		cmd, err := gitCommand(ctx, r.upstreamURL, args...)
+		if err != nil {
+			return errors.Wrap(err, "create git command")
+		}
+		output, err = runGit(cmd)
+		if err != nil {
+			return &gitError{err: err, output: output}
+		}
+		return nil
+	})
+	return output, err
+}
+
+// proxyArgs returns the git configuration sending requests to upstreamURL through the same proxy as other upstream
+// requests, or nothing if git should be left to find the proxy from the environment itself.
+func proxyArgs(ctx context.Context, upstreamURL string) []string {
+	if !strings.HasPrefix(upstreamURL, "http://") && !strings.HasPrefix(upstreamURL, "https://") {
+		return nil
+	}
+	proxy, explicit, err := httputil.ProxyForURL(ctx, upstreamURL)
+	if err != nil || !explicit {
+		return nil
+	}
+	if proxy == nil {
+		// An empty proxy disables any proxy git would otherwise use.
+		return []string{"-c", "http.proxy="}
+	}
+	return []string{"-c", "http.proxy=" + proxy.String()}
+}
+
 func gitCommand(ctx context.Context, url string, args ...string) (*exec.Cmd, error) {
 	configArgs, err := getInsteadOfDisableArgsForURL(ctx, url)
 	if err != nil {
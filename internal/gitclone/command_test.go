@@ -2,9 +2,12 @@ package gitclone //nolint:testpackage // Internal functions need to be tested
 
 import (
 	"context"
+	"os/exec"
 	"testing"
+	"time"
 
 	"github.com/alecthomas/assert/v2"
+	"github.com/alecthomas/errors"
 )
 
 func TestGetInsteadOfDisableArgsForURL(t *testing.T) {
@@ -66,3 +69,54 @@ func TestGitCommandWithEmptyURL(t *testing.T) {
 	assert.Equal(t, "git", cmd.Args[0])
 	assert.Equal(t, "version", cmd.Args[len(cmd.Args)-1])
 }
+
+func TestGetUpstreamRefsRetriesTransientErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		failures       int
+		failureOutput  string
+		expectErr      bool
+		expectAttempts int
+	}{
+		{
+			name:           "TransientThenSuccess",
+			failures:       2,
+			failureOutput:  "fatal: unable to access 'https://example.com/repo/': Could not resolve host: example.com",
+			expectAttempts: 3,
+		},
+		{
+			name:           "AuthenticationNotRetried",
+			failures:       1,
+			failureOutput:  "fatal: Authentication failed for 'https://example.com/repo/'",
+			expectErr:      true,
+			expectAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			originalRunGit, originalRetry := runGit, networkRetry
+			t.Cleanup(func() { runGit, networkRetry = originalRunGit, originalRetry })
+			networkRetry.InitialBackoff = time.Millisecond
+			networkRetry.MaxBackoff = time.Millisecond
+
+			attempts := 0
+			runGit = func(*exec.Cmd) ([]byte, error) {
+				attempts++
+				if attempts <= tt.failures {
+					return []byte(tt.failureOutput), errors.New("exit status 128")
+				}
+				return []byte("abc123\trefs/heads/main\n"), nil
+			}
+
+			repo := &Repository{upstreamURL: "https://example.com/repo"}
+			refs, err := repo.GetUpstreamRefs(t.Context())
+			assert.Equal(t, tt.expectAttempts, attempts)
+			if tt.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]string{"refs/heads/main": "abc123"}, refs)
+		})
+	}
+}
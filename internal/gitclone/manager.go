@@ -2,11 +2,15 @@ package gitclone
 
 import (
 	"context"
+	"fmt"
 	"io/fs"
+	"log/slog"
+	"maps"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -14,6 +18,7 @@ import (
 
 	"github.com/alecthomas/errors"
 
+	"github.com/block/cachew/internal/events"
 	"github.com/block/cachew/internal/logging"
 )
 
@@ -56,6 +61,10 @@ type Config struct {
 	MirrorRoot       string        `hcl:"mirror-root" help:"Directory to store git clones."`
 	FetchInterval    time.Duration `hcl:"fetch-interval,optional" help:"How often to fetch from upstream in minutes." default:"15m"`
 	RefCheckInterval time.Duration `hcl:"ref-check-interval,optional" help:"How long to cache ref checks." default:"10s"`
+	CloneDepth       int           `hcl:"clone-depth,optional" help:"Create shallow mirrors with this many commits of history (0 for full history)."`
+	MaxCloneDepth    int           `hcl:"max-clone-depth,optional" help:"Cap for auto-deepening shallow mirrors when a commit is missing (0 to unshallow instead)."`
+	Filter           string        `hcl:"filter,optional" help:"Partial clone filter for mirrors, eg. \"blob:limit=1m\" or \"sparse:oid=main:.sparse\". Filtered out objects are fetched from upstream when first requested."`
+	UserAgent        string        `hcl:"-" kong:"-"` // Sent to upstreams as http.userAgent.
 }
 
 type Repository struct {
@@ -68,6 +77,8 @@ type Repository struct {
 	lastRefCheck  time.Time
 	refCheckValid bool
 	fetchSem      chan struct{}
+	depth         int  // history depth of a shallow mirror, 0 if unknown or full
+	filtered      bool // whether the mirror is a partial clone
 }
 
 type Manager struct {
@@ -99,6 +110,14 @@ func NewManager(ctx context.Context, config Config) (*Manager, error) {
 		config.RefCheckInterval = 10 * time.Second
 	}
 
+	if config.CloneDepth < 0 || config.MaxCloneDepth < 0 {
+		return nil, errors.New("clone-depth and max-clone-depth must not be negative")
+	}
+
+	if err := validateFilter(config.Filter); err != nil {
+		return nil, err
+	}
+
 	if err := os.MkdirAll(config.MirrorRoot, 0o750); err != nil {
 		return nil, errors.Wrap(err, "create root directory")
 	}
@@ -115,11 +134,26 @@ func NewManager(ctx context.Context, config Config) (*Manager, error) {
 	}, nil
 }
 
+// filterPrefixes are the git partial clone filter-specs that mirrors may be created with.
+var filterPrefixes = []string{"blob:none", "blob:limit=", "tree:", "sparse:oid=", "object:type=", "combine:"} //nolint:gochecknoglobals
+
+func validateFilter(filter string) error {
+	if filter == "" {
+		return nil
+	}
+	for _, prefix := range filterPrefixes {
+		if strings.HasPrefix(filter, prefix) {
+			return nil
+		}
+	}
+	return errors.Errorf("filter must be a git filter-spec starting with one of %s, got %q", strings.Join(filterPrefixes, ", "), filter)
+}
+
 func (m *Manager) Config() Config {
 	return m.config
 }
 
-func (m *Manager) GetOrCreate(_ context.Context, upstreamURL string) (*Repository, error) {
+func (m *Manager) GetOrCreate(ctx context.Context, upstreamURL string) (*Repository, error) {
 	m.clonesMu.RLock()
 	repo, exists := m.clones[upstreamURL]
 	m.clonesMu.RUnlock()
@@ -148,6 +182,7 @@ func (m *Manager) GetOrCreate(_ context.Context, upstreamURL string) (*Repositor
 	gitDir := filepath.Join(clonePath, ".git")
 	if _, err := os.Stat(gitDir); err == nil {
 		repo.state = StateReady
+		repo.filtered = isPartialClone(ctx, clonePath)
 	}
 
 	repo.fetchSem <- struct{}{}
@@ -162,7 +197,18 @@ func (m *Manager) Get(upstreamURL string) *Repository {
 	return m.clones[upstreamURL]
 }
 
-func (m *Manager) DiscoverExisting(_ context.Context) ([]*Repository, error) {
+// Repositories returns a snapshot of all known repositories, sorted by upstream URL.
+func (m *Manager) Repositories() []*Repository {
+	m.clonesMu.RLock()
+	defer m.clonesMu.RUnlock()
+	repos := slices.Collect(maps.Values(m.clones))
+	slices.SortFunc(repos, func(a, b *Repository) int { return strings.Compare(a.upstreamURL, b.upstreamURL) })
+	return repos
+}
+
+// DiscoverExisting registers the clones already present under the mirror root as ready, with their last fetch time
+// taken from the clone on disk.
+func (m *Manager) DiscoverExisting(ctx context.Context) ([]*Repository, error) {
 	var discovered []*Repository
 	err := filepath.Walk(m.config.MirrorRoot, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -206,9 +252,12 @@ func (m *Manager) DiscoverExisting(_ context.Context) ([]*Repository, error) {
 
 		repo := &Repository{
 			state:       StateReady,
+			config:      m.config,
 			path:        path,
 			upstreamURL: upstreamURL,
+			lastFetch:   discoveredFetchTime(gitDir),
 			fetchSem:    make(chan struct{}, 1),
+			filtered:    isPartialClone(ctx, path),
 		}
 		repo.fetchSem <- struct{}{}
 
@@ -228,6 +277,17 @@ func (m *Manager) DiscoverExisting(_ context.Context) ([]*Repository, error) {
 	return discovered, nil
 }
 
+// discoveredFetchTime estimates when an existing clone was last updated from the modification time of FETCH_HEAD,
+// which is written by every fetch, or HEAD if it has never been fetched since it was cloned.
+func discoveredFetchTime(gitDir string) time.Time {
+	for _, name := range []string{"FETCH_HEAD", "HEAD"} {
+		if info, err := os.Stat(filepath.Join(gitDir, name)); err == nil {
+			return info.ModTime()
+		}
+	}
+	return time.Time{}
+}
+
 func (m *Manager) clonePathForURL(upstreamURL string) string {
 	parsed, err := url.Parse(upstreamURL)
 	if err != nil {
@@ -252,6 +312,14 @@ func (r *Repository) UpstreamURL() string {
 	return r.upstreamURL
 }
 
+// Filtered returns true if the mirror was created with a partial clone filter, so is missing objects that are fetched
+// from upstream when needed.
+func (r *Repository) Filtered() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.filtered
+}
+
 func (r *Repository) LastFetch() time.Time {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -285,7 +353,14 @@ func (r *Repository) Clone(ctx context.Context) error {
 	r.state = StateCloning
 	r.mu.Unlock()
 
+	events.Publish(ctx, events.CloneStart, slog.String("upstream", r.upstreamURL))
+	start := time.Now()
 	err := r.executeClone(ctx)
+	finished := []slog.Attr{slog.String("upstream", r.upstreamURL), slog.Duration("duration", time.Since(start))}
+	if err != nil {
+		finished = append(finished, slog.String("error", err.Error()))
+	}
+	events.Publish(ctx, events.CloneFinish, finished...)
 
 	r.mu.Lock()
 	if err != nil {
@@ -295,6 +370,8 @@ func (r *Repository) Clone(ctx context.Context) error {
 	}
 
 	r.state = StateReady
+	r.depth = r.config.CloneDepth
+	r.filtered = r.config.Filter != ""
 	r.lastFetch = time.Now()
 	r.mu.Unlock()
 	return nil
@@ -312,34 +389,35 @@ func (r *Repository) executeClone(ctx context.Context) error {
 		"-c", "http.postBuffer=" + strconv.Itoa(config.PostBuffer),
 		"-c", "http.lowSpeedLimit=" + strconv.Itoa(config.LowSpeedLimit),
 		"-c", "http.lowSpeedTime=" + strconv.Itoa(int(config.LowSpeedTime.Seconds())),
-		r.upstreamURL, r.path,
 	}
-
-	cmd, err := gitCommand(ctx, r.upstreamURL, args...)
-	if err != nil {
-		return errors.Wrap(err, "create git command")
+	if r.config.CloneDepth > 0 {
+		args = append(args, "--depth", strconv.Itoa(r.config.CloneDepth), "--no-single-branch")
+	}
+	if r.config.Filter != "" {
+		// The upstream becomes a promisor remote, which git fetches filtered out objects from when they are needed,
+		// including by upload-pack when serving them to clients.
+		args = append(args, "--filter="+r.config.Filter)
 	}
-	output, err := cmd.CombinedOutput()
+	args = append(args, r.upstreamURL, r.path)
+
+	// git removes the partial clone on failure, so a failed attempt can be retried in place.
+	output, err := r.runNetworkGit(ctx, args...)
 	if err != nil {
 		return errors.Wrapf(err, "git clone: %s", string(output))
 	}
 
 	// #nosec G204 - r.path is controlled by us
-	cmd = exec.CommandContext(ctx, "git", "-C", r.path, "config", "remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*")
+	cmd := exec.CommandContext(ctx, "git", "-C", r.path, "config", "remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*")
 	output, err = cmd.CombinedOutput()
 	if err != nil {
 		return errors.Wrapf(err, "configure fetch refspec: %s", string(output))
 	}
 
-	cmd, err = gitCommand(ctx, r.upstreamURL, "-C", r.path,
+	output, err = r.runNetworkGit(ctx, "-C", r.path,
 		"-c", "http.postBuffer="+strconv.Itoa(config.PostBuffer),
 		"-c", "http.lowSpeedLimit="+strconv.Itoa(config.LowSpeedLimit),
 		"-c", "http.lowSpeedTime="+strconv.Itoa(int(config.LowSpeedTime.Seconds())),
 		"fetch", "--all")
-	if err != nil {
-		return errors.Wrap(err, "create git command for fetch")
-	}
-	output, err = cmd.CombinedOutput()
 	if err != nil {
 		return errors.Wrapf(err, "fetch all branches: %s", string(output))
 	}
@@ -347,53 +425,93 @@ func (r *Repository) executeClone(ctx context.Context) error {
 	return nil
 }
 
-func (r *Repository) Fetch(ctx context.Context) error {
+// Fetch updates the clone from upstream.
+//
+// It returns the remote-tracking refs whose history was rewritten by the fetch, ie. whose previous tip is not an
+// ancestor of the new one, as happens when a branch is force-pushed upstream. If a fetch is already in progress, Fetch
+// waits for it to complete and returns no refs.
+func (r *Repository) Fetch(ctx context.Context) ([]string, error) {
 	select {
 	case <-r.fetchSem:
 		defer func() {
 			r.fetchSem <- struct{}{}
 		}()
 	case <-ctx.Done():
-		return errors.Wrap(ctx.Err(), "context cancelled before acquiring fetch semaphore")
+		return nil, errors.Wrap(ctx.Err(), "context cancelled before acquiring fetch semaphore")
 	default:
 		select {
 		case <-r.fetchSem:
 			r.fetchSem <- struct{}{}
-			return nil
+			return nil, nil
 		case <-ctx.Done():
-			return errors.Wrap(ctx.Err(), "context cancelled while waiting for fetch")
+			return nil, errors.Wrap(ctx.Err(), "context cancelled while waiting for fetch")
 		}
 	}
 
 	r.mu.Lock()
+	defer r.mu.Unlock()
 
 	config := DefaultGitTuningConfig()
 
+	before, err := r.remoteRefsLocked(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	// #nosec G204 - r.path is controlled by us
-	cmd, err := gitCommand(ctx, r.upstreamURL, "-C", r.path,
+	output, err := r.runNetworkGit(ctx, "-C", r.path,
 		"-c", "http.postBuffer="+strconv.Itoa(config.PostBuffer),
 		"-c", "http.lowSpeedLimit="+strconv.Itoa(config.LowSpeedLimit),
 		"-c", "http.lowSpeedTime="+strconv.Itoa(int(config.LowSpeedTime.Seconds())),
 		"remote", "update", "--prune")
 	if err != nil {
-		return errors.Wrap(err, "create git command")
+		return nil, errors.Wrapf(err, "git remote update: %s", string(output))
 	}
-	output, err := cmd.CombinedOutput()
+
+	r.lastFetch = time.Now()
+
+	after, err := r.remoteRefsLocked(ctx)
 	if err != nil {
-		return errors.Wrapf(err, "git remote update: %s", string(output))
+		return nil, err
+	}
+	var rewritten []string
+	for ref, oldSHA := range before {
+		newSHA, ok := after[ref]
+		if !ok || newSHA == oldSHA || r.isAncestorLocked(ctx, oldSHA, newSHA) {
+			continue
+		}
+		rewritten = append(rewritten, ref)
 	}
+	slices.Sort(rewritten)
+	return rewritten, nil
+}
 
-	r.lastFetch = time.Now()
-	r.mu.Unlock()
+// remoteRefsLocked returns the remote-tracking refs of the clone, mapped to the commits they point to.
+func (r *Repository) remoteRefsLocked(ctx context.Context) (map[string]string, error) {
+	// #nosec G204 - r.path is controlled by us
+	output, err := exec.CommandContext(ctx, "git", "-C", r.path, "for-each-ref", "--format=%(objectname) %(refname)", "refs/remotes/").CombinedOutput()
+	if err != nil {
+		return nil, errors.Wrapf(err, "git for-each-ref: %s", string(output))
+	}
+	return ParseGitRefs(output), nil
+}
 
-	return nil
+// isAncestorLocked returns true if ancestor is reachable from commit. Failures, such as the ancestor having been
+// garbage collected, are treated as a rewrite.
+func (r *Repository) isAncestorLocked(ctx context.Context, ancestor, commit string) bool {
+	// #nosec G204 - r.path and the commits are controlled by us
+	cmd := exec.CommandContext(ctx, "git", "-C", r.path, "merge-base", "--is-ancestor", ancestor, commit)
+	return cmd.Run() == nil
 }
 
-func (r *Repository) EnsureRefsUpToDate(ctx context.Context) error {
+// EnsureRefsUpToDate fetches if upstream refs have changed, checking at most once per refCheckInterval.
+//
+// It returns the refs rewritten by the fetch, as described by [Repository.Fetch].
+func (r *Repository) EnsureRefsUpToDate(ctx context.Context, refCheckInterval time.Duration) ([]string, error) {
 	r.mu.Lock()
-	if r.refCheckValid && time.Since(r.lastRefCheck) < r.config.RefCheckInterval {
+	if r.refCheckValid && time.Since(r.lastRefCheck) < refCheckInterval {
 		r.mu.Unlock()
-		return nil
+		return nil, nil
 	}
 	r.lastRefCheck = time.Now()
 	r.refCheckValid = true
@@ -401,12 +519,12 @@ func (r *Repository) EnsureRefsUpToDate(ctx context.Context) error {
 
 	localRefs, err := r.GetLocalRefs(ctx)
 	if err != nil {
-		return errors.Wrap(err, "get local refs")
+		return nil, errors.Wrap(err, "get local refs")
 	}
 
 	upstreamRefs, err := r.GetUpstreamRefs(ctx)
 	if err != nil {
-		return errors.Wrap(err, "get upstream refs")
+		return nil, errors.Wrap(err, "get upstream refs")
 	}
 
 	needsFetch := false
@@ -429,16 +547,16 @@ func (r *Repository) EnsureRefsUpToDate(ctx context.Context) error {
 		r.mu.Lock()
 		r.refCheckValid = true
 		r.mu.Unlock()
-		return nil
+		return nil, nil
 	}
 
-	err = r.Fetch(ctx)
+	rewritten, err := r.Fetch(ctx)
 	if err != nil {
 		r.mu.Lock()
 		r.refCheckValid = false
 		r.mu.Unlock()
 	}
-	return err
+	return rewritten, err
 }
 
 func (r *Repository) GetLocalRefs(ctx context.Context) (map[string]string, error) {
@@ -460,22 +578,130 @@ func (r *Repository) GetLocalRefs(ctx context.Context) (map[string]string, error
 
 func (r *Repository) GetUpstreamRefs(ctx context.Context) (map[string]string, error) {
 	// #nosec G204 - r.upstreamURL is controlled by us
-	cmd, err := gitCommand(ctx, r.upstreamURL, "ls-remote", r.upstreamURL)
+	output, err := r.runNetworkGit(ctx, "ls-remote", r.upstreamURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "git ls-remote: %s", string(output))
+	}
+
+	return ParseGitRefs(output), nil
+}
+
+// EnsureCommit makes sure ref is present in the mirror. If it is missing
+// from a shallow mirror the history is progressively deepened, doubling the
+// depth each time up to MaxCloneDepth, or unshallowed outright if no cap is
+// configured.
+func (r *Repository) EnsureCommit(ctx context.Context, ref string) error {
+	if r.HasCommit(ctx, ref) {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	logger := logging.FromContext(ctx).With("upstream", r.upstreamURL, "ref", ref)
+	for {
+		if r.hasCommitLocked(ctx, ref) {
+			return nil
+		}
+		depth, err := r.shallowDepthLocked(ctx)
+		if err != nil {
+			return err
+		}
+		if depth == 0 {
+			return errors.Errorf("commit %s not found in repository %s", ref, r.upstreamURL)
+		}
+
+		args := []string{"-C", r.path, "fetch", "--unshallow", "origin"}
+		if r.config.MaxCloneDepth > 0 {
+			if depth >= r.config.MaxCloneDepth {
+				return errors.Errorf("commit %s not found in repository %s within depth %d", ref, r.upstreamURL, r.config.MaxCloneDepth)
+			}
+			depth = min(depth*2, r.config.MaxCloneDepth)
+			args = []string{"-C", r.path, "fetch", "--depth", strconv.Itoa(depth), "origin"}
+		}
+		logger.InfoContext(ctx, "Deepening shallow mirror to find missing commit", "depth", depth)
+		// #nosec G204 - r.path is controlled by us
+		output, err := r.runNetworkGit(ctx, args...)
+		if err != nil {
+			// A fetch that fails part way through can leave partial objects behind, so clean up and retry once.
+			logger.WarnContext(ctx, "Fetch failed, cleaning up partial objects before retrying", "error", err)
+			if cleanupErr := r.cleanupPartialFetchLocked(ctx); cleanupErr != nil {
+				return &CommitError{Ref: ref, UpstreamURL: r.upstreamURL, Err: errors.Join(err, cleanupErr)}
+			}
+			output, err = r.runNetworkGit(ctx, args...)
+		}
+		if err != nil {
+			return &CommitError{Ref: ref, UpstreamURL: r.upstreamURL, Err: errors.Wrapf(err, "deepen shallow mirror: %s", string(output))}
+		}
+		r.depth = depth
+	}
+}
+
+// CommitError is returned by [Repository.EnsureCommit] when fetching a missing commit fails, even after recovering
+// from a partial fetch.
+type CommitError struct {
+	Ref         string
+	UpstreamURL string
+	Err         error
+}
+
+func (e *CommitError) Error() string {
+	return fmt.Sprintf("fetch commit %s from %s: %s", e.Ref, e.UpstreamURL, e.Err)
+}
+
+func (e *CommitError) Unwrap() error { return e.Err }
+
+// cleanupPartialFetchLocked removes the objects left behind by an interrupted fetch.
+//
+// fsck is only run to log the damage, as unreachable partial objects are expected and removed by the prune.
+func (r *Repository) cleanupPartialFetchLocked(ctx context.Context) error {
+	// #nosec G204 - r.path is controlled by us
+	output, err := runGit(exec.CommandContext(ctx, "git", "-C", r.path, "fsck", "--connectivity-only", "--no-progress"))
 	if err != nil {
-		return nil, errors.Wrap(err, "create git command")
+		logging.FromContext(ctx).WarnContext(ctx, "Mirror failed consistency check after fetch",
+			"upstream", r.upstreamURL, "output", strings.TrimSpace(string(output)))
 	}
-	output, err := cmd.CombinedOutput()
+	// #nosec G204 - r.path is controlled by us
+	output, err = runGit(exec.CommandContext(ctx, "git", "-C", r.path, "prune", "--expire=now"))
 	if err != nil {
-		return nil, errors.Wrap(err, "git ls-remote")
+		return errors.Wrapf(err, "git prune: %s", string(output))
 	}
+	return nil
+}
 
-	return ParseGitRefs(output), nil
+// isPartialClone returns true if the mirror at path has a promisor remote, as created by cloning with a filter. Mirrors
+// found on disk may have been created with a different filter configuration than the current one.
+func isPartialClone(ctx context.Context, path string) bool {
+	// #nosec G204 - path is controlled by us
+	output, err := exec.CommandContext(ctx, "git", "-C", path, "config", "--get", "remote.origin.promisor").Output()
+	return err == nil && strings.TrimSpace(string(output)) == "true"
+}
+
+// shallowDepthLocked returns the current depth of the mirror if it is
+// shallow, or 0 if it has full history.
+func (r *Repository) shallowDepthLocked(ctx context.Context) (int, error) {
+	// #nosec G204 - r.path is controlled by us
+	output, err := exec.CommandContext(ctx, "git", "-C", r.path, "rev-parse", "--is-shallow-repository").CombinedOutput()
+	if err != nil {
+		return 0, errors.Wrapf(err, "git rev-parse: %s", string(output))
+	}
+	if strings.TrimSpace(string(output)) != "true" {
+		return 0, nil
+	}
+	if r.depth > 0 {
+		return r.depth, nil
+	}
+	// Mirrors discovered on disk don't record how deep they are.
+	return max(r.config.CloneDepth, 1), nil
 }
 
 func (r *Repository) HasCommit(ctx context.Context, ref string) bool {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
+	return r.hasCommitLocked(ctx, ref)
+}
 
+func (r *Repository) hasCommitLocked(ctx context.Context, ref string) bool {
 	// #nosec G204 - r.path and ref are controlled by us
 	cmd := exec.CommandContext(ctx, "git", "-C", r.path, "cat-file", "-e", ref)
 	err := cmd.Run()
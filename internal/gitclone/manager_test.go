@@ -6,10 +6,13 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/alecthomas/assert/v2"
+	"github.com/alecthomas/errors"
 
 	"github.com/block/cachew/internal/logging"
 )
@@ -141,6 +144,13 @@ func TestManager_DiscoverExisting(t *testing.T) {
 		assert.NoError(t, os.MkdirAll(gitDir, 0o755))
 		assert.NoError(t, os.WriteFile(filepath.Join(gitDir, "HEAD"), []byte("ref: refs/heads/main\n"), 0o644))
 	}
+	// The last fetch time is taken from FETCH_HEAD if the clone has been fetched, and HEAD otherwise.
+	cloned := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	fetched := time.Now().Add(-time.Hour).Truncate(time.Second)
+	assert.NoError(t, os.Chtimes(filepath.Join(repos[0], ".git", "HEAD"), cloned, cloned))
+	assert.NoError(t, os.Chtimes(filepath.Join(repos[1], ".git", "HEAD"), cloned, cloned))
+	assert.NoError(t, os.WriteFile(filepath.Join(repos[1], ".git", "FETCH_HEAD"), nil, 0o644))
+	assert.NoError(t, os.Chtimes(filepath.Join(repos[1], ".git", "FETCH_HEAD"), fetched, fetched))
 
 	discovered, err := manager.DiscoverExisting(context.Background())
 	assert.NoError(t, err)
@@ -149,10 +159,12 @@ func TestManager_DiscoverExisting(t *testing.T) {
 	repo1 := manager.Get("https://github.com/user1/repo1")
 	assert.NotZero(t, repo1)
 	assert.Equal(t, StateReady, repo1.State())
+	assert.Equal(t, cloned, repo1.LastFetch())
 
 	repo2 := manager.Get("https://github.com/user2/repo2")
 	assert.NotZero(t, repo2)
 	assert.Equal(t, StateReady, repo2.State())
+	assert.Equal(t, fetched, repo2.LastFetch())
 
 	repo3 := manager.Get("https://gitlab.com/org/project")
 	assert.NotZero(t, repo3)
@@ -318,3 +330,190 @@ func TestRepository_HasCommit(t *testing.T) {
 	assert.False(t, repo.HasCommit(ctx, "nonexistent"))
 	assert.False(t, repo.HasCommit(ctx, "v9.9.9"))
 }
+
+func TestRepository_EnsureCommitDeepensShallowClone(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	tmpDir := t.TempDir()
+
+	// An upstream with eight commits, oldest first.
+	upstreamPath := filepath.Join(tmpDir, "upstream")
+	output, err := exec.Command("git", "init", "-q", "-b", "main", upstreamPath).CombinedOutput()
+	assert.NoError(t, err, "%s", output)
+	var commits []string
+	for i := range 8 {
+		output, err = exec.Command("git", "-C", upstreamPath, "-c", "user.name=test", "-c", "user.email=test@example.com",
+			"commit", "-q", "--allow-empty", "-m", "commit "+strconv.Itoa(i)).CombinedOutput()
+		assert.NoError(t, err, "%s", output)
+		output, err = exec.Command("git", "-C", upstreamPath, "rev-parse", "HEAD").CombinedOutput()
+		assert.NoError(t, err, "%s", output)
+		commits = append(commits, strings.TrimSpace(string(output)))
+	}
+
+	tests := []struct {
+		name          string
+		maxCloneDepth int
+		commit        string
+		expectedDepth int
+		expectedError string
+	}{
+		{name: "DeepensToFindCommit", maxCloneDepth: 16, commit: commits[4], expectedDepth: 4},
+		{name: "StopsAtCap", maxCloneDepth: 2, commit: commits[0], expectedError: "within depth 2"},
+		{name: "UnshallowsWithoutCap", commit: commits[0]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, err := NewManager(ctx, Config{
+				MirrorRoot:    filepath.Join(t.TempDir(), "mirrors"),
+				CloneDepth:    1,
+				MaxCloneDepth: tt.maxCloneDepth,
+			})
+			assert.NoError(t, err)
+			repo, err := manager.GetOrCreate(ctx, "file://"+upstreamPath)
+			assert.NoError(t, err)
+			assert.NoError(t, repo.Clone(ctx))
+			assert.False(t, repo.Filtered())
+			assert.True(t, repo.HasCommit(ctx, commits[7]))
+			assert.False(t, repo.HasCommit(ctx, tt.commit))
+
+			err = repo.EnsureCommit(ctx, tt.commit)
+			if tt.expectedError != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+				assert.False(t, repo.HasCommit(ctx, tt.commit))
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, repo.HasCommit(ctx, tt.commit))
+
+			output, err := exec.Command("git", "-C", repo.Path(), "rev-parse", "--is-shallow-repository").CombinedOutput()
+			assert.NoError(t, err, "%s", output)
+			assert.Equal(t, tt.expectedDepth != 0, strings.TrimSpace(string(output)) == "true")
+			if tt.expectedDepth != 0 {
+				output, err = exec.Command("git", "-C", repo.Path(), "rev-list", "--count", "origin/main").CombinedOutput()
+				assert.NoError(t, err, "%s", output)
+				assert.Equal(t, strconv.Itoa(tt.expectedDepth), strings.TrimSpace(string(output)))
+			}
+		})
+	}
+}
+
+func TestRepository_EnsureCommitRecoversFromPartialFetch(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	tmpDir := t.TempDir()
+
+	upstreamPath := filepath.Join(tmpDir, "upstream")
+	output, err := exec.Command("git", "init", "-q", "-b", "main", upstreamPath).CombinedOutput()
+	assert.NoError(t, err, "%s", output)
+	var commits []string
+	for i := range 3 {
+		output, err = exec.Command("git", "-C", upstreamPath, "-c", "user.name=test", "-c", "user.email=test@example.com",
+			"commit", "-q", "--allow-empty", "-m", "commit "+strconv.Itoa(i)).CombinedOutput()
+		assert.NoError(t, err, "%s", output)
+		output, err = exec.Command("git", "-C", upstreamPath, "rev-parse", "HEAD").CombinedOutput()
+		assert.NoError(t, err, "%s", output)
+		commits = append(commits, strings.TrimSpace(string(output)))
+	}
+
+	tests := []struct {
+		name          string
+		fetchFailures int
+		expectError   bool
+	}{
+		{name: "FailsOnceThenSucceeds", fetchFailures: 1},
+		{name: "FailsAfterCleanup", fetchFailures: 2, expectError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, err := NewManager(ctx, Config{MirrorRoot: filepath.Join(t.TempDir(), "mirrors"), CloneDepth: 1})
+			assert.NoError(t, err)
+			repo, err := manager.GetOrCreate(ctx, "file://"+upstreamPath)
+			assert.NoError(t, err)
+			assert.NoError(t, repo.Clone(ctx))
+
+			originalRunGit := runGit
+			t.Cleanup(func() { runGit = originalRunGit })
+			fetches := 0
+			var commands []string
+			runGit = func(cmd *exec.Cmd) ([]byte, error) {
+				command := cmd.Args[3]
+				commands = append(commands, command)
+				if command == "fetch" {
+					fetches++
+					if fetches <= tt.fetchFailures {
+						return []byte("fatal: pack has bad object at offset 1234"), errors.New("exit status 128")
+					}
+				}
+				return originalRunGit(cmd)
+			}
+
+			err = repo.EnsureCommit(ctx, commits[0])
+			assert.Equal(t, []string{"fetch", "fsck", "prune", "fetch"}, commands)
+			if tt.expectError {
+				commitErr, ok := errors.AsType[*CommitError](err)
+				assert.True(t, ok, "expected a CommitError, got %v", err)
+				assert.Equal(t, commits[0], commitErr.Ref)
+				assert.False(t, repo.HasCommit(ctx, commits[0]))
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, repo.HasCommit(ctx, commits[0]))
+		})
+	}
+}
+
+func TestRepository_CloneWithFilter(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	tmpDir := t.TempDir()
+
+	// The large blob is only in history, as the mirror's checkout of HEAD fetches the blobs it needs.
+	upstreamPath := filepath.Join(tmpDir, "upstream")
+	large := strings.Repeat("large blob\n", 1000)
+	run := func(args ...string) string {
+		output, err := exec.Command("git", append([]string{"-C", upstreamPath, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...).CombinedOutput()
+		assert.NoError(t, err, "%s", output)
+		return strings.TrimSpace(string(output))
+	}
+	assert.NoError(t, os.MkdirAll(upstreamPath, 0o750))
+	run("init", "-q", "-b", "main")
+	run("config", "uploadpack.allowFilter", "true")
+	assert.NoError(t, os.WriteFile(filepath.Join(upstreamPath, "data.txt"), []byte(large), 0o600))
+	run("add", "data.txt")
+	run("commit", "-q", "-m", "large")
+	largeBlob := run("rev-parse", "HEAD:data.txt")
+	assert.NoError(t, os.WriteFile(filepath.Join(upstreamPath, "data.txt"), []byte("small\n"), 0o600))
+	run("commit", "-q", "-am", "small")
+
+	manager, err := NewManager(ctx, Config{MirrorRoot: filepath.Join(tmpDir, "mirrors"), Filter: "blob:limit=1k"})
+	assert.NoError(t, err)
+	repo, err := manager.GetOrCreate(ctx, "file://"+upstreamPath)
+	assert.NoError(t, err)
+	assert.NoError(t, repo.Clone(ctx))
+	assert.True(t, repo.Filtered())
+
+	// Mirrors found on disk are recognised as partial clones whatever the current filter configuration.
+	unfiltered, err := NewManager(ctx, Config{MirrorRoot: filepath.Join(tmpDir, "mirrors")})
+	assert.NoError(t, err)
+	discovered, err := unfiltered.DiscoverExisting(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(discovered))
+	assert.True(t, discovered[0].Filtered())
+
+	missing := func() string {
+		output, err := exec.Command("git", "-C", repo.Path(), "rev-list", "--objects", "--missing=print", "--all").CombinedOutput()
+		assert.NoError(t, err, "%s", output)
+		return string(output)
+	}
+	assert.Contains(t, missing(), "?"+largeBlob)
+
+	output, err := exec.Command("git", "-C", repo.Path(), "cat-file", "-p", largeBlob).CombinedOutput()
+	assert.NoError(t, err, "%s", output)
+	assert.Equal(t, large, string(output))
+	assert.NotContains(t, missing(), "?"+largeBlob)
+}
+
+func TestNewManager_InvalidFilter(t *testing.T) {
+	_, ctx := logging.Configure(t.Context(), logging.Config{Level: slog.LevelError})
+	_, err := NewManager(ctx, Config{MirrorRoot: t.TempDir(), Filter: "--upload-pack=touch /tmp/pwned"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "filter must be a git filter-spec")
+}
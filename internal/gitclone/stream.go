@@ -0,0 +1,92 @@
+package gitclone
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/errors"
+)
+
+// Archive streams an archive of the tree at ref, in a format supported by "git archive" such as "zip" or "tar", with
+// every path beneath prefix.
+//
+// The archive is not buffered, and the repository is read-locked until the returned reader is closed. If git fails,
+// the error is returned by Read in place of [io.EOF].
+func (r *Repository) Archive(ctx context.Context, ref, format, prefix string) (io.ReadCloser, error) {
+	if strings.HasPrefix(ref, "-") {
+		return nil, errors.Errorf("invalid ref %q", ref)
+	}
+	return r.streamGit(ctx, "archive", "--format="+format, "--prefix="+prefix, ref)
+}
+
+// Show streams the contents of the file at path in the tree at ref, with the same locking and error reporting as
+// [Repository.Archive].
+func (r *Repository) Show(ctx context.Context, ref, path string) (io.ReadCloser, error) {
+	if strings.HasPrefix(ref, "-") {
+		return nil, errors.Errorf("invalid ref %q", ref)
+	}
+	return r.streamGit(ctx, "show", ref+":"+path)
+}
+
+// streamGit starts a git command in the repository under its read lock, returning its output as it is produced.
+func (r *Repository) streamGit(ctx context.Context, args ...string) (io.ReadCloser, error) {
+	r.mu.RLock()
+	// #nosec G204 - r.path is controlled by us and callers ensure args are not options
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", r.path}, args...)...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		r.mu.RUnlock()
+		return nil, errors.Wrapf(err, "git %s", args[0])
+	}
+	if err := cmd.Start(); err != nil {
+		r.mu.RUnlock()
+		return nil, errors.Wrapf(err, "git %s", args[0])
+	}
+	return &gitOutput{ReadCloser: stdout, command: args[0], cmd: cmd, stderr: stderr, unlock: r.mu.RUnlock}, nil
+}
+
+// gitOutput reads the output of a git command started by [Repository.streamGit], reaping the process and releasing the
+// repository's read lock once the output is exhausted or closed.
+type gitOutput struct {
+	io.ReadCloser
+	command string
+	cmd     *exec.Cmd
+	stderr  *bytes.Buffer
+	unlock  func()
+
+	once sync.Once
+	err  error
+}
+
+func (g *gitOutput) Read(p []byte) (int, error) {
+	n, err := g.ReadCloser.Read(p)
+	if errors.Is(err, io.EOF) {
+		if waitErr := g.wait(); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err //nolint:wrapcheck // io.EOF must be returned unwrapped
+}
+
+func (g *gitOutput) Close() error {
+	// Closing the pipe first makes git exit if the output was abandoned part way through.
+	_ = g.ReadCloser.Close()
+	_ = g.wait()
+	return nil
+}
+
+func (g *gitOutput) wait() error {
+	g.once.Do(func() {
+		defer g.unlock()
+		if err := g.cmd.Wait(); err != nil {
+			g.err = errors.Wrapf(err, "git %s failed: %s", g.command, strings.TrimSpace(g.stderr.String()))
+		}
+	})
+	return g.err
+}
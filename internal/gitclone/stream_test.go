@@ -0,0 +1,136 @@
+package gitclone //nolint:testpackage // white-box testing required for unexported fields
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/alecthomas/errors"
+)
+
+func TestRepository_Archive(t *testing.T) {
+	repoPath := filepath.Join(t.TempDir(), "test-repo")
+	assert.NoError(t, os.MkdirAll(filepath.Join(repoPath, "sub"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(repoPath, "go.mod"), []byte("module example.com/test\n"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(repoPath, "sub", "file.txt"), []byte("hello"), 0o644))
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+		{"tag", "v1.0.0"},
+	} {
+		output, err := exec.Command("git", append([]string{"-C", repoPath}, args...)...).CombinedOutput()
+		assert.NoError(t, err, string(output))
+	}
+	repo := &Repository{state: StateReady, path: repoPath}
+
+	expected := map[string]string{
+		"example.com/test@v1.0.0/go.mod":       "module example.com/test\n",
+		"example.com/test@v1.0.0/sub/file.txt": "hello",
+	}
+
+	t.Run("Zip", func(t *testing.T) {
+		data := readArchive(t, repo, "v1.0.0", "zip")
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		assert.NoError(t, err)
+		files := map[string]string{}
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			assert.NoError(t, err)
+			content, err := io.ReadAll(rc)
+			assert.NoError(t, err)
+			assert.NoError(t, rc.Close())
+			files[f.Name] = string(content)
+		}
+		assert.Equal(t, expected, files)
+	})
+
+	t.Run("Tar", func(t *testing.T) {
+		tr := tar.NewReader(bytes.NewReader(readArchive(t, repo, "v1.0.0", "tar")))
+		files := map[string]string{}
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			assert.NoError(t, err)
+			if hdr.Typeflag != tar.TypeReg {
+				continue
+			}
+			content, err := io.ReadAll(tr)
+			assert.NoError(t, err)
+			files[hdr.Name] = string(content)
+		}
+		assert.Equal(t, expected, files)
+	})
+
+	t.Run("UnknownRef", func(t *testing.T) {
+		archive, err := repo.Archive(t.Context(), "v9.9.9", "zip", "")
+		assert.NoError(t, err)
+		_, err = io.ReadAll(archive)
+		assert.Error(t, err)
+		assert.NoError(t, archive.Close())
+	})
+
+	t.Run("OptionAsRef", func(t *testing.T) {
+		_, err := repo.Archive(t.Context(), "--output=/tmp/x", "zip", "")
+		assert.Error(t, err)
+	})
+
+	t.Run("ReleasesLockWhenAbandoned", func(t *testing.T) {
+		archive, err := repo.Archive(t.Context(), "v1.0.0", "tar", "")
+		assert.NoError(t, err)
+		_, err = archive.Read(make([]byte, 1))
+		assert.NoError(t, err)
+		assert.NoError(t, archive.Close())
+		assert.True(t, repo.mu.TryLock(), "read lock was not released")
+		repo.mu.Unlock()
+	})
+}
+
+func TestRepository_Show(t *testing.T) {
+	repoPath := filepath.Join(t.TempDir(), "test-repo")
+	assert.NoError(t, os.MkdirAll(repoPath, 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(repoPath, "go.mod"), []byte("module example.com/test\n"), 0o644))
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+	} {
+		output, err := exec.Command("git", append([]string{"-C", repoPath}, args...)...).CombinedOutput()
+		assert.NoError(t, err, string(output))
+	}
+	repo := &Repository{state: StateReady, path: repoPath}
+
+	show, err := repo.Show(t.Context(), "HEAD", "go.mod")
+	assert.NoError(t, err)
+	content, err := io.ReadAll(show)
+	assert.NoError(t, err)
+	assert.NoError(t, show.Close())
+	assert.Equal(t, "module example.com/test\n", string(content))
+
+	show, err = repo.Show(t.Context(), "HEAD", "missing.txt")
+	assert.NoError(t, err)
+	_, err = io.ReadAll(show)
+	assert.Error(t, err)
+	assert.NoError(t, show.Close())
+}
+
+func readArchive(t *testing.T, repo *Repository, ref, format string) []byte {
+	t.Helper()
+	archive, err := repo.Archive(t.Context(), ref, format, "example.com/test@v1.0.0/")
+	assert.NoError(t, err)
+	data, err := io.ReadAll(archive)
+	assert.NoError(t, err)
+	assert.NoError(t, archive.Close())
+	return data
+}
@@ -0,0 +1,65 @@
+package httputil
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/alecthomas/errors"
+)
+
+// An Authorizer decides whether a request may access administrative and diagnostic endpoints.
+type Authorizer interface {
+	// Authorize returns an error, ideally an [HTTPResponder], if the request is not authorized.
+	Authorize(r *http.Request) error
+}
+
+// TokenAuthorizer authorizes requests bearing one of a fixed set of tokens in an "Authorization: Bearer" header.
+//
+// If no tokens are configured all requests are authorized.
+type TokenAuthorizer struct {
+	tokens []string
+}
+
+var _ Authorizer = (*TokenAuthorizer)(nil)
+
+// NewTokenAuthorizer creates a new [TokenAuthorizer].
+func NewTokenAuthorizer(tokens []string) *TokenAuthorizer {
+	return &TokenAuthorizer{tokens: tokens}
+}
+
+func (t *TokenAuthorizer) Authorize(r *http.Request) error {
+	if len(t.tokens) == 0 {
+		return nil
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return Errorf(http.StatusUnauthorized, "missing bearer token")
+	}
+	for _, candidate := range t.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(candidate)) == 1 {
+			return nil
+		}
+	}
+	return Errorf(http.StatusForbidden, "invalid bearer token")
+}
+
+// RequireAuthorization wraps next so that it is only called for requests authorized by a.
+//
+// A nil Authorizer authorizes all requests.
+func RequireAuthorization(a Authorizer, next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := a.Authorize(r); err != nil {
+			if responder, ok := errors.AsType[HTTPResponder](err); ok {
+				responder.WriteHTTP(w, r)
+			} else {
+				ErrorResponse(w, r, http.StatusForbidden, err.Error())
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
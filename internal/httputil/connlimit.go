@@ -0,0 +1,133 @@
+package httputil
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecthomas/errors"
+)
+
+// healthConnections is the number of connections accepted beyond the limit so that health checks can still be
+// served while the server is saturated.
+const healthConnections = 8
+
+// ConnectionConfig limits the connections held open by clients.
+type ConnectionConfig struct {
+	MaxConnections int           `hcl:"max-connections,optional" help:"Maximum simultaneous client connections. Beyond this, connections are only served health checks. 0 disables the limit."`
+	HeaderTimeout  time.Duration `hcl:"header-timeout,optional" help:"Time allowed for clients to send request headers, after which the connection is closed." default:"10s"`
+	IdleTimeout    time.Duration `hcl:"idle-timeout,optional" help:"How long idle keep-alive connections are held open." default:"2m"`
+}
+
+// Validate the configuration.
+func (c *ConnectionConfig) Validate() error {
+	var errs []error
+	if c.MaxConnections < 0 {
+		errs = append(errs, errors.New("max-connections must not be negative"))
+	}
+	if c.HeaderTimeout <= 0 {
+		errs = append(errs, errors.New("header-timeout must be positive"))
+	}
+	if c.IdleTimeout < 0 {
+		errs = append(errs, errors.New("idle-timeout must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// ConnectionLimiter bounds the number of simultaneous client connections, protecting the server from clients that
+// hold connections open, such as slowloris attacks, which trickle request headers.
+//
+// Connections beyond the limit are not refused outright, so that health checks stay responsive when the server is
+// saturated. Instead a few more are accepted, which are only served health checks and are closed after a single
+// request. Connections beyond those are closed immediately.
+type ConnectionLimiter struct {
+	config   ConnectionConfig
+	active   atomic.Int64
+	overflow atomic.Int64
+}
+
+// NewConnectionLimiter creates a [ConnectionLimiter].
+func NewConnectionLimiter(config ConnectionConfig) *ConnectionLimiter {
+	return &ConnectionLimiter{config: config}
+}
+
+// Listener returns inner wrapped to enforce the limit. If the limit is disabled inner is returned unchanged.
+func (l *ConnectionLimiter) Listener(inner net.Listener) net.Listener {
+	if l.config.MaxConnections <= 0 {
+		return inner
+	}
+	return &limitListener{Listener: inner, limiter: l}
+}
+
+// ConnContext returns ctx annotated with whether c was accepted beyond the limit. It must be called from the
+// server's ConnContext for [ConnectionLimiter.Middleware] to take effect.
+func (l *ConnectionLimiter) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if lc, ok := c.(*limitedConn); ok && lc.overflow {
+		return context.WithValue(ctx, overflowKey{}, true)
+	}
+	return ctx
+}
+
+// Middleware returns next wrapped to reject requests other than to healthPaths on connections accepted beyond the
+// limit, with 503 Service Unavailable. If the limit is disabled next is returned unchanged.
+func (l *ConnectionLimiter) Middleware(next http.Handler, healthPaths ...string) http.Handler {
+	if l.config.MaxConnections <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if overflow, _ := r.Context().Value(overflowKey{}).(bool); !overflow { //nolint:errcheck
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Release the connection as soon as the request is served.
+		w.Header().Set("Connection", "close")
+		if slices.Contains(healthPaths, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Too many connections", http.StatusServiceUnavailable)
+	})
+}
+
+type overflowKey struct{}
+
+type limitListener struct {
+	net.Listener
+	limiter *ConnectionLimiter
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if l.limiter.active.Add(1) <= int64(l.limiter.config.MaxConnections) {
+			return &limitedConn{Conn: conn, counter: &l.limiter.active}, nil
+		}
+		l.limiter.active.Add(-1)
+		if l.limiter.overflow.Add(1) <= healthConnections {
+			return &limitedConn{Conn: conn, counter: &l.limiter.overflow, overflow: true}, nil
+		}
+		l.limiter.overflow.Add(-1)
+		_ = conn.Close() //nolint:errcheck
+	}
+}
+
+// limitedConn releases its slot in the limit when closed.
+type limitedConn struct {
+	net.Conn
+	counter  *atomic.Int64
+	overflow bool
+	once     sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	c.once.Do(func() { c.counter.Add(-1) })
+	return errors.WithStack(c.Conn.Close())
+}
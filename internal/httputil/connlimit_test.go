@@ -0,0 +1,80 @@
+package httputil_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/httputil"
+)
+
+func TestConnectionLimiter(t *testing.T) {
+	limiter := httputil.NewConnectionLimiter(httputil.ConnectionConfig{MaxConnections: 2, HeaderTimeout: time.Minute})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /_liveness", func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write([]byte("OK")) })
+	mux.HandleFunc("GET /data", func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write([]byte("data")) })
+	server := httptest.NewUnstartedServer(limiter.Middleware(mux, "/_liveness"))
+	server.Listener = limiter.Listener(server.Listener)
+	server.Config.ConnContext = limiter.ConnContext
+	server.Start()
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	// get returns the status of a request to path, or 0 if the connection was closed.
+	get := func(path string) int {
+		t.Helper()
+		resp, err := client.Get(server.URL + path) //nolint:noctx
+		if err != nil {
+			return 0
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode
+	}
+	// slowClient opens a connection and trickles a partial request, holding the connection open.
+	slowClient := func() net.Conn {
+		t.Helper()
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		_, err = conn.Write([]byte("GET /data HTTP/1.1\r\nHost: example.com\r\n"))
+		assert.NoError(t, err)
+		return conn
+	}
+
+	assert.Equal(t, http.StatusOK, get("/data"))
+
+	first := slowClient()
+	slowClient()
+
+	// Saturated: health checks are still served, but other requests are rejected.
+	assert.Equal(t, http.StatusOK, get("/_liveness"))
+	assert.Equal(t, http.StatusServiceUnavailable, get("/data"))
+
+	// Once the connections reserved for health checks are also held, connections are closed immediately.
+	for range 8 {
+		slowClient()
+	}
+	conn := slowClient()
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err := conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, os.ErrDeadlineExceeded), "connection should have been closed")
+
+	// Legitimate requests proceed when a connection is released.
+	assert.NoError(t, first.Close())
+	status := 0
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if status = get("/data"); status == http.StatusOK {
+			break
+		}
+	}
+	assert.Equal(t, http.StatusOK, status)
+}
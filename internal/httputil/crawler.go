@@ -0,0 +1,70 @@
+package httputil
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/block/cachew/internal/logging"
+)
+
+// robotsTxt disallows all crawling, as every path either proxies an upstream or is administrative.
+const robotsTxt = "User-agent: *\nDisallow: /\n"
+
+// RobotsHandler serves a robots.txt asking crawlers not to index any path.
+func RobotsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(robotsTxt)) //nolint:errcheck
+	})
+}
+
+// CrawlerConfig blocks clients by User-Agent.
+type CrawlerConfig struct {
+	BlockUserAgents []string `hcl:"block-user-agents,optional" help:"Case-insensitive substrings of User-Agents, eg. \"Googlebot\", that are rejected with 403 Forbidden on all paths other than health checks and robots.txt. If empty, no clients are blocked."`
+}
+
+// CrawlerBlocker rejects requests from crawlers and other unwanted clients, identified by their User-Agent, so that
+// they cannot discover and amplify requests to upstreams through the predictable paths exposed by strategies.
+//
+// Crawlers that honour robots.txt never reach it, so it is a backstop for those that do not.
+type CrawlerBlocker struct {
+	userAgents []string
+}
+
+// NewCrawlerBlocker creates a [CrawlerBlocker].
+func NewCrawlerBlocker(config CrawlerConfig) *CrawlerBlocker {
+	userAgents := make([]string, 0, len(config.BlockUserAgents))
+	for _, ua := range config.BlockUserAgents {
+		if ua = strings.TrimSpace(ua); ua != "" {
+			userAgents = append(userAgents, strings.ToLower(ua))
+		}
+	}
+	return &CrawlerBlocker{userAgents: userAgents}
+}
+
+// blocked returns true if userAgent matches one of the blocked User-Agents.
+func (b *CrawlerBlocker) blocked(userAgent string) bool {
+	userAgent = strings.ToLower(userAgent)
+	return slices.ContainsFunc(b.userAgents, func(blocked string) bool {
+		return strings.Contains(userAgent, blocked)
+	})
+}
+
+// Middleware returns next wrapped to reject requests from blocked User-Agents with 403 Forbidden, other than to
+// allowedPaths. If no User-Agents are blocked next is returned unchanged.
+func (b *CrawlerBlocker) Middleware(next http.Handler, allowedPaths ...string) http.Handler {
+	if len(b.userAgents) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(allowedPaths, r.URL.Path) || !b.blocked(r.Header.Get("User-Agent")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		logging.FromContext(r.Context()).DebugContext(r.Context(), "Blocked crawler",
+			slog.String("user_agent", r.Header.Get("User-Agent")), slog.String("path", r.URL.Path))
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	})
+}
@@ -0,0 +1,67 @@
+package httputil_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/httputil"
+	"github.com/block/cachew/internal/logging"
+)
+
+func TestCrawlerBlocker(t *testing.T) {
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+	mux := http.NewServeMux()
+	mux.Handle("GET /robots.txt", httputil.RobotsHandler())
+	mux.HandleFunc("GET /_liveness", func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write([]byte("OK")) })
+	mux.HandleFunc("GET /github.com/org/repo/info/refs", func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write([]byte("refs")) })
+	blocker := httputil.NewCrawlerBlocker(httputil.CrawlerConfig{BlockUserAgents: []string{"Googlebot", " ", "BadBot/"}})
+	handler := blocker.Middleware(mux, "/_liveness", "/robots.txt")
+
+	tests := []struct {
+		name       string
+		path       string
+		userAgent  string
+		wantStatus int
+	}{
+		{"AllowedClient", "/github.com/org/repo/info/refs", "git/2.43.0", http.StatusOK},
+		{"NoUserAgent", "/github.com/org/repo/info/refs", "", http.StatusOK},
+		{"BlockedCrawler", "/github.com/org/repo/info/refs", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", http.StatusForbidden},
+		{"CaseInsensitive", "/github.com/org/repo/info/refs", "badbot/1.0", http.StatusForbidden},
+		{"HealthCheck", "/_liveness", "Googlebot/2.1", http.StatusOK},
+		{"Robots", "/robots.txt", "Googlebot/2.1", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequestWithContext(ctx, http.MethodGet, tt.path, nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+
+	t.Run("RobotsPolicy", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/robots.txt", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+		body, err := io.ReadAll(w.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "User-agent: *\nDisallow: /\n", string(body))
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		disabled := httputil.NewCrawlerBlocker(httputil.CrawlerConfig{}).Middleware(mux)
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/github.com/org/repo/info/refs", nil)
+		req.Header.Set("User-Agent", "Googlebot/2.1")
+		w := httptest.NewRecorder()
+		disabled.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
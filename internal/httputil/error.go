@@ -3,10 +3,12 @@ package httputil
 
 import (
 	"fmt"
+	"log/slog"
 	"net/http"
 
 	"github.com/alecthomas/errors"
 
+	"github.com/block/cachew/internal/events"
 	"github.com/block/cachew/internal/logging"
 )
 
@@ -14,6 +16,7 @@ import (
 func ErrorResponse(w http.ResponseWriter, r *http.Request, status int, msg string, args ...any) {
 	logger := logging.FromContext(r.Context()).With("url", r.URL, "status", status)
 	logger.ErrorContext(r.Context(), msg, args...)
+	events.Publish(r.Context(), events.Error, slog.Int("status", status), slog.String("url", r.URL.String()), slog.String("message", msg))
 	http.Error(w, msg, status)
 }
 
@@ -0,0 +1,73 @@
+package httputil
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// PathConfig controls how requests for unclean paths are handled.
+type PathConfig struct {
+	Redirect bool `hcl:"redirect,optional" help:"Redirect requests for paths with duplicate slashes or \".\" and \"..\" segments to the clean path with 307 Temporary Redirect, rather than serving them in place."`
+}
+
+// PathNormalizer rewrites request paths containing duplicate slashes or "." and ".." segments to their clean form,
+// so that equivalent paths reach the same strategy route and cache entry.
+//
+// Go's [http.ServeMux] redirects such requests to the clean path instead, which costs a round trip and is not
+// followed by every client, eg. git only follows redirects of its initial request.
+type PathNormalizer struct {
+	redirect bool
+}
+
+// NewPathNormalizer creates a [PathNormalizer].
+func NewPathNormalizer(config PathConfig) *PathNormalizer {
+	return &PathNormalizer{redirect: config.Redirect}
+}
+
+// Middleware returns next wrapped to serve requests for unclean paths as if they were for the clean path. If
+// configured to redirect, next is returned unchanged, leaving [http.ServeMux] to redirect them.
+//
+// Only the escaped path is cleaned, so escaped slashes ("%2F") within a segment, which some upstreams treat as
+// significant, are never collapsed. A trailing slash is preserved, as it may select a different resource.
+func (n *PathNormalizer) Middleware(next http.Handler) http.Handler {
+	if n.redirect {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		escaped := r.URL.EscapedPath()
+		// Asterisk-form ("OPTIONS *") and authority-form (CONNECT) targets have no path to clean.
+		if !strings.HasPrefix(escaped, "/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cleaned := cleanPath(escaped)
+		if cleaned == escaped {
+			next.ServeHTTP(w, r)
+			return
+		}
+		unescaped, err := url.PathUnescape(cleaned)
+		if err != nil {
+			// The escaped path was parsed from the request, so cleaning it cannot make it invalid.
+			next.ServeHTTP(w, r)
+			return
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = unescaped
+		r2.URL.RawPath = cleaned
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// cleanPath returns the canonical form of the escaped path p, as [http.ServeMux] would redirect to.
+func cleanPath(p string) string {
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
@@ -0,0 +1,82 @@
+package httputil_test
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/httputil"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy"
+	"github.com/block/cachew/internal/strategy/handler"
+)
+
+func TestPathNormalizer(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	var fetches atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		_, _ = w.Write([]byte("content of " + r.URL.EscapedPath())) //nolint:errcheck
+	}))
+	defer upstream.Close()
+	u, err := url.Parse(upstream.URL)
+	assert.NoError(t, err)
+	prefix := "/" + u.Host
+
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer memCache.Close()
+	mux := http.NewServeMux()
+	_, err = strategy.NewHost(ctx, strategy.HostConfig{Target: upstream.URL}, memCache, mux)
+	assert.NoError(t, err)
+	normalizing := httputil.NewPathNormalizer(httputil.PathConfig{}).Middleware(mux)
+
+	get := func(h http.Handler, path string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get(normalizing, prefix+"/a/b")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, handler.CacheMiss, w.Header().Get("X-Cache"))
+	assert.Equal(t, "content of /a/b", w.Body.String())
+
+	tests := []struct {
+		name        string
+		path        string
+		expectBody  string
+		expectCache string
+	}{
+		{"DuplicateSlashes", "/" + prefix + "//a//b", "content of /a/b", handler.CacheHit},
+		{"DotSegments", prefix + "/./a/c/../b", "content of /a/b", handler.CacheHit},
+		{"TrailingSlashPreserved", prefix + "//a/b/", "content of /a/b/", handler.CacheMiss},
+		{"EscapedSlashesPreserved", prefix + "/a%2F%2Fb", "content of /a//b", handler.CacheMiss},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(normalizing, tt.path)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.expectCache, w.Header().Get("X-Cache"))
+			assert.Equal(t, tt.expectBody, w.Body.String())
+		})
+	}
+	assert.Equal(t, int32(3), fetches.Load())
+
+	t.Run("Redirect", func(t *testing.T) {
+		redirecting := httputil.NewPathNormalizer(httputil.PathConfig{Redirect: true}).Middleware(mux)
+		w := get(redirecting, "/"+prefix+"//a//b")
+		assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		assert.True(t, strings.HasSuffix(w.Header().Get("Location"), prefix+"/a/b"), w.Header().Get("Location"))
+	})
+}
@@ -0,0 +1,118 @@
+package httputil
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/alecthomas/errors"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ProxyFunc selects the proxy for a request, as in [http.Transport.Proxy]. A nil URL connects directly.
+type ProxyFunc func(req *http.Request) (*url.URL, error)
+
+// ProxyConfig selects the HTTP proxy used for upstream requests.
+//
+// If URL is empty, the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables are used, and NoProxy is ignored.
+type ProxyConfig struct {
+	URL     string   `hcl:"url,optional" help:"URL of the HTTP proxy for upstream requests. Defaults to the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables."`
+	NoProxy []string `hcl:"no-proxy,optional" help:"Hosts to connect to directly rather than through the proxy, as host names, domains (eg. .example.com), IP addresses or CIDRs, optionally with a port."`
+}
+
+// Validate the configuration.
+func (c *ProxyConfig) Validate() error {
+	if c.URL == "" {
+		if len(c.NoProxy) > 0 {
+			return errors.New("no-proxy requires a proxy url")
+		}
+		return nil
+	}
+	if u, err := url.Parse(c.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		return errors.Errorf("proxy url must be an absolute URL, got %q", c.URL)
+	}
+	return nil
+}
+
+// ProxyFunc returns the function selecting the proxy for each request.
+func (c *ProxyConfig) ProxyFunc() ProxyFunc {
+	config := httpproxy.FromEnvironment()
+	if c.URL != "" {
+		config = &httpproxy.Config{HTTPProxy: c.URL, HTTPSProxy: c.URL, NoProxy: strings.Join(c.NoProxy, ",")}
+	}
+	proxyForURL := config.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return errors.WithStack2(proxyForURL(req.URL))
+	}
+}
+
+//nolint:gochecknoglobals
+var (
+	defaultProxy         atomic.Pointer[ProxyFunc]
+	defaultProxyExplicit atomic.Bool
+)
+
+// SetDefaultProxy configures the proxy returned by [Proxy] for all upstream requests that are not overridden with
+// [ProxyTransport].
+func SetDefaultProxy(config ProxyConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	proxy := config.ProxyFunc()
+	defaultProxy.Store(&proxy)
+	defaultProxyExplicit.Store(config.URL != "")
+	return nil
+}
+
+type proxyOverrideKey struct{}
+
+// Proxy selects the proxy for an upstream request, for use as [http.Transport.Proxy].
+//
+// The proxy is that of the [ProxyTransport] the request was sent through, otherwise the one configured with
+// [SetDefaultProxy], otherwise from the environment.
+func Proxy(req *http.Request) (*url.URL, error) {
+	if proxy, ok := req.Context().Value(proxyOverrideKey{}).(ProxyFunc); ok {
+		return proxy(req)
+	}
+	if proxy := defaultProxy.Load(); proxy != nil {
+		return (*proxy)(req)
+	}
+	return errors.WithStack2(http.ProxyFromEnvironment(req))
+}
+
+// ProxyForURL returns the proxy that [Proxy] selects for an upstream URL, for clients such as git that are not
+// configured with a [http.Transport].
+//
+// explicit is true if the proxy, or the lack of one, was configured with [SetDefaultProxy] rather than taken from the
+// environment, which such clients may already honor themselves.
+func ProxyForURL(ctx context.Context, upstreamURL string) (proxy *url.URL, explicit bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, upstreamURL, nil)
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	proxy, err = Proxy(req)
+	return proxy, defaultProxyExplicit.Load(), err
+}
+
+// ProxyTransport sends requests through next with the proxy selected by config rather than the default.
+//
+// The override only takes effect if the underlying [http.Transport] selects proxies with [Proxy], as the default
+// transport of cachewd does. If config has no URL, next is returned unchanged.
+func ProxyTransport(next http.RoundTripper, config ProxyConfig) http.RoundTripper {
+	if config.URL == "" {
+		return next
+	}
+	return &proxyTransport{next: next, proxy: config.ProxyFunc()}
+}
+
+type proxyTransport struct {
+	next  http.RoundTripper
+	proxy ProxyFunc
+}
+
+func (p *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.WithContext(context.WithValue(req.Context(), proxyOverrideKey{}, p.proxy))
+	return errors.WithStack2(p.next.RoundTrip(req))
+}
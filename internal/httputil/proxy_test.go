@@ -0,0 +1,105 @@
+package httputil_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/httputil"
+)
+
+func TestProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "proxy:"+r.URL.Host)
+	}))
+	defer proxy.Close()
+	direct := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "direct:"+r.Host)
+	}))
+	defer direct.Close()
+
+	assert.NoError(t, httputil.SetDefaultProxy(httputil.ProxyConfig{URL: proxy.URL, NoProxy: []string{".internal.example.com"}}))
+	t.Cleanup(func() { _ = httputil.SetDefaultProxy(httputil.ProxyConfig{}) })
+
+	// Loopback hosts are never proxied, so example hosts are resolved to the direct server when dialed.
+	transport := http.DefaultTransport.(*http.Transport).Clone() //nolint:errcheck
+	transport.Proxy = httputil.Proxy
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if strings.Contains(addr, "example.com") {
+			addr = direct.Listener.Addr().String()
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+	defer transport.CloseIdleConnections()
+
+	tests := []struct {
+		name     string
+		override httputil.ProxyConfig
+		url      string
+		expected string
+	}{
+		{name: "External", url: "http://external.example.com/", expected: "proxy:external.example.com"},
+		{name: "NoProxy", url: "http://repo.internal.example.com/", expected: "direct:repo.internal.example.com"},
+		{name: "OverrideBypass",
+			override: httputil.ProxyConfig{URL: proxy.URL, NoProxy: []string{"external.example.com"}},
+			url:      "http://external.example.com/", expected: "direct:external.example.com"},
+		{name: "OverrideProxy",
+			override: httputil.ProxyConfig{URL: proxy.URL},
+			url:      "http://repo.internal.example.com/", expected: "proxy:repo.internal.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{Transport: httputil.ProxyTransport(transport, tt.override)}
+			req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, tt.url, nil)
+			assert.NoError(t, err)
+			resp, err := client.Do(req)
+			assert.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, string(body))
+		})
+	}
+
+	t.Run("ForURL", func(t *testing.T) {
+		proxyURL, explicit, err := httputil.ProxyForURL(t.Context(), "https://external.example.com/repo.git")
+		assert.NoError(t, err)
+		assert.True(t, explicit)
+		assert.Equal(t, proxy.URL, proxyURL.String())
+
+		proxyURL, explicit, err = httputil.ProxyForURL(t.Context(), "https://repo.internal.example.com/repo.git")
+		assert.NoError(t, err)
+		assert.True(t, explicit)
+		assert.Zero(t, proxyURL)
+	})
+}
+
+func TestProxyConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config httputil.ProxyConfig
+		err    string
+	}{
+		{name: "Environment"},
+		{name: "Valid", config: httputil.ProxyConfig{URL: "http://proxy.example.com:3128", NoProxy: []string{"10.0.0.0/8"}}},
+		{name: "NotURL", config: httputil.ProxyConfig{URL: "proxy.example.com:3128"}, err: "proxy url must be an absolute URL"},
+		{name: "NoProxyWithoutURL", config: httputil.ProxyConfig{NoProxy: []string{"example.com"}}, err: "no-proxy requires a proxy url"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+			}
+		})
+	}
+}
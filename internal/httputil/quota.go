@@ -0,0 +1,155 @@
+package httputil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/logging"
+)
+
+// QuotaConfig limits the bytes served to each client.
+type QuotaConfig struct {
+	Bytes  int64         `hcl:"bytes,optional" help:"Bytes each client may be served per window before its requests are rejected with 429 Too Many Requests. 0 disables the quota."`
+	Window time.Duration `hcl:"window,optional" help:"Length of the quota window." default:"1h"`
+	// MaxClients bounds the memory used to track clients. Clients beyond it share a single quota until the window
+	// ends.
+	MaxClients int `hcl:"max-clients,optional" help:"Maximum number of clients tracked per window, beyond which new clients share a single quota." default:"100000"`
+}
+
+// Validate the configuration.
+func (c *QuotaConfig) Validate() error {
+	var errs []error
+	if c.Bytes < 0 {
+		errs = append(errs, errors.New("bytes must not be negative"))
+	}
+	if c.Bytes > 0 && c.Window <= 0 {
+		errs = append(errs, errors.New("window must be positive"))
+	}
+	if c.MaxClients < 0 {
+		errs = append(errs, errors.New("max-clients must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// ByteQuota counts the bytes served to each client over fixed windows, rejecting requests from clients that have
+// exhausted their quota until the window ends.
+//
+// Clients are identified by their bearer token if the authorizer accepts it, and otherwise by their IP address, so that
+// a client cannot escape its quota by presenting a new token with each request. Counting is
+// approximate: a request is admitted if the quota is not yet exhausted when it starts, and is never cut short, so a
+// client may exceed its quota by up to the size of its concurrent responses.
+type ByteQuota struct {
+	config     QuotaConfig
+	authorizer Authorizer
+
+	mu      sync.Mutex
+	window  time.Time                // Start of the current window.
+	clients map[string]*atomic.Int64 // Bytes served in the current window, by client.
+}
+
+// NewByteQuota creates a [ByteQuota]. Bearer tokens are only used to identify clients if authorizer accepts them, so
+// with a nil authorizer all clients are identified by their IP address.
+func NewByteQuota(config QuotaConfig, authorizer Authorizer) *ByteQuota {
+	if config.MaxClients == 0 {
+		config.MaxClients = 100000
+	}
+	return &ByteQuota{config: config, authorizer: authorizer, clients: map[string]*atomic.Int64{}}
+}
+
+// Middleware returns next wrapped to enforce the quota. If the quota is disabled next is returned unchanged.
+func (q *ByteQuota) Middleware(next http.Handler) http.Handler {
+	if q.config.Bytes <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := q.client(r)
+		usage, reset := q.usage(client)
+		if usage.Load() >= q.config.Bytes {
+			logging.FromContext(r.Context()).WarnContext(r.Context(), "Client exceeded byte quota",
+				slog.String("client", client), slog.Int64("quota", q.config.Bytes))
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(reset).Seconds()))))
+			http.Error(w, "Byte quota exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(&countingResponseWriter{ResponseWriter: w, count: usage}, r)
+	})
+}
+
+// usage returns the counter of bytes served to client in the current window, and when the window ends.
+func (q *ByteQuota) usage(client string) (*atomic.Int64, time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if window := time.Now().Truncate(q.config.Window); !window.Equal(q.window) {
+		q.window = window
+		clear(q.clients)
+	}
+	usage, ok := q.clients[client]
+	if !ok && len(q.clients) >= q.config.MaxClients {
+		client = overflowClient
+		usage, ok = q.clients[client]
+	}
+	if !ok {
+		usage = &atomic.Int64{}
+		q.clients[client] = usage
+	}
+	return usage, q.window.Add(q.config.Window)
+}
+
+// overflowClient is the client that clients beyond MaxClients are counted as.
+const overflowClient = "overflow"
+
+// client identifies the client of a request. Tokens are hashed so that they are not retained or logged.
+func (q *ByteQuota) client(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" && q.verified(r) {
+		sum := sha256.Sum256([]byte(token))
+		return "token:" + hex.EncodeToString(sum[:8])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// verified returns true if the authorizer accepts the bearer token of r, and would not accept r without it. Otherwise
+// the token identifies nothing, eg. if no tokens are configured, and could be changed freely.
+func (q *ByteQuota) verified(r *http.Request) bool {
+	if q.authorizer == nil || q.authorizer.Authorize(r) != nil {
+		return false
+	}
+	anonymous := r.Clone(r.Context())
+	anonymous.Header.Del("Authorization")
+	return q.authorizer.Authorize(anonymous) != nil
+}
+
+// countingResponseWriter adds the bytes written to a response to a counter.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	count *atomic.Int64
+}
+
+func (c *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.count.Add(int64(n))
+	return n, errors.WithStack(err)
+}
+
+func (c *countingResponseWriter) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows [http.ResponseController] to reach the underlying writer.
+func (c *countingResponseWriter) Unwrap() http.ResponseWriter { return c.ResponseWriter }
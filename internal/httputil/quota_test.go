@@ -0,0 +1,121 @@
+package httputil_test
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/httputil"
+	"github.com/block/cachew/internal/logging"
+)
+
+func TestByteQuota(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	authorizer := httputil.NewTokenAuthorizer([]string{"secret"})
+	handler := httputil.NewByteQuota(httputil.QuotaConfig{Bytes: 250, Window: time.Hour}, authorizer).
+		Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(body))
+		}))
+
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+	get := func(remoteAddr, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// The quota is exhausted by the third response, so only the fourth request is rejected.
+	for range 3 {
+		w := get("10.0.0.1:1234", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, body, w.Body.String())
+	}
+	w := get("10.0.0.1:5678", "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEqual(t, "", w.Header().Get("Retry-After"))
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		token        string
+		expectStatus int
+	}{
+		{name: "OtherAddress", remoteAddr: "10.0.0.2:1234", expectStatus: http.StatusOK},
+		{name: "TokenFromThrottledAddress", remoteAddr: "10.0.0.1:1234", token: "secret", expectStatus: http.StatusOK},
+		{name: "ThrottledAddress", remoteAddr: "10.0.0.1:1234", expectStatus: http.StatusTooManyRequests},
+		{name: "UnknownTokenFromThrottledAddress", remoteAddr: "10.0.0.1:1234", token: "rotated", expectStatus: http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(tt.remoteAddr, tt.token)
+			assert.Equal(t, tt.expectStatus, w.Code)
+		})
+	}
+}
+
+func TestByteQuotaRotatingTokens(t *testing.T) {
+	tests := []struct {
+		name       string
+		authorizer httputil.Authorizer
+	}{
+		{name: "NoAuthorizer"},
+		{name: "NoTokensConfigured", authorizer: httputil.NewTokenAuthorizer(nil)},
+		{name: "UnknownTokens", authorizer: httputil.NewTokenAuthorizer([]string{"secret"})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := httputil.NewByteQuota(httputil.QuotaConfig{Bytes: 250, Window: time.Hour}, tt.authorizer).
+				Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					_, _ = w.Write([]byte(strings.Repeat("x", 100)))
+				}))
+			ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+
+			// Tokens that the authorizer does not verify are ignored, so the client is throttled by its address.
+			codes := []int{}
+			for i := range 4 {
+				req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+				req.RemoteAddr = "10.0.0.1:1234"
+				req.Header.Set("Authorization", "Bearer token-"+strconv.Itoa(i))
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+				codes = append(codes, w.Code)
+			}
+			assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes)
+		})
+	}
+}
+
+func TestByteQuotaMaxClients(t *testing.T) {
+	handler := httputil.NewByteQuota(httputil.QuotaConfig{Bytes: 150, Window: time.Hour, MaxClients: 1}, nil).
+		Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(strings.Repeat("x", 100)))
+		}))
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+	get := func(remoteAddr string) int {
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, get("10.0.0.1:1234"))
+	// Clients beyond the first share a quota, which the second and third exhaust.
+	assert.Equal(t, http.StatusOK, get("10.0.0.2:1234"))
+	assert.Equal(t, http.StatusOK, get("10.0.0.3:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, get("10.0.0.4:1234"))
+	// The tracked client keeps its own quota.
+	assert.Equal(t, http.StatusOK, get("10.0.0.1:1234"))
+}
@@ -0,0 +1,37 @@
+package httputil
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// sensitiveHeaderFragments are substrings of header names whose values should never be logged.
+var sensitiveHeaderFragments = []string{"authorization", "cookie", "token", "secret", "password", "key", "api"}
+
+// IsSensitiveHeader returns true if the named header likely carries a credential.
+func IsSensitiveHeader(name string) bool {
+	name = strings.ToLower(name)
+	return slices.ContainsFunc(sensitiveHeaderFragments, func(fragment string) bool {
+		return strings.Contains(name, fragment)
+	})
+}
+
+// RedactedHeaders logs headers with the values of sensitive headers redacted.
+type RedactedHeaders http.Header
+
+var _ slog.LogValuer = RedactedHeaders(nil)
+
+func (r RedactedHeaders) LogValue() slog.Value {
+	attrs := make([]slog.Attr, 0, len(r))
+	for name, values := range r {
+		value := strings.Join(values, ", ")
+		if IsSensitiveHeader(name) {
+			value = "REDACTED"
+		}
+		attrs = append(attrs, slog.String(name, value))
+	}
+	slices.SortFunc(attrs, func(a, b slog.Attr) int { return strings.Compare(a.Key, b.Key) })
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,26 @@
+package httputil_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/httputil"
+)
+
+func TestRedactedHeaders(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewTextHandler(buf, nil))
+	logger.Info("headers", slog.Any("headers", httputil.RedactedHeaders(http.Header{
+		"User-Agent":    {"cachew"},
+		"Authorization": {"Bearer hunter2"},
+		"X-Mirror-Key":  {"hunter2"},
+	})))
+	assert.Contains(t, buf.String(), "headers.User-Agent=cachew")
+	assert.Contains(t, buf.String(), "headers.Authorization=REDACTED")
+	assert.Contains(t, buf.String(), "headers.X-Mirror-Key=REDACTED")
+	assert.NotContains(t, buf.String(), "hunter2")
+}
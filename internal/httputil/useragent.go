@@ -0,0 +1,46 @@
+package httputil
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/alecthomas/errors"
+)
+
+type clientUserAgentKey struct{}
+
+// CaptureUserAgent records the client's User-Agent in the request context so that [UserAgentTransport] can forward
+// it to upstreams.
+func CaptureUserAgent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ua := r.Header.Get("User-Agent"); ua != "" {
+			r = r.WithContext(context.WithValue(r.Context(), clientUserAgentKey{}, ua))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// UserAgentTransport identifies the proxy to upstreams by setting the User-Agent of outgoing requests.
+//
+// Requests without a User-Agent, or that carry the client's User-Agent because they were copied from the incoming
+// request, are given UserAgent. Requests with any other User-Agent, eg. one configured for a strategy, are left alone.
+type UserAgentTransport struct {
+	Next      http.RoundTripper
+	UserAgent string
+	// ForwardClient passes the client's User-Agent, as recorded by [CaptureUserAgent], in X-Forwarded-User-Agent.
+	ForwardClient bool
+}
+
+var _ http.RoundTripper = (*UserAgentTransport)(nil)
+
+func (u *UserAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	client, _ := req.Context().Value(clientUserAgentKey{}).(string)
+	if ua := req.Header.Get("User-Agent"); ua == "" || ua == client {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", u.UserAgent)
+		if u.ForwardClient && client != "" {
+			req.Header.Set("X-Forwarded-User-Agent", client)
+		}
+	}
+	return errors.WithStack2(u.Next.RoundTrip(req))
+}
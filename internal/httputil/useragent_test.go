@@ -0,0 +1,63 @@
+package httputil_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/httputil"
+)
+
+func TestUserAgentTransport(t *testing.T) {
+	var received http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name            string
+		forward         bool
+		copyClientAgent bool
+		requestAgent    string
+		expectAgent     string
+		expectForwarded string
+	}{
+		{name: "NewRequest", expectAgent: "cachewd/1.2.3"},
+		{name: "CopiedClientAgent", copyClientAgent: true, expectAgent: "cachewd/1.2.3"},
+		{name: "ForwardClient", forward: true, expectAgent: "cachewd/1.2.3", expectForwarded: "curl/8.0"},
+		{name: "ConfiguredAgentPreserved", requestAgent: "custom/1.0", expectAgent: "custom/1.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{Transport: &httputil.UserAgentTransport{
+				Next:          http.DefaultTransport,
+				UserAgent:     "cachewd/1.2.3",
+				ForwardClient: tt.forward,
+			}}
+			handler := httputil.CaptureUserAgent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+				assert.NoError(t, err)
+				if tt.copyClientAgent {
+					req.Header.Set("User-Agent", r.Header.Get("User-Agent"))
+				}
+				if tt.requestAgent != "" {
+					req.Header.Set("User-Agent", tt.requestAgent)
+				}
+				resp, err := client.Do(req)
+				assert.NoError(t, err)
+				_ = resp.Body.Close()
+				w.WriteHeader(resp.StatusCode)
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("User-Agent", "curl/8.0")
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			assert.Equal(t, tt.expectAgent, received.Get("User-Agent"))
+			assert.Equal(t, tt.expectForwarded, received.Get("X-Forwarded-User-Agent"))
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package httputil
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/block/cachew/internal/logging"
+)
+
+// Warmup delays readiness after startup until warmup tasks, such as refreshing clones found on disk, have completed,
+// so that rolling deploys do not send traffic to a server that would answer it with a burst of slow misses.
+//
+// Readiness is reported once every task has completed or the grace period has elapsed, whichever is first, and is
+// never withdrawn. Tasks started after that are not waited for.
+type Warmup struct {
+	logger   *slog.Logger
+	deadline time.Time
+
+	mu      sync.Mutex
+	pending map[*warmupTask]bool
+	ready   bool
+}
+
+type warmupTask struct{ name string }
+
+// NewWarmup creates a [Warmup] that waits at most gracePeriod from now. A zero grace period is ready immediately.
+func NewWarmup(ctx context.Context, gracePeriod time.Duration) *Warmup {
+	return &Warmup{
+		logger:   logging.FromContext(ctx),
+		deadline: time.Now().Add(gracePeriod),
+		pending:  map[*warmupTask]bool{},
+		ready:    gracePeriod <= 0,
+	}
+}
+
+// Start a warmup task, returning a function to call once it has completed.
+func (w *Warmup) Start(name string) (done func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.ready {
+		return func() {}
+	}
+	task := &warmupTask{name: name}
+	w.pending[task] = true
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			delete(w.pending, task)
+		})
+	}
+}
+
+// Ready returns true once warmup has completed or its grace period has elapsed.
+func (w *Warmup) Ready() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.ready {
+		return true
+	}
+	switch {
+	case len(w.pending) == 0:
+		w.logger.Info("Warmup complete")
+	case time.Now().After(w.deadline):
+		names := make([]string, 0, len(w.pending))
+		for task := range w.pending {
+			names = append(names, task.name)
+		}
+		w.logger.Warn("Warmup grace period elapsed before tasks completed", slog.Any("pending", names))
+	default:
+		return false
+	}
+	w.ready = true
+	return true
+}
+
+// ReadinessHandler responds with "200 OK" once [Warmup.Ready], and "503 Service Unavailable" until then.
+func (w *Warmup) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		if !w.Ready() {
+			rw.Header().Set("Retry-After", "1")
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = rw.Write([]byte("Warming up")) //nolint:errcheck
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("OK")) //nolint:errcheck
+	})
+}
+
+type warmupKey struct{}
+
+// ContextWithWarmup returns a new context with the given warmup, to which [StartWarmup] adds tasks.
+func ContextWithWarmup(ctx context.Context, warmup *Warmup) context.Context {
+	return context.WithValue(ctx, warmupKey{}, warmup)
+}
+
+// StartWarmup starts a warmup task on the [Warmup] in ctx, if any, returning a function to call once it has
+// completed.
+func StartWarmup(ctx context.Context, name string) (done func()) {
+	warmup, ok := ctx.Value(warmupKey{}).(*Warmup)
+	if !ok {
+		return func() {}
+	}
+	return warmup.Start(name)
+}
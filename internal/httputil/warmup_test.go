@@ -0,0 +1,61 @@
+package httputil_test
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/httputil"
+	"github.com/block/cachew/internal/logging"
+)
+
+func TestWarmup(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+
+	readiness := func(warmup *httputil.Warmup) int {
+		w := httptest.NewRecorder()
+		warmup.ReadinessHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/_readiness", nil))
+		return w.Code
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		warmup := httputil.NewWarmup(ctx, 0)
+		warmup.Start("ignored")
+		assert.Equal(t, http.StatusOK, readiness(warmup))
+	})
+
+	t.Run("TasksComplete", func(t *testing.T) {
+		warmup := httputil.NewWarmup(ctx, time.Hour)
+		wctx := httputil.ContextWithWarmup(ctx, warmup)
+		first := httputil.StartWarmup(wctx, "first")
+		second := httputil.StartWarmup(wctx, "second")
+		assert.Equal(t, http.StatusServiceUnavailable, readiness(warmup))
+		first()
+		first()
+		assert.Equal(t, http.StatusServiceUnavailable, readiness(warmup))
+		second()
+		assert.Equal(t, http.StatusOK, readiness(warmup))
+
+		// Readiness is never withdrawn.
+		httputil.StartWarmup(wctx, "late")
+		assert.Equal(t, http.StatusOK, readiness(warmup))
+	})
+
+	t.Run("GracePeriodElapses", func(t *testing.T) {
+		warmup := httputil.NewWarmup(ctx, 50*time.Millisecond)
+		warmup.Start("slow")
+		assert.Equal(t, http.StatusServiceUnavailable, readiness(warmup))
+		time.Sleep(100 * time.Millisecond)
+		assert.Equal(t, http.StatusOK, readiness(warmup))
+	})
+
+	t.Run("NoWarmupInContext", func(t *testing.T) {
+		done := httputil.StartWarmup(ctx, "untracked")
+		done()
+	})
+}
@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// OtherValue replaces label values beyond a label's cardinality cap.
+const OtherValue = "other"
+
+// Labels bounds the cardinality of attributes attached to strategy metrics.
+//
+// Attributes are first filtered by the allow and deny lists, then mapped through any bucketing function registered
+// for their key, and finally collapsed into [OtherValue] once their key has been seen with the configured maximum
+// number of distinct values. Values seen before the cap is reached continue to be reported as-is.
+//
+// It is safe for concurrent use.
+type Labels struct {
+	allow     map[attribute.Key]bool
+	deny      map[attribute.Key]bool
+	maxValues int
+
+	mu      sync.Mutex
+	buckets map[attribute.Key]func(string) string
+	seen    map[attribute.Key]map[string]struct{}
+}
+
+// NewLabels creates a label policy from the label settings in cfg.
+func NewLabels(cfg Config) *Labels {
+	l := &Labels{
+		allow:     map[attribute.Key]bool{},
+		deny:      map[attribute.Key]bool{},
+		maxValues: cfg.LabelMaxValues,
+		buckets:   map[attribute.Key]func(string) string{},
+		seen:      map[attribute.Key]map[string]struct{}{},
+	}
+	for _, key := range cfg.LabelAllow {
+		l.allow[attribute.Key(key)] = true
+	}
+	for _, key := range cfg.LabelDeny {
+		l.deny[attribute.Key(key)] = true
+	}
+	return l
+}
+
+// Bucket registers a function that maps values of key to a coarser value before the cardinality cap is applied, eg.
+// [HostBucket] to label by host rather than by full path.
+func (l *Labels) Bucket(key attribute.Key, f func(string) string) *Labels {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buckets[key] = f
+	return l
+}
+
+// Apply returns attrs with disallowed keys removed, bucketing applied, and values beyond the cardinality cap replaced
+// by [OtherValue].
+//
+// Only string attributes are bucketed and capped; other types are passed through if allowed.
+func (l *Labels) Apply(attrs ...attribute.KeyValue) []attribute.KeyValue {
+	out := make([]attribute.KeyValue, 0, len(attrs))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, attr := range attrs {
+		if l.deny[attr.Key] || (len(l.allow) > 0 && !l.allow[attr.Key]) {
+			continue
+		}
+		if attr.Value.Type() != attribute.STRING {
+			out = append(out, attr)
+			continue
+		}
+		value := attr.Value.AsString()
+		if bucket, ok := l.buckets[attr.Key]; ok {
+			value = bucket(value)
+		}
+		out = append(out, attr.Key.String(l.limit(attr.Key, value)))
+	}
+	return out
+}
+
+func (l *Labels) limit(key attribute.Key, value string) string {
+	if l.maxValues <= 0 {
+		return value
+	}
+	values, ok := l.seen[key]
+	if !ok {
+		values = map[string]struct{}{}
+		l.seen[key] = values
+	}
+	if _, ok := values[value]; ok {
+		return value
+	}
+	if len(values) >= l.maxValues {
+		return OtherValue
+	}
+	values[value] = struct{}{}
+	return value
+}
+
+// HostBucket reduces a URL, or a path whose first segment is a host such as "github.com/org/repo", to its host.
+func HostBucket(value string) string {
+	if u, err := url.Parse(value); err == nil && u.Host != "" {
+		return strings.ToLower(u.Hostname())
+	}
+	host, _, _ := strings.Cut(strings.TrimPrefix(value, "/"), "/")
+	return strings.ToLower(host)
+}
+
+var defaultLabels atomic.Pointer[Labels] //nolint:gochecknoglobals
+
+// DefaultLabels returns the label policy configured by [New], for use by strategies when recording metrics.
+//
+// Before [New] is called the policy allows all labels without a cardinality cap.
+func DefaultLabels() *Labels {
+	if l := defaultLabels.Load(); l != nil {
+		return l
+	}
+	defaultLabels.CompareAndSwap(nil, NewLabels(Config{}))
+	return defaultLabels.Load()
+}
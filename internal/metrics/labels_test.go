@@ -0,0 +1,58 @@
+package metrics_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/block/cachew/internal/metrics"
+)
+
+func TestLabelsCardinalityCap(t *testing.T) {
+	labels := metrics.NewLabels(metrics.Config{LabelMaxValues: 3})
+	var got []string
+	for i := range 5 {
+		attrs := labels.Apply(attribute.String("host", fmt.Sprintf("host%d", i)))
+		got = append(got, attrs[0].Value.AsString())
+	}
+	assert.Equal(t, []string{"host0", "host1", "host2", metrics.OtherValue, metrics.OtherValue}, got)
+
+	// Values seen before the cap was reached are still reported.
+	assert.Equal(t, []attribute.KeyValue{attribute.String("host", "host1")}, labels.Apply(attribute.String("host", "host1")))
+	// Each label has its own cap.
+	assert.Equal(t, []attribute.KeyValue{attribute.String("status", "HIT")}, labels.Apply(attribute.String("status", "HIT")))
+}
+
+func TestLabelsAllowDeny(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   metrics.Config
+		expected []attribute.KeyValue
+	}{
+		{"AllowAll", metrics.Config{},
+			[]attribute.KeyValue{attribute.String("host", "a"), attribute.String("path", "/b"), attribute.Int("code", 200)}},
+		{"Deny", metrics.Config{LabelDeny: []string{"path"}},
+			[]attribute.KeyValue{attribute.String("host", "a"), attribute.Int("code", 200)}},
+		{"Allow", metrics.Config{LabelAllow: []string{"host", "path"}, LabelDeny: []string{"path"}},
+			[]attribute.KeyValue{attribute.String("host", "a")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels := metrics.NewLabels(tt.config)
+			got := labels.Apply(attribute.String("host", "a"), attribute.String("path", "/b"), attribute.Int("code", 200))
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestLabelsBucket(t *testing.T) {
+	labels := metrics.NewLabels(metrics.Config{LabelMaxValues: 1}).Bucket("repo", metrics.HostBucket)
+	assert.Equal(t, []attribute.KeyValue{attribute.String("repo", "github.com")},
+		labels.Apply(attribute.String("repo", "https://GitHub.com/org/repo.git")))
+	assert.Equal(t, []attribute.KeyValue{attribute.String("repo", "github.com")},
+		labels.Apply(attribute.String("repo", "github.com/other/repo")))
+	assert.Equal(t, []attribute.KeyValue{attribute.String("repo", metrics.OtherValue)},
+		labels.Apply(attribute.String("repo", "/gitlab.com/org/repo")))
+}
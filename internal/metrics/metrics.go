@@ -30,8 +30,19 @@ type Config struct {
 	OTLPEndpoint       string `help:"OTLP endpoint URL." default:"http://localhost:4318"`
 	OTLPInsecure       bool   `help:"Use insecure connection for OTLP." default:"false"`
 	OTLPExportInterval int    `help:"OTLP export interval in seconds." default:"60"`
+	// ShutdownTimeout bounds the final export on shutdown, so that an unreachable collector cannot hang it.
+	ShutdownTimeout time.Duration `help:"Longest time to spend exporting final metrics on shutdown (defaults to 5s)." default:"5s"`
+
+	LabelAllow     []string `help:"Labels strategies may attach to metrics, eg. \"cache\" and \"upstream\". If empty, all labels not denied are allowed."`
+	LabelDeny      []string `help:"Labels strategies must not attach to metrics."`
+	LabelMaxValues int      `help:"Maximum distinct values per metric label, beyond which values are reported as \"other\" (0 for no limit)." default:"100"`
+
+	// Readers are additional metric readers, eg. for tests.
+	Readers []sdkmetric.Reader `hcl:"-" kong:"-"`
 }
 
+const defaultShutdownTimeout = 5 * time.Second
+
 // Client provides OpenTelemetry metrics with configurable exporters.
 type Client struct {
 	provider          metric.MeterProvider
@@ -40,6 +51,7 @@ type Client struct {
 	registry          *prometheus.Registry
 	serviceName       string
 	port              int
+	shutdownTimeout   time.Duration
 }
 
 // New creates a new OpenTelemetry metrics client with configurable exporters.
@@ -47,7 +59,7 @@ func New(ctx context.Context, cfg Config) (*Client, error) {
 	logger := logging.FromContext(ctx)
 
 	// Validate that at least one exporter is enabled
-	if !cfg.EnablePrometheus && !cfg.EnableOTLP {
+	if !cfg.EnablePrometheus && !cfg.EnableOTLP && len(cfg.Readers) == 0 {
 		return nil, errors.New("at least one exporter (Prometheus or OTLP) must be enabled")
 	}
 
@@ -103,6 +115,8 @@ func New(ctx context.Context, cfg Config) (*Client, error) {
 		exporters = append(exporters, "otlp")
 	}
 
+	readers = append(readers, cfg.Readers...)
+
 	// Create meter provider with all configured readers
 	providerOpts := []sdkmetric.Option{
 		sdkmetric.WithResource(res),
@@ -113,6 +127,7 @@ func New(ctx context.Context, cfg Config) (*Client, error) {
 
 	provider := sdkmetric.NewMeterProvider(providerOpts...)
 	otel.SetMeterProvider(provider)
+	defaultLabels.Store(NewLabels(cfg))
 
 	client := &Client{
 		provider:          provider,
@@ -121,6 +136,10 @@ func New(ctx context.Context, cfg Config) (*Client, error) {
 		registry:          registry,
 		serviceName:       cfg.ServiceName,
 		port:              cfg.Port,
+		shutdownTimeout:   cfg.ShutdownTimeout,
+	}
+	if client.shutdownTimeout <= 0 {
+		client.shutdownTimeout = defaultShutdownTimeout
 	}
 
 	logger.InfoContext(ctx, "OpenTelemetry metrics initialized",
@@ -133,17 +152,28 @@ func New(ctx context.Context, cfg Config) (*Client, error) {
 	return client, nil
 }
 
-// Close shuts down the meter provider.
+// Close exports any metrics recorded since the last export and shuts down the meter provider.
+//
+// Both are bounded by the configured shutdown timeout, so that an unreachable collector cannot hang shutdown, in which
+// case the unexported metrics are lost and an error is returned.
 func (c *Client) Close() error {
 	if c.provider == nil {
 		return nil
 	}
-	if provider, ok := c.provider.(*sdkmetric.MeterProvider); ok {
-		if err := provider.Shutdown(context.Background()); err != nil {
-			return fmt.Errorf("failed to shutdown meter provider: %w", err)
-		}
+	provider, ok := c.provider.(*sdkmetric.MeterProvider)
+	if !ok {
+		return nil
 	}
-	return nil
+	ctx, cancel := context.WithTimeout(context.Background(), c.shutdownTimeout)
+	defer cancel()
+	var errs []error
+	if err := provider.ForceFlush(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to flush metrics: %w", err))
+	}
+	if err := provider.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to shutdown meter provider: %w", err))
+	}
+	return errors.Join(errs...)
 }
 
 // Handler returns the HTTP handler for the /metrics endpoint.
@@ -4,10 +4,14 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/alecthomas/assert/v2"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 
 	"github.com/block/cachew/internal/logging"
 	"github.com/block/cachew/internal/metrics"
@@ -115,3 +119,82 @@ func TestMetricsNoExportersError(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "at least one exporter")
 }
+
+// memoryExporter records the final value of each int64 counter it exports. If block is set, exports instead wait
+// until their context is done, like a dead collector.
+type memoryExporter struct {
+	block bool
+
+	mu       sync.Mutex
+	counters map[string]int64
+}
+
+func (m *memoryExporter) Temporality(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+	return sdkmetric.DefaultTemporalitySelector(kind)
+}
+
+func (m *memoryExporter) Aggregation(kind sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+	return sdkmetric.DefaultAggregationSelector(kind)
+}
+
+func (m *memoryExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	if m.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, sm := range rm.ScopeMetrics {
+		for _, metric := range sm.Metrics {
+			if sum, ok := metric.Data.(metricdata.Sum[int64]); ok && len(sum.DataPoints) > 0 {
+				m.counters[metric.Name] = sum.DataPoints[0].Value
+			}
+		}
+	}
+	return nil
+}
+
+func (m *memoryExporter) ForceFlush(context.Context) error { return nil }
+
+func (m *memoryExporter) Shutdown(context.Context) error { return nil }
+
+func TestMetricsFlushedOnClose(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+
+	t.Run("Flushed", func(t *testing.T) {
+		exporter := &memoryExporter{counters: map[string]int64{}}
+		client, err := metrics.New(ctx, metrics.Config{
+			ServiceName: "cachew-flush",
+			// The interval is long enough that only the final flush exports.
+			Readers: []sdkmetric.Reader{sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(time.Hour))},
+		})
+		assert.NoError(t, err)
+
+		counter, err := otel.GetMeterProvider().Meter("test").Int64Counter("requests")
+		assert.NoError(t, err)
+		counter.Add(ctx, 3)
+
+		assert.NoError(t, client.Close())
+		exporter.mu.Lock()
+		defer exporter.mu.Unlock()
+		assert.Equal(t, map[string]int64{"requests": 3}, exporter.counters)
+	})
+
+	t.Run("DeadCollector", func(t *testing.T) {
+		exporter := &memoryExporter{block: true}
+		client, err := metrics.New(ctx, metrics.Config{
+			ServiceName:     "cachew-dead",
+			ShutdownTimeout: 100 * time.Millisecond,
+			Readers:         []sdkmetric.Reader{sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(time.Hour))},
+		})
+		assert.NoError(t, err)
+
+		counter, err := otel.GetMeterProvider().Meter("test").Int64Counter("requests")
+		assert.NoError(t, err)
+		counter.Add(ctx, 1)
+
+		start := time.Now()
+		assert.Error(t, client.Close())
+		assert.True(t, time.Since(start) < 5*time.Second)
+	})
+}
@@ -0,0 +1,43 @@
+// Package retry retries operations with exponential backoff.
+package retry
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"github.com/alecthomas/errors"
+)
+
+// Config controls how an operation is retried.
+type Config struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry. It doubles after each subsequent attempt.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts.
+	MaxBackoff time.Duration
+}
+
+// Do calls fn until it succeeds, returns an error rejected by "retryable", runs out of attempts, or ctx is
+// cancelled.
+//
+// Delays are jittered between half and the full backoff so that concurrent callers don't retry in lockstep. The
+// error from the last attempt is returned.
+func Do(ctx context.Context, config Config, retryable func(error) bool, fn func(ctx context.Context) error) error {
+	backoff := config.InitialBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn(ctx)
+		if err == nil || attempt >= config.MaxAttempts || !retryable(err) {
+			return err
+		}
+		delay := backoff/2 + rand.N(backoff/2+1) //nolint:gosec // Jitter does not need to be cryptographically secure.
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return errors.Join(err, errors.WithStack(ctx.Err()))
+		}
+		backoff = min(backoff*2, config.MaxBackoff)
+	}
+}
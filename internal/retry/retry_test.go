@@ -0,0 +1,46 @@
+package retry_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/retry"
+)
+
+var errTransient = errors.New("transient")
+
+func TestDo(t *testing.T) {
+	config := retry.Config{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+	isTransient := func(err error) bool { return errors.Is(err, errTransient) }
+	tests := []struct {
+		name          string
+		errs          []error
+		expectErr     bool
+		expectAttempt int
+	}{
+		{name: "Succeeds", errs: []error{nil}, expectAttempt: 1},
+		{name: "RetriesTransient", errs: []error{errTransient, errTransient, nil}, expectAttempt: 3},
+		{name: "ExhaustsAttempts", errs: []error{errTransient, errTransient, errTransient, nil}, expectErr: true, expectAttempt: 3},
+		{name: "PermanentNotRetried", errs: []error{errors.New("permanent"), nil}, expectErr: true, expectAttempt: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := retry.Do(context.Background(), config, isTransient, func(context.Context) error {
+				err := tt.errs[attempts]
+				attempts++
+				return err
+			})
+			if tt.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectAttempt, attempts)
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/cache"
+)
+
+// Format of an archive written by [WriteSubtree].
+type Format string
+
+const (
+	FormatZip   Format = "zip"
+	FormatTarGz Format = "tar.gz"
+)
+
+// ParseFormat parses an archive format name, defaulting to [FormatZip] if empty.
+func ParseFormat(name string) (Format, error) {
+	switch format := Format(name); format {
+	case "":
+		return FormatZip, nil
+	case FormatZip, FormatTarGz:
+		return format, nil
+	default:
+		return "", errors.Errorf("unsupported archive format %q, expected %q or %q", name, FormatZip, FormatTarGz)
+	}
+}
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	if f == FormatTarGz {
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+// WriteSubtree transcodes the part of a snapshot beneath dir into a new archive of the given format, written to w.
+//
+// Entry names are relative to dir, and an empty dir selects the whole snapshot. Only regular files, directories and
+// symlinks are included. The snapshot is streamed from the cache and decompressed on the fly, so memory use does not
+// grow with the size of the snapshot.
+//
+// Nothing is written to w until the first entry beneath dir is found, so if dir does not exist in the snapshot
+// os.ErrNotExist is returned with w untouched.
+func WriteSubtree(ctx context.Context, remote cache.Cache, key cache.Key, dir string, format Format, w io.Writer) error {
+	rc, err := RestoreToTar(ctx, remote, key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	dir = strings.Trim(path.Clean("/"+dir), "/")
+	var aw archiveWriter
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "failed to read snapshot")
+		}
+		name, ok := subtreeName(hdr.Name, dir)
+		if !ok {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeDir, tar.TypeSymlink:
+		default:
+			continue
+		}
+		if aw == nil {
+			aw = newArchiveWriter(format, w)
+		}
+		if name == "" {
+			if hdr.Typeflag == tar.TypeDir {
+				// The root of the subtree is implied by the archive itself.
+				continue
+			}
+			// A file selected directly is archived under its own name.
+			name = path.Base(path.Clean(hdr.Name))
+		}
+		if err := aw.add(hdr, name, tr); err != nil {
+			return errors.Wrapf(err, "failed to add %s", name)
+		}
+	}
+	if aw == nil {
+		return errors.Wrap(os.ErrNotExist, dir)
+	}
+	return aw.Close()
+}
+
+// subtreeName returns the name of a snapshot entry relative to dir, or false if it does not lie beneath dir. The
+// name of dir itself is empty.
+func subtreeName(entry, dir string) (string, bool) {
+	entry = path.Clean(entry)
+	if entry == ".." || strings.HasPrefix(entry, "../") || strings.HasPrefix(entry, "/") {
+		return "", false
+	}
+	if entry == "." {
+		entry = ""
+	}
+	switch {
+	case entry == dir:
+		return "", true
+	case dir == "":
+		return entry, true
+	case strings.HasPrefix(entry, dir+"/"):
+		return strings.TrimPrefix(entry, dir+"/"), true
+	default:
+		return "", false
+	}
+}
+
+type archiveWriter interface {
+	io.Closer
+	add(hdr *tar.Header, name string, r io.Reader) error
+}
+
+func newArchiveWriter(format Format, w io.Writer) archiveWriter {
+	if format == FormatTarGz {
+		gz := gzip.NewWriter(w)
+		return &tarGzWriter{gz: gz, tw: tar.NewWriter(gz)}
+	}
+	return &zipWriter{zw: zip.NewWriter(w)}
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipWriter) add(hdr *tar.Header, name string, r io.Reader) error {
+	fh, err := zip.FileInfoHeader(hdr.FileInfo())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	fh.Name = name
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		fh.Name += "/"
+		fh.Method = zip.Store
+	case tar.TypeSymlink:
+		fh.Method = zip.Store
+		r = strings.NewReader(hdr.Linkname)
+	default:
+		fh.Method = zip.Deflate
+	}
+	fw, err := z.zw.CreateHeader(fh)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if hdr.Typeflag == tar.TypeDir {
+		return nil
+	}
+	_, err = io.Copy(fw, r)
+	return errors.WithStack(err)
+}
+
+func (z *zipWriter) Close() error { return errors.WithStack(z.zw.Close()) }
+
+type tarGzWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (t *tarGzWriter) add(hdr *tar.Header, name string, r io.Reader) error {
+	out := *hdr
+	out.Name = name
+	if hdr.Typeflag == tar.TypeDir {
+		out.Name += "/"
+	}
+	if err := t.tw.WriteHeader(&out); err != nil {
+		return errors.WithStack(err)
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		return nil
+	}
+	_, err := io.Copy(t.tw, r)
+	return errors.WithStack(err)
+}
+
+func (t *tarGzWriter) Close() error {
+	return errors.Join(errors.WithStack(t.tw.Close()), errors.WithStack(t.gz.Close()))
+}
@@ -0,0 +1,155 @@
+package snapshot
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/alecthomas/errors"
+)
+
+// Matcher matches paths against a list of .gitignore-style patterns.
+//
+// Supported syntax:
+//
+//   - "#" starts a comment and blank lines are ignored.
+//   - "!" negates a pattern, re-including paths excluded by an earlier pattern.
+//   - A trailing "/" only matches directories.
+//   - A pattern containing a "/" is relative to the root, otherwise it matches at any depth.
+//   - "*" and "?" match within a path segment, "**" matches across segments.
+//
+// As with git, the last matching pattern wins.
+type Matcher struct {
+	patterns []matcherPattern
+}
+
+type matcherPattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// NewMatcher compiles the given patterns into a [Matcher].
+func NewMatcher(patterns []string) (*Matcher, error) {
+	m := &Matcher{}
+	for _, pattern := range patterns {
+		p, ok, err := compilePattern(pattern)
+		if err != nil {
+			return nil, errors.Errorf("%q: %w", pattern, err)
+		}
+		if ok {
+			m.patterns = append(m.patterns, p)
+		}
+	}
+	return m, nil
+}
+
+// ReadPatterns reads newline-separated patterns, eg. from a .gitignore file.
+func ReadPatterns(r io.Reader) ([]string, error) {
+	var patterns []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		patterns = append(patterns, scanner.Text())
+	}
+	return patterns, errors.WithStack(scanner.Err())
+}
+
+// Match returns true if the slash-separated path, relative to the root, is excluded.
+func (m *Matcher) Match(path string, isDir bool) bool {
+	matched := false
+	for _, p := range m.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.re.MatchString(path) {
+			matched = !p.negate
+		}
+	}
+	return matched
+}
+
+func compilePattern(pattern string) (matcherPattern, bool, error) {
+	pattern = strings.TrimRight(pattern, " \t\r")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return matcherPattern{}, false, nil
+	}
+	p := matcherPattern{}
+	switch {
+	case strings.HasPrefix(pattern, "!"):
+		p.negate = true
+		pattern = pattern[1:]
+	case strings.HasPrefix(pattern, `\!`), strings.HasPrefix(pattern, `\#`):
+		pattern = pattern[1:]
+	}
+	if trimmed, ok := strings.CutSuffix(pattern, "/"); ok {
+		p.dirOnly = true
+		pattern = trimmed
+	}
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if pattern == "" {
+		return matcherPattern{}, false, nil
+	}
+
+	expr := strings.Builder{}
+	expr.WriteString("^")
+	if !anchored {
+		expr.WriteString("(?:.*/)?")
+	}
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch {
+		case segment == "**" && last:
+			expr.WriteString(".*")
+		case segment == "**":
+			expr.WriteString("(?:.*/)?")
+		default:
+			expr.WriteString(globToRegexp(segment))
+			if !last {
+				expr.WriteString("/")
+			}
+		}
+	}
+	expr.WriteString("$")
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return matcherPattern{}, false, errors.WithStack(err)
+	}
+	p.re = re
+	return p, true, nil
+}
+
+// globToRegexp converts a single path segment glob to a regular expression.
+func globToRegexp(glob string) string {
+	out := strings.Builder{}
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			out.WriteString("[^/]*")
+		case '?':
+			out.WriteString("[^/]")
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				out.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			}
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				out.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			out.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			out.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return out.String()
+}
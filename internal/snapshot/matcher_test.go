@@ -0,0 +1,54 @@
+package snapshot_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/snapshot"
+)
+
+func TestMatcher(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{name: "Basename", patterns: []string{"*.log"}, path: "a/b/c.log", expected: true},
+		{name: "BasenameNoMatch", patterns: []string{"*.log"}, path: "a/b/c.txt", expected: false},
+		{name: "AnchoredRoot", patterns: []string{"/build"}, path: "build", isDir: true, expected: true},
+		{name: "AnchoredNotNested", patterns: []string{"/build"}, path: "src/build", isDir: true, expected: false},
+		{name: "DirOnlyMatchesDir", patterns: []string{"cache/"}, path: "x/cache", isDir: true, expected: true},
+		{name: "DirOnlySkipsFile", patterns: []string{"cache/"}, path: "x/cache", expected: false},
+		{name: "Negation", patterns: []string{"*.log", "!keep.log"}, path: "dir/keep.log", expected: false},
+		{name: "NegationThenReexclude", patterns: []string{"*.log", "!keep.log", "dir/keep.log"}, path: "dir/keep.log", expected: true},
+		{name: "LeadingDoubleStar", patterns: []string{"**/node_modules"}, path: "a/b/node_modules", isDir: true, expected: true},
+		{name: "MiddleDoubleStar", patterns: []string{"a/**/z.txt"}, path: "a/b/c/z.txt", expected: true},
+		{name: "MiddleDoubleStarZeroDirs", patterns: []string{"a/**/z.txt"}, path: "a/z.txt", expected: true},
+		{name: "TrailingDoubleStar", patterns: []string{"a/**"}, path: "a/b/c", expected: true},
+		{name: "TrailingDoubleStarNotSelf", patterns: []string{"a/**"}, path: "a", isDir: true, expected: false},
+		{name: "QuestionMark", patterns: []string{"file?.txt"}, path: "file1.txt", expected: true},
+		{name: "CharacterClass", patterns: []string{"file[!0-9].txt"}, path: "file1.txt", expected: false},
+		{name: "Comment", patterns: []string{"# *.txt"}, path: "a.txt", expected: false},
+		{name: "EscapedHash", patterns: []string{`\#notes`}, path: "#notes", expected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := snapshot.NewMatcher(tt.patterns)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, m.Match(tt.path, tt.isDir))
+		})
+	}
+}
+
+func TestReadPatterns(t *testing.T) {
+	patterns, err := snapshot.ReadPatterns(strings.NewReader("# comment\n*.log\n\n!keep.log\n"))
+	assert.NoError(t, err)
+	m, err := snapshot.NewMatcher(patterns)
+	assert.NoError(t, err)
+	assert.True(t, m.Match("debug.log", false))
+	assert.False(t, m.Match("keep.log", false))
+}
@@ -2,9 +2,12 @@
 package snapshot
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"io/fs"
 	"net/http"
 	"os"
 	"os/exec"
@@ -16,12 +19,51 @@ import (
 	"github.com/block/cachew/internal/cache"
 )
 
+// Option configures [Create].
+type Option func(*options)
+
+type options struct {
+	reproducible bool
+	gitignore    bool
+	headers      http.Header
+}
+
+// Reproducible normalizes archive entries so that the same directory tree always produces a byte-identical
+// snapshot, allowing callers to key snapshots by their content.
+//
+// Entries are stored in lexical order with their modification times set to the Unix epoch, their owner and group
+// set to 0 with no user or group names, and their modes masked so that they are always owner readable and writable
+// and never group or world writable. Execute bits and symlinks are preserved. This requires GNU tar.
+func Reproducible() Option {
+	return func(o *options) { o.reproducible = true }
+}
+
+// Gitignore interprets exclude patterns with .gitignore syntax, see [Matcher], rather than tar's --exclude syntax.
+func Gitignore() Option {
+	return func(o *options) { o.gitignore = true }
+}
+
+// Header sets an additional header on the snapshot object, eg. an ETag identifying the state it was created from.
+func Header(name, value string) Option {
+	return func(o *options) {
+		if o.headers == nil {
+			o.headers = make(http.Header)
+		}
+		o.headers.Set(name, value)
+	}
+}
+
 // Create archives a directory using tar with zstd compression, then uploads to the cache.
 //
-// The archive preserves all file permissions, ownership, and symlinks.
+// The archive preserves all file permissions, ownership, and symlinks unless [Reproducible] is given.
 // The operation is fully streaming - no temporary files are created.
-// Exclude patterns use tar's --exclude syntax.
-func Create(ctx context.Context, remote cache.Cache, key cache.Key, directory string, ttl time.Duration, excludePatterns []string) error {
+// Exclude patterns use tar's --exclude syntax unless [Gitignore] is given.
+func Create(ctx context.Context, remote cache.Cache, key cache.Key, directory string, ttl time.Duration, excludePatterns []string, opts ...Option) error {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	// Verify directory exists
 	if info, err := os.Stat(directory); err != nil {
 		return errors.Wrap(err, "failed to stat directory")
@@ -29,26 +71,58 @@ func Create(ctx context.Context, remote cache.Cache, key cache.Key, directory st
 		return errors.Errorf("not a directory: %s", directory)
 	}
 
-	headers := make(http.Header)
+	var matcher *Matcher
+	if o.gitignore {
+		var err error
+		matcher, err = NewMatcher(excludePatterns)
+		if err != nil {
+			return errors.Wrap(err, "invalid exclude pattern")
+		}
+	}
+
+	headers := o.headers.Clone()
+	if headers == nil {
+		headers = make(http.Header)
+	}
 	headers.Set("Content-Type", "application/zstd")
 	headers.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(directory)+".tar.zst"))
 
-	wc, err := remote.Create(ctx, key, headers, ttl)
+	// Cancelling the object's context abandons it, so that a failed archive is never committed.
+	createCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	wc, err := remote.Create(createCtx, key, headers, ttl)
 	if err != nil {
 		return errors.Wrap(err, "failed to create object")
 	}
 
-	tarArgs := []string{"-cpf", "-", "-C", directory}
-	for _, pattern := range excludePatterns {
-		tarArgs = append(tarArgs, "--exclude", pattern)
+	// The file list is streamed to tar on stdin, so it must not recurse into directories itself. Excludes must precede
+	// the list to apply to it.
+	args := []string{"-cpf", "-", "-C", directory}
+	if !o.gitignore {
+		for _, pattern := range excludePatterns {
+			args = append(args, "--exclude", pattern)
+		}
 	}
-	tarArgs = append(tarArgs, ".")
-
-	tarCmd := exec.CommandContext(ctx, "tar", tarArgs...)
+	args = append(args, "--no-recursion", "--null", "-T", "-")
+	if o.reproducible {
+		// listFiles already walks in lexical order, so only the entry metadata needs normalizing.
+		args = append(args, "--format=gnu", "--mtime=@0", "--owner=0", "--group=0", "--numeric-owner", "--mode=u+rw,go-w")
+	}
+	files, filesWriter := io.Pipe()
+	defer files.Close()
+	listed := make(chan error, 1)
+	go func() {
+		err := listFiles(filesWriter, directory, matcher)
+		filesWriter.CloseWithError(err)
+		listed <- err
+	}()
+	tarCmd := exec.CommandContext(ctx, "tar", args...)
+	tarCmd.Stdin = files
 	zstdCmd := exec.CommandContext(ctx, "zstd", "-c", "-T0")
 
 	tarStdout, err := tarCmd.StdoutPipe()
 	if err != nil {
+		cancel()
 		return errors.Join(errors.Wrap(err, "failed to create tar stdout pipe"), wc.Close())
 	}
 
@@ -60,24 +134,68 @@ func Create(ctx context.Context, remote cache.Cache, key cache.Key, directory st
 	zstdCmd.Stderr = &zstdStderr
 
 	if err := tarCmd.Start(); err != nil {
+		cancel()
 		return errors.Join(errors.Wrap(err, "failed to start tar"), wc.Close())
 	}
 
 	if err := zstdCmd.Start(); err != nil {
+		cancel()
 		return errors.Join(errors.Wrap(err, "failed to start zstd"), tarCmd.Wait(), wc.Close())
 	}
 
 	tarErr := tarCmd.Wait()
+	// Unblock the listing if tar exited without reading all of it.
+	_ = files.Close()
+	listErr := <-listed
 	zstdErr := zstdCmd.Wait()
-	closeErr := wc.Close()
 
 	var errs []error
-	if tarErr != nil {
+	switch {
+	case listErr != nil && !errors.Is(listErr, io.ErrClosedPipe):
+		// tar reports the same error from reading its truncated input.
+		errs = append(errs, errors.Wrap(listErr, "failed to list files"))
+	case tarErr != nil:
 		errs = append(errs, errors.Errorf("tar failed: %w: %s", tarErr, tarStderr.String()))
 	}
 	if zstdErr != nil {
 		errs = append(errs, errors.Errorf("zstd failed: %w: %s", zstdErr, zstdStderr.String()))
 	}
+	if len(errs) > 0 {
+		cancel()
+	}
+	if closeErr := wc.Close(); closeErr != nil {
+		errs = append(errs, errors.Wrap(closeErr, "failed to close writer"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// CreateFromTar compresses an existing tar stream with zstd and uploads it to the cache.
+//
+// This is the streaming counterpart of [Create] for callers that already have a tar stream, such as one read from
+// stdin, and produces an object that can be restored with either [Restore] or [RestoreToTar].
+func CreateFromTar(ctx context.Context, remote cache.Cache, key cache.Key, r io.Reader, ttl time.Duration) error {
+	headers := make(http.Header)
+	headers.Set("Content-Type", "application/zstd")
+
+	wc, err := remote.Create(ctx, key, headers, ttl)
+	if err != nil {
+		return errors.Wrap(err, "failed to create object")
+	}
+
+	var zstdStderr bytes.Buffer
+	zstdCmd := exec.CommandContext(ctx, "zstd", "-c", "-T0")
+	zstdCmd.Stdin = r
+	zstdCmd.Stdout = wc
+	zstdCmd.Stderr = &zstdStderr
+
+	zstdErr := zstdCmd.Run()
+	closeErr := wc.Close()
+
+	var errs []error
+	if zstdErr != nil {
+		errs = append(errs, errors.Errorf("zstd failed: %w: %s", zstdErr, zstdStderr.String()))
+	}
 	if closeErr != nil {
 		errs = append(errs, errors.Wrap(closeErr, "failed to close writer"))
 	}
@@ -85,6 +203,40 @@ func Create(ctx context.Context, remote cache.Cache, key cache.Key, directory st
 	return errors.Join(errs...)
 }
 
+// listFiles walks directory and writes a NUL-separated list of the paths not excluded by matcher, if any, to w.
+//
+// Excluded directories are not descended into, so as with git, their contents cannot be re-included.
+func listFiles(w io.Writer, directory string, matcher *Matcher) error {
+	files := bufio.NewWriter(w)
+	err := filepath.WalkDir(directory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(directory, path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		rel = filepath.ToSlash(rel)
+		if rel != "." && matcher != nil && matcher.Match(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if rel != "." {
+			rel = "./" + rel
+		}
+		if _, err := files.WriteString(rel); err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(files.WriteByte(0))
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(files.Flush())
+}
+
 // Restore downloads an archive from the cache and extracts it to a directory.
 //
 // The archive is decompressed with zstd and extracted with tar, preserving
@@ -138,3 +290,77 @@ func Restore(ctx context.Context, remote cache.Cache, key cache.Key, directory s
 
 	return errors.Join(errs...)
 }
+
+// RestoreToTar downloads an archive from the cache and returns it as a decompressed tar stream.
+//
+// This is the streaming counterpart of [Restore]. Decompression failures are returned from Read in place of io.EOF,
+// and the caller must Close the returned reader to release the underlying object and zstd process.
+func RestoreToTar(ctx context.Context, remote cache.Cache, key cache.Key) (io.ReadCloser, error) {
+	rc, _, err := remote.Open(ctx, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open object")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	zstdCmd := exec.CommandContext(ctx, "zstd", "-dc", "-T0")
+	zstdCmd.Stdin = rc
+	stdout, err := zstdCmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, errors.Join(errors.Wrap(err, "failed to create zstd stdout pipe"), rc.Close())
+	}
+	tr := &tarReader{cmd: zstdCmd, stdout: stdout, object: rc, cancel: cancel}
+	zstdCmd.Stderr = &tr.stderr
+
+	if err := zstdCmd.Start(); err != nil {
+		cancel()
+		return nil, errors.Join(errors.Wrap(err, "failed to start zstd"), rc.Close())
+	}
+	return tr, nil
+}
+
+// tarReader streams the output of a zstd decompression process.
+type tarReader struct {
+	cmd     *exec.Cmd
+	stdout  io.Reader
+	stderr  bytes.Buffer
+	object  io.Closer
+	cancel  context.CancelFunc
+	waited  bool
+	waitErr error
+}
+
+func (t *tarReader) Read(p []byte) (int, error) {
+	n, err := t.stdout.Read(p)
+	if errors.Is(err, io.EOF) {
+		if waitErr := t.wait(); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err //nolint:wrapcheck // io.EOF must be returned unwrapped
+}
+
+// Close releases the cached object and the zstd process.
+//
+// Closing before the stream is fully read terminates zstd, in which case its exit status is not reported.
+func (t *tarReader) Close() error {
+	var zstdErr error
+	if t.waited {
+		zstdErr = t.waitErr
+	} else {
+		t.cancel()
+		_ = t.wait() //nolint:errcheck // zstd is killed by the cancellation above
+	}
+	t.cancel()
+	return errors.Join(zstdErr, errors.Wrap(t.object.Close(), "failed to close object"))
+}
+
+func (t *tarReader) wait() error {
+	if !t.waited {
+		t.waited = true
+		if err := t.cmd.Wait(); err != nil {
+			t.waitErr = errors.Errorf("zstd failed: %w: %s", err, t.stderr.String())
+		}
+	}
+	return t.waitErr
+}
@@ -1,16 +1,20 @@
 package snapshot_test
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
 	"github.com/alecthomas/assert/v2"
+	"github.com/alecthomas/errors"
 
 	"github.com/block/cachew/internal/cache"
 	"github.com/block/cachew/internal/logging"
@@ -30,12 +34,13 @@ func TestCreateAndRestoreRoundTrip(t *testing.T) {
 	assert.NoError(t, os.Mkdir(filepath.Join(srcDir, "subdir"), 0o755))
 	assert.NoError(t, os.WriteFile(filepath.Join(srcDir, "subdir", "file3.txt"), []byte("content3"), 0o644))
 
-	err = snapshot.Create(ctx, mem, key, srcDir, time.Hour, nil)
+	err = snapshot.Create(ctx, mem, key, srcDir, time.Hour, nil, snapshot.Header("ETag", `"abc"`))
 	assert.NoError(t, err)
 
 	headers, err := mem.Stat(ctx, key)
 	assert.NoError(t, err)
 	assert.Equal(t, "application/zstd", headers.Get("Content-Type"))
+	assert.Equal(t, `"abc"`, headers.Get("ETag"))
 
 	dstDir := t.TempDir()
 	err = snapshot.Restore(ctx, mem, key, dstDir)
@@ -70,8 +75,13 @@ func TestCreateWithExcludePatterns(t *testing.T) {
 	assert.NoError(t, os.WriteFile(filepath.Join(srcDir, "exclude.log"), []byte("excluded"), 0o644))
 	assert.NoError(t, os.Mkdir(filepath.Join(srcDir, "logs"), 0o755))
 	assert.NoError(t, os.WriteFile(filepath.Join(srcDir, "logs", "app.log"), []byte("excluded"), 0o644))
+	assert.NoError(t, os.MkdirAll(filepath.Join(srcDir, "src", "tmp"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(srcDir, "src", "tmp", "keep.txt"), []byte("included"), 0o644))
+	assert.NoError(t, os.Mkdir(filepath.Join(srcDir, "tmp"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(srcDir, "tmp", "scratch.txt"), []byte("excluded"), 0o644))
 
-	err = snapshot.Create(ctx, mem, key, srcDir, time.Hour, []string{"*.log", "logs"})
+	// "./tmp" is only meaningful to tar, which matches it against the root directory alone.
+	err = snapshot.Create(ctx, mem, key, srcDir, time.Hour, []string{"*.log", "logs", "./tmp"})
 	assert.NoError(t, err)
 
 	dstDir := t.TempDir()
@@ -86,6 +96,72 @@ func TestCreateWithExcludePatterns(t *testing.T) {
 
 	_, err = os.Stat(filepath.Join(dstDir, "logs"))
 	assert.IsError(t, err, os.ErrNotExist)
+
+	_, err = os.Stat(filepath.Join(dstDir, "src", "tmp", "keep.txt"))
+	assert.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(dstDir, "tmp"))
+	assert.IsError(t, err, os.ErrNotExist)
+}
+
+func TestCreateWithGitignorePatterns(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		included []string
+		excluded []string
+	}{
+		{
+			name:     "NegationOverridesExclude",
+			patterns: []string{"*.log", "!important.log"},
+			included: []string{"important.log", "src/main.go"},
+			excluded: []string{"debug.log", "src/trace.log"},
+		},
+		{
+			name:     "DirectoryOnly",
+			patterns: []string{"build/"},
+			included: []string{"src/build", "src/main.go"},
+			excluded: []string{"build/out.bin", "src/nested/build/out.bin"},
+		},
+		{
+			name:     "DoubleStar",
+			patterns: []string{"src/**/*.gen.go", "**/tmp"},
+			included: []string{"src/main.go", "main.gen.go"},
+			excluded: []string{"src/main.gen.go", "src/a/b/c/deep.gen.go", "tmp/x", "src/a/tmp/y"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+			mem, err := cache.NewMemory(ctx, cache.MemoryConfig{LimitMB: 100, MaxTTL: time.Hour})
+			assert.NoError(t, err)
+			defer mem.Close()
+			key := cache.Key{1, 2, 3}
+
+			srcDir := t.TempDir()
+			for _, path := range append(slices.Clone(tt.included), tt.excluded...) {
+				full := filepath.Join(srcDir, filepath.FromSlash(path))
+				assert.NoError(t, os.MkdirAll(filepath.Dir(full), 0o755))
+				assert.NoError(t, os.WriteFile(full, []byte(path), 0o644))
+			}
+
+			err = snapshot.Create(ctx, mem, key, srcDir, time.Hour, tt.patterns, snapshot.Gitignore())
+			assert.NoError(t, err)
+
+			dstDir := t.TempDir()
+			err = snapshot.Restore(ctx, mem, key, dstDir)
+			assert.NoError(t, err)
+
+			for _, path := range tt.included {
+				_, err := os.Stat(filepath.Join(dstDir, filepath.FromSlash(path)))
+				assert.NoError(t, err, "%s should be archived", path)
+			}
+			for _, path := range tt.excluded {
+				_, err := os.Stat(filepath.Join(dstDir, filepath.FromSlash(path)))
+				assert.IsError(t, err, os.ErrNotExist, "%s should not be archived", path)
+			}
+		})
+	}
 }
 
 func TestCreatePreservesSymlinks(t *testing.T) {
@@ -276,12 +352,162 @@ func TestCreateSetsCorrectHeaders(t *testing.T) {
 	srcDir := t.TempDir()
 	assert.NoError(t, os.WriteFile(filepath.Join(srcDir, "file.txt"), []byte("content"), 0o644))
 
-	err = snapshot.Create(ctx, mem, key, srcDir, time.Hour, nil)
+	err = snapshot.Create(ctx, mem, key, srcDir, time.Hour, nil, snapshot.Header("ETag", `"abc"`))
 	assert.NoError(t, err)
 
 	headers, err := mem.Stat(ctx, key)
 	assert.NoError(t, err)
 	assert.Equal(t, "application/zstd", headers.Get("Content-Type"))
+	assert.Equal(t, `"abc"`, headers.Get("ETag"))
 	assert.Contains(t, headers.Get("Content-Disposition"), "attachment")
 	assert.Contains(t, headers.Get("Content-Disposition"), ".tar.zst")
 }
+
+func TestCreateFromTarAndRestoreToTarRoundTrip(t *testing.T) {
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+	mem, err := cache.NewMemory(ctx, cache.MemoryConfig{LimitMB: 100, MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer mem.Close()
+	key := cache.Key{1, 2, 3}
+
+	files := map[string]string{"file1.txt": "content1", "subdir/file2.txt": "content2"}
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	for _, name := range []string{"file1.txt", "subdir/file2.txt"} {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(files[name]))}))
+		_, err = tw.Write([]byte(files[name]))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+
+	err = snapshot.CreateFromTar(ctx, mem, key, bytes.NewReader(archive.Bytes()), time.Hour)
+	assert.NoError(t, err)
+
+	rc, headers, err := mem.Open(ctx, key)
+	assert.NoError(t, err)
+	stored, err := io.ReadAll(rc)
+	assert.NoError(t, err)
+	assert.NoError(t, rc.Close())
+	assert.Equal(t, "application/zstd", headers.Get("Content-Type"))
+	assert.True(t, bytes.HasPrefix(stored, []byte{0x28, 0xb5, 0x2f, 0xfd}), "stored object is not zstd-compressed")
+
+	tarStream, err := snapshot.RestoreToTar(ctx, mem, key)
+	assert.NoError(t, err)
+	restored, err := io.ReadAll(tarStream)
+	assert.NoError(t, err)
+	assert.NoError(t, tarStream.Close())
+	assert.Equal(t, archive.Bytes(), restored)
+
+	// The streamed archive can also be restored to a directory.
+	dstDir := t.TempDir()
+	err = snapshot.Restore(ctx, mem, key, dstDir)
+	assert.NoError(t, err)
+	for name, want := range files {
+		content, err := os.ReadFile(filepath.Join(dstDir, name))
+		assert.NoError(t, err)
+		assert.Equal(t, want, string(content))
+	}
+}
+
+func TestRestoreToTarCorruptObject(t *testing.T) {
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+	mem, err := cache.NewMemory(ctx, cache.MemoryConfig{LimitMB: 100, MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer mem.Close()
+	key := cache.Key{1, 2, 3}
+
+	wc, err := mem.Create(ctx, key, nil, time.Hour)
+	assert.NoError(t, err)
+	_, err = wc.Write([]byte("not zstd"))
+	assert.NoError(t, err)
+	assert.NoError(t, wc.Close())
+
+	tarStream, err := snapshot.RestoreToTar(ctx, mem, key)
+	assert.NoError(t, err)
+	_, err = io.ReadAll(tarStream)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "zstd failed")
+	assert.Error(t, tarStream.Close())
+}
+
+func TestRestoreToTarEarlyClose(t *testing.T) {
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+	mem, err := cache.NewMemory(ctx, cache.MemoryConfig{LimitMB: 100, MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer mem.Close()
+	key := cache.Key{1, 2, 3}
+
+	err = snapshot.CreateFromTar(ctx, mem, key, bytes.NewReader(bytes.Repeat([]byte("data"), 1<<20)), time.Hour)
+	assert.NoError(t, err)
+
+	tarStream, err := snapshot.RestoreToTar(ctx, mem, key)
+	assert.NoError(t, err)
+	_, err = tarStream.Read(make([]byte, 16))
+	assert.NoError(t, err)
+	assert.NoError(t, tarStream.Close())
+}
+
+func TestRestoreToTarNonexistentKey(t *testing.T) {
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+	mem, err := cache.NewMemory(ctx, cache.MemoryConfig{LimitMB: 100, MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer mem.Close()
+
+	_, err = snapshot.RestoreToTar(ctx, mem, cache.Key{1, 2, 3})
+	assert.Error(t, err)
+}
+
+func TestCreateReproducible(t *testing.T) {
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+	mem, err := cache.NewMemory(ctx, cache.MemoryConfig{LimitMB: 100, MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer mem.Close()
+
+	srcDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(srcDir, "b.txt"), []byte("b"), 0o664))
+	assert.NoError(t, os.Mkdir(filepath.Join(srcDir, "dir"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(srcDir, "dir", "a.sh"), []byte("#!/bin/sh"), 0o755))
+	assert.NoError(t, os.Symlink("b.txt", filepath.Join(srcDir, "link")))
+
+	read := func(key cache.Key) []byte {
+		t.Helper()
+		rc, _, err := mem.Open(ctx, key)
+		assert.NoError(t, err)
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		assert.NoError(t, err)
+		return data
+	}
+
+	first := cache.Key{1}
+	assert.NoError(t, snapshot.Create(ctx, mem, first, srcDir, time.Hour, nil, snapshot.Reproducible()))
+
+	// Touching the tree must not change the archive.
+	later := time.Now().Add(time.Hour)
+	for _, name := range []string{"b.txt", "dir", "dir/a.sh"} {
+		assert.NoError(t, os.Chtimes(filepath.Join(srcDir, name), later, later))
+	}
+	second := cache.Key{2}
+	assert.NoError(t, snapshot.Create(ctx, mem, second, srcDir, time.Hour, nil, snapshot.Reproducible()))
+	assert.Equal(t, read(first), read(second))
+
+	tarStream, err := snapshot.RestoreToTar(ctx, mem, first)
+	assert.NoError(t, err)
+	defer tarStream.Close()
+	tr := tar.NewReader(tarStream)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NoError(t, err)
+		names = append(names, hdr.Name)
+		assert.Equal(t, int64(0), hdr.ModTime.Unix(), "%s", hdr.Name)
+		assert.Equal(t, 0, hdr.Uid, "%s", hdr.Name)
+		assert.Equal(t, 0, hdr.Gid, "%s", hdr.Name)
+		assert.Equal(t, "", hdr.Uname, "%s", hdr.Name)
+		assert.Equal(t, int64(0), hdr.Mode&0o022, "%s", hdr.Name)
+	}
+	assert.Equal(t, []string{"./", "./b.txt", "./dir/", "./dir/a.sh", "./link"}, names)
+}
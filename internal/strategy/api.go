@@ -4,6 +4,7 @@ package strategy
 import (
 	"context"
 	"net/http"
+	"net/url"
 	"os"
 
 	"github.com/alecthomas/errors"
@@ -31,34 +32,86 @@ func NewRegistry() *Registry {
 }
 
 type registryEntry struct {
-	schema  *hcl.Block
-	factory func(ctx context.Context, config *hcl.Block, cache cache.Cache, mux Mux, vars map[string]string) (Strategy, error)
+	schema          *hcl.Block
+	requiresRestart bool
+	rawKeys         bool
+	factory         func(ctx context.Context, config *hcl.Block, cache cache.Cache, mux Mux, vars map[string]string) (Strategy, error)
+	validate        func(config *hcl.Block, vars map[string]string) error
 }
 
 type Factory[Config any, S Strategy] func(ctx context.Context, config Config, cache cache.Cache, mux Mux) (S, error)
 
+// blockConfig is the configuration accepted in the block of a strategy: its own configuration, and the
+// [BudgetConfig] and [NamespaceConfig] common to all strategies.
+type blockConfig[Config any] struct {
+	Config    Config          `hcl:",embed"`
+	Budget    BudgetConfig    `hcl:",embed"`
+	Namespace NamespaceConfig `hcl:",embed"`
+}
+
+// RegisterOption configures how a strategy is registered.
+type RegisterOption func(*registryEntry)
+
+// RequiresRestart marks a strategy that starts background work, such as scheduled jobs, which would be duplicated by
+// creating it again when the configuration is reloaded.
+func RequiresRestart() RegisterOption {
+	return func(e *registryEntry) { e.requiresRestart = true }
+}
+
+// RawKeys marks a strategy whose keys are chosen by its clients, such as the object API, which must address objects
+// by the keys it is given rather than in a [NamespaceConfig].
+func RawKeys() RegisterOption {
+	return func(e *registryEntry) { e.rawKeys = true }
+}
+
 // Register a new proxy strategy.
-func Register[Config any, S Strategy](r *Registry, id, description string, factory Factory[Config, S]) {
-	var c Config
+//
+// The handlers the strategy registers with its mux are wrapped to enforce the [BudgetConfig] in its block, and unless
+// registered with [RawKeys] its cache is isolated in the [NamespaceConfig] of its block.
+func Register[Config any, S Strategy](r *Registry, id, description string, factory Factory[Config, S], options ...RegisterOption) {
+	var c blockConfig[Config]
 	schema, err := hcl.BlockSchema(id, &c)
 	if err != nil {
 		panic(err)
 	}
 	block := schema.Entries[0].(*hcl.Block) //nolint:errcheck // This seems spurious
 	block.Comments = hcl.CommentList{description}
-	r.registry[id] = registryEntry{
-		schema: block,
-		factory: func(ctx context.Context, config *hcl.Block, cache cache.Cache, mux Mux, vars map[string]string) (Strategy, error) {
-			var cfg Config
-			transformer := func(defaultValue string) string {
-				return os.Expand(defaultValue, func(key string) string { return vars[key] })
-			}
-			if err := hcl.UnmarshalBlock(config, &cfg, hcl.AllowExtra(false), hcl.WithDefaultTransformer(transformer)); err != nil {
-				return nil, errors.WithStack(err)
+	unmarshal := func(config *hcl.Block, vars map[string]string) (blockConfig[Config], error) {
+		var cfg blockConfig[Config]
+		transformer := func(defaultValue string) string {
+			return os.Expand(defaultValue, func(key string) string { return vars[key] })
+		}
+		err := hcl.UnmarshalBlock(config, &cfg, hcl.AllowExtra(false), hcl.WithDefaultTransformer(transformer))
+		return cfg, errors.WithStack(err)
+	}
+	entry := registryEntry{schema: block}
+	for _, option := range options {
+		option(&entry)
+	}
+	entry.factory = func(ctx context.Context, config *hcl.Block, cache cache.Cache, mux Mux, vars map[string]string) (Strategy, error) {
+		cfg, err := unmarshal(config, vars)
+		if err != nil {
+			return nil, err
+		}
+		if !entry.rawKeys {
+			if cache, err = cfg.Namespace.Cache(config, cache); err != nil {
+				return nil, err
 			}
-			return factory(ctx, cfg, cache, mux)
-		},
+		}
+		return factory(ctx, cfg.Config, cache, &budgetMux{mux: mux, budget: cfg.Budget})
+	}
+	entry.validate = func(config *hcl.Block, vars map[string]string) error {
+		cfg, err := unmarshal(config, vars)
+		if err != nil {
+			return err
+		}
+		errs := []error{cfg.Budget.Validate()}
+		if v, ok := any(&cfg.Config).(cache.Validator); ok {
+			errs = append(errs, v.Validate())
+		}
+		return errors.Join(errs...)
 	}
+	r.registry[id] = entry
 }
 
 // Schema returns the schema for all registered strategies.
@@ -75,6 +128,23 @@ func (r *Registry) Exists(name string) bool {
 	return ok
 }
 
+// Reloadable returns true if the named strategy can be created again when the configuration is reloaded, replacing
+// an existing instance. See [RequiresRestart].
+func (r *Registry) Reloadable(name string) bool {
+	entry, ok := r.registry[name]
+	return ok && !entry.requiresRestart
+}
+
+// Validate the configuration of the named strategy without constructing it.
+//
+// Will return "ErrNotFound" if the strategy is not found.
+func (r *Registry) Validate(name string, config *hcl.Block, vars map[string]string) error {
+	if entry, ok := r.registry[name]; ok {
+		return entry.validate(config, vars)
+	}
+	return errors.Errorf("%s: %w", name, ErrNotFound)
+}
+
 // Create a new proxy strategy.
 //
 // Will return "ErrNotFound" if the strategy is not found.
@@ -95,3 +165,18 @@ func (r *Registry) Create(
 type Strategy interface {
 	String() string
 }
+
+// validateURL checks that value, the setting named field, is an absolute http(s) URL.
+func validateURL(field, value string) error {
+	if value == "" {
+		return errors.Errorf("%s is required", field)
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return errors.Errorf("%s: %w", field, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.Errorf("%s must be an absolute http(s) URL, got %q", field, value)
+	}
+	return nil
+}
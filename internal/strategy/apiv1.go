@@ -4,39 +4,77 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"maps"
+	"mime"
 	"net/http"
 	"os"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/httputil"
 	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/snapshot"
 )
 
-func RegisterAPIV1(r *Registry) {
-	Register(r, "apiv1", "The stable API of the cache server.", NewAPIV1)
+// RegisterAPIV1 registers the API strategy. Administrative endpoints are restricted to requests authorized by
+// authorizer, signed URLs are verified with signingKey, and resumable uploads are configured by uploads.
+func RegisterAPIV1(r *Registry, authorizer httputil.Authorizer, signingKey string, uploads UploadConfig) {
+	Register(r, "apiv1", "The stable API of the cache server.", func(ctx context.Context, config struct{}, cache cache.Cache, mux Mux) (*APIV1, error) {
+		return NewAPIV1(ctx, config, cache, mux, authorizer, signingKey, uploads)
+	}, RawKeys())
 }
 
 var _ Strategy = (*APIV1)(nil)
 
 // The APIV1 strategy represents v1 of the proxy API.
 type APIV1 struct {
-	cache  cache.Cache
-	logger *slog.Logger
+	cache      cache.Cache
+	logger     *slog.Logger
+	signingKey []byte
+	uploads    *uploadSessions
 }
 
-func NewAPIV1(ctx context.Context, _ struct{}, cache cache.Cache, mux Mux) (*APIV1, error) {
+// NewAPIV1 creates the API strategy.
+//
+// If signingKey is not empty, objects are also served to holders of a signed URL minted with [cache.SignKey], at
+// "/_signed/{token}", regardless of any other authorization.
+func NewAPIV1(ctx context.Context, _ struct{}, cache cache.Cache, mux Mux, authorizer httputil.Authorizer, signingKey string, uploads UploadConfig) (*APIV1, error) {
+	sessions, err := newUploadSessions(uploads)
+	if err != nil {
+		return nil, err
+	}
 	s := &APIV1{
-		logger: logging.FromContext(ctx),
-		cache:  cache,
+		logger:     logging.FromContext(ctx),
+		cache:      cache,
+		signingKey: []byte(signingKey),
+		uploads:    sessions,
 	}
 	mux.Handle("GET /api/v1/object/{key}", http.HandlerFunc(s.getObject))
 	mux.Handle("HEAD /api/v1/object/{key}", http.HandlerFunc(s.statObject))
 	mux.Handle("POST /api/v1/object/{key}", http.HandlerFunc(s.putObject))
+	mux.Handle("PATCH /api/v1/object/{key}", http.HandlerFunc(s.touchObject))
 	mux.Handle("DELETE /api/v1/object/{key}", http.HandlerFunc(s.deleteObject))
-	mux.Handle("GET /api/v1/stats", http.HandlerFunc(s.getStats))
+	mux.Handle("HEAD /api/v1/upload/{key}", http.HandlerFunc(s.statUpload))
+	mux.Handle("PUT /api/v1/upload/{key}", http.HandlerFunc(s.putUploadChunk))
+	mux.Handle("POST /api/v1/upload/{key}", http.HandlerFunc(s.completeUpload))
+	mux.Handle("DELETE /api/v1/upload/{key}", http.HandlerFunc(s.abortUpload))
+	mux.Handle("GET /api/v1/snapshot/{key}/{path...}", http.HandlerFunc(s.getSnapshotSubtree))
+	mux.Handle("GET /api/v1/stats", httputil.RequireAuthorization(authorizer, http.HandlerFunc(s.getStats)))
+	mux.Handle("GET /_cache", httputil.RequireAuthorization(authorizer, http.HandlerFunc(s.listTagged)))
+	mux.Handle("DELETE /_cache", httputil.RequireAuthorization(authorizer, http.HandlerFunc(s.purge)))
+	mux.Handle("GET /_cache/keys", httputil.RequireAuthorization(authorizer, http.HandlerFunc(s.listKeys)))
+	mux.Handle("GET /_cache/epoch", httputil.RequireAuthorization(authorizer, http.HandlerFunc(s.getEpoch)))
+	mux.Handle("POST /_cache/epoch", httputil.RequireAuthorization(authorizer, http.HandlerFunc(s.advanceEpoch)))
+	mux.Handle("POST /_cache/has", httputil.RequireAuthorization(authorizer, http.HandlerFunc(s.hasObjects)))
+	if signingKey != "" {
+		mux.Handle("GET /_signed/{token}", http.HandlerFunc(s.getSignedObject))
+	}
 	return s, nil
 }
 
@@ -69,7 +107,24 @@ func (d *APIV1) getObject(w http.ResponseWriter, r *http.Request) {
 		d.httpError(w, http.StatusBadRequest, err, "Invalid key")
 		return
 	}
+	d.serveObject(w, r, key)
+}
+
+// getSignedObject serves the object granted by a signed URL.
+func (d *APIV1) getSignedObject(w http.ResponseWriter, r *http.Request) {
+	key, err := cache.VerifySignedKey(d.signingKey, r.PathValue("token"))
+	switch {
+	case errors.Is(err, cache.ErrSignatureExpired):
+		http.Error(w, "Signed URL has expired", http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, "Invalid signed URL", http.StatusForbidden)
+		return
+	}
+	d.serveObject(w, r, key)
+}
 
+func (d *APIV1) serveObject(w http.ResponseWriter, r *http.Request, key cache.Key) {
 	cr, headers, err := d.cache.Open(r.Context(), key)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
@@ -91,43 +146,155 @@ func (d *APIV1) getObject(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// getSnapshotSubtree streams part of a snapshot, created with the snapshot package, as a zip or tar.gz archive
+// selected by the "format" query parameter.
+func (d *APIV1) getSnapshotSubtree(w http.ResponseWriter, r *http.Request) {
+	key, err := cache.ParseKey(r.PathValue("key"))
+	if err != nil {
+		d.httpError(w, http.StatusBadRequest, err, "Invalid key")
+		return
+	}
+	format, err := snapshot.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		d.httpError(w, http.StatusBadRequest, err, "Invalid format")
+		return
+	}
+
+	dir := r.PathValue("path")
+	name := path.Base("/" + dir)
+	if name == "/" {
+		name = key.String()
+	}
+	aw := &archiveResponse{ResponseWriter: w, contentType: format.ContentType(), filename: name + "." + string(format)}
+	err = snapshot.WriteSubtree(r.Context(), d.cache, key, dir, format, aw)
+	switch {
+	case err == nil:
+	case aw.started:
+		// The status has already been sent, so the only way to signal failure is to abort the response.
+		d.logger.Error("Failed to stream snapshot archive", slog.String("error", err.Error()), slog.String("key", key.String()))
+		panic(http.ErrAbortHandler)
+	case errors.Is(err, os.ErrNotExist):
+		http.Error(w, "Snapshot path not found", http.StatusNotFound)
+	default:
+		d.httpError(w, http.StatusInternalServerError, err, "Failed to read snapshot", slog.String("key", key.String()))
+	}
+}
+
+// archiveResponse sets the archive headers on the first write, so that errors before any of the archive is generated
+// can still be reported with a status code.
+type archiveResponse struct {
+	http.ResponseWriter
+	contentType string
+	filename    string
+	started     bool
+}
+
+func (a *archiveResponse) Write(p []byte) (int, error) {
+	if !a.started {
+		a.started = true
+		a.Header().Set("Content-Type", a.contentType)
+		a.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.filename}))
+	}
+	return a.ResponseWriter.Write(p) //nolint:wrapcheck
+}
+
 func (d *APIV1) putObject(w http.ResponseWriter, r *http.Request) {
 	key, err := cache.ParseKey(r.PathValue("key"))
 	if err != nil {
 		d.httpError(w, http.StatusBadRequest, err, "Invalid key")
 		return
 	}
+	cw, ok := d.createObject(w, r, key)
+	if !ok {
+		return
+	}
+	d.writeObject(w, cw, r.Body)
+}
 
+// createObject creates the object at key with the headers, Time-To-Live and "If-None-Match" precondition of r,
+// responding with an error and returning false if it cannot be created.
+func (d *APIV1) createObject(w http.ResponseWriter, r *http.Request, key cache.Key) (io.WriteCloser, bool) {
 	var ttl time.Duration
+	var err error
 	ttlh := r.Header.Get("Time-To-Live")
 	if ttlh != "" {
 		ttl, err = time.ParseDuration(ttlh)
 		if err != nil {
 			d.httpError(w, http.StatusBadRequest, err, "Invalid Time-To-Live header format, must be in Go duration format eg. 1h")
-			return
+			return nil, false
 		}
 	}
 
+	if err := validateTags(r.Header); err != nil {
+		d.httpError(w, http.StatusBadRequest, err, "Invalid "+cache.TagHeader+" header")
+		return nil, false
+	}
+
 	// Extract and filter headers from request
 	headers := cache.FilterTransportHeaders(r.Header)
 
-	cw, err := d.cache.Create(r.Context(), key, headers, ttl)
-	if err != nil {
+	// "If-None-Match: *" only creates the object if it does not already exist, so that the first writer wins.
+	create := d.cache.Create
+	if r.Header.Get("If-None-Match") == "*" {
+		create = func(ctx context.Context, key cache.Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+			return cache.CreateExclusive(ctx, d.cache, key, headers, ttl)
+		}
+	}
+	cw, err := create(r.Context(), key, headers, ttl)
+	if errors.Is(err, cache.ErrExists) {
+		http.Error(w, "Cache object already exists", http.StatusPreconditionFailed)
+		return nil, false
+	} else if err != nil {
 		d.httpError(w, http.StatusInternalServerError, err, "Failed to create cache writer", slog.String("key", key.String()))
-		return
+		return nil, false
 	}
+	return cw, true
+}
 
-	if _, err := io.Copy(cw, r.Body); err != nil {
+// writeObject copies body to an object created with createObject, committing it.
+func (d *APIV1) writeObject(w http.ResponseWriter, cw io.WriteCloser, body io.Reader) {
+	if _, err := io.Copy(cw, body); err != nil {
 		d.httpError(w, http.StatusInternalServerError, err, "Failed to copy request body to cache writer")
 		return
 	}
 
-	if err := cw.Close(); err != nil {
+	if err := cw.Close(); errors.Is(err, cache.ErrExists) {
+		http.Error(w, "Cache object already exists", http.StatusPreconditionFailed)
+		return
+	} else if err != nil {
 		d.httpError(w, http.StatusInternalServerError, err, "Failed to close cache writer")
 		return
 	}
 }
 
+// touchObject resets the expiry of an object to the Time-To-Live header, or the maximum TTL if absent.
+func (d *APIV1) touchObject(w http.ResponseWriter, r *http.Request) {
+	key, err := cache.ParseKey(r.PathValue("key"))
+	if err != nil {
+		d.httpError(w, http.StatusBadRequest, err, "Invalid key")
+		return
+	}
+
+	var ttl time.Duration
+	if ttlh := r.Header.Get("Time-To-Live"); ttlh != "" {
+		ttl, err = time.ParseDuration(ttlh)
+		if err != nil {
+			d.httpError(w, http.StatusBadRequest, err, "Invalid Time-To-Live header format, must be in Go duration format eg. 1h")
+			return
+		}
+	}
+
+	err = d.cache.Touch(r.Context(), key, ttl)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, "Cache object not found", http.StatusNotFound)
+			return
+		}
+		d.httpError(w, http.StatusInternalServerError, err, "Failed to touch cache object", slog.String("key", key.String()))
+		return
+	}
+}
+
 func (d *APIV1) deleteObject(w http.ResponseWriter, r *http.Request) {
 	key, err := cache.ParseKey(r.PathValue("key"))
 	if err != nil {
@@ -146,6 +313,10 @@ func (d *APIV1) deleteObject(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// getStats returns the object count, size and capacity of the cache.
+//
+// It is restricted to authorized requests as it may be expensive: the S3 backend lists the whole bucket to compute
+// them, taking a request per thousand objects.
 func (d *APIV1) getStats(w http.ResponseWriter, r *http.Request) {
 	stats, err := d.cache.Stats(r.Context())
 	if err != nil {
@@ -163,6 +334,206 @@ func (d *APIV1) getStats(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// validateTags returns an error if the tags in the [cache.TagHeader] headers are invalid, or too many.
+func validateTags(headers http.Header) error {
+	count := 0
+	for _, value := range headers.Values(cache.TagHeader) {
+		for _, tag := range strings.Split(value, ",") {
+			if err := cache.ValidateTag(strings.TrimSpace(tag)); err != nil {
+				return err
+			}
+			count++
+		}
+	}
+	if count > cache.MaxTags {
+		return fmt.Errorf("at most %d tags may be set, got %d", cache.MaxTags, count)
+	}
+	return nil
+}
+
+// listTagged lists the objects created with the tag in the "tag" query parameter, as JSON.
+func (d *APIV1) listTagged(w http.ResponseWriter, r *http.Request) {
+	tag := r.URL.Query().Get("tag")
+	if err := cache.ValidateTag(tag); err != nil {
+		d.httpError(w, http.StatusBadRequest, err, "Invalid tag")
+		return
+	}
+	objects, err := cache.ListTagged(r.Context(), d.cache, tag)
+	if err != nil {
+		if errors.Is(err, cache.ErrListUnavailable) {
+			d.httpError(w, http.StatusNotImplemented, err, "Listing not available for this cache backend")
+			return
+		}
+		d.httpError(w, http.StatusInternalServerError, err, "Failed to list cache objects", slog.String("tag", tag))
+		return
+	}
+	if objects == nil {
+		objects = []cache.ObjectInfo{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(objects); err != nil {
+		d.logger.Error("Failed to encode list response", slog.String("error", err.Error()))
+	}
+}
+
+// listKeys streams the objects whose keys start with the "prefix" query parameter, as newline-delimited JSON.
+//
+// If listing fails after the response has started, it is aborted so that the client does not mistake a partial
+// listing for a complete one.
+func (d *APIV1) listKeys(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	// Errors before the first object replace the content type.
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	started := false
+	for object, err := range cache.ListKeys(r.Context(), d.cache, prefix) {
+		if err != nil {
+			switch {
+			case started:
+				d.logger.Error("Failed to list cache objects", slog.String("prefix", prefix), slog.String("error", err.Error()))
+				panic(http.ErrAbortHandler)
+			case errors.Is(err, cache.ErrInvalidKeyPrefix):
+				d.httpError(w, http.StatusBadRequest, err, "Invalid prefix, must be hexadecimal")
+			case errors.Is(err, cache.ErrListUnavailable):
+				d.httpError(w, http.StatusNotImplemented, err, "Listing not available for this cache backend")
+			default:
+				d.httpError(w, http.StatusInternalServerError, err, "Failed to list cache objects", slog.String("prefix", prefix))
+			}
+			return
+		}
+		started = true
+		if err := enc.Encode(&object); err != nil {
+			return
+		}
+	}
+}
+
+// purge removes objects in bulk, selected by the "olderThan" (a Go duration), "targetBytes" and "tag" query
+// parameters. A tag may be combined with olderThan, but not with targetBytes.
+func (d *APIV1) purge(w http.ResponseWriter, r *http.Request) {
+	var options cache.PurgeOptions
+	query := r.URL.Query()
+	if olderThan := query.Get("olderThan"); olderThan != "" {
+		var err error
+		options.OlderThan, err = time.ParseDuration(olderThan)
+		if err != nil || options.OlderThan <= 0 {
+			http.Error(w, "Invalid olderThan, must be a positive Go duration eg. 24h", http.StatusBadRequest)
+			return
+		}
+	}
+	if targetBytes := query.Get("targetBytes"); targetBytes != "" {
+		var err error
+		options.TargetBytes, err = strconv.ParseInt(targetBytes, 10, 64)
+		if err != nil || options.TargetBytes <= 0 {
+			http.Error(w, "Invalid targetBytes, must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+	if query.Has("tag") {
+		options.Tag = query.Get("tag")
+		if err := cache.ValidateTag(options.Tag); err != nil {
+			d.httpError(w, http.StatusBadRequest, err, "Invalid tag")
+			return
+		}
+		if options.TargetBytes > 0 {
+			http.Error(w, "tag cannot be combined with targetBytes", http.StatusBadRequest)
+			return
+		}
+	}
+	if options == (cache.PurgeOptions{}) {
+		http.Error(w, "One of olderThan, targetBytes or tag is required", http.StatusBadRequest)
+		return
+	}
+
+	result, err := cache.Purge(r.Context(), d.cache, options)
+	if err != nil {
+		if errors.Is(err, cache.ErrPurgeUnavailable) {
+			d.httpError(w, http.StatusNotImplemented, err, "Purge not available for this cache backend")
+			return
+		}
+		d.httpError(w, http.StatusInternalServerError, err, "Failed to purge cache")
+		return
+	}
+	d.logger.InfoContext(r.Context(), "Purged cache", "older_than", options.OlderThan, "target_bytes", options.TargetBytes,
+		"tag", options.Tag, "objects", result.Objects, "bytes", result.Bytes)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		d.logger.Error("Failed to encode purge response", slog.String("error", err.Error()))
+	}
+}
+
+// epochResponse is the JSON response of the epoch endpoints.
+type epochResponse struct {
+	Epoch uint64 `json:"epoch"`
+}
+
+// getEpoch returns the current cache epoch, as JSON.
+func (d *APIV1) getEpoch(w http.ResponseWriter, r *http.Request) {
+	epoch, err := cache.CurrentEpoch(r.Context(), d.cache)
+	d.writeEpoch(w, epoch, err)
+}
+
+// advanceEpoch advances the cache epoch, invalidating every cached object across all instances sharing the cache,
+// and returns the new epoch as JSON.
+func (d *APIV1) advanceEpoch(w http.ResponseWriter, r *http.Request) {
+	epoch, err := cache.AdvanceEpoch(r.Context(), d.cache)
+	d.writeEpoch(w, epoch, err)
+}
+
+func (d *APIV1) writeEpoch(w http.ResponseWriter, epoch uint64, err error) {
+	if err != nil {
+		if errors.Is(err, cache.ErrEpochUnavailable) {
+			d.httpError(w, http.StatusNotImplemented, err, "Epoch not available, the epoch cache decorator is not configured")
+			return
+		}
+		d.httpError(w, http.StatusInternalServerError, err, "Failed to access cache epoch")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(epochResponse{Epoch: epoch}); err != nil {
+		d.logger.Error("Failed to encode epoch response", slog.String("error", err.Error()))
+	}
+}
+
+// maxHasKeys limits the number of keys in a single batch existence check.
+const maxHasKeys = 10000
+
+// hasObjects checks for the existence of a JSON list of keys, returning a JSON object mapping each key, as given, to
+// whether it is cached.
+func (d *APIV1) hasObjects(w http.ResponseWriter, r *http.Request) {
+	var keys []string
+	if err := json.NewDecoder(r.Body).Decode(&keys); err != nil {
+		d.httpError(w, http.StatusBadRequest, err, "Invalid request body, must be a JSON list of keys")
+		return
+	}
+	if len(keys) > maxHasKeys {
+		http.Error(w, "Too many keys, at most "+strconv.Itoa(maxHasKeys)+" may be checked at once", http.StatusBadRequest)
+		return
+	}
+
+	result := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		key, err := cache.ParseKey(k)
+		if err != nil {
+			d.httpError(w, http.StatusBadRequest, err, "Invalid key")
+			return
+		}
+		ok, err := d.cache.Has(r.Context(), key)
+		if err != nil {
+			d.httpError(w, http.StatusInternalServerError, err, "Failed to check cache object", slog.String("key", key.String()))
+			return
+		}
+		result[k] = ok
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		d.logger.Error("Failed to encode has response", slog.String("error", err.Error()))
+	}
+}
+
 func (d *APIV1) httpError(w http.ResponseWriter, code int, err error, message string, args ...any) {
 	args = append(args, slog.String("error", err.Error()))
 	d.logger.Error(message, args...)
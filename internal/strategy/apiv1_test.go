@@ -0,0 +1,485 @@
+package strategy_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/httputil"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/snapshot"
+	"github.com/block/cachew/internal/strategy"
+)
+
+func TestAPIV1Purge(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		token         string
+		expectStatus  int
+		expectObjects int64
+		expectKept    []string
+	}{
+		{name: "OlderThan", query: "olderThan=50ms", token: "secret", expectStatus: http.StatusOK, expectObjects: 2, expectKept: []string{"new"}},
+		{name: "TargetBytes", query: "targetBytes=2000", token: "secret", expectStatus: http.StatusOK, expectObjects: 1, expectKept: []string{"old2", "new"}},
+		{name: "NoParameters", token: "secret", expectStatus: http.StatusBadRequest, expectKept: []string{"old1", "old2", "new"}},
+		{name: "InvalidDuration", query: "olderThan=yesterday", token: "secret", expectStatus: http.StatusBadRequest, expectKept: []string{"old1", "old2", "new"}},
+		{name: "Tag", query: "tag=ci", token: "secret", expectStatus: http.StatusOK, expectObjects: 1, expectKept: []string{"old2", "new"}},
+		{name: "TagOlderThan", query: "tag=ci&olderThan=1h", token: "secret", expectStatus: http.StatusOK, expectKept: []string{"old1", "old2", "new"}},
+		{name: "TagTargetBytes", query: "tag=ci&targetBytes=2000", token: "secret", expectStatus: http.StatusBadRequest, expectKept: []string{"old1", "old2", "new"}},
+		{name: "InvalidTag", query: "tag=", token: "secret", expectStatus: http.StatusBadRequest, expectKept: []string{"old1", "old2", "new"}},
+		{name: "Unauthorized", query: "olderThan=50ms", expectStatus: http.StatusUnauthorized, expectKept: []string{"old1", "old2", "new"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+			memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+			assert.NoError(t, err)
+			defer memCache.Close()
+
+			mux := http.NewServeMux()
+			_, err = strategy.NewAPIV1(ctx, struct{}{}, memCache, mux, httputil.NewTokenAuthorizer([]string{"secret"}), "", strategy.UploadConfig{})
+			assert.NoError(t, err)
+
+			for _, name := range []string{"old1", "old2", "new"} {
+				if name == "new" {
+					time.Sleep(100 * time.Millisecond)
+				}
+				var headers http.Header
+				if name == "old1" {
+					headers = http.Header{cache.TagHeader: {"ci"}}
+				}
+				w, err := memCache.Create(ctx, cache.NewKey(name), headers, 0)
+				assert.NoError(t, err)
+				_, err = w.Write([]byte(strings.Repeat("x", 1000)))
+				assert.NoError(t, err)
+				assert.NoError(t, w.Close())
+			}
+
+			req := httptest.NewRequestWithContext(ctx, http.MethodDelete, "/_cache?"+tt.query, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			assert.Equal(t, tt.expectStatus, w.Code, "%s", w.Body.String())
+
+			if tt.expectStatus == http.StatusOK {
+				var result cache.PurgeResult
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+				assert.Equal(t, cache.PurgeResult{Objects: tt.expectObjects, Bytes: tt.expectObjects * 1000}, result)
+			}
+			stats, err := memCache.Stats(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(len(tt.expectKept)), stats.Objects)
+			for _, name := range tt.expectKept {
+				_, err := memCache.Stat(ctx, cache.NewKey(name))
+				assert.NoError(t, err, "%s should not have been purged", name)
+			}
+		})
+	}
+}
+
+func TestAPIV1ListTagged(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer memCache.Close()
+
+	mux := http.NewServeMux()
+	_, err = strategy.NewAPIV1(ctx, struct{}{}, memCache, mux, nil, "", strategy.UploadConfig{})
+	assert.NoError(t, err)
+
+	put := func(name string, tags ...string) int {
+		t.Helper()
+		key := cache.NewKey(name)
+		req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/api/v1/object/"+key.String(), strings.NewReader(name))
+		for _, tag := range tags {
+			req.Header.Add(cache.TagHeader, tag)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, put("a", "ci", "run-1"))
+	assert.Equal(t, http.StatusOK, put("bb", "ci,run-2"))
+	assert.Equal(t, http.StatusOK, put("ccc"))
+	assert.Equal(t, http.StatusBadRequest, put("d", "not a tag"))
+	assert.Equal(t, http.StatusBadRequest, put("e", "1,2,3,4,5,6,7,8,9,10,11"))
+
+	list := func(query string) (int, []cache.ObjectInfo) {
+		t.Helper()
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/_cache?"+query, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		var objects []cache.ObjectInfo
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &objects))
+		}
+		return w.Code, objects
+	}
+	status, objects := list("tag=ci")
+	assert.Equal(t, http.StatusOK, status)
+	slices.SortFunc(objects, func(a, b cache.ObjectInfo) int { return cmp.Compare(a.Size, b.Size) })
+	assert.Equal(t, []cache.ObjectInfo{{Key: cache.NewKey("a"), Size: 1}, {Key: cache.NewKey("bb"), Size: 2}}, objects)
+	status, objects = list("tag=run-2")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []cache.ObjectInfo{{Key: cache.NewKey("bb"), Size: 2}}, objects)
+	status, objects = list("tag=missing")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []cache.ObjectInfo{}, objects)
+	status, _ = list("")
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestAPIV1ListKeys(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer memCache.Close()
+
+	mux := http.NewServeMux()
+	_, err = strategy.NewAPIV1(ctx, struct{}{}, memCache, mux, nil, "", strategy.UploadConfig{})
+	assert.NoError(t, err)
+
+	for _, name := range []string{"a", "bb"} {
+		key := cache.NewKey(name)
+		req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/api/v1/object/"+key.String(), strings.NewReader(name))
+		req.Header.Set("Content-Type", "text/plain")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	list := func(query string) (int, []cache.ObjectInfo) {
+		t.Helper()
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/_cache/keys?"+query, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		var objects []cache.ObjectInfo
+		if w.Code == http.StatusOK {
+			assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+			dec := json.NewDecoder(w.Body)
+			for dec.More() {
+				var object cache.ObjectInfo
+				assert.NoError(t, dec.Decode(&object))
+				objects = append(objects, object)
+			}
+		}
+		return w.Code, objects
+	}
+	status, objects := list("")
+	assert.Equal(t, http.StatusOK, status)
+	slices.SortFunc(objects, func(a, b cache.ObjectInfo) int { return cmp.Compare(a.Size, b.Size) })
+	assert.Equal(t, 2, len(objects))
+	assert.Equal(t, cache.NewKey("a"), objects[0].Key)
+	assert.Equal(t, int64(1), objects[0].Size)
+	assert.Equal(t, "text/plain", objects[0].Headers.Get("Content-Type"))
+	assert.Equal(t, cache.NewKey("bb"), objects[1].Key)
+
+	key := cache.NewKey("bb")
+	status, objects = list("prefix=" + key.String()[:6])
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 1, len(objects))
+	assert.Equal(t, key, objects[0].Key)
+
+	status, objects = list("prefix=ffffffffffffffff")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 0, len(objects))
+
+	status, _ = list("prefix=xyz")
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestAPIV1Epoch(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer memCache.Close()
+
+	epochRequest := func(mux *http.ServeMux, method string) (int, uint64) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequestWithContext(ctx, method, "/_cache/epoch", nil))
+		var response struct {
+			Epoch uint64 `json:"epoch"`
+		}
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		}
+		return w.Code, response.Epoch
+	}
+
+	t.Run("Unavailable", func(t *testing.T) {
+		mux := http.NewServeMux()
+		_, err := strategy.NewAPIV1(ctx, struct{}{}, memCache, mux, nil, "", strategy.UploadConfig{})
+		assert.NoError(t, err)
+		status, _ := epochRequest(mux, http.MethodPost)
+		assert.Equal(t, http.StatusNotImplemented, status)
+	})
+
+	t.Run("InvalidatesAcrossInstances", func(t *testing.T) {
+		var muxes []*http.ServeMux
+		for range 2 {
+			epochCache, err := cache.NewEpochCache(ctx, memCache, time.Hour)
+			assert.NoError(t, err)
+			mux := http.NewServeMux()
+			_, err = strategy.NewAPIV1(ctx, struct{}{}, epochCache, mux, nil, "", strategy.UploadConfig{})
+			assert.NoError(t, err)
+			muxes = append(muxes, mux)
+		}
+
+		key := cache.NewKey("object")
+		req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/api/v1/object/"+key.String(), strings.NewReader("content"))
+		w := httptest.NewRecorder()
+		muxes[0].ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		getObject := func(mux *http.ServeMux) int {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/api/v1/object/"+key.String(), nil))
+			return w.Code
+		}
+		assert.Equal(t, http.StatusOK, getObject(muxes[1]))
+
+		status, epoch := epochRequest(muxes[0], http.MethodPost)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, uint64(1), epoch)
+		status, epoch = epochRequest(muxes[1], http.MethodGet)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, uint64(1), epoch)
+		for _, mux := range muxes {
+			assert.Equal(t, http.StatusNotFound, getObject(mux))
+		}
+	})
+}
+
+func TestAPIV1Has(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer memCache.Close()
+
+	mux := http.NewServeMux()
+	_, err = strategy.NewAPIV1(ctx, struct{}{}, memCache, mux, httputil.NewTokenAuthorizer([]string{"secret"}), "", strategy.UploadConfig{})
+	assert.NoError(t, err)
+
+	present := cache.NewKey("present")
+	expired := cache.NewKey("expired")
+	for key, ttl := range map[cache.Key]time.Duration{present: time.Hour, expired: time.Millisecond} {
+		w, err := memCache.Create(ctx, key, nil, ttl)
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	absent := cache.NewKey("absent")
+	body := `["` + present.String() + `", "` + expired.String() + `", "` + absent.String() + `"]`
+	req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/_cache/has", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "%s", w.Body.String())
+
+	req = httptest.NewRequestWithContext(ctx, http.MethodPost, "/_cache/has", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, "%s", w.Body.String())
+
+	var result map[string]bool
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, map[string]bool{present.String(): true, expired.String(): false, absent.String(): false}, result)
+
+	req = httptest.NewRequestWithContext(ctx, http.MethodPost, "/_cache/has", strings.NewReader(`{"not": "a list"}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAPIV1PutIfNoneMatch(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer memCache.Close()
+	mux := http.NewServeMux()
+	_, err = strategy.NewAPIV1(ctx, struct{}{}, memCache, mux, nil, "", strategy.UploadConfig{})
+	assert.NoError(t, err)
+
+	key := cache.NewKey("snapshot")
+	tests := []struct {
+		name         string
+		ifNoneMatch  string
+		body         string
+		expectStatus int
+		expectBody   string
+	}{
+		{"FirstConditional", "*", "first", http.StatusOK, "first"},
+		{"SecondConditional", "*", "second", http.StatusPreconditionFailed, "first"},
+		{"Overwrite", "", "overwritten", http.StatusOK, "overwritten"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/api/v1/object/"+key.String(), strings.NewReader(tt.body))
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			assert.Equal(t, tt.expectStatus, w.Code)
+
+			r, headers, err := memCache.Open(ctx, key)
+			assert.NoError(t, err)
+			assert.Equal(t, "", headers.Get("If-None-Match"), "precondition should not be stored")
+			data, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectBody, string(data))
+		})
+	}
+}
+
+func TestAPIV1SnapshotSubtree(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer memCache.Close()
+	mux := http.NewServeMux()
+	_, err = strategy.NewAPIV1(ctx, struct{}{}, memCache, mux, nil, "", strategy.UploadConfig{})
+	assert.NoError(t, err)
+
+	src := t.TempDir()
+	for name, content := range map[string]string{
+		"README.md":          "readme",
+		"sub/a.txt":          "a",
+		"sub/nested/b.txt":   "b",
+		"subdirectory/c.txt": "c",
+	} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(src, filepath.Dir(name)), 0o755))
+		assert.NoError(t, os.WriteFile(filepath.Join(src, name), []byte(content), 0o644))
+	}
+	key := cache.NewKey("snapshot")
+	assert.NoError(t, snapshot.Create(ctx, memCache, key, src, time.Hour, nil))
+
+	t.Run("Zip", func(t *testing.T) {
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/api/v1/snapshot/"+key.String()+"/sub?format=zip", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+		assert.Equal(t, "attachment; filename=sub.zip", w.Header().Get("Content-Disposition"))
+
+		zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		assert.NoError(t, err)
+		files := map[string]string{}
+		for _, f := range zr.File {
+			content := ""
+			if !f.FileInfo().IsDir() {
+				r, err := f.Open()
+				assert.NoError(t, err)
+				data, err := io.ReadAll(r)
+				assert.NoError(t, err)
+				content = string(data)
+			}
+			files[f.Name] = content
+		}
+		assert.Equal(t, map[string]string{"a.txt": "a", "nested/": "", "nested/b.txt": "b"}, files)
+	})
+
+	t.Run("TarGz", func(t *testing.T) {
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/api/v1/snapshot/"+key.String()+"/sub/nested?format=tar.gz", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/gzip", w.Header().Get("Content-Type"))
+
+		gz, err := gzip.NewReader(w.Body)
+		assert.NoError(t, err)
+		tr := tar.NewReader(gz)
+		hdr, err := tr.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, "b.txt", hdr.Name)
+		_, err = tr.Next()
+		assert.IsError(t, err, io.EOF)
+	})
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"MissingPath", "/api/v1/snapshot/" + key.String() + "/missing", http.StatusNotFound},
+		{"MissingSnapshot", "/api/v1/snapshot/" + strings.Repeat("0", 64) + "/sub", http.StatusNotFound},
+		{"InvalidFormat", "/api/v1/snapshot/" + key.String() + "/sub?format=rar", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, tt.path, nil))
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
+
+func TestAPIV1SignedURL(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer memCache.Close()
+
+	mux := http.NewServeMux()
+	_, err = strategy.NewAPIV1(ctx, struct{}{}, memCache, mux, httputil.NewTokenAuthorizer([]string{"admin"}), "signing-secret", strategy.UploadConfig{})
+	assert.NoError(t, err)
+
+	key := cache.NewKey("shared")
+	w, err := memCache.Create(ctx, key, http.Header{"Content-Type": []string{"text/plain"}}, time.Hour)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("shared content"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	secret := []byte("signing-secret")
+	valid := cache.SignKey(secret, key, time.Now().Add(time.Minute))
+	tampered := []byte(valid)
+	tampered[0] ^= 1
+
+	tests := []struct {
+		name         string
+		token        string
+		expectStatus int
+		expectBody   string
+	}{
+		{name: "Valid", token: valid, expectStatus: http.StatusOK, expectBody: "shared content"},
+		{name: "Missing", token: cache.SignKey(secret, cache.NewKey("missing"), time.Now().Add(time.Minute)), expectStatus: http.StatusNotFound},
+		{name: "Expired", token: cache.SignKey(secret, key, time.Now().Add(-time.Second)), expectStatus: http.StatusForbidden, expectBody: "Signed URL has expired\n"},
+		{name: "Tampered", token: string(tampered), expectStatus: http.StatusForbidden, expectBody: "Invalid signed URL\n"},
+		{name: "WrongSecret", token: cache.SignKey([]byte("other"), key, time.Now().Add(time.Minute)), expectStatus: http.StatusForbidden, expectBody: "Invalid signed URL\n"},
+		{name: "Malformed", token: "not-a-token", expectStatus: http.StatusForbidden, expectBody: "Invalid signed URL\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/_signed/"+tt.token, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			assert.Equal(t, tt.expectStatus, w.Code, "%s", w.Body.String())
+			if tt.expectBody != "" {
+				assert.Equal(t, tt.expectBody, w.Body.String())
+			}
+		})
+	}
+}
@@ -3,13 +3,18 @@ package strategy
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"slices"
 	"strings"
+	"time"
+
+	"github.com/alecthomas/errors"
 
 	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/httputil"
 	"github.com/block/cachew/internal/logging"
 	"github.com/block/cachew/internal/strategy/handler"
 )
@@ -24,96 +29,251 @@ func RegisterArtifactory(r *Registry) {
 //
 //	artifactory "https://example.jfrog.io" {
 //	  hosts = ["maven.example.com", "npm.example.com"]
+//
+//	  upstream "https://other.jfrog.io" {
+//	    hosts = ["maven.other.com"]
+//	  }
 //	}
 //
 // When hosts are configured, the strategy supports both host-based routing
 // (clients connect to maven.example.com) and path-based routing
 // (clients connect to /example.jfrog.io). Both modes share the same cache.
 type ArtifactoryConfig struct {
+	Target              string                      `hcl:"target,label" help:"The target Artifactory URL to proxy requests to."`
+	Hosts               []string                    `hcl:"hosts,optional" help:"List of hostnames to accept for host-based routing. If empty, uses path-based routing only."`
+	StaleIfError        time.Duration               `hcl:"stale-if-error,optional" help:"Serve expired cached objects for up to this long when the upstream fails (0 disables)."`
+	FailClosed          bool                        `hcl:"fail-closed,optional" help:"Fail requests when the cache returns an error other than a miss, rather than logging it and fetching from upstream."`
+	AllowedContentTypes []string                    `hcl:"allowed-content-types,optional" help:"Only cache responses with these content types, eg. \"text/*\" (defaults to all)."`
+	AllowedExtensions   []string                    `hcl:"allowed-extensions,optional" help:"Only cache requests for paths with these file extensions, eg. \".jar\" (defaults to all)."`
+	Headers             map[string]string           `hcl:"headers,optional" help:"Static headers to add to every upstream request."`
+	PassthroughMethods  []string                    `hcl:"passthrough-methods,optional" help:"HTTP methods, eg. \"POST\", forwarded verbatim to the upstream without caching."`
+	Upstreams           []ArtifactoryUpstreamConfig `hcl:"upstream,block" help:"Additional Artifactory instances to proxy with the same settings, each under its own routes."`
+	Observe             bool                        `hcl:"observe,optional" help:"Dry run: always fetch from upstream and never cache, logging whether each request would have been a hit or a miss."`
+	Credentials         string                      `hcl:"credentials,optional" help:"How responses to requests carrying credentials are cached: \"per-user\" caches them separately for each user, \"bypass\" never caches them, and \"shared\" shares them between all users, which is only safe if every authorized user sees the same content." default:"per-user"`
+	Proxy               httputil.ProxyConfig        `hcl:"proxy,block" help:"HTTP proxy for requests to the targets, overriding the global proxy."`
+}
+
+// ArtifactoryUpstreamConfig is an additional Artifactory instance proxied by the same strategy.
+type ArtifactoryUpstreamConfig struct {
 	Target string   `hcl:"target,label" help:"The target Artifactory URL to proxy requests to."`
 	Hosts  []string `hcl:"hosts,optional" help:"List of hostnames to accept for host-based routing. If empty, uses path-based routing only."`
 }
 
+// Validate the configuration.
+func (c *ArtifactoryConfig) Validate() error {
+	var errs []error
+	errs = append(errs, validateURL("target", c.Target))
+	if slices.Contains(c.Hosts, "") {
+		errs = append(errs, errors.New("hosts must not contain empty hostnames"))
+	}
+	for _, upstream := range c.Upstreams {
+		if err := validateURL("target", upstream.Target); err != nil {
+			errs = append(errs, errors.Errorf("upstream %q: %w", upstream.Target, err))
+		}
+		if slices.Contains(upstream.Hosts, "") {
+			errs = append(errs, errors.Errorf("upstream %q: hosts must not contain empty hostnames", upstream.Target))
+		}
+	}
+	errs = append(errs, c.validateRoutes())
+	if c.StaleIfError < 0 {
+		errs = append(errs, errors.New("stale-if-error must not be negative"))
+	}
+	if _, err := parseCredentialPolicy(c.Credentials); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, validateMethods(c.PassthroughMethods))
+	errs = append(errs, c.Proxy.Validate())
+	return errors.Join(errs...)
+}
+
+// validateRoutes checks that no two targets are served under the same path prefix or host, which would otherwise make
+// registering their routes panic.
+func (c *ArtifactoryConfig) validateRoutes() error {
+	var errs []error
+	prefixes := map[string]string{}
+	hosts := map[string]string{}
+	targets := append([]ArtifactoryUpstreamConfig{{Target: c.Target, Hosts: c.Hosts}}, c.Upstreams...)
+	for _, target := range targets {
+		u, err := url.Parse(target.Target)
+		if err != nil {
+			continue // Reported by validateURL.
+		}
+		prefix := "/" + u.Host + u.EscapedPath()
+		if other, ok := prefixes[prefix]; ok {
+			errs = append(errs, errors.Errorf("upstream %q: duplicates target %q", target.Target, other))
+		} else {
+			prefixes[prefix] = target.Target
+		}
+		for _, host := range target.Hosts {
+			if other, ok := hosts[host]; ok {
+				errs = append(errs, errors.Errorf("upstream %q: host %q is already served by %q", target.Target, host, other))
+			} else {
+				hosts[host] = target.Target
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// parseCredentialPolicy parses the "credentials" setting, which defaults to "per-user".
+func parseCredentialPolicy(value string) (handler.CredentialPolicy, error) {
+	switch value {
+	case "", "per-user":
+		return handler.CredentialsPerUser, nil
+	case "bypass":
+		return handler.CredentialsBypass, nil
+	case "shared":
+		return handler.CredentialsShared, nil
+	default:
+		return 0, errors.Errorf("credentials must be one of \"per-user\", \"bypass\" or \"shared\", got %q", value)
+	}
+}
+
 // The Artifactory [Strategy] forwards all GET requests to the specified Artifactory instance,
 // caching the response payloads.
 //
 // Key features:
 // - Sets X-JFrog-Download-Redirect-To header to prevent redirects
-// - Passes through authentication headers
-// - Supports both host-based and path-based routing simultaneously.
+// - Passes through authentication headers, caching responses separately for each user unless configured otherwise
+// - Supports both host-based and path-based routing simultaneously
+// - Forwards requests using the configured passthrough methods without caching
+// - Proxies additional instances, configured as "upstream" blocks, under their own routes.
 type Artifactory struct {
+	cache     cache.Cache
+	client    *http.Client
+	logger    *slog.Logger
+	upstreams []*artifactoryUpstream // The primary target first.
+	shadow    cache.Cache            // Set in observe mode, shared by all upstreams.
+}
+
+// artifactoryUpstream is a single Artifactory instance and the routes it is served under.
+type artifactoryUpstream struct {
 	target       *url.URL
-	cache        cache.Cache
-	client       *http.Client
 	logger       *slog.Logger
 	prefix       string   // For path-based routing
 	allowedHosts []string // For host-based routing
 }
 
-var _ Strategy = (*Artifactory)(nil)
+var (
+	_ Strategy  = (*Artifactory)(nil)
+	_ io.Closer = (*Artifactory)(nil)
+)
 
 func NewArtifactory(ctx context.Context, config ArtifactoryConfig, cache cache.Cache, mux Mux) (*Artifactory, error) {
-	u, err := url.Parse(config.Target)
+	credentials, err := parseCredentialPolicy(config.Credentials)
 	if err != nil {
-		return nil, fmt.Errorf("invalid target URL: %w", err)
+		return nil, err
+	}
+	if err := config.validateRoutes(); err != nil {
+		return nil, err
 	}
-
 	a := &Artifactory{
-		target: u,
 		cache:  cache,
-		client: &http.Client{},
+		client: &http.Client{Transport: httputil.ProxyTransport(http.DefaultTransport, config.Proxy)},
 		logger: logging.FromContext(ctx),
 	}
+	if config.Observe {
+		if a.shadow, err = newShadowCache(ctx, 0); err != nil {
+			return nil, err
+		}
+	}
 
-	hdlr := handler.New(a.client, cache).
-		CacheKey(func(r *http.Request) string {
-			return a.buildTargetURL(r).String()
-		}).
-		Transform(func(r *http.Request) (*http.Request, error) {
-			return a.transformRequest(r)
-		})
+	targets := append([]ArtifactoryUpstreamConfig{{Target: config.Target, Hosts: config.Hosts}}, config.Upstreams...)
+	for _, target := range targets {
+		u, err := url.Parse(target.Target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target URL %q: %w", target.Target, err)
+		}
+		upstream := &artifactoryUpstream{target: u, logger: a.logger}
+		a.upstreams = append(a.upstreams, upstream)
+
+		// The cache key is the canonical upstream URL, so instances never share entries.
+		hdlr := handler.New(a.client, cache).
+			CacheKey(func(r *http.Request) string {
+				return upstream.buildTargetURL(r).String()
+			}).
+			Transform(func(r *http.Request) (*http.Request, error) {
+				return upstream.transformRequest(r)
+			}).
+			StaleIfError(config.StaleIfError).
+			AllowContentTypes(config.AllowedContentTypes...).
+			AllowExtensions(config.AllowedExtensions...).
+			UpstreamHeaders(config.Headers).
+			Credentials(credentials, artifactoryAuthHeaders...)
+		if config.FailClosed {
+			hdlr.FailClosed()
+		}
+		if a.shadow != nil {
+			hdlr.Observe(a.shadow)
+		}
 
-	// Register path-based route (for backward compatibility)
-	a.registerPathBased(ctx, u, hdlr, mux)
+		// Register path-based route (for backward compatibility)
+		a.registerPathBased(ctx, upstream, hdlr, mux)
+
+		// Register host-based routes if configured
+		if len(target.Hosts) > 0 {
+			a.registerHostBased(ctx, upstream, target.Hosts, hdlr, mux)
+		}
 
-	// Register host-based routes if configured
-	if len(config.Hosts) > 0 {
-		a.registerHostBased(ctx, config.Hosts, hdlr, mux)
+		a.registerPassthrough(ctx, upstream, config, mux)
 	}
 
 	return a, nil
 }
 
 // registerPathBased registers the path-based routing pattern.
-func (a *Artifactory) registerPathBased(ctx context.Context, target *url.URL, hdlr http.Handler, mux Mux) {
-	a.prefix = "/" + target.Host + target.EscapedPath()
+func (a *Artifactory) registerPathBased(ctx context.Context, upstream *artifactoryUpstream, hdlr http.Handler, mux Mux) {
+	upstream.prefix = "/" + upstream.target.Host + upstream.target.EscapedPath()
 
-	pattern := "GET " + a.prefix + "/"
+	pattern := "GET " + upstream.prefix + "/"
 	mux.Handle(pattern, hdlr)
 	a.logger.InfoContext(ctx, "Registered Artifactory path-based route",
-		slog.String("prefix", a.prefix),
-		slog.String("target", target.String()))
+		slog.String("prefix", upstream.prefix),
+		slog.String("target", upstream.target.String()))
 }
 
 // registerHostBased registers host-based routing patterns for the configured hosts.
-func (a *Artifactory) registerHostBased(ctx context.Context, hosts []string, hdlr http.Handler, mux Mux) {
+func (a *Artifactory) registerHostBased(ctx context.Context, upstream *artifactoryUpstream, hosts []string, hdlr http.Handler, mux Mux) {
 	// Store allowed hosts for routing detection in buildTargetURL
-	a.allowedHosts = hosts
+	upstream.allowedHosts = hosts
 
 	for _, host := range hosts {
 		pattern := "GET " + host + "/"
 		mux.Handle(pattern, hdlr)
 		a.logger.InfoContext(ctx, "Registered Artifactory host-based route",
 			slog.String("pattern", pattern),
-			slog.String("target", a.target.String()))
+			slog.String("target", upstream.target.String()))
 	}
 }
 
-func (a *Artifactory) String() string { return "artifactory:" + a.target.Host + a.target.Path }
+// registerPassthrough registers uncached routes for the configured passthrough methods on both the path-based and
+// host-based routes.
+func (a *Artifactory) registerPassthrough(ctx context.Context, upstream *artifactoryUpstream, config ArtifactoryConfig, mux Mux) {
+	passthrough := newPassthrough(a.client, upstream.buildTargetURL, config.Headers)
+	for _, method := range config.PassthroughMethods {
+		mux.Handle(method+" "+upstream.prefix+"/", passthrough)
+		for _, host := range upstream.allowedHosts {
+			mux.Handle(method+" "+host+"/", passthrough)
+		}
+	}
+	if len(config.PassthroughMethods) > 0 {
+		a.logger.InfoContext(ctx, "Registered Artifactory passthrough methods",
+			slog.String("target", upstream.target.String()),
+			slog.Any("methods", config.PassthroughMethods))
+	}
+}
+
+func (a *Artifactory) String() string {
+	target := a.upstreams[0].target
+	return "artifactory:" + target.Host + target.Path
+}
+
+// Close releases the shadow cache of an Artifactory strategy in observe mode.
+func (a *Artifactory) Close() error { return closeShadowCache(a.shadow) }
 
 // transformRequest transforms the incoming request before sending to upstream Artifactory.
-func (a *Artifactory) transformRequest(r *http.Request) (*http.Request, error) {
-	targetURL := a.buildTargetURL(r)
+func (u *artifactoryUpstream) transformRequest(r *http.Request) (*http.Request, error) {
+	targetURL := u.buildTargetURL(r)
 
 	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, targetURL.String(), nil)
 	if err != nil {
@@ -121,7 +281,7 @@ func (a *Artifactory) transformRequest(r *http.Request) (*http.Request, error) {
 	}
 
 	// Pass through authentication headers
-	a.copyAuthHeaders(r, req)
+	u.copyAuthHeaders(r, req)
 
 	// Set X-JFrog-Download-Redirect-To to None to prevent Artifactory from redirecting
 	// This ensures the proxy can cache the actual artifact content
@@ -131,13 +291,13 @@ func (a *Artifactory) transformRequest(r *http.Request) (*http.Request, error) {
 }
 
 // buildTargetURL constructs the target URL from the incoming request.
-func (a *Artifactory) buildTargetURL(r *http.Request) *url.URL {
+func (u *artifactoryUpstream) buildTargetURL(r *http.Request) *url.URL {
 	var path string
 
 	// Dynamically detect routing mode based on request
 	// If request Host matches one of our configured hosts, use host-based routing
 	// Otherwise, use path-based routing
-	isHostBased := a.isHostBasedRequest(r)
+	isHostBased := u.isHostBasedRequest(r)
 
 	if isHostBased {
 		// Host-based: use full request path as-is
@@ -153,33 +313,33 @@ func (a *Artifactory) buildTargetURL(r *http.Request) *url.URL {
 		// Strip "/global.example.jfrog.io" -> "/libs-release/foo.jar"
 		// Proxy to: GET https://global.example.jfrog.io/libs-release/foo.jar
 		path = r.URL.Path
-		if len(path) >= len(a.prefix) {
-			path = path[len(a.prefix):]
+		if len(path) >= len(u.prefix) {
+			path = path[len(u.prefix):]
 		}
 		if path == "" {
 			path = "/"
 		}
 	}
 
-	a.logger.Debug("buildTargetURL",
+	u.logger.Debug("buildTargetURL",
 		"host_based", isHostBased,
 		"request_host", r.Host,
 		"request_path", r.URL.Path,
 		"stripped_path", path)
 
-	targetURL := *a.target
-	targetURL.Path = a.target.Path + path
+	targetURL := *u.target
+	targetURL.Path = u.target.Path + path
 	targetURL.RawQuery = r.URL.RawQuery
 
-	a.logger.Debug("buildTargetURL result",
+	u.logger.Debug("buildTargetURL result",
 		"url", targetURL.String())
 
 	return &targetURL
 }
 
 // isHostBasedRequest checks if the incoming request is using host-based routing.
-func (a *Artifactory) isHostBasedRequest(r *http.Request) bool {
-	if len(a.allowedHosts) == 0 {
+func (u *artifactoryUpstream) isHostBasedRequest(r *http.Request) bool {
+	if len(u.allowedHosts) == 0 {
 		return false // No hosts configured, must be path-based
 	}
 
@@ -190,18 +350,15 @@ func (a *Artifactory) isHostBasedRequest(r *http.Request) bool {
 	}
 
 	// Check if request host matches any configured host
-	return slices.Contains(a.allowedHosts, requestHost)
+	return slices.Contains(u.allowedHosts, requestHost)
 }
 
-// copyAuthHeaders copies authentication-related headers from the source to destination request.
-func (a *Artifactory) copyAuthHeaders(src, dst *http.Request) {
-	authHeaders := []string{
-		"Authorization",
-		"X-JFrog-Art-Api",
-		"Cookie",
-	}
+// artifactoryAuthHeaders are the headers carrying credentials that are passed through to Artifactory.
+var artifactoryAuthHeaders = []string{"Authorization", "X-JFrog-Art-Api", "Cookie"} //nolint:gochecknoglobals
 
-	for _, header := range authHeaders {
+// copyAuthHeaders copies authentication-related headers from the source to destination request.
+func (u *artifactoryUpstream) copyAuthHeaders(src, dst *http.Request) {
+	for _, header := range artifactoryAuthHeaders {
 		if value := src.Header.Get(header); value != "" {
 			dst.Header.Set(header, value)
 		}
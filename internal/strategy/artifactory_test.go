@@ -69,6 +69,18 @@ func setupArtifactoryTest(t *testing.T, config strategy.ArtifactoryConfig) (*moc
 	return mock, mux, ctx
 }
 
+func TestArtifactoryObserve(t *testing.T) {
+	mock, mux, ctx := setupArtifactoryTest(t, strategy.ArtifactoryConfig{Observe: true})
+
+	for range 2 {
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/"+mock.server.Listener.Addr().String()+"/libs-release/app.jar", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	assert.Equal(t, 2, mock.requestCount, "observed requests should always be fetched from upstream")
+}
+
 func TestArtifactoryBasicRequest(t *testing.T) {
 	mock, mux, ctx := setupArtifactoryTest(t, strategy.ArtifactoryConfig{})
 
@@ -133,6 +145,40 @@ func TestArtifactoryQueryParamsIncludedInKey(t *testing.T) {
 	assert.Equal(t, 2, mock.requestCount, "different query params should result in different cache keys")
 }
 
+func TestArtifactoryCredentials(t *testing.T) {
+	tests := []struct {
+		name          string
+		credentials   string
+		expectFetches int // Of requests as alice, bob, alice again and anonymously.
+	}{
+		{name: "PerUserByDefault", expectFetches: 3},
+		{name: "PerUser", credentials: "per-user", expectFetches: 3},
+		{name: "Bypass", credentials: "bypass", expectFetches: 4},
+		{name: "Shared", credentials: "shared", expectFetches: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock, mux, ctx := setupArtifactoryTest(t, strategy.ArtifactoryConfig{Credentials: tt.credentials})
+			path := "/" + mock.server.Listener.Addr().String() + "/libs-private/secret.jar"
+
+			for _, auth := range []string{"Bearer alice", "Bearer bob", "Bearer alice", ""} {
+				mock.responseContent = "artifact for " + auth
+				req := httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx)
+				if auth != "" {
+					req.Header.Set("Authorization", auth)
+				}
+				w := httptest.NewRecorder()
+				mux.ServeHTTP(w, req)
+				assert.Equal(t, http.StatusOK, w.Code)
+				if tt.credentials != "shared" && auth != "" {
+					assert.Equal(t, "artifact for "+auth, w.Body.String(), "one user's artifact was served to another")
+				}
+			}
+			assert.Equal(t, tt.expectFetches, mock.requestCount)
+		})
+	}
+}
+
 func TestArtifactoryXJFrogDownloadRedirectHeader(t *testing.T) {
 	mock, mux, ctx := setupArtifactoryTest(t, strategy.ArtifactoryConfig{})
 
@@ -346,3 +392,95 @@ func TestArtifactoryPathBasedOnlyWhenNoHostsConfigured(t *testing.T) {
 	assert.Equal(t, []byte("artifact-content"), w.Body.Bytes())
 	assert.Equal(t, 1, mock.requestCount)
 }
+
+func TestArtifactoryMultipleUpstreams(t *testing.T) {
+	second := newMockArtifactoryServer()
+	second.responseContent = "second-content"
+	t.Cleanup(second.close)
+
+	first, mux, ctx := setupArtifactoryTest(t, strategy.ArtifactoryConfig{
+		Hosts: []string{"maven.first.example.com"},
+		Upstreams: []strategy.ArtifactoryUpstreamConfig{
+			{Target: second.server.URL, Hosts: []string{"maven.second.example.com"}},
+		},
+	})
+
+	get := func(host, path string) string {
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+		if host != "" {
+			req.Host = host
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	const artifact = "/libs-release/app-1.0.jar"
+	firstPrefix := "/" + first.server.Listener.Addr().String()
+	secondPrefix := "/" + second.server.Listener.Addr().String()
+
+	assert.Equal(t, "artifact-content", get("", firstPrefix+artifact))
+	assert.Equal(t, "second-content", get("", secondPrefix+artifact))
+	assert.Equal(t, 1, first.requestCount)
+	assert.Equal(t, 1, second.requestCount)
+	assert.Equal(t, artifact, second.lastRequestPath)
+
+	// Each upstream is cached independently, under both its path and host routes.
+	assert.Equal(t, "artifact-content", get("maven.first.example.com", artifact))
+	assert.Equal(t, "second-content", get("maven.second.example.com", artifact))
+	assert.Equal(t, "second-content", get("", secondPrefix+artifact))
+	assert.Equal(t, 1, first.requestCount)
+	assert.Equal(t, 1, second.requestCount)
+}
+
+func TestArtifactoryDuplicateUpstreams(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    strategy.ArtifactoryConfig
+		expectErr string
+	}{
+		{
+			name: "DuplicateTarget",
+			config: strategy.ArtifactoryConfig{
+				Target:    "https://a.jfrog.io",
+				Upstreams: []strategy.ArtifactoryUpstreamConfig{{Target: "http://a.jfrog.io"}},
+			},
+			expectErr: `duplicates target "https://a.jfrog.io"`,
+		},
+		{
+			name: "DuplicateHost",
+			config: strategy.ArtifactoryConfig{
+				Target:    "https://a.jfrog.io",
+				Hosts:     []string{"maven.example.com"},
+				Upstreams: []strategy.ArtifactoryUpstreamConfig{{Target: "https://b.jfrog.io", Hosts: []string{"maven.example.com"}}},
+			},
+			expectErr: `host "maven.example.com" is already served by "https://a.jfrog.io"`,
+		},
+		{
+			name: "DistinctPaths",
+			config: strategy.ArtifactoryConfig{
+				Target:    "https://a.jfrog.io/one",
+				Upstreams: []strategy.ArtifactoryUpstreamConfig{{Target: "https://a.jfrog.io/two"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+			memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+			assert.NoError(t, err)
+			defer memCache.Close()
+
+			validateErr := tt.config.Validate()
+			_, newErr := strategy.NewArtifactory(ctx, tt.config, memCache, http.NewServeMux())
+			if tt.expectErr == "" {
+				assert.NoError(t, validateErr)
+				assert.NoError(t, newErr)
+				return
+			}
+			assert.Contains(t, validateErr.Error(), tt.expectErr)
+			assert.Contains(t, newErr.Error(), tt.expectErr)
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package strategy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alecthomas/errors"
+)
+
+// BudgetConfig limits the cost of each request handled by a strategy.
+//
+// It is accepted in the block of every strategy and enforced by middleware wrapping the strategy's handlers, so
+// strategies need not enforce it themselves. Both limits are disabled by default, so strategies serving large
+// transfers, such as git, are unlimited unless configured otherwise.
+type BudgetConfig struct {
+	MaxRequestDuration time.Duration `hcl:"max-request-duration,optional" help:"Maximum duration of a request, after which it is cancelled and answered with 504 Gateway Timeout. 0 disables the limit."`
+	MaxResponseBytes   int64         `hcl:"max-response-bytes,optional" help:"Maximum size of a response body. Larger responses are answered with 413 Content Too Large. 0 disables the limit."`
+}
+
+// Validate the configuration.
+func (c *BudgetConfig) Validate() error {
+	var errs []error
+	if c.MaxRequestDuration < 0 {
+		errs = append(errs, errors.New("max-request-duration must not be negative"))
+	}
+	if c.MaxResponseBytes < 0 {
+		errs = append(errs, errors.New("max-response-bytes must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// Middleware returns next wrapped to enforce the budget.
+//
+// A request that runs out of time is cancelled, and answered with 504 if its response has not yet started. A response
+// that exceeds the byte budget is answered with 413 if this is known before it starts, either from its Content-Length
+// or because its first write is too large. Otherwise the response is aborted, so that the client sees a truncated
+// transfer rather than a complete one.
+func (c BudgetConfig) Middleware(next http.Handler) http.Handler {
+	if c.MaxRequestDuration == 0 && c.MaxResponseBytes == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if c.MaxRequestDuration > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, c.MaxRequestDuration)
+			defer cancel()
+		}
+		bw := &budgetWriter{ResponseWriter: w, ctx: ctx, maxBytes: c.MaxResponseBytes}
+		next.ServeHTTP(bw, r.WithContext(ctx))
+		switch {
+		case bw.rejected:
+		case bw.aborted:
+			panic(http.ErrAbortHandler)
+		case bw.timedOut() && !bw.committed:
+			bw.reject(http.StatusGatewayTimeout)
+		default:
+			bw.commit()
+		}
+	})
+}
+
+var errBudgetExceeded = errors.New("request budget exceeded")
+
+// budgetWriter holds back the status of a response until its body is first written or flushed, so that a response
+// exceeding the budget can still be replaced with an error.
+type budgetWriter struct {
+	http.ResponseWriter
+	ctx       context.Context
+	maxBytes  int64
+	written   int64
+	code      int
+	committed bool
+	rejected  bool
+	aborted   bool
+}
+
+func (b *budgetWriter) WriteHeader(code int) {
+	if b.code != 0 || b.rejected {
+		return
+	}
+	b.code = code
+	if b.maxBytes > 0 {
+		if length, err := strconv.ParseInt(b.Header().Get("Content-Length"), 10, 64); err == nil && length > b.maxBytes {
+			b.reject(http.StatusRequestEntityTooLarge)
+		}
+	}
+}
+
+func (b *budgetWriter) Write(p []byte) (int, error) {
+	if b.code == 0 {
+		b.WriteHeader(http.StatusOK)
+	}
+	if b.rejected || b.aborted {
+		return 0, errBudgetExceeded
+	}
+	var code int
+	switch {
+	case b.timedOut():
+		code = http.StatusGatewayTimeout
+	case b.maxBytes > 0 && b.written+int64(len(p)) > b.maxBytes:
+		code = http.StatusRequestEntityTooLarge
+	}
+	if code != 0 {
+		if b.committed {
+			b.aborted = true
+		} else {
+			b.reject(code)
+		}
+		return 0, errBudgetExceeded
+	}
+	b.commit()
+	n, err := b.ResponseWriter.Write(p)
+	b.written += int64(n)
+	return n, errors.WithStack(err)
+}
+
+func (b *budgetWriter) Flush() {
+	if b.rejected || b.aborted {
+		return
+	}
+	b.commit()
+	if f, ok := b.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows [http.ResponseController] to reach the underlying writer.
+func (b *budgetWriter) Unwrap() http.ResponseWriter { return b.ResponseWriter }
+
+// commit sends the status of the response, after which it can no longer be replaced.
+func (b *budgetWriter) commit() {
+	if b.committed || b.rejected {
+		return
+	}
+	b.committed = true
+	if b.code != 0 {
+		b.ResponseWriter.WriteHeader(b.code)
+	}
+}
+
+// reject replaces the response with an error, discarding anything written afterwards.
+func (b *budgetWriter) reject(code int) {
+	b.rejected = true
+	http.Error(b.ResponseWriter, http.StatusText(code), code)
+}
+
+func (b *budgetWriter) timedOut() bool {
+	return errors.Is(b.ctx.Err(), context.DeadlineExceeded)
+}
+
+// budgetMux applies a budget to every handler a strategy registers.
+type budgetMux struct {
+	mux    Mux
+	budget BudgetConfig
+}
+
+func (m *budgetMux) Handle(pattern string, handler http.Handler) {
+	m.mux.Handle(pattern, m.budget.Middleware(handler))
+}
+
+func (m *budgetMux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.mux.Handle(pattern, m.budget.Middleware(http.HandlerFunc(handler)))
+}
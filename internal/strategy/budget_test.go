@@ -0,0 +1,106 @@
+package strategy_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/alecthomas/hcl/v2"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy"
+)
+
+func TestBudget(t *testing.T) {
+	body := strings.Repeat("x", 1024)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		case "/streamed":
+			// Flushing before the body is complete prevents a Content-Length from being sent.
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+		case "/trickle":
+			// The first part of the body fits within the budget, so the response starts before it is exceeded.
+			_, _ = io.WriteString(w, body[:80])
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+			_, _ = io.WriteString(w, body[80:])
+			return
+		}
+		_, _ = io.WriteString(w, body)
+	}))
+	defer backend.Close()
+	u, err := url.Parse(backend.URL)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		budget        string
+		path          string
+		expectedCode  int
+		expectedError bool
+	}{
+		{"Unlimited", "", "/file", http.StatusOK, false},
+		{"Generous", "max-response-bytes = 1048576\nmax-request-duration = \"1m\"", "/file", http.StatusOK, false},
+		{"TightBytes", "max-response-bytes = 100", "/file", http.StatusRequestEntityTooLarge, false},
+		{"TightBytesStreamed", "max-response-bytes = 100", "/streamed", http.StatusRequestEntityTooLarge, false},
+		{"TightBytesStarted", "max-response-bytes = 100", "/trickle", 0, true},
+		{"TightDuration", `max-request-duration = "50ms"`, "/slow", http.StatusGatewayTimeout, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+			memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+			assert.NoError(t, err)
+			defer memCache.Close()
+
+			ast, err := hcl.Parse(strings.NewReader("host \"" + backend.URL + "\" {\n" + tt.budget + "\n}"))
+			assert.NoError(t, err)
+			block := ast.Entries[0].(*hcl.Block) //nolint:errcheck
+			sr := strategy.NewRegistry()
+			strategy.RegisterHost(sr)
+			assert.NoError(t, sr.Validate("host", block, nil))
+			mux := http.NewServeMux()
+			_, err = sr.Create(ctx, "host", block, memCache, mux, nil)
+			assert.NoError(t, err)
+			server := httptest.NewUnstartedServer(mux)
+			server.Config.BaseContext = func(net.Listener) context.Context { return ctx }
+			server.Start()
+			defer server.Close()
+
+			resp, err := http.Get(server.URL + "/" + u.Host + tt.path) //nolint:noctx
+			if err == nil {
+				defer resp.Body.Close()
+			}
+			if tt.expectedError {
+				// The response had already started, so the connection is dropped rather than the response replaced.
+				if err == nil {
+					_, err = io.ReadAll(resp.Body)
+				}
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+			data, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			if tt.expectedCode == http.StatusOK {
+				assert.Equal(t, body, string(data))
+			}
+		})
+	}
+}
@@ -18,6 +18,13 @@ import (
 	"github.com/block/cachew/internal/logging"
 )
 
+// serveFromBackend serves a git HTTP request from the local mirror with git http-backend.
+//
+// The response is flushed as the backend writes it, so that progress and keepalive packets reach the client while a
+// pack is being prepared, rather than waiting in the server's buffer until an idle timeout, eg. of a load balancer,
+// drops the connection. If the backend fails after the response has started the connection is aborted, so that the
+// client sees an incomplete chunked body rather than a truncated one that appears complete. CGI responses have no
+// trailers, so there are none to relay.
 func (s *Strategy) serveFromBackend(w http.ResponseWriter, r *http.Request, repo *gitclone.Repository) {
 	ctx := r.Context()
 	logger := logging.FromContext(ctx)
@@ -37,18 +44,9 @@ func (s *Strategy) serveFromBackend(w http.ResponseWriter, r *http.Request, repo
 	host := r.PathValue("host")
 	pathValue := r.PathValue("path")
 
-	// Insert /.git before the git protocol paths to match the filesystem layout
-	var gitOperation string
-	var repoPathWithSuffix string
-
-	for _, op := range []string{"/info/refs", "/git-upload-pack", "/git-receive-pack"} {
-		if idx := strings.Index(pathValue, op); idx != -1 {
-			repoPathWithSuffix = pathValue[:idx]
-			gitOperation = pathValue[idx:]
-			break
-		}
-	}
-
+	// Insert /.git before the git protocol paths to match the filesystem layout. Both smart and dumb HTTP paths are
+	// served, the latter because GIT_HTTP_EXPORT_ALL is set.
+	repoPathWithSuffix, gitOperation := splitGitOperation(pathValue)
 	repoPath := strings.TrimSuffix(repoPathWithSuffix, ".git")
 	backendPath := "/" + host + "/" + repoPath + "/.git" + gitOperation
 
@@ -57,6 +55,27 @@ func (s *Strategy) serveFromBackend(w http.ResponseWriter, r *http.Request, repo
 		slog.String("backend_path", backendPath),
 		slog.String("clone_path", repo.Path()))
 
+	env := []string{
+		"GIT_PROJECT_ROOT=" + absRoot,
+		"GIT_HTTP_EXPORT_ALL=1",
+		"PATH=" + os.Getenv("PATH"),
+	}
+	if repo.Filtered() {
+		env = append(env,
+			// Serve partial clones, and the objects their clients later fetch on demand.
+			"GIT_CONFIG_COUNT=2",
+			"GIT_CONFIG_KEY_0=uploadpack.allowFilter",
+			"GIT_CONFIG_VALUE_0=true",
+			"GIT_CONFIG_KEY_1=uploadpack.allowAnySHA1InWant",
+			"GIT_CONFIG_VALUE_1=true",
+			// Objects missing from filtered mirrors are fetched from upstream, which git otherwise refuses to do
+			// while serving. That includes blobs whose size is needed to serve a "blob:limit" filter.
+			"GIT_NO_LAZY_FETCH=0",
+		)
+	}
+
+	bw := &backendResponseWriter{ResponseWriter: w}
+	failed := false
 	repo.WithReadLock(func() error { //nolint:errcheck,gosec
 		var stderrBuf bytes.Buffer
 
@@ -64,31 +83,71 @@ func (s *Strategy) serveFromBackend(w http.ResponseWriter, r *http.Request, repo
 			Path:   gitPath,
 			Args:   []string{"http-backend"},
 			Stderr: &stderrBuf,
-			Env: []string{
-				"GIT_PROJECT_ROOT=" + absRoot,
-				"GIT_HTTP_EXPORT_ALL=1",
-				"PATH=" + os.Getenv("PATH"),
-			},
+			Env:    env,
 		}
 
 		r2 := r.Clone(r.Context())
 		r2.URL.Path = backendPath
 
-		handler.ServeHTTP(w, r2)
+		handler.ServeHTTP(bw, r2)
 
 		if stderrBuf.Len() > 0 {
 			logger.ErrorContext(r.Context(), "git http-backend error",
 				slog.String("stderr", stderrBuf.String()),
 				slog.String("path", backendPath))
+			failed = backendFailed(stderrBuf.String())
 		}
 
 		return nil
 	})
+	if failed && bw.status != 0 && bw.status < http.StatusBadRequest {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// backendFailed returns true if the stderr of git http-backend reports a fatal error, after which its response is
+// incomplete.
+func backendFailed(stderr string) bool {
+	for line := range strings.Lines(stderr) {
+		if strings.HasPrefix(line, "fatal:") {
+			return true
+		}
+	}
+	return false
 }
 
+// backendResponseWriter flushes each write of a git http-backend response, and records its status.
+type backendResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (b *backendResponseWriter) WriteHeader(status int) {
+	b.status = status
+	b.ResponseWriter.WriteHeader(status)
+}
+
+func (b *backendResponseWriter) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	n, err := b.ResponseWriter.Write(p)
+	if err != nil {
+		return n, errors.WithStack(err)
+	}
+	_ = http.NewResponseController(b.ResponseWriter).Flush() //nolint:errcheck // Not all writers can flush.
+	return n, nil
+}
+
+// Unwrap allows [http.ResponseController] to reach the underlying writer.
+func (b *backendResponseWriter) Unwrap() http.ResponseWriter { return b.ResponseWriter }
+
 func (s *Strategy) ensureRefsUpToDate(ctx context.Context, repo *gitclone.Repository) error {
-	if err := repo.EnsureRefsUpToDate(ctx); err != nil {
+	_, refCheckInterval := s.intervals(repo.UpstreamURL())
+	rewritten, err := repo.EnsureRefsUpToDate(ctx, refCheckInterval)
+	if err != nil {
 		return errors.Wrap(err, "ensure refs up to date")
 	}
+	s.invalidateRewrittenBundle(ctx, repo, rewritten)
 	return nil
 }
@@ -5,7 +5,9 @@ import (
 	"context"
 	"log/slog"
 	"net/http"
+	"os"
 	"os/exec"
+	"strings"
 	"time"
 
 	"github.com/alecthomas/errors"
@@ -21,19 +23,25 @@ func (s *Strategy) generateAndUploadBundle(ctx context.Context, repo *gitclone.R
 
 	logger.InfoContext(ctx, "Bundle generation started", slog.String("upstream", upstream))
 
-	cacheKey := cache.NewKey(upstream + ".bundle")
+	cacheKey := bundleKey(upstream)
 
-	headers := http.Header{
-		"Content-Type": []string{"application/x-git-bundle"},
-	}
 	ttl := 7 * 24 * time.Hour
-	w, err := s.cache.Create(ctx, cacheKey, headers, ttl)
-	if err != nil {
-		return errors.Wrap(err, "create cache entry")
-	}
-	defer w.Close()
+	err := errors.Wrap(repo.WithReadLock(func() error {
+		// The ETag is derived under the same lock as the bundle, so that it describes exactly the refs bundled.
+		etag, err := refsETag(ctx, repo.Path())
+		if err != nil {
+			return err
+		}
+		headers := http.Header{
+			"Content-Type": []string{"application/x-git-bundle"},
+			"Etag":         []string{etag},
+		}
+		w, err := s.cache.Create(ctx, cacheKey, headers, ttl)
+		if err != nil {
+			return errors.Wrap(err, "create cache entry")
+		}
+		defer w.Close()
 
-	err = errors.Wrap(repo.WithReadLock(func() error {
 		var stderr bytes.Buffer
 		// Use --branches --remotes to include all branches but exclude tags (which can be massive)
 		// #nosec G204 - repo.Path() is controlled by us
@@ -55,3 +63,72 @@ func (s *Strategy) generateAndUploadBundle(ctx context.Context, repo *gitclone.R
 	logger.InfoContext(ctx, "Bundle generation completed", slog.String("upstream", upstream))
 	return nil
 }
+
+func bundleKey(upstreamURL string) cache.Key { return cache.NewKey(upstreamURL + ".bundle") }
+
+// handleBundleDelete invalidates the cached bundle of a repository, eg. after its history has been rewritten in a way
+// that was not detected by a fetch.
+func (s *Strategy) handleBundleDelete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	pathValue := r.PathValue("path")
+	if !strings.HasSuffix(pathValue, "/bundle") {
+		http.NotFound(w, r)
+		return
+	}
+	upstreamURL := "https://" + r.PathValue("host") + "/" + ExtractRepoPath(strings.TrimSuffix(pathValue, "/bundle"))
+
+	if err := s.invalidateBundle(ctx, upstreamURL); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, "Bundle not found", http.StatusNotFound)
+			return
+		}
+		logging.FromContext(ctx).ErrorContext(ctx, "Failed to invalidate bundle",
+			slog.String("upstream", upstreamURL),
+			slog.String("error", err.Error()))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// invalidateRewrittenBundle invalidates the bundle of repo if a fetch rewrote any of its refs, as the bundle would
+// otherwise give clients history that upstream has discarded until its next periodic regeneration.
+func (s *Strategy) invalidateRewrittenBundle(ctx context.Context, repo *gitclone.Repository, rewritten []string) {
+	if len(rewritten) == 0 || s.config.BundleInterval == 0 {
+		return
+	}
+	logger := logging.FromContext(ctx)
+	logger.InfoContext(ctx, "Upstream history rewritten, invalidating bundle",
+		slog.String("upstream", repo.UpstreamURL()),
+		slog.Any("refs", rewritten))
+	if err := s.invalidateBundle(ctx, repo.UpstreamURL()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.ErrorContext(ctx, "Failed to invalidate bundle",
+			slog.String("upstream", repo.UpstreamURL()),
+			slog.String("error", err.Error()))
+	}
+}
+
+// repairBundle deletes the bundle of upstreamURL, eg. after it was found to be corrupt, and schedules its regeneration.
+func (s *Strategy) repairBundle(ctx context.Context, upstreamURL string) {
+	if err := s.invalidateBundle(ctx, upstreamURL); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logging.FromContext(ctx).ErrorContext(ctx, "Failed to invalidate bundle",
+			slog.String("upstream", upstreamURL),
+			slog.String("error", err.Error()))
+	}
+}
+
+// invalidateBundle deletes the cached bundle of upstreamURL and, if bundles are enabled and the repository has been
+// cloned, schedules its regeneration rather than waiting for the next interval.
+//
+// Regeneration is scheduled even if there was no bundle to delete, in which case os.ErrNotExist is returned.
+func (s *Strategy) invalidateBundle(ctx context.Context, upstreamURL string) error {
+	err := s.cache.Delete(ctx, bundleKey(upstreamURL))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.Wrap(err, "delete bundle")
+	}
+	if repo := s.cloneManager.Get(upstreamURL); s.config.BundleInterval > 0 && repo != nil && repo.State() == gitclone.StateReady {
+		s.scheduler.Submit(upstreamURL, "bundle", func(ctx context.Context) error {
+			return s.generateAndUploadBundle(ctx, repo)
+		})
+	}
+	return errors.WithStack(err)
+}
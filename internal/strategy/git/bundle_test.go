@@ -2,8 +2,12 @@ package git_test
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -30,7 +34,7 @@ func TestBundleHTTPEndpoint(t *testing.T) {
 
 	_, err = git.New(ctx, git.Config{
 		BundleInterval: 24 * time.Hour,
-	}, jobscheduler.New(ctx, jobscheduler.Config{}), memCache, mux, cloneManager)
+	}, jobscheduler.New(ctx, jobscheduler.Config{}), memCache, mux, cloneManager, nil)
 	assert.NoError(t, err)
 
 	// Create a fake bundle in the cache
@@ -109,7 +113,7 @@ func TestBundleInterval(t *testing.T) {
 
 			s, err := git.New(ctx, git.Config{
 				BundleInterval: tt.bundleInterval,
-			}, jobscheduler.New(ctx, jobscheduler.Config{}), memCache, mux, cloneManager)
+			}, jobscheduler.New(ctx, jobscheduler.Config{}), memCache, mux, cloneManager, nil)
 			assert.NoError(t, err)
 			assert.NotZero(t, s)
 
@@ -117,3 +121,143 @@ func TestBundleInterval(t *testing.T) {
 		})
 	}
 }
+
+func TestBundleRangeRequests(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+	cloneManager := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: t.TempDir()})
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{})
+	assert.NoError(t, err)
+	mux := newTestMux()
+	_, err = git.New(ctx, git.Config{}, jobscheduler.New(ctx, jobscheduler.Config{}), memCache, mux, cloneManager, nil)
+	assert.NoError(t, err)
+
+	bundleData := []byte("0123456789abcdefghij")
+	writer, err := memCache.Create(ctx, cache.NewKey("https://github.com/org/repo.bundle"),
+		http.Header{"Content-Type": {"application/x-git-bundle"}}, time.Hour)
+	assert.NoError(t, err)
+	_, err = writer.Write(bundleData)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	tests := []struct {
+		name          string
+		rangeHeader   string
+		expectedCode  int
+		expectedBody  string
+		expectedRange string
+		expectedLen   string
+	}{
+		{"Full", "", http.StatusOK, string(bundleData), "", "20"},
+		{"Resume", "bytes=12-", http.StatusPartialContent, "cdefghij", "bytes 12-19/20", "8"},
+		{"Bounded", "bytes=2-5", http.StatusPartialContent, "2345", "bytes 2-5/20", "4"},
+		{"Suffix", "bytes=-3", http.StatusPartialContent, "hij", "bytes 17-19/20", "3"},
+		{"Unsatisfiable", "bytes=20-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */20", ""},
+		{"Malformed", "bytes=5-2", http.StatusOK, string(bundleData), "", "20"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/git/github.com/org/repo/bundle", nil)
+			req = req.WithContext(ctx)
+			req.SetPathValue("host", "github.com")
+			req.SetPathValue("path", "org/repo/bundle")
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			w := httptest.NewRecorder()
+			mux.handlers["GET /git/{host}/{path...}"].ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			assert.Equal(t, tt.expectedRange, w.Header().Get("Content-Range"))
+			if tt.expectedCode == http.StatusRequestedRangeNotSatisfiable {
+				return
+			}
+			assert.Equal(t, tt.expectedBody, w.Body.String())
+			assert.Equal(t, tt.expectedLen, w.Header().Get("Content-Length"))
+			assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+			assert.Equal(t, "application/x-git-bundle", w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestBundleInvalidatedOnRewrite(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+	tmpDir := t.TempDir()
+	run := func(args ...string) string {
+		t.Helper()
+		output, err := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...).CombinedOutput()
+		assert.NoError(t, err, "%s", output)
+		return strings.TrimSpace(string(output))
+	}
+
+	upstreamPath := filepath.Join(tmpDir, "upstream")
+	run("init", "-q", "-b", "main", upstreamPath)
+	run("-C", upstreamPath, "commit", "-q", "--allow-empty", "-m", "first")
+	run("-C", upstreamPath, "commit", "-q", "--allow-empty", "-m", "second")
+	original := run("-C", upstreamPath, "rev-parse", "HEAD")
+
+	// An existing clone, discovered on startup, whose origin is the local upstream.
+	mirrorRoot := filepath.Join(tmpDir, "mirrors")
+	run("clone", "-q", "file://"+upstreamPath, filepath.Join(mirrorRoot, "github.com", "org", "repo"))
+
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{})
+	assert.NoError(t, err)
+	mux := newTestMux()
+	cloneManager := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: mirrorRoot, FetchInterval: time.Millisecond})
+	_, err = git.New(ctx, git.Config{BundleInterval: time.Hour},
+		jobscheduler.New(ctx, jobscheduler.Config{}), memCache, mux, cloneManager, nil)
+	assert.NoError(t, err)
+	originalETag := waitForBundleRef(ctx, t, memCache, original).Get("ETag")
+	assert.NotEqual(t, "", originalETag)
+
+	// Force-push a rewritten history upstream, then trigger a fetch with a request.
+	run("-C", upstreamPath, "reset", "-q", "--hard", "HEAD~1")
+	run("-C", upstreamPath, "commit", "-q", "--allow-empty", "-m", "rewritten")
+	rewritten := run("-C", upstreamPath, "rev-parse", "HEAD")
+
+	req := httptest.NewRequest(http.MethodGet, "/git/github.com/org/repo/HEAD", nil).WithContext(ctx)
+	req.SetPathValue("host", "github.com")
+	req.SetPathValue("path", "org/repo/HEAD")
+	mux.handlers["GET /git/{host}/{path...}"].ServeHTTP(httptest.NewRecorder(), req)
+
+	// The bundle is regenerated well before the next interval.
+	assert.NotEqual(t, originalETag, waitForBundleRef(ctx, t, memCache, rewritten).Get("ETag"))
+
+	for _, tt := range []struct {
+		path         string
+		expectedCode int
+	}{
+		{"org/repo/bundle", http.StatusOK},
+		{"org/other/bundle", http.StatusNotFound},
+		{"org/repo/snapshot", http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(http.MethodDelete, "/git/github.com/"+tt.path, nil).WithContext(ctx)
+		req.SetPathValue("host", "github.com")
+		req.SetPathValue("path", tt.path)
+		w := httptest.NewRecorder()
+		mux.handlers["DELETE /git/{host}/{path...}"].ServeHTTP(w, req)
+		assert.Equal(t, tt.expectedCode, w.Code, tt.path)
+	}
+}
+
+// waitForBundleRef waits until the cached bundle of https://github.com/org/repo has main at commit, returning its
+// headers.
+func waitForBundleRef(ctx context.Context, t *testing.T, c cache.Cache, commit string) http.Header {
+	t.Helper()
+	expected := commit + " refs/remotes/origin/main\n"
+	var header string
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		r, headers, err := c.Open(ctx, cache.NewKey("https://github.com/org/repo.bundle"))
+		if err != nil {
+			continue
+		}
+		data, err := io.ReadAll(r)
+		_ = r.Close()
+		assert.NoError(t, err)
+		header, _, _ = strings.Cut(string(data), "\n\n")
+		if strings.Contains(header+"\n", expected) {
+			return headers
+		}
+	}
+	t.Fatalf("bundle does not contain %q, header:\n%s", expected, header)
+	return nil
+}
@@ -2,17 +2,20 @@
 package git
 
 import (
-	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -21,20 +24,54 @@ import (
 
 	"github.com/block/cachew/internal/cache"
 	"github.com/block/cachew/internal/gitclone"
+	cachewhttputil "github.com/block/cachew/internal/httputil"
 	"github.com/block/cachew/internal/jobscheduler"
 	"github.com/block/cachew/internal/logging"
 	"github.com/block/cachew/internal/strategy"
+	"github.com/block/cachew/internal/strategy/handler"
 )
 
-func Register(r *strategy.Registry, scheduler jobscheduler.Scheduler, cloneManager gitclone.ManagerProvider) {
+func Register(r *strategy.Registry, scheduler jobscheduler.Scheduler, cloneManager gitclone.ManagerProvider, authorizer cachewhttputil.Authorizer) {
 	strategy.Register(r, "git", "Caches Git repositories, including bundle and tarball snapshots.", func(ctx context.Context, config Config, cache cache.Cache, mux strategy.Mux) (*Strategy, error) {
-		return New(ctx, config, scheduler, cache, mux, cloneManager)
-	})
+		return New(ctx, config, scheduler, cache, mux, cloneManager, authorizer)
+	}, strategy.RequiresRestart())
 }
 
 type Config struct {
 	BundleInterval   time.Duration `hcl:"bundle-interval,optional" help:"How often to generate bundles. 0 disables bundling." default:"0"`
 	SnapshotInterval time.Duration `hcl:"snapshot-interval,optional" help:"How often to generate tar.zstd snapshots. 0 disables snapshots." default:"0"`
+	Repos            []RepoConfig  `hcl:"repo,block" help:"Per-repository overrides of the git-clone intervals. The first matching block applies."`
+	MaxDiscoveredAge time.Duration `hcl:"max-discovered-age,optional" help:"Clones found on disk at startup that were last fetched longer ago than this are fetched immediately. 0 disables." default:"0"`
+}
+
+// RepoConfig overrides the fetch and ref check intervals for repositories whose upstream URL matches Pattern.
+type RepoConfig struct {
+	Pattern          string        `hcl:"pattern,label" help:"Upstream URL glob, in path.Match syntax, eg. https://github.com/myorg/*"`
+	FetchInterval    time.Duration `hcl:"fetch-interval,optional" help:"How often to fetch from upstream. Defaults to the git-clone fetch-interval."`
+	RefCheckInterval time.Duration `hcl:"ref-check-interval,optional" help:"How long to cache ref checks. Defaults to the git-clone ref-check-interval."`
+}
+
+// Validate the configuration.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.BundleInterval < 0 {
+		errs = append(errs, errors.New("bundle-interval must not be negative"))
+	}
+	if c.SnapshotInterval < 0 {
+		errs = append(errs, errors.New("snapshot-interval must not be negative"))
+	}
+	if c.MaxDiscoveredAge < 0 {
+		errs = append(errs, errors.New("max-discovered-age must not be negative"))
+	}
+	for _, repo := range c.Repos {
+		if _, err := path.Match(repo.Pattern, ""); err != nil {
+			errs = append(errs, errors.Errorf("repo %q: %w", repo.Pattern, err))
+		}
+		if repo.FetchInterval < 0 || repo.RefCheckInterval < 0 {
+			errs = append(errs, errors.Errorf("repo %q: intervals must not be negative", repo.Pattern))
+		}
+	}
+	return errors.Join(errs...)
 }
 
 type Strategy struct {
@@ -56,9 +93,14 @@ func New(
 	cache cache.Cache,
 	mux strategy.Mux,
 	cloneManagerProvider gitclone.ManagerProvider,
+	authorizer cachewhttputil.Authorizer,
 ) (*Strategy, error) {
 	logger := logging.FromContext(ctx)
 
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
 	cloneManager, err := cloneManagerProvider()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create clone manager")
@@ -83,6 +125,18 @@ func New(
 			slog.String("error", err.Error()))
 	}
 	for _, repo := range existing {
+		if s.config.MaxDiscoveredAge > 0 && time.Since(repo.LastFetch()) >= s.config.MaxDiscoveredAge {
+			logger.InfoContext(ctx, "Refreshing stale clone found on disk",
+				slog.String("upstream", repo.UpstreamURL()),
+				slog.Time("last_fetch", repo.LastFetch()))
+			// Serving a stale clone would answer clients with outdated refs, so readiness waits for the refresh.
+			warmedUp := cachewhttputil.StartWarmup(ctx, "refresh "+repo.UpstreamURL())
+			s.scheduler.Submit(repo.UpstreamURL(), "fetch", func(ctx context.Context) error {
+				defer warmedUp()
+				s.fetch(ctx, repo)
+				return nil
+			})
+		}
 		if s.config.BundleInterval > 0 {
 			s.scheduleBundleJobs(repo)
 		}
@@ -105,8 +159,10 @@ func New(
 		},
 	}
 
+	mux.Handle("GET /git/_status", cachewhttputil.RequireAuthorization(authorizer, http.HandlerFunc(s.handleStatus)))
 	mux.Handle("GET /git/{host}/{path...}", http.HandlerFunc(s.handleRequest))
 	mux.Handle("POST /git/{host}/{path...}", http.HandlerFunc(s.handleRequest))
+	mux.Handle("DELETE /git/{host}/{path...}", cachewhttputil.RequireAuthorization(authorizer, http.HandlerFunc(s.handleBundleDelete)))
 
 	logger.InfoContext(ctx, "Git strategy initialized",
 		"bundle_interval", config.BundleInterval,
@@ -195,23 +251,35 @@ func (s *Strategy) handleRequest(w http.ResponseWriter, r *http.Request) {
 }
 
 // SpoolKeyForRequest returns the spool key for a request, or empty string if the
-// request is not spoolable. For POST requests, the body is hashed to differentiate
-// protocol v2 commands (e.g. ls-refs vs fetch) that share the same URL. The request
-// body is buffered and replaced so it can still be read by the caller.
+// request is not spoolable. Identical concurrent requests share a key, so only the
+// first is forwarded upstream while the rest are served from its spool.
+//
+// info/refs advertisements are keyed by service and Git-Protocol header, as the
+// advertisement differs between protocol versions. For POST requests, the body is
+// hashed to differentiate protocol v2 commands (e.g. ls-refs vs fetch) that share
+// the same URL. The request body is buffered and replaced so it can still be read
+// by the caller.
 func SpoolKeyForRequest(pathValue string, r *http.Request) (string, error) {
+	if strings.HasSuffix(pathValue, "/info/refs") {
+		service := r.URL.Query().Get("service")
+		if r.Method != http.MethodGet || service != "git-upload-pack" {
+			return "", nil
+		}
+		h := sha256.Sum256([]byte(service + "\n" + r.Header.Get("Git-Protocol")))
+		return "info-refs-" + hex.EncodeToString(h[:8]), nil
+	}
 	if !strings.HasSuffix(pathValue, "/git-upload-pack") {
 		return "", nil
 	}
 	if r.Method != http.MethodPost || r.Body == nil {
 		return "upload-pack", nil
 	}
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		return "", errors.Wrap(err, "read request body for spool key")
+	h := sha256.New()
+	h.Write([]byte(r.Header.Get("Git-Protocol") + "\n"))
+	if err := handler.HashBody(r, h); err != nil {
+		return "", errors.Wrap(err, "hash request body for spool key")
 	}
-	r.Body = io.NopCloser(bytes.NewReader(body))
-	h := sha256.Sum256(body)
-	return "upload-pack-" + hex.EncodeToString(h[:8]), nil
+	return "upload-pack-" + hex.EncodeToString(h.Sum(nil)[:8]), nil
 }
 
 func spoolDirForURL(mirrorRoot, upstreamURL string) string {
@@ -281,6 +349,14 @@ func (s *Strategy) serveWithSpool(w http.ResponseWriter, r *http.Request, host,
 			slog.String("key", key),
 			slog.String("upstream", upstreamURL))
 		tw := NewSpoolTeeWriter(w, spool)
+		defer func() {
+			// The proxy aborts the handler if the upstream body fails mid-stream, so readers must be told that the
+			// spool is incomplete rather than left waiting for it to complete.
+			if p := recover(); p != nil {
+				spool.MarkError(errors.Errorf("upstream response aborted: %v", p))
+				panic(p)
+			}
+		}()
 		s.forwardToUpstream(tw, r, host, pathValue)
 		spool.MarkComplete()
 		return
@@ -306,23 +382,43 @@ func (s *Strategy) serveWithSpool(w http.ResponseWriter, r *http.Request, host,
 		logger.WarnContext(ctx, "Spool read failed mid-stream",
 			slog.String("key", key),
 			slog.String("error", err.Error()))
+		// The status and part of the body have already been sent, so abort the connection rather than ending the
+		// response cleanly. Otherwise the client would treat the truncated response, eg. a partial pack, as complete.
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// dumbObjectPathRe matches the object paths requested by git's dumb HTTP protocol: the pack list and alternates,
+// pack files and their indexes, and loose objects.
+var dumbObjectPathRe = regexp.MustCompile(`/objects/(info/(packs|alternates|http-alternates)|pack/pack-[0-9a-f]{40,64}\.(pack|idx)|[0-9a-f]{2}/[0-9a-f]{38,62})$`)
+
+// splitGitOperation splits a request path into the repository path, including any ".git" suffix, and the smart or
+// dumb HTTP protocol path that follows it, eg. "org/repo.git/objects/info/packs" is split into "org/repo.git" and
+// "/objects/info/packs". The operation is empty if the path is not a git protocol request.
+func splitGitOperation(pathValue string) (repoPath, operation string) {
+	for _, op := range []string{"/info/refs", "/git-upload-pack", "/git-receive-pack", "/HEAD"} {
+		if prefix, ok := strings.CutSuffix(pathValue, op); ok {
+			return prefix, op
+		}
 	}
+	if loc := dumbObjectPathRe.FindStringIndex(pathValue); loc != nil {
+		return pathValue[:loc[0]], pathValue[loc[0]:]
+	}
+	return pathValue, ""
 }
 
 func ExtractRepoPath(pathValue string) string {
-	repoPath := pathValue
-	repoPath = strings.TrimSuffix(repoPath, "/info/refs")
-	repoPath = strings.TrimSuffix(repoPath, "/git-upload-pack")
-	repoPath = strings.TrimSuffix(repoPath, "/git-receive-pack")
-	repoPath = strings.TrimSuffix(repoPath, ".git")
-	return repoPath
+	repoPath, _ := splitGitOperation(pathValue)
+	return strings.TrimSuffix(repoPath, ".git")
 }
 
 func (s *Strategy) handleBundleRequest(w http.ResponseWriter, r *http.Request, host, pathValue string) {
-	s.serveCachedArtifact(w, r, host, pathValue, "bundle")
+	s.serveCachedArtifact(w, r, host, pathValue, "bundle", s.repairBundle)
 }
 
-func (s *Strategy) serveCachedArtifact(w http.ResponseWriter, r *http.Request, host, pathValue, artifact string) {
+// serveCachedArtifact serves an artifact generated from a repository. If the cached artifact is corrupt, repair is
+// called with the repository's upstream URL to generate it again, and the artifact is reported as not found until then.
+func (s *Strategy) serveCachedArtifact(w http.ResponseWriter, r *http.Request, host, pathValue, artifact string, repair func(ctx context.Context, upstreamURL string)) {
 	ctx := r.Context()
 	logger := logging.FromContext(ctx)
 
@@ -335,8 +431,18 @@ func (s *Strategy) serveCachedArtifact(w http.ResponseWriter, r *http.Request, h
 	upstreamURL := "https://" + host + "/" + repoPath
 	cacheKey := cache.NewKey(upstreamURL + "." + artifact)
 
-	reader, headers, err := s.cache.Open(ctx, cacheKey)
-	if err != nil {
+	reader, headers, code, err := s.openCachedArtifact(r, cacheKey)
+	if rangeErr, ok := errors.AsType[*cache.RangeNotSatisfiableError](err); ok {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", rangeErr.Size))
+		http.Error(w, "Range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return
+	} else if err != nil {
+		if errors.Is(err, cache.ErrCorrupt) {
+			logger.WarnContext(ctx, "Regenerating corrupt "+artifact,
+				slog.String("upstream", upstreamURL),
+				slog.String("error", err.Error()))
+			repair(ctx, upstreamURL)
+		}
 		if errors.Is(err, os.ErrNotExist) {
 			logger.DebugContext(ctx, artifact+" not found in cache",
 				slog.String("upstream", upstreamURL))
@@ -356,6 +462,19 @@ func (s *Strategy) serveCachedArtifact(w http.ResponseWriter, r *http.Request, h
 			w.Header().Add(key, value)
 		}
 	}
+	if _, ok := s.cache.(cache.RangeOpener); ok {
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+	if notModified(r, headers) {
+		w.Header().Del("Content-Length")
+		w.Header().Del("Content-Range")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(code)
+	if r.Method == http.MethodHead {
+		return
+	}
 
 	_, err = io.Copy(w, reader)
 	if err != nil {
@@ -365,6 +484,76 @@ func (s *Strategy) serveCachedArtifact(w http.ResponseWriter, r *http.Request, h
 	}
 }
 
+// openCachedArtifact opens an artifact from the cache, returning the status code to respond with.
+//
+// If the cache is a [cache.RangeOpener] the response includes a Content-Length, and a single "Range: bytes=" request
+// is served with "206 Partial Content" so that interrupted downloads of large bundles can be resumed. Otherwise the
+// whole artifact is served without a Content-Length.
+func (s *Strategy) openCachedArtifact(r *http.Request, key cache.Key) (io.ReadCloser, http.Header, int, error) {
+	ctx := r.Context()
+	ro, ok := s.cache.(cache.RangeOpener)
+	if !ok {
+		reader, headers, err := s.cache.Open(ctx, key)
+		return reader, headers, http.StatusOK, errors.WithStack(err)
+	}
+	// If-Range validation is not supported, so conditional range requests always receive the full artifact.
+	if rng, ok := cache.ParseRange(r.Header.Get("Range")); ok && r.Header.Get("If-Range") == "" {
+		reader, headers, err := ro.OpenRange(ctx, key, rng)
+		return reader, headers, http.StatusPartialContent, errors.WithStack(err)
+	}
+	reader, headers, err := ro.OpenRange(ctx, key, cache.Range{Start: 0, End: -1})
+	if _, ok := errors.AsType[*cache.RangeNotSatisfiableError](err); ok {
+		// Only an empty artifact cannot satisfy a range covering the whole object.
+		reader, headers, err = s.cache.Open(ctx, key)
+		if err != nil {
+			return nil, nil, 0, errors.WithStack(err)
+		}
+		headers = headers.Clone()
+		headers.Set("Content-Length", "0")
+		return reader, headers, http.StatusOK, nil
+	} else if err != nil {
+		return nil, nil, 0, errors.WithStack(err)
+	}
+	headers.Del("Content-Range")
+	return reader, headers, http.StatusOK, nil
+}
+
+// notModified evaluates the If-None-Match and If-Modified-Since headers of a request against the headers of a cached
+// artifact, returning true if the client's copy is current.
+//
+// As in RFC 9110, If-Modified-Since is ignored if If-None-Match is present, and entity tags are compared weakly.
+func notModified(r *http.Request, headers http.Header) bool {
+	if values := r.Header.Values("If-None-Match"); len(values) > 0 {
+		etag := strings.TrimPrefix(headers.Get("ETag"), "W/")
+		for _, candidate := range strings.Split(strings.Join(values, ","), ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || (etag != "" && strings.TrimPrefix(candidate, "W/") == etag) {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(headers.Get("Last-Modified"))
+	return err == nil && !lastModified.After(since)
+}
+
+// refsETag returns a weak entity tag for an artifact generated from the clone at dir. It is derived from the clone's
+// refs, so it changes whenever the clone's history does, but artifacts generated from the same refs share a tag even
+// if they are not byte-for-byte identical.
+func refsETag(ctx context.Context, dir string) (string, error) {
+	// #nosec G204 - dir is controlled by us
+	output, err := exec.CommandContext(ctx, "git", "-C", dir, "for-each-ref", "--format=%(objectname) %(refname)").CombinedOutput()
+	if err != nil {
+		return "", errors.Wrapf(err, "git for-each-ref: %s", string(output))
+	}
+	sum := sha256.Sum256(output)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
 func (s *Strategy) startClone(ctx context.Context, repo *gitclone.Repository) {
 	logger := logging.FromContext(ctx)
 
@@ -398,8 +587,28 @@ func (s *Strategy) startClone(ctx context.Context, repo *gitclone.Repository) {
 	}
 }
 
+// intervals returns the fetch and ref check intervals for a repository, from the first matching repo override and
+// falling back to the clone manager's defaults.
+func (s *Strategy) intervals(upstreamURL string) (fetch, refCheck time.Duration) {
+	defaults := s.cloneManager.Config()
+	fetch, refCheck = defaults.FetchInterval, defaults.RefCheckInterval
+	for _, repo := range s.config.Repos {
+		if matched, _ := path.Match(repo.Pattern, upstreamURL); !matched { //nolint:errcheck // Validated in New.
+			continue
+		}
+		if repo.FetchInterval > 0 {
+			fetch = repo.FetchInterval
+		}
+		if repo.RefCheckInterval > 0 {
+			refCheck = repo.RefCheckInterval
+		}
+		break
+	}
+	return fetch, refCheck
+}
+
 func (s *Strategy) maybeBackgroundFetch(repo *gitclone.Repository) {
-	if !repo.NeedsFetch(s.cloneManager.Config().FetchInterval) {
+	if fetchInterval, _ := s.intervals(repo.UpstreamURL()); !repo.NeedsFetch(fetchInterval) {
 		return
 	}
 
@@ -410,21 +619,27 @@ func (s *Strategy) maybeBackgroundFetch(repo *gitclone.Repository) {
 }
 
 func (s *Strategy) backgroundFetch(ctx context.Context, repo *gitclone.Repository) {
-	logger := logging.FromContext(ctx)
-
-	if !repo.NeedsFetch(s.cloneManager.Config().FetchInterval) {
+	if fetchInterval, _ := s.intervals(repo.UpstreamURL()); !repo.NeedsFetch(fetchInterval) {
 		return
 	}
+	s.fetch(ctx, repo)
+}
+
+func (s *Strategy) fetch(ctx context.Context, repo *gitclone.Repository) {
+	logger := logging.FromContext(ctx)
 
 	logger.DebugContext(ctx, "Fetching updates",
 		slog.String("upstream", repo.UpstreamURL()),
 		slog.String("path", repo.Path()))
 
-	if err := repo.Fetch(ctx); err != nil {
+	rewritten, err := repo.Fetch(ctx)
+	if err != nil {
 		logger.ErrorContext(ctx, "Fetch failed",
 			slog.String("upstream", repo.UpstreamURL()),
 			slog.String("error", err.Error()))
+		return
 	}
+	s.invalidateRewrittenBundle(ctx, repo, rewritten)
 }
 
 func (s *Strategy) scheduleBundleJobs(repo *gitclone.Repository) {
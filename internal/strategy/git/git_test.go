@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/alecthomas/assert/v2"
 
@@ -67,7 +68,7 @@ func TestNew(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			mux := newTestMux()
 			cm := gitclone.NewManagerProvider(ctx, tt.config)
-			s, err := git.New(ctx, git.Config{}, jobscheduler.New(ctx, jobscheduler.Config{}), nil, mux, cm)
+			s, err := git.New(ctx, git.Config{}, jobscheduler.New(ctx, jobscheduler.Config{}), nil, mux, cm, nil)
 			if tt.wantError != "" {
 				assert.Error(t, err)
 				assert.Contains(t, err.Error(), tt.wantError)
@@ -120,6 +121,26 @@ func TestExtractRepoPath(t *testing.T) {
 			input:    "org/repo",
 			expected: "org/repo",
 		},
+		{
+			name:     "DumbHead",
+			input:    "org/repo.git/HEAD",
+			expected: "org/repo",
+		},
+		{
+			name:     "DumbInfoPacks",
+			input:    "org/repo/objects/info/packs",
+			expected: "org/repo",
+		},
+		{
+			name:     "DumbPackFile",
+			input:    "org/repo.git/objects/pack/pack-0123456789abcdef0123456789abcdef01234567.pack",
+			expected: "org/repo",
+		},
+		{
+			name:     "DumbLooseObject",
+			input:    "org/objects/repo/objects/ab/0123456789abcdef0123456789abcdef012345",
+			expected: "org/objects/repo",
+		},
 	}
 
 	for _, tt := range tests {
@@ -151,11 +172,46 @@ func TestNewWithExistingCloneOnDisk(t *testing.T) {
 		MirrorRoot:    tmpDir,
 		FetchInterval: 15,
 	})
-	s, err := git.New(ctx, git.Config{}, jobscheduler.New(ctx, jobscheduler.Config{}), nil, mux, cm)
+	s, err := git.New(ctx, git.Config{}, jobscheduler.New(ctx, jobscheduler.Config{}), nil, mux, cm, nil)
 	assert.NoError(t, err)
 	assert.NotZero(t, s)
 }
 
+// recordingScheduler records submitted jobs without running them.
+type recordingScheduler struct {
+	jobs []string
+}
+
+func (s *recordingScheduler) WithQueuePrefix(string) jobscheduler.Scheduler { return s }
+
+func (s *recordingScheduler) Submit(queue, id string, _ func(context.Context) error) {
+	s.jobs = append(s.jobs, queue+" "+id)
+}
+
+func (s *recordingScheduler) SubmitPeriodicJob(queue, id string, _ time.Duration, _ func(context.Context) error) {
+	s.jobs = append(s.jobs, queue+" "+id)
+}
+
+func TestNewRefreshesStaleDiscoveredClones(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+	tmpDir := t.TempDir()
+
+	for name, age := range map[string]time.Duration{"stale": 30 * 24 * time.Hour, "fresh": time.Minute} {
+		gitDir := filepath.Join(tmpDir, "github.com", "org", name, ".git")
+		assert.NoError(t, os.MkdirAll(gitDir, 0o750))
+		headPath := filepath.Join(gitDir, "HEAD")
+		assert.NoError(t, os.WriteFile(headPath, []byte("ref: refs/heads/main\n"), 0o640))
+		mtime := time.Now().Add(-age)
+		assert.NoError(t, os.Chtimes(headPath, mtime, mtime))
+	}
+
+	scheduler := &recordingScheduler{}
+	cm := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: tmpDir})
+	_, err := git.New(ctx, git.Config{MaxDiscoveredAge: 24 * time.Hour}, scheduler, nil, newTestMux(), cm, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"https://github.com/org/stale fetch"}, scheduler.jobs)
+}
+
 func TestIntegrationWithMockUpstream(t *testing.T) {
 	_, ctx := logging.Configure(context.Background(), logging.Config{})
 
@@ -175,7 +231,7 @@ func TestIntegrationWithMockUpstream(t *testing.T) {
 		MirrorRoot:    tmpDir,
 		FetchInterval: 15,
 	})
-	_, err := git.New(ctx, git.Config{}, jobscheduler.New(ctx, jobscheduler.Config{}), nil, mux, cm)
+	_, err := git.New(ctx, git.Config{}, jobscheduler.New(ctx, jobscheduler.Config{}), nil, mux, cm, nil)
 	assert.NoError(t, err)
 
 	// Verify handlers exist
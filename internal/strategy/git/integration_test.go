@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"math/rand/v2"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -62,7 +63,7 @@ func TestIntegrationGitCloneViaProxy(t *testing.T) {
 		FetchInterval: 15,
 	})
 	mux := http.NewServeMux()
-	strategy, err := git.New(ctx, git.Config{}, jobscheduler.New(ctx, jobscheduler.Config{}), nil, mux, gc)
+	strategy, err := git.New(ctx, git.Config{}, jobscheduler.New(ctx, jobscheduler.Config{}), nil, mux, gc, nil)
 	assert.NoError(t, err)
 	assert.NotZero(t, strategy)
 
@@ -142,7 +143,7 @@ func TestIntegrationGitFetchViaProxy(t *testing.T) {
 	})
 
 	mux := http.NewServeMux()
-	_, err = git.New(ctx, git.Config{}, jobscheduler.New(ctx, jobscheduler.Config{}), nil, mux, gc)
+	_, err = git.New(ctx, git.Config{}, jobscheduler.New(ctx, jobscheduler.Config{}), nil, mux, gc, nil)
 	assert.NoError(t, err)
 
 	server := testServerWithLogging(ctx, mux)
@@ -222,7 +223,7 @@ func TestIntegrationPushForwardsToUpstream(t *testing.T) {
 		MirrorRoot:    clonesDir,
 		FetchInterval: 15,
 	})
-	_, err = git.New(ctx, git.Config{}, jobscheduler.New(ctx, jobscheduler.Config{}), nil, mux, gc)
+	_, err = git.New(ctx, git.Config{}, jobscheduler.New(ctx, jobscheduler.Config{}), nil, mux, gc, nil)
 	assert.NoError(t, err)
 
 	server := testServerWithLogging(ctx, mux)
@@ -316,7 +317,7 @@ func TestIntegrationSpoolReusesDuringClone(t *testing.T) {
 		MirrorRoot:    clonesDir,
 		FetchInterval: 15,
 	})
-	strategy, err := git.New(ctx, git.Config{}, jobscheduler.New(ctx, jobscheduler.Config{}), nil, mux, gc)
+	strategy, err := git.New(ctx, git.Config{}, jobscheduler.New(ctx, jobscheduler.Config{}), nil, mux, gc, nil)
 	assert.NoError(t, err)
 
 	strategy.SetHTTPTransport(&countingTransport{
@@ -374,3 +375,203 @@ func TestIntegrationSpoolReusesDuringClone(t *testing.T) {
 	t.Logf("Total upstream upload-pack requests: %d (first clone: %d)", totalCount, firstCloneCount)
 	assert.Equal(t, firstCloneCount, totalCount, "second clone should not have made additional upstream upload-pack requests")
 }
+
+// TestIntegrationDumbHTTPFetchFromMirror verifies that clients restricted to git's dumb HTTP protocol are served
+// from an existing local mirror.
+func TestIntegrationDumbHTTPFetchFromMirror(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+	tmpDir := t.TempDir()
+	clonesDir := filepath.Join(tmpDir, "clones")
+	upstreamDir := filepath.Join(tmpDir, "upstream")
+	workDir := filepath.Join(tmpDir, "work")
+
+	// Seed the mirror directly from a local repository, so it is discovered as ready and no upstream is needed.
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main", upstreamDir},
+		{"-C", upstreamDir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "first"},
+		{"-C", upstreamDir, "gc", "-q"},
+		{"-C", upstreamDir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "second"},
+		{"clone", "-q", "--no-local", upstreamDir, filepath.Join(clonesDir, "example.invalid", "org", "repo")},
+	} {
+		output, err := exec.Command("git", args...).CombinedOutput()
+		assert.NoError(t, err, "%s", output)
+	}
+
+	gc := gitclone.NewManagerProvider(ctx, gitclone.Config{
+		MirrorRoot:    clonesDir,
+		FetchInterval: 15,
+	})
+	mux := http.NewServeMux()
+	_, err := git.New(ctx, git.Config{}, jobscheduler.New(ctx, jobscheduler.Config{}), nil, mux, gc, nil)
+	assert.NoError(t, err)
+
+	var dumbRequests atomic.Int32
+	server := testServerWithLogging(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/objects/") {
+			dumbRequests.Add(1)
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	cmd := exec.Command("git", "clone", server.URL+"/git/example.invalid/org/repo", filepath.Join(workDir, "repo"))
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_SMART_HTTP=0")
+	output, err := cmd.CombinedOutput()
+	assert.NoError(t, err, "%s", output)
+
+	output, err = exec.Command("git", "-C", filepath.Join(workDir, "repo"), "log", "--format=%s").CombinedOutput()
+	assert.NoError(t, err, "%s", output)
+	assert.Equal(t, "second\nfirst\n", string(output))
+	assert.True(t, dumbRequests.Load() > 0, "expected the clone to use the dumb HTTP protocol")
+}
+
+// TestIntegrationLargeCloneFromMirror clones a repository with a pack too large to buffer through the proxy, and
+// checks that the response is completely relayed.
+func TestIntegrationLargeCloneFromMirror(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+	tmpDir := t.TempDir()
+	clonesDir := filepath.Join(tmpDir, "clones")
+	upstreamDir := filepath.Join(tmpDir, "upstream")
+	workDir := filepath.Join(tmpDir, "work")
+
+	// Incompressible content, so that the pack is as large as the files.
+	assert.NoError(t, os.MkdirAll(upstreamDir, 0o750))
+	random := rand.NewChaCha8([32]byte{})
+	for i := range 8 {
+		data := make([]byte, 2<<20)
+		_, _ = random.Read(data)
+		assert.NoError(t, os.WriteFile(filepath.Join(upstreamDir, fmt.Sprintf("data-%d.bin", i)), data, 0o600))
+	}
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main", upstreamDir},
+		{"-C", upstreamDir, "add", "."},
+		{"-C", upstreamDir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "large"},
+		{"clone", "-q", "--no-local", upstreamDir, filepath.Join(clonesDir, "example.invalid", "org", "repo")},
+	} {
+		output, err := exec.Command("git", args...).CombinedOutput()
+		assert.NoError(t, err, "%s", output)
+	}
+
+	gc := gitclone.NewManagerProvider(ctx, gitclone.Config{
+		MirrorRoot:    clonesDir,
+		FetchInterval: 15,
+	})
+	mux := http.NewServeMux()
+	_, err := git.New(ctx, git.Config{}, jobscheduler.New(ctx, jobscheduler.Config{}), nil, mux, gc, nil)
+	assert.NoError(t, err)
+	server := testServerWithLogging(ctx, mux)
+	defer server.Close()
+
+	clientDir := filepath.Join(workDir, "repo")
+	cmd := exec.Command("git", "clone", "--progress", server.URL+"/git/example.invalid/org/repo", clientDir)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	output, err := cmd.CombinedOutput()
+	assert.NoError(t, err, "%s", output)
+	assert.NotContains(t, string(output), "early EOF")
+
+	output, err = exec.Command("git", "-C", clientDir, "fsck", "--full").CombinedOutput()
+	assert.NoError(t, err, "%s", output)
+	upstreamTree, err := exec.Command("git", "-C", upstreamDir, "rev-parse", "HEAD^{tree}").CombinedOutput()
+	assert.NoError(t, err, "%s", upstreamTree)
+	clientTree, err := exec.Command("git", "-C", clientDir, "rev-parse", "HEAD^{tree}").CombinedOutput()
+	assert.NoError(t, err, "%s", clientTree)
+	assert.Equal(t, string(upstreamTree), string(clientTree))
+}
+
+// TestIntegrationPartialCloneFromFilteredMirror clones with a blob filter through a mirror created with the same
+// filter, and checks that a large blob missing from both is only fetched by the client when accessed.
+func TestIntegrationPartialCloneFromFilteredMirror(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+	tmpDir := t.TempDir()
+	clonesDir := filepath.Join(tmpDir, "clones")
+	upstreamDir := filepath.Join(tmpDir, "upstream")
+	mirrorDir := filepath.Join(clonesDir, "example.invalid", "org", "repo")
+	workDir := filepath.Join(tmpDir, "work")
+
+	// The large blob is only in history, as the mirror's checkout of HEAD fetches the blobs it needs.
+	large := strings.Repeat("large blob\n", 1000)
+	assert.NoError(t, os.MkdirAll(upstreamDir, 0o750))
+	assert.NoError(t, os.WriteFile(filepath.Join(upstreamDir, "data.txt"), []byte(large), 0o600))
+	commit := []string{"-C", upstreamDir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q"}
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main", upstreamDir},
+		{"-C", upstreamDir, "config", "uploadpack.allowFilter", "true"},
+		{"-C", upstreamDir, "config", "uploadpack.allowAnySHA1InWant", "true"},
+		{"-C", upstreamDir, "add", "data.txt"},
+		append(commit, "-m", "large"),
+	} {
+		output, err := exec.Command("git", args...).CombinedOutput()
+		assert.NoError(t, err, "%s", output)
+	}
+	assert.NoError(t, os.WriteFile(filepath.Join(upstreamDir, "data.txt"), []byte("small\n"), 0o600))
+	// Seed the mirror directly, as cachew would create it, so it is discovered as ready with the upstream as its
+	// promisor remote.
+	for _, args := range [][]string{
+		append(commit, "-a", "-m", "small"),
+		{"clone", "-q", "--filter=blob:limit=1k", "file://" + upstreamDir, mirrorDir},
+	} {
+		output, err := exec.Command("git", args...).CombinedOutput()
+		assert.NoError(t, err, "%s", output)
+	}
+	output, err := exec.Command("git", "-C", upstreamDir, "rev-parse", "HEAD~1:data.txt").CombinedOutput()
+	assert.NoError(t, err, "%s", output)
+	largeBlob := strings.TrimSpace(string(output))
+
+	// Lists objects, marking missing ones with "?", without fetching them.
+	missing := func(dir string) string {
+		cmd := exec.Command("git", "-C", dir, "rev-list", "--objects", "--missing=print", "--all")
+		cmd.Env = append(os.Environ(), "GIT_NO_LAZY_FETCH=1")
+		output, err := cmd.CombinedOutput()
+		assert.NoError(t, err, "%s", output)
+		return string(output)
+	}
+	assert.Contains(t, missing(mirrorDir), "?"+largeBlob)
+
+	gc := gitclone.NewManagerProvider(ctx, gitclone.Config{
+		MirrorRoot:    clonesDir,
+		FetchInterval: 15,
+		Filter:        "blob:limit=1k",
+	})
+	mux := http.NewServeMux()
+	_, err = git.New(ctx, git.Config{}, jobscheduler.New(ctx, jobscheduler.Config{}), nil, mux, gc, nil)
+	assert.NoError(t, err)
+	server := testServerWithLogging(ctx, mux)
+	defer server.Close()
+
+	clientDir := filepath.Join(workDir, "repo")
+	cmd := exec.Command("git", "clone", "-q", "--filter=blob:limit=1k", server.URL+"/git/example.invalid/org/repo", clientDir)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	output, err = cmd.CombinedOutput()
+	assert.NoError(t, err, "%s", output)
+	// The mirror may fetch the blob from upstream to learn its size, but the client only fetches it when accessed.
+	assert.Contains(t, missing(clientDir), "?"+largeBlob)
+
+	cmd = exec.Command("git", "-C", clientDir, "show", "HEAD~1:data.txt")
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	output, err = cmd.CombinedOutput()
+	assert.NoError(t, err, "%s", output)
+	assert.Equal(t, large, string(output))
+	assert.NotContains(t, missing(clientDir), "?"+largeBlob)
+	assert.NotContains(t, missing(mirrorDir), "?"+largeBlob)
+}
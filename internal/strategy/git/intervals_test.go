@@ -0,0 +1,92 @@
+package git //nolint:testpackage // white-box testing required to observe background fetch scheduling
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/jobscheduler"
+	"github.com/block/cachew/internal/logging"
+)
+
+// countingScheduler runs jobs synchronously, counting them by queue and ID.
+type countingScheduler struct {
+	ctx  context.Context
+	mu   sync.Mutex
+	runs map[string]int
+}
+
+func (c *countingScheduler) WithQueuePrefix(string) jobscheduler.Scheduler { return c }
+
+func (c *countingScheduler) Submit(queue, id string, run func(ctx context.Context) error) {
+	c.mu.Lock()
+	c.runs[queue+":"+id]++
+	c.mu.Unlock()
+	_ = run(c.ctx)
+}
+
+func (c *countingScheduler) SubmitPeriodicJob(queue, id string, _ time.Duration, run func(ctx context.Context) error) {
+	c.Submit(queue, id, run)
+}
+
+func (c *countingScheduler) count(queue, id string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.runs[queue+":"+id]
+}
+
+func TestRepoFetchIntervalOverride(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found in PATH")
+	}
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	tmpDir := t.TempDir()
+
+	upstreams := map[string]string{}
+	for _, name := range []string{"fast", "slow"} {
+		dir := filepath.Join(tmpDir, "upstream", name)
+		for _, args := range [][]string{
+			{"init", "-q", "-b", "main", dir},
+			{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "initial"},
+		} {
+			output, err := exec.Command("git", args...).CombinedOutput()
+			assert.NoError(t, err, "%s", output)
+		}
+		upstreams[name] = "file://" + dir
+	}
+
+	scheduler := &countingScheduler{ctx: ctx, runs: map[string]int{}}
+	cm := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: filepath.Join(tmpDir, "clones"), FetchInterval: time.Hour})
+	s, err := New(ctx, Config{
+		Repos: []RepoConfig{{Pattern: "file://" + tmpDir + "/upstream/f*", FetchInterval: time.Millisecond}},
+	}, scheduler, nil, http.NewServeMux(), cm, nil)
+	assert.NoError(t, err)
+	_, err = New(ctx, Config{Repos: []RepoConfig{{Pattern: "[", FetchInterval: time.Millisecond}}}, scheduler, nil, http.NewServeMux(), cm, nil)
+	assert.Error(t, err)
+
+	repos := map[string]*gitclone.Repository{}
+	for name, upstream := range upstreams {
+		repo, err := s.cloneManager.GetOrCreate(ctx, upstream)
+		assert.NoError(t, err)
+		assert.NoError(t, repo.Clone(ctx))
+		repos[name] = repo
+	}
+
+	for range 5 {
+		time.Sleep(5 * time.Millisecond)
+		for _, repo := range repos {
+			s.maybeBackgroundFetch(repo)
+		}
+	}
+
+	assert.Equal(t, 5, scheduler.count(upstreams["fast"], "fetch"))
+	assert.Equal(t, 0, scheduler.count(upstreams["slow"], "fetch"))
+}
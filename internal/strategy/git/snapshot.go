@@ -24,7 +24,7 @@ func (s *Strategy) generateAndUploadSnapshot(ctx context.Context, repo *gitclone
 	ttl := 7 * 24 * time.Hour
 	excludePatterns := []string{"*.lock"}
 
-	err := errors.Wrap(snapshot.Create(ctx, s.cache, cacheKey, repo.Path(), ttl, excludePatterns), "create snapshot")
+	err := errors.Wrap(s.createSnapshot(ctx, repo, cacheKey, ttl, excludePatterns), "create snapshot")
 	if err != nil {
 		logger.ErrorContext(ctx, "Snapshot generation failed", slog.String("upstream", upstream), slog.String("error", err.Error()))
 		return err
@@ -34,6 +34,17 @@ func (s *Strategy) generateAndUploadSnapshot(ctx context.Context, repo *gitclone
 	return nil
 }
 
+func (s *Strategy) createSnapshot(ctx context.Context, repo *gitclone.Repository, key cache.Key, ttl time.Duration, excludePatterns []string) error {
+	return errors.WithStack(repo.WithReadLock(func() error {
+		// The ETag is derived under the same lock as the archive, so that it describes exactly the refs archived.
+		etag, err := refsETag(ctx, repo.Path())
+		if err != nil {
+			return err
+		}
+		return errors.WithStack(snapshot.Create(ctx, s.cache, key, repo.Path(), ttl, excludePatterns, snapshot.Header("ETag", etag)))
+	}))
+}
+
 func (s *Strategy) scheduleSnapshotJobs(repo *gitclone.Repository) {
 	s.scheduler.SubmitPeriodicJob(repo.UpstreamURL(), "snapshot-periodic", s.config.SnapshotInterval, func(ctx context.Context) error {
 		return s.generateAndUploadSnapshot(ctx, repo)
@@ -41,5 +52,15 @@ func (s *Strategy) scheduleSnapshotJobs(repo *gitclone.Repository) {
 }
 
 func (s *Strategy) handleSnapshotRequest(w http.ResponseWriter, r *http.Request, host, pathValue string) {
-	s.serveCachedArtifact(w, r, host, pathValue, "snapshot")
+	s.serveCachedArtifact(w, r, host, pathValue, "snapshot", s.repairSnapshot)
+}
+
+// repairSnapshot schedules the regeneration of the snapshot of upstreamURL, eg. after it was found to be corrupt,
+// rather than waiting for the next interval.
+func (s *Strategy) repairSnapshot(_ context.Context, upstreamURL string) {
+	if repo := s.cloneManager.Get(upstreamURL); s.config.SnapshotInterval > 0 && repo != nil && repo.State() == gitclone.StateReady {
+		s.scheduler.Submit(upstreamURL, "snapshot", func(ctx context.Context) error {
+			return s.generateAndUploadSnapshot(ctx, repo)
+		})
+	}
 }
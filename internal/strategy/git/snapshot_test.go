@@ -29,7 +29,7 @@ func TestSnapshotHTTPEndpoint(t *testing.T) {
 	})
 	_, err = git.New(ctx, git.Config{
 		SnapshotInterval: 24 * time.Hour,
-	}, jobscheduler.New(ctx, jobscheduler.Config{}), memCache, mux, cm)
+	}, jobscheduler.New(ctx, jobscheduler.Config{}), memCache, mux, cm, nil)
 	assert.NoError(t, err)
 
 	// Create a fake snapshot in the cache
@@ -103,9 +103,74 @@ func TestSnapshotInterval(t *testing.T) {
 			})
 			s, err := git.New(ctx, git.Config{
 				SnapshotInterval: tt.snapshotInterval,
-			}, jobscheduler.New(ctx, jobscheduler.Config{}), memCache, mux, cm)
+			}, jobscheduler.New(ctx, jobscheduler.Config{}), memCache, mux, cm, nil)
 			assert.NoError(t, err)
 			assert.NotZero(t, s)
 		})
 	}
 }
+
+func TestSnapshotConditionalRequests(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{})
+	assert.NoError(t, err)
+	mux := newTestMux()
+	cm := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: t.TempDir()})
+	_, err = git.New(ctx, git.Config{}, jobscheduler.New(ctx, jobscheduler.Config{}), memCache, mux, cm, nil)
+	assert.NoError(t, err)
+
+	lastModified := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	snapshotData := []byte("fake snapshot data")
+	writer, err := memCache.Create(ctx, cache.NewKey("https://github.com/org/repo.snapshot"), http.Header{
+		"Content-Type":  {"application/zstd"},
+		"Etag":          {`W/"abc"`},
+		"Last-Modified": {lastModified.Format(http.TimeFormat)},
+	}, time.Hour)
+	assert.NoError(t, err)
+	_, err = writer.Write(snapshotData)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	tests := []struct {
+		name         string
+		method       string
+		headers      map[string]string
+		expectedCode int
+		expectedBody string
+	}{
+		{"Head", http.MethodHead, nil, http.StatusOK, ""},
+		{"Get", http.MethodGet, nil, http.StatusOK, string(snapshotData)},
+		{"IfNoneMatchMatches", http.MethodGet, map[string]string{"If-None-Match": `W/"abc"`}, http.StatusNotModified, ""},
+		{"IfNoneMatchComparesWeakly", http.MethodGet, map[string]string{"If-None-Match": `"abc"`}, http.StatusNotModified, ""},
+		{"IfNoneMatchList", http.MethodHead, map[string]string{"If-None-Match": `"xyz", W/"abc"`}, http.StatusNotModified, ""},
+		{"IfNoneMatchDiffers", http.MethodGet, map[string]string{"If-None-Match": `W/"xyz"`}, http.StatusOK, string(snapshotData)},
+		{"IfModifiedSinceCurrent", http.MethodGet, map[string]string{"If-Modified-Since": lastModified.Format(http.TimeFormat)},
+			http.StatusNotModified, ""},
+		{"IfModifiedSinceStale", http.MethodGet, map[string]string{"If-Modified-Since": lastModified.Add(-time.Hour).Format(http.TimeFormat)},
+			http.StatusOK, string(snapshotData)},
+		{"IfNoneMatchTakesPrecedence", http.MethodGet, map[string]string{
+			"If-None-Match":     `W/"xyz"`,
+			"If-Modified-Since": lastModified.Format(http.TimeFormat),
+		}, http.StatusOK, string(snapshotData)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/git/github.com/org/repo/snapshot", nil).WithContext(ctx)
+			req.SetPathValue("host", "github.com")
+			req.SetPathValue("path", "org/repo/snapshot")
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			mux.handlers["GET /git/{host}/{path...}"].ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			assert.Equal(t, tt.expectedBody, w.Body.String())
+			assert.Equal(t, `W/"abc"`, w.Header().Get("ETag"))
+			if tt.expectedCode == http.StatusOK {
+				assert.Equal(t, "18", w.Header().Get("Content-Length"))
+				assert.Equal(t, "application/zstd", w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
@@ -1,17 +1,26 @@
 package git_test
 
 import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/alecthomas/assert/v2"
 
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/jobscheduler"
+	"github.com/block/cachew/internal/logging"
 	"github.com/block/cachew/internal/strategy/git"
 )
 
@@ -331,7 +340,7 @@ func TestSpoolKeyForRequest(t *testing.T) {
 		body     string
 		expected string
 	}{
-		{name: "InfoRefs", path: "org/repo.git/info/refs", method: http.MethodGet, expected: ""},
+		{name: "InfoRefsNoService", path: "org/repo.git/info/refs", method: http.MethodGet, expected: ""},
 		{name: "UploadPackGET", path: "org/repo.git/git-upload-pack", method: http.MethodGet, expected: "upload-pack"},
 		{name: "Unknown", path: "org/repo.git/something-else", method: http.MethodGet, expected: ""},
 		{name: "Plain", path: "org/repo", method: http.MethodGet, expected: ""},
@@ -370,6 +379,22 @@ func TestSpoolKeyForRequest(t *testing.T) {
 	}
 }
 
+func TestSpoolKeyForInfoRefs(t *testing.T) {
+	key := func(service, protocol string) string {
+		r := httptest.NewRequest(http.MethodGet, "/org/repo.git/info/refs?service="+service, nil)
+		if protocol != "" {
+			r.Header.Set("Git-Protocol", protocol)
+		}
+		key, err := git.SpoolKeyForRequest("org/repo.git/info/refs", r)
+		assert.NoError(t, err)
+		return key
+	}
+	assert.Equal(t, "", key("git-receive-pack", ""))
+	assert.Contains(t, key("git-upload-pack", ""), "info-refs-")
+	assert.Equal(t, key("git-upload-pack", "version=2"), key("git-upload-pack", "version=2"))
+	assert.NotEqual(t, key("git-upload-pack", ""), key("git-upload-pack", "version=2"))
+}
+
 func TestResponseSpoolLargeData(t *testing.T) {
 	dir := t.TempDir()
 	rs, err := git.NewResponseSpool(filepath.Join(dir, "large.spool"))
@@ -400,3 +425,147 @@ func TestResponseSpoolLargeData(t *testing.T) {
 	<-readDone
 	assert.Equal(t, totalSize, rec.Body.Len())
 }
+
+// idleScheduler accepts jobs without running them, holding repositories in their current state.
+type idleScheduler struct{}
+
+func (s idleScheduler) WithQueuePrefix(string) jobscheduler.Scheduler                              { return s }
+func (idleScheduler) Submit(string, string, func(context.Context) error)                           {}
+func (idleScheduler) SubmitPeriodicJob(string, string, time.Duration, func(context.Context) error) {}
+
+// blockingTransport serves canned upstream responses once released, counting requests.
+type blockingTransport struct {
+	release  chan struct{}
+	requests atomic.Int32
+}
+
+func (b *blockingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b.requests.Add(1)
+	<-b.release
+	body := "advertisement for " + req.URL.Path
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": []string{"application/x-git-upload-pack-advertisement"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func TestConcurrentRequestsCoalescedBySpool(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{name: "InfoRefs", method: http.MethodGet, path: "/git/github.com/org/repo/info/refs?service=git-upload-pack"},
+		{name: "UploadPack", method: http.MethodPost, path: "/git/github.com/org/repo/git-upload-pack", body: "command=fetch\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+			mux := http.NewServeMux()
+			cm := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: t.TempDir()})
+			s, err := git.New(ctx, git.Config{}, idleScheduler{}, nil, mux, cm, nil)
+			assert.NoError(t, err)
+			transport := &blockingTransport{release: make(chan struct{})}
+			s.SetHTTPTransport(transport)
+
+			serve := func() *httptest.ResponseRecorder {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequestWithContext(ctx, tt.method, tt.path, strings.NewReader(tt.body))
+				r.Header.Set("Git-Protocol", "version=2")
+				mux.ServeHTTP(w, r)
+				return w
+			}
+
+			first := make(chan *httptest.ResponseRecorder, 1)
+			go func() { first <- serve() }()
+			for transport.requests.Load() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			second := make(chan *httptest.ResponseRecorder, 1)
+			go func() { second <- serve() }()
+			// Give the second request time to attach to the spool before upstream responds.
+			time.Sleep(50 * time.Millisecond)
+			close(transport.release)
+
+			w1, w2 := <-first, <-second
+			assert.Equal(t, http.StatusOK, w1.Code)
+			assert.Equal(t, http.StatusOK, w2.Code)
+			assert.NotEqual(t, "", w1.Body.String())
+			assert.Equal(t, w1.Body.String(), w2.Body.String())
+			assert.Equal(t, w1.Header().Get("Content-Type"), w2.Header().Get("Content-Type"))
+			assert.Equal(t, int32(1), transport.requests.Load())
+		})
+	}
+}
+
+// truncatingTransport returns a response whose body fails after a few bytes, once released.
+type truncatingTransport struct {
+	requests atomic.Int32
+	release  chan struct{}
+}
+
+func (b *truncatingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b.requests.Add(1)
+	<-b.release
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": []string{"application/x-git-upload-pack-result"}},
+		Body:          io.NopCloser(io.MultiReader(strings.NewReader("partial pack"), iotest.ErrReader(io.ErrUnexpectedEOF))),
+		ContentLength: -1,
+		Request:       req,
+	}, nil
+}
+
+func TestSpoolTruncationAbortsClients(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	mux := http.NewServeMux()
+	cm := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: t.TempDir()})
+	s, err := git.New(ctx, git.Config{}, idleScheduler{}, nil, mux, cm, nil)
+	assert.NoError(t, err)
+	transport := &truncatingTransport{release: make(chan struct{})}
+	s.SetHTTPTransport(transport)
+	server := httptest.NewUnstartedServer(mux)
+	server.Config.BaseContext = func(net.Listener) context.Context { return ctx }
+	server.Start()
+	defer server.Close()
+
+	fetch := func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/git/github.com/org/repo/git-upload-pack", strings.NewReader("command=fetch\n"))
+		assert.NoError(t, err)
+		req.Header.Set("Git-Protocol", "version=2")
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+		return err
+	}
+
+	first := make(chan error, 1)
+	go func() { first <- fetch() }()
+	for transport.requests.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	second := make(chan error, 1)
+	go func() { second <- fetch() }()
+	// Give the second request time to attach to the spool before upstream responds.
+	time.Sleep(50 * time.Millisecond)
+	close(transport.release)
+
+	// Both the client served by the upstream request and the one served from its spool must see the response end
+	// uncleanly, rather than receiving a truncated body that looks complete.
+	for _, result := range []chan error{first, second} {
+		select {
+		case err := <-result:
+			assert.Error(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("client did not observe the truncated response")
+		}
+	}
+	assert.Equal(t, int32(1), transport.requests.Load())
+}
@@ -0,0 +1,58 @@
+package git
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/logging"
+)
+
+// CloneStatus describes the state of a single clone, as reported by the "/git/_status" endpoint.
+type CloneStatus struct {
+	Upstream  string    `json:"upstream"`
+	State     string    `json:"state"`
+	LastFetch time.Time `json:"last_fetch,omitzero"`
+	Size      int64     `json:"size"`
+	Bundle    bool      `json:"bundle"`
+}
+
+func (s *Strategy) handleStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	statuses := []CloneStatus{}
+	for _, repo := range s.cloneManager.Repositories() {
+		status := CloneStatus{
+			Upstream:  repo.UpstreamURL(),
+			State:     repo.State().String(),
+			LastFetch: repo.LastFetch(),
+			Size:      diskUsage(repo.Path()),
+		}
+		if s.cache != nil {
+			_, err := s.cache.Stat(ctx, cache.NewKey(repo.UpstreamURL()+".bundle"))
+			status.Bundle = err == nil
+		}
+		statuses = append(statuses, status)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(statuses); err != nil {
+		logging.FromContext(ctx).WarnContext(ctx, "Failed to write status", "error", err)
+	}
+}
+
+// diskUsage returns the total size of the files under dir, or 0 if it does not exist.
+func diskUsage(dir string) int64 {
+	var size int64
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error { //nolint:errcheck
+		if err != nil || d.IsDir() {
+			return nil //nolint:nilerr // Files may be removed mid-walk, and a partial size is good enough.
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
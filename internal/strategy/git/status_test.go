@@ -0,0 +1,112 @@
+package git_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/httputil"
+	"github.com/block/cachew/internal/jobscheduler"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy/git"
+)
+
+func TestStatusEndpoint(t *testing.T) {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git not found in PATH")
+	}
+	_, ctx := logging.Configure(context.Background(), logging.Config{})
+	tmpDir := t.TempDir()
+
+	// A local upstream, served over HTTP so the clone can be held open until released.
+	upstreamRoot := filepath.Join(tmpDir, "upstream")
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main", filepath.Join(upstreamRoot, "repo")},
+		{"-C", filepath.Join(upstreamRoot, "repo"), "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "initial"},
+	} {
+		output, err := exec.Command("git", args...).CombinedOutput()
+		assert.NoError(t, err, "%s", output)
+	}
+	release := make(chan struct{})
+	backend := &cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Env:  []string{"GIT_PROJECT_ROOT=" + upstreamRoot, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		backend.ServeHTTP(w, r)
+	}))
+	defer upstream.Close()
+
+	mux := newTestMux()
+	cm := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: filepath.Join(tmpDir, "clones")})
+	_, err = git.New(ctx, git.Config{}, jobscheduler.New(ctx, jobscheduler.Config{}), nil, mux, cm,
+		httputil.NewTokenAuthorizer([]string{"secret"}))
+	assert.NoError(t, err)
+	handler := mux.handlers["GET /git/_status"]
+	assert.NotZero(t, handler)
+
+	getStatus := func(token string) (int, []git.CloneStatus) {
+		req := httptest.NewRequest(http.MethodGet, "/git/_status", nil).WithContext(ctx)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var statuses []git.CloneStatus
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &statuses))
+		}
+		return w.Code, statuses
+	}
+	waitForState := func(state string) git.CloneStatus {
+		for range 100 {
+			_, statuses := getStatus("secret")
+			if len(statuses) == 1 && statuses[0].State == state {
+				return statuses[0]
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("timed out waiting for clone state %q", state)
+		return git.CloneStatus{}
+	}
+
+	code, _ := getStatus("")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = getStatus("wrong")
+	assert.Equal(t, http.StatusForbidden, code)
+
+	manager, err := cm()
+	assert.NoError(t, err)
+	repo, err := manager.GetOrCreate(ctx, upstream.URL+"/repo")
+	assert.NoError(t, err)
+
+	status := waitForState("empty")
+	assert.Equal(t, upstream.URL+"/repo", status.Upstream)
+	assert.True(t, status.LastFetch.IsZero())
+
+	cloneErr := make(chan error, 1)
+	go func() { cloneErr <- repo.Clone(ctx) }()
+	waitForState("cloning")
+
+	close(release)
+	assert.NoError(t, <-cloneErr)
+	status = waitForState("ready")
+	assert.False(t, status.LastFetch.IsZero())
+	assert.True(t, status.Size > 0)
+	assert.False(t, status.Bundle)
+	_, err = os.Stat(filepath.Join(repo.Path(), ".git"))
+	assert.NoError(t, err)
+}
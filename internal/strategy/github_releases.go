@@ -4,9 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"regexp"
 	"slices"
+	"sync"
 
 	"github.com/alecthomas/errors"
 
@@ -23,6 +26,7 @@ func RegisterGitHubReleases(r *Registry) {
 type GitHubReleasesConfig struct {
 	Token       string   `hcl:"token" help:"GitHub token for authentication."`
 	PrivateOrgs []string `hcl:"private-orgs" help:"List of private GitHub organisations."`
+	Observe     bool     `hcl:"observe,optional" help:"Dry run: always fetch from upstream and never cache, logging whether each request would have been a hit or a miss."`
 }
 
 // The GitHubReleases strategy fetches private (and public) release binaries from GitHub.
@@ -30,6 +34,11 @@ type GitHubReleases struct {
 	config GitHubReleasesConfig
 	cache  cache.Cache
 	client *http.Client
+	shadow cache.Cache // Set in observe mode.
+
+	assetsMu sync.Mutex
+	// assets maps org/repo/release/file to the resolved API asset URL.
+	assets map[string]string
 }
 
 // NewGitHubReleases creates a [Strategy] that fetches private (and public) release binaries from GitHub.
@@ -38,6 +47,7 @@ func NewGitHubReleases(ctx context.Context, config GitHubReleasesConfig, cache c
 		config: config,
 		cache:  cache,
 		client: http.DefaultClient,
+		assets: map[string]string{},
 	}
 	logger := logging.FromContext(ctx)
 	if config.Token == "" {
@@ -59,14 +69,27 @@ func NewGitHubReleases(ctx context.Context, config GitHubReleasesConfig, cache c
 			file := r.PathValue("file")
 			return s.downloadRelease(r.Context(), org, repo, release, file)
 		})
+	if config.Observe {
+		var err error
+		if s.shadow, err = newShadowCache(ctx, 0); err != nil {
+			return nil, err
+		}
+		h.Observe(s.shadow)
+	}
 	mux.Handle("GET /github.com/{org}/{repo}/releases/download/{release}/{file}", h)
 	return s, nil
 }
 
-var _ Strategy = (*GitHubReleases)(nil)
+var (
+	_ Strategy  = (*GitHubReleases)(nil)
+	_ io.Closer = (*GitHubReleases)(nil)
+)
 
 func (g *GitHubReleases) String() string { return "github-releases" }
 
+// Close releases the shadow cache of a GitHubReleases strategy in observe mode.
+func (g *GitHubReleases) Close() error { return closeShadowCache(g.shadow) }
+
 // newGitHubRequest creates a new HTTP request with GitHub API headers and authentication.
 func (g *GitHubReleases) newGitHubRequest(ctx context.Context, url, accept string) (*http.Request, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
@@ -104,53 +127,117 @@ func (g *GitHubReleases) downloadRelease(ctx context.Context, org, repo, release
 		return req, nil
 	}
 
-	// Use GitHub API to get release info and find the asset
 	logger.DebugContext(ctx, "Using GitHub API for private release")
-	apiURL := fmt.Sprintf("https://api.github.com/repos/%s/%s/releases/tags/%s", org, repo, release)
-	req, err := g.newGitHubRequest(ctx, apiURL, "application/vnd.github+json")
+	assetURL, err := g.resolveAsset(ctx, org, repo, release, file)
 	if err != nil {
-		return nil, httputil.Errorf(http.StatusInternalServerError, "create API request")
+		return nil, err
 	}
 
-	resp, err := g.client.Do(req)
+	logger.DebugContext(ctx, "Found asset in release", slog.String("asset_url", assetURL))
+
+	// Create request for the asset download
+	req, err := g.newGitHubRequest(ctx, assetURL, "application/octet-stream")
 	if err != nil {
-		return nil, httputil.Errorf(http.StatusBadGateway, "fetch release info failed: %w", err)
+		return nil, httputil.Errorf(http.StatusInternalServerError, "create asset request failed: %w", err)
 	}
-	defer resp.Body.Close()
+	return req, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, httputil.Errorf(resp.StatusCode, "GitHub API returned %d", resp.StatusCode)
+type gitHubAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// resolveAsset returns the API URL of a private release asset, following
+// asset list pagination if the asset is not in the release's first page of
+// assets. Resolved URLs are remembered to avoid re-paginating.
+func (g *GitHubReleases) resolveAsset(ctx context.Context, org, repo, release, file string) (string, error) {
+	key := org + "/" + repo + "/" + release + "/" + file
+	g.assetsMu.Lock()
+	assetURL, ok := g.assets[key]
+	g.assetsMu.Unlock()
+	if ok {
+		return assetURL, nil
 	}
 
+	logger := logging.FromContext(ctx)
+	apiURL := fmt.Sprintf("https://api.github.com/repos/%s/%s/releases/tags/%s", org, repo, release)
 	var releaseInfo struct {
-		Assets []struct {
-			Name string `json:"name"`
-			URL  string `json:"url"`
-		} `json:"assets"`
+		AssetsURL string        `json:"assets_url"`
+		Assets    []gitHubAsset `json:"assets"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&releaseInfo); err != nil {
-		return nil, httputil.Errorf(http.StatusBadGateway, "decode release info failed: %w", err)
+	if _, err := g.getJSON(ctx, apiURL, "release info", &releaseInfo); err != nil {
+		return "", err
 	}
-
-	// Find the matching asset
-	var assetURL string
-	for _, asset := range releaseInfo.Assets {
-		if asset.Name == file {
-			assetURL = asset.URL
-			break
+	assetURL = findAsset(releaseInfo.Assets, file)
+	seen := len(releaseInfo.Assets)
+
+	// The release object only embeds a limited number of assets, so page
+	// through the assets endpoint for the rest.
+	next := ""
+	if assetURL == "" && releaseInfo.AssetsURL != "" {
+		next = releaseInfo.AssetsURL + "?per_page=100"
+	}
+	for assetURL == "" && next != "" {
+		var assets []gitHubAsset
+		link, err := g.getJSON(ctx, next, "release assets", &assets)
+		if err != nil {
+			return "", err
 		}
+		assetURL = findAsset(assets, file)
+		seen += len(assets)
+		next = nextPageURL(link)
 	}
 	if assetURL == "" {
-		logger.ErrorContext(ctx, "Asset not found in release", slog.Int("assets_count", len(releaseInfo.Assets)))
-		return nil, httputil.Errorf(http.StatusNotFound, "asset %s not found in release %s", file, release)
+		logger.ErrorContext(ctx, "Asset not found in release", slog.Int("assets_count", seen))
+		return "", httputil.Errorf(http.StatusNotFound, "asset %s not found in release %s", file, release)
 	}
 
-	logger.DebugContext(ctx, "Found asset in release", slog.String("asset_url", assetURL))
+	g.assetsMu.Lock()
+	g.assets[key] = assetURL
+	g.assetsMu.Unlock()
+	return assetURL, nil
+}
 
-	// Create request for the asset download
-	req, err = g.newGitHubRequest(ctx, assetURL, "application/octet-stream")
+// getJSON fetches a GitHub API URL, decodes the JSON response into v, and
+// returns the response's Link header.
+func (g *GitHubReleases) getJSON(ctx context.Context, url, what string, v any) (string, error) {
+	req, err := g.newGitHubRequest(ctx, url, "application/vnd.github+json")
 	if err != nil {
-		return nil, httputil.Errorf(http.StatusInternalServerError, "create asset request failed: %w", err)
+		return "", httputil.Errorf(http.StatusInternalServerError, "create API request")
 	}
-	return req, nil
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", httputil.Errorf(http.StatusBadGateway, "fetch %s failed: %w", what, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", httputil.Errorf(resp.StatusCode, "GitHub API returned %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", httputil.Errorf(http.StatusBadGateway, "decode %s failed: %w", what, err)
+	}
+	return resp.Header.Get("Link"), nil
+}
+
+func findAsset(assets []gitHubAsset, file string) string {
+	for _, asset := range assets {
+		if asset.Name == file {
+			return asset.URL
+		}
+	}
+	return ""
+}
+
+var nextLinkRe = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?next"?`) //nolint:gochecknoglobals
+
+// nextPageURL extracts the rel="next" URL from a GitHub Link header, if any.
+func nextPageURL(link string) string {
+	m := nextLinkRe.FindStringSubmatch(link)
+	if m == nil {
+		return ""
+	}
+	return m[1]
 }
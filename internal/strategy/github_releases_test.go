@@ -34,6 +34,7 @@ func newMockGitHubServer() *mockGitHubServer {
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /repos/{org}/{repo}/releases/tags/{tag}", m.handleAPIRequest)
 	mux.HandleFunc("GET /repos/{org}/{repo}/releases/assets/{assetID}", m.handleAssetDownload)
+	mux.HandleFunc("GET /repos/{org}/{repo}/releases/42/assets", m.handleAssetList)
 	mux.HandleFunc("GET /{org}/{repo}/releases/download/{release}/{file}", m.handlePublicDownload)
 	m.server = httptest.NewServer(mux)
 	return m
@@ -52,6 +53,19 @@ func (m *mockGitHubServer) handleAPIRequest(w http.ResponseWriter, r *http.Reque
 	repo := r.PathValue("repo")
 	tag := r.PathValue("tag")
 
+	if tag == "paginated" {
+		releaseInfo := map[string]any{
+			"tag_name":   tag,
+			"assets_url": "https://api.github.com/repos/" + org + "/" + repo + "/releases/42/assets",
+			"assets": []map[string]string{
+				{"name": "page1.tar.gz", "url": "https://api.github.com/repos/" + org + "/" + repo + "/releases/assets/1"},
+			},
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(releaseInfo)
+		return
+	}
+
 	if strings.Contains(r.URL.Path, "missing.tar.gz") {
 		releaseInfo := map[string]any{
 			"tag_name": tag,
@@ -80,6 +94,21 @@ func (m *mockGitHubServer) handleAPIRequest(w http.ResponseWriter, r *http.Reque
 	_ = json.NewEncoder(w).Encode(releaseInfo)
 }
 
+// handleAssetList serves a two page asset list, with binary.tar.gz only on the second page.
+func (m *mockGitHubServer) handleAssetList(w http.ResponseWriter, r *http.Request) {
+	m.apiCallCount++
+	base := "https://api.github.com/repos/" + r.PathValue("org") + "/" + r.PathValue("repo") + "/releases"
+	assets := []map[string]string{{"name": "page1.tar.gz", "url": base + "/assets/1"}}
+	if r.URL.Query().Get("page") == "2" {
+		assets = []map[string]string{{"name": "binary.tar.gz", "url": base + "/assets/12345"}}
+		w.Header().Set("Link", `<`+base+`/42/assets?per_page=100&page=1>; rel="prev", <`+base+`/42/assets?per_page=100&page=1>; rel="first"`)
+	} else {
+		w.Header().Set("Link", `<`+base+`/42/assets?per_page=100&page=2>; rel="next", <`+base+`/42/assets?per_page=100&page=2>; rel="last"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(assets)
+}
+
 func (m *mockGitHubServer) handleAssetDownload(w http.ResponseWriter, _ *http.Request) {
 	m.downloadCallCount++
 	w.Header().Set("Content-Type", "application/octet-stream")
@@ -181,6 +210,23 @@ func TestGitHubReleasesPrivateRepo(t *testing.T) {
 	assert.Equal(t, 1, mock.downloadCallCount, "second request should be served from cache")
 }
 
+func TestGitHubReleasesPrivateRepoPaginatedAssets(t *testing.T) {
+	mock, mux, ctx := setupTest(t, strategy.GitHubReleasesConfig{
+		Token:       "test-token",
+		PrivateOrgs: []string{"privateorg"},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/github.com/privateorg/repo/releases/download/paginated/binary.tar.gz", nil)
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []byte("private-binary-content"), w.Body.Bytes())
+	assert.Equal(t, 3, mock.apiCallCount, "release lookup plus two asset pages")
+	assert.Equal(t, 1, mock.downloadCallCount)
+}
+
 func TestGitHubReleasesPrivateRepoAssetNotFound(t *testing.T) {
 	mock, mux, ctx := setupTest(t, strategy.GitHubReleasesConfig{
 		Token:       "test-token",
@@ -5,13 +5,17 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"path"
+	"slices"
 	"strings"
 
 	"github.com/block/cachew/internal/cache"
 )
 
 type goproxyCacher struct {
-	cache cache.Cache
+	cache             cache.Cache
+	allowedExtensions []string
+	index             *moduleIndex
 }
 
 func (g *goproxyCacher) Get(ctx context.Context, name string) (io.ReadCloser, error) {
@@ -26,7 +30,7 @@ func (g *goproxyCacher) Get(ctx context.Context, name string) (io.ReadCloser, er
 }
 
 func (g *goproxyCacher) Put(ctx context.Context, name string, content io.ReadSeeker) error {
-	if strings.HasSuffix(name, "/@v/list") || strings.HasSuffix(name, "/@latest") {
+	if !g.cacheable(name) {
 		return nil
 	}
 
@@ -50,5 +54,19 @@ func (g *goproxyCacher) Put(ctx context.Context, name string, content io.ReadSee
 		return fmt.Errorf("close cache entry: %w", err)
 	}
 
+	g.index.record(ctx, name)
+
 	return nil
 }
+
+// cacheable returns true if the named file may be stored in the cache.
+//
+// Module version lists and @latest queries have no extension so are never cached.
+func (g *goproxyCacher) cacheable(name string) bool {
+	if strings.HasPrefix(name, "sumdb/") {
+		// Checksum database lookups and full tiles are immutable, but partial tiles (".p/") grow as the log does and
+		// the latest signed tree head changes.
+		return strings.Contains(name, "/lookup/") || (strings.Contains(name, "/tile/") && !strings.Contains(name, ".p/"))
+	}
+	return slices.Contains(g.allowedExtensions, path.Ext(name))
+}
@@ -0,0 +1,58 @@
+package gomod
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// canonicalPaths wraps the Go module proxy handler, rewriting module paths and versions that contain unescaped
+// uppercase letters, eg. "github.com/Azure/azure-sdk-for-go", to their "!"-escaped form, eg.
+// "github.com/!azure/azure-sdk-for-go", before they are keyed and fetched. Clients that escape paths inconsistently
+// then share a single cache entry per file.
+//
+// Paths that are already escaped are left unchanged, as are paths that are invalid in either form, eg. "!Azure".
+func canonicalPaths(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if canonical := canonicalPath(r.URL.Path); canonical != r.URL.Path {
+			r = r.Clone(r.Context())
+			r.URL.Path = canonical
+			r.URL.RawPath = ""
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// canonicalPath returns the canonical form of the path of a module proxy request, "/gomod/<module>/@<query>".
+func canonicalPath(urlPath string) string {
+	target, ok := strings.CutPrefix(urlPath, "/gomod/")
+	if !ok {
+		return urlPath
+	}
+	modulePath, query, ok := strings.Cut(target, "/@")
+	if !ok {
+		return urlPath
+	}
+	modulePath = canonicalElement(modulePath, module.UnescapePath, module.EscapePath)
+	if file, ok := strings.CutPrefix(query, "v/"); ok && file != "list" {
+		ext := path.Ext(file)
+		version := canonicalElement(strings.TrimSuffix(file, ext), module.UnescapeVersion, module.EscapeVersion)
+		query = "v/" + version + ext
+	}
+	return "/gomod/" + modulePath + "/@" + query
+}
+
+// canonicalElement returns the escaped form of a module path or version that is not validly escaped but is valid
+// unescaped, and otherwise returns it unchanged.
+func canonicalElement(s string, unescape, escape func(string) (string, error)) string {
+	if _, err := unescape(s); err == nil {
+		return s
+	}
+	escaped, err := escape(s)
+	if err != nil {
+		return s
+	}
+	return escaped
+}
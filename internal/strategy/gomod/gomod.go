@@ -6,7 +6,10 @@ import (
 	"log/slog"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 
+	"github.com/alecthomas/errors"
 	"github.com/goproxy/goproxy"
 
 	"github.com/block/cachew/internal/cache"
@@ -18,12 +21,41 @@ import (
 func Register(r *strategy.Registry, cloneManager gitclone.ManagerProvider) {
 	strategy.Register(r, "gomod", "Caches Go module proxy requests.", func(ctx context.Context, config Config, cache cache.Cache, mux strategy.Mux) (*Strategy, error) {
 		return New(ctx, config, cache, mux, cloneManager)
-	})
+	}, strategy.RequiresRestart())
 }
 
 type Config struct {
-	Proxy        string   `hcl:"proxy,optional" help:"Upstream Go module proxy URL (defaults to proxy.golang.org)" default:"https://proxy.golang.org"`
-	PrivatePaths []string `hcl:"private-paths,optional" help:"Module path patterns for private repositories"`
+	Proxy             string        `hcl:"proxy,optional" help:"Upstream Go module proxy URL (defaults to proxy.golang.org)" default:"https://proxy.golang.org"`
+	PrivatePaths      []string      `hcl:"private-paths,optional" help:"Module path patterns for private repositories"`
+	AllowedExtensions []string      `hcl:"allowed-extensions,optional" help:"File extensions of module files that may be cached (defaults to .info, .mod and .zip)"`
+	NotFoundTTL       time.Duration `hcl:"not-found-ttl,optional" help:"How long to remember module versions that upstream reports as missing. 0 disables." default:"0s"`
+	QueryTTL          time.Duration `hcl:"query-ttl,optional" help:"How long to reuse @latest and version list responses, sharing one upstream call between concurrent requests. 0 disables." default:"0s"`
+	QueryStaleTTL     time.Duration `hcl:"query-stale-ttl,optional" help:"How long to keep the last @latest and version list responses, to serve if upstream fails. Only used if query-ttl is set." default:"24h"`
+	PrefetchHints     bool          `hcl:"prefetch-hints,optional" help:"Add Link rel=prefetch headers to @latest responses for the .info, .mod and .zip files of the resolved version (defaults to false)."`
+	CanonicalPaths    bool          `hcl:"canonical-paths,optional" help:"Escape uppercase letters in module paths and versions that clients send unescaped, so that both forms share a cache entry (defaults to false)."`
+}
+
+// Validate the configuration.
+func (c *Config) Validate() error {
+	var errs []error
+	if u, err := url.Parse(c.Proxy); err != nil || u.Scheme == "" || u.Host == "" {
+		errs = append(errs, errors.Errorf("proxy must be an absolute URL, got %q", c.Proxy))
+	}
+	for _, ext := range c.AllowedExtensions {
+		if !strings.HasPrefix(ext, ".") {
+			errs = append(errs, errors.Errorf("allowed-extensions: %q must start with \".\"", ext))
+		}
+	}
+	if c.NotFoundTTL < 0 {
+		errs = append(errs, errors.New("not-found-ttl must not be negative"))
+	}
+	if c.QueryTTL < 0 {
+		errs = append(errs, errors.New("query-ttl must not be negative"))
+	}
+	if c.QueryTTL > 0 && c.QueryStaleTTL < c.QueryTTL {
+		errs = append(errs, errors.New("query-stale-ttl must not be less than query-ttl"))
+	}
+	return errors.Join(errs...)
 }
 
 type Strategy struct {
@@ -33,6 +65,7 @@ type Strategy struct {
 	proxy        *url.URL
 	goproxy      *goproxy.Goproxy
 	cloneManager *gitclone.Manager
+	index        *moduleIndex
 }
 
 var _ strategy.Strategy = (*Strategy)(nil)
@@ -54,6 +87,7 @@ func New(ctx context.Context, config Config, cache cache.Cache, mux strategy.Mux
 		logger:       logging.FromContext(ctx),
 		proxy:        parsedURL,
 		cloneManager: cloneManager,
+		index:        newModuleIndex(cache),
 	}
 
 	publicFetcher := &goproxy.GoFetcher{
@@ -74,11 +108,26 @@ func New(ctx context.Context, config Config, cache cache.Cache, mux strategy.Mux
 			slog.Any("private-paths", config.PrivatePaths))
 	}
 
+	if config.NotFoundTTL > 0 {
+		fetcher = &negativeCachingFetcher{fetcher: fetcher, cache: cache, ttl: config.NotFoundTTL}
+	}
+
+	if config.QueryTTL > 0 {
+		fetcher = newQueryCachingFetcher(s.logger, fetcher, cache, config.QueryTTL, config.QueryStaleTTL)
+	}
+
+	allowedExtensions := config.AllowedExtensions
+	if len(allowedExtensions) == 0 {
+		allowedExtensions = []string{".info", ".mod", ".zip"}
+	}
+
 	s.goproxy = &goproxy.Goproxy{
 		Logger:  s.logger,
 		Fetcher: fetcher,
 		Cacher: &goproxyCacher{
-			cache: cache,
+			cache:             cache,
+			allowedExtensions: allowedExtensions,
+			index:             s.index,
 		},
 		ProxiedSumDBs: []string{
 			"sum.golang.org https://sum.golang.org",
@@ -88,7 +137,15 @@ func New(ctx context.Context, config Config, cache cache.Cache, mux strategy.Mux
 	s.logger.InfoContext(ctx, "Initialized Go module proxy strategy",
 		slog.String("proxy", s.proxy.String()))
 
-	mux.Handle("GET /gomod/{path...}", http.StripPrefix("/gomod", s.goproxy))
+	mux.HandleFunc("GET /gomod/_index", s.handleIndex)
+	var handler http.Handler = http.StripPrefix("/gomod", s.goproxy)
+	if config.PrefetchHints {
+		handler = prefetchHints(handler)
+	}
+	if config.CanonicalPaths {
+		handler = canonicalPaths(handler)
+	}
+	mux.Handle("GET /gomod/{path...}", handler)
 
 	return s, nil
 }
@@ -3,7 +3,9 @@ package gomod_test
 import (
 	"archive/zip"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
@@ -13,6 +15,7 @@ import (
 	"time"
 
 	"github.com/alecthomas/assert/v2"
+	"golang.org/x/mod/module"
 
 	"github.com/block/cachew/internal/cache"
 	"github.com/block/cachew/internal/gitclone"
@@ -109,7 +112,15 @@ func (m *mockGoModServer) handleRequest(w http.ResponseWriter, r *http.Request)
 		parts := strings.Split(path, "/@v/")
 		if len(parts) == 2 {
 			modulePath := strings.TrimPrefix(parts[0], "/")
+			if unescaped, err := module.UnescapePath(modulePath); err == nil {
+				modulePath = unescaped
+			}
 			versionPart := parts[1]
+			if i := strings.LastIndex(versionPart, "."); i >= 0 {
+				if unescaped, err := module.UnescapeVersion(versionPart[:i]); err == nil {
+					versionPart = unescaped + versionPart[i:]
+				}
+			}
 
 			switch {
 			case strings.HasSuffix(path, ".info"):
@@ -165,6 +176,12 @@ func (m *mockGoModServer) getRequestCount(path string) int {
 
 func setupGoModTest(t *testing.T) (*mockGoModServer, *http.ServeMux, context.Context) {
 	t.Helper()
+	return setupGoModTestWithConfig(t, gomod.Config{})
+}
+
+// setupGoModTestWithConfig is like setupGoModTest, but with the given config pointed at the mock upstream.
+func setupGoModTestWithConfig(t *testing.T, config gomod.Config) (*mockGoModServer, *http.ServeMux, context.Context) {
+	t.Helper()
 
 	mock := newMockGoModServer(t)
 	t.Cleanup(mock.close)
@@ -180,9 +197,8 @@ func setupGoModTest(t *testing.T) (*mockGoModServer, *http.ServeMux, context.Con
 	})
 	assert.NoError(t, err)
 	mux := http.NewServeMux()
-	_, err = gomod.New(ctx, gomod.Config{
-		Proxy: mock.server.URL,
-	}, memCache, mux, cm)
+	config.Proxy = mock.server.URL
+	_, err = gomod.New(ctx, config, memCache, mux, cm)
 	assert.NoError(t, err)
 
 	return mock, mux, ctx
@@ -261,6 +277,46 @@ func TestGoModLatest(t *testing.T) {
 	assert.Equal(t, 1, mock.getRequestCount("/github.com/example/test/@latest"))
 }
 
+func TestGoModLatestPrefetchHints(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   gomod.Config
+		path     string
+		expected []string
+	}{
+		{name: "Latest", config: gomod.Config{PrefetchHints: true}, path: "/gomod/github.com/example/test/@latest",
+			expected: []string{
+				"</gomod/github.com/example/test/@v/v1.1.0.info>; rel=prefetch",
+				"</gomod/github.com/example/test/@v/v1.1.0.mod>; rel=prefetch",
+				"</gomod/github.com/example/test/@v/v1.1.0.zip>; rel=prefetch",
+			}},
+		{name: "Disabled", path: "/gomod/github.com/example/test/@latest"},
+		{name: "NotLatest", config: gomod.Config{PrefetchHints: true}, path: "/gomod/github.com/example/test/@v/list"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, mux, ctx := setupGoModTestWithConfig(t, tt.config)
+
+			req := httptest.NewRequestWithContext(ctx, http.MethodGet, tt.path, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.expected, w.Header().Values("Link"))
+		})
+	}
+
+	t.Run("BodyUnchanged", func(t *testing.T) {
+		_, mux, ctx := setupGoModTestWithConfig(t, gomod.Config{PrefetchHints: true})
+
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/gomod/github.com/example/test/@latest", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		assert.Equal(t, `{"Version":"v1.1.0","Time":"2023-06-01T00:00:00Z"}`, w.Body.String())
+	})
+}
+
 func TestGoModCaching(t *testing.T) {
 	mock, mux, ctx := setupGoModTest(t)
 
@@ -298,6 +354,53 @@ func TestGoModComplexModulePath(t *testing.T) {
 	assert.Equal(t, 1, mock.getRequestCount("/golang.org/x/tools/@v/v0.1.0.info"))
 }
 
+func TestGoModCanonicalPaths(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         gomod.Config
+		path           string
+		expectedStatus int
+	}{
+		{name: "Escaped", config: gomod.Config{CanonicalPaths: true}, path: "/gomod/github.com/!example/!test/@v/v1.0.0-!r!c1.info", expectedStatus: http.StatusOK},
+		{name: "Unescaped", config: gomod.Config{CanonicalPaths: true}, path: "/gomod/github.com/Example/Test/@v/v1.0.0-RC1.info", expectedStatus: http.StatusOK},
+		{name: "Mixed", config: gomod.Config{CanonicalPaths: true}, path: "/gomod/github.com/!example/Test/@v/v1.0.0-RC1.info", expectedStatus: http.StatusNotFound},
+		{name: "Disabled", path: "/gomod/github.com/Example/Test/@v/v1.0.0-RC1.info", expectedStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, mux, ctx := setupGoModTestWithConfig(t, tt.config)
+
+			req := httptest.NewRequestWithContext(ctx, http.MethodGet, tt.path, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+
+	t.Run("SharedCacheEntry", func(t *testing.T) {
+		mock, mux, ctx := setupGoModTestWithConfig(t, gomod.Config{CanonicalPaths: true})
+
+		var bodies []string
+		for _, path := range []string{
+			"/gomod/github.com/Example/Test/@v/v1.0.0.mod",
+			"/gomod/github.com/!example/!test/@v/v1.0.0.mod",
+			"/gomod/github.com/Example/Test/@v/v1.0.0.mod",
+		} {
+			req := httptest.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code, path)
+			bodies = append(bodies, w.Body.String())
+		}
+
+		assert.Equal(t, bodies[0], bodies[1])
+		assert.Equal(t, bodies[0], bodies[2])
+		assert.Equal(t, 1, mock.getRequestCount("/github.com/!example/!test/@v/v1.0.0.mod"))
+		assert.Equal(t, 0, mock.getRequestCount("/github.com/Example/Test/@v/v1.0.0.mod"))
+	})
+}
+
 func TestGoModNonOKResponse(t *testing.T) {
 	mock, mux, ctx := setupGoModTest(t)
 
@@ -322,6 +425,35 @@ func TestGoModNonOKResponse(t *testing.T) {
 	assert.Equal(t, 2, mock.getRequestCount(upstreamPath), "404 responses should not be cached")
 }
 
+func TestGoModNotFoundCached(t *testing.T) {
+	mock, mux, ctx := setupGoModTestWithConfig(t, gomod.Config{NotFoundTTL: 500 * time.Millisecond})
+
+	upstreamPath := "/github.com/example/unpublished/@v/v1.0.0.info"
+	mock.setResponse(upstreamPath, http.StatusNotFound, "not found")
+
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/gomod"+upstreamPath, nil)
+		req = req.WithContext(ctx)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusNotFound, get().Code)
+	assert.Equal(t, 1, mock.getRequestCount(upstreamPath))
+
+	assert.Equal(t, http.StatusNotFound, get().Code)
+	assert.Equal(t, 1, mock.getRequestCount(upstreamPath), "known-missing module should be served from the negative cache")
+
+	// Once the negative entry expires, a newly published version is discovered.
+	delete(mock.responses, upstreamPath)
+	time.Sleep(600 * time.Millisecond)
+	w := get()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"Version":"v1.0.0","Time":"2023-01-01T00:00:00Z"}`, w.Body.String())
+	assert.Equal(t, 2, mock.getRequestCount(upstreamPath))
+}
+
 func TestGoModMultipleConcurrentRequests(t *testing.T) {
 	mock, mux, ctx := setupGoModTest(t)
 
@@ -394,3 +526,168 @@ func TestGoModLatestNotCached(t *testing.T) {
 	assert.Equal(t, w1.Body.String(), w2.Body.String())
 	assert.Equal(t, 2, mock.getRequestCount(upstreamPath), "/@latest endpoint should not be cached")
 }
+
+func TestGoModIndex(t *testing.T) {
+	_, mux, ctx := setupGoModTest(t)
+
+	// Downloading any file of a module version caches all three.
+	for _, path := range []string{
+		"/gomod/github.com/example/test/@v/v1.0.0.info",
+		"/gomod/github.com/example/test/@v/v1.0.0.mod",
+		"/gomod/github.com/example/test/@v/v1.1.0.info",
+		"/gomod/github.com/acme/other/@v/v0.1.0.mod",
+		"/gomod/github.com/example/test/@v/list",
+	} {
+		req := httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, path)
+	}
+
+	list := func(t *testing.T, query string) gomod.IndexPage {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/gomod/_index"+query, nil).WithContext(ctx)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var page gomod.IndexPage
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		return page
+	}
+
+	files := []string{".info", ".mod", ".zip"}
+
+	t.Run("All", func(t *testing.T) {
+		assert.Equal(t, gomod.IndexPage{Modules: []gomod.ModuleVersion{
+			{Path: "github.com/acme/other", Version: "v0.1.0", Files: files},
+			{Path: "github.com/example/test", Version: "v1.0.0", Files: files},
+			{Path: "github.com/example/test", Version: "v1.1.0", Files: files},
+		}}, list(t, ""))
+	})
+
+	t.Run("Prefix", func(t *testing.T) {
+		page := list(t, "?prefix=github.com/acme/")
+		assert.Equal(t, []gomod.ModuleVersion{
+			{Path: "github.com/acme/other", Version: "v0.1.0", Files: files},
+		}, page.Modules)
+	})
+
+	t.Run("Paginated", func(t *testing.T) {
+		page := list(t, "?prefix=github.com/example/&limit=1")
+		assert.Equal(t, "v1.0.0", page.Modules[0].Version)
+		assert.Equal(t, "github.com/example/test@v1.0.0", page.Next)
+		page = list(t, "?prefix=github.com/example/&limit=1&after="+page.Next)
+		assert.Equal(t, "v1.1.0", page.Modules[0].Version)
+		assert.Equal(t, "", page.Next)
+	})
+
+	t.Run("Gzip", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/gomod/_index", nil).WithContext(ctx)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		gz, err := gzip.NewReader(w.Body)
+		assert.NoError(t, err)
+		var page gomod.IndexPage
+		assert.NoError(t, json.NewDecoder(gz).Decode(&page))
+		assert.Equal(t, 3, len(page.Modules))
+	})
+
+	t.Run("InvalidLimit", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/gomod/_index?limit=0", nil).WithContext(ctx)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+// Instances sharing a cache persist the modules they cache in the same index without losing each other's entries.
+func TestGoModIndexSharedCache(t *testing.T) {
+	mock := newMockGoModServer(t)
+	t.Cleanup(mock.close)
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: 24 * time.Hour})
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = memCache.Close() })
+	newInstance := func() *http.ServeMux {
+		mux := http.NewServeMux()
+		cm := gitclone.NewManagerProvider(ctx, gitclone.Config{MirrorRoot: t.TempDir()})
+		_, err := gomod.New(ctx, gomod.Config{Proxy: mock.server.URL}, memCache, mux, cm)
+		assert.NoError(t, err)
+		return mux
+	}
+	get := func(mux *http.ServeMux, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx))
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		return w
+	}
+
+	var wg sync.WaitGroup
+	for _, path := range []string{"/gomod/github.com/example/test/@v/v1.0.0.mod", "/gomod/github.com/acme/other/@v/v0.1.0.mod"} {
+		mux := newInstance()
+		wg.Go(func() { get(mux, path) })
+	}
+	wg.Wait()
+
+	files := []string{".info", ".mod", ".zip"}
+	expected := []gomod.ModuleVersion{
+		{Path: "github.com/acme/other", Version: "v0.1.0", Files: files},
+		{Path: "github.com/example/test", Version: "v1.0.0", Files: files},
+	}
+	var page gomod.IndexPage
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		page = gomod.IndexPage{}
+		assert.NoError(t, json.Unmarshal(get(newInstance(), "/gomod/_index").Body.Bytes(), &page))
+		if len(page.Modules) == len(expected) {
+			break
+		}
+	}
+	assert.Equal(t, expected, page.Modules)
+}
+
+func TestGoModQueryCaching(t *testing.T) {
+	mock, mux, ctx := setupGoModTestWithConfig(t, gomod.Config{QueryTTL: 200 * time.Millisecond, QueryStaleTTL: time.Hour})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/gomod"+path, nil).WithContext(ctx)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	latestPath := "/github.com/example/test/@latest"
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			w := get(latestPath)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, `{"Version":"v1.1.0","Time":"2023-06-01T00:00:00Z"}`, w.Body.String())
+		})
+	}
+	wg.Wait()
+	assert.Equal(t, 1, mock.getRequestCount(latestPath), "concurrent requests should share one upstream call")
+
+	listPath := "/github.com/example/test/@v/list"
+	assert.Equal(t, http.StatusOK, get(listPath).Code)
+	assert.Equal(t, 1, mock.getRequestCount(listPath))
+
+	// Once expired, an upstream failure serves the last known list, and upstream is not retried until it expires again.
+	mock.setResponse(listPath, http.StatusInternalServerError, "unavailable")
+	time.Sleep(300 * time.Millisecond)
+	w := get(listPath)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "v1.0.0\nv1.0.1\nv1.1.0", w.Body.String())
+	upstreamCalls := mock.getRequestCount(listPath)
+	assert.True(t, upstreamCalls > 1, "expired list should have been refetched")
+	w = get(listPath)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "v1.0.0\nv1.0.1\nv1.1.0", w.Body.String())
+	assert.Equal(t, upstreamCalls, mock.getRequestCount(listPath))
+
+	// Modules that do not exist are not served stale.
+	missingPath := "/github.com/example/missing/@v/list"
+	mock.setResponse(missingPath, http.StatusNotFound, "not found")
+	assert.Equal(t, http.StatusNotFound, get(missingPath).Code)
+}
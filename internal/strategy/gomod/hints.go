@@ -0,0 +1,51 @@
+package gomod
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// prefetchHints wraps the Go module proxy handler, adding a "Link: <url>; rel=prefetch" header to successful @latest
+// responses for each of the files of the resolved version, as those are what clients fetch next. This lets clients
+// and CDNs that honor prefetch hints start fetching them before they are requested.
+//
+// Only @latest responses are buffered, as they are small and the version is not known until the body is written.
+func prefetchHints(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		modulePath, ok := strings.CutSuffix(r.URL.Path, "/@latest")
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		bw := &bufferedResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(bw, r)
+		if bw.status == http.StatusOK {
+			var latest queryResponse
+			if err := json.Unmarshal(bw.body.Bytes(), &latest); err == nil {
+				if version, err := module.EscapeVersion(latest.Version); err == nil && latest.Version != "" {
+					for _, ext := range indexExtensions {
+						w.Header().Add("Link", "<"+modulePath+"/@v/"+version+ext+">; rel=prefetch")
+					}
+				}
+			}
+		}
+		w.WriteHeader(bw.status)
+		_, _ = w.Write(bw.body.Bytes()) //nolint:errcheck
+	})
+}
+
+// bufferedResponseWriter holds back the status and body of a response so that headers can be added once it is
+// complete.
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponseWriter) WriteHeader(status int) { b.status = status }
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) { return b.body.Write(p) } //nolint:wrapcheck
@@ -0,0 +1,469 @@
+package gomod
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/errors"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/httputil"
+	"github.com/block/cachew/internal/logging"
+)
+
+const (
+	defaultIndexLimit = 100
+	maxIndexLimit     = 1000
+)
+
+// indexKey is the cache key the paths of the indexed modules are persisted under, and moduleIndexKey that of the
+// versions of each module. They are not valid goproxy names, so cannot collide with cached module files.
+var indexKey = cache.NewKey("gomod:_index:modules") //nolint:gochecknoglobals
+
+func moduleIndexKey(modulePath string) cache.Key { return cache.NewKey("gomod:_index/" + modulePath) }
+
+// indexFlushDelay is how long recorded files are batched before the index is persisted.
+const indexFlushDelay = time.Second
+
+// indexExtensions are the module files recorded in the index.
+var indexExtensions = []string{".info", ".mod", ".zip"} //nolint:gochecknoglobals
+
+// ModuleVersion is an entry of the module index.
+type ModuleVersion struct {
+	Path    string   `json:"path"`
+	Version string   `json:"version"`
+	Files   []string `json:"files"` // Extensions of the cached files, eg. ".mod".
+}
+
+// IndexPage is a page of the module index.
+type IndexPage struct {
+	Modules []ModuleVersion `json:"modules"`
+	// Next is the cursor to pass as "after" to fetch the next page, or empty if this is the last page.
+	Next string `json:"next,omitempty"`
+}
+
+// moduleIndex records the module versions cached by the strategy, as cache keys are hashes that cannot be
+// enumerated.
+//
+// The versions of each module are persisted in an object of their own, and the paths of the indexed modules in
+// another. Recorded files are batched for indexFlushDelay, then each object that changed is merged with its persisted
+// copy and written, so that entries recorded by other instances sharing the cache are retained. Two instances writing
+// the same object at once may still lose the entries of one, but as each module has its own object that requires
+// both to cache files of the same module within moments of each other. Files recorded less than indexFlushDelay before
+// the process exits are not persisted.
+//
+// As files may be evicted at any time, entries are checked against the cache when listed and pruned if none of their
+// files remain. The cache is checked without holding the lock, so listing does not block recording.
+type moduleIndex struct {
+	cache cache.Cache
+
+	flushMu sync.Mutex // Serialises writes of the persisted index.
+
+	mu          sync.Mutex
+	pathsLoaded bool
+	modules     map[string]map[string][]string // Files by version by module path.
+	loaded      map[string]bool                // Modules merged with their persisted versions.
+	dirty       map[string]bool                // Modules with files not yet persisted.
+	changes     map[string]uint64              // Number of files recorded for each module, to detect recording while pruning.
+	newPaths    bool                           // Whether modules may have been added since the paths were persisted.
+	flushing    bool                           // Whether a flush is scheduled.
+}
+
+func newModuleIndex(c cache.Cache) *moduleIndex {
+	return &moduleIndex{
+		cache:   c,
+		modules: map[string]map[string][]string{},
+		loaded:  map[string]bool{},
+		dirty:   map[string]bool{},
+		changes: map[string]uint64{},
+	}
+}
+
+// record adds the module file cached under the goproxy name to the index, scheduling it to be persisted.
+func (m *moduleIndex) record(ctx context.Context, name string) {
+	modulePath, version, ext, ok := parseModuleFileName(name)
+	if !ok {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, known := m.modules[modulePath]; !known {
+		m.newPaths = true
+		m.modules[modulePath] = map[string][]string{}
+	}
+	if !addFile(m.modules[modulePath], version, ext) {
+		return
+	}
+	m.dirty[modulePath] = true
+	m.changes[modulePath]++
+	if m.flushing {
+		return
+	}
+	m.flushing = true
+	// The flush outlives the request that cached the file.
+	ctx = context.WithoutCancel(ctx)
+	time.AfterFunc(indexFlushDelay, func() {
+		if err := m.flush(ctx); err != nil {
+			logging.FromContext(ctx).WarnContext(ctx, "Failed to persist module index", slog.String("error", err.Error()))
+		}
+	})
+}
+
+// flush persists the modules recorded since the last flush, merging each with its persisted copy.
+func (m *moduleIndex) flush(ctx context.Context) error {
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	m.mu.Lock()
+	m.flushing = false
+	dirty := make(map[string]map[string][]string, len(m.dirty))
+	for modulePath := range m.dirty {
+		dirty[modulePath] = cloneVersions(m.modules[modulePath])
+	}
+	clear(m.dirty)
+	newPaths := m.newPaths
+	m.newPaths = false
+	paths := slices.Collect(maps.Keys(m.modules))
+	m.mu.Unlock()
+
+	var errs []error
+	for modulePath, versions := range dirty {
+		stored := map[string][]string{}
+		err := m.read(ctx, moduleIndexKey(modulePath), &stored)
+		if err == nil {
+			mergeVersions(stored, versions)
+			err = m.write(ctx, moduleIndexKey(modulePath), stored)
+		}
+		m.mu.Lock()
+		if err != nil {
+			// Retried by the next flush.
+			m.dirty[modulePath] = true
+			errs = append(errs, errors.Errorf("%s: %w", modulePath, err))
+		} else if current, ok := m.modules[modulePath]; ok {
+			mergeVersions(current, stored)
+			m.loaded[modulePath] = true
+		}
+		m.mu.Unlock()
+	}
+	if newPaths {
+		var stored []string
+		err := m.read(ctx, indexKey, &stored)
+		if err == nil {
+			stored = slices.Compact(slices.Sorted(slices.Values(append(stored, paths...))))
+			err = m.write(ctx, indexKey, stored)
+		}
+		if err != nil {
+			m.mu.Lock()
+			m.newPaths = true
+			m.mu.Unlock()
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// list returns up to limit entries whose path has prefix, following the entry identified by the cursor after.
+func (m *moduleIndex) list(ctx context.Context, prefix, after string, limit int) (IndexPage, error) {
+	paths, err := m.modulePaths(ctx, prefix)
+	if err != nil {
+		return IndexPage{}, err
+	}
+	afterPath, afterVersion, _ := strings.Cut(after, "@")
+
+	page := IndexPage{Modules: []ModuleVersion{}}
+	for _, modulePath := range paths {
+		if modulePath < afterPath {
+			continue
+		}
+		versions, changes, err := m.versions(ctx, modulePath)
+		if err != nil {
+			return IndexPage{}, err
+		}
+		missing := map[string][]string{}
+		for _, version := range sortedVersions(versions) {
+			if modulePath == afterPath && semver.Compare(version, afterVersion) <= 0 {
+				continue
+			}
+			if len(page.Modules) == limit {
+				last := page.Modules[len(page.Modules)-1]
+				page.Next = last.Path + "@" + last.Version
+				return page, m.prune(ctx, modulePath, changes, missing)
+			}
+			files, gone, err := m.cachedFiles(ctx, modulePath, version, versions[version])
+			if err != nil {
+				return IndexPage{}, err
+			}
+			if len(gone) > 0 {
+				missing[version] = gone
+			}
+			if len(files) > 0 {
+				page.Modules = append(page.Modules, ModuleVersion{Path: modulePath, Version: version, Files: files})
+			}
+		}
+		if err := m.prune(ctx, modulePath, changes, missing); err != nil {
+			return IndexPage{}, err
+		}
+	}
+	return page, nil
+}
+
+// modulePaths returns the sorted paths of the indexed modules that have prefix, loading the persisted paths the first
+// time it is called.
+func (m *moduleIndex) modulePaths(ctx context.Context, prefix string) ([]string, error) {
+	m.mu.Lock()
+	loaded := m.pathsLoaded
+	m.mu.Unlock()
+	if !loaded {
+		var stored []string
+		if err := m.read(ctx, indexKey, &stored); err != nil {
+			return nil, err
+		}
+		m.mu.Lock()
+		for _, modulePath := range stored {
+			if _, ok := m.modules[modulePath]; !ok {
+				m.modules[modulePath] = map[string][]string{}
+			}
+		}
+		m.pathsLoaded = true
+		m.mu.Unlock()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	paths := make([]string, 0, len(m.modules))
+	for modulePath := range m.modules {
+		if strings.HasPrefix(modulePath, prefix) {
+			paths = append(paths, modulePath)
+		}
+	}
+	slices.Sort(paths)
+	return paths, nil
+}
+
+// versions returns a copy of the indexed versions of a module and the number of files recorded for it, loading its
+// persisted versions the first time it is listed.
+func (m *moduleIndex) versions(ctx context.Context, modulePath string) (map[string][]string, uint64, error) {
+	m.mu.Lock()
+	loaded := m.loaded[modulePath]
+	m.mu.Unlock()
+	if !loaded {
+		stored := map[string][]string{}
+		if err := m.read(ctx, moduleIndexKey(modulePath), &stored); err != nil {
+			return nil, 0, err
+		}
+		m.mu.Lock()
+		if current, ok := m.modules[modulePath]; ok {
+			mergeVersions(current, stored)
+			m.loaded[modulePath] = true
+		}
+		m.mu.Unlock()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return cloneVersions(m.modules[modulePath]), m.changes[modulePath], nil
+}
+
+// prune removes the files of a module found by list to be no longer cached, and persists its remaining versions.
+//
+// Nothing is pruned if files were recorded for the module since it was listed, as they may have been cached again, and
+// the persisted copy is not merged first, as that would restore the pruned files. A module without any remaining
+// versions is removed from the index.
+func (m *moduleIndex) prune(ctx context.Context, modulePath string, changes uint64, missing map[string][]string) error {
+	if len(missing) == 0 {
+		return nil
+	}
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	m.mu.Lock()
+	versions, ok := m.modules[modulePath]
+	if !ok || m.changes[modulePath] != changes {
+		m.mu.Unlock()
+		return nil
+	}
+	for version, gone := range missing {
+		versions[version] = slices.DeleteFunc(versions[version], func(ext string) bool { return slices.Contains(gone, ext) })
+		if len(versions[version]) == 0 {
+			delete(versions, version)
+		}
+	}
+	remaining := cloneVersions(versions)
+	if len(remaining) == 0 {
+		delete(m.modules, modulePath)
+		delete(m.loaded, modulePath)
+		delete(m.changes, modulePath)
+	}
+	m.mu.Unlock()
+
+	if len(remaining) > 0 {
+		return m.write(ctx, moduleIndexKey(modulePath), remaining)
+	}
+	if err := m.cache.Delete(ctx, moduleIndexKey(modulePath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.Wrap(err, "failed to delete module index")
+	}
+	var stored []string
+	if err := m.read(ctx, indexKey, &stored); err != nil {
+		return err
+	}
+	return m.write(ctx, indexKey, slices.DeleteFunc(stored, func(p string) bool { return p == modulePath }))
+}
+
+// cachedFiles returns those of the recorded files of a module version that are still cached, and those that are not.
+func (m *moduleIndex) cachedFiles(ctx context.Context, modulePath, version string, files []string) (cached, missing []string, err error) {
+	escapedPath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	escapedVersion, err := module.EscapeVersion(version)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	for _, ext := range files {
+		exists, err := m.cache.Has(ctx, cache.NewKey(escapedPath+"/@v/"+escapedVersion+ext))
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to check module file")
+		}
+		if exists {
+			cached = append(cached, ext)
+		} else {
+			missing = append(missing, ext)
+		}
+	}
+	return cached, missing, nil
+}
+
+// read decodes the persisted index object under key into v, leaving v unchanged if there is none.
+func (m *moduleIndex) read(ctx context.Context, key cache.Key, v any) error {
+	r, _, err := m.cache.Open(ctx, key)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "failed to open module index")
+	}
+	defer r.Close()
+	if err := json.NewDecoder(r).Decode(v); err != nil {
+		// A corrupt index is rebuilt as modules are cached again.
+		logging.FromContext(ctx).WarnContext(ctx, "Discarding unreadable module index", slog.String("error", err.Error()))
+	}
+	return nil
+}
+
+func (m *moduleIndex) write(ctx context.Context, key cache.Key, v any) error {
+	// The index must outlive the module files it lists, so is kept for the cache's maximum TTL.
+	w, err := m.cache.Create(ctx, key, http.Header{"Content-Type": []string{"application/json"}}, 0)
+	if err != nil {
+		return errors.Wrap(err, "failed to create module index")
+	}
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		return errors.Join(errors.Wrap(err, "failed to write module index"), w.Close())
+	}
+	return errors.Wrap(w.Close(), "failed to write module index")
+}
+
+// addFile adds the file with extension ext of a version to versions, returning false if it was already present.
+func addFile(versions map[string][]string, version, ext string) bool {
+	if slices.Contains(versions[version], ext) {
+		return false
+	}
+	versions[version] = append(versions[version], ext)
+	slices.Sort(versions[version])
+	return true
+}
+
+// mergeVersions adds the files of each version in src to dst.
+func mergeVersions(dst, src map[string][]string) {
+	for version, files := range src {
+		for _, ext := range files {
+			addFile(dst, version, ext)
+		}
+	}
+}
+
+func cloneVersions(versions map[string][]string) map[string][]string {
+	cloned := make(map[string][]string, len(versions))
+	for version, files := range versions {
+		cloned[version] = slices.Clone(files)
+	}
+	return cloned
+}
+
+// sortedVersions returns the versions of a module in semver order.
+func sortedVersions(versions map[string][]string) []string {
+	sorted := make([]string, 0, len(versions))
+	for version := range versions {
+		sorted = append(sorted, version)
+	}
+	semver.Sort(sorted)
+	return sorted
+}
+
+// parseModuleFileName parses a goproxy name of the form "<escaped path>/@v/<escaped version><ext>" for one of the
+// indexed extensions.
+func parseModuleFileName(name string) (modulePath, version, ext string, ok bool) {
+	escapedPath, file, ok := strings.Cut(name, "/@v/")
+	if !ok {
+		return "", "", "", false
+	}
+	ext = path.Ext(file)
+	if !slices.Contains(indexExtensions, ext) {
+		return "", "", "", false
+	}
+	modulePath, err := module.UnescapePath(escapedPath)
+	if err != nil {
+		return "", "", "", false
+	}
+	version, err = module.UnescapeVersion(strings.TrimSuffix(file, ext))
+	if err != nil || !semver.IsValid(version) {
+		return "", "", "", false
+	}
+	return modulePath, version, ext, true
+}
+
+// handleIndex serves a page of the module index as JSON, compressed with gzip if the client accepts it.
+//
+// Query parameters are "prefix" to only list modules whose path starts with it, "limit" for the page size, and
+// "after" for the cursor returned as "next" by the previous page.
+func (s *Strategy) handleIndex(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultIndexLimit
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			httputil.ErrorResponse(w, r, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = min(parsed, maxIndexLimit)
+	}
+	page, err := s.index.list(r.Context(), query.Get("prefix"), query.Get("after"), limit)
+	if err != nil {
+		httputil.ErrorResponse(w, r, http.StatusInternalServerError, "Failed to list module index", "error", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Vary", "Accept-Encoding")
+	var out io.Writer = w
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	}
+	if err := json.NewEncoder(out).Encode(page); err != nil {
+		logging.FromContext(r.Context()).ErrorContext(r.Context(), "Failed to write module index", slog.String("error", err.Error()))
+	}
+}
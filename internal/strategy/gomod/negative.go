@@ -0,0 +1,80 @@
+package gomod
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/errors"
+	"github.com/goproxy/goproxy"
+
+	"github.com/block/cachew/internal/cache"
+)
+
+// negativeCacheHeader marks cache entries recording that a module version does not exist.
+const negativeCacheHeader = "X-Cachew-Not-Found"
+
+// transientNotFound are fragments of goproxy "not found" errors that are caused by upstream failures rather than a
+// missing module, so must not be negatively cached.
+var transientNotFound = []string{"bad upstream", "fetch timed out"} //nolint:gochecknoglobals
+
+// negativeCachingFetcher is a [goproxy.Fetcher] that briefly remembers module versions upstream reported as missing,
+// so repeated requests for them don't all reach upstream.
+//
+// Only downloads (.info, .mod and .zip) are negatively cached. Version lists and queries such as @latest are always
+// passed through, so a newly published version is discovered as soon as the negative entry expires.
+type negativeCachingFetcher struct {
+	fetcher goproxy.Fetcher
+	cache   cache.Cache
+	ttl     time.Duration
+}
+
+var _ goproxy.Fetcher = (*negativeCachingFetcher)(nil)
+
+func (n *negativeCachingFetcher) Query(ctx context.Context, path, query string) (string, time.Time, error) {
+	return errors.WithStack3(n.fetcher.Query(ctx, path, query))
+}
+
+func (n *negativeCachingFetcher) List(ctx context.Context, path string) ([]string, error) {
+	return errors.WithStack2(n.fetcher.List(ctx, path))
+}
+
+func (n *negativeCachingFetcher) Download(ctx context.Context, path, version string) (info, mod, zip io.ReadSeekCloser, err error) {
+	key := cache.NewKey("not-found:" + path + "@" + version)
+	if r, _, err := n.cache.Open(ctx, key); err == nil {
+		reason, readErr := io.ReadAll(r)
+		_ = r.Close()
+		if readErr == nil {
+			return nil, nil, nil, errors.Errorf("%s: %w", reason, fs.ErrNotExist)
+		}
+	}
+
+	info, mod, zip, err = n.fetcher.Download(ctx, path, version)
+	if err != nil && errors.Is(err, fs.ErrNotExist) && !isTransientNotFound(err) {
+		n.remember(ctx, key, err)
+	}
+	return info, mod, zip, errors.WithStack(err)
+}
+
+// remember records that a module version does not exist, storing the upstream error as the entry's body.
+func (n *negativeCachingFetcher) remember(ctx context.Context, key cache.Key, reason error) {
+	w, err := n.cache.Create(ctx, key, http.Header{negativeCacheHeader: []string{"1"}}, n.ttl)
+	if err != nil {
+		return
+	}
+	_, err = io.WriteString(w, reason.Error())
+	_ = errors.Join(err, w.Close())
+}
+
+func isTransientNotFound(err error) bool {
+	msg := err.Error()
+	for _, fragment := range transientNotFound {
+		if strings.Contains(msg, fragment) {
+			return true
+		}
+	}
+	return false
+}
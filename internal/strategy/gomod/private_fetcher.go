@@ -8,6 +8,8 @@ import (
 	"io"
 	"io/fs"
 	"log/slog"
+	"net/http"
+	"os"
 	"os/exec"
 	"sort"
 	"strings"
@@ -22,6 +24,8 @@ import (
 type privateFetcher struct {
 	logger       *slog.Logger
 	cloneManager *gitclone.Manager
+	// resolveRepo maps a module path to the URL of the git repository hosting it.
+	resolveRepo func(ctx context.Context, modulePath string) (string, error)
 }
 
 type moduleInfo struct {
@@ -33,6 +37,7 @@ func newPrivateFetcher(logger *slog.Logger, cloneManager *gitclone.Manager) *pri
 	return &privateFetcher{
 		logger:       logger,
 		cloneManager: cloneManager,
+		resolveRepo:  newVanityResolver(&http.Client{Timeout: 30 * time.Second}).Resolve,
 	}
 }
 
@@ -40,7 +45,10 @@ func (p *privateFetcher) Query(ctx context.Context, path, query string) (version
 	logger := p.logger.With(slog.String("module", path), slog.String("query", query))
 	logger.DebugContext(ctx, "Private fetcher: Query")
 
-	gitURL := p.modulePathToGitURL(path)
+	gitURL, err := p.resolveRepo(ctx, path)
+	if err != nil {
+		return "", time.Time{}, errors.Wrapf(err, "resolve repository for %s", path)
+	}
 
 	repo, err := p.cloneManager.GetOrCreate(ctx, gitURL)
 	if err != nil {
@@ -63,7 +71,10 @@ func (p *privateFetcher) List(ctx context.Context, path string) (versions []stri
 	logger := p.logger.With(slog.String("module", path))
 	logger.DebugContext(ctx, "Private fetcher: List")
 
-	gitURL := p.modulePathToGitURL(path)
+	gitURL, err := p.resolveRepo(ctx, path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolve repository for %s", path)
+	}
 	repo, err := p.cloneManager.GetOrCreate(ctx, gitURL)
 	if err != nil {
 		return nil, errors.Wrapf(err, "get or create clone for %s", path)
@@ -85,7 +96,10 @@ func (p *privateFetcher) Download(ctx context.Context, path, version string) (in
 	logger := p.logger.With(slog.String("module", path), slog.String("version", version))
 	logger.DebugContext(ctx, "Private fetcher: Download")
 
-	gitURL := p.modulePathToGitURL(path)
+	gitURL, err := p.resolveRepo(ctx, path)
+	if err != nil {
+		return nil, nil, nil, errors.Wrapf(err, "resolve repository for %s", path)
+	}
 	repo, err := p.cloneManager.GetOrCreate(ctx, gitURL)
 	if err != nil {
 		return nil, nil, nil, errors.Wrapf(err, "get or create clone for %s", path)
@@ -146,15 +160,8 @@ func (p *privateFetcher) ensureReady(ctx context.Context, repo *gitclone.Reposit
 	}
 }
 
-func (p *privateFetcher) modulePathToGitURL(modulePath string) string {
-	return "https://" + modulePath
-}
-
 func (p *privateFetcher) verifyCommitExists(ctx context.Context, repo *gitclone.Repository, ref string) error {
-	if !repo.HasCommit(ctx, ref) {
-		return errors.Errorf("commit %s not found in repository %s", ref, repo.UpstreamURL())
-	}
-	return nil
+	return errors.WithStack(repo.EnsureCommit(ctx, ref))
 }
 
 func (p *privateFetcher) resolveVersionQuery(ctx context.Context, repo *gitclone.Repository, query string) (string, time.Time, error) {
@@ -268,37 +275,66 @@ func (p *privateFetcher) generateInfo(ctx context.Context, repo *gitclone.Reposi
 	return newReadSeekCloser(bytes.NewReader(data)), nil
 }
 
-func (p *privateFetcher) generateMod(ctx context.Context, repo *gitclone.Repository, modulePath, version string) io.ReadSeekCloser {
-	output, err := gitclone.WithReadLockReturn(repo, func() ([]byte, error) {
-		// #nosec G204 - version and repo.Path() are controlled by this package, not user input
-		cmd := exec.CommandContext(ctx, "git", "-C", repo.Path(), "show", fmt.Sprintf("%s:go.mod", version))
-		return cmd.CombinedOutput()
-	})
+// maxGoModSize is the largest go.mod file served, matching the limit enforced by the go command.
+const maxGoModSize = 16 << 20
 
+func (p *privateFetcher) generateMod(ctx context.Context, repo *gitclone.Repository, modulePath, version string) io.ReadSeekCloser {
+	mod, err := p.readMod(ctx, repo, version)
 	if err != nil {
+		p.logger.DebugContext(ctx, "Using minimal go.mod", slog.String("module", modulePath), slog.String("error", err.Error()))
 		minimal := fmt.Sprintf("module %s\n\ngo 1.21\n", modulePath)
 		return newReadSeekCloser(bytes.NewReader([]byte(minimal)))
 	}
+	return newReadSeekCloser(bytes.NewReader(mod))
+}
 
-	return newReadSeekCloser(bytes.NewReader(output))
+// readMod reads go.mod at version. Whether it exists is only known once git exits, so it is read in full.
+func (p *privateFetcher) readMod(ctx context.Context, repo *gitclone.Repository, version string) ([]byte, error) {
+	show, err := repo.Show(ctx, version, "go.mod")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer show.Close()
+	mod, err := io.ReadAll(io.LimitReader(show, maxGoModSize+1))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(mod) > maxGoModSize {
+		return nil, errors.Errorf("go.mod is larger than %d bytes", maxGoModSize)
+	}
+	return mod, nil
 }
 
 func (p *privateFetcher) generateZip(ctx context.Context, repo *gitclone.Repository, modulePath, version string) (io.ReadSeekCloser, error) {
 	prefix := fmt.Sprintf("%s@%s/", modulePath, version)
-	output, err := gitclone.WithReadLockReturn(repo, func() ([]byte, error) {
-		// #nosec G204 - version and repo.Path() are controlled by this package, not user input
-		cmd := exec.CommandContext(ctx, "git", "-C", repo.Path(), "archive",
-			"--format=zip",
-			fmt.Sprintf("--prefix=%s", prefix),
-			version)
-		return cmd.CombinedOutput()
-	})
+	archive, err := repo.Archive(ctx, version, "zip", prefix)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer archive.Close()
 
+	// goproxy needs to seek within the zip, so it is spooled to disk rather than held in memory.
+	f, err := os.CreateTemp("", "cachew-gomod-*.zip")
 	if err != nil {
-		return nil, errors.Wrapf(err, "git archive failed: %s", string(output))
+		return nil, errors.Wrap(err, "create temporary zip")
+	}
+	zip := &tempFile{File: f}
+	if _, err := io.Copy(f, archive); err != nil {
+		return nil, errors.Join(errors.WithStack(err), zip.Close())
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Join(errors.WithStack(err), zip.Close())
 	}
+	return zip, nil
+}
+
+// tempFile is a temporary file that is removed when closed.
+type tempFile struct {
+	*os.File
+}
 
-	return newReadSeekCloser(bytes.NewReader(output)), nil
+func (t *tempFile) Close() error {
+	return errors.Join(t.File.Close(), os.Remove(t.Name()))
 }
 
 type readSeekCloser struct {
@@ -0,0 +1,96 @@
+package gomod //nolint:testpackage
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/logging"
+)
+
+func TestPrivateFetcherDownload(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+
+	repoDir := filepath.Join(t.TempDir(), "large")
+	run := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", repoDir}, args...)...)
+		output, err := cmd.CombinedOutput()
+		assert.NoError(t, err, string(output))
+	}
+	assert.NoError(t, os.MkdirAll(repoDir, 0o750))
+	run("init", "-q", "-b", "main")
+	run("config", "user.email", "test@example.com")
+	run("config", "user.name", "Test")
+	// Incompressible files, so the archive is as large as the module.
+	files := map[string][]byte{}
+	for i := range 8 {
+		content := make([]byte, 1<<20)
+		_, _ = rand.Read(content)
+		name := fmt.Sprintf("data%d.bin", i)
+		files[name] = content
+		assert.NoError(t, os.WriteFile(filepath.Join(repoDir, name), content, 0o600))
+	}
+	run("add", ".")
+	run("commit", "-q", "-m", "without go.mod")
+	run("tag", "v1.0.0")
+	assert.NoError(t, os.WriteFile(filepath.Join(repoDir, "go.mod"), []byte("module example.com/large\n"), 0o600))
+	run("add", "go.mod")
+	run("commit", "-q", "-m", "add go.mod")
+	run("tag", "v1.1.0")
+
+	cloneManager, err := gitclone.NewManager(ctx, gitclone.Config{MirrorRoot: t.TempDir()})
+	assert.NoError(t, err)
+	fetcher := newPrivateFetcher(logging.FromContext(ctx), cloneManager)
+	fetcher.resolveRepo = func(context.Context, string) (string, error) { return "file://" + repoDir, nil }
+
+	tests := []struct {
+		version     string
+		expectedMod string
+	}{
+		{version: "v1.0.0", expectedMod: "module example.com/large\n\ngo 1.21\n"},
+		{version: "v1.1.0", expectedMod: "module example.com/large\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			info, mod, zipReader, err := fetcher.Download(ctx, "example.com/large", tt.version)
+			assert.NoError(t, err)
+			defer info.Close()
+			defer mod.Close()
+
+			content, err := io.ReadAll(mod)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedMod, string(content))
+
+			data, err := io.ReadAll(zipReader)
+			assert.NoError(t, err)
+			zipFile, ok := zipReader.(*tempFile)
+			assert.True(t, ok, "zip should be spooled to a temporary file")
+			assert.NoError(t, zipReader.Close())
+			_, err = os.Stat(zipFile.Name())
+			assert.True(t, os.IsNotExist(err), "temporary zip should be removed when closed")
+
+			zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			assert.NoError(t, err)
+			prefix := "example.com/large@" + tt.version + "/"
+			for name, expected := range files {
+				f, err := zr.Open(prefix + name)
+				assert.NoError(t, err)
+				actual, err := io.ReadAll(f)
+				assert.NoError(t, err)
+				assert.NoError(t, f.Close())
+				assert.True(t, bytes.Equal(expected, actual), "%s differs", name)
+			}
+		})
+	}
+}
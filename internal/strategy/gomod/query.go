@@ -0,0 +1,220 @@
+package gomod
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/errors"
+	"github.com/goproxy/goproxy"
+
+	"github.com/block/cachew/internal/cache"
+)
+
+// queryFetchedHeader records when a cached query response was fetched from upstream.
+const queryFetchedHeader = "X-Cachew-Fetched"
+
+// queryCachingFetcher is a [goproxy.Fetcher] that briefly caches @latest queries and version lists, which are
+// otherwise fetched from upstream on every request.
+//
+// Concurrent lookups of the same module share a single upstream call, and responses are reused for ttl. The last
+// known response is kept for staleTTL and served if upstream fails, so an upstream blip does not fail builds. After a
+// failure, upstream is not retried for that module until ttl has passed.
+type queryCachingFetcher struct {
+	fetcher  goproxy.Fetcher
+	cache    cache.Cache
+	logger   *slog.Logger
+	ttl      time.Duration
+	staleTTL time.Duration
+
+	mu      sync.Mutex
+	calls   map[string]*queryCall
+	backoff map[string]time.Time // Lookups that failed upstream, and when they may be retried.
+}
+
+var _ goproxy.Fetcher = (*queryCachingFetcher)(nil)
+
+// queryCall is an upstream lookup that concurrent callers wait on.
+type queryCall struct {
+	done chan struct{}
+	body []byte
+	err  error
+}
+
+type queryResponse struct {
+	Version string
+	Time    time.Time
+}
+
+func newQueryCachingFetcher(logger *slog.Logger, fetcher goproxy.Fetcher, c cache.Cache, ttl, staleTTL time.Duration) *queryCachingFetcher {
+	return &queryCachingFetcher{
+		fetcher:  fetcher,
+		cache:    c,
+		logger:   logger,
+		ttl:      ttl,
+		staleTTL: staleTTL,
+		calls:    map[string]*queryCall{},
+		backoff:  map[string]time.Time{},
+	}
+}
+
+func (q *queryCachingFetcher) Query(ctx context.Context, path, query string) (string, time.Time, error) {
+	if query != "latest" {
+		return errors.WithStack3(q.fetcher.Query(ctx, path, query))
+	}
+	body, err := q.fetch(ctx, "latest:"+path, func(ctx context.Context) ([]byte, error) {
+		version, t, err := q.fetcher.Query(ctx, path, query)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return errors.WithStack2(json.Marshal(queryResponse{Version: version, Time: t}))
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var response queryResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", time.Time{}, errors.Errorf("invalid cached query response: %w", err)
+	}
+	return response.Version, response.Time, nil
+}
+
+func (q *queryCachingFetcher) List(ctx context.Context, path string) ([]string, error) {
+	body, err := q.fetch(ctx, "list:"+path, func(ctx context.Context) ([]byte, error) {
+		versions, err := q.fetcher.List(ctx, path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return []byte(strings.Join(versions, "\n")), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(body) == 0 {
+		return []string{}, nil
+	}
+	return strings.Split(string(body), "\n"), nil
+}
+
+func (q *queryCachingFetcher) Download(ctx context.Context, path, version string) (info, mod, zip io.ReadSeekCloser, err error) {
+	info, mod, zip, err = q.fetcher.Download(ctx, path, version)
+	return info, mod, zip, errors.WithStack(err)
+}
+
+// fetch returns the response to the lookup "name" from the cache if it was fetched less than ttl ago, otherwise from
+// upstream via fetchUpstream, falling back to the last known response if upstream fails.
+func (q *queryCachingFetcher) fetch(ctx context.Context, name string, fetchUpstream func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	key := cache.NewKey("gomod-query:" + name)
+	body, fetched, err := q.load(ctx, key)
+	if err == nil && (time.Since(fetched) < q.ttl || q.backingOff(name)) {
+		return body, nil
+	}
+
+	body, err = q.coalesce(name, func() ([]byte, error) {
+		// Shared by every waiting request, so must not be cancelled with the first.
+		ctx := context.WithoutCancel(ctx)
+		body, err := fetchUpstream(ctx)
+		q.mu.Lock()
+		if isUpstreamFailure(err) {
+			q.backoff[name] = time.Now().Add(q.ttl)
+		} else {
+			delete(q.backoff, name)
+		}
+		q.mu.Unlock()
+		if err == nil {
+			q.store(ctx, key, body)
+		}
+		return body, err
+	})
+	if !isUpstreamFailure(err) {
+		return body, err
+	}
+	stale, fetched, staleErr := q.load(ctx, key)
+	if staleErr != nil {
+		return nil, err
+	}
+	q.logger.WarnContext(ctx, "Upstream failed, serving last known response",
+		slog.String("lookup", name), slog.Duration("age", time.Since(fetched)), slog.String("error", err.Error()))
+	return stale, nil
+}
+
+// backingOff returns true if the lookup "name" recently failed upstream and should not yet be retried.
+func (q *queryCachingFetcher) backingOff(name string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	retryAt, ok := q.backoff[name]
+	if ok && time.Now().After(retryAt) {
+		delete(q.backoff, name)
+		return false
+	}
+	return ok
+}
+
+// coalesce calls fn, or if a call for name is already in flight waits for and returns its result instead.
+func (q *queryCachingFetcher) coalesce(name string, fn func() ([]byte, error)) ([]byte, error) {
+	q.mu.Lock()
+	if call, ok := q.calls[name]; ok {
+		q.mu.Unlock()
+		<-call.done
+		return call.body, call.err
+	}
+	call := &queryCall{done: make(chan struct{})}
+	q.calls[name] = call
+	q.mu.Unlock()
+
+	call.body, call.err = fn()
+
+	q.mu.Lock()
+	delete(q.calls, name)
+	q.mu.Unlock()
+	close(call.done)
+	return call.body, call.err
+}
+
+// load returns a cached response and when it was fetched.
+func (q *queryCachingFetcher) load(ctx context.Context, key cache.Key) ([]byte, time.Time, error) {
+	r, headers, err := q.cache.Open(ctx, key)
+	if err != nil {
+		return nil, time.Time{}, errors.WithStack(err)
+	}
+	defer r.Close()
+	var fetched time.Time
+	if err := fetched.UnmarshalText([]byte(headers.Get(queryFetchedHeader))); err != nil {
+		return nil, time.Time{}, errors.Errorf("invalid %s header: %w", queryFetchedHeader, err)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, time.Time{}, errors.WithStack(err)
+	}
+	return body, fetched, nil
+}
+
+// store caches a response for staleTTL, so it can be served if upstream later fails.
+func (q *queryCachingFetcher) store(ctx context.Context, key cache.Key, body []byte) {
+	fetched, err := time.Now().MarshalText()
+	if err != nil {
+		return
+	}
+	w, err := q.cache.Create(ctx, key, http.Header{queryFetchedHeader: []string{string(fetched)}}, q.staleTTL)
+	if err == nil {
+		_, err = w.Write(body)
+		err = errors.Join(err, w.Close())
+	}
+	if err != nil {
+		q.logger.WarnContext(ctx, "Failed to cache query response", slog.String("key", key.String()), slog.String("error", err.Error()))
+	}
+}
+
+// isUpstreamFailure returns true if err is a failure to reach upstream, rather than a module that does not exist.
+func isUpstreamFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	return !errors.Is(err, fs.ErrNotExist) || isTransientNotFound(err)
+}
@@ -0,0 +1,182 @@
+package gomod
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/errors"
+)
+
+// vanityCacheTTL is how long a resolved go-import is reused before the vanity host is asked again.
+const vanityCacheTTL = time.Hour
+
+// knownGitHosts serve their repositories at the module path, so they are never asked for a go-import.
+var knownGitHosts = []string{"github.com/", "gitlab.com/", "bitbucket.org/"}
+
+// vanityResolver maps module paths to the git repositories hosting them.
+//
+// Vanity import paths, eg. "go.uber.org/zap", are resolved with the "go-import" meta tag served by the vanity host in
+// response to "https://<module path>?go-get=1", as described in "go help importpath". Resolutions are cached per
+// repository root so that every module and package below a root is resolved with a single request.
+type vanityResolver struct {
+	client *http.Client
+
+	mu    sync.Mutex
+	roots map[string]vanityRoot // Keyed by import prefix.
+}
+
+type vanityRoot struct {
+	repoURL   string
+	expiresAt time.Time
+}
+
+func newVanityResolver(client *http.Client) *vanityResolver {
+	return &vanityResolver{client: client, roots: map[string]vanityRoot{}}
+}
+
+// Resolve returns the URL of the git repository hosting modulePath.
+//
+// Modules on well-known hosts, and modules whose host does not serve a go-import meta tag, are assumed to be hosted
+// at "https://<module path>".
+func (v *vanityResolver) Resolve(ctx context.Context, modulePath string) (string, error) {
+	for _, host := range knownGitHosts {
+		if strings.HasPrefix(modulePath, host) {
+			return "https://" + modulePath, nil
+		}
+	}
+	if repoURL, ok := v.cached(modulePath); ok {
+		return repoURL, nil
+	}
+	imports, err := v.fetchImports(ctx, modulePath)
+	if err != nil {
+		return "", err
+	}
+	if len(imports) == 0 {
+		return "https://" + modulePath, nil
+	}
+	match, err := matchGoImport(imports, modulePath)
+	if err != nil {
+		return "", err
+	}
+	v.mu.Lock()
+	v.roots[match.prefix] = vanityRoot{repoURL: match.repoURL, expiresAt: time.Now().Add(vanityCacheTTL)}
+	v.mu.Unlock()
+	return match.repoURL, nil
+}
+
+func (v *vanityResolver) cached(modulePath string) (string, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := time.Now()
+	for prefix, root := range v.roots {
+		if now.After(root.expiresAt) {
+			delete(v.roots, prefix)
+			continue
+		}
+		if hasPathPrefix(modulePath, prefix) {
+			return root.repoURL, true
+		}
+	}
+	return "", false
+}
+
+func (v *vanityResolver) fetchImports(ctx context.Context, modulePath string) ([]goImport, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+modulePath+"?go-get=1", nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "create go-import request for %s", modulePath)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetch go-import for %s", modulePath)
+	}
+	defer resp.Body.Close()
+	// As with the go command, the meta tags of error pages are honoured too.
+	imports, err := parseGoImports(resp.Body)
+	return imports, errors.Wrapf(err, "parse go-import for %s", modulePath)
+}
+
+type goImport struct {
+	prefix  string
+	vcs     string
+	repoURL string
+}
+
+// parseGoImports returns the go-import meta tags in the head of an HTML document.
+func parseGoImports(r io.Reader) ([]goImport, error) {
+	d := xml.NewDecoder(r)
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		if strings.EqualFold(charset, "utf-8") || strings.EqualFold(charset, "ascii") {
+			return input, nil
+		}
+		return nil, errors.Errorf("can't decode XML document using charset %q", charset)
+	}
+	var imports []goImport
+	for {
+		t, err := d.RawToken()
+		if err != nil {
+			if errors.Is(err, io.EOF) || len(imports) > 0 {
+				return imports, nil
+			}
+			return nil, errors.WithStack(err)
+		}
+		if e, ok := t.(xml.StartElement); ok && strings.EqualFold(e.Name.Local, "body") {
+			return imports, nil
+		}
+		if e, ok := t.(xml.EndElement); ok && strings.EqualFold(e.Name.Local, "head") {
+			return imports, nil
+		}
+		e, ok := t.(xml.StartElement)
+		if !ok || !strings.EqualFold(e.Name.Local, "meta") || xmlAttr(e, "name") != "go-import" {
+			continue
+		}
+		if fields := strings.Fields(xmlAttr(e, "content")); len(fields) == 3 {
+			imports = append(imports, goImport{prefix: fields[0], vcs: fields[1], repoURL: fields[2]})
+		}
+	}
+}
+
+func xmlAttr(e xml.StartElement, name string) string {
+	for _, attr := range e.Attr {
+		if strings.EqualFold(attr.Name.Local, name) {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// matchGoImport returns the git go-import whose prefix contains modulePath.
+//
+// "mod" imports, which refer to a module proxy rather than a repository, are ignored.
+func matchGoImport(imports []goImport, modulePath string) (goImport, error) {
+	var match *goImport
+	for i, imp := range imports {
+		if imp.vcs == "mod" || !hasPathPrefix(modulePath, imp.prefix) {
+			continue
+		}
+		if match != nil {
+			return goImport{}, errors.Errorf("multiple go-import meta tags match %s", modulePath)
+		}
+		match = &imports[i]
+	}
+	switch {
+	case match == nil:
+		return goImport{}, errors.Errorf("no go-import meta tag matches %s", modulePath)
+	case match.vcs != "git":
+		return goImport{}, errors.Errorf("%s is hosted in %s, only git is supported", modulePath, match.vcs)
+	}
+	return *match, nil
+}
+
+// hasPathPrefix reports whether path is prefix or is below it.
+func hasPathPrefix(path, prefix string) bool {
+	rest, ok := strings.CutPrefix(path, prefix)
+	return ok && (rest == "" || strings.HasPrefix(rest, "/"))
+}
@@ -0,0 +1,130 @@
+package gomod //nolint:testpackage
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/gitclone"
+	"github.com/block/cachew/internal/logging"
+)
+
+func TestVanityImportPath(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+
+	repoDir := filepath.Join(t.TempDir(), "zap")
+	run := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", repoDir}, args...)...)
+		output, err := cmd.CombinedOutput()
+		assert.NoError(t, err, string(output))
+	}
+	assert.NoError(t, os.MkdirAll(repoDir, 0o750))
+	run("init", "-q", "-b", "main")
+	run("config", "user.email", "test@example.com")
+	run("config", "user.name", "Test")
+	assert.NoError(t, os.WriteFile(filepath.Join(repoDir, "go.mod"), []byte("module vanity/zap\n"), 0o600))
+	run("add", "go.mod")
+	run("commit", "-q", "-m", "initial")
+	run("tag", "v1.0.0")
+	repoURL := "file://" + repoDir
+
+	var vanityRequests atomic.Int32
+	var modulePrefix string
+	vanity := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vanityRequests.Add(1)
+		assert.Equal(t, "1", r.URL.Query().Get("go-get"))
+		_, _ = fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+<meta name="go-import" content="%[1]s mod https://proxy.example.com">
+<meta name="go-import" content="%[1]s git %[2]s">
+<meta name="go-source" content="%[1]s %[2]s %[2]s/tree{/dir} %[2]s/blob{/dir}/{file}#L{line}">
+</head>
+<body>Nothing to see here.</body>
+</html>`, modulePrefix, repoURL)
+	}))
+	t.Cleanup(vanity.Close)
+	modulePrefix = strings.TrimPrefix(vanity.URL, "https://") + "/zap"
+
+	cloneManager, err := gitclone.NewManager(ctx, gitclone.Config{MirrorRoot: t.TempDir()})
+	assert.NoError(t, err)
+	fetcher := newPrivateFetcher(logging.FromContext(ctx), cloneManager)
+	fetcher.resolveRepo = newVanityResolver(vanity.Client()).Resolve
+
+	versions, err := fetcher.List(ctx, modulePrefix)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"v1.0.0"}, versions)
+	assert.NotZero(t, cloneManager.Get(repoURL), "resolved repository should be cloned")
+	assert.Zero(t, cloneManager.Get("https://"+modulePrefix), "vanity host should not be cloned")
+
+	// Modules below the repository root reuse the cached resolution.
+	_, err = fetcher.List(ctx, modulePrefix+"/v2")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), vanityRequests.Load())
+}
+
+func TestMatchGoImport(t *testing.T) {
+	tests := []struct {
+		name          string
+		imports       []goImport
+		modulePath    string
+		expected      string
+		expectedError string
+	}{
+		{
+			name:       "ExactPrefix",
+			imports:    []goImport{{prefix: "go.uber.org/zap", vcs: "git", repoURL: "https://github.com/uber-go/zap"}},
+			modulePath: "go.uber.org/zap",
+			expected:   "https://github.com/uber-go/zap",
+		},
+		{
+			name:       "BelowRoot",
+			imports:    []goImport{{prefix: "go.uber.org/zap", vcs: "git", repoURL: "https://github.com/uber-go/zap"}},
+			modulePath: "go.uber.org/zap/exp",
+			expected:   "https://github.com/uber-go/zap",
+		},
+		{
+			name: "IgnoresModAndOtherRoots",
+			imports: []goImport{
+				{prefix: "go.uber.org/zap", vcs: "mod", repoURL: "https://proxy.example.com"},
+				{prefix: "go.uber.org/zapper", vcs: "git", repoURL: "https://github.com/uber-go/zapper"},
+				{prefix: "go.uber.org/zap", vcs: "git", repoURL: "https://github.com/uber-go/zap"},
+			},
+			modulePath: "go.uber.org/zap",
+			expected:   "https://github.com/uber-go/zap",
+		},
+		{
+			name:          "NoMatch",
+			imports:       []goImport{{prefix: "go.uber.org/zapper", vcs: "git", repoURL: "https://github.com/uber-go/zapper"}},
+			modulePath:    "go.uber.org/zap",
+			expectedError: "no go-import meta tag matches go.uber.org/zap",
+		},
+		{
+			name:          "UnsupportedVCS",
+			imports:       []goImport{{prefix: "example.com/repo", vcs: "hg", repoURL: "https://hg.example.com/repo"}},
+			modulePath:    "example.com/repo",
+			expectedError: "example.com/repo is hosted in hg, only git is supported",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, err := matchGoImport(tt.imports, tt.modulePath)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, match.repoURL)
+		})
+	}
+}
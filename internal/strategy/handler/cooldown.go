@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxRetryAfter caps how long an upstream can ask us to back off, so a bogus header can't disable a host indefinitely.
+const maxRetryAfter = time.Hour
+
+// hostCooldown tracks upstream hosts that have asked us to back off via Retry-After.
+type hostCooldown struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+func newHostCooldown() *hostCooldown {
+	return &hostCooldown{until: map[string]time.Time{}}
+}
+
+// set a cooldown for host lasting d.
+func (c *hostCooldown) set(host string, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.until[host] = time.Now().Add(min(d, maxRetryAfter))
+}
+
+// remaining returns how long host is still cooling down for, or 0 if it isn't.
+func (c *hostCooldown) remaining(host string) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	until, ok := c.until[host]
+	if !ok {
+		return 0
+	}
+	remaining := time.Until(until)
+	if remaining <= 0 {
+		delete(c.until, host)
+		return 0
+	}
+	return remaining
+}
+
+// parseRetryAfter parses a Retry-After header value, which is either a number of seconds or an HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	return max(time.Until(t), 0), true
+}
+
+// formatRetryAfter formats d as a Retry-After delay in whole seconds, rounding up.
+func formatRetryAfter(d time.Duration) string {
+	return strconv.Itoa(int((d + time.Second - 1) / time.Second))
+}
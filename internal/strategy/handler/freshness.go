@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fetchedHeader records when a response cached with [Handler.HTTPFreshness] was received from upstream, from which its
+// Age is computed when it is served. It is never sent to clients.
+const fetchedHeader = "X-Cachew-Fetched"
+
+// heuristicFraction is the fraction of the time since a response was last modified for which it is considered fresh
+// if it has no explicit expiry, as suggested by RFC 7234 section 4.2.2.
+const heuristicFraction = 10
+
+// HTTPFreshness caches responses for the freshness lifetime given by their headers, using the algorithm of RFC 7234
+// section 4.2, rather than the TTL set with [Handler.TTL].
+//
+// The lifetime is taken from "Cache-Control: s-maxage" or "max-age", then "Expires", and finally heuristically from
+// "Last-Modified". Responses without any of these are cached with the TTL. The time a response has already spent in
+// upstream caches, from its "Date" and "Age" headers and the time taken to fetch it, is deducted from its lifetime,
+// so that chaining the proxy behind other caches or CDNs does not extend it.
+//
+// Responses that are already stale, or that forbid shared caching with "Cache-Control: no-store", "no-cache" or
+// "private", are streamed without caching.
+func (h *Handler) HTTPFreshness() *Handler {
+	h.httpFreshness = true
+	return h
+}
+
+// storedResponse returns the headers and TTL with which resp, requested from upstream at requested and received at
+// received, is cached. It returns false if the response must not be cached.
+func (h *Handler) storedResponse(r *http.Request, resp *http.Response, requested, received time.Time) (http.Header, time.Duration, bool) {
+	headers := maps.Clone(resp.Header)
+	if !h.httpFreshness {
+		return headers, h.ttlFunc(r), true
+	}
+	fetched, _ := received.MarshalText() //nolint:errcheck // Only years outside [0,9999] fail to marshal.
+	headers.Set(fetchedHeader, string(fetched))
+	directives := cacheControl(resp.Header)
+	for _, directive := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := directives[directive]; ok {
+			return nil, 0, false
+		}
+	}
+	lifetime, ok := freshnessLifetime(resp.Header, directives, received)
+	if !ok {
+		return headers, h.ttlFunc(r), true
+	}
+	age := initialAge(resp.Header, requested, received)
+	if lifetime <= age {
+		return nil, 0, false
+	}
+	headers.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	return headers, lifetime - age, true
+}
+
+// freshnessLifetime returns the freshness lifetime of a response as described by RFC 7234 section 4.2.1, or false if
+// its headers do not give one.
+func freshnessLifetime(header http.Header, directives map[string]string, received time.Time) (time.Duration, bool) {
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if value, ok := directives[directive]; ok {
+			if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds >= 0 {
+				return time.Duration(seconds) * time.Second, true
+			}
+			// An invalid max-age makes the response stale.
+			return 0, true
+		}
+	}
+	date := responseDate(header, received)
+	if expires := header.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			// An invalid Expires, eg. "0", represents a time in the past.
+			return 0, true
+		}
+		return max(0, expiresAt.Sub(date)), true
+	}
+	if lastModified, err := http.ParseTime(header.Get("Last-Modified")); err == nil && date.After(lastModified) {
+		return date.Sub(lastModified) / heuristicFraction, true
+	}
+	return 0, false
+}
+
+// initialAge returns the age of a response when it was received, as described by RFC 7234 section 4.2.3.
+func initialAge(header http.Header, requested, received time.Time) time.Duration {
+	apparentAge := max(0, received.Sub(responseDate(header, received)))
+	var ageValue time.Duration
+	if seconds, err := strconv.ParseInt(header.Get("Age"), 10, 64); err == nil && seconds > 0 {
+		ageValue = time.Duration(seconds) * time.Second
+	}
+	responseDelay := max(0, received.Sub(requested))
+	return max(apparentAge, ageValue+responseDelay)
+}
+
+// responseDate returns the time a response was generated from its Date header, defaulting to when it was received.
+func responseDate(header http.Header, received time.Time) time.Time {
+	if date, err := http.ParseTime(header.Get("Date")); err == nil {
+		return date
+	}
+	return received
+}
+
+// cacheControl parses the directives of the Cache-Control headers, mapping each lowercased directive to its
+// unquoted argument.
+func cacheControl(header http.Header) map[string]string {
+	directives := map[string]string{}
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
+
+// setAge replaces the fetched header of a cached response with its "Age": its age when it was received from
+// upstream plus the time since then.
+func setAge(header http.Header, now time.Time) {
+	value := header.Get(fetchedHeader)
+	if value == "" {
+		return
+	}
+	header.Del(fetchedHeader)
+	var fetched time.Time
+	if err := fetched.UnmarshalText([]byte(value)); err != nil {
+		return
+	}
+	var age time.Duration
+	if seconds, err := strconv.ParseInt(header.Get("Age"), 10, 64); err == nil && seconds > 0 {
+		age = time.Duration(seconds) * time.Second
+	}
+	age += max(0, now.Sub(fetched))
+	header.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+}
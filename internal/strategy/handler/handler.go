@@ -1,20 +1,45 @@
 package handler
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
 	"io"
 	"log/slog"
 	"maps"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/alecthomas/errors"
+	"go.opentelemetry.io/otel/metric"
 
 	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/events"
 	"github.com/block/cachew/internal/httputil"
 	"github.com/block/cachew/internal/logging"
 )
 
+// Values of the X-Cache response header, describing how a response was produced.
+const (
+	CacheHit     = "HIT"     // Served from the cache.
+	CacheMiss    = "MISS"    // Fetched from upstream and cached.
+	CacheStale   = "STALE"   // Served expired from the cache because upstream failed.
+	CacheBypass  = "BYPASS"  // Fetched from upstream but not cached.
+	CacheWarming = "WARMING" // Being fetched from upstream and cached in the background.
+)
+
 // Handler provides a fluent API for creating cache-backed HTTP handlers.
 //
 // Example usage:
@@ -34,13 +59,40 @@ type Handler struct {
 	transformFunc func(*http.Request) (*http.Request, error)
 	errorHandler  func(error, http.ResponseWriter, *http.Request)
 	ttlFunc       func(*http.Request) time.Duration
+	staleIfError  time.Duration
+	cooldown      *hostCooldown
+	contentTypes  []string
+	extensions    []string
+	headers       http.Header
+	// cacheRedirected caches the final response of a followed redirect under the original key.
+	cacheRedirected bool
+	readThroughOnly bool
+	cacheSetCookie  bool
+	precompress     []string
+	// requireLength streams responses without a Content-Length without caching them.
+	requireLength bool
+	// observer is set in observe mode, in which cache is a shadowCache.
+	observer *observer
+	// keyIncludesBody adds the request method and a hash of the request body to cache keys.
+	keyIncludesBody bool
+	// warmer is set by WarmInBackground.
+	warmer *warmer
+	// credentialPolicy controls caching of requests carrying any of credentialHeaders.
+	credentialPolicy  CredentialPolicy
+	credentialHeaders []string
+	// httpFreshness caches responses for the freshness lifetime given by their headers.
+	httpFreshness bool
+	// failClosed fails requests when the cache cannot be read, rather than fetching them from upstream.
+	failClosed bool
+	requests   metric.Int64Counter
 }
 
 // New creates a new Handler with the given HTTP client and cache.
 // By default:
 // - Cache key is derived from the request URL
 // - No request transformation is performed
-// - Standard error handling is used.
+// - Standard error handling is used
+// - Upstream hosts responding 429/503 with Retry-After are not contacted again until it elapses.
 func New(client *http.Client, c cache.Cache) *Handler {
 	return &Handler{
 		client: client,
@@ -55,6 +107,9 @@ func New(client *http.Client, c cache.Cache) *Handler {
 		ttlFunc: func(_ *http.Request) time.Duration {
 			return 0
 		},
+		cooldown:        newHostCooldown(),
+		cacheRedirected: true,
+		requests:        newRequestCounter(),
 	}
 }
 
@@ -65,6 +120,34 @@ func (h *Handler) CacheKey(f func(*http.Request) string) *Handler {
 	return h
 }
 
+// maxKeyBodySize is the largest request body hashed into a cache key by [Handler.KeyIncludesBody].
+const maxKeyBodySize = 1 << 20
+
+// KeyIncludesBody adds the request method and a SHA-256 of the request body to the cache key, so that requests to
+// the same URL with different bodies, eg. GraphQL queries sent with POST, are cached separately from each other and
+// from GET requests.
+//
+// The body is buffered to be hashed and then replayed to upstream. Requests with bodies larger than 1MB are rejected
+// with "413 Content Too Large".
+func (h *Handler) KeyIncludesBody() *Handler {
+	h.keyIncludesBody = true
+	return h
+}
+
+// HashBody writes the body of r to h, replacing it so that it can still be read.
+func HashBody(r *http.Request, h hash.Hash) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return errors.Wrap(err, "read request body")
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	h.Write(body)
+	return nil
+}
+
 // Transform sets the function used to transform the incoming request before fetching.
 // This is where you can modify the request URL, headers, etc.
 // The function receives the original incoming request and should return the request
@@ -89,6 +172,211 @@ func (h *Handler) TTL(f func(*http.Request) time.Duration) *Handler {
 	return h
 }
 
+// StaleIfError enables serving expired cached objects when the upstream fetch fails.
+//
+// If an object has expired less than "window" ago and the upstream request errors or returns a 5xx, the stale
+// object is served with a "Warning: 111" header instead of the upstream error. Only caches implementing
+// [cache.StaleOpener] retain expired objects.
+func (h *Handler) StaleIfError(window time.Duration) *Handler {
+	h.staleIfError = window
+	return h
+}
+
+// AllowContentTypes restricts caching to responses with one of the given media types.
+//
+// Types may be exact, eg. "application/zip", or a wildcard subtype, eg. "text/*". Responses that are not allowed
+// by either [Handler.AllowContentTypes] or [Handler.AllowExtensions] are streamed to the client but not cached. If
+// neither is set, all responses are cached.
+func (h *Handler) AllowContentTypes(types ...string) *Handler {
+	h.contentTypes = types
+	return h
+}
+
+// Precompress additionally stores a gzip-compressed variant of cached responses with one of the given media types,
+// which are matched as with [Handler.AllowContentTypes].
+//
+// Clients accepting gzip are then served the stored variant with "Content-Encoding: gzip" rather than the identity
+// body, without compressing it on each request. Responses that are already encoded are not precompressed, nor are
+// those with an already compressed media type, eg. "application/zip", or whose first 64KiB do not compress by at
+// least 10%. Clients are served the identity body of objects without a variant, and cached responses only carry
+// "Vary: Accept-Encoding" while a variant is stored.
+func (h *Handler) Precompress(types ...string) *Handler {
+	h.precompress = types
+	return h
+}
+
+// AllowExtensions restricts caching to requests whose URL path has one of the given file extensions, eg. ".zip".
+//
+// See [Handler.AllowContentTypes] for how the two allowlists combine.
+func (h *Handler) AllowExtensions(extensions ...string) *Handler {
+	h.extensions = extensions
+	return h
+}
+
+// UpstreamHeaders sets static headers to add to every upstream request, eg. a mirror key or User-Agent.
+//
+// Headers already set by the [Handler.Transform] function, such as passed-through credentials, take precedence.
+func (h *Handler) UpstreamHeaders(headers map[string]string) *Handler {
+	h.headers = make(http.Header, len(headers))
+	for name, value := range headers {
+		h.headers.Set(name, value)
+	}
+	return h
+}
+
+// WarmInBackground responds to cache misses for responses of at least minSize bytes with "202 Accepted" and a
+// Retry-After of retryAfter, and caches the response in the background, so that clients poll for large objects
+// rather than holding a connection open for the whole upstream transfer.
+//
+// Further requests for an object while it is being warmed are also answered with 202, without contacting upstream.
+// Responses of unknown length, and those that would not be cached, are streamed as usual. Warmed objects are not
+// precompressed. The objects being warmed are abandoned by [Handler.Close], which must be called once the handler is
+// no longer served.
+//
+// Git bundles and snapshots are not warmed, as they are not fetched from upstream on a miss: they are generated by the
+// git strategy's scheduled jobs, and a request for one that has not been generated yet is answered with 404.
+func (h *Handler) WarmInBackground(minSize int64, retryAfter time.Duration) *Handler {
+	h.warmer = newWarmer(minSize, retryAfter)
+	return h
+}
+
+// Close abandons the objects being cached in the background by [Handler.WarmInBackground], waiting for their
+// goroutines to exit. Partially written entries are discarded.
+func (h *Handler) Close() error {
+	if h.warmer != nil {
+		h.warmer.close()
+	}
+	return nil
+}
+
+// CredentialPolicy controls how a [Handler] caches requests carrying credentials, for upstreams whose responses may
+// differ between users.
+type CredentialPolicy int
+
+const (
+	// CredentialsShared shares responses between all clients, regardless of their credentials.
+	CredentialsShared CredentialPolicy = iota
+	// CredentialsPerUser caches responses separately for each distinct set of credentials.
+	CredentialsPerUser
+	// CredentialsBypass fetches requests carrying credentials from upstream without caching them.
+	CredentialsBypass
+)
+
+// Credentials sets how requests carrying any of the given headers, eg. "Authorization", are cached.
+//
+// By default responses are shared, which is only safe if upstream serves the same content to every user that it
+// authorizes, or if credentials are not forwarded to upstream at all.
+func (h *Handler) Credentials(policy CredentialPolicy, headers ...string) *Handler {
+	h.credentialPolicy = policy
+	h.credentialHeaders = headers
+	return h
+}
+
+// credentials returns a hash of the credentials carried by r, or "" if it carries none.
+func (h *Handler) credentials(r *http.Request) string {
+	digest := sha256.New()
+	found := false
+	for _, name := range h.credentialHeaders {
+		for _, value := range r.Header.Values(name) {
+			found = true
+			_, _ = fmt.Fprintf(digest, "%s: %s\n", http.CanonicalHeaderKey(name), value)
+		}
+	}
+	if !found {
+		return ""
+	}
+	return hex.EncodeToString(digest.Sum(nil))
+}
+
+// ReadThroughOnly disables caching entirely, for upstreams whose responses must never be persisted.
+//
+// Requests are still transformed and fetched from upstream with the configured headers, redirect policy and
+// backoff, but the response is only streamed to the client with "X-Cache: BYPASS". The cache is never read or
+// written, so [Handler.StaleIfError] has no effect.
+func (h *Handler) ReadThroughOnly() *Handler {
+	h.readThroughOnly = true
+	return h
+}
+
+// Observe enables a dry-run mode for validating cache keys and TTLs against real traffic.
+//
+// Requests are always fetched from upstream and the cache is never read or written. Instead, whether each request
+// would have been a hit or a miss is logged with its key and TTL, and counted in the
+// "cachew.handler.observed_decisions" metric. The objects that would have been cached are tracked in shadow, which
+// stores their headers and TTLs but not their bodies.
+func (h *Handler) Observe(shadow cache.Cache) *Handler {
+	h.cache = shadowCache{shadow}
+	h.observer = newObserver(shadow)
+	return h
+}
+
+// AllowSetCookie permits caching responses that set cookies.
+//
+// By default a response carrying Set-Cookie is streamed to the client without caching, as the cookie is usually
+// specific to the client that triggered the fetch and must not be replayed to others. Only enable this for upstreams
+// whose cookies are known to be safe to share.
+func (h *Handler) AllowSetCookie() *Handler {
+	h.cacheSetCookie = true
+	return h
+}
+
+// RequireContentLength streams responses without a Content-Length, eg. chunked responses, without caching them.
+//
+// By default such responses are cached once their body has been completely received, as signalled by the final
+// chunk of a chunked response or the end of an HTTP/2 stream, and replayed with the Content-Length recorded by the
+// cache. Responses delimited only by the upstream closing the connection are never cached, as a truncated body
+// cannot be distinguished from a complete one.
+func (h *Handler) RequireContentLength() *Handler {
+	h.requireLength = true
+	return h
+}
+
+// FailClosed fails requests with "500 Internal Server Error" when the cache returns an error other than a miss.
+//
+// By default such errors are logged and the request is fetched from upstream as if it were a miss, so that a failing
+// cache backend degrades to a plain proxy rather than an outage. Errors creating cache entries always stream the
+// response to the client without caching it.
+func (h *Handler) FailClosed() *Handler {
+	h.failClosed = true
+	return h
+}
+
+// RedirectPolicy controls how a [Handler] follows upstream redirects.
+type RedirectPolicy struct {
+	// Max is the number of redirects to follow, after which the redirect itself is relayed to the client.
+	Max int
+	// AllowedHosts that a redirect may lead to in addition to the upstream host. If empty, any host is allowed.
+	AllowedHosts []string
+	// Cache the final response of a followed redirect under the key of the original request.
+	Cache bool
+}
+
+// Redirects sets the policy for following upstream redirects.
+//
+// Redirects that revisit a URL, or lead to a host that is not allowed, fail the request with "502 Bad Gateway". If
+// not set, the client's own policy applies and followed redirects are cached.
+func (h *Handler) Redirects(policy RedirectPolicy) *Handler {
+	client := *h.client
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > policy.Max {
+			return http.ErrUseLastResponse
+		}
+		for _, prev := range via {
+			if prev.URL.String() == req.URL.String() {
+				return errors.Errorf("redirect loop at %s", req.URL)
+			}
+		}
+		if len(policy.AllowedHosts) > 0 && req.URL.Host != via[0].URL.Host &&
+			!slices.Contains(policy.AllowedHosts, req.URL.Hostname()) {
+			return errors.Errorf("redirect to disallowed host %s", req.URL.Hostname())
+		}
+		return nil
+	}
+	h.client = &client
+	h.cacheRedirected = policy.Cache
+	return h
+}
+
 // ServeHTTP implements http.Handler.
 // The handler will:
 // 1. Determine the cache key using the configured function
@@ -96,15 +384,63 @@ func (h *Handler) TTL(f func(*http.Request) time.Duration) *Handler {
 // 3. If cached, stream from cache
 // 4. If not cached, transform the request and fetch from upstream
 // 5. Cache the response while streaming to the client.
+//
+// Range requests for cached objects are served with "206 Partial Content" when the cache implements
+// [cache.RangeOpener], with a "multipart/byteranges" body if multiple ranges are requested.
+//
+// Cached objects that fail their integrity check when opened are evicted and fetched again from upstream, so that
+// clients receive the correct bytes rather than an error. Caches that verify objects as they are streamed, eg. with
+// [cache.DiskConfig.VerifyOnRead], can only report corruption after the response has started, so that response fails
+// and the object is fetched again by later requests.
+//
+// Responses carry an "X-Cache" header of [CacheHit], [CacheMiss], [CacheStale], [CacheBypass] or [CacheWarming]. When
+// debug logging is enabled the hashed cache key is also returned in "X-Cache-Key".
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	logger := logging.FromContext(r.Context())
 
+	if h.credentialPolicy == CredentialsBypass && h.credentials(r) != "" {
+		// The copy shares the state of h, such as upstream cooldowns, and only disables caching.
+		bypass := *h
+		bypass.readThroughOnly = true
+		bypass.credentialPolicy = CredentialsShared
+		bypass.ServeHTTP(w, r)
+		return
+	}
+
 	cacheKeyStr := h.cacheKeyFunc(r)
+	defer h.recordRequest(r.Context(), w, cacheKeyStr)
+	if h.keyIncludesBody {
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, maxKeyBodySize)
+		}
+		digest := sha256.New()
+		if err := HashBody(r, digest); err != nil {
+			if _, ok := errors.AsType[*http.MaxBytesError](err); ok {
+				h.errorHandler(httputil.Errorf(http.StatusRequestEntityTooLarge, "request body is too large to cache"), w, r)
+				return
+			}
+			h.errorHandler(httputil.Errorf(http.StatusBadRequest, "%w", err), w, r)
+			return
+		}
+		cacheKeyStr = r.Method + " " + cacheKeyStr + " " + hex.EncodeToString(digest.Sum(nil))
+	}
+	if h.credentialPolicy == CredentialsPerUser {
+		if credentials := h.credentials(r); credentials != "" {
+			cacheKeyStr += " credentials:" + credentials
+		}
+	}
 	key := cache.NewKey(cacheKeyStr)
 
 	logger.DebugContext(r.Context(), "Processing request", slog.String("cache_key", cacheKeyStr))
 
-	if h.serveCached(w, r, key, logger) {
+	if h.observer != nil {
+		h.observer.observe(r.Context(), key, h.ttlFunc(r), logger)
+	} else if !h.readThroughOnly && h.serveCached(w, r, key, logger) {
+		return
+	}
+
+	if h.warming(key) {
+		h.respondWarming(w, r, key, logger)
 		return
 	}
 
@@ -112,23 +448,257 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) serveCached(w http.ResponseWriter, r *http.Request, key cache.Key, logger *slog.Logger) bool {
+	if ro, ok := h.cache.(cache.RangeOpener); ok {
+		// If-Range validation is not supported, so conditional range requests always receive the full object.
+		if ranges, ok := cache.ParseRanges(r.Header.Get("Range")); ok && r.Header.Get("If-Range") == "" {
+			switch {
+			case len(ranges) == 1:
+				return h.serveCachedRange(w, r, key, ro, ranges[0], logger)
+			case len(ranges) <= maxRanges:
+				return h.serveCachedRanges(w, r, key, ro, ranges, logger)
+			}
+		}
+	}
+
 	cr, headers, err := h.cache.Open(r.Context(), key)
 	if err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
+		if !h.missing(r.Context(), key, err, logger) {
 			h.errorHandler(httputil.Errorf(http.StatusInternalServerError, "failed to open cache: %w", err), w, r)
 			return true
 		}
 		return false
 	}
 
+	if len(h.precompress) > 0 {
+		// The variant is only served while the identity object exists, so that it is never served after the
+		// object is deleted.
+		if acceptsEncoding(r.Header.Get("Accept-Encoding"), "gzip") {
+			if vr, vheaders, err := h.cache.Open(r.Context(), key.Variant("gzip")); err == nil {
+				_ = cr.Close()
+				logger.DebugContext(r.Context(), "Cache hit", slog.String("encoding", "gzip"))
+				h.streamCached(w, r, key, vr, vheaders, http.StatusOK, CacheHit, logger)
+				return true
+			}
+		} else if exists, err := h.cache.Has(r.Context(), key.Variant("gzip")); err == nil && exists {
+			headers = headers.Clone()
+			headers.Add("Vary", "Accept-Encoding")
+		}
+	}
+
 	logger.DebugContext(r.Context(), "Cache hit")
+	h.streamCached(w, r, key, cr, headers, http.StatusOK, CacheHit, logger)
+	return true
+}
+
+// missing returns true if err, from opening key, means the object should be fetched from upstream.
+//
+// This heals objects that fail their integrity check: a corrupt object is evicted, in case the cache has not already
+// done so, and fetched and cached again rather than failing the request. Other errors are treated as misses unless
+// the handler fails closed, or the request has been cancelled.
+func (h *Handler) missing(ctx context.Context, key cache.Key, err error, logger *slog.Logger) bool {
+	if errors.Is(err, cache.ErrCorrupt) {
+		logger.WarnContext(ctx, "Re-fetching corrupt cache entry", slog.String("error", err.Error()))
+		if err := h.cache.Delete(ctx, key); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.ErrorContext(ctx, "Failed to evict corrupt cache entry", slog.String("error", err.Error()))
+		}
+		h.deleteGzipVariant(ctx, key, logger)
+		return true
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return true
+	}
+	if h.failClosed || ctx.Err() != nil {
+		return false
+	}
+	logger.ErrorContext(ctx, "Failed to open cache, fetching from upstream", slog.String("error", err.Error()))
+	return true
+}
+
+// acceptsEncoding returns true if an "Accept-Encoding" header allows the given content coding.
+func acceptsEncoding(header, encoding string) bool {
+	accepted := false
+	for _, spec := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(spec), ";")
+		coding = strings.TrimSpace(coding)
+		if !strings.EqualFold(coding, encoding) && coding != "*" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		// An explicit coding takes precedence over the wildcard.
+		if coding != "*" {
+			return q > 0
+		}
+		accepted = q > 0
+	}
+	return accepted
+}
+
+// serveCachedRange serves part of a cached object with "206 Partial Content", reading only the requested bytes
+// from the cache.
+func (h *Handler) serveCachedRange(w http.ResponseWriter, r *http.Request, key cache.Key, ro cache.RangeOpener, rng cache.Range, logger *slog.Logger) bool {
+	cr, headers, err := ro.OpenRange(r.Context(), key, rng)
+	if rangeErr, ok := errors.AsType[*cache.RangeNotSatisfiableError](err); ok {
+		h.setCacheHeaders(w, r, key, CacheHit, logger)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", rangeErr.Size))
+		http.Error(w, "Range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return true
+	} else if err != nil {
+		if !h.missing(r.Context(), key, err, logger) {
+			h.errorHandler(httputil.Errorf(http.StatusInternalServerError, "failed to open cache: %w", err), w, r)
+			return true
+		}
+		return false
+	}
+
+	logger.DebugContext(r.Context(), "Cache hit", slog.String("range", headers.Get("Content-Range")))
+	h.streamCached(w, r, key, cr, headers, http.StatusPartialContent, CacheHit, logger)
+	return true
+}
+
+// maxRanges is the most ranges served from a single request. Requests for more receive the full object.
+const maxRanges = 16
+
+// serveCachedRanges serves multiple parts of a cached object with "206 Partial Content" and a "multipart/byteranges"
+// body. Unsatisfiable ranges are omitted, and only if none can be satisfied is the request rejected.
+func (h *Handler) serveCachedRanges(w http.ResponseWriter, r *http.Request, key cache.Key, ro cache.RangeOpener, ranges []cache.Range, logger *slog.Logger) bool {
+	type part struct {
+		reader  io.ReadCloser
+		headers http.Header
+	}
+	parts := make([]part, 0, len(ranges))
+	defer func() {
+		for _, p := range parts {
+			_ = p.reader.Close()
+		}
+	}()
+	var size int64
+	for _, rng := range ranges {
+		cr, headers, err := ro.OpenRange(r.Context(), key, rng)
+		if rangeErr, ok := errors.AsType[*cache.RangeNotSatisfiableError](err); ok {
+			size = rangeErr.Size
+			continue
+		} else if err != nil {
+			if !h.missing(r.Context(), key, err, logger) {
+				h.errorHandler(httputil.Errorf(http.StatusInternalServerError, "failed to open cache: %w", err), w, r)
+				return true
+			}
+			return false
+		}
+		parts = append(parts, part{reader: cr, headers: headers})
+	}
+	if len(parts) == 0 {
+		h.setCacheHeaders(w, r, key, CacheHit, logger)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, "Range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return true
+	}
+
+	contentType := parts[0].headers.Get("Content-Type")
+	partHeader := func(p part) textproto.MIMEHeader {
+		header := textproto.MIMEHeader{}
+		if contentType != "" {
+			header.Set("Content-Type", contentType)
+		}
+		header.Set("Content-Range", p.headers.Get("Content-Range"))
+		return header
+	}
+
+	// The length of the body is that of the parts plus their framing, which is measured by writing it without the
+	// parts.
+	mw := multipart.NewWriter(w)
+	framing := &countingWriter{}
+	fw := multipart.NewWriter(framing)
+	_ = fw.SetBoundary(mw.Boundary())
+	var length int64
+	for _, p := range parts {
+		_, _ = fw.CreatePart(partHeader(p))
+		n, _ := strconv.ParseInt(p.headers.Get("Content-Length"), 10, 64)
+		length += n
+	}
+	_ = fw.Close()
+
+	maps.Copy(w.Header(), parts[0].headers)
+	w.Header().Del("Content-Range")
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.Header().Set("Content-Length", strconv.FormatInt(length+framing.n, 10))
+	w.Header().Set("Accept-Ranges", "bytes")
+	h.setCacheHeaders(w, r, key, CacheHit, logger)
+	logger.DebugContext(r.Context(), "Cache hit", slog.Int("ranges", len(parts)))
+	w.WriteHeader(http.StatusPartialContent)
+	for _, p := range parts {
+		pw, err := mw.CreatePart(partHeader(p))
+		if err == nil {
+			_, err = io.Copy(pw, p.reader)
+		}
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to stream from cache", slog.String("error", err.Error()))
+			return true
+		}
+	}
+	if err := mw.Close(); err != nil {
+		logger.ErrorContext(r.Context(), "Failed to stream from cache", slog.String("error", err.Error()))
+	}
+	return true
+}
+
+// countingWriter discards what is written to it, counting the bytes.
+type countingWriter struct{ n int64 }
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// serveStale serves an expired object from the cache if stale-if-error is enabled and one is available.
+func (h *Handler) serveStale(w http.ResponseWriter, r *http.Request, key cache.Key, logger *slog.Logger) bool {
+	if h.staleIfError <= 0 || h.readThroughOnly || h.observer != nil {
+		return false
+	}
+	cr, headers, err := cache.OpenStale(r.Context(), h.cache, key, h.staleIfError)
+	if err != nil {
+		return false
+	}
+	logger.WarnContext(r.Context(), "Upstream failed, serving stale object from cache")
+	w.Header().Set("Warning", `111 - "Revalidation Failed"`)
+	h.streamCached(w, r, key, cr, headers, http.StatusOK, CacheStale, logger)
+	return true
+}
+
+// setCacheHeaders reports how the response was produced, and with [Handler.HTTPFreshness] its Age if it was cached. It
+// must be called after any stored or upstream headers are copied to the response so that those from an upstream cache
+// are replaced.
+func (h *Handler) setCacheHeaders(w http.ResponseWriter, r *http.Request, key cache.Key, status string, logger *slog.Logger) {
+	w.Header().Set("X-Cache", status)
+	if h.httpFreshness {
+		setAge(w.Header(), time.Now())
+	} else {
+		// Entries cached while HTTPFreshness was enabled record when they were fetched.
+		w.Header().Del(fetchedHeader)
+	}
+	events.Publish(r.Context(), events.Cache, slog.String("status", status), slog.String("method", r.Method),
+		slog.String("url", r.URL.String()), slog.String("key", key.String()))
+	if logger.Enabled(r.Context(), slog.LevelDebug) {
+		w.Header().Set("X-Cache-Key", key.String())
+	}
+}
+
+func (h *Handler) streamCached(w http.ResponseWriter, r *http.Request, key cache.Key, cr io.ReadCloser, headers http.Header, code int, status string, logger *slog.Logger) {
 	defer cr.Close()
 	maps.Copy(w.Header(), headers)
+	if _, ok := h.cache.(cache.RangeOpener); ok {
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+	h.setCacheHeaders(w, r, key, status, logger)
+	w.WriteHeader(code)
 	if _, err := io.Copy(w, cr); err != nil {
 		logger.ErrorContext(r.Context(), "Failed to stream from cache", slog.String("error", err.Error()))
 		httputil.ErrorResponse(w, r, http.StatusInternalServerError, "Failed to stream from cache", "error", err.Error())
 	}
-	return true
 }
 
 func (h *Handler) fetchAndCache(w http.ResponseWriter, r *http.Request, key cache.Key, logger *slog.Logger) {
@@ -139,9 +709,34 @@ func (h *Handler) fetchAndCache(w http.ResponseWriter, r *http.Request, key cach
 		h.errorHandler(err, w, r)
 		return
 	}
+	for name, values := range h.headers {
+		if upstreamReq.Header.Get(name) == "" {
+			upstreamReq.Header[name] = values
+		}
+	}
+	logger.DebugContext(r.Context(), "Fetching from upstream",
+		slog.String("url", upstreamReq.URL.String()),
+		slog.Any("headers", httputil.RedactedHeaders(upstreamReq.Header)))
 
+	host := upstreamReq.URL.Host
+	if remaining := h.cooldown.remaining(host); remaining > 0 {
+		if h.serveStale(w, r, key, logger) {
+			return
+		}
+		w.Header().Set("Retry-After", formatRetryAfter(remaining))
+		h.errorHandler(httputil.Errorf(http.StatusServiceUnavailable, "upstream %s requested backoff", host), w, r)
+		return
+	}
+
+	upstreamReq, fetch := h.detachableRequest(r, upstreamReq)
+	defer fetch.release()
+
+	requested := time.Now()
 	resp, err := h.client.Do(upstreamReq)
 	if err != nil {
+		if h.serveStale(w, r, key, logger) {
+			return
+		}
 		h.errorHandler(httputil.Errorf(http.StatusBadGateway, "failed to fetch: %w", err), w, r)
 		return
 	}
@@ -151,39 +746,194 @@ func (h *Handler) fetchAndCache(w http.ResponseWriter, r *http.Request, key cach
 		}
 	}()
 
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			logger.WarnContext(r.Context(), "Upstream requested backoff", slog.String("host", host), slog.Duration("retry_after", d))
+			h.cooldown.set(host, d)
+		}
+	}
+
+	if resp.StatusCode >= http.StatusInternalServerError && h.serveStale(w, r, key, logger) {
+		return
+	}
+
 	if resp.StatusCode != http.StatusOK {
+		h.setCacheHeaders(w, r, key, CacheBypass, logger)
 		h.streamNonOKResponse(w, resp, logger)
 		return
 	}
 
-	h.streamAndCache(w, r, key, resp, logger)
+	if h.readThroughOnly {
+		h.streamUncached(w, r, key, resp, logger)
+		return
+	}
+
+	if !h.cacheRedirected && resp.Request.URL.String() != upstreamReq.URL.String() {
+		logger.DebugContext(r.Context(), "Response was redirected, streaming without caching",
+			slog.String("url", resp.Request.URL.String()))
+		h.streamUncached(w, r, key, resp, logger)
+		return
+	}
+
+	if !h.cacheable(r, resp) {
+		logger.DebugContext(r.Context(), "Response is not cacheable, streaming without caching",
+			slog.String("content_type", resp.Header.Get("Content-Type")))
+		h.streamUncached(w, r, key, resp, logger)
+		return
+	}
+
+	headers, ttl, ok := h.storedResponse(r, resp, requested, time.Now())
+	if !ok {
+		logger.DebugContext(r.Context(), "Response is stale or not cacheable by shared caches, streaming without caching",
+			slog.String("cache_control", resp.Header.Get("Cache-Control")))
+		h.streamUncached(w, r, key, resp, logger)
+		return
+	}
+
+	if h.shouldWarm(resp) && h.warmer.start(key) {
+		h.warmInBackground(w, r, key, resp, fetch.detach(), headers, ttl, logger)
+		return
+	}
+
+	h.streamAndCache(w, r, key, resp, headers, ttl, logger)
+}
+
+// warming returns true if key is being cached in the background by [Handler.WarmInBackground].
+func (h *Handler) warming(key cache.Key) bool {
+	return h.warmer != nil && h.observer == nil && h.warmer.isWarming(key)
+}
+
+// shouldWarm returns true if resp should be cached in the background by [Handler.WarmInBackground].
+func (h *Handler) shouldWarm(resp *http.Response) bool {
+	if h.warmer == nil || h.observer != nil || resp.ContentLength < h.warmer.minSize {
+		return false
+	}
+	return h.cacheSetCookie || len(resp.Header.Values("Set-Cookie")) == 0
+}
+
+func (h *Handler) streamUncached(w http.ResponseWriter, r *http.Request, key cache.Key, resp *http.Response, logger *slog.Logger) {
+	maps.Copy(w.Header(), resp.Header)
+	h.setCacheHeaders(w, r, key, CacheBypass, logger)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		logger.ErrorContext(r.Context(), "Failed to stream response", slog.String("error", err.Error()))
+	}
+}
+
+// cacheable returns true if the response is allowed by the content type and extension allowlists.
+func (h *Handler) cacheable(r *http.Request, resp *http.Response) bool {
+	if len(h.contentTypes) == 0 && len(h.extensions) == 0 {
+		return true
+	}
+	if ext := path.Ext(r.URL.Path); ext != "" && slices.ContainsFunc(h.extensions, func(allowed string) bool {
+		return strings.EqualFold(allowed, ext)
+	}) {
+		return true
+	}
+	return matchesContentType(h.contentTypes, resp.Header.Get("Content-Type"))
+}
+
+// matchesContentType returns true if the media type of a Content-Type header is one of types, which may include
+// wildcard subtypes, eg. "text/*".
+func matchesContentType(types []string, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(types, func(allowed string) bool {
+		allowed = strings.ToLower(allowed)
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok {
+			return strings.HasPrefix(mediaType, prefix+"/")
+		}
+		return mediaType == allowed
+	})
 }
 
 func (h *Handler) streamNonOKResponse(w http.ResponseWriter, resp *http.Response, logger *slog.Logger) {
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		w.Header().Set("Retry-After", retryAfter)
+	}
+	// Redirects that were not followed are relayed so that the client can follow them itself.
+	if location := resp.Header.Get("Location"); location != "" {
+		w.Header().Set("Location", location)
+	}
 	w.WriteHeader(resp.StatusCode)
 	if _, err := io.Copy(w, resp.Body); err != nil {
 		logger.ErrorContext(resp.Request.Context(), "Failed to stream error response", slog.String("error", err.Error()))
 	}
 }
 
-func (h *Handler) streamAndCache(w http.ResponseWriter, r *http.Request, key cache.Key, resp *http.Response, logger *slog.Logger) {
-	ttl := h.ttlFunc(r)
-	responseHeaders := maps.Clone(resp.Header)
-	cw, err := h.cache.Create(r.Context(), key, responseHeaders, ttl)
+// streamAndCache streams the response to the client while writing it to the cache with responseHeaders and ttl.
+//
+// Responses carrying Set-Cookie are streamed without caching unless [Handler.AllowSetCookie] was called, as are
+// responses of unknown length whose completion cannot be detected (see [Handler.RequireContentLength]).
+//
+// Truncated bodies, whether shorter than their Content-Length or missing the final chunk, fail the copy and the
+// entry is discarded.
+//
+// Failing to cache the response, eg. because the cache is out of space, does not fail the request. The partially
+// written entry is abandoned and the response continues to be streamed without caching.
+func (h *Handler) streamAndCache(w http.ResponseWriter, r *http.Request, key cache.Key, resp *http.Response, responseHeaders http.Header, ttl time.Duration, logger *slog.Logger) {
+	if !h.cacheSetCookie && len(resp.Header.Values("Set-Cookie")) > 0 {
+		logger.DebugContext(r.Context(), "Response sets cookies, streaming without caching")
+		h.streamUncached(w, r, key, resp, logger)
+		return
+	}
+	if resp.ContentLength < 0 && (h.requireLength || closeDelimited(resp)) {
+		logger.DebugContext(r.Context(), "Response length is unknown, streaming without caching")
+		h.streamUncached(w, r, key, resp, logger)
+		return
+	}
+	precompress := len(h.precompress) > 0 && resp.Header.Get("Content-Encoding") == "" &&
+		matchesContentType(h.precompress, resp.Header.Get("Content-Type"))
+	// Cancelling the context passed to Create discards the entry, along with its variant.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	cw, err := h.cache.Create(ctx, key, responseHeaders, ttl)
 	if err != nil {
-		h.errorHandler(httputil.Errorf(http.StatusInternalServerError, "failed to create cache entry: %w", err), w, r)
+		logger.WarnContext(r.Context(), "Failed to create cache entry, streaming without caching", slog.String("error", err.Error()))
+		h.streamUncached(w, r, key, resp, logger)
 		return
 	}
+	var variant *gzipVariant
+	if precompress {
+		variant = h.createGzipVariant(ctx, key, responseHeaders, ttl, logger)
+	}
 
 	pr, pw := io.Pipe()
 	go func() {
-		mw := io.MultiWriter(pw, cw)
-		_, copyErr := io.Copy(mw, resp.Body)
-		closeErr := errors.Join(cw.Close(), resp.Body.Close())
-		pw.CloseWithError(errors.Join(copyErr, closeErr))
+		cacheWriter := &abandonableWriter{w: cw, abandon: cancel}
+		writers := []io.Writer{pw, cacheWriter}
+		var variantWriter *abandonableWriter
+		if variant != nil {
+			variantWriter = &abandonableWriter{w: variant, abandon: cancel}
+			writers = append(writers, variantWriter)
+		}
+		_, copyErr := io.Copy(io.MultiWriter(writers...), resp.Body)
+		if copyErr != nil {
+			cancel()
+		}
+		var cacheErr error
+		if variant != nil {
+			// The variant is committed first, so that a failure to commit it discards the object too, and the
+			// variant is never older than the object.
+			if cacheErr = errors.Join(variantWriter.err, variant.Close()); cacheErr != nil {
+				cancel()
+			}
+		}
+		if len(h.precompress) > 0 && (variant == nil || variant.skipped) && ctx.Err() == nil {
+			// A variant of an earlier body must not be served alongside this one, eg. if only the identity
+			// object was evicted.
+			h.deleteGzipVariant(ctx, key, logger)
+		}
+		cacheErr = errors.Join(cacheErr, cacheWriter.err, cw.Close())
+		if copyErr == nil && cacheErr != nil {
+			logger.WarnContext(r.Context(), "Failed to cache response, streamed without caching", slog.String("error", cacheErr.Error()))
+		}
+		pw.CloseWithError(errors.Join(copyErr, resp.Body.Close()))
 	}()
 
-	maps.Copy(w.Header(), resp.Header)
+	maps.Copy(w.Header(), responseHeaders)
+	h.setCacheHeaders(w, r, key, CacheMiss, logger)
 	if _, err := io.Copy(w, pr); err != nil {
 		logger.ErrorContext(r.Context(), "Failed to stream response", slog.String("error", err.Error()))
 	}
@@ -192,6 +942,12 @@ func (h *Handler) streamAndCache(w http.ResponseWriter, r *http.Request, key cac
 	}
 }
 
+// closeDelimited returns true if the end of a response's body is only signalled by the upstream closing the
+// connection, so that a truncated body reads the same as a complete one.
+func closeDelimited(resp *http.Response) bool {
+	return resp.ProtoMajor < 2 && resp.ContentLength < 0 && !slices.Contains(resp.TransferEncoding, "chunked")
+}
+
 func defaultErrorHandler(err error, w http.ResponseWriter, r *http.Request) {
 	if h, ok := errors.AsType[httputil.HTTPResponder](err); ok {
 		h.WriteHTTP(w, r)
@@ -199,3 +955,139 @@ func defaultErrorHandler(err error, w http.ResponseWriter, r *http.Request) {
 		httputil.ErrorResponse(w, r, http.StatusInternalServerError, err.Error())
 	}
 }
+
+// compressedTypes are media types whose content is already compressed, so is not precompressed even if it matches
+// the types passed to [Handler.Precompress].
+var compressedTypes = []string{ //nolint:gochecknoglobals
+	"application/gzip", "application/x-gzip", "application/zip", "application/zstd", "application/x-xz",
+	"application/x-bzip2", "application/x-7z-compressed", "application/java-archive",
+	"image/png", "image/jpeg", "image/gif", "image/webp", "image/avif",
+	"audio/*", "video/*", "font/woff", "font/woff2",
+}
+
+const (
+	// variantSampleSize is how much of an object is compressed before deciding whether to keep its gzip variant.
+	// Smaller objects are always compressed.
+	variantSampleSize = 64 * 1024
+	// maxVariantRatio is the largest ratio of compressed to identity size of the sample for which the variant is kept.
+	maxVariantRatio = 0.9
+)
+
+// createGzipVariant creates the gzip-compressed variant of the object at key, returning nil if it cannot be created or
+// its content is already compressed.
+func (h *Handler) createGzipVariant(ctx context.Context, key cache.Key, headers http.Header, ttl time.Duration, logger *slog.Logger) *gzipVariant {
+	if matchesContentType(compressedTypes, headers.Get("Content-Type")) {
+		return nil
+	}
+	headers = maps.Clone(headers)
+	headers.Del("Content-Length")
+	headers.Set("Content-Encoding", "gzip")
+	headers.Add("Vary", "Accept-Encoding")
+	ctx, discard := context.WithCancel(ctx)
+	vw, err := h.cache.Create(ctx, key.Variant("gzip"), headers, ttl)
+	if err != nil {
+		discard()
+		logger.WarnContext(ctx, "Failed to create precompressed variant", slog.String("error", err.Error()))
+		return nil
+	}
+	counter := &countingWriter{}
+	return &gzipVariant{
+		gz:      gzip.NewWriter(io.MultiWriter(vw, counter)),
+		cw:      vw,
+		counter: counter,
+		discard: discard,
+		sample:  &bytes.Buffer{},
+		logger:  logger,
+		ctx:     ctx,
+	}
+}
+
+// deleteGzipVariant deletes the gzip variant of the object at key, if any.
+func (h *Handler) deleteGzipVariant(ctx context.Context, key cache.Key, logger *slog.Logger) {
+	if err := h.cache.Delete(ctx, key.Variant("gzip")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.WarnContext(ctx, "Failed to delete precompressed variant", slog.String("error", err.Error()))
+	}
+}
+
+// gzipVariant compresses an object into its gzip variant.
+//
+// The first [variantSampleSize] bytes are buffered and compressed before the rest of the object. If they do not
+// compress to at most [maxVariantRatio] of their size the content is assumed to be incompressible, and the variant is
+// discarded rather than compressing the rest of it.
+type gzipVariant struct {
+	gz      *gzip.Writer
+	cw      io.WriteCloser
+	counter *countingWriter
+	discard context.CancelFunc
+	sample  *bytes.Buffer // Nil once the sample has been compressed.
+	skipped bool
+	logger  *slog.Logger
+	ctx     context.Context
+}
+
+func (g *gzipVariant) Write(p []byte) (int, error) {
+	switch {
+	case g.skipped:
+		return len(p), nil
+	case g.sample != nil:
+		g.sample.Write(p)
+		if g.sample.Len() < variantSampleSize {
+			return len(p), nil
+		}
+		return len(p), g.compressSample()
+	default:
+		return errors.WithStack2(g.gz.Write(p))
+	}
+}
+
+// compressSample compresses the buffered sample, discarding the variant if it does not compress well enough.
+func (g *gzipVariant) compressSample() error {
+	sample := g.sample
+	g.sample = nil
+	if _, err := g.gz.Write(sample.Bytes()); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := g.gz.Flush(); err != nil {
+		return errors.WithStack(err)
+	}
+	if ratio := float64(g.counter.n) / float64(sample.Len()); ratio > maxVariantRatio {
+		g.logger.DebugContext(g.ctx, "Content is incompressible, discarding precompressed variant", slog.Float64("ratio", ratio))
+		g.skipped = true
+		g.discard()
+	}
+	return nil
+}
+
+func (g *gzipVariant) Close() error {
+	defer g.discard()
+	if g.sample != nil {
+		// Objects smaller than the sample are always compressed.
+		if _, err := g.gz.Write(g.sample.Bytes()); err != nil {
+			return errors.Join(errors.WithStack(err), g.cw.Close())
+		}
+	}
+	if g.skipped {
+		_ = g.cw.Close() //nolint:errcheck // The variant was discarded, which Close reports as an error.
+		return nil
+	}
+	return errors.Join(g.gz.Close(), g.cw.Close())
+}
+
+// abandonableWriter writes to a cache entry until the first error, after which the entry is abandoned and subsequent
+// writes are discarded so the response can still be streamed to the client.
+type abandonableWriter struct {
+	w       io.Writer
+	abandon context.CancelFunc
+	err     error
+}
+
+func (a *abandonableWriter) Write(p []byte) (int, error) {
+	if a.err != nil {
+		return len(p), nil
+	}
+	if _, err := a.w.Write(p); err != nil {
+		a.err = errors.WithStack(err)
+		a.abandon()
+	}
+	return len(p), nil
+}
@@ -1,21 +1,37 @@
 package handler_test
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/rand"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/alecthomas/assert/v2"
 	"github.com/alecthomas/errors"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 
 	"github.com/block/cachew/internal/cache"
 	"github.com/block/cachew/internal/httputil"
 	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/metrics"
 	"github.com/block/cachew/internal/strategy/handler"
 )
 
@@ -25,6 +41,7 @@ type testRequest struct {
 	expectStatus   int
 	expectBody     string
 	expectContains string
+	expectXCache   string
 }
 
 func TestBuilder(t *testing.T) {
@@ -86,8 +103,8 @@ func TestBuilder(t *testing.T) {
 					})
 			},
 			requests: []testRequest{
-				{url: "/test", expectStatus: http.StatusOK, expectBody: "simple response"},
-				{url: "/test", expectStatus: http.StatusOK, expectBody: "simple response"},
+				{url: "/test", expectStatus: http.StatusOK, expectBody: "simple response", expectXCache: handler.CacheMiss},
+				{url: "/test", expectStatus: http.StatusOK, expectBody: "simple response", expectXCache: handler.CacheHit},
 			},
 			expectUpstreamCalls: map[string]int{"/simple": 1},
 		},
@@ -189,7 +206,7 @@ func TestBuilder(t *testing.T) {
 					})
 			},
 			requests: []testRequest{
-				{url: "/test", expectStatus: http.StatusNotFound, expectBody: "not found"},
+				{url: "/test", expectStatus: http.StatusNotFound, expectBody: "not found", expectXCache: handler.CacheBypass},
 			},
 			expectUpstreamCalls: map[string]int{"/not-found": 1},
 		},
@@ -243,6 +260,52 @@ func TestBuilder(t *testing.T) {
 			},
 			expectUpstreamCalls: map[string]int{"/simple": 1},
 		},
+		{
+			name: "AllowedContentType",
+			buildHandler: func(c cache.Cache) http.Handler {
+				return handler.New(http.DefaultClient, c).
+					AllowContentTypes("text/*").
+					Transform(func(r *http.Request) (*http.Request, error) {
+						return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL+"/simple", nil)
+					})
+			},
+			requests: []testRequest{
+				{url: "/test", expectStatus: http.StatusOK, expectBody: "simple response"},
+				{url: "/test", expectStatus: http.StatusOK, expectBody: "simple response"},
+			},
+			expectUpstreamCalls: map[string]int{"/simple": 1},
+		},
+		{
+			name: "DisallowedContentType",
+			buildHandler: func(c cache.Cache) http.Handler {
+				return handler.New(http.DefaultClient, c).
+					AllowContentTypes("text/*").
+					Transform(func(r *http.Request) (*http.Request, error) {
+						return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL+"/stream", nil)
+					})
+			},
+			requests: []testRequest{
+				{url: "/test", expectStatus: http.StatusOK, expectContains: "chunk 99", expectXCache: handler.CacheBypass},
+				{url: "/test", expectStatus: http.StatusOK, expectContains: "chunk 99", expectXCache: handler.CacheBypass},
+			},
+			expectUpstreamCalls: map[string]int{"/stream": 2},
+		},
+		{
+			name: "AllowedExtension",
+			buildHandler: func(c cache.Cache) http.Handler {
+				return handler.New(http.DefaultClient, c).
+					AllowContentTypes("text/*").
+					AllowExtensions(".bin").
+					Transform(func(r *http.Request) (*http.Request, error) {
+						return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL+"/stream", nil)
+					})
+			},
+			requests: []testRequest{
+				{url: "/test.bin", expectStatus: http.StatusOK, expectContains: "chunk 99"},
+				{url: "/test.bin", expectStatus: http.StatusOK, expectContains: "chunk 99"},
+			},
+			expectUpstreamCalls: map[string]int{"/stream": 1},
+		},
 	}
 
 	for _, tt := range tests {
@@ -272,6 +335,9 @@ func TestBuilder(t *testing.T) {
 					assert.True(t, strings.Contains(w.Body.String(), req.expectContains),
 						"request %d: expected body to contain %q, got %q", i, req.expectContains, w.Body.String())
 				}
+				if req.expectXCache != "" {
+					assert.Equal(t, req.expectXCache, w.Header().Get("X-Cache"), "request %d X-Cache mismatch", i)
+				}
 			}
 
 			for path, expectedCount := range tt.expectUpstreamCalls {
@@ -305,3 +371,1278 @@ func mustNewMemoryCache() cache.Cache {
 	}
 	return c
 }
+
+func TestCacheKeyHeader(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(w, "response")
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name      string
+		level     slog.Level
+		expectKey bool
+	}{
+		{name: "Debug", level: slog.LevelDebug, expectKey: true},
+		{name: "Info", level: slog.LevelInfo},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := handler.New(http.DefaultClient, mustNewMemoryCache()).
+				Transform(func(r *http.Request) (*http.Request, error) {
+					return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+				})
+			logger := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: tt.level}))
+			ctx := logging.ContextWithLogger(context.Background(), logger)
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/test", nil))
+			assert.Equal(t, handler.CacheMiss, w.Header().Get("X-Cache"))
+			expected := ""
+			if tt.expectKey {
+				key := cache.NewKey("/test")
+				expected = key.String()
+			}
+			assert.Equal(t, expected, w.Header().Get("X-Cache-Key"))
+		})
+	}
+}
+
+func TestStaleIfError(t *testing.T) {
+	var upstreamDown atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if upstreamDown.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = fmt.Fprint(w, "fresh response")
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name         string
+		staleIfError time.Duration
+		expectStatus int
+		expectBody   string
+	}{
+		{name: "ServesStale", staleIfError: time.Hour, expectStatus: http.StatusOK, expectBody: "fresh response"},
+		{name: "Disabled", expectStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreamDown.Store(false)
+			h := handler.New(http.DefaultClient, mustNewMemoryCache()).
+				TTL(func(_ *http.Request) time.Duration { return 50 * time.Millisecond }).
+				StaleIfError(tt.staleIfError).
+				Transform(func(r *http.Request) (*http.Request, error) {
+					return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+				})
+			ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/test", nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			time.Sleep(100 * time.Millisecond)
+			upstreamDown.Store(true)
+
+			w = httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/test", nil))
+			assert.Equal(t, tt.expectStatus, w.Code)
+			if tt.expectBody != "" {
+				assert.Equal(t, tt.expectBody, w.Body.String())
+				assert.Equal(t, `111 - "Revalidation Failed"`, w.Header().Get("Warning"))
+				assert.Equal(t, handler.CacheStale, w.Header().Get("X-Cache"))
+			}
+		})
+	}
+}
+
+func TestRepairCorruptObject(t *testing.T) {
+	var fetches atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fetches.Add(1)
+		_, _ = fmt.Fprint(w, "correct bytes")
+	}))
+	defer upstream.Close()
+
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	root := t.TempDir()
+	c, err := cache.NewDisk(ctx, cache.DiskConfig{Root: root, MaxTTL: time.Hour, VerifyOnRead: true})
+	assert.NoError(t, err)
+	defer c.Close()
+	h := handler.New(http.DefaultClient, c).
+		CacheKey(func(_ *http.Request) string { return "object" }).
+		Transform(func(r *http.Request) (*http.Request, error) {
+			return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+		})
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/object", nil))
+		return w
+	}
+
+	w := serve()
+	assert.Equal(t, handler.CacheMiss, w.Header().Get("X-Cache"))
+	assert.Equal(t, int32(1), fetches.Load())
+
+	key := cache.NewKey("object")
+	path := filepath.Join(root, key.String()[:2], key.String())
+	assert.NoError(t, os.WriteFile(path, []byte("corrupt bytes"), 0o600))
+
+	// Corruption is only detected once the body has been streamed, so this response fails.
+	w = serve()
+	assert.Equal(t, handler.CacheHit, w.Header().Get("X-Cache"))
+	assert.NotEqual(t, "correct bytes", w.Body.String())
+	assert.Equal(t, int32(1), fetches.Load())
+
+	w = serve()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, handler.CacheMiss, w.Header().Get("X-Cache"))
+	assert.Equal(t, "correct bytes", w.Body.String())
+	assert.Equal(t, int32(2), fetches.Load(), "corrupt object should be fetched again")
+
+	w = serve()
+	assert.Equal(t, handler.CacheHit, w.Header().Get("X-Cache"))
+	assert.Equal(t, "correct bytes", w.Body.String())
+	assert.Equal(t, int32(2), fetches.Load(), "repaired object should be served from cache")
+}
+
+// ttlCache records the TTL objects are created with.
+type ttlCache struct {
+	cache.Cache
+	ttl     time.Duration
+	created bool
+}
+
+func (c *ttlCache) Create(ctx context.Context, key cache.Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	c.ttl = ttl
+	c.created = true
+	return errors.WithStack2(c.Cache.Create(ctx, key, headers, ttl))
+}
+
+func TestHTTPFreshness(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name        string
+		headers     map[string]string
+		disabled    bool
+		expectTTL   time.Duration
+		expectStale bool
+	}{
+		{name: "MaxAge", headers: map[string]string{"Cache-Control": "public, max-age=3600"}, expectTTL: time.Hour},
+		{name: "SharedMaxAge", headers: map[string]string{"Cache-Control": "max-age=60, s-maxage=600"}, expectTTL: 10 * time.Minute},
+		{name: "AgeDeducted", headers: map[string]string{"Cache-Control": "max-age=3600", "Age": "600"}, expectTTL: 50 * time.Minute},
+		{name: "DateDeducted", headers: map[string]string{"Cache-Control": "max-age=3600", "Date": now.Add(-20 * time.Minute).UTC().Format(http.TimeFormat)},
+			expectTTL: 40 * time.Minute},
+		{name: "Expires", headers: map[string]string{"Date": now.UTC().Format(http.TimeFormat), "Expires": now.Add(2 * time.Hour).UTC().Format(http.TimeFormat)},
+			expectTTL: 2 * time.Hour},
+		{name: "InvalidExpires", headers: map[string]string{"Expires": "0"}, expectStale: true},
+		{name: "Heuristic", headers: map[string]string{"Date": now.UTC().Format(http.TimeFormat), "Last-Modified": now.Add(-10 * time.Hour).UTC().Format(http.TimeFormat)},
+			expectTTL: time.Hour},
+		{name: "NoFreshnessInformation", expectTTL: 5 * time.Minute},
+		{name: "Stale", headers: map[string]string{"Cache-Control": "max-age=60", "Age": "120"}, expectStale: true},
+		{name: "NoStore", headers: map[string]string{"Cache-Control": "no-store"}, expectStale: true},
+		{name: "Private", headers: map[string]string{"Cache-Control": "private, max-age=3600"}, expectStale: true},
+		{name: "Disabled", headers: map[string]string{"Cache-Control": "max-age=60"}, disabled: true, expectTTL: 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				for name, value := range tt.headers {
+					w.Header().Set(name, value)
+				}
+				_, _ = fmt.Fprint(w, "response")
+			}))
+			defer upstream.Close()
+
+			c := &ttlCache{Cache: mustNewMemoryCache()}
+			h := handler.New(http.DefaultClient, c).
+				TTL(func(_ *http.Request) time.Duration { return 5 * time.Minute }).
+				Transform(func(r *http.Request) (*http.Request, error) {
+					return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+				})
+			if !tt.disabled {
+				h.HTTPFreshness()
+			}
+			ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/test", nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "response", w.Body.String())
+
+			if tt.expectStale {
+				assert.Equal(t, handler.CacheBypass, w.Header().Get("X-Cache"))
+				assert.False(t, c.created, "stale response should not be cached")
+				return
+			}
+			assert.Equal(t, handler.CacheMiss, w.Header().Get("X-Cache"))
+			assert.True(t, c.created)
+			assert.True(t, c.ttl > tt.expectTTL-2*time.Second && c.ttl <= tt.expectTTL,
+				"expected a TTL of %s, got %s", tt.expectTTL, c.ttl)
+		})
+	}
+}
+
+func TestAgeHeader(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("Age", "100")
+		_, _ = fmt.Fprint(w, "response")
+	}))
+	defer upstream.Close()
+
+	c := mustNewMemoryCache()
+	h := handler.New(http.DefaultClient, c).
+		HTTPFreshness().
+		Transform(func(r *http.Request) (*http.Request, error) {
+			return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+		})
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, path, nil))
+		return w
+	}
+
+	t.Run("Miss", func(t *testing.T) {
+		w := serve("/fetched")
+		assert.Equal(t, handler.CacheMiss, w.Header().Get("X-Cache"))
+		assert.Equal(t, "100", w.Header().Get("Age"))
+		assert.Equal(t, "", w.Header().Get("X-Cachew-Fetched"))
+	})
+
+	t.Run("Hit", func(t *testing.T) {
+		w := serve("/fetched")
+		assert.Equal(t, handler.CacheHit, w.Header().Get("X-Cache"))
+		assert.Equal(t, "100", w.Header().Get("Age"))
+		assert.Equal(t, "", w.Header().Get("X-Cachew-Fetched"))
+	})
+
+	t.Run("ResidentTimeAdded", func(t *testing.T) {
+		fetched, err := time.Now().Add(-time.Minute).MarshalText()
+		assert.NoError(t, err)
+		headers := http.Header{"Age": []string{"100"}, "X-Cachew-Fetched": []string{string(fetched)}}
+		cw, err := c.Create(ctx, cache.NewKey("/resident"), headers, time.Hour)
+		assert.NoError(t, err)
+		_, err = cw.Write([]byte("response"))
+		assert.NoError(t, err)
+		assert.NoError(t, cw.Close())
+
+		w := serve("/resident")
+		assert.Equal(t, handler.CacheHit, w.Header().Get("X-Cache"))
+		assert.Equal(t, "160", w.Header().Get("Age"))
+		assert.Equal(t, "", w.Header().Get("X-Cachew-Fetched"))
+	})
+
+	t.Run("WithoutHTTPFreshness", func(t *testing.T) {
+		c := mustNewMemoryCache()
+		h := handler.New(http.DefaultClient, c).
+			Transform(func(r *http.Request) (*http.Request, error) {
+				return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+			})
+		for _, status := range []string{handler.CacheMiss, handler.CacheHit} {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/plain", nil))
+			assert.Equal(t, status, w.Header().Get("X-Cache"))
+			assert.Equal(t, "100", w.Header().Get("Age"))
+		}
+		cr, headers, err := c.Open(ctx, cache.NewKey("/plain"))
+		assert.NoError(t, err)
+		assert.NoError(t, cr.Close())
+		assert.Equal(t, "", headers.Get("X-Cachew-Fetched"))
+	})
+}
+
+func TestRetryAfterCooldown(t *testing.T) {
+	var upstreamCalls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		upstreamCalls.Add(1)
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+
+	h := handler.New(http.DefaultClient, mustNewMemoryCache()).
+		Transform(func(r *http.Request) (*http.Request, error) {
+			return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+		})
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/test", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Retry-After"))
+	assert.Equal(t, int32(1), upstreamCalls.Load())
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/other", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Retry-After"))
+	assert.Equal(t, int32(1), upstreamCalls.Load(), "upstream should not be contacted during cooldown")
+}
+
+// fullCache simulates a cache whose filesystem has run out of space.
+type fullCache struct {
+	cache.Cache
+	failCreate bool
+}
+
+func (f *fullCache) Create(ctx context.Context, key cache.Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	if f.failCreate {
+		return nil, errors.WithStack(syscall.ENOSPC)
+	}
+	w, err := f.Cache.Create(ctx, key, headers, ttl)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &fullWriter{WriteCloser: w}, nil
+}
+
+type fullWriter struct {
+	io.WriteCloser
+	written int
+}
+
+func (f *fullWriter) Write(p []byte) (int, error) {
+	if f.written > 0 {
+		return 0, errors.WithStack(syscall.ENOSPC)
+	}
+	f.written += len(p)
+	return errors.WithStack2(f.WriteCloser.Write(p))
+}
+
+func TestCacheFullStreamsUncached(t *testing.T) {
+	body := strings.Repeat("x", 256*1024)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(w, body)
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name       string
+		failCreate bool
+	}{
+		{name: "CreateFails", failCreate: true},
+		{name: "WriteFails"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &fullCache{Cache: mustNewMemoryCache(), failCreate: tt.failCreate}
+			h := handler.New(http.DefaultClient, c).
+				Transform(func(r *http.Request) (*http.Request, error) {
+					return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+				})
+			_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/test", nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.True(t, w.Body.String() == body, "response body was truncated to %d bytes", w.Body.Len())
+
+			_, err := c.Stat(ctx, cache.NewKey("/test"))
+			assert.IsError(t, err, os.ErrNotExist)
+		})
+	}
+}
+
+// brokenCache simulates a cache backend whose storage is failing.
+type brokenCache struct {
+	cache.Cache
+}
+
+func (brokenCache) Open(context.Context, cache.Key) (io.ReadCloser, http.Header, error) {
+	return nil, nil, errors.WithStack(syscall.EIO)
+}
+
+func (brokenCache) Create(context.Context, cache.Key, http.Header, time.Duration) (io.WriteCloser, error) {
+	return nil, errors.WithStack(syscall.EIO)
+}
+
+func TestCacheErrorPolicy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(w, "upstream content")
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name          string
+		failClosed    bool
+		expectStatus  int
+		expectFetches int32
+	}{
+		{name: "FailOpen", expectStatus: http.StatusOK, expectFetches: 1},
+		{name: "FailClosed", failClosed: true, expectStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fetches atomic.Int32
+			h := handler.New(http.DefaultClient, brokenCache{Cache: mustNewMemoryCache()}).
+				Transform(func(r *http.Request) (*http.Request, error) {
+					fetches.Add(1)
+					return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+				})
+			if tt.failClosed {
+				h.FailClosed()
+			}
+			_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/test", nil))
+			assert.Equal(t, tt.expectStatus, w.Code)
+			assert.Equal(t, tt.expectFetches, fetches.Load())
+			if tt.expectStatus == http.StatusOK {
+				assert.Equal(t, "upstream content", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRangeRequests(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(w, "0123456789")
+	}))
+	defer upstream.Close()
+
+	caches := []struct {
+		name     string
+		newCache func(t *testing.T) cache.Cache
+	}{
+		{name: "Memory", newCache: func(*testing.T) cache.Cache { return mustNewMemoryCache() }},
+		{name: "Disk", newCache: func(t *testing.T) cache.Cache {
+			_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+			c, err := cache.NewDisk(ctx, cache.DiskConfig{Root: t.TempDir(), MaxTTL: time.Hour})
+			assert.NoError(t, err)
+			t.Cleanup(func() { _ = c.Close() })
+			return c
+		}},
+	}
+	for _, tc := range caches {
+		t.Run(tc.name, func(t *testing.T) {
+			h := handler.New(http.DefaultClient, tc.newCache(t)).
+				Transform(func(r *http.Request) (*http.Request, error) {
+					return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+				})
+			ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/test", nil))
+			assert.Equal(t, handler.CacheMiss, w.Header().Get("X-Cache"))
+
+			tests := []struct {
+				name                string
+				rangeHeader         string
+				expectStatus        int
+				expectBody          string
+				expectContentRange  string
+				expectContentLength string
+			}{
+				{name: "Bounded", rangeHeader: "bytes=2-5", expectStatus: http.StatusPartialContent, expectBody: "2345",
+					expectContentRange: "bytes 2-5/10", expectContentLength: "4"},
+				{name: "OpenEnded", rangeHeader: "bytes=7-", expectStatus: http.StatusPartialContent, expectBody: "789",
+					expectContentRange: "bytes 7-9/10", expectContentLength: "3"},
+				{name: "Suffix", rangeHeader: "bytes=-2", expectStatus: http.StatusPartialContent, expectBody: "89",
+					expectContentRange: "bytes 8-9/10", expectContentLength: "2"},
+				{name: "BeyondEOF", rangeHeader: "bytes=10-", expectStatus: http.StatusRequestedRangeNotSatisfiable,
+					expectBody: "Range not satisfiable\n", expectContentRange: "bytes */10"},
+				{name: "MalformedIgnored", rangeHeader: "bytes=5-2", expectStatus: http.StatusOK, expectBody: "0123456789"},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/test", nil)
+					req.Header.Set("Range", tt.rangeHeader)
+					w := httptest.NewRecorder()
+					h.ServeHTTP(w, req)
+					assert.Equal(t, tt.expectStatus, w.Code)
+					assert.Equal(t, tt.expectBody, w.Body.String())
+					assert.Equal(t, tt.expectContentRange, w.Header().Get("Content-Range"))
+					if tt.expectContentLength != "" {
+						assert.Equal(t, tt.expectContentLength, w.Header().Get("Content-Length"))
+					}
+					assert.Equal(t, handler.CacheHit, w.Header().Get("X-Cache"))
+				})
+			}
+		})
+	}
+}
+
+func TestMultiRangeRequests(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = fmt.Fprint(w, "0123456789")
+	}))
+	defer upstream.Close()
+
+	h := handler.New(http.DefaultClient, mustNewMemoryCache()).
+		Transform(func(r *http.Request) (*http.Request, error) {
+			return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+		})
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/test", nil))
+	assert.Equal(t, handler.CacheMiss, w.Header().Get("X-Cache"))
+
+	type part struct {
+		contentRange string
+		body         string
+	}
+	tests := []struct {
+		name         string
+		rangeHeader  string
+		expectStatus int
+		expectParts  []part
+	}{
+		{name: "TwoRanges", rangeHeader: "bytes=0-1,4-5", expectStatus: http.StatusPartialContent,
+			expectParts: []part{{"bytes 0-1/10", "01"}, {"bytes 4-5/10", "45"}}},
+		{name: "SuffixAndOpenEnded", rangeHeader: "bytes=-2, 3-", expectStatus: http.StatusPartialContent,
+			expectParts: []part{{"bytes 8-9/10", "89"}, {"bytes 3-9/10", "3456789"}}},
+		{name: "UnsatisfiableOmitted", rangeHeader: "bytes=2-3,20-", expectStatus: http.StatusPartialContent,
+			expectParts: []part{{"bytes 2-3/10", "23"}}},
+		{name: "AllUnsatisfiable", rangeHeader: "bytes=10-,20-30", expectStatus: http.StatusRequestedRangeNotSatisfiable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/test", nil)
+			req.Header.Set("Range", tt.rangeHeader)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			assert.Equal(t, tt.expectStatus, w.Code)
+			assert.Equal(t, handler.CacheHit, w.Header().Get("X-Cache"))
+			if tt.expectStatus != http.StatusPartialContent {
+				assert.Equal(t, "bytes */10", w.Header().Get("Content-Range"))
+				return
+			}
+			assert.Equal(t, strconv.Itoa(w.Body.Len()), w.Header().Get("Content-Length"))
+			mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+			assert.NoError(t, err)
+			assert.Equal(t, "multipart/byteranges", mediaType)
+
+			reader := multipart.NewReader(w.Body, params["boundary"])
+			var parts []part
+			for {
+				p, err := reader.NextPart()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				assert.NoError(t, err)
+				assert.Equal(t, "text/plain", p.Header.Get("Content-Type"))
+				body, err := io.ReadAll(p)
+				assert.NoError(t, err)
+				parts = append(parts, part{p.Header.Get("Content-Range"), string(body)})
+			}
+			assert.Equal(t, tt.expectParts, parts)
+		})
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	var cdnCalls atomic.Int32
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		cdnCalls.Add(1)
+		_, _ = fmt.Fprint(w, "artifact")
+	}))
+	defer cdn.Close()
+	var upstream *httptest.Server
+	upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/artifact":
+			http.Redirect(w, r, cdn.URL+"/artifact", http.StatusFound)
+		case "/hop":
+			http.Redirect(w, r, "/artifact", http.StatusMovedPermanently)
+		case "/loop":
+			http.Redirect(w, r, upstream.URL+"/loop", http.StatusFound)
+		}
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name           string
+		path           string
+		policy         handler.RedirectPolicy
+		expectStatus   int
+		expectBody     string
+		expectLocation string
+		expectCached   bool
+	}{
+		{name: "FollowedAndCached", path: "/artifact", policy: handler.RedirectPolicy{Max: 1, Cache: true},
+			expectStatus: http.StatusOK, expectBody: "artifact", expectCached: true},
+		{name: "FollowedNotCached", path: "/artifact", policy: handler.RedirectPolicy{Max: 1},
+			expectStatus: http.StatusOK, expectBody: "artifact"},
+		{name: "AllowedHost", path: "/artifact", policy: handler.RedirectPolicy{Max: 1, AllowedHosts: []string{"127.0.0.1"}, Cache: true},
+			expectStatus: http.StatusOK, expectBody: "artifact", expectCached: true},
+		{name: "DisallowedHost", path: "/artifact", policy: handler.RedirectPolicy{Max: 1, AllowedHosts: []string{"cdn.example.com"}, Cache: true},
+			expectStatus: http.StatusBadGateway},
+		{name: "MaxExceeded", path: "/hop", policy: handler.RedirectPolicy{Max: 1, Cache: true},
+			expectStatus: http.StatusFound, expectLocation: cdn.URL + "/artifact"},
+		{name: "Loop", path: "/loop", policy: handler.RedirectPolicy{Max: 5, Cache: true},
+			expectStatus: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := mustNewMemoryCache()
+			h := handler.New(http.DefaultClient, c).
+				Transform(func(r *http.Request) (*http.Request, error) {
+					return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL+r.URL.Path, nil)
+				}).
+				Redirects(tt.policy)
+			_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, tt.path, nil))
+			assert.Equal(t, tt.expectStatus, w.Code)
+			assert.Equal(t, tt.expectLocation, w.Header().Get("Location"))
+			if tt.expectBody != "" {
+				assert.Equal(t, tt.expectBody, w.Body.String())
+			}
+
+			// The final response is cached under the key of the original request.
+			_, err := c.Stat(ctx, cache.NewKey(tt.path))
+			if tt.expectCached {
+				assert.NoError(t, err)
+			} else {
+				assert.IsError(t, err, os.ErrNotExist)
+			}
+		})
+	}
+	assert.True(t, http.DefaultClient.CheckRedirect == nil, "policy must not modify the shared client")
+}
+
+// untouchableCache fails the test if it is read or written.
+type untouchableCache struct {
+	cache.Cache
+	t *testing.T
+}
+
+func (u *untouchableCache) Open(context.Context, cache.Key) (io.ReadCloser, http.Header, error) {
+	u.t.Error("cache must not be read")
+	return nil, nil, os.ErrNotExist
+}
+
+func (u *untouchableCache) Create(context.Context, cache.Key, http.Header, time.Duration) (io.WriteCloser, error) {
+	u.t.Error("cache must not be written")
+	return nil, errors.New("unexpected write")
+}
+
+func TestReadThroughOnly(t *testing.T) {
+	var upstreamCalls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		upstreamCalls.Add(1)
+		_, _ = fmt.Fprint(w, "sensitive")
+	}))
+	defer upstream.Close()
+
+	h := handler.New(http.DefaultClient, &untouchableCache{Cache: mustNewMemoryCache(), t: t}).
+		Transform(func(r *http.Request) (*http.Request, error) {
+			return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+		}).
+		StaleIfError(time.Hour).
+		ReadThroughOnly()
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+
+	for i := range 2 {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/test", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "sensitive", w.Body.String())
+		assert.Equal(t, handler.CacheBypass, w.Header().Get("X-Cache"))
+		assert.Equal(t, int32(i+1), upstreamCalls.Load())
+	}
+}
+
+func TestSetCookie(t *testing.T) {
+	tests := []struct {
+		name          string
+		allow         bool
+		expectedCache []string
+		expectedCalls int32
+	}{
+		{name: "BypassedByDefault", expectedCache: []string{handler.CacheBypass, handler.CacheBypass}, expectedCalls: 2},
+		{name: "CachedWhenAllowed", allow: true, expectedCache: []string{handler.CacheMiss, handler.CacheHit}, expectedCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var upstreamCalls atomic.Int32
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				upstreamCalls.Add(1)
+				http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+				_, _ = fmt.Fprint(w, "content")
+			}))
+			defer upstream.Close()
+
+			h := handler.New(http.DefaultClient, mustNewMemoryCache()).
+				Transform(func(r *http.Request) (*http.Request, error) {
+					return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+				})
+			if tt.allow {
+				h.AllowSetCookie()
+			}
+			_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+
+			for i, expected := range tt.expectedCache {
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/test", nil))
+				assert.Equal(t, http.StatusOK, w.Code, "request %d", i)
+				assert.Equal(t, "content", w.Body.String(), "request %d", i)
+				assert.Equal(t, "session=secret", w.Header().Get("Set-Cookie"), "request %d", i)
+				assert.Equal(t, expected, w.Header().Get("X-Cache"), "request %d", i)
+			}
+			assert.Equal(t, tt.expectedCalls, upstreamCalls.Load())
+		})
+	}
+}
+
+func TestPrecompressedVariants(t *testing.T) {
+	const body = `{"versions": ["v1.0.0", "v1.1.0", "v1.2.0"]}`
+	var upstreamCalls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		if r.URL.Path == "/text" {
+			w.Header().Set("Content-Type", "text/plain")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		_, _ = fmt.Fprint(w, body)
+	}))
+	defer upstream.Close()
+
+	h := handler.New(http.DefaultClient, mustNewMemoryCache()).
+		Transform(func(r *http.Request) (*http.Request, error) {
+			return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL+r.URL.Path, nil)
+		}).
+		Precompress("application/json")
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+
+	for _, path := range []string{"/json", "/text"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, path, nil))
+		assert.Equal(t, handler.CacheMiss, w.Header().Get("X-Cache"))
+		assert.Equal(t, body, w.Body.String())
+	}
+
+	tests := []struct {
+		name           string
+		path           string
+		acceptEncoding string
+		expectEncoding string
+		expectVary     string
+	}{
+		{name: "GzipClient", path: "/json", acceptEncoding: "gzip, deflate, br", expectEncoding: "gzip", expectVary: "Accept-Encoding"},
+		{name: "WildcardClient", path: "/json", acceptEncoding: "*", expectEncoding: "gzip", expectVary: "Accept-Encoding"},
+		{name: "IdentityClient", path: "/json", expectVary: "Accept-Encoding"},
+		{name: "GzipRefused", path: "/json", acceptEncoding: "*, gzip;q=0", expectVary: "Accept-Encoding"},
+		{name: "NotPrecompressed", path: "/text", acceptEncoding: "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequestWithContext(ctx, http.MethodGet, tt.path, nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, handler.CacheHit, w.Header().Get("X-Cache"))
+			assert.Equal(t, tt.expectEncoding, w.Header().Get("Content-Encoding"))
+			assert.Equal(t, tt.expectVary, w.Header().Get("Vary"))
+			got := w.Body.String()
+			if tt.expectEncoding == "gzip" {
+				zr, err := gzip.NewReader(w.Body)
+				assert.NoError(t, err)
+				decompressed, err := io.ReadAll(zr)
+				assert.NoError(t, err)
+				got = string(decompressed)
+			}
+			assert.Equal(t, body, got)
+		})
+	}
+	assert.Equal(t, int32(2), upstreamCalls.Load())
+}
+
+func TestPrecompressedVariantReplaced(t *testing.T) {
+	random := make([]byte, 128*1024)
+	_, _ = rand.Read(random)
+	bodies := []string{strings.Repeat(`{"name": "module", "versions": ["v1.0.0", "v1.1.0"]}`+"\n", 2000), string(random)}
+	var upstreamCalls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, bodies[upstreamCalls.Add(1)-1])
+	}))
+	defer upstream.Close()
+
+	memCache := mustNewMemoryCache()
+	h := handler.New(http.DefaultClient, memCache).
+		CacheKey(func(r *http.Request) string { return r.URL.Path }).
+		Transform(func(r *http.Request) (*http.Request, error) {
+			return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL+r.URL.Path, nil)
+		}).
+		Precompress("application/json")
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+	get := func(acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/index.json", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("gzip")
+	assert.Equal(t, handler.CacheMiss, w.Header().Get("X-Cache"))
+	w = get("gzip")
+	assert.Equal(t, handler.CacheHit, w.Header().Get("X-Cache"))
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+
+	// Only the identity object is evicted, and the content fetched again no longer compresses.
+	assert.NoError(t, memCache.Delete(ctx, cache.NewKey("/index.json")))
+	w = get("gzip")
+	assert.Equal(t, handler.CacheMiss, w.Header().Get("X-Cache"))
+	assert.Equal(t, bodies[1], w.Body.String())
+	assert.Equal(t, "", w.Header().Get("Vary"))
+
+	for _, acceptEncoding := range []string{"gzip", "identity"} {
+		w = get(acceptEncoding)
+		assert.Equal(t, handler.CacheHit, w.Header().Get("X-Cache"))
+		assert.Equal(t, "", w.Header().Get("Content-Encoding"), acceptEncoding)
+		assert.Equal(t, "", w.Header().Get("Vary"), acceptEncoding)
+		assert.Equal(t, bodies[1], w.Body.String(), acceptEncoding)
+	}
+	assert.Equal(t, int32(2), upstreamCalls.Load())
+}
+
+func TestPrecompressIncompressible(t *testing.T) {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	f, err := zw.Create("random.bin")
+	assert.NoError(t, err)
+	random := make([]byte, 128*1024)
+	_, _ = rand.Read(random)
+	_, err = f.Write(random)
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	text := strings.Repeat(`{"name": "module", "versions": ["v1.0.0", "v1.1.0"]}`+"\n", 2000)
+
+	bodies := map[string]struct {
+		contentType string
+		body        string
+	}{
+		"/module.zip": {contentType: "application/octet-stream", body: archive.String()},
+		"/typed.zip":  {contentType: "application/zip", body: text},
+		"/index.json": {contentType: "application/json", body: text},
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", bodies[r.URL.Path].contentType)
+		_, _ = fmt.Fprint(w, bodies[r.URL.Path].body)
+	}))
+	defer upstream.Close()
+
+	h := handler.New(http.DefaultClient, mustNewMemoryCache()).
+		Transform(func(r *http.Request) (*http.Request, error) {
+			return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL+r.URL.Path, nil)
+		}).
+		Precompress("application/*")
+	ctx := logging.ContextWithLogger(context.Background(), slog.Default())
+
+	tests := []struct {
+		name           string
+		path           string
+		expectEncoding string
+	}{
+		{name: "IncompressibleContent", path: "/module.zip"},
+		{name: "CompressedType", path: "/typed.zip"},
+		{name: "Compressible", path: "/index.json", expectEncoding: "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, tt.path, nil))
+			assert.Equal(t, handler.CacheMiss, w.Header().Get("X-Cache"))
+			assert.Equal(t, bodies[tt.path].body, w.Body.String())
+
+			req := httptest.NewRequestWithContext(ctx, http.MethodGet, tt.path, nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			w = httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			assert.Equal(t, handler.CacheHit, w.Header().Get("X-Cache"))
+			assert.Equal(t, tt.expectEncoding, w.Header().Get("Content-Encoding"))
+			got := w.Body.String()
+			if tt.expectEncoding == "gzip" {
+				assert.True(t, w.Body.Len() < len(bodies[tt.path].body)/10)
+				zr, err := gzip.NewReader(w.Body)
+				assert.NoError(t, err)
+				decompressed, err := io.ReadAll(zr)
+				assert.NoError(t, err)
+				got = string(decompressed)
+			}
+			assert.Equal(t, bodies[tt.path].body, got)
+		})
+	}
+}
+
+func TestUnknownLengthResponses(t *testing.T) {
+	chunked := func(w http.ResponseWriter, _ *http.Request) {
+		for _, chunk := range []string{"hello ", "world"} {
+			_, _ = fmt.Fprint(w, chunk)
+			w.(http.Flusher).Flush()
+		}
+	}
+	// raw writes a response directly to the connection and closes it, so that the body can be cut short.
+	raw := func(response string) http.HandlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				panic(err)
+			}
+			_, _ = conn.Write([]byte(response))
+			_ = conn.Close()
+		}
+	}
+	tests := []struct {
+		name          string
+		upstream      http.HandlerFunc
+		requireLength bool
+		expectedCache []string
+		expectedCalls int32
+	}{
+		{
+			name:          "ChunkedCached",
+			upstream:      chunked,
+			expectedCache: []string{handler.CacheMiss, handler.CacheHit},
+			expectedCalls: 1,
+		},
+		{
+			name:          "ChunkedWithRequiredLength",
+			upstream:      chunked,
+			requireLength: true,
+			expectedCache: []string{handler.CacheBypass, handler.CacheBypass},
+			expectedCalls: 2,
+		},
+		{
+			name:          "TruncatedChunked",
+			upstream:      raw("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nhello \r\n"),
+			expectedCache: []string{handler.CacheMiss, handler.CacheMiss},
+			expectedCalls: 2,
+		},
+		{
+			name:          "CloseDelimited",
+			upstream:      raw("HTTP/1.1 200 OK\r\nConnection: close\r\n\r\nhello world"),
+			expectedCache: []string{handler.CacheBypass, handler.CacheBypass},
+			expectedCalls: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var upstreamCalls atomic.Int32
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				upstreamCalls.Add(1)
+				tt.upstream(w, r)
+			}))
+			defer upstream.Close()
+
+			h := handler.New(http.DefaultClient, mustNewMemoryCache()).
+				Transform(func(r *http.Request) (*http.Request, error) {
+					return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+				})
+			if tt.requireLength {
+				h.RequireContentLength()
+			}
+			_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+
+			for i, expected := range tt.expectedCache {
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/test", nil))
+				assert.Equal(t, expected, w.Header().Get("X-Cache"), "request %d", i)
+				if expected == handler.CacheHit {
+					assert.Equal(t, "hello world", w.Body.String(), "request %d", i)
+					assert.Equal(t, "11", w.Header().Get("Content-Length"), "request %d", i)
+				}
+			}
+			assert.Equal(t, tt.expectedCalls, upstreamCalls.Load())
+		})
+	}
+}
+
+func TestObserve(t *testing.T) {
+	var upstreamCalls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		upstreamCalls.Add(1)
+		_, _ = fmt.Fprint(w, "content")
+	}))
+	defer upstream.Close()
+
+	c := mustNewMemoryCache()
+	h := handler.New(http.DefaultClient, c).
+		Transform(func(r *http.Request) (*http.Request, error) {
+			return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+		}).
+		TTL(func(*http.Request) time.Duration { return time.Minute }).
+		Observe(mustNewMemoryCache())
+
+	logs := &strings.Builder{}
+	ctx := logging.ContextWithLogger(context.Background(), slog.New(slog.NewJSONHandler(logs, nil)))
+	for i := range 2 {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/test", nil))
+		assert.Equal(t, http.StatusOK, w.Code, "request %d", i)
+		assert.Equal(t, "content", w.Body.String(), "request %d", i)
+	}
+	assert.Equal(t, int32(2), upstreamCalls.Load(), "requests should always be fetched from upstream")
+
+	var decisions []string
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var record struct {
+			Msg      string `json:"msg"`
+			Decision string `json:"decision"`
+			TTL      int64  `json:"ttl"`
+		}
+		assert.NoError(t, json.Unmarshal([]byte(line), &record))
+		if record.Msg == "Observed cache decision" {
+			decisions = append(decisions, record.Decision)
+			assert.Equal(t, time.Minute.Nanoseconds(), record.TTL)
+		}
+	}
+	assert.Equal(t, []string{handler.CacheMiss, handler.CacheHit}, decisions)
+
+	exists, err := c.Has(ctx, cache.NewKey("/test"))
+	assert.NoError(t, err)
+	assert.False(t, exists, "observe mode must not write to the cache")
+	stats, err := c.Stats(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), stats.Objects)
+}
+
+func TestKeyIncludesBody(t *testing.T) {
+	var upstreamCalls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		_, _ = fmt.Fprintf(w, "%s %s", r.Method, body)
+	}))
+	defer upstream.Close()
+
+	h := handler.New(http.DefaultClient, mustNewMemoryCache()).
+		Transform(func(r *http.Request) (*http.Request, error) {
+			return http.NewRequestWithContext(r.Context(), r.Method, upstream.URL, r.Body)
+		}).
+		KeyIncludesBody()
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+
+	tests := []struct {
+		name         string
+		method       string
+		body         string
+		expectBody   string
+		expectXCache string
+		expectStatus int
+	}{
+		{name: "FirstQuery", method: http.MethodPost, body: "{a}", expectBody: "POST {a}", expectXCache: handler.CacheMiss},
+		{name: "SecondQuery", method: http.MethodPost, body: "{b}", expectBody: "POST {b}", expectXCache: handler.CacheMiss},
+		{name: "RepeatedQuery", method: http.MethodPost, body: "{a}", expectBody: "POST {a}", expectXCache: handler.CacheHit},
+		{name: "GetSameURL", method: http.MethodGet, expectBody: "GET ", expectXCache: handler.CacheMiss},
+		{name: "BodyTooLarge", method: http.MethodPost, body: strings.Repeat("x", 1<<20+1), expectStatus: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, tt.method, "/graphql", strings.NewReader(tt.body)))
+			if tt.expectStatus != 0 {
+				assert.Equal(t, tt.expectStatus, w.Code)
+				return
+			}
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.expectBody, w.Body.String())
+			assert.Equal(t, tt.expectXCache, w.Header().Get("X-Cache"))
+		})
+	}
+	assert.Equal(t, int32(3), upstreamCalls.Load())
+}
+
+func TestWarmInBackground(t *testing.T) {
+	large := strings.Repeat("x", 10000)
+	release := make(chan struct{})
+	var upstreamCalls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		if r.URL.Path == "/small" {
+			_, _ = fmt.Fprint(w, "small")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(large)))
+		_, _ = fmt.Fprint(w, large[:100])
+		w.(http.Flusher).Flush() //nolint:forcetypeassert
+		<-release
+		_, _ = fmt.Fprint(w, large[100:])
+	}))
+	defer upstream.Close()
+
+	h := handler.New(http.DefaultClient, mustNewMemoryCache()).
+		Transform(func(r *http.Request) (*http.Request, error) {
+			return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL+r.URL.Path, nil)
+		}).
+		WarmInBackground(1000, 2*time.Second)
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get("/large")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.Equal(t, handler.CacheWarming, w.Header().Get("X-Cache"))
+
+	// Requests while warming don't reach upstream.
+	w = get("/large")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, int32(1), upstreamCalls.Load())
+
+	// Small responses are streamed as usual.
+	w = get("/small")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, handler.CacheMiss, w.Header().Get("X-Cache"))
+
+	close(release)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if w = get("/large"); w.Code != http.StatusAccepted {
+			break
+		}
+	}
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, handler.CacheHit, w.Header().Get("X-Cache"))
+	assert.Equal(t, large, w.Body.String())
+	assert.Equal(t, int32(2), upstreamCalls.Load())
+}
+
+func TestWarmInBackgroundClose(t *testing.T) {
+	large := strings.Repeat("x", 10000)
+	var upstreamCalls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(large)))
+		if upstreamCalls.Add(1) == 1 {
+			// The first transfer stalls until the warmer abandons it.
+			_, _ = fmt.Fprint(w, large[:100])
+			w.(http.Flusher).Flush() //nolint:forcetypeassert
+			<-r.Context().Done()
+			return
+		}
+		_, _ = fmt.Fprint(w, large)
+	}))
+	defer upstream.Close()
+
+	memCache := mustNewMemoryCache()
+	h := handler.New(http.DefaultClient, memCache).
+		CacheKey(func(r *http.Request) string { return r.URL.Path }).
+		Transform(func(r *http.Request) (*http.Request, error) {
+			return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL+r.URL.Path, nil)
+		}).
+		WarmInBackground(1000, 2*time.Second)
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get("/large")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	// Close returns once the stalled warmer has exited, without caching the partial object.
+	assert.NoError(t, h.Close())
+	exists, err := memCache.Has(ctx, cache.NewKey("/large"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// Misses after Close are streamed rather than warmed.
+	w = get("/large")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, handler.CacheMiss, w.Header().Get("X-Cache"))
+	assert.Equal(t, large, w.Body.String())
+}
+
+func TestWarmInBackgroundServer(t *testing.T) {
+	large := strings.Repeat("x", 10000)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(large)))
+		_, _ = fmt.Fprint(w, large[:100])
+		w.(http.Flusher).Flush() //nolint:forcetypeassert
+		// The rest of the body is sent after the client has received its 202 and its request context is cancelled.
+		time.Sleep(100 * time.Millisecond)
+		_, _ = fmt.Fprint(w, large[100:])
+	}))
+	defer upstream.Close()
+
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	h := handler.New(http.DefaultClient, mustNewMemoryCache()).
+		Transform(func(r *http.Request) (*http.Request, error) {
+			return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL+r.URL.Path, nil)
+		}).
+		WarmInBackground(1000, 2*time.Second)
+	defer h.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(logging.ContextWithLogger(r.Context(), logging.FromContext(ctx))))
+	}))
+	defer server.Close()
+	get := func() (*http.Response, string) {
+		resp, err := http.Get(server.URL + "/large")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return resp, string(body)
+	}
+
+	resp, _ := get()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	var body string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if resp, body = get(); resp.StatusCode != http.StatusAccepted {
+			break
+		}
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, handler.CacheHit, resp.Header.Get("X-Cache"))
+	assert.Equal(t, large, body)
+}
+
+func TestRequestMetricLabels(t *testing.T) {
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	reader := sdkmetric.NewManualReader()
+	client, err := metrics.New(ctx, metrics.Config{ServiceName: "cachew-test", Readers: []sdkmetric.Reader{reader}, LabelDeny: []string{"cache"}, LabelMaxValues: 2})
+	assert.NoError(t, err)
+	defer client.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(w, "content")
+	}))
+	defer upstream.Close()
+
+	h := handler.New(http.DefaultClient, mustNewMemoryCache()).
+		CacheKey(func(r *http.Request) string { return "https://" + r.URL.Query().Get("host") + "/artifact" }).
+		Transform(func(r *http.Request) (*http.Request, error) {
+			return http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+		})
+	for _, host := range []string{"a.example.com", "b.example.com", "c.example.com", "a.example.com"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/artifact?host="+host, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	var rm metricdata.ResourceMetrics
+	assert.NoError(t, reader.Collect(ctx, &rm))
+	counts := map[string]int64{}
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			if m.Name != "cachew.handler.requests" {
+				continue
+			}
+			for _, point := range m.Data.(metricdata.Sum[int64]).DataPoints { //nolint:forcetypeassert
+				_, denied := point.Attributes.Value("cache")
+				assert.False(t, denied, "denied label should be dropped")
+				upstream, _ := point.Attributes.Value("upstream")
+				counts[upstream.AsString()] += point.Value
+			}
+		}
+	}
+	assert.Equal(t, map[string]int64{"a.example.com": 2, "b.example.com": 1, metrics.OtherValue: 1}, counts)
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+
+	"github.com/block/cachew/internal/metrics"
+)
+
+const meterName = "github.com/block/cachew/internal/strategy/handler"
+
+func newRequestCounter() metric.Int64Counter {
+	requests, err := otel.Meter(meterName).Int64Counter("cachew.handler.requests",
+		metric.WithDescription("Number of requests served by caching handlers, by X-Cache status and upstream host"))
+	if err != nil {
+		return noop.Int64Counter{}
+	}
+	return requests
+}
+
+// recordRequest counts a request by the X-Cache status of its response and the host of its cache key, which is the
+// upstream URL unless the strategy keys requests otherwise.
+//
+// Labels are subject to [metrics.DefaultLabels], so that the number of hosts reported is bounded.
+func (h *Handler) recordRequest(ctx context.Context, w http.ResponseWriter, cacheKey string) {
+	status := w.Header().Get("X-Cache")
+	if status == "" {
+		return // The request failed before a response was produced.
+	}
+	attrs := metrics.DefaultLabels().Apply(attribute.String("cache", status), attribute.String("upstream", metrics.HostBucket(cacheKey)))
+	h.requests.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
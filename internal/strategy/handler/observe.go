@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/alecthomas/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/metrics"
+)
+
+// observer records the decisions a [Handler] in observe mode would have made.
+type observer struct {
+	shadow    cache.Cache
+	decisions metric.Int64Counter
+}
+
+func newObserver(shadow cache.Cache) *observer {
+	decisions, err := otel.Meter(meterName).Int64Counter("cachew.handler.observed_decisions",
+		metric.WithDescription("Number of would-be cache hits and misses of handlers in observe mode"))
+	if err != nil {
+		decisions = noop.Int64Counter{}
+	}
+	return &observer{shadow: shadow, decisions: decisions}
+}
+
+// observe logs whether the request for key would have been served from the cache.
+func (o *observer) observe(ctx context.Context, key cache.Key, ttl time.Duration, logger *slog.Logger) {
+	decision := CacheMiss
+	if exists, err := o.shadow.Has(ctx, key); err != nil {
+		logger.WarnContext(ctx, "Failed to check shadow cache", slog.String("error", err.Error()))
+		return
+	} else if exists {
+		decision = CacheHit
+	}
+	logger.InfoContext(ctx, "Observed cache decision",
+		slog.String("decision", decision),
+		slog.String("key", key.String()),
+		slog.Duration("ttl", ttl))
+	o.decisions.Add(ctx, 1, metric.WithAttributes(metrics.DefaultLabels().Apply(attribute.String("decision", decision))...))
+}
+
+// shadowEntrySize approximates the memory used by the key and headers of an entry.
+const shadowEntrySize = 512
+
+// shadowCache records the objects that would have been cached, with their headers and TTLs but without their bodies.
+//
+// Each body is replaced by shadowEntrySize bytes, so that the size limit of a shadow such as [cache.Memory] bounds the
+// number of objects tracked rather than being disregarded by empty bodies.
+type shadowCache struct {
+	cache.Cache
+}
+
+func (s shadowCache) Create(ctx context.Context, key cache.Key, headers http.Header, ttl time.Duration) (io.WriteCloser, error) {
+	w, err := s.Cache.Create(ctx, key, headers, ttl)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return discardBody{w}, nil
+}
+
+// discardBody commits an object with a placeholder in place of its body.
+type discardBody struct {
+	io.WriteCloser
+}
+
+func (discardBody) Write(p []byte) (int, error) { return len(p), nil }
+
+func (d discardBody) Close() error {
+	if _, err := d.WriteCloser.Write(make([]byte, shadowEntrySize)); err != nil {
+		return errors.Join(errors.WithStack(err), d.WriteCloser.Close())
+	}
+	return errors.WithStack(d.WriteCloser.Close())
+}
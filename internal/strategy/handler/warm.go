@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/cache"
+)
+
+// warmer tracks the objects being cached in the background by [Handler.WarmInBackground].
+type warmer struct {
+	minSize    int64
+	retryAfter time.Duration
+
+	// closing is cancelled by close to abandon the objects being warmed, which wg tracks.
+	closing context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	closed  bool
+	warming map[cache.Key]bool
+}
+
+func newWarmer(minSize int64, retryAfter time.Duration) *warmer {
+	closing, cancel := context.WithCancel(context.Background())
+	return &warmer{minSize: minSize, retryAfter: retryAfter, closing: closing, cancel: cancel, warming: map[cache.Key]bool{}}
+}
+
+// start marks key as being warmed, returning false if it already is or the warmer is closed. Each successful call must
+// be followed by a call to done.
+func (w *warmer) start(key cache.Key) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed || w.warming[key] {
+		return false
+	}
+	w.warming[key] = true
+	w.wg.Add(1)
+	return true
+}
+
+func (w *warmer) done(key cache.Key) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.warming, key)
+	w.wg.Done()
+}
+
+// close abandons the objects being warmed and waits for their goroutines to exit. Misses after close are streamed as
+// usual.
+func (w *warmer) close() {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+	w.cancel()
+	w.wg.Wait()
+}
+
+func (w *warmer) isWarming(key cache.Key) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.warming[key]
+}
+
+// detachableFetch is an upstream request that is cancelled with the client's request until it is detached, so that
+// a response warmed in the background outlives the request that fetched it.
+type detachableFetch struct {
+	stop     func() bool
+	cancel   context.CancelFunc
+	detached bool
+}
+
+// detachableRequest returns upstreamReq with a context that can be detached from r if the handler warms in the
+// background. Otherwise upstreamReq is returned unchanged with a nil fetch, which is safe to release.
+func (h *Handler) detachableRequest(r, upstreamReq *http.Request) (*http.Request, *detachableFetch) {
+	if h.warmer == nil || h.observer != nil {
+		return upstreamReq, nil
+	}
+	ctx, cancel := context.WithCancel(context.WithoutCancel(upstreamReq.Context()))
+	stop := context.AfterFunc(r.Context(), cancel)
+	return upstreamReq.WithContext(ctx), &detachableFetch{stop: stop, cancel: cancel}
+}
+
+// detach stops the fetch from being cancelled with the client's request, returning the function that cancels it.
+func (f *detachableFetch) detach() context.CancelFunc {
+	f.stop()
+	f.detached = true
+	return f.cancel
+}
+
+// release cancels the fetch unless it has been detached.
+func (f *detachableFetch) release() {
+	if f == nil || f.detached {
+		return
+	}
+	f.stop()
+	f.cancel()
+}
+
+// respondWarming tells the client that the object is being cached and to retry later.
+func (h *Handler) respondWarming(w http.ResponseWriter, r *http.Request, key cache.Key, logger *slog.Logger) {
+	w.Header().Set("Retry-After", formatRetryAfter(h.warmer.retryAfter))
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	h.setCacheHeaders(w, r, key, CacheWarming, logger)
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = io.WriteString(w, "Object is being cached, retry later\n")
+}
+
+// warmInBackground responds with "202 Accepted" and caches the response body in the background with headers and ttl.
+// The caller must have claimed key with [warmer.start], and resp must have been fetched with a detached request
+// whose context is cancelled by cancelFetch.
+func (h *Handler) warmInBackground(w http.ResponseWriter, r *http.Request, key cache.Key, resp *http.Response, cancelFetch context.CancelFunc, headers http.Header, ttl time.Duration, logger *slog.Logger) {
+	// The body outlives the request, so is taken from the response before the caller closes it.
+	body := resp.Body
+	resp.Body = http.NoBody
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	// Closing the body interrupts a stalled upstream transfer.
+	stop := context.AfterFunc(h.warmer.closing, func() {
+		cancel()
+		cancelFetch()
+		_ = body.Close()
+	})
+	go func() {
+		defer h.warmer.done(key)
+		defer stop()
+		defer cancel()
+		defer cancelFetch()
+		start := time.Now()
+		err := h.cacheBody(ctx, cancel, key, headers, ttl, body)
+		if err != nil {
+			logger.WarnContext(ctx, "Failed to warm cache", slog.String("error", err.Error()))
+			return
+		}
+		logger.DebugContext(ctx, "Warmed cache", slog.Duration("elapsed", time.Since(start)))
+	}()
+
+	logger.DebugContext(r.Context(), "Cache miss, warming in background", slog.Int64("content_length", resp.ContentLength))
+	h.respondWarming(w, r, key, logger)
+}
+
+// cacheBody writes body to the cache at key, closing it. Cancelling ctx with cancel discards the entry.
+func (h *Handler) cacheBody(ctx context.Context, cancel context.CancelFunc, key cache.Key, headers http.Header, ttl time.Duration, body io.ReadCloser) error {
+	defer body.Close()
+	cw, err := h.cache.Create(ctx, key, headers, ttl)
+	if err != nil {
+		return errors.Errorf("failed to create cache entry: %w", err)
+	}
+	if _, err := io.Copy(cw, body); err != nil {
+		cancel()
+		return errors.Join(errors.Errorf("failed to fetch: %w", err), cw.Close())
+	}
+	return errors.WithStack(cw.Close())
+}
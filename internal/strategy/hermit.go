@@ -2,6 +2,7 @@ package strategy
 
 import (
 	"context"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
@@ -23,6 +24,7 @@ func RegisterHermit(r *Registry, cachewURL string) {
 
 type HermitConfig struct {
 	GitHubBaseURL string `hcl:"github-base-url" help:"Base URL for GitHub release redirects" default:"${CACHEW_URL}/github.com"`
+	Observe       bool   `hcl:"observe,optional" help:"Dry run: always fetch from upstream and never cache, logging whether each request would have been a hit or a miss."`
 }
 
 // Hermit caches Hermit package downloads.
@@ -36,9 +38,13 @@ type Hermit struct {
 	mux             Mux
 	redirectHandler http.Handler
 	directHandler   http.Handler
+	shadow          cache.Cache // Set in observe mode.
 }
 
-var _ Strategy = (*Hermit)(nil)
+var (
+	_ Strategy  = (*Hermit)(nil)
+	_ io.Closer = (*Hermit)(nil)
+)
 
 func NewHermit(ctx context.Context, cachewURL string, config HermitConfig, _ jobscheduler.Scheduler, c cache.Cache, mux Mux) (*Hermit, error) {
 	logger := logging.FromContext(ctx)
@@ -50,6 +56,12 @@ func NewHermit(ctx context.Context, cachewURL string, config HermitConfig, _ job
 		logger: logger,
 		mux:    mux,
 	}
+	if config.Observe {
+		var err error
+		if s.shadow, err = newShadowCache(ctx, 0); err != nil {
+			return nil, err
+		}
+	}
 
 	s.directHandler = s.createDirectHandler(c)
 	mux.Handle("GET /hermit/{host}/{path...}", s.directHandler)
@@ -70,14 +82,21 @@ func NewHermit(ctx context.Context, cachewURL string, config HermitConfig, _ job
 
 func (s *Hermit) String() string { return "hermit" }
 
+// Close releases the shadow cache of a Hermit strategy in observe mode.
+func (s *Hermit) Close() error { return closeShadowCache(s.shadow) }
+
 func (s *Hermit) createDirectHandler(c cache.Cache) http.Handler {
-	return handler.New(s.client, c).
+	h := handler.New(s.client, c).
 		CacheKey(func(r *http.Request) string {
 			return s.buildOriginalURL(r)
 		}).
 		Transform(func(r *http.Request) (*http.Request, error) {
 			return s.buildDirectRequest(r)
 		})
+	if s.shadow != nil {
+		h.Observe(s.shadow)
+	}
+	return h
 }
 
 func (s *Hermit) createRedirectHandler(isInternalRedirect bool, c cache.Cache) http.Handler {
@@ -88,7 +107,7 @@ func (s *Hermit) createRedirectHandler(isInternalRedirect bool, c cache.Cache) h
 		cacheBackend = c
 	}
 
-	return handler.New(s.client, cacheBackend).
+	h := handler.New(s.client, cacheBackend).
 		CacheKey(func(r *http.Request) string {
 			return s.buildGitHubURL(r)
 		}).
@@ -96,6 +115,11 @@ func (s *Hermit) createRedirectHandler(isInternalRedirect bool, c cache.Cache) h
 			s.logger.DebugContext(r.Context(), "Redirect handler called for GitHub release")
 			return s.buildRedirectRequest(r)
 		})
+	// Internal redirects are cached by the github-releases strategy, so there is nothing to observe here.
+	if s.shadow != nil && !isInternalRedirect {
+		h.Observe(s.shadow)
+	}
+	return h
 }
 
 func (s *Hermit) buildGitHubURL(r *http.Request) string {
@@ -1,13 +1,19 @@
 package strategy
 
 import (
+	"cmp"
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"time"
+
+	"github.com/alecthomas/errors"
 
 	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/httputil"
 	"github.com/block/cachew/internal/logging"
 	"github.com/block/cachew/internal/strategy/handler"
 )
@@ -26,19 +32,69 @@ func RegisterHost(r *Registry) {
 //
 // In this example, the strategy will be mounted under "/github.com".
 type HostConfig struct {
-	Target string `hcl:"target,label" help:"The target URL to proxy requests to."`
+	Target                  string               `hcl:"target,label" help:"The target URL to proxy requests to."`
+	TTL                     time.Duration        `hcl:"ttl,optional" help:"How long to cache responses, capped by the cache's max-ttl (defaults to the cache's max-ttl)."`
+	HTTPFreshness           bool                 `hcl:"http-freshness,optional" help:"Cache responses for the freshness lifetime given by their Cache-Control, Expires or Last-Modified headers, less their Age, as described by RFC 7234. Responses that give none are cached for ttl, and stale or private responses are not cached."`
+	StaleIfError            time.Duration        `hcl:"stale-if-error,optional" help:"Serve expired cached objects for up to this long when the upstream fails (0 disables)."`
+	AllowedContentTypes     []string             `hcl:"allowed-content-types,optional" help:"Only cache responses with these content types, eg. \"text/*\" (defaults to all)."`
+	AllowedExtensions       []string             `hcl:"allowed-extensions,optional" help:"Only cache requests for paths with these file extensions, eg. \".jar\" (defaults to all)."`
+	Headers                 map[string]string    `hcl:"headers,optional" help:"Static headers to add to every upstream request."`
+	PassthroughMethods      []string             `hcl:"passthrough-methods,optional" help:"HTTP methods, eg. \"POST\", forwarded verbatim to the upstream without caching."`
+	MaxRedirects            int                  `hcl:"max-redirects,optional" help:"Upstream redirects to follow before relaying the redirect to the client, or -1 to relay redirects without following them (defaults to 10)." default:"10"`
+	RedirectHosts           []string             `hcl:"redirect-hosts,optional" help:"Hosts, eg. a CDN, that upstream redirects may lead to (defaults to all)."`
+	CacheRedirects          bool                 `hcl:"cache-redirects,optional" help:"Cache the response of a followed redirect under the original URL." default:"true"`
+	ReadThroughOnly         bool                 `hcl:"read-through-only,optional" help:"Proxy requests without ever reading from or writing to the cache."`
+	FailClosed              bool                 `hcl:"fail-closed,optional" help:"Fail requests when the cache returns an error other than a miss, rather than logging it and fetching from upstream."`
+	PrecompressContentTypes []string             `hcl:"precompress-content-types,optional" help:"Also cache a gzip-compressed variant of responses with these content types, eg. \"application/json\", served to clients accepting gzip. Content that is already compressed is not precompressed."`
+	CacheSetCookie          bool                 `hcl:"cache-set-cookie,optional" help:"Cache responses that set cookies, which are otherwise streamed without caching. Only enable for upstreams whose cookies are safe to share between clients."`
+	RequireContentLength    bool                 `hcl:"require-content-length,optional" help:"Only cache responses with a Content-Length. Chunked responses are otherwise cached once they complete."`
+	Observe                 bool                 `hcl:"observe,optional" help:"Dry run: always fetch from upstream and never cache, logging whether each request would have been a hit or a miss."`
+	WarmMinSize             int64                `hcl:"warm-min-size,optional" help:"Answer misses for responses of at least this many bytes with 202 Accepted and cache them in the background, so clients poll rather than wait (0 disables)."`
+	WarmRetryAfter          time.Duration        `hcl:"warm-retry-after,optional" help:"How long clients are asked to wait before retrying a request for an object being cached in the background." default:"5s"`
+	Proxy                   httputil.ProxyConfig `hcl:"proxy,block" help:"HTTP proxy for requests to the target, overriding the global proxy."`
+}
+
+// Validate the configuration.
+func (c *HostConfig) Validate() error {
+	var errs []error
+	errs = append(errs, validateURL("target", c.Target))
+	if c.TTL < 0 {
+		errs = append(errs, errors.New("ttl must not be negative"))
+	}
+	if c.StaleIfError < 0 {
+		errs = append(errs, errors.New("stale-if-error must not be negative"))
+	}
+	if c.MaxRedirects < -1 {
+		errs = append(errs, errors.New("max-redirects must be -1 or more"))
+	}
+	if c.WarmMinSize < 0 {
+		errs = append(errs, errors.New("warm-min-size must not be negative"))
+	}
+	if c.WarmMinSize > 0 && c.WarmRetryAfter <= 0 {
+		errs = append(errs, errors.New("warm-retry-after must be positive"))
+	}
+	errs = append(errs, validateMethods(c.PassthroughMethods))
+	errs = append(errs, c.Proxy.Validate())
+	return errors.Join(errs...)
 }
 
 // The Host [Strategy] forwards all GET requests to the specified host, caching the response payloads.
+//
+// Requests using any of the configured passthrough methods are forwarded without caching.
 type Host struct {
-	target *url.URL
-	cache  cache.Cache
-	client *http.Client
-	logger *slog.Logger
-	prefix string
+	target  *url.URL
+	cache   cache.Cache
+	client  *http.Client
+	logger  *slog.Logger
+	prefix  string
+	handler *handler.Handler
+	shadow  cache.Cache // Set in observe mode.
 }
 
-var _ Strategy = (*Host)(nil)
+var (
+	_ Strategy  = (*Host)(nil)
+	_ io.Closer = (*Host)(nil)
+)
 
 func NewHost(ctx context.Context, config HostConfig, cache cache.Cache, mux Mux) (*Host, error) {
 	u, err := url.Parse(config.Target)
@@ -46,10 +102,19 @@ func NewHost(ctx context.Context, config HostConfig, cache cache.Cache, mux Mux)
 		return nil, fmt.Errorf("invalid target URL: %w", err)
 	}
 	prefix := "/" + u.Host + u.EscapedPath()
+	// Configs built in code rather than decoded from HCL have no defaults applied, so an unset redirect policy gets them
+	// here.
+	switch config.MaxRedirects {
+	case 0:
+		config.MaxRedirects = 10
+		config.CacheRedirects = true
+	case -1:
+		config.MaxRedirects = 0
+	}
 	h := &Host{
 		target: u,
 		cache:  cache,
-		client: &http.Client{},
+		client: &http.Client{Transport: httputil.ProxyTransport(http.DefaultTransport, config.Proxy)},
 		logger: logging.FromContext(ctx),
 		prefix: prefix,
 	}
@@ -61,14 +126,82 @@ func NewHost(ctx context.Context, config HostConfig, cache cache.Cache, mux Mux)
 		Transform(func(r *http.Request) (*http.Request, error) {
 			targetURL := h.buildTargetURL(r)
 			return http.NewRequestWithContext(r.Context(), http.MethodGet, targetURL.String(), nil)
+		}).
+		StaleIfError(config.StaleIfError).
+		AllowContentTypes(config.AllowedContentTypes...).
+		AllowExtensions(config.AllowedExtensions...).
+		UpstreamHeaders(config.Headers).
+		Precompress(config.PrecompressContentTypes...).
+		Redirects(handler.RedirectPolicy{
+			Max:          config.MaxRedirects,
+			AllowedHosts: config.RedirectHosts,
+			Cache:        config.CacheRedirects,
 		})
+	if config.TTL > 0 {
+		hdlr.TTL(func(*http.Request) time.Duration { return config.TTL })
+	}
+	if config.HTTPFreshness {
+		hdlr.HTTPFreshness()
+	}
+	if config.ReadThroughOnly {
+		hdlr.ReadThroughOnly()
+	}
+	if config.FailClosed {
+		hdlr.FailClosed()
+	}
+	if config.CacheSetCookie {
+		hdlr.AllowSetCookie()
+	}
+	if config.RequireContentLength {
+		hdlr.RequireContentLength()
+	}
+	if config.WarmMinSize > 0 {
+		hdlr.WarmInBackground(config.WarmMinSize, config.WarmRetryAfter)
+	}
+	if config.Observe {
+		if h.shadow, err = newShadowCache(ctx, config.TTL); err != nil {
+			return nil, err
+		}
+		hdlr.Observe(h.shadow)
+	}
 
+	h.handler = hdlr
 	mux.Handle("GET "+prefix+"/", hdlr)
+
+	passthrough := newPassthrough(h.client, h.buildTargetURL, config.Headers)
+	for _, method := range config.PassthroughMethods {
+		mux.Handle(method+" "+prefix+"/", passthrough)
+	}
 	return h, nil
 }
 
+// newShadowCache creates the cache tracking the objects a strategy in observe mode would have cached, which must be
+// closed with [closeShadowCache] when the strategy is closed.
+//
+// Objects expire with the strategy's TTL, or the default maximum TTL of the cache backends if it is not set.
+func newShadowCache(ctx context.Context, ttl time.Duration) (cache.Cache, error) {
+	shadow, err := cache.NewMemory(ctx, cache.MemoryConfig{LimitMB: 64, MaxTTL: cmp.Or(ttl, time.Hour)})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create shadow cache")
+	}
+	return shadow, nil
+}
+
+// closeShadowCache closes the shadow cache of a strategy, if it is in observe mode.
+func closeShadowCache(shadow cache.Cache) error {
+	if shadow == nil {
+		return nil
+	}
+	return errors.Wrap(shadow.Close(), "failed to close shadow cache")
+}
+
 func (d *Host) String() string { return "host:" + d.target.Host + d.target.Path }
 
+// Close abandons the objects being warmed in the background and releases the shadow cache of a host in observe mode.
+func (d *Host) Close() error {
+	return errors.Join(d.handler.Close(), closeShadowCache(d.shadow))
+}
+
 // buildTargetURL constructs the target URL from the incoming request.
 func (d *Host) buildTargetURL(r *http.Request) *url.URL {
 	// Strip the prefix from the request path
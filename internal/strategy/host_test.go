@@ -2,10 +2,12 @@ package strategy_test
 
 import (
 	"context"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
@@ -87,6 +89,73 @@ func TestHostNonOKStatus(t *testing.T) {
 	assert.Error(t, err, "non-OK responses should not be cached")
 }
 
+func TestHostRedirectDefaults(t *testing.T) {
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("artifact"))
+	}))
+	defer cdn.Close()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, cdn.URL+"/artifact", http.StatusFound)
+	}))
+	defer backend.Close()
+	u, err := url.Parse(backend.URL)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		maxRedirects int
+		expectedCode int
+		expectCached bool
+	}{
+		{name: "Unset", expectedCode: http.StatusOK, expectCached: true},
+		{name: "Relayed", maxRedirects: -1, expectedCode: http.StatusFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+			memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+			assert.NoError(t, err)
+			defer memCache.Close()
+
+			mux := http.NewServeMux()
+			_, err = strategy.NewHost(ctx, strategy.HostConfig{Target: backend.URL, MaxRedirects: tt.maxRedirects}, memCache, mux)
+			assert.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/"+u.Host+"/artifact", nil))
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			_, err = memCache.Stat(ctx, cache.NewKey(backend.URL+"/artifact"))
+			assert.Equal(t, tt.expectCached, err == nil, "%v", err)
+		})
+	}
+}
+
+func TestHostObserveClose(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("response"))
+	}))
+	defer backend.Close()
+	u, err := url.Parse(backend.URL)
+	assert.NoError(t, err)
+
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer memCache.Close()
+
+	mux := http.NewServeMux()
+	host, err := strategy.NewHost(ctx, strategy.HostConfig{Target: backend.URL, Observe: true}, memCache, mux)
+	assert.NoError(t, err)
+	assert.NoError(t, host.Close())
+
+	// Requests still in flight when a reloaded host is closed are served without recording them.
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/"+u.Host+"/object", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "response", w.Body.String())
+}
+
 func TestHostInvalidTargetURL(t *testing.T) {
 	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
 	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
@@ -110,3 +179,83 @@ func TestHostString(t *testing.T) {
 
 	assert.Equal(t, "host:example.com/prefix", host.String())
 }
+
+func TestHostUpstreamHeaders(t *testing.T) {
+	var received http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		_, _ = w.Write([]byte("response"))
+	}))
+	defer backend.Close()
+
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer memCache.Close()
+
+	mux := http.NewServeMux()
+	_, err = strategy.NewHost(ctx, strategy.HostConfig{
+		Target: backend.URL,
+		Headers: map[string]string{
+			"User-Agent":   "cachew-test/1.0",
+			"x-mirror-key": "secret-key",
+		},
+	}, memCache, mux)
+	assert.NoError(t, err)
+
+	u, _ := url.Parse(backend.URL)
+	req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/"+u.Host+"/test", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "cachew-test/1.0", received.Get("User-Agent"))
+	assert.Equal(t, "secret-key", received.Get("X-Mirror-Key"))
+}
+
+func TestHostPassthroughMethods(t *testing.T) {
+	type upstreamRequest struct {
+		method, path, body, auth string
+	}
+	var requests []upstreamRequest
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, upstreamRequest{r.Method, r.URL.Path, string(body), r.Header.Get("Authorization")})
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("deleted " + r.URL.Path))
+	}))
+	defer backend.Close()
+
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer memCache.Close()
+
+	mux := http.NewServeMux()
+	_, err = strategy.NewHost(ctx, strategy.HostConfig{Target: backend.URL, PassthroughMethods: []string{"DELETE"}}, memCache, mux)
+	assert.NoError(t, err)
+
+	u, _ := url.Parse(backend.URL)
+	reqPath := "/" + u.Host + "/items/1"
+
+	req := httptest.NewRequestWithContext(ctx, http.MethodDelete, reqPath, strings.NewReader("payload"))
+	req.Header.Set("Authorization", "Bearer token")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "deleted /items/1", w.Body.String())
+	assert.Equal(t, "BYPASS", w.Header().Get("X-Cache"))
+	assert.Equal(t, []upstreamRequest{{http.MethodDelete, "/items/1", "payload", "Bearer token"}}, requests)
+
+	stats, err := memCache.Stats(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), stats.Objects, "passthrough responses must not be cached")
+
+	// Methods that are not configured are not routed.
+	req = httptest.NewRequestWithContext(ctx, http.MethodPost, reqPath, nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, 1, len(requests))
+}
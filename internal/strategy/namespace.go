@@ -0,0 +1,33 @@
+package strategy
+
+import (
+	"strings"
+
+	"github.com/alecthomas/errors"
+	"github.com/alecthomas/hcl/v2"
+
+	"github.com/block/cachew/internal/cache"
+)
+
+// NamespaceConfig isolates the cache entries of a strategy from those of other strategies.
+//
+// It is accepted in the block of every strategy. Keys are mixed with the namespace before they reach the cache, so
+// two strategies caching the same URL with different transforms or credentials never serve each other's entries.
+// The namespace defaults to the block's name and labels, eg. "host:https://example.com", so strategies are isolated
+// unless configured with the same namespace.
+type NamespaceConfig struct {
+	CacheNamespace string `hcl:"cache-namespace,optional" help:"Namespace mixed into the strategy's cache keys. Strategies configured with the same namespace share cache entries. Defaults to the block's name and labels. Changing it orphans existing entries."`
+}
+
+// Namespace returns the namespace of the strategy configured by block.
+func (c NamespaceConfig) Namespace(block *hcl.Block) string {
+	if c.CacheNamespace != "" {
+		return c.CacheNamespace
+	}
+	return strings.Join(append([]string{block.Name}, block.Labels...), ":")
+}
+
+// Cache returns inner with keys mixed with the namespace of the strategy configured by block.
+func (c NamespaceConfig) Cache(block *hcl.Block, inner cache.Cache) (cache.Cache, error) {
+	return errors.WithStack2(cache.NewSaltedCache(inner, "strategy-namespace:"+c.Namespace(block)))
+}
@@ -0,0 +1,68 @@
+package strategy_test
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/alecthomas/hcl/v2"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy"
+)
+
+// urlCacher caches the same URL in whatever cache it is given.
+type urlCacher struct{}
+
+func (urlCacher) String() string { return "url-cacher" }
+
+func registerURLCacher(r *strategy.Registry, id string) {
+	strategy.Register(r, id, "Caches a fixed URL.", func(ctx context.Context, _ struct{}, c cache.Cache, _ strategy.Mux) (urlCacher, error) {
+		w, err := c.Create(ctx, cache.NewKey("https://example.com/artifact.jar"), nil, time.Hour)
+		if err != nil {
+			return urlCacher{}, err
+		}
+		_, _ = w.Write([]byte(id))
+		return urlCacher{}, w.Close()
+	})
+}
+
+func TestNamespace(t *testing.T) {
+	tests := []struct {
+		name            string
+		config          string
+		expectedObjects int64
+	}{
+		{"IsolatedByDefault", "first {}\nsecond {}", 2},
+		{"Shared", "first {\ncache-namespace = \"shared\"\n}\nsecond {\ncache-namespace = \"shared\"\n}", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+			memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+			assert.NoError(t, err)
+			defer memCache.Close()
+
+			sr := strategy.NewRegistry()
+			registerURLCacher(sr, "first")
+			registerURLCacher(sr, "second")
+			ast, err := hcl.Parse(strings.NewReader(tt.config))
+			assert.NoError(t, err)
+			for _, entry := range ast.Entries {
+				block := entry.(*hcl.Block) //nolint:errcheck
+				assert.NoError(t, sr.Validate(block.Name, block, nil))
+				_, err = sr.Create(ctx, block.Name, block, memCache, http.NewServeMux(), nil)
+				assert.NoError(t, err)
+			}
+
+			stats, err := memCache.Stats(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedObjects, stats.Objects)
+		})
+	}
+}
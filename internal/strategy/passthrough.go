@@ -0,0 +1,59 @@
+package strategy
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy/handler"
+)
+
+// newPassthrough returns a handler that forwards requests verbatim, including their bodies, to the URL returned by
+// target, and relays the upstream response without caching it.
+//
+// Static headers are added to the upstream request unless already set by the client.
+//
+// "Expect: 100-continue" is forwarded upstream. Provided the transport has an ExpectContinueTimeout, as
+// [http.DefaultTransport] does, the client body is not read until the upstream responds with "100 Continue", which is
+// relayed to the client, and a final status sent instead is relayed without the client ever sending the body.
+func newPassthrough(client *http.Client, target func(*http.Request) *url.URL, headers map[string]string) http.Handler {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL = target(pr.In)
+			pr.Out.Host = ""
+			for name, value := range headers {
+				if pr.Out.Header.Get(name) == "" {
+					pr.Out.Header.Set(name, value)
+				}
+			}
+		},
+		Transport: client.Transport,
+		ModifyResponse: func(resp *http.Response) error {
+			resp.Header.Set("X-Cache", handler.CacheBypass)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			logging.FromContext(r.Context()).ErrorContext(r.Context(), "Upstream request failed", slog.String("error", err.Error()))
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+}
+
+// validateMethods checks that the passthrough-methods setting lists upper-case methods that are not cached.
+func validateMethods(methods []string) error {
+	var errs []error
+	for _, method := range methods {
+		switch {
+		case method == "" || method != strings.ToUpper(method):
+			errs = append(errs, errors.Errorf("passthrough-methods must be upper-case HTTP methods, got %q", method))
+		case method == http.MethodGet || method == http.MethodHead:
+			errs = append(errs, errors.Errorf("passthrough-methods must not include %s, which is cached", method))
+		}
+	}
+	return errors.Join(errs...)
+}
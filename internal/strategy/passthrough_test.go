@@ -0,0 +1,99 @@
+package strategy_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+
+	"github.com/block/cachew/internal/cache"
+	"github.com/block/cachew/internal/logging"
+	"github.com/block/cachew/internal/strategy"
+)
+
+func TestPassthroughExpectContinue(t *testing.T) {
+	var received []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Expect") != "100-continue" {
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+		if r.Header.Get("Authorization") == "" {
+			// Rejected before the body is read, so no 100 Continue is sent.
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, string(body))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer backend.Close()
+
+	_, ctx := logging.Configure(context.Background(), logging.Config{Level: slog.LevelError})
+	memCache, err := cache.NewMemory(ctx, cache.MemoryConfig{MaxTTL: time.Hour})
+	assert.NoError(t, err)
+	defer memCache.Close()
+
+	mux := http.NewServeMux()
+	_, err = strategy.NewHost(ctx, strategy.HostConfig{Target: backend.URL, PassthroughMethods: []string{"PUT"}}, memCache, mux)
+	assert.NoError(t, err)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	u, _ := url.Parse(backend.URL)
+	path := "/" + u.Host + "/artifact.bin"
+
+	t.Run("Accepted", func(t *testing.T) {
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		assert.NoError(t, err)
+		defer conn.Close()
+		assert.NoError(t, conn.SetDeadline(time.Now().Add(10*time.Second)))
+		br := bufio.NewReader(conn)
+
+		_, err = fmt.Fprintf(conn, "PUT %s HTTP/1.1\r\nHost: cachew\r\nAuthorization: Bearer token\r\nExpect: 100-continue\r\nContent-Length: 7\r\n\r\n", path)
+		assert.NoError(t, err)
+
+		// The body must not be sent until the upstream has agreed to receive it.
+		status, err := br.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 100 Continue\r\n", status)
+		blank, err := br.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, "\r\n", blank)
+
+		_, err = io.WriteString(conn, "payload")
+		assert.NoError(t, err)
+
+		resp, err := http.ReadResponse(br, nil)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, []string{"payload"}, received)
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		assert.NoError(t, err)
+		defer conn.Close()
+		assert.NoError(t, conn.SetDeadline(time.Now().Add(10*time.Second)))
+
+		_, err = fmt.Fprintf(conn, "PUT %s HTTP/1.1\r\nHost: cachew\r\nExpect: 100-continue\r\nContent-Length: 7\r\n\r\n", path)
+		assert.NoError(t, err)
+
+		// The upstream's final status is relayed without a 100 Continue, so the client never sends the body.
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, 1, len(received))
+	})
+}
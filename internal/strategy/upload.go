@@ -0,0 +1,326 @@
+package strategy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecthomas/errors"
+
+	"github.com/block/cachew/internal/cache"
+)
+
+// uploadSessionTTL is how long an upload session is kept without receiving a chunk before it is abandoned.
+const uploadSessionTTL = 24 * time.Hour
+
+// errChunkOffset is returned for a chunk that does not start at the end of the data committed to its session.
+var errChunkOffset = errors.New("chunk does not start at the committed offset")
+
+// errUploadTooLarge is returned for a chunk that would take its session beyond the maximum upload size.
+var errUploadTooLarge = errors.New("upload exceeds the maximum size")
+
+// UploadConfig configures the resumable uploads of the object API.
+type UploadConfig struct {
+	Dir       string `hcl:"dir,optional" help:"Directory the chunks of resumable uploads are spooled to until they are completed. Defaults to the system temporary directory."`
+	MaxSizeMB int    `hcl:"max-size-mb,optional" help:"Largest object that may be uploaded in chunks, in MB." default:"10240"`
+}
+
+// Validate the configuration.
+func (c *UploadConfig) Validate() error {
+	if c.MaxSizeMB < 0 {
+		return errors.New("max-size-mb must not be negative")
+	}
+	return nil
+}
+
+// uploadSessions are the resumable uploads in progress, keyed by the key of the object each one creates.
+//
+// The chunks of each upload are spooled to a file in the configured directory, so that a client whose connection
+// fails can resume from the last committed chunk. The object is only created in the cache, from the spooled chunks,
+// when the upload is completed.
+//
+// Sessions are held in the memory of the instance that received their first chunk, so every request of an upload
+// must reach the same instance, and uploads in progress are lost when it restarts. Clients then start them again.
+type uploadSessions struct {
+	dir     string
+	maxSize int64
+
+	mu       sync.Mutex
+	sessions map[cache.Key]*uploadSession
+}
+
+func newUploadSessions(config UploadConfig) (*uploadSessions, error) {
+	// Configs built in code rather than decoded from HCL have no defaults applied.
+	if config.MaxSizeMB == 0 {
+		config.MaxSizeMB = 10240
+	}
+	if config.Dir != "" {
+		if err := os.MkdirAll(config.Dir, 0o750); err != nil {
+			return nil, errors.Errorf("failed to create upload directory: %w", err)
+		}
+	}
+	return &uploadSessions{
+		dir:      config.Dir,
+		maxSize:  int64(config.MaxSizeMB) << 20,
+		sessions: map[cache.Key]*uploadSession{},
+	}, nil
+}
+
+// uploadSession is the data committed to a resumable upload.
+type uploadSession struct {
+	// updated is when the session last received a chunk, in Unix nanoseconds. It is read without holding mu, so that
+	// expired sessions are found without waiting for chunks being appended.
+	updated atomic.Int64
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	closed bool
+}
+
+// append writes a chunk starting at offset to the session of key, creating the session if offset is zero, and
+// returns the size committed to the session.
+func (u *uploadSessions) append(key cache.Key, offset int64, chunk io.Reader) (int64, error) {
+	session, err := u.session(key, offset == 0)
+	if err != nil {
+		return 0, err
+	}
+	return session.append(offset, chunk, u.maxSize)
+}
+
+// stat returns the size committed to the session of key, and the hex SHA-256 digest of the committed data, with which
+// a client resuming the upload checks that it is sending the same object.
+func (u *uploadSessions) stat(key cache.Key) (int64, string, error) {
+	session, err := u.session(key, false)
+	if err != nil {
+		return 0, "", err
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.closed {
+		return 0, "", errors.WithStack(os.ErrNotExist)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(session.file, 0, session.size)); err != nil {
+		return 0, "", errors.Errorf("failed to read upload: %w", err)
+	}
+	return session.size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// take removes the session of key, so that no further chunks are appended to it, and returns its data. The caller
+// must close it.
+func (u *uploadSessions) take(key cache.Key) (*uploadSession, error) {
+	u.mu.Lock()
+	session, ok := u.sessions[key]
+	delete(u.sessions, key)
+	u.mu.Unlock()
+	if !ok {
+		return nil, errors.WithStack(os.ErrNotExist)
+	}
+	// Waits for any chunk being appended.
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.closed = true
+	if _, err := session.file.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Join(errors.Errorf("failed to rewind upload: %w", err), session.remove())
+	}
+	return session, nil
+}
+
+// abort discards the session of key.
+func (u *uploadSessions) abort(key cache.Key) error {
+	session, err := u.take(key)
+	if err != nil {
+		return err
+	}
+	return session.Close()
+}
+
+// session returns the session of key, creating it if create is true. Sessions that have been idle for longer than
+// [uploadSessionTTL] are discarded.
+func (u *uploadSessions) session(key cache.Key, create bool) (*uploadSession, error) {
+	u.sweep()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if session, ok := u.sessions[key]; ok {
+		return session, nil
+	}
+	if !create {
+		return nil, errors.WithStack(os.ErrNotExist)
+	}
+	file, err := os.CreateTemp(u.dir, "cachew-upload-*")
+	if err != nil {
+		return nil, errors.Errorf("failed to create upload file: %w", err)
+	}
+	session := &uploadSession{file: file}
+	session.updated.Store(time.Now().UnixNano())
+	u.sessions[key] = session
+	return session, nil
+}
+
+// sweep discards the sessions that have been idle for longer than [uploadSessionTTL]. Their files are removed after
+// releasing the lock on the sessions, as that waits for any chunk still being appended.
+func (u *uploadSessions) sweep() {
+	expiry := time.Now().Add(-uploadSessionTTL).UnixNano()
+	var expired []*uploadSession
+	u.mu.Lock()
+	for k, session := range u.sessions {
+		if session.updated.Load() < expiry {
+			delete(u.sessions, k)
+			expired = append(expired, session)
+		}
+	}
+	u.mu.Unlock()
+	for _, session := range expired {
+		session.mu.Lock()
+		session.closed = true
+		_ = session.remove() //nolint:errcheck // The session is abandoned.
+		session.mu.Unlock()
+	}
+}
+
+// append writes a chunk starting at offset, returning the committed size. A chunk that cannot be read completely, or
+// that would take the session beyond maxSize, is discarded, so that it can be sent again.
+func (s *uploadSession) append(offset int64, chunk io.Reader, maxSize int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, errors.WithStack(os.ErrNotExist)
+	}
+	if offset != s.size {
+		return s.size, errors.WithStack(errChunkOffset)
+	}
+	s.updated.Store(time.Now().UnixNano())
+	n, err := io.Copy(s.file, io.LimitReader(chunk, maxSize-s.size+1))
+	if err == nil && s.size+n > maxSize {
+		err = errors.WithStack(errUploadTooLarge)
+	}
+	if err != nil {
+		if terr := s.file.Truncate(s.size); terr != nil {
+			err = errors.Join(err, terr)
+		} else if _, serr := s.file.Seek(s.size, io.SeekStart); serr != nil {
+			err = errors.Join(err, serr)
+		}
+		return s.size, errors.Errorf("failed to write chunk: %w", err)
+	}
+	s.size += n
+	s.updated.Store(time.Now().UnixNano())
+	return s.size, nil
+}
+
+func (s *uploadSession) Read(p []byte) (int, error) { return s.file.Read(p) } //nolint:wrapcheck
+
+// Close discards the session's data.
+func (s *uploadSession) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.remove()
+}
+
+func (s *uploadSession) remove() error {
+	return errors.Join(s.file.Close(), os.Remove(s.file.Name()))
+}
+
+// putUploadChunk appends the request body to the resumable upload of an object, at the offset in the
+// [cache.UploadOffsetHeader] header. An upload is started by its chunk at offset zero.
+//
+// The committed size of the upload is returned in the [cache.UploadOffsetHeader] header, including with "409
+// Conflict" if the chunk does not start there, eg. because the response to a chunk that was committed was lost. A
+// chunk that would make the upload larger than the configured maximum is rejected with "413 Request Entity Too
+// Large".
+func (d *APIV1) putUploadChunk(w http.ResponseWriter, r *http.Request) {
+	key, err := cache.ParseKey(r.PathValue("key"))
+	if err != nil {
+		d.httpError(w, http.StatusBadRequest, err, "Invalid key")
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get(cache.UploadOffsetHeader), 10, 64)
+	if err == nil && offset < 0 {
+		err = errors.Errorf("offset must not be negative, got %d", offset)
+	}
+	if err != nil {
+		d.httpError(w, http.StatusBadRequest, err, "Invalid "+cache.UploadOffsetHeader+" header")
+		return
+	}
+	size, err := d.uploads.append(key, offset, r.Body)
+	switch {
+	case errors.Is(err, errChunkOffset):
+		w.Header().Set(cache.UploadOffsetHeader, strconv.FormatInt(size, 10))
+		http.Error(w, "Chunk does not start at the committed offset", http.StatusConflict)
+	case errors.Is(err, os.ErrNotExist):
+		http.Error(w, "Upload not found", http.StatusNotFound)
+	case errors.Is(err, errUploadTooLarge):
+		w.Header().Set(cache.UploadOffsetHeader, strconv.FormatInt(size, 10))
+		http.Error(w, "Upload exceeds the maximum size", http.StatusRequestEntityTooLarge)
+	case err != nil:
+		d.httpError(w, http.StatusInternalServerError, err, "Failed to write upload chunk", slog.String("key", key.String()))
+	default:
+		w.Header().Set(cache.UploadOffsetHeader, strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// statUpload returns the committed size of the resumable upload of an object in the [cache.UploadOffsetHeader]
+// header, and the digest of the committed data in the [cache.UploadDigestHeader] header.
+func (d *APIV1) statUpload(w http.ResponseWriter, r *http.Request) {
+	key, err := cache.ParseKey(r.PathValue("key"))
+	if err != nil {
+		d.httpError(w, http.StatusBadRequest, err, "Invalid key")
+		return
+	}
+	size, digest, err := d.uploads.stat(key)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		d.httpError(w, http.StatusInternalServerError, err, "Failed to stat upload", slog.String("key", key.String()))
+		return
+	}
+	w.Header().Set(cache.UploadOffsetHeader, strconv.FormatInt(size, 10))
+	w.Header().Set(cache.UploadDigestHeader, digest)
+}
+
+// completeUpload creates an object from the chunks of its resumable upload, with the same headers and preconditions
+// as an upload in a single request.
+func (d *APIV1) completeUpload(w http.ResponseWriter, r *http.Request) {
+	key, err := cache.ParseKey(r.PathValue("key"))
+	if err != nil {
+		d.httpError(w, http.StatusBadRequest, err, "Invalid key")
+		return
+	}
+	session, err := d.uploads.take(key)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		d.httpError(w, http.StatusInternalServerError, err, "Failed to complete upload", slog.String("key", key.String()))
+		return
+	}
+	defer session.Close()
+	cw, ok := d.createObject(w, r, key)
+	if !ok {
+		return
+	}
+	d.writeObject(w, cw, session)
+}
+
+// abortUpload discards the resumable upload of an object.
+func (d *APIV1) abortUpload(w http.ResponseWriter, r *http.Request) {
+	key, err := cache.ParseKey(r.PathValue("key"))
+	if err != nil {
+		d.httpError(w, http.StatusBadRequest, err, "Invalid key")
+		return
+	}
+	if err := d.uploads.abort(key); errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+	} else if err != nil {
+		d.httpError(w, http.StatusInternalServerError, err, "Failed to abort upload", slog.String("key", key.String()))
+	}
+}